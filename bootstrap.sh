@@ -37,6 +37,7 @@ go_get code.google.com/p/go-commander
 go_get code.google.com/p/go-uuid/uuid
 go_get code.google.com/p/gogoprotobuf/{proto,protoc-gen-gogo,gogoproto}
 go_get github.com/golang/glog
+go_get github.com/peterh/liner
 go_get gopkg.in/yaml.v1
 
 # Create symlinks to all git hooks in your own .git dir.
@@ -0,0 +1,194 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// DefaultBulkPutConcurrency is the maximum number of ranges written
+// in parallel by BulkPut unless otherwise specified.
+const DefaultBulkPutConcurrency = 8
+
+// A RangeLookup looks up the descriptor of the range containing a
+// key. kv.DistSender and kv.RangeDescriptorCache implement it.
+type RangeLookup interface {
+	LookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error)
+}
+
+// BulkPutOptions are parameters for use with KV.BulkPut.
+type BulkPutOptions struct {
+	// Ranges partitions the pairs by range. If nil, all pairs are
+	// written by a single worker.
+	Ranges RangeLookup
+	// Concurrency is the maximum number of ranges written in
+	// parallel. Defaults to DefaultBulkPutConcurrency if not positive.
+	Concurrency int
+	// Blind writes the pairs with blind puts, which skip reading
+	// existing values. Keys which already have a value fail with a
+	// KeyExistsError instead of being overwritten.
+	Blind bool
+}
+
+// A BulkPutError is the failure to write a single key of a bulk put.
+type BulkPutError struct {
+	Key proto.Key
+	Err error
+}
+
+// BulkPutErrors are the per-key failures of a bulk put, sorted by
+// key. Keys not listed were written successfully.
+type BulkPutErrors []BulkPutError
+
+// Error implements the error interface.
+func (e BulkPutErrors) Error() string {
+	if len(e) == 0 {
+		return "bulk put succeeded"
+	}
+	return fmt.Sprintf("failed to write %d key(s) of bulk put; first %q: %s", len(e), e[0].Key, e[0].Err)
+}
+
+// Implement sort.Interface for BulkPutErrors.
+func (e BulkPutErrors) Len() int           { return len(e) }
+func (e BulkPutErrors) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e BulkPutErrors) Less(i, j int) bool { return e[i].Key.Less(e[j].Key) }
+
+// bulkPairs implements sort.Interface to sort key/value pairs by key.
+type bulkPairs []proto.KeyValue
+
+func (p bulkPairs) Len() int           { return len(p) }
+func (p bulkPairs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p bulkPairs) Less(i, j int) bool { return p[i].Key.Less(p[j].Key) }
+
+// BulkPut writes a large set of key/value pairs. The pairs are
+// sorted by key, with the last of any duplicates taking precedence,
+// and partitioned into batches by the range boundaries reported by
+// opts.Ranges. Batches are written in parallel, with at most
+// opts.Concurrency in flight; the pairs of a batch are written in
+// key order, which lets blind puts skip reading existing values.
+//
+// BulkPut is not atomic: on failure, it returns BulkPutErrors
+// listing each key which could not be written, and all other keys
+// have been written. BulkPut may not be called on a transactional
+// client.
+func (kv *KV) BulkPut(pairs []proto.KeyValue, opts *BulkPutOptions) error {
+	if kv.exec != nil {
+		return util.Errorf("bulk put cannot be used within a transaction")
+	}
+	if opts == nil {
+		opts = &BulkPutOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBulkPutConcurrency
+	}
+
+	batches, errs := partitionBulkPairs(sortBulkPairs(pairs), opts.Ranges)
+
+	var mu sync.Mutex // Protects errs
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, batch := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(batch []proto.KeyValue) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			for _, pair := range batch {
+				args := &proto.PutRequest{
+					RequestHeader: proto.RequestHeader{Key: pair.Key},
+					Value:         pair.Value,
+					Blind:         opts.Blind,
+				}
+				if err := kv.Call(proto.Put, args, &proto.PutResponse{}); err != nil {
+					mu.Lock()
+					errs = append(errs, BulkPutError{Key: pair.Key, Err: err})
+					mu.Unlock()
+				}
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Sort(errs)
+	return errs
+}
+
+// sortBulkPairs returns a copy of pairs sorted by key. Of pairs with
+// duplicate keys, only the last is retained.
+func sortBulkPairs(pairs []proto.KeyValue) []proto.KeyValue {
+	sorted := make(bulkPairs, len(pairs))
+	copy(sorted, pairs)
+	sort.Stable(sorted)
+	deduped := sorted[:0]
+	for i, pair := range sorted {
+		if i+1 < len(sorted) && bytes.Equal(pair.Key, sorted[i+1].Key) {
+			continue
+		}
+		deduped = append(deduped, pair)
+	}
+	return deduped
+}
+
+// partitionBulkPairs splits the sorted pairs into batches of pairs
+// belonging to the same range according to lookup. Pairs whose range
+// cannot be looked up are returned as errors. If lookup is nil, all
+// pairs are returned as a single batch. Stale descriptors only make
+// for suboptimal batches, as each put is routed individually.
+func partitionBulkPairs(pairs []proto.KeyValue, lookup RangeLookup) ([][]proto.KeyValue, BulkPutErrors) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	if lookup == nil {
+		return [][]proto.KeyValue{pairs}, nil
+	}
+	var batches [][]proto.KeyValue
+	var errs BulkPutErrors
+	var desc *proto.RangeDescriptor
+	start := 0 // Index of the first pair of the current batch
+	for i, pair := range pairs {
+		if desc != nil && desc.ContainsKey(pair.Key) {
+			continue
+		}
+		if i > start {
+			batches = append(batches, pairs[start:i])
+		}
+		start = i
+		var err error
+		if desc, err = lookup.LookupRangeDescriptor(pair.Key); err != nil {
+			errs = append(errs, BulkPutError{Key: pair.Key, Err: err})
+			desc = nil
+			start = i + 1
+		}
+	}
+	if start < len(pairs) {
+		batches = append(batches, pairs[start:])
+	}
+	return batches, errs
+}
@@ -0,0 +1,158 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// testRangeLookup splits the key space into ranges at the split keys
+// and fails lookups of keys in failKeys.
+type testRangeLookup struct {
+	splits   []proto.Key
+	failKeys map[string]bool
+}
+
+func (l *testRangeLookup) LookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error) {
+	if l.failKeys[string(key)] {
+		return nil, util.Errorf("lookup of %q failed", key)
+	}
+	desc := &proto.RangeDescriptor{StartKey: proto.KeyMin, EndKey: proto.KeyMax}
+	for _, split := range l.splits {
+		if key.Less(split) {
+			desc.EndKey = split
+			break
+		}
+		desc.StartKey = split
+	}
+	return desc, nil
+}
+
+func makeBulkPairs(keysAndValues ...string) []proto.KeyValue {
+	var pairs []proto.KeyValue
+	for i := 0; i < len(keysAndValues); i += 2 {
+		pairs = append(pairs, proto.KeyValue{
+			Key:   proto.Key(keysAndValues[i]),
+			Value: proto.Value{Bytes: []byte(keysAndValues[i+1])},
+		})
+	}
+	return pairs
+}
+
+// TestBulkPutPartition verifies that pairs are sorted, deduplicated
+// and partitioned by range, and that failed lookups are reported
+// per key.
+func TestBulkPutPartition(t *testing.T) {
+	lookup := &testRangeLookup{
+		splits:   []proto.Key{proto.Key("c"), proto.Key("m"), proto.Key("x")},
+		failKeys: map[string]bool{"y": true},
+	}
+	pairs := makeBulkPairs("y", "1", "a", "1", "m", "1", "b", "1", "a", "2", "o", "1", "c", "1")
+	batches, errs := partitionBulkPairs(sortBulkPairs(pairs), lookup)
+
+	expBatches := [][]proto.KeyValue{
+		makeBulkPairs("a", "2", "b", "1"),
+		makeBulkPairs("c", "1"),
+		makeBulkPairs("m", "1", "o", "1"),
+	}
+	if !reflect.DeepEqual(batches, expBatches) {
+		t.Errorf("expected batches %v; got %v", expBatches, batches)
+	}
+	if len(errs) != 1 || !errs[0].Key.Equal(proto.Key("y")) {
+		t.Errorf("expected lookup error for key \"y\"; got %v", errs)
+	}
+
+	// Without a lookup, all pairs are written as one batch.
+	if batches, _ := partitionBulkPairs(sortBulkPairs(pairs), nil); len(batches) != 1 || len(batches[0]) != 6 {
+		t.Errorf("expected a single batch of 6 pairs; got %v", batches)
+	}
+}
+
+// TestBulkPut verifies that all pairs are written in key order within
+// each range, that blind puts are requested when specified, and that
+// failures of individual keys are returned sorted by key.
+func TestBulkPut(t *testing.T) {
+	var mu sync.Mutex
+	var written []string
+	client := NewKV(newTestSender(func(call *Call) {
+		args := call.Args.(*proto.PutRequest)
+		if !args.Blind {
+			t.Errorf("expected blind put of %q", args.Key)
+		}
+		if key := string(args.Key); key == "b" || key == "k" {
+			call.Reply.Header().SetGoError(proto.NewKeyExistsError(args.Key, proto.Timestamp{}))
+			return
+		}
+		mu.Lock()
+		written = append(written, string(args.Key))
+		mu.Unlock()
+	}), nil)
+
+	lookup := &testRangeLookup{splits: []proto.Key{proto.Key("f"), proto.Key("j")}}
+	pairs := makeBulkPairs("k", "1", "a", "1", "g", "1", "b", "1", "c", "1", "h", "1", "l", "1")
+	err := client.BulkPut(pairs, &BulkPutOptions{Ranges: lookup, Concurrency: 2, Blind: true})
+	bErr, ok := err.(BulkPutErrors)
+	if !ok || len(bErr) != 2 || !bErr[0].Key.Equal(proto.Key("b")) || !bErr[1].Key.Equal(proto.Key("k")) {
+		t.Fatalf("expected errors for keys \"b\" and \"k\"; got %v", err)
+	}
+	if _, ok := bErr[0].Err.(*proto.KeyExistsError); !ok {
+		t.Errorf("expected key exists error; got %T", bErr[0].Err)
+	}
+
+	// Puts to different ranges may interleave, but each range's keys
+	// must have been written in order.
+	var ranges [3][]string
+	for _, key := range written {
+		switch {
+		case key < "f":
+			ranges[0] = append(ranges[0], key)
+		case key < "j":
+			ranges[1] = append(ranges[1], key)
+		default:
+			ranges[2] = append(ranges[2], key)
+		}
+	}
+	for i, keys := range ranges {
+		if !sort.StringsAreSorted(keys) {
+			t.Errorf("range %d: expected keys written in order; got %v", i, keys)
+		}
+	}
+	if len(written) != 5 {
+		t.Errorf("expected 5 keys written; got %v", written)
+	}
+}
+
+// TestBulkPutInTransaction verifies that bulk puts cannot be issued
+// within a transaction.
+func TestBulkPutInTransaction(t *testing.T) {
+	client := NewKV(newTestSender(func(call *Call) {}), nil)
+	if err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+		if err := txn.BulkPut(makeBulkPairs("a", "1"), nil); err == nil {
+			t.Errorf("expected error issuing bulk put within a transaction")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
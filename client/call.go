@@ -42,14 +42,55 @@ func now(clock Clock) int64 {
 
 // resetClientCmdID sets the client command ID if the call is for a
 // read-write method. The client command ID provides idempotency
-// protection in conjunction with the server.
-func (c *Call) resetClientCmdID(clock Clock) {
+// protection in conjunction with the server. If session is not nil,
+// the command is assigned the next sequence number in the session,
+// unless it already carries a sequence number pending in the session,
+// as does a retry, which keeps it.
+func (c *Call) resetClientCmdID(clock Clock, session *Session) {
 	// On mutating commands, set a client command ID. This prevents
 	// mutations from being run multiple times on retries.
 	if proto.IsReadWrite(c.Method) {
-		c.Args.Header().CmdID = proto.ClientCmdID{
+		cmdID := &c.Args.Header().CmdID
+		var seq int64
+		if c.hasPendingCmdID(session) {
+			seq = cmdID.Seq
+		}
+		*cmdID = proto.ClientCmdID{
 			WallTime: now(clock),
 			Random:   rand.Int63(),
 		}
+		if session != nil {
+			cmdID.SessionID = session.ID
+			if seq == 0 {
+				seq, _ = session.next()
+			}
+			cmdID.Seq, cmdID.AckSeq = seq, session.acked()
+		}
+	}
+}
+
+// hasPendingCmdID returns true if the call is a read-write call which
+// carries a command ID assigned within session whose sequence number
+// hasn't completed, as does a resubmission of a call whose result
+// was ambiguous.
+func (c *Call) hasPendingCmdID(session *Session) bool {
+	if session == nil || !proto.IsReadWrite(c.Method) {
+		return false
+	}
+	cmdID := c.Args.Header().CmdID
+	return cmdID.SessionID == session.ID && cmdID.Seq != 0 && session.pending(cmdID.Seq)
+}
+
+// completeClientCmdID informs the session, if not nil, that the call
+// assigned a sequence number by resetClientCmdID has completed. Calls
+// whose result is ambiguous remain pending, so that they may be
+// retried under their original sequence number.
+func (c *Call) completeClientCmdID(session *Session) {
+	if session == nil || !proto.IsReadWrite(c.Method) {
+		return
+	}
+	if _, ok := c.Reply.Header().GoError().(*proto.AmbiguousResultError); ok {
+		return
 	}
+	session.complete(c.Args.Header().CmdID.Seq)
 }
@@ -110,6 +110,31 @@ expect retries as a matter of course. This is why the transaction
 functionality is exposed through a retryable function. The retryable
 function should have no side effects which are not idempotent.
 
+Where a transaction's scope can't be expressed as a single function,
+for example when it spans the lifetime of an HTTP request, the
+building blocks of RunTransaction may be used directly. NewTxn creates
+a transaction, whose commands are issued through the returned Txn,
+and Commit or Rollback ends it. Txn.Exec runs a retryable function
+within the transaction and commits it, restarting as RunTransaction
+does:
+
+  txn, err := kv.NewTxn(&client.TransactionOptions{Name: "request"})
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer txn.Close()
+  if err := txn.Exec(handleRequest); err != nil {
+    txn.Rollback()
+  }
+
+TransactionOptions.Isolation selects between SERIALIZABLE (the
+default) and SNAPSHOT isolation. A transaction's timestamp may be
+pushed forward by a conflicting reader or writer. SERIALIZABLE
+transactions then restart, while SNAPSHOT transactions simply commit
+at the later timestamp. SNAPSHOT isolation is appropriate for
+read-heavy transactions which can tolerate write skew and would
+otherwise suffer repeated restarts.
+
 Transactions should endeavor to write using KV.Prepare calls. This
 allows writes to the same range to be batched together. In cases where
 the entire transaction affects only a single range, transactions can
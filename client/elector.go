@@ -0,0 +1,120 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// An Elector campaigns for leadership among candidates sharing a key.
+// Leadership is held via a Mutex on the key: the candidate holding
+// the lock is the leader, and other candidates periodically attempt
+// to acquire it in case the leader fails or resigns.
+type Elector struct {
+	mutex   *Mutex
+	stopper *util.Stopper
+
+	mu     sync.Mutex
+	leader bool
+}
+
+// NewElector returns an elector which campaigns for leadership at
+// key on behalf of the candidate identified by id.
+func (kv *KV) NewElector(key proto.Key, id string) *Elector {
+	return &Elector{
+		mutex:   kv.NewMutex(key, id),
+		stopper: util.NewStopper(),
+	}
+}
+
+// Mutex returns the mutex underlying the election. Its lease duration
+// may be adjusted before Start is invoked, and its fencing token
+// identifies the current term of leadership.
+func (e *Elector) Mutex() *Mutex {
+	return e.mutex
+}
+
+// Start begins campaigning for leadership in the background.
+func (e *Elector) Start() {
+	e.stopper.RunWorker(e.campaign)
+}
+
+// Stop stops campaigning, resigning leadership if held.
+func (e *Elector) Stop() {
+	e.stopper.Stop()
+}
+
+// IsLeader returns whether this candidate currently believes itself
+// to be the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Leader returns the id of the current leader, or the empty string
+// if there is none.
+func (e *Elector) Leader() (string, error) {
+	_, l, err := e.mutex.readLease()
+	if err != nil || l == nil || l.Expiration <= now(e.mutex.kv.clock) {
+		return "", err
+	}
+	return l.Holder, nil
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = leader
+}
+
+// campaign attempts to acquire leadership until stopped. While
+// leader, it waits for leadership to be lost before campaigning
+// again.
+func (e *Elector) campaign() {
+	for {
+		ok, err := e.mutex.TryLock()
+		if err != nil {
+			log.Warningf("election at %q failed: %s", e.mutex.key, err)
+		}
+		if ok {
+			e.setLeader(true)
+			select {
+			case <-e.mutex.Done():
+				e.setLeader(false)
+				continue
+			case <-e.stopper.ShouldStop():
+				e.setLeader(false)
+				if err := e.mutex.Unlock(); err != nil {
+					log.Warningf("unable to resign leadership at %q: %s", e.mutex.key, err)
+				}
+				return
+			}
+		}
+		select {
+		case <-time.After(e.mutex.LeaseDuration / 3):
+		case <-e.stopper.ShouldStop():
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build !nogob
+
+package client
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// GobEnabled is true in builds which encode values other than
+// protocol buffer messages written by PutI with gob. Builds with the
+// nogob tag don't link gob and support protocol buffer values only.
+const GobEnabled = true
+
+// gobEncode encodes iface with gob.
+func gobEncode(iface interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(iface); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gobDecode decodes the gob-encoded bytes b into iface.
+func gobDecode(b []byte, iface interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(b)).Decode(iface)
+}
@@ -0,0 +1,39 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build nogob
+
+package client
+
+import "github.com/cockroachdb/cockroach/util"
+
+// GobEnabled is true in builds which encode values other than
+// protocol buffer messages written by PutI with gob. Builds with the
+// nogob tag don't link gob and support protocol buffer values only.
+const GobEnabled = false
+
+// gobEncode fails: values other than protocol buffer messages can't
+// be encoded without gob.
+func gobEncode(iface interface{}) ([]byte, error) {
+	return nil, util.Errorf("unable to encode %T: only protocol buffer messages are supported in nogob builds", iface)
+}
+
+// gobDecode fails: values other than protocol buffer messages can't
+// be decoded without gob.
+func gobDecode(b []byte, iface interface{}) error {
+	return util.Errorf("unable to decode %T: only protocol buffer messages are supported in nogob builds", iface)
+}
@@ -0,0 +1,50 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build nogob
+
+package client
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestValueCodecNoGob verifies that nogob builds encode protocol
+// buffer messages and reject other values.
+func TestValueCodecNoGob(t *testing.T) {
+	item := &proto.QueueItem{Data: []byte("data"), Deadline: 1, Attempts: 2}
+	data, err := encodeValue(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &proto.QueueItem{}
+	if err := decodeValue(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded.Data) != "data" || decoded.Deadline != 1 || decoded.Attempts != 2 {
+		t.Errorf("expected %+v; got %+v", item, decoded)
+	}
+	if _, err := encodeValue("value"); err == nil {
+		t.Error("expected an error encoding a string")
+	}
+	var s string
+	if err := decodeValue(data, &s); err == nil {
+		t.Error("expected an error decoding into a string")
+	}
+}
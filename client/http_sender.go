@@ -23,9 +23,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
@@ -38,6 +40,9 @@ const (
 	// TODO(spencer): change this to CONSTANT https. We shouldn't be
 	// supporting http here at all.
 	KVDBScheme = "http"
+	// KVDBSecureScheme is the scheme for connecting to the kvdb
+	// endpoint of nodes started with certificates.
+	KVDBSecureScheme = "https"
 	// StatusTooManyRequests indicates client should retry due to
 	// server having too many requests.
 	StatusTooManyRequests = 429
@@ -62,18 +67,72 @@ var HTTPRetryOptions = util.RetryOptions{
 // Key-Value database provided by a Cockroach cluster by connecting
 // via HTTP to a Cockroach node. Overly-busy nodes will redirect
 // this client to other nodes.
+//
+// An HTTPSender may be supplied with several gateway nodes. Calls
+// are sent to one gateway at a time; if a gateway fails or is
+// unavailable, the sender fails over to the next.
+//
+// If the transport has a TLS config, calls are sent over HTTPS, as
+// required by nodes started with certificates. Such nodes
+// authenticate calls by the client certificate in the TLS config or
+// by an auth token set with SetAuthToken.
 type HTTPSender struct {
-	server string       // The host:port address of the Cockroach gateway node
-	client *http.Client // The HTTP client
+	servers   []string     // The host:port addresses of the Cockroach gateway nodes
+	client    *http.Client // The HTTP client
+	scheme    string       // KVDBScheme or KVDBSecureScheme
+	authToken string       // Auth token presented with each call, if not empty
+
+	mu      sync.Mutex // Protects current
+	current int        // Index of the gateway to which calls are sent
 }
 
 // NewHTTPSender returns a new instance of HTTPSender.
 func NewHTTPSender(server string, transport *http.Transport) *HTTPSender {
+	return NewHTTPSenderWithGateways([]string{server}, transport)
+}
+
+// NewHTTPSenderWithGateways returns a new instance of HTTPSender
+// which sends calls to the first of the supplied gateway nodes and
+// fails over to the others in order.
+func NewHTTPSenderWithGateways(servers []string, transport *http.Transport) *HTTPSender {
+	if len(servers) == 0 {
+		log.Fatal("at least one gateway must be specified")
+	}
+	scheme := KVDBScheme
+	if transport != nil && transport.TLSClientConfig != nil {
+		scheme = KVDBSecureScheme
+	}
 	return &HTTPSender{
-		server: server,
+		servers: append([]string(nil), servers...),
 		client: &http.Client{
 			Transport: transport,
 		},
+		scheme: scheme,
+	}
+}
+
+// SetAuthToken sets the auth token presented with each call, as
+// minted by the token command.
+func (s *HTTPSender) SetAuthToken(token string) {
+	s.authToken = token
+}
+
+// gateway returns the gateway to which calls are currently sent.
+func (s *HTTPSender) gateway() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servers[s.current]
+}
+
+// failover advances to the next gateway if calls are still sent to
+// the failed gateway; concurrent calls which failed against the same
+// gateway fail over only once.
+func (s *HTTPSender) failover(failed string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.servers) > 1 && s.servers[s.current] == failed {
+		s.current = (s.current + 1) % len(s.servers)
+		log.Warningf("failing over from gateway %s to %s", failed, s.servers[s.current])
 	}
 }
 
@@ -84,16 +143,33 @@ func NewHTTPSender(server string, transport *http.Transport) *HTTPSender {
 // reporting failure when in fact the command may have gone through
 // and been executed successfully. We retry here to eventually get
 // through with the same client command ID and be given the cached
-// response.
+// response. If retries are exhausted for a read-write command after
+// such an error, the command's result is unknown and an
+// AmbiguousResultError is returned.
+//
+// Retries after such errors, and after retryable response codes, are
+// sent to the next gateway if several were supplied. Each retry
+// attempt tries every gateway once without backoff in between. Since
+// the client command ID is retained, a command which the failed
+// gateway had executed is deduplicated by the range's response cache.
 func (s *HTTPSender) Send(call *Call) {
 	var retryOpts util.RetryOptions = HTTPRetryOptions
 	retryOpts.Tag = fmt.Sprintf("http %s", call.Method)
+	var ambiguous bool // true if a read-write command may have been executed
 
-	if err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
-		resp, err := s.post(call)
+	// Read-write commands must carry a client command ID in order to
+	// be retried safely.
+	if proto.IsReadWrite(call.Method) && call.Args.Header().CmdID.IsEmpty() {
+		call.resetClientCmdID(nil, nil)
+	}
+
+	// post sends the call to a single gateway. Errors after which the
+	// call may be retried return RetryContinue.
+	post := func(server string) (util.RetryStatus, error) {
+		resp, err := s.post(server, call)
 		if err != nil {
 			if resp != nil {
-				log.Warningf("failed to send HTTP request with status code %d", resp.StatusCode)
+				log.Warningf("failed to send HTTP request to %s with status code %d", server, resp.StatusCode)
 				// See if we can retry based on HTTP response code.
 				switch resp.StatusCode {
 				case http.StatusServiceUnavailable, http.StatusGatewayTimeout, StatusTooManyRequests:
@@ -115,7 +191,10 @@ func (s *HTTPSender) Send(call *Call) {
 				// warning so there's visiblity that this is happening. Some of
 				// the errors we'll sweep up in this net shouldn't be retried,
 				// but we can't really know for sure which.
-				log.Warningf("failed to send HTTP request or read its response: %s", t)
+				log.Warningf("failed to send HTTP request to %s or read its response: %s", server, t)
+				if proto.IsReadWrite(call.Method) {
+					ambiguous = true
+				}
 				return util.RetryContinue, nil
 			default:
 				// Can't retry in order to recover from this error. Propagate.
@@ -124,7 +203,24 @@ func (s *HTTPSender) Send(call *Call) {
 		}
 		// On successful post, we're done with retry loop.
 		return util.RetryBreak, nil
+	}
+
+	// Each attempt tries every gateway once, failing over immediately
+	// from one to the next, so that backoff and the retry count
+	// advance only once all gateways have failed.
+	if err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
+		for i := 0; i < len(s.servers); i++ {
+			server := s.gateway()
+			if status, err := post(server); status != util.RetryContinue {
+				return status, err
+			}
+			s.failover(server)
+		}
+		return util.RetryContinue, nil
 	}); err != nil {
+		if ambiguous {
+			err = proto.NewAmbiguousResultError(err)
+		}
 		call.Reply.Header().SetGoError(err)
 	}
 }
@@ -133,26 +229,35 @@ func (s *HTTPSender) Send(call *Call) {
 func (s *HTTPSender) Close() {
 }
 
-// post posts the call using the HTTP client. The call's method is
-// appended to KVDBEndpoint and set as the URL path. The call's arguments
-// are protobuf-serialized and written as the POST body. The content
-// type is set to application/x-protobuf.
+// post posts the call to the specified server using the HTTP
+// client. The call's method is appended to KVDBEndpoint and set as
+// the URL path. The call's arguments are protobuf-serialized and
+// written as the POST body. The content type is set to
+// application/x-protobuf.
 //
 // On success, the response body is unmarshalled into call.Reply.
-func (s *HTTPSender) post(call *Call) (*http.Response, error) {
+func (s *HTTPSender) post(server string, call *Call) (*http.Response, error) {
+	// Request prefix-compressed keys for scans, which are decompressed
+	// below.
+	if args, ok := call.Args.(*proto.ScanRequest); ok {
+		args.CompressKeys = true
+	}
 	// Marshal the args into a request body.
 	body, err := gogoproto.Marshal(call.Args)
 	if err != nil {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("%s://%s%s%s", KVDBScheme, s.server, KVDBEndpoint, call.Method)
+	url := fmt.Sprintf("%s://%s%s%s", s.scheme, server, KVDBEndpoint, call.Method)
 	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, util.Errorf("unable to create request: %s", err)
 	}
 	req.Header.Add("Content-Type", "application/x-protobuf")
 	req.Header.Add("Accept", "application/x-protobuf")
+	if s.authToken != "" {
+		req.Header.Set(util.AuthorizationHeader, util.AuthTokenScheme+s.authToken)
+	}
 	resp, err := s.client.Do(req)
 	if resp == nil {
 		return nil, &httpSendError{util.Errorf("http client was closed: %s", err)}
@@ -172,5 +277,13 @@ func (s *HTTPSender) post(call *Call) (*http.Response, error) {
 		log.Errorf("request completed, but unable to unmarshal response from server: %s; body=%q", err, b)
 		return nil, &httpSendError{err}
 	}
+	if reply, ok := call.Reply.(*proto.ScanResponse); ok {
+		if err := reply.DecompressKeys(); err != nil {
+			return nil, err
+		}
+		if err := reply.VerifyChecksum(); err != nil {
+			return nil, err
+		}
+	}
 	return resp, nil
 }
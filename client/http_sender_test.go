@@ -18,10 +18,12 @@
 package client
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
@@ -95,6 +97,36 @@ func TestHTTPSenderSend(t *testing.T) {
 	}
 }
 
+// TestHTTPSenderSecure verifies that a sender with a TLS config posts
+// over HTTPS and presents its auth token.
+func TestHTTPSenderSecure(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			t.Error("expected request over TLS")
+		}
+		if auth := r.Header.Get(util.AuthorizationHeader); auth != util.AuthTokenScheme+"token" {
+			t.Errorf("expected auth token to be presented; got %q", auth)
+		}
+		body, contentType, err := util.MarshalResponse(r, testPutResp, util.AllEncodings)
+		if err != nil {
+			t.Errorf("failed to marshal response: %s", err)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	sender := NewHTTPSender(server.Listener.Addr().String(), &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	})
+	sender.SetAuthToken("token")
+	reply := &proto.PutResponse{}
+	sender.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
+	if reply.GoError() != nil {
+		t.Errorf("expected success; got %s", reply.GoError())
+	}
+}
+
 // TestHTTPSenderRetryResponseCodes verifies that send is retried
 // on some HTTP response codes but not on others.
 func TestHTTPSenderRetryResponseCodes(t *testing.T) {
@@ -212,3 +244,130 @@ func TestHTTPSenderRetryHTTPSendError(t *testing.T) {
 		server.Close()
 	}
 }
+
+// TestHTTPSenderAmbiguousResult verifies that a read-write command
+// which exhausts its retries after errors sending the HTTP request
+// returns an AmbiguousResultError, while a read-only command does not.
+func TestHTTPSenderAmbiguousResult(t *testing.T) {
+	defer func(opts util.RetryOptions) { HTTPRetryOptions = opts }(HTTPRetryOptions)
+	HTTPRetryOptions.Backoff = 1 * time.Millisecond
+	HTTPRetryOptions.MaxAttempts = 2
+
+	var s *httptest.Server
+	server, addr := startTestHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.CloseClientConnections()
+	}))
+	s = server
+	defer server.Close()
+
+	sender := createTestHTTPSender(addr)
+	putReply := &proto.PutResponse{}
+	sender.Send(&Call{Method: proto.Put, Args: &proto.PutRequest{}, Reply: putReply})
+	if _, ok := putReply.GoError().(*proto.AmbiguousResultError); !ok {
+		t.Errorf("expected ambiguous result error; got %v", putReply.GoError())
+	}
+	getReply := &proto.GetResponse{}
+	sender.Send(&Call{Method: proto.Get, Args: &proto.GetRequest{}, Reply: getReply})
+	if err := getReply.GoError(); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(*proto.AmbiguousResultError); ok {
+		t.Errorf("expected read-only command to fail unambiguously; got %s", err)
+	}
+}
+
+// TestHTTPSenderFailover verifies that a call which fails because
+// its gateway died mid-call is retried against another gateway with
+// the original client command ID, and that subsequent calls are sent
+// to the new gateway.
+func TestHTTPSenderFailover(t *testing.T) {
+	defer func(opts util.RetryOptions) { HTTPRetryOptions = opts }(HTTPRetryOptions)
+	HTTPRetryOptions.Backoff = 1 * time.Millisecond
+
+	var cmdIDs []proto.ClientCmdID
+	var gateways []string
+	handler := func(name string, s **httptest.Server, fail bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqBody, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("unexpected error reading body: %s", err)
+			}
+			args := &proto.PutRequest{}
+			if err := util.UnmarshalRequest(r, reqBody, args, util.AllEncodings); err != nil {
+				t.Errorf("unexpected error unmarshalling request: %s", err)
+			}
+			cmdIDs = append(cmdIDs, args.CmdID)
+			gateways = append(gateways, name)
+			if fail {
+				// The gateway dies after receiving the call.
+				(*s).CloseClientConnections()
+				return
+			}
+			body, contentType, err := util.MarshalResponse(r, testPutResp, util.AllEncodings)
+			if err != nil {
+				t.Errorf("failed to marshal response: %s", err)
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Write(body)
+		})
+	}
+	var s1, s2 *httptest.Server
+	s1, addr1 := startTestHTTPServer(handler("s1", &s1, true))
+	defer s1.Close()
+	s2, addr2 := startTestHTTPServer(handler("s2", &s2, false))
+	defer s2.Close()
+
+	sender := NewHTTPSenderWithGateways([]string{addr1, addr2}, &http.Transport{
+		TLSClientConfig: rpc.LoadInsecureTLSConfig().Config(),
+	})
+	for i := 0; i < 2; i++ {
+		reply := &proto.PutResponse{}
+		sender.Send(&Call{
+			Method: proto.Put,
+			Args:   &proto.PutRequest{RequestHeader: proto.RequestHeader{Key: testKey}},
+			Reply:  reply,
+		})
+		if reply.GoError() != nil {
+			t.Fatalf("%d: expected success; got %s", i, reply.GoError())
+		}
+	}
+	if expGateways := []string{"s1", "s2", "s2"}; !reflect.DeepEqual(gateways, expGateways) {
+		t.Fatalf("expected calls to gateways %v; got %v", expGateways, gateways)
+	}
+	if cmdIDs[0].IsEmpty() || !reflect.DeepEqual(cmdIDs[0], cmdIDs[1]) {
+		t.Errorf("expected retry to carry the original client command ID %+v; got %+v", cmdIDs[0], cmdIDs[1])
+	}
+	if reflect.DeepEqual(cmdIDs[2], cmdIDs[0]) {
+		t.Errorf("expected a new client command ID for a new call; got %+v", cmdIDs[2])
+	}
+}
+
+// TestHTTPSenderFailoverMaxAttempts verifies that each retry attempt
+// tries every gateway once, so that a call fails once every gateway
+// has failed on each of the maximum number of attempts.
+func TestHTTPSenderFailoverMaxAttempts(t *testing.T) {
+	defer func(opts util.RetryOptions) { HTTPRetryOptions = opts }(HTTPRetryOptions)
+	HTTPRetryOptions.Backoff = 1 * time.Millisecond
+	HTTPRetryOptions.MaxAttempts = 2
+
+	var count int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	s1, addr1 := startTestHTTPServer(handler)
+	defer s1.Close()
+	s2, addr2 := startTestHTTPServer(handler)
+	defer s2.Close()
+
+	sender := NewHTTPSenderWithGateways([]string{addr1, addr2}, &http.Transport{
+		TLSClientConfig: rpc.LoadInsecureTLSConfig().Config(),
+	})
+	reply := &proto.PutResponse{}
+	sender.Send(&Call{Method: proto.Put, Args: &proto.PutRequest{}, Reply: reply})
+	if reply.GoError() == nil {
+		t.Error("expected retries to be exhausted")
+	}
+	if count != 4 {
+		t.Errorf("expected each of 2 gateways to be tried on 2 attempts; got %d calls", count)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// An Iterator streams over the key/value pairs in a span of the key
+// space, issuing successive Scan requests of at most pageSize rows
+// as necessary. Only a single page of rows is held in memory at a
+// time. Iterators created from a transactional client scan within
+// the transaction.
+//
+// Typical usage:
+//
+//	iter := kv.NewIterator(start, end, 100)
+//	for iter.Next() {
+//	  if err := iter.ValueProto(msg); err != nil { ... }
+//	}
+//	if err := iter.Err(); err != nil { ... }
+type Iterator struct {
+	kv       *KV
+	start    proto.Key // Start key of the next page
+	end      proto.Key
+	pageSize int64
+	rows     []proto.KeyValue
+	idx      int
+	done     bool // True once the final page has been fetched
+	err      error
+}
+
+// NewIterator returns an iterator over the key/value pairs in the
+// span [start, end). pageSize specifies the maximum number of rows
+// fetched per Scan request and must be positive.
+func (kv *KV) NewIterator(start, end proto.Key, pageSize int64) *Iterator {
+	iter := &Iterator{
+		kv:       kv,
+		start:    start,
+		end:      end,
+		pageSize: pageSize,
+		idx:      -1,
+	}
+	if pageSize <= 0 {
+		iter.err = util.Errorf("iterator page size must be positive: %d", pageSize)
+	}
+	return iter
+}
+
+// Next advances the iterator to the next key/value pair, fetching
+// the next page of rows if necessary. Returns false when the span is
+// exhausted or an error occurs; use Err to distinguish the two.
+func (iter *Iterator) Next() bool {
+	if iter.err != nil {
+		return false
+	}
+	iter.idx++
+	if iter.idx < len(iter.rows) {
+		return true
+	}
+	if iter.done {
+		return false
+	}
+	reply := &proto.ScanResponse{}
+	if iter.err = iter.kv.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:    iter.start,
+			EndKey: iter.end,
+		},
+		MaxResults: iter.pageSize,
+	}, reply); iter.err != nil {
+		return false
+	}
+	iter.rows, iter.idx = reply.Rows, 0
+	if int64(len(iter.rows)) < iter.pageSize {
+		iter.done = true
+	}
+	if len(iter.rows) == 0 {
+		return false
+	}
+	// Resume the next scan just after the last key returned.
+	iter.start = iter.rows[len(iter.rows)-1].Key.Next()
+	return true
+}
+
+// Err returns the error, if any, encountered during iteration.
+func (iter *Iterator) Err() error {
+	return iter.err
+}
+
+// Key returns the key at the current position of the iterator.
+func (iter *Iterator) Key() proto.Key {
+	return iter.rows[iter.idx].Key
+}
+
+// Value returns the value at the current position of the iterator.
+func (iter *Iterator) Value() *proto.Value {
+	return &iter.rows[iter.idx].Value
+}
+
+// ValueProto unmarshals the value at the current position of the
+// iterator into msg. Returns an error if the value fails checksum
+// verification or is not a byte value.
+func (iter *Iterator) ValueProto(msg gogoproto.Message) error {
+	kv := &iter.rows[iter.idx]
+	if err := kv.Value.Verify(kv.Key); err != nil {
+		return err
+	}
+	if kv.Value.Integer != nil {
+		return util.Errorf("unexpected integer value at key %q: %+v", kv.Key, kv.Value)
+	}
+	return gogoproto.Unmarshal(kv.Value.Bytes, msg)
+}
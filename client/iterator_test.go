@@ -0,0 +1,162 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// newScanTestSender returns a test sender which services Scan
+// requests from the supplied sorted rows, invoking onScan for each.
+func newScanTestSender(rows []proto.KeyValue, onScan func(*proto.ScanRequest)) *testSender {
+	return newTestSender(func(call *Call) {
+		if call.Method != proto.Scan {
+			return
+		}
+		args := call.Args.(*proto.ScanRequest)
+		reply := call.Reply.(*proto.ScanResponse)
+		onScan(args)
+		for _, kv := range rows {
+			if int64(len(reply.Rows)) == args.MaxResults {
+				break
+			}
+			if !kv.Key.Less(args.Key) && kv.Key.Less(args.EndKey) {
+				reply.Rows = append(reply.Rows, kv)
+			}
+		}
+	})
+}
+
+// makeIteratorRows returns count rows with keys "key-00", "key-01",
+// etc., each containing a proto-encoded timestamp with WallTime set
+// to the row's index.
+func makeIteratorRows(t *testing.T, count int) []proto.KeyValue {
+	var rows []proto.KeyValue
+	for i := 0; i < count; i++ {
+		key := proto.Key(fmt.Sprintf("key-%02d", i))
+		data, err := gogoproto.Marshal(&proto.Timestamp{WallTime: int64(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := proto.Value{Bytes: data}
+		value.InitChecksum(key)
+		rows = append(rows, proto.KeyValue{Key: key, Value: value})
+	}
+	return rows
+}
+
+// TestIteratorPaging verifies that the iterator visits every row in
+// the span, in order, issuing one scan per page.
+func TestIteratorPaging(t *testing.T) {
+	rows := makeIteratorRows(t, 10)
+	testCases := []struct {
+		start, end string
+		pageSize   int64
+		expRows    int
+		expScans   int
+	}{
+		{"a", "z", 1, 10, 11},
+		{"a", "z", 3, 10, 4},
+		{"a", "z", 5, 10, 3},
+		{"a", "z", 100, 10, 1},
+		{"key-03", "key-07", 2, 4, 3},
+		{"x", "z", 5, 0, 1},
+	}
+	for i, test := range testCases {
+		scans := 0
+		kv := NewKV(newScanTestSender(rows, func(args *proto.ScanRequest) {
+			scans++
+			if args.MaxResults != test.pageSize {
+				t.Errorf("%d: expected max results %d; got %d", i, test.pageSize, args.MaxResults)
+			}
+		}), nil)
+		iter := kv.NewIterator(proto.Key(test.start), proto.Key(test.end), test.pageSize)
+		count := 0
+		var last proto.Key
+		for iter.Next() {
+			if last != nil && !last.Less(iter.Key()) {
+				t.Errorf("%d: keys out of order: %q >= %q", i, last, iter.Key())
+			}
+			last = iter.Key()
+			ts := &proto.Timestamp{}
+			if err := iter.ValueProto(ts); err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			if expKey := fmt.Sprintf("key-%02d", ts.WallTime); string(iter.Key()) != expKey {
+				t.Errorf("%d: expected key %q; got %q", i, expKey, iter.Key())
+			}
+			count++
+		}
+		if err := iter.Err(); err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+		if count != test.expRows {
+			t.Errorf("%d: expected %d rows; got %d", i, test.expRows, count)
+		}
+		if scans != test.expScans {
+			t.Errorf("%d: expected %d scans; got %d", i, test.expScans, scans)
+		}
+	}
+}
+
+// TestIteratorError verifies that scan errors and invalid page sizes
+// terminate iteration and are reported via Err.
+func TestIteratorError(t *testing.T) {
+	kv := NewKV(newTestSender(func(call *Call) {
+		call.Reply.Header().SetGoError(&proto.RangeNotFoundError{})
+	}), nil)
+	iter := kv.NewIterator(proto.Key("a"), proto.Key("z"), 10)
+	if iter.Next() {
+		t.Error("expected iteration to stop on error")
+	}
+	if _, ok := iter.Err().(*proto.RangeNotFoundError); !ok {
+		t.Errorf("expected range not found error; got %v", iter.Err())
+	}
+
+	if iter = kv.NewIterator(proto.Key("a"), proto.Key("z"), 0); iter.Next() || iter.Err() == nil {
+		t.Error("expected error for zero page size")
+	}
+}
+
+// TestIteratorInTransaction verifies that an iterator created from a
+// transactional client issues its scans within the transaction.
+func TestIteratorInTransaction(t *testing.T) {
+	rows := makeIteratorRows(t, 5)
+	kv := NewKV(newScanTestSender(rows, func(args *proto.ScanRequest) {
+		if args.Txn == nil || string(args.Txn.ID) != string(txnID) {
+			t.Errorf("expected scan within txn; got %+v", args.Txn)
+		}
+	}), nil)
+	if err := kv.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+		iter := txn.NewIterator(proto.Key("a"), proto.Key("z"), 2)
+		count := 0
+		for iter.Next() {
+			count++
+		}
+		if count != len(rows) {
+			t.Errorf("expected %d rows; got %d", len(rows), count)
+		}
+		return iter.Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
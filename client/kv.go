@@ -18,14 +18,15 @@
 package client
 
 import (
-	"bytes"
-	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"time"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 )
 
 // TxnRetryOptions sets the retry options for handling write conflicts.
@@ -38,7 +39,12 @@ var TxnRetryOptions = util.RetryOptions{
 
 // TransactionOptions are parameters for use with KV.RunTransaction.
 type TransactionOptions struct {
-	Name      string // Concise desc of txn for debugging
+	Name string // Concise desc of txn for debugging
+	// Isolation selects the transaction's isolation level. The default,
+	// proto.SERIALIZABLE, restarts the transaction whenever its commit
+	// timestamp is pushed past its read timestamp. proto.SNAPSHOT
+	// instead commits at the pushed timestamp, which avoids restarts
+	// for read-heavy workloads but permits write skew.
 	Isolation proto.IsolationType
 }
 
@@ -68,9 +74,98 @@ type KV struct {
 	// UserPriority is set non-zero in call arguments, this value is
 	// ignored.
 	UserPriority int32
+	// Tag is the default accounting tag to set on API calls. If Tag is
+	// set non-empty in call arguments, this value is ignored.
+	Tag string
+	// Limits are the request size limits verified before sending API
+	// calls. Requests which exceed them fail immediately with a
+	// RequestTooLargeError.
+	Limits proto.RequestLimits
+	// ScanChecksums, if true, requests a checksum of the rows returned
+	// by each scan, which is verified on receipt. Scans whose rows
+	// don't match the checksum fail.
+	ScanChecksums bool
 
-	sender KVSender
-	clock  Clock
+	sender  KVSender
+	clock   Clock
+	session *Session
+	metrics *kvMetrics
+	exec    *TxnExec // Non-nil only for transactional clients
+}
+
+// TxnExec describes the progress of a transaction run via
+// RunTransaction. It is available to the retryable function through
+// KV.TxnExec so that applications can adapt their behavior after
+// repeated restarts, for example by coarsening their locking or
+// bumping the transaction's priority.
+type TxnExec struct {
+	// Restarts is the number of times the retryable function has been
+	// restarted.
+	Restarts int
+	// Reasons contains the error which caused each restart, in order.
+	Reasons []error
+}
+
+// LastReason returns the error which caused the most recent restart,
+// or nil if the transaction has not been restarted.
+func (e *TxnExec) LastReason() error {
+	if len(e.Reasons) == 0 {
+		return nil
+	}
+	return e.Reasons[len(e.Reasons)-1]
+}
+
+// kvMetrics holds the metrics tracked by a KV client. Metrics are
+// shared between a KV client and the transactional clients created
+// from it via RunTransaction.
+type kvMetrics struct {
+	registry    *metric.Registry
+	calls       *metric.Counter   // API calls
+	callErrors  *metric.Counter   // API calls which returned an error
+	callLatency *metric.Histogram // API call latency in nanoseconds
+	txns        *metric.Counter   // Transactions run
+	txnRestarts *metric.Counter   // Transaction retries
+	txnAborts   *metric.Counter   // Transactions aborted
+
+	// Transaction retries, broken down by reason.
+	txnRestartsUncertainty *metric.Counter // ReadWithinUncertaintyIntervalError
+	txnRestartsAborted     *metric.Counter // TransactionAbortedError
+	txnRestartsPush        *metric.Counter // TransactionPushError
+	txnRestartsRetry       *metric.Counter // TransactionRetryError
+}
+
+func newKVMetrics() *kvMetrics {
+	r := metric.NewRegistry()
+	return &kvMetrics{
+		registry:    r,
+		calls:       r.Counter("calls"),
+		callErrors:  r.Counter("calls.errors"),
+		callLatency: r.Histogram("calls.latency"),
+		txns:        r.Counter("txns"),
+		txnRestarts: r.Counter("txns.restarts"),
+		txnAborts:   r.Counter("txns.aborts"),
+
+		txnRestartsUncertainty: r.Counter("txns.restarts.uncertainty"),
+		txnRestartsAborted:     r.Counter("txns.restarts.aborted"),
+		txnRestartsPush:        r.Counter("txns.restarts.push"),
+		txnRestartsRetry:       r.Counter("txns.restarts.retry"),
+	}
+}
+
+// recordRestart increments the restart counters for a transaction
+// restarted due to err.
+func (m *kvMetrics) recordRestart(err error) {
+	m.txnRestarts.Inc(1)
+	switch err.(type) {
+	case *proto.ReadWithinUncertaintyIntervalError:
+		m.txnRestartsUncertainty.Inc(1)
+	case *proto.TransactionAbortedError:
+		m.txnRestartsAborted.Inc(1)
+	case *proto.TransactionPushError:
+		m.txnRestartsPush.Inc(1)
+	case *proto.TransactionRetryError:
+		m.txnRestartsRetry.Inc(1)
+	}
 }
 
 // NewKV creates a new instance of KV using the specified sender. By
@@ -79,14 +174,59 @@ type KV struct {
 // KV struct should be manually initialized in order to utilize a
 // txnSender. Clock is used to formulate client command IDs, which
 // provide idempotency on API calls. If clock is nil, uses
-// time.UnixNanos as default implementation.
+// time.UnixNanos as default implementation. Commands issued by the
+// client are protected against replay within a new Session.
 func NewKV(sender KVSender, clock Clock) *KV {
+	return NewKVWithSession(sender, clock, NewSession())
+}
+
+// NewKVWithSession creates a new instance of KV as NewKV, but issues
+// commands within the supplied session. Use this to retain replay
+// protection for commands issued by a previous client which used the
+// same session, for example after reconnecting to the cluster through
+// a different node.
+func NewKVWithSession(sender KVSender, clock Clock, session *Session) *KV {
 	return &KV{
-		sender: newSingleCallSender(sender, clock),
-		clock:  clock,
+		Limits:  proto.DefaultRequestLimits,
+		sender:  newSingleCallSender(sender, clock, session),
+		clock:   clock,
+		session: session,
+		metrics: newKVMetrics(),
 	}
 }
 
+// Session returns the session within which the client issues
+// commands.
+func (kv *KV) Session() *Session {
+	return kv.session
+}
+
+// Registry returns the registry of client metrics.
+func (kv *KV) Registry() *metric.Registry {
+	return kv.metrics.registry
+}
+
+// TxnExec returns the execution state of the transaction within
+// which the client issues commands, or nil if the client is not
+// transactional. Within a retryable function supplied to
+// RunTransaction, this reports the number of restarts so far and the
+// reason for each.
+func (kv *KV) TxnExec() *TxnExec {
+	return kv.exec
+}
+
+// Transaction returns a copy of the transaction within which the
+// client issues commands, including its ID, epoch, priority,
+// timestamps and status, for use in logging and debugging. Returns
+// nil if the client is not transactional or if the transaction has
+// not yet begun, which it does with the first command issued.
+func (kv *KV) Transaction() *proto.Transaction {
+	if ts, ok := kv.sender.(*txnSender); ok {
+		return ts.transaction()
+	}
+	return nil
+}
+
 // Sender returns the sender supplied to NewKV.
 func (kv *KV) Sender() KVSender {
 	switch t := kv.sender.(type) {
@@ -109,13 +249,36 @@ func (kv *KV) Call(method string, args proto.Request, reply proto.Response) erro
 	if args.Header().UserPriority == nil && kv.UserPriority != 0 {
 		args.Header().UserPriority = gogoproto.Int32(kv.UserPriority)
 	}
+	if args.Header().Tag == "" {
+		args.Header().Tag = kv.Tag
+	}
+	if err := kv.verifyLimits(args); err != nil {
+		reply.Header().SetGoError(err)
+		return err
+	}
+	scanArgs, isScan := args.(*proto.ScanRequest)
+	if isScan && kv.ScanChecksums {
+		scanArgs.Checksum = true
+	}
 	call := &Call{
 		Method: method,
 		Args:   args,
 		Reply:  reply,
 	}
+	start := time.Now()
 	kv.sender.Send(call)
-	return call.Reply.Header().GoError()
+	err := call.Reply.Header().GoError()
+	if err == nil && isScan && scanArgs.Checksum {
+		if err = verifyScanChecksum(call.Reply.(*proto.ScanResponse)); err != nil {
+			call.Reply.Header().SetGoError(err)
+		}
+	}
+	kv.metrics.calls.Inc(1)
+	kv.metrics.callLatency.RecordValue(time.Since(start).Nanoseconds())
+	if err != nil {
+		kv.metrics.callErrors.Inc(1)
+	}
+	return err
 }
 
 // TODO(spencer): implement Prepare.
@@ -129,72 +292,157 @@ func (kv *KV) Call(method string, args proto.Request, reply proto.Response) erro
 // than once. The opts struct contains transaction settings.
 //
 // Calling RunTransaction on the transactional KV client which is
-// supplied to the retryable function is an error.
+// supplied to the retryable function is an error. The client's
+// TxnExec method reports restarts of the transaction to retryable.
+//
+// RunTransaction is equivalent to creating a Txn via NewTxn, invoking
+// Txn.Exec and, if it fails, Txn.Rollback.
 func (kv *KV) RunTransaction(opts *TransactionOptions, retryable func(txn *KV) error) error {
-	if _, ok := kv.sender.(*txnSender); ok {
-		return util.Errorf("cannot invoke RunTransaction on an already-transactional client")
+	txn, err := kv.NewTxn(opts)
+	if err != nil {
+		return err
 	}
+	defer txn.Close()
+	if err := txn.Exec(retryable); err != nil && !txn.sender.txnEnd {
+		if rbErr := txn.Rollback(); rbErr != nil {
+			log.Errorf("txn %q: failure aborting transaction: %s; abort caused by: %s", txn.ID(), rbErr, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// A Txn is a distributed transaction whose scope is controlled by the
+// caller, for use where the closure-based RunTransaction doesn't fit,
+// such as a transaction spanning the lifetime of an HTTP request.
+// Commands are issued through the embedded transactional KV client.
+// The transaction must be ended with Commit or Rollback, or by
+// Exec; Close should be invoked once it's no longer in use.
+type Txn struct {
+	*KV
+	opts   *TransactionOptions
+	sender *txnSender
+}
 
+// NewTxn creates a transaction with the supplied options. Commands
+// issued through the returned Txn are executed within the
+// transaction, which begins with the first command. Calling NewTxn on
+// a transactional KV client is an error.
+func (kv *KV) NewTxn(opts *TransactionOptions) (*Txn, error) {
+	if _, ok := kv.sender.(*txnSender); ok {
+		return nil, util.Errorf("cannot create a transaction from an already-transactional client")
+	}
 	// Create a new KV for the transaction using a transactional KV sender.
-	txnSender := newTxnSender(kv.Sender(), kv.clock, opts)
-	txnKV := &KV{
-		User:         kv.User,
-		UserPriority: kv.UserPriority,
-		sender:       txnSender,
+	sender := newTxnSender(kv.Sender(), kv.clock, kv.session, opts)
+	kv.metrics.txns.Inc(1)
+	return &Txn{
+		KV: &KV{
+			User:          kv.User,
+			UserPriority:  kv.UserPriority,
+			Tag:           kv.Tag,
+			Limits:        kv.Limits,
+			ScanChecksums: kv.ScanChecksums,
+			sender:        sender,
+			session:       kv.session,
+			metrics:       kv.metrics,
+			exec:          &TxnExec{},
+		},
+		opts:   opts,
+		sender: sender,
+	}, nil
+}
+
+// ID returns the ID of the transaction, or nil if it has not yet
+// begun.
+func (txn *Txn) ID() []byte {
+	if t := txn.Transaction(); t != nil {
+		return t.ID
 	}
-	defer txnKV.Close()
+	return nil
+}
 
+// Exec executes retryable within the transaction and commits it
+// unless retryable ended it explicitly. retryable is run again, with
+// backoff where appropriate, whenever the transaction must be
+// restarted. Any other error is returned to the caller without ending
+// the transaction, which should then be rolled back via Rollback.
+func (txn *Txn) Exec(retryable func(txn *KV) error) error {
 	// Run retryable in a retry loop until we encounter a success or
 	// error condition this loop isn't capable of handling.
 	retryOpts := TxnRetryOptions
-	retryOpts.Tag = opts.Name
-	if err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
-		txnSender.txnEnd = false // always reset before [re]starting txn
-		err := retryable(txnKV)
-		if err == nil && !txnSender.txnEnd {
-			// If there were no errors running retryable, commit the txn. This
-			// may block waiting for outstanding writes to complete in case
-			// retryable didn't -- we need the most recent of all response
-			// timestamps in order to commit.
-			etArgs := &proto.EndTransactionRequest{Commit: true}
-			etReply := &proto.EndTransactionResponse{}
-			txnKV.Call(proto.EndTransaction, etArgs, etReply)
-			err = etReply.Header().GoError()
+	retryOpts.Tag = txn.opts.Name
+	var restartErr error // cause of the pending restart, if any
+	return util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
+		if restartErr != nil {
+			txn.exec.Restarts++
+			txn.exec.Reasons = append(txn.exec.Reasons, restartErr)
+			txn.metrics.recordRestart(restartErr)
+			restartErr = nil
+		}
+		txn.sender.txnEnd = false // always reset before [re]starting txn
+		err := retryable(txn.KV)
+		if err == nil {
+			err = txn.Commit()
 		}
 		switch t := err.(type) {
 		case *proto.ReadWithinUncertaintyIntervalError:
 			// Retry immediately on read within uncertainty interval.
+			restartErr = t
 			return util.RetryReset, nil
 		case *proto.TransactionAbortedError:
 			// If the transaction was aborted, the txnSender will have created
 			// a new txn. We allow backoff/retry in this case.
+			restartErr = t
 			return util.RetryContinue, nil
 		case *proto.TransactionPushError:
 			// Backoff and retry on failure to push a conflicting transaction.
+			restartErr = t
 			return util.RetryContinue, nil
 		case *proto.TransactionRetryError:
 			// Return RetryReset for an immediate retry (as in the case of
 			// an SSI txn whose timestamp was pushed).
+			restartErr = t
 			return util.RetryReset, nil
 		default:
 			// For all other cases, finish retry loop, returning possible error.
 			return util.RetryBreak, t
 		}
-	}); err != nil && !txnSender.txnEnd {
-		etArgs := &proto.EndTransactionRequest{Commit: false}
-		etReply := &proto.EndTransactionResponse{}
-		txnKV.Call(proto.EndTransaction, etArgs, etReply)
-		if etReply.Header().GoError() != nil {
-			log.Errorf("failure aborting transaction: %s; abort caused by: %s", etReply.Header().GoError(), err)
-		}
-		return err
+	})
+}
+
+// Commit commits the transaction unless it has already been ended.
+// This may block waiting for outstanding writes to complete, as the
+// most recent of all response timestamps is needed in order to
+// commit. If the returned error requires the transaction to be
+// restarted, the caller must reissue its commands before committing
+// again; Exec does so automatically.
+func (txn *Txn) Commit() error {
+	if txn.sender.txnEnd {
+		return nil
 	}
-	return nil
+	etArgs := &proto.EndTransactionRequest{Commit: true}
+	etReply := &proto.EndTransactionResponse{}
+	txn.Call(proto.EndTransaction, etArgs, etReply)
+	return etReply.Header().GoError()
+}
+
+// Rollback aborts the transaction unless it has already been ended.
+func (txn *Txn) Rollback() error {
+	if txn.sender.txnEnd {
+		return nil
+	}
+	txn.metrics.txnAborts.Inc(1)
+	etArgs := &proto.EndTransactionRequest{Commit: false}
+	etReply := &proto.EndTransactionResponse{}
+	txn.Call(proto.EndTransaction, etArgs, etReply)
+	return etReply.Header().GoError()
 }
 
-// GetI fetches the value at the specified key and gob-deserializes it
-// into "value". Returns true on success or false if the key was not
-// found. The timestamp of the write is returned as the second return
+// GetI fetches the value at the specified key and deserializes it
+// into "value" as written by PutI: protocol buffer messages are
+// unmarshalled and other values gob-deserialized, which builds with
+// the nogob tag don't support (see GobEnabled).
+// Returns true on success or false if the key was not found. The timestamp of the write is returned as the second return
 // value. The first result parameter is "ok": true if a value was
 // found for the requested key; false otherwise. An error is returned
 // on error fetching from underlying storage or deserializing value.
@@ -206,7 +454,7 @@ func (kv *KV) GetI(key proto.Key, iface interface{}) (bool, proto.Timestamp, err
 	if value.Integer != nil {
 		return false, proto.Timestamp{}, util.Errorf("unexpected integer value at key %q: %+v", key, value)
 	}
-	if err := gob.NewDecoder(bytes.NewBuffer(value.Bytes)).Decode(iface); err != nil {
+	if err := decodeValue(value.Bytes, iface); err != nil {
 		return true, *value.Timestamp, err
 	}
 	return true, *value.Timestamp, nil
@@ -229,11 +477,44 @@ func (kv *KV) GetProto(key proto.Key, msg gogoproto.Message) (bool, proto.Timest
 	return true, *value.Timestamp, nil
 }
 
+// GetJSON fetches the JSON document at the specified key and decodes
+// it into v. See comments for GetI for details on return values.
+func (kv *KV) GetJSON(key proto.Key, v interface{}) (bool, proto.Timestamp, error) {
+	return kv.GetJSONPath(key, "", v)
+}
+
+// GetJSONPath fetches only the element at path of the JSON document
+// at the specified key and decodes it into v. The element is
+// extracted by the range holding the key, so the remainder of the
+// document isn't transferred. See proto.ExtractJSONPath for the path
+// syntax; a missing element decodes as JSON null. See comments for
+// GetI for details on return values.
+func (kv *KV) GetJSONPath(key proto.Key, path string, v interface{}) (bool, proto.Timestamp, error) {
+	value, err := kv.getWithJSONPath(key, path)
+	if err != nil || value == nil {
+		return false, proto.Timestamp{}, err
+	}
+	if value.Integer != nil {
+		return false, proto.Timestamp{}, util.Errorf("unexpected integer value at key %q: %+v", key, value)
+	}
+	if err := json.Unmarshal(value.Bytes, v); err != nil {
+		return true, *value.Timestamp, err
+	}
+	return true, *value.Timestamp, nil
+}
+
 // getInternal fetches the requested key and returns the value.
 func (kv *KV) getInternal(key proto.Key) (*proto.Value, error) {
+	return kv.getWithJSONPath(key, "")
+}
+
+// getWithJSONPath fetches the requested key and returns the value,
+// reduced to the element at the JSON path if the path is set.
+func (kv *KV) getWithJSONPath(key proto.Key, path string) (*proto.Value, error) {
 	reply := &proto.GetResponse{}
 	if err := kv.Call(proto.Get, &proto.GetRequest{
 		RequestHeader: proto.RequestHeader{Key: key},
+		JSONPath:      path,
 	}, reply); err != nil {
 		return nil, err
 	}
@@ -243,13 +524,149 @@ func (kv *KV) getInternal(key proto.Key) (*proto.Value, error) {
 	return nil, nil
 }
 
-// PutI sets the given key to the gob-serialized byte string of value.
+// GetAt fetches the value of the specified key as of the supplied
+// historical timestamp, returning nil if the key had no value at
+// that time. Deleted and overwritten values remain readable until
+// they fall behind the GC threshold of their range, which trails the
+// current time by the zone's GC TTL; reads as of an earlier time
+// fail with a *proto.ReadBeforeGCThresholdError. GetAt should not be
+// used within a transaction, which reads at its own timestamp.
+func (kv *KV) GetAt(key proto.Key, timestamp proto.Timestamp) (*proto.Value, error) {
+	reply := &proto.GetResponse{}
+	if err := kv.Call(proto.Get, &proto.GetRequest{
+		RequestHeader: proto.RequestHeader{Key: key, Timestamp: timestamp},
+	}, reply); err != nil {
+		return nil, err
+	}
+	if reply.Value != nil {
+		return reply.Value, reply.Value.Verify(key)
+	}
+	return nil, nil
+}
+
+// ScanAt scans up to maxResults keys in the range [key, endKey) as
+// of the supplied historical timestamp. See GetAt for the limits on
+// historical reads.
+func (kv *KV) ScanAt(key, endKey proto.Key, maxResults int64, timestamp proto.Timestamp) ([]proto.KeyValue, error) {
+	reply := &proto.ScanResponse{}
+	if err := kv.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{Key: key, EndKey: endKey, Timestamp: timestamp},
+		MaxResults:    maxResults,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return reply.Rows, nil
+}
+
+// A ReadBound trades the consistency of a non-transactional read for
+// latency: EXACT reads are served by the range leader, while bounded
+// staleness and inconsistent reads may be served by the nearest or
+// fastest replica. See proto.ReadBound for the guarantees of each.
+type ReadBound struct {
+	Bound proto.ReadBound
+	// MaxStaleness is the maximum age of a bounded staleness read.
+	MaxStaleness time.Duration
+}
+
+// ExactRead reads the latest committed values from the range leader.
+var ExactRead = ReadBound{Bound: proto.EXACT}
+
+// InconsistentRead reads from any replica's current state, skipping
+// the intents of pending transactions.
+var InconsistentRead = ReadBound{Bound: proto.INCONSISTENT}
+
+// BoundedStalenessRead reads from any replica whose state is no older
+// than maxStaleness.
+func BoundedStalenessRead(maxStaleness time.Duration) ReadBound {
+	return ReadBound{Bound: proto.BOUNDED_STALENESS, MaxStaleness: maxStaleness}
+}
+
+// header returns a request header for the key range with the read
+// bound set.
+func (b ReadBound) header(key, endKey proto.Key) proto.RequestHeader {
+	return proto.RequestHeader{
+		Key:          key,
+		EndKey:       endKey,
+		ReadBound:    b.Bound,
+		MaxStaleness: b.MaxStaleness.Nanoseconds(),
+	}
+}
+
+// GetWithBound fetches the value of the specified key with the
+// consistency specified by bound, returning nil if the key has no
+// value. Within a transaction, the bound is ignored and the read is
+// exact.
+func (kv *KV) GetWithBound(key proto.Key, bound ReadBound) (*proto.Value, error) {
+	reply := &proto.GetResponse{}
+	if err := kv.Call(proto.Get, &proto.GetRequest{
+		RequestHeader: bound.header(key, nil),
+	}, reply); err != nil {
+		return nil, err
+	}
+	if reply.Value != nil {
+		return reply.Value, reply.Value.Verify(key)
+	}
+	return nil, nil
+}
+
+// ScanWithBound scans up to maxResults keys in the range [key, endKey)
+// with the consistency specified by bound. See GetWithBound.
+func (kv *KV) ScanWithBound(key, endKey proto.Key, maxResults int64, bound ReadBound) ([]proto.KeyValue, error) {
+	reply := &proto.ScanResponse{}
+	if err := kv.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: bound.header(key, endKey),
+		MaxResults:    maxResults,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return reply.Rows, nil
+}
+
+// Query selects the rows of the named structured table which satisfy
+// all predicates, returning the values of the listed columns (all
+// columns if none are listed) of at most limit rows if limit is
+// positive. Queries are served by the structured data layer of the
+// gateway node and can't be run within a transaction.
+func (kv *KV) Query(table string, predicates []proto.Predicate, columns []string, limit int64) (*proto.QueryResponse, error) {
+	reply := &proto.QueryResponse{}
+	if err := kv.Call(proto.Query, &proto.QueryRequest{
+		Table:      table,
+		Predicates: predicates,
+		Columns:    columns,
+		Limit:      limit,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// PutI sets the given key to the protobuf serialization of value if
+// it's a protocol buffer message, or to its gob-serialized byte
+// string otherwise. Values are thus encoded alike by all builds,
+// though builds with the nogob tag support protocol buffer values
+// only (see GobEnabled).
 func (kv *KV) PutI(key proto.Key, iface interface{}) error {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(iface); err != nil {
+	data, err := encodeValue(iface)
+	if err != nil {
 		return err
 	}
-	return kv.putInternal(key, proto.Value{Bytes: buf.Bytes()})
+	return kv.putInternal(key, proto.Value{Bytes: data})
+}
+
+// encodeValue encodes iface for PutI.
+func encodeValue(iface interface{}) ([]byte, error) {
+	if msg, ok := iface.(gogoproto.Message); ok {
+		return gogoproto.Marshal(msg)
+	}
+	return gobEncode(iface)
+}
+
+// decodeValue decodes b, as encoded by encodeValue, into iface.
+func decodeValue(b []byte, iface interface{}) error {
+	if msg, ok := iface.(gogoproto.Message); ok {
+		return gogoproto.Unmarshal(b, msg)
+	}
+	return gobDecode(b, iface)
 }
 
 // PutProto sets the given key to the protobuf-serialized byte string
@@ -262,6 +679,15 @@ func (kv *KV) PutProto(key proto.Key, msg gogoproto.Message) error {
 	return kv.putInternal(key, proto.Value{Bytes: data})
 }
 
+// PutJSON sets the given key to the JSON encoding of v.
+func (kv *KV) PutJSON(key proto.Key, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return kv.putInternal(key, proto.Value{Bytes: data})
+}
+
 // putInternal writes the specified value to key.
 func (kv *KV) putInternal(key proto.Key, value proto.Value) error {
 	value.InitChecksum(key)
@@ -271,7 +697,136 @@ func (kv *KV) putInternal(key proto.Key, value proto.Value) error {
 	}, &proto.PutResponse{})
 }
 
+// ConditionalDelete deletes the given key only if its existing value
+// matches expValue, or, if expValue is nil, only if the key exists.
+// On a mismatch, the key's actual value is returned along with the
+// error.
+func (kv *KV) ConditionalDelete(key proto.Key, expValue *proto.Value) (*proto.Value, error) {
+	reply := &proto.ConditionalDeleteResponse{}
+	err := kv.Call(proto.ConditionalDelete, &proto.ConditionalDeleteRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		ExpValue:      expValue,
+	}, reply)
+	return reply.ActualValue, err
+}
+
+// errConditionFailed aborts the transaction carrying out a
+// compare-and-swap whose conditions don't hold.
+var errConditionFailed = errors.New("compare-and-swap condition failed")
+
+// CompareAndSwap checks the conditions and, only if all hold, applies
+// the writes atomically, returning whether they were applied. A
+// condition with a nil expected value requires its key not to exist,
+// and a write with a nil value deletes its key. If all keys lie within
+// a single range, the request is executed by the range in a single
+// round trip, without the overhead of a transaction. Otherwise, or if
+// the cluster doesn't yet support CompareAndSwap, the conditions are
+// checked and the writes applied within a transaction. Invoked on a
+// transactional client, they're carried out as part of its
+// transaction.
+func (kv *KV) CompareAndSwap(conditions []proto.CompareAndSwapCondition, writes []proto.CompareAndSwapWrite) (bool, error) {
+	if _, ok := kv.sender.(*txnSender); ok {
+		return compareAndSwapTxn(kv, conditions, writes)
+	}
+	if len(conditions) == 0 && len(writes) == 0 {
+		return true, nil
+	}
+	args := &proto.CompareAndSwapRequest{
+		Conditions: conditions,
+		Writes:     make([]proto.CompareAndSwapWrite, len(writes)),
+	}
+	for i, w := range writes {
+		args.Writes[i].Key = w.Key
+		if w.Value != nil {
+			value := *w.Value
+			value.InitChecksum(w.Key)
+			args.Writes[i].Value = &value
+		}
+	}
+	args.Key, args.EndKey = args.Span()
+	reply := &proto.CompareAndSwapResponse{}
+	err := kv.Call(proto.CompareAndSwap, args, reply)
+	switch err.(type) {
+	case nil:
+		return true, nil
+	case *proto.OpRequiresTxnError, *proto.UnsupportedVersionError:
+		// Fall back to a transaction.
+	default:
+		if len(reply.FailedKey) > 0 {
+			return false, nil
+		}
+		return false, err
+	}
+	err = kv.RunTransaction(&TransactionOptions{Name: "compare-and-swap"}, func(txn *KV) error {
+		swapped, err := compareAndSwapTxn(txn, conditions, writes)
+		if err == nil && !swapped {
+			return errConditionFailed
+		}
+		return err
+	})
+	if err == errConditionFailed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// compareAndSwapTxn carries out a compare-and-swap within the
+// transaction of the supplied client.
+func compareAndSwapTxn(txn *KV, conditions []proto.CompareAndSwapCondition, writes []proto.CompareAndSwapWrite) (bool, error) {
+	for i := range conditions {
+		value, err := txn.getInternal(conditions[i].Key)
+		if err != nil {
+			return false, err
+		}
+		if !conditions[i].Holds(value) {
+			return false, nil
+		}
+	}
+	for _, w := range writes {
+		var err error
+		if w.Value == nil {
+			err = txn.Call(proto.Delete, &proto.DeleteRequest{
+				RequestHeader: proto.RequestHeader{Key: w.Key},
+			}, &proto.DeleteResponse{})
+		} else {
+			err = txn.putInternal(w.Key, *w.Value)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 // Close closes the KV client and its sender.
 func (kv *KV) Close() {
 	kv.sender.Close()
 }
+
+// verifyScanChecksum verifies the checksum of the rows of a scan
+// which requested one. A missing checksum is an error.
+func verifyScanChecksum(reply *proto.ScanResponse) error {
+	if reply.Checksum == nil {
+		return util.Errorf("scan response of %d rows is missing its checksum", len(reply.Rows))
+	}
+	return reply.VerifyChecksum()
+}
+
+// verifyLimits verifies that args does not exceed the client's request
+// limits. Keys in the system keyspace, which may exceed the maximum
+// key size by the length of their prefix, are left to the store to
+// verify.
+func (kv *KV) verifyLimits(args proto.Request) error {
+	header := args.Header()
+	for _, k := range []struct {
+		field string
+		key   proto.Key
+	}{{"key", header.Key}, {"end_key", header.EndKey}} {
+		if len(k.key) > 0 && k.key[0] != 0 {
+			if err := kv.Limits.VerifyKey(k.field, k.key); err != nil {
+				return err
+			}
+		}
+	}
+	return kv.Limits.VerifyRequest(args)
+}
@@ -18,12 +18,16 @@
 package client
 
 import (
+	"bytes"
 	"errors"
 	"reflect"
 	"testing"
 	"time"
 
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
 )
 
 // TestKVTransactionSender verifies the proper unwrapping and
@@ -132,6 +136,122 @@ func TestKVAbortTransaction(t *testing.T) {
 	}
 }
 
+// TestKVManualTransaction verifies that a transaction created via
+// NewTxn is ended exactly once, by whichever of Commit and Rollback
+// is invoked first.
+func TestKVManualTransaction(t *testing.T) {
+	for _, commit := range []bool{true, false} {
+		var ends []bool
+		client := NewKV(newTestSender(func(call *Call) {
+			if call.Method == proto.EndTransaction {
+				ends = append(ends, call.Args.(*proto.EndTransactionRequest).Commit)
+			}
+		}), nil)
+		txn, err := client.NewTxn(&TransactionOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := txn.Call(proto.Put, proto.PutArgs(proto.Key("a"), []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			err = txn.Commit()
+		} else {
+			err = txn.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Ending the transaction a second time is a noop.
+		if err := txn.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if err := txn.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		txn.Close()
+		if !reflect.DeepEqual(ends, []bool{commit}) {
+			t.Errorf("expected single EndTransaction with commit=%t; got %v", commit, ends)
+		}
+	}
+}
+
+// TestKVTxnExec verifies that Txn.Exec commits the transaction, and
+// that a failed Exec leaves the transaction to be rolled back by the
+// caller.
+func TestKVTxnExec(t *testing.T) {
+	var ends []bool
+	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method == proto.EndTransaction {
+			ends = append(ends, call.Args.(*proto.EndTransactionRequest).Commit)
+		}
+	}), nil)
+
+	txn, err := client.NewTxn(&TransactionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Exec(func(txn *KV) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ends, []bool{true}) {
+		t.Errorf("expected commit; got %v", ends)
+	}
+
+	ends = nil
+	if txn, err = client.NewTxn(&TransactionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Exec(func(txn *KV) error { return errors.New("foo") }); err == nil {
+		t.Fatal("expected error from Exec")
+	}
+	if len(ends) != 0 {
+		t.Fatalf("expected transaction to remain open; got %v", ends)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ends, []bool{false}) {
+		t.Errorf("expected abort; got %v", ends)
+	}
+}
+
+// TestKVTransaction verifies that the transactional client exposes
+// its transaction once begun, reflecting the status with which it
+// was ended.
+func TestKVTransaction(t *testing.T) {
+	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method == proto.EndTransaction {
+			txn := *call.Args.Header().Txn
+			txn.Status = proto.COMMITTED
+			call.Reply.(*proto.EndTransactionResponse).Txn = &txn
+		}
+	}), nil)
+	if client.Transaction() != nil {
+		t.Error("expected no transaction for non-transactional client")
+	}
+	txn, err := client.NewTxn(&TransactionOptions{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Close()
+	if txn.Transaction() != nil || txn.ID() != nil {
+		t.Error("expected no transaction before the first command")
+	}
+	if err := txn.Call(proto.Put, proto.PutArgs(proto.Key("a"), []byte("value")), &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if pt := txn.Transaction(); pt == nil || !reflect.DeepEqual(pt.ID, txnID) || pt.Status != proto.PENDING {
+		t.Errorf("expected pending transaction %q; got %+v", txnID, pt)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if pt := txn.Transaction(); pt == nil || pt.Status != proto.COMMITTED {
+		t.Errorf("expected committed transaction; got %+v", pt)
+	}
+}
+
 // TestKVRunTransactionRetryOnErrors verifies that the transaction
 // is retried on the correct errors.
 func TestKVRunTransactionRetryOnErrors(t *testing.T) {
@@ -182,3 +302,312 @@ func TestKVRunTransactionRetryOnErrors(t *testing.T) {
 		}
 	}
 }
+
+// TestKVRunTransactionTxnExec verifies that restarts and their
+// causes are reported to the retryable function via TxnExec and
+// recorded in the client's metrics.
+func TestKVRunTransactionTxnExec(t *testing.T) {
+	TxnRetryOptions.Backoff = 1 * time.Millisecond
+
+	errs := []error{&proto.TransactionRetryError{}, &proto.TransactionPushError{}}
+	count := 0
+	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method == proto.Put {
+			if count < len(errs) {
+				call.Reply.Header().SetGoError(errs[count])
+			}
+			count++
+		}
+	}), nil)
+	if client.TxnExec() != nil {
+		t.Error("expected nil TxnExec for non-transactional client")
+	}
+	var exec *TxnExec
+	attempt := 0
+	if err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+		exec = txn.TxnExec()
+		if exec.Restarts != attempt {
+			t.Errorf("expected %d restarts; got %d", attempt, exec.Restarts)
+		}
+		if attempt > 0 && reflect.TypeOf(exec.LastReason()) != reflect.TypeOf(errs[attempt-1]) {
+			t.Errorf("expected last reason of type %T; got %T", errs[attempt-1], exec.LastReason())
+		}
+		attempt++
+		return txn.Call(proto.Put, testPutReq, &proto.PutResponse{})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exec.Restarts != 2 || len(exec.Reasons) != 2 {
+		t.Errorf("expected 2 restarts; got %d with reasons %v", exec.Restarts, exec.Reasons)
+	}
+
+	snapshot := client.Registry().Snapshot()
+	for name, expected := range map[string]float64{
+		"txns.restarts":             2,
+		"txns.restarts.retry":       1,
+		"txns.restarts.push":        1,
+		"txns.restarts.uncertainty": 0,
+		"txns.restarts.aborted":     0,
+	} {
+		if snapshot[name] != expected {
+			t.Errorf("expected %s=%f; got %f", name, expected, snapshot[name])
+		}
+	}
+}
+
+// TestKVClientCommandIDUsesClock verifies that client command IDs
+// for read-write calls take their wall time from the supplied
+// clock.
+func TestKVClientCommandIDUsesClock(t *testing.T) {
+	manual := hlc.NewManualClock(10)
+	var wallTimes []int64
+	client := NewKV(newTestSender(func(call *Call) {
+		wallTimes = append(wallTimes, call.Args.Header().CmdID.WallTime)
+	}), manual)
+	for i := 0; i < 2; i++ {
+		args := &proto.PutRequest{RequestHeader: proto.RequestHeader{Key: testKey}}
+		if err := client.Call(proto.Put, args, &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+		manual.Increment(5)
+	}
+	if expected := []int64{10, 15}; !reflect.DeepEqual(wallTimes, expected) {
+		t.Errorf("expected command ID wall times %v; got %v", expected, wallTimes)
+	}
+}
+
+// TestKVClientRequestLimits verifies that requests exceeding the
+// client's limits fail without being sent, and that system keys are
+// left for the store to verify.
+func TestKVClientRequestLimits(t *testing.T) {
+	var sent int
+	client := NewKV(newTestSender(func(call *Call) {
+		sent++
+	}), nil)
+	client.Limits = proto.RequestLimits{MaxKeySize: 4, MaxValueSize: 4}
+
+	testCases := []struct {
+		args  *proto.PutRequest
+		field string // empty if no error expected
+	}{
+		{&proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("abcde")}}, "key"},
+		{&proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a")},
+			Value: proto.Value{Bytes: []byte("value")}}, "value"},
+		{&proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("\x00\x00meta2abcde")}}, ""},
+	}
+	for i, test := range testCases {
+		err := client.Call(proto.Put, test.args, &proto.PutResponse{})
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if tErr, ok := err.(*proto.RequestTooLargeError); !ok || tErr.Field != test.field {
+			t.Errorf("%d: expected request too large error for %q; got %v", i, test.field, err)
+		}
+	}
+	if sent != 1 {
+		t.Errorf("expected only the valid request to be sent; sent %d", sent)
+	}
+}
+
+// TestKVReadBound verifies that reads are sent with the requested
+// read bound and staleness.
+func TestKVReadBound(t *testing.T) {
+	var header proto.RequestHeader
+	client := NewKV(newTestSender(func(call *Call) {
+		header = *call.Args.Header()
+	}), nil)
+
+	testCases := []struct {
+		bound        ReadBound
+		maxStaleness int64
+	}{
+		{ExactRead, 0},
+		{InconsistentRead, 0},
+		{BoundedStalenessRead(time.Second), time.Second.Nanoseconds()},
+	}
+	for i, test := range testCases {
+		if _, err := client.GetWithBound(testKey, test.bound); err != nil {
+			t.Fatal(err)
+		}
+		if header.ReadBound != test.bound.Bound || header.MaxStaleness != test.maxStaleness {
+			t.Errorf("%d: expected %s with staleness %d; got %s with %d",
+				i, test.bound.Bound, test.maxStaleness, header.ReadBound, header.MaxStaleness)
+		}
+		if _, err := client.ScanWithBound(testKey, testKey.Next(), 0, test.bound); err != nil {
+			t.Fatal(err)
+		}
+		if header.ReadBound != test.bound.Bound || !header.EndKey.Equal(testKey.Next()) {
+			t.Errorf("%d: expected scan with %s; got %+v", i, test.bound.Bound, header)
+		}
+	}
+}
+
+// TestKVCompareAndSwap verifies that compare-and-swaps are sent as a
+// single request and fall back to a transaction if their keys span
+// ranges.
+func TestKVCompareAndSwap(t *testing.T) {
+	var methods []string
+	var spansRanges bool
+	client := NewKV(newTestSender(func(call *Call) {
+		methods = append(methods, call.Method)
+		switch call.Method {
+		case proto.CompareAndSwap:
+			args, reply := call.Args.(*proto.CompareAndSwapRequest), call.Reply.(*proto.CompareAndSwapResponse)
+			if spansRanges {
+				reply.SetGoError(proto.NewOpRequiresTxnError(call.Method))
+			} else if args.Conditions[0].ExpValue != nil {
+				reply.FailedKey = args.Conditions[0].Key
+				reply.SetGoError(util.Errorf("key %q does not exist", reply.FailedKey))
+			}
+		}
+	}), nil)
+
+	conditions := []proto.CompareAndSwapCondition{{Key: proto.Key("a")}}
+	writes := []proto.CompareAndSwapWrite{
+		{Key: proto.Key("a"), Value: &proto.Value{Bytes: []byte("value")}},
+		{Key: proto.Key("c")},
+	}
+	if swapped, err := client.CompareAndSwap(conditions, writes); !swapped || err != nil {
+		t.Fatalf("expected swap; got %t, %v", swapped, err)
+	}
+	if !reflect.DeepEqual(methods, []string{proto.CompareAndSwap}) {
+		t.Errorf("expected a single compare-and-swap; got %v", methods)
+	}
+	if writes[0].Value.Checksum != nil {
+		t.Error("expected the caller's values not to be modified")
+	}
+
+	// A failed condition isn't an error.
+	methods = nil
+	failing := []proto.CompareAndSwapCondition{{Key: proto.Key("a"), ExpValue: &proto.Value{}}}
+	if swapped, err := client.CompareAndSwap(failing, writes); swapped || err != nil {
+		t.Errorf("expected no swap; got %t, %v", swapped, err)
+	}
+
+	// Keys spanning ranges are checked and written in a transaction.
+	methods = nil
+	spansRanges = true
+	if swapped, err := client.CompareAndSwap(conditions, writes); !swapped || err != nil {
+		t.Fatalf("expected swap; got %t, %v", swapped, err)
+	}
+	expMethods := []string{proto.CompareAndSwap, proto.Get, proto.Put, proto.Delete, proto.EndTransaction}
+	if !reflect.DeepEqual(methods, expMethods) {
+		t.Errorf("expected %v; got %v", expMethods, methods)
+	}
+}
+
+// TestKVGetAt verifies that historical reads are sent with the
+// requested timestamp and that errors for reads before the GC
+// threshold are returned to the caller.
+func TestKVGetAt(t *testing.T) {
+	threshold := proto.Timestamp{WallTime: 10}
+	client := NewKV(newTestSender(func(call *Call) {
+		if ts := call.Args.Header().Timestamp; ts.Less(threshold) {
+			call.Reply.Header().SetGoError(proto.NewReadBeforeGCThresholdError(ts, threshold))
+			return
+		}
+		if gr, ok := call.Reply.(*proto.GetResponse); ok {
+			gr.Value = &proto.Value{Bytes: []byte("value")}
+			gr.Value.InitChecksum(call.Args.Header().Key)
+		}
+	}), nil)
+
+	if v, err := client.GetAt(testKey, proto.Timestamp{WallTime: 15}); err != nil || string(v.Bytes) != "value" {
+		t.Errorf("expected \"value\"; got %v, %v", v, err)
+	}
+	if _, err := client.GetAt(testKey, proto.Timestamp{WallTime: 5}); err == nil {
+		t.Error("expected error reading before the GC threshold")
+	} else if _, ok := err.(*proto.ReadBeforeGCThresholdError); !ok {
+		t.Errorf("expected ReadBeforeGCThresholdError; got %T: %s", err, err)
+	}
+	if _, err := client.ScanAt(testKey, testKey.Next(), 0, proto.Timestamp{WallTime: 5}); err == nil {
+		t.Error("expected error scanning before the GC threshold")
+	}
+}
+
+// TestKVScanChecksums verifies that clients configured with
+// ScanChecksums request a checksum of scanned rows and fail scans
+// whose rows don't match it or which lack one.
+func TestKVScanChecksums(t *testing.T) {
+	var corrupt, omit bool
+	client := NewKV(newTestSender(func(call *Call) {
+		args, ok := call.Args.(*proto.ScanRequest)
+		if !ok {
+			return
+		}
+		reply := call.Reply.(*proto.ScanResponse)
+		reply.Rows = []proto.KeyValue{{Key: proto.Key("a"), Value: proto.Value{Bytes: []byte("value")}}}
+		if args.Checksum && !omit {
+			reply.SetChecksum()
+		}
+		if corrupt {
+			reply.Rows[0].Value.Bytes[0] = 'x'
+		}
+	}), nil)
+
+	// Without ScanChecksums, no checksum is requested or verified.
+	corrupt = true
+	if _, err := client.ScanAt(testKey, testKey.Next(), 0, proto.Timestamp{}); err != nil {
+		t.Errorf("expected unverified scan to succeed; got %s", err)
+	}
+
+	client.ScanChecksums = true
+	testCases := []struct {
+		corrupt, omit bool
+		expErr        bool
+	}{
+		{false, false, false},
+		{true, false, true},
+		{false, true, true},
+	}
+	for i, test := range testCases {
+		corrupt, omit = test.corrupt, test.omit
+		if _, err := client.ScanAt(testKey, testKey.Next(), 0, proto.Timestamp{}); (err != nil) != test.expErr {
+			t.Errorf("%d: expected error=%t; got %v", i, test.expErr, err)
+		}
+	}
+}
+
+// TestValueCodec verifies that protocol buffer messages are encoded
+// as protobufs whether or not gob is linked, so that values written
+// by builds with and without the nogob tag are read alike by either,
+// and that other values are gob-encoded only where gob is linked.
+func TestValueCodec(t *testing.T) {
+	item := &proto.QueueItem{Data: []byte("data"), Deadline: 1, Attempts: 2}
+	expected, err := gogoproto.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeValue(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("expected protobuf encoding %q; got %q", expected, data)
+	}
+	decoded := &proto.QueueItem{}
+	if err := decodeValue(expected, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(item, decoded) {
+		t.Errorf("expected %+v; got %+v", item, decoded)
+	}
+
+	data, err = encodeValue("value")
+	if !GobEnabled {
+		if err == nil {
+			t.Error("expected an error encoding a string without gob")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := decodeValue(data, &s); err != nil || s != "value" {
+		t.Errorf("expected \"value\"; got %q, %v", s, err)
+	}
+}
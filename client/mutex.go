@@ -0,0 +1,258 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// DefaultLeaseDuration is the default duration of the lease held by
+// a Mutex. The lease is renewed every third of this duration.
+const DefaultLeaseDuration = 10 * time.Second
+
+// LockRetryOptions sets the retry options for Mutex.Lock.
+var LockRetryOptions = util.RetryOptions{
+	Backoff:     50 * time.Millisecond,
+	MaxBackoff:  1 * time.Second,
+	Constant:    2,
+	MaxAttempts: 0, // retry indefinitely
+}
+
+// A Mutex is a distributed lock implemented with a lease record
+// stored at a key. The lock is acquired by conditionally putting a
+// lease naming the holder if the existing lease has expired or been
+// released, and is held for as long as the lease is renewed, which
+// happens automatically in the background.
+//
+// Each acquisition is assigned a fencing token which is strictly
+// greater than that of any previous acquisition. Since lease expiry
+// is judged using client clocks, a holder may lose the lock without
+// noticing immediately; resources protected by the lock should
+// reject operations carrying a token lower than the highest token
+// they have seen.
+type Mutex struct {
+	// LeaseDuration is the duration of the lease. Must be set before
+	// the lock is first acquired.
+	LeaseDuration time.Duration
+
+	kv     *KV
+	key    proto.Key
+	holder string
+
+	mu      sync.Mutex
+	held    bool
+	token   int64
+	current []byte        // Encoded lease last written by this mutex
+	stopper *util.Stopper // Stops the lease renewal worker
+	done    chan struct{} // Closed when the lock is no longer held
+}
+
+// NewMutex returns a mutex which stores its lease at key. holder
+// identifies the lock holder to others (see Elector.Leader); if
+// empty, a unique identifier is generated.
+func (kv *KV) NewMutex(key proto.Key, holder string) *Mutex {
+	if holder == "" {
+		holder = uuid.New()
+	}
+	done := make(chan struct{})
+	close(done)
+	return &Mutex{
+		LeaseDuration: DefaultLeaseDuration,
+		kv:            kv,
+		key:           key,
+		holder:        holder,
+		done:          done,
+	}
+}
+
+// readLease reads the lease record, returning the encoded and decoded
+// lease, or nils if none exists.
+func (m *Mutex) readLease() ([]byte, *proto.MutexLease, error) {
+	reply := &proto.GetResponse{}
+	if err := m.kv.Call(proto.Get, proto.GetArgs(m.key), reply); err != nil {
+		return nil, nil, err
+	}
+	if reply.Value == nil {
+		return nil, nil, nil
+	}
+	l := &proto.MutexLease{}
+	if err := decodeValue(reply.Value.Bytes, l); err != nil {
+		return nil, nil, err
+	}
+	return reply.Value.Bytes, l, nil
+}
+
+// writeLease writes the lease record if the existing record matches
+// expected, which is nil if no record should exist. Returns the
+// encoded lease and whether it was written.
+func (m *Mutex) writeLease(l *proto.MutexLease, expected []byte) ([]byte, bool, error) {
+	data, err := encodeValue(l)
+	if err != nil {
+		return nil, false, err
+	}
+	args := &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: m.key},
+		Value:         proto.Value{Bytes: data},
+	}
+	args.Value.InitChecksum(m.key)
+	if expected != nil {
+		args.ExpValue = &proto.Value{Bytes: expected}
+	}
+	reply := &proto.ConditionalPutResponse{}
+	if err := m.kv.Call(proto.ConditionalPut, args, reply); err != nil {
+		if reply.ActualValue != nil {
+			// The lease was written concurrently by another holder.
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// TryLock attempts to acquire the lock without blocking. Returns
+// whether the lock was acquired.
+func (m *Mutex) TryLock() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.held {
+		return false, util.Errorf("lock %q already held", m.key)
+	}
+	now := now(m.kv.clock)
+	cur, l, err := m.readLease()
+	if err != nil {
+		return false, err
+	}
+	next := &proto.MutexLease{Holder: m.holder, Token: 1, Expiration: now + m.LeaseDuration.Nanoseconds()}
+	if l != nil {
+		if l.Expiration > now && l.Holder != m.holder {
+			return false, nil
+		}
+		next.Token = l.Token + 1
+	}
+	data, ok, err := m.writeLease(next, cur)
+	if !ok || err != nil {
+		return false, err
+	}
+	m.held, m.token, m.current = true, next.Token, data
+	m.done = make(chan struct{})
+	m.stopper = util.NewStopper()
+	stopper, done := m.stopper, m.done
+	stopper.RunWorker(func() { m.renew(stopper, done) })
+	return true, nil
+}
+
+// Lock acquires the lock, retrying with backoff until it is
+// available.
+func (m *Mutex) Lock() error {
+	retryOpts := LockRetryOptions
+	retryOpts.Tag = "lock " + string(m.key)
+	return util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
+		ok, err := m.TryLock()
+		if err != nil {
+			return util.RetryBreak, err
+		}
+		if !ok {
+			return util.RetryContinue, nil
+		}
+		return util.RetryBreak, nil
+	})
+}
+
+// Unlock releases the lock. The lease record is retained with its
+// expiration cleared so that fencing tokens continue to increase.
+func (m *Mutex) Unlock() error {
+	m.mu.Lock()
+	if !m.held {
+		m.mu.Unlock()
+		return util.Errorf("lock %q not held", m.key)
+	}
+	m.held = false
+	close(m.done)
+	stopper, current := m.stopper, m.current
+	released := &proto.MutexLease{Holder: m.holder, Token: m.token}
+	m.mu.Unlock()
+
+	stopper.Stop()
+	_, _, err := m.writeLease(released, current)
+	return err
+}
+
+// Token returns the fencing token of the current acquisition.
+func (m *Mutex) Token() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token
+}
+
+// Done returns a channel which is closed when the lock is no longer
+// held, either because it was released or because its lease could
+// not be renewed.
+func (m *Mutex) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.done
+}
+
+// renew periodically extends the lease until the stopper is stopped
+// or the lease is found to have been taken by another holder.
+func (m *Mutex) renew(stopper *util.Stopper, done chan struct{}) {
+	ticker := time.NewTicker(m.LeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !m.renewLease(done) {
+				return
+			}
+		case <-stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// renewLease extends the lease. Returns false if the lock is no
+// longer held.
+func (m *Mutex) renewLease(done chan struct{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.held || m.done != done {
+		return false
+	}
+	next := &proto.MutexLease{Holder: m.holder, Token: m.token, Expiration: now(m.kv.clock) + m.LeaseDuration.Nanoseconds()}
+	data, ok, err := m.writeLease(next, m.current)
+	if err != nil {
+		// Retry on the next tick; the lease is only lost if another
+		// holder overwrites it.
+		log.Warningf("unable to renew lease on lock %q: %s", m.key, err)
+		return true
+	}
+	if !ok {
+		log.Infof("lost lease on lock %q", m.key)
+		m.held = false
+		close(m.done)
+		return false
+	}
+	m.current = data
+	return true
+}
@@ -0,0 +1,194 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// DefaultVisibilityTimeout is the default duration for which a
+// dequeued message is hidden from other consumers while awaiting
+// acknowledgement.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// queueScanPageSize is the number of messages scanned per request
+// when searching a shard for a visible message.
+const queueScanPageSize = 100
+
+var (
+	queueCounterSuffix = proto.Key("ctr")
+	queueMessageSuffix = proto.Key("msg")
+)
+
+// A Queue is a durable FIFO work queue stored under a key prefix.
+// Messages are spread across a fixed number of shards to reduce
+// contention between producers and consumers; ordering is FIFO within
+// each shard. Each shard keeps a counter, incremented to assign
+// sequence numbers to enqueued messages, and the messages themselves,
+// keyed by sequence number:
+//
+//	<prefix><shard>ctr        -> int64 sequence counter
+//	<prefix><shard>msg<seq>   -> proto.QueueItem, encoded as by PutI
+//
+// A dequeued message is hidden from other consumers for the
+// visibility timeout. If it is not acknowledged within that time, it
+// becomes visible again and is redelivered.
+type Queue struct {
+	// VisibilityTimeout is the duration for which a dequeued message
+	// is hidden from other consumers.
+	VisibilityTimeout time.Duration
+
+	kv          *KV
+	prefix      proto.Key
+	shards      uint32
+	nextEnqueue uint32 // Round-robin shard for next enqueue
+	nextDequeue uint32 // Round-robin shard at which to begin next dequeue
+}
+
+// A QueueMessage is a message returned by Queue.Dequeue.
+type QueueMessage struct {
+	Key      proto.Key // Uniquely identifies the message within the queue
+	Data     []byte
+	Attempts int // Number of times the message has been dequeued
+
+	deadline int64 // Visibility deadline set by the dequeue
+}
+
+// NewQueue returns a queue stored under the specified key prefix
+// using the specified number of shards. All users of a queue must
+// agree on the number of shards.
+func (kv *KV) NewQueue(prefix proto.Key, shards int) *Queue {
+	if shards < 1 {
+		shards = 1
+	}
+	return &Queue{
+		VisibilityTimeout: DefaultVisibilityTimeout,
+		kv:                kv,
+		prefix:            prefix,
+		shards:            uint32(shards),
+	}
+}
+
+// shardKey returns the key prefix for the specified shard.
+func (q *Queue) shardKey(shard uint32) proto.Key {
+	return proto.MakeKey(q.prefix, encoding.EncodeUint32(nil, shard))
+}
+
+// messagePrefix returns the key prefix for messages in the shard.
+func (q *Queue) messagePrefix(shard uint32) proto.Key {
+	return proto.MakeKey(q.shardKey(shard), queueMessageSuffix)
+}
+
+// Enqueue durably appends a message containing data to the queue.
+func (q *Queue) Enqueue(data []byte) error {
+	shard := atomic.AddUint32(&q.nextEnqueue, 1) % q.shards
+	incReply := &proto.IncrementResponse{}
+	if err := q.kv.Call(proto.Increment, &proto.IncrementRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.MakeKey(q.shardKey(shard), queueCounterSuffix)},
+		Increment:     1,
+	}, incReply); err != nil {
+		return err
+	}
+	key := proto.MakeKey(q.messagePrefix(shard), encoding.EncodeUint64(nil, uint64(incReply.NewValue)))
+	return q.kv.PutI(key, &proto.QueueItem{Data: data})
+}
+
+// Dequeue returns the oldest visible message from the next non-empty
+// shard, hiding it from other consumers for the visibility timeout.
+// The message must be acknowledged via Ack once processed. Returns
+// nil if no message is visible.
+func (q *Queue) Dequeue() (*QueueMessage, error) {
+	start := atomic.AddUint32(&q.nextDequeue, 1)
+	for i := uint32(0); i < q.shards; i++ {
+		msg, err := q.dequeueShard((start + i) % q.shards)
+		if msg != nil || err != nil {
+			return msg, err
+		}
+	}
+	return nil, nil
+}
+
+// dequeueShard claims the oldest visible message in the shard within
+// a transaction.
+func (q *Queue) dequeueShard(shard uint32) (*QueueMessage, error) {
+	var msg *QueueMessage
+	err := q.kv.RunTransaction(&TransactionOptions{Name: "dequeue"}, func(txn *KV) error {
+		msg = nil
+		now := now(q.kv.clock)
+		prefix := q.messagePrefix(shard)
+		iter := txn.NewIterator(prefix, prefix.PrefixEnd(), queueScanPageSize)
+		for iter.Next() {
+			item := &proto.QueueItem{}
+			if err := decodeValue(iter.Value().Bytes, item); err != nil {
+				return err
+			}
+			if item.Deadline > now {
+				continue
+			}
+			item.Deadline = now + q.VisibilityTimeout.Nanoseconds()
+			item.Attempts++
+			data, err := encodeValue(item)
+			if err != nil {
+				return err
+			}
+			key := iter.Key()
+			value := proto.Value{Bytes: data}
+			value.InitChecksum(key)
+			if err := txn.Call(proto.ConditionalPut, &proto.ConditionalPutRequest{
+				RequestHeader: proto.RequestHeader{Key: key},
+				Value:         value,
+				ExpValue:      &proto.Value{Bytes: iter.Value().Bytes},
+			}, &proto.ConditionalPutResponse{}); err != nil {
+				return err
+			}
+			msg = &QueueMessage{
+				Key:      key,
+				Data:     item.Data,
+				Attempts: int(item.Attempts),
+				deadline: item.Deadline,
+			}
+			return nil
+		}
+		return iter.Err()
+	})
+	return msg, err
+}
+
+// Ack acknowledges successful processing of a dequeued message,
+// removing it from the queue. Returns an error if the message's
+// visibility timeout expired and it has since been dequeued again.
+func (q *Queue) Ack(msg *QueueMessage) error {
+	return q.kv.RunTransaction(&TransactionOptions{Name: "ack"}, func(txn *KV) error {
+		item := &proto.QueueItem{}
+		ok, _, err := txn.GetI(msg.Key, item)
+		if err != nil {
+			return err
+		}
+		if !ok || item.Deadline != msg.deadline {
+			return util.Errorf("message %q is no longer held by this consumer", msg.Key)
+		}
+		return txn.Call(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: msg.Key},
+		}, &proto.DeleteResponse{})
+	})
+}
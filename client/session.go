@@ -0,0 +1,106 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// A Session provides replay protection for the read-write commands
+// issued by a client which spans reconnects to the cluster. Every
+// command issued within the session is assigned an increasing
+// sequence number. Each command also carries the sequence number
+// through which all of the session's commands have completed. Ranges
+// persist this value alongside their response caches and reject
+// replays of completed commands whose responses have since been
+// garbage collected, instead of executing them a second time.
+//
+// To continue a session after reconnecting through a different
+// gateway node, supply the original client's Session to
+// NewKVWithSession when creating the new client. A command whose
+// result is ambiguous (see proto.AmbiguousResultError) remains
+// pending in the session: resubmitting the same request arguments
+// retries it under its original command ID and sequence number, so
+// that it executes at most once even if the first attempt succeeded.
+// The session's acknowledged sequence number doesn't advance past a
+// pending command. Ranges garbage collect the records of sessions
+// which have been inactive for a day (see storage.DefaultSessionTTL),
+// after which their commands must not be retried.
+//
+// A Session is safe for concurrent access.
+type Session struct {
+	ID int64 // Randomly chosen, non-zero session ID
+
+	sync.Mutex
+	seq         int64              // Last assigned sequence number
+	ackSeq      int64              // All commands <= ackSeq have completed
+	outstanding map[int64]struct{} // Sequence numbers of pending commands
+}
+
+// NewSession returns a new session with a random ID.
+func NewSession() *Session {
+	s := &Session{outstanding: map[int64]struct{}{}}
+	for s.ID == 0 {
+		s.ID = rand.Int63()
+	}
+	return s
+}
+
+// next assigns the next sequence number in the session to a pending
+// command. Returns the sequence number and the sequence number
+// through which all commands in the session have completed.
+func (s *Session) next() (seq, ackSeq int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.seq++
+	s.outstanding[s.seq] = struct{}{}
+	return s.seq, s.ackSeq
+}
+
+// pending returns true if the command with the specified sequence
+// number was assigned it by this session and hasn't completed.
+func (s *Session) pending(seq int64) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.outstanding[seq]
+	return ok
+}
+
+// acked returns the sequence number through which all commands in the
+// session have completed.
+func (s *Session) acked() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.ackSeq
+}
+
+// complete marks the command with the specified sequence number as
+// completed and advances the acknowledged sequence number past all
+// completed commands which are not preceded by a pending command.
+func (s *Session) complete(seq int64) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.outstanding, seq)
+	for s.ackSeq < s.seq {
+		if _, ok := s.outstanding[s.ackSeq+1]; ok {
+			break
+		}
+		s.ackSeq++
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestSessionAckSeq verifies the acknowledged sequence number only
+// advances past commands once all preceding commands have completed.
+func TestSessionAckSeq(t *testing.T) {
+	s := NewSession()
+	if s.ID == 0 {
+		t.Fatal("expected non-zero session ID")
+	}
+	for i := int64(1); i <= 3; i++ {
+		if seq, ackSeq := s.next(); seq != i || ackSeq != 0 {
+			t.Fatalf("expected seq %d, ack 0; got %d, %d", i, seq, ackSeq)
+		}
+	}
+	s.complete(2)
+	if _, ackSeq := s.next(); ackSeq != 0 {
+		t.Errorf("expected ack 0 with command 1 pending; got %d", ackSeq)
+	}
+	s.complete(1)
+	if _, ackSeq := s.next(); ackSeq != 2 {
+		t.Errorf("expected ack 2; got %d", ackSeq)
+	}
+	s.complete(3)
+	s.complete(4)
+	s.complete(5)
+	if _, ackSeq := s.next(); ackSeq != 5 {
+		t.Errorf("expected ack 5; got %d", ackSeq)
+	}
+}
+
+// TestKVSessionCmdIDs verifies read-write commands issued by KV
+// clients sharing a session receive increasing sequence numbers.
+func TestKVSessionCmdIDs(t *testing.T) {
+	var cmdIDs []proto.ClientCmdID
+	sender := newTestSender(func(call *Call) {
+		cmdIDs = append(cmdIDs, call.Args.Header().CmdID)
+	})
+	kv := NewKV(sender, nil)
+	reconnected := NewKVWithSession(sender, nil, kv.Session())
+	for _, c := range []*KV{kv, reconnected} {
+		if err := c.Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cmdIDs) != 2 {
+		t.Fatalf("expected 2 commands; got %d", len(cmdIDs))
+	}
+	for i, cmdID := range cmdIDs {
+		if cmdID.SessionID != kv.Session().ID || cmdID.Seq != int64(i+1) || cmdID.AckSeq != int64(i) {
+			t.Errorf("%d: unexpected command ID %+v", i, cmdID)
+		}
+	}
+}
+
+// TestKVSessionRetries verifies that a command keeps its sequence
+// number across retries and that a resubmitted command whose result
+// was ambiguous is retried under its original command ID, while the
+// session's acknowledged sequence number doesn't advance past it.
+func TestKVSessionRetries(t *testing.T) {
+	var cmdIDs []proto.ClientCmdID
+	sender := newTestSender(func(call *Call) {
+		cmdIDs = append(cmdIDs, call.Args.Header().CmdID)
+		switch len(cmdIDs) {
+		case 1:
+			call.Reply.Header().SetGoError(&proto.WriteTooOldError{})
+		case 2:
+			call.Reply.Header().SetGoError(proto.NewAmbiguousResultError(util.Errorf("connection reset")))
+		}
+	})
+	kv := NewKV(sender, nil)
+	args := &proto.PutRequest{}
+	err := kv.Call(proto.Put, args, &proto.PutResponse{})
+	if _, ok := err.(*proto.AmbiguousResultError); !ok {
+		t.Fatalf("expected an ambiguous result; got %v", err)
+	}
+	if len(cmdIDs) != 2 || cmdIDs[0].Seq != 1 || cmdIDs[1].Seq != 1 || cmdIDs[0].Random == cmdIDs[1].Random {
+		t.Fatalf("expected a retry with a fresh ID under seq 1; got %+v", cmdIDs)
+	}
+	if !kv.Session().pending(1) {
+		t.Error("expected the ambiguous command to remain pending")
+	}
+
+	// Resubmit the command through a client reconnected to the session.
+	reconnected := NewKVWithSession(sender, nil, kv.Session())
+	if err := reconnected.Call(proto.Put, args, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cmdIDs) != 3 || cmdIDs[2] != cmdIDs[1] {
+		t.Errorf("expected the resubmission to carry ID %+v; got %+v", cmdIDs[1], cmdIDs[2:])
+	}
+	if err := reconnected.Call(proto.Put, args, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if cmdID := cmdIDs[len(cmdIDs)-1]; cmdID.Seq != 2 || cmdID.AckSeq != 1 {
+		t.Errorf("expected a new command at seq 2 acknowledging seq 1; got %+v", cmdID)
+	}
+}
@@ -28,15 +28,17 @@ import (
 type singleCallSender struct {
 	wrapped KVSender
 	clock   Clock
+	session *Session
 }
 
 // newSingleCallSender returns a new instance of singleCallSender
-// wrapping the supplied sender. The clock is used to create client
-// command IDs.
-func newSingleCallSender(wrapped KVSender, clock Clock) *singleCallSender {
+// wrapping the supplied sender. The clock and session are used to
+// create client command IDs.
+func newSingleCallSender(wrapped KVSender, clock Clock, session *Session) *singleCallSender {
 	return &singleCallSender{
 		wrapped: wrapped,
 		clock:   clock,
+		session: session,
 	}
 }
 
@@ -50,11 +52,21 @@ func (s *singleCallSender) Send(call *Call) {
 	}
 	var retryOpts util.RetryOptions = TxnRetryOptions
 	retryOpts.Tag = call.Method
+	// A resubmitted call whose result was ambiguous keeps its command
+	// ID on the first attempt, so that its cached response is returned
+	// if it executed.
+	resubmitted := call.hasPendingCmdID(s.session)
 	if err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
 		// Reset client command ID (if applicable) on every retry at this
 		// level--retries due to network timeouts or disconnects are
 		// handled at lower levels by the KVSender implementation(s).
-		call.resetClientCmdID(s.clock)
+		// Conflicts are cached responses, so a retry needs a fresh ID,
+		// but it keeps its sequence number in the session.
+		if resubmitted {
+			resubmitted = false
+		} else {
+			call.resetClientCmdID(s.clock, s.session)
+		}
 
 		// Send the call.
 		s.wrapped.Send(call)
@@ -84,6 +96,9 @@ func (s *singleCallSender) Send(call *Call) {
 	}); err != nil {
 		call.Reply.Header().SetGoError(err)
 	}
+	// The call keeps its sequence number in the session across retries
+	// and completes with its final reply, unless that's ambiguous.
+	call.completeClientCmdID(s.session)
 }
 
 // Close implements the KVSender interface. It invokes Close on the
@@ -26,7 +26,7 @@ import (
 )
 
 func TestSingleCallSenderSend(t *testing.T) {
-	ts := newSingleCallSender(newTestSender(nil), nil)
+	ts := newSingleCallSender(newTestSender(nil), nil, nil)
 	reply := &proto.PutResponse{}
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 	if reply.GoError() != nil {
@@ -47,7 +47,7 @@ func TestSingleCallSenderZeroTimestamp(t *testing.T) {
 				t.Errorf("%s: expected non-zero timestamp for method", call.Method)
 			}
 		}
-	}), nil)
+	}), nil, nil)
 	for method := range proto.AllMethods {
 		args, reply, err := proto.CreateArgsAndReply(method)
 		if err != nil {
@@ -86,7 +86,7 @@ func TestSingleCallSenderErrors(t *testing.T) {
 			if count == 1 {
 				call.Reply.Header().SetGoError(test.err)
 			}
-		}), nil)
+		}), nil, nil)
 		reply := &proto.PutResponse{}
 		scs.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 		if test.retry {
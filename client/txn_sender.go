@@ -20,6 +20,7 @@ package client
 import (
 	"sync"
 
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
@@ -28,7 +29,9 @@ import (
 // A txnSender proxies requests to the underlying KVSender, automatically
 // beginning a transaction and then propagating timestamps and txn
 // changes to all commands. On receipt of TransactionRetryError,
-// the transaction epoch is incremented and error passed to caller.
+// the transaction's reads are refreshed if it was ending; failing
+// that, the transaction epoch is incremented and error passed to
+// caller.
 // On receipt of TransactionAbortedError, the transaction is re-
 // created and error passed to caller.
 //
@@ -37,6 +40,7 @@ import (
 type txnSender struct {
 	wrapped KVSender
 	clock   Clock
+	session *Session
 	*TransactionOptions
 	txnEnd bool // True if EndTransaction was invoked internally
 
@@ -44,14 +48,31 @@ type txnSender struct {
 	timestamp   proto.Timestamp
 	txn         *proto.Transaction
 	minPriority int32 // set on abort
+	// Spans read in the current epoch, to be refreshed if the
+	// transaction's timestamp is pushed. If the number of spans
+	// exceeds maxRefreshSpans, reads are no longer tracked and the
+	// transaction must restart instead.
+	refreshSpans   []span
+	refreshInvalid bool
+}
+
+// maxRefreshSpans is the maximum number of spans read by a
+// transaction which are tracked for refreshing.
+const maxRefreshSpans = 1000
+
+// A span is a key or key range read by a transaction.
+type span struct {
+	key, endKey proto.Key
 }
 
 // newTxnSender returns a new instance of txnSender which wraps a
-// KVSender and uses the supplied transaction options.
-func newTxnSender(wrapped KVSender, clock Clock, opts *TransactionOptions) *txnSender {
+// KVSender and uses the supplied transaction options. The clock and
+// session are used to create client command IDs.
+func newTxnSender(wrapped KVSender, clock Clock, session *Session, opts *TransactionOptions) *txnSender {
 	return &txnSender{
 		wrapped:            wrapped,
 		clock:              clock,
+		session:            session,
 		TransactionOptions: opts,
 	}
 }
@@ -63,9 +84,14 @@ func newTxnSender(wrapped KVSender, clock Clock, opts *TransactionOptions) *txnS
 // - Begin transaction with first key
 // - Propagate response timestamps to subsequent requests
 // - Set client command IDs on read-write commands
+// - Assign each read-write command the transaction's next sequence
+//   number, making replays of it within the epoch idempotent
+// - Refresh reads and commit at the pushed timestamp on a
+//   TransactionRetryError from EndTransaction, if no other txn has
+//   written to the spans read since
 // - Increment epoch -or- abort on TransactionRetryError
 // - Restart transaction on TransactionAbortedError
-// - Restart transaction on WriteTooOldError
+// - Advance timestamp and retry on WriteTooOldError (SNAPSHOT commits at it)
 // - Retry commands (with possible backoff) on WriteIntentErrors
 //
 // If limits for backoff / retry are enabled through the options and
@@ -109,6 +135,10 @@ func (ts *txnSender) Send(call *Call) {
 		ts.Unlock()
 		return
 	}
+	// Number each write so that replays of it are recognized as such.
+	if proto.IsReadWrite(call.Method) {
+		ts.txn.Sequence = gogoproto.Int32(ts.txn.GetSequence() + 1)
+	}
 	// Set Args.Timestamp & Args.Txn to reflect current values.
 	userPriority := call.Args.Header().GetUserPriority()
 	txnCopy := *ts.txn
@@ -123,7 +153,10 @@ func (ts *txnSender) Send(call *Call) {
 		// Reset client command ID (if applicable) on every retry at this
 		// level--retries due to network timeouts or disconnects are
 		// handled by lower-level KVSender implementation(s).
-		call.resetClientCmdID(ts.clock)
+		call.resetClientCmdID(ts.clock, ts.session)
+		// Clear the reply of a previous attempt; decoding a reply from
+		// the wire doesn't overwrite fields left unset by the range.
+		call.Reply.Reset()
 
 		// Send call through wrapped sender.
 		ts.wrapped.Send(call)
@@ -135,7 +168,7 @@ func (ts *txnSender) Send(call *Call) {
 			ts.timestamp = call.Reply.Header().Timestamp
 		}
 		if call.Reply.Header().GoError() != nil {
-			log.Infof("failed %s: %s", call.Method, call.Reply.Header().GoError())
+			log.Infof("txn %q: failed %s: %s", txnCopy.ID, call.Method, call.Reply.Header().GoError())
 		}
 		// Take action on various errors.
 		switch t := call.Reply.Header().GoError().(type) {
@@ -155,6 +188,7 @@ func (ts *txnSender) Send(call *Call) {
 				ts.timestamp = candidateTS
 			}
 			ts.txn.Restart(userPriority, ts.txn.Priority, ts.timestamp)
+			ts.resetRefreshSpans()
 		case *proto.TransactionAbortedError:
 			// Increase timestamp if applicable.
 			if ts.timestamp.Less(t.Txn.Timestamp) {
@@ -162,6 +196,7 @@ func (ts *txnSender) Send(call *Call) {
 			}
 			ts.txn = nil // Abort.
 			ts.minPriority = t.Txn.Priority
+			ts.resetRefreshSpans()
 		case *proto.TransactionPushError:
 			// Increase timestamp if applicable.
 			if ts.timestamp.Less(t.PusheeTxn.Timestamp) {
@@ -169,12 +204,20 @@ func (ts *txnSender) Send(call *Call) {
 				ts.timestamp.Logical++ // ensure this txn's timestamp > other txn
 			}
 			ts.txn.Restart(userPriority, t.PusheeTxn.Priority-1, ts.timestamp)
+			ts.resetRefreshSpans()
 		case *proto.TransactionRetryError:
 			// Increase timestamp if applicable.
 			if ts.timestamp.Less(t.Txn.Timestamp) {
 				ts.timestamp = t.Txn.Timestamp
 			}
+			// If the transaction's commit timestamp was pushed, it may
+			// still commit at the pushed timestamp if nothing it read
+			// has since been written. Retry the commit immediately.
+			if ts.refreshCommit(call, t.Txn.Timestamp) {
+				return util.RetryReset, nil
+			}
 			ts.txn.Restart(userPriority, t.Txn.Priority, ts.timestamp)
+			ts.resetRefreshSpans()
 		case *proto.WriteTooOldError:
 			// If write is too old, update the timestamp and immediately retry.
 			if ts.timestamp.Less(t.ExistingTimestamp) {
@@ -205,19 +248,133 @@ func (ts *txnSender) Send(call *Call) {
 			// Make sure to upgrade our priority to the conflicting txn's - 1.
 			return util.RetryContinue, nil
 		case nil:
-			if call.Method == proto.EndTransaction || call.Method == proto.InternalEndTxn {
-				ts.txnEnd = true // set this txn as having been ended
+			if proto.NeedReadPerm(call.Method) {
+				ts.addRefreshSpan(call.Args.Header())
+			}
+			var endTxn *proto.Transaction
+			switch t := call.Reply.(type) {
+			case *proto.EndTransactionResponse:
+				endTxn = t.Txn
+			case *proto.InternalEndTxnResponse:
+				endTxn = t.Txn
+			default:
+				return util.RetryBreak, nil
+			}
+			ts.txnEnd = true // set this txn as having been ended
+			// Reflect the final status of the transaction.
+			if endTxn != nil && ts.txn != nil {
+				ts.txn.Status = endTxn.Status
 			}
 		}
 		return util.RetryBreak, nil
 	})
+	// The call keeps its sequence number in the session across retries
+	// and completes with its final reply.
+	call.completeClientCmdID(ts.session)
 
 	if _, ok := err.(*util.RetryMaxAttemptsError); ok {
+		ts.Lock()
 		ts.txn.Restart(userPriority, ts.txn.Priority, ts.timestamp)
+		ts.resetRefreshSpans()
+		ts.Unlock()
 		call.Reply.Header().SetGoError(proto.NewTransactionRetryError(ts.txn))
 	}
 }
 
+// addRefreshSpan records the key or key range read by a command.
+// The txnSender lock must be held.
+func (ts *txnSender) addRefreshSpan(header *proto.RequestHeader) {
+	if ts.refreshInvalid {
+		return
+	}
+	if len(ts.refreshSpans) >= maxRefreshSpans {
+		ts.refreshSpans = nil
+		ts.refreshInvalid = true
+		return
+	}
+	ts.refreshSpans = append(ts.refreshSpans, span{key: header.Key, endKey: header.EndKey})
+}
+
+// resetRefreshSpans forgets the spans read by the transaction, as
+// its commands are reissued on restart. The txnSender lock must be
+// held.
+func (ts *txnSender) resetRefreshSpans() {
+	ts.refreshSpans = nil
+	ts.refreshInvalid = false
+}
+
+// refreshCommit refreshes the transaction's reads at refreshTS if
+// call ends the transaction, returning true if the call should be
+// retried, permitted to commit at refreshTS. The txnSender lock must
+// be held.
+func (ts *txnSender) refreshCommit(call *Call, refreshTS proto.Timestamp) bool {
+	var refreshed **proto.Timestamp
+	switch t := call.Args.(type) {
+	case *proto.EndTransactionRequest:
+		refreshed = &t.RefreshedTimestamp
+	case *proto.InternalEndTxnRequest:
+		refreshed = &t.RefreshedTimestamp
+	default:
+		return false
+	}
+	if !ts.refreshReads(refreshTS, call.Args.Header()) {
+		return false
+	}
+	*refreshed = &refreshTS
+	call.Args.Header().Timestamp = ts.timestamp
+	// Clear the retry error so it isn't mistaken for the result of
+	// the refreshed commit.
+	call.Reply.Reset()
+	return true
+}
+
+// refreshReads verifies that no other transaction has written to the
+// spans read by the transaction between its timestamp and refreshTS,
+// recording the reads at refreshTS. Returns true if the transaction
+// may commit at refreshTS without restarting. The txnSender lock must
+// be held.
+func (ts *txnSender) refreshReads(refreshTS proto.Timestamp, header *proto.RequestHeader) bool {
+	if ts.refreshInvalid {
+		return false
+	}
+	txn := *ts.txn
+	txn.Timestamp = refreshTS
+	for _, s := range ts.refreshSpans {
+		call := &Call{
+			Method: proto.InternalRefresh,
+			Args: &proto.InternalRefreshRequest{
+				RequestHeader: proto.RequestHeader{
+					Key:          s.key,
+					EndKey:       s.endKey,
+					User:         header.User,
+					UserPriority: header.UserPriority,
+					Timestamp:    refreshTS,
+					Txn:          &txn,
+				},
+				RefreshFrom: ts.txn.Timestamp,
+			},
+			Reply: &proto.InternalRefreshResponse{},
+		}
+		ts.wrapped.Send(call)
+		if err := call.Reply.Header().GoError(); err != nil {
+			log.Infof("txn %q: unable to refresh reads at %s: %s", txn.ID, refreshTS, err)
+			return false
+		}
+	}
+	return true
+}
+
+// transaction returns a copy of the transaction, or nil if it has not
+// yet begun.
+func (ts *txnSender) transaction() *proto.Transaction {
+	ts.Lock()
+	defer ts.Unlock()
+	if ts.txn == nil {
+		return nil
+	}
+	return gogoproto.Clone(ts.txn).(*proto.Transaction)
+}
+
 // Close is a noop for the txnSender.
 func (ts *txnSender) Close() {
 }
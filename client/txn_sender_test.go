@@ -19,6 +19,7 @@ package client
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 	"time"
 
@@ -78,7 +79,7 @@ func (ts *testSender) Close() {
 // TestTxnSenderBeginTxn verifies a BeginTransaction is issued as soon
 // as a request is received using the request key as base key.
 func TestTxnSenderBeginTxn(t *testing.T) {
-	ts := newTxnSender(newTestSender(nil), nil, &TransactionOptions{})
+	ts := newTxnSender(newTestSender(nil), nil, nil, &TransactionOptions{})
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: &proto.PutResponse{}})
 	if ts.txn == nil || !bytes.Equal(ts.txn.ID, txnID) {
 		t.Errorf("expected sender to have transaction initialized with ID %s: %s", txnID, ts.txn)
@@ -104,7 +105,7 @@ func TestTxnSenderNewClientCmdID(t *testing.T) {
 			return
 		}
 		call.Reply.Header().SetGoError(&proto.WriteIntentError{})
-	}), nil, &TransactionOptions{})
+	}), nil, nil, &TransactionOptions{})
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: &proto.PutResponse{}})
 	if count != 3 {
 		t.Errorf("expected three retries; got %d", count)
@@ -114,6 +115,33 @@ func TestTxnSenderNewClientCmdID(t *testing.T) {
 	}
 }
 
+// TestTxnSenderSequence verifies that each read-write command is sent
+// with the transaction's next sequence number, and that reads and
+// retries of a command don't advance it.
+func TestTxnSenderSequence(t *testing.T) {
+	TxnRetryOptions.Backoff = 1 * time.Millisecond
+
+	var seqs []int32
+	retried := false
+	ts := newTxnSender(newTestSender(func(call *Call) {
+		seqs = append(seqs, call.Args.Header().Txn.GetSequence())
+		if call.Method == proto.Put && !retried {
+			retried = true
+			call.Reply.Header().SetGoError(&proto.WriteIntentError{})
+		}
+	}), nil, nil, &TransactionOptions{})
+	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: &proto.PutResponse{}})
+	ts.Send(&Call{
+		Method: proto.Get,
+		Args:   &proto.GetRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("key")}},
+		Reply:  &proto.GetResponse{},
+	})
+	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: &proto.PutResponse{}})
+	if expected := []int32{1, 1, 1, 2}; !reflect.DeepEqual(seqs, expected) {
+		t.Errorf("expected sequences %v; got %v", expected, seqs)
+	}
+}
+
 // TestTxnSenderEndTxn verifies that request key is set to the txn ID
 // on a call to EndTransaction.
 func TestTxnSenderEndTxn(t *testing.T) {
@@ -131,7 +159,7 @@ func TestTxnSenderEndTxn(t *testing.T) {
 			if !call.Args.Header().Key.Equal(txnID) {
 				t.Errorf("%d: expected request key to be %q; got %q", i, txnID, call.Args.Header().Key)
 			}
-		}), nil, &TransactionOptions{})
+		}), nil, nil, &TransactionOptions{})
 		ts.Send(&Call{Method: test.method, Args: test.args, Reply: test.reply})
 		if !ts.txnEnd {
 			t.Errorf("%d: expected txnEnd to be true", i)
@@ -150,7 +178,7 @@ func TestTxnSenderNonTransactional(t *testing.T) {
 			if !isTransactional {
 				t.Errorf("%s: should not have received this method type in txn", method)
 			}
-		}), nil, &TransactionOptions{})
+		}), nil, nil, &TransactionOptions{})
 		args, reply, err := proto.CreateArgsAndReply(method)
 		if err != nil {
 			t.Errorf("%s: unexpected error creating args and reply: %s", method, err)
@@ -186,7 +214,7 @@ func TestTxnSenderRequestTimestamp(t *testing.T) {
 			t.Errorf("%d: expected ts %s got %s", testIdx, test.expRequestTS, call.Args.Header().Timestamp)
 		}
 		call.Reply.Header().Timestamp = test.responseTS
-	}), nil, &TransactionOptions{})
+	}), nil, nil, &TransactionOptions{})
 
 	for testIdx = range testCases {
 		ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: &proto.PutResponse{}})
@@ -203,7 +231,7 @@ func TestTxnSenderReadWithinUncertaintyIntervalError(t *testing.T) {
 	ts := newTxnSender(newTestSender(func(call *Call) {
 		count++
 		call.Reply.Header().SetGoError(&proto.ReadWithinUncertaintyIntervalError{ExistingTimestamp: existingTS})
-	}), nil, &TransactionOptions{})
+	}), nil, nil, &TransactionOptions{})
 	reply := &proto.PutResponse{}
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 	if count != 1 {
@@ -234,7 +262,7 @@ func TestTxnSenderTransactionAbortedError(t *testing.T) {
 		call.Reply.Header().SetGoError(&proto.TransactionAbortedError{
 			Txn: proto.Transaction{Timestamp: abortTS, Priority: abortPri},
 		})
-	}), nil, &TransactionOptions{})
+	}), nil, nil, &TransactionOptions{})
 	reply := &proto.PutResponse{}
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 	if count != 1 {
@@ -268,7 +296,7 @@ func TestTxnSenderTransactionPushError(t *testing.T) {
 		call.Reply.Header().SetGoError(&proto.TransactionPushError{
 			PusheeTxn: proto.Transaction{Timestamp: pusheeTS, Priority: pusheePri},
 		})
-	}), nil, &TransactionOptions{})
+	}), nil, nil, &TransactionOptions{})
 	reply := &proto.PutResponse{}
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 	if count != 1 {
@@ -302,7 +330,7 @@ func TestTxnSenderTransactionRetryError(t *testing.T) {
 		call.Reply.Header().SetGoError(&proto.TransactionRetryError{
 			Txn: proto.Transaction{Timestamp: newTS, Priority: newPri},
 		})
-	}), nil, &TransactionOptions{})
+	}), nil, nil, &TransactionOptions{})
 	reply := &proto.PutResponse{}
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 	if count != 1 {
@@ -322,6 +350,76 @@ func TestTxnSenderTransactionRetryError(t *testing.T) {
 	}
 }
 
+// TestTxnSenderRefreshReads verifies that a transaction whose
+// commit timestamp was pushed refreshes its reads and retries its
+// commit at the pushed timestamp, restarting only if the refresh
+// fails.
+func TestTxnSenderRefreshReads(t *testing.T) {
+	TxnRetryOptions.Backoff = 1 * time.Millisecond
+
+	pushedTS := makeTS(10, 10)
+	for _, refreshOK := range []bool{true, false} {
+		var refreshes []proto.Key
+		var commits []*proto.Timestamp // refreshed timestamp of each commit
+		ts := newTxnSender(newTestSender(func(call *Call) {
+			switch call.Method {
+			case proto.InternalRefresh:
+				args := call.Args.(*proto.InternalRefreshRequest)
+				refreshes = append(refreshes, args.Key)
+				if !args.Timestamp.Equal(pushedTS) || !args.RefreshFrom.Equal(makeTS(0, 0)) {
+					t.Errorf("expected refresh from %s to %s; got %s to %s", makeTS(0, 0), pushedTS, args.RefreshFrom, args.Timestamp)
+				}
+				if !refreshOK {
+					call.Reply.Header().SetGoError(proto.NewTransactionRetryError(args.Txn))
+				}
+			case proto.EndTransaction:
+				refreshed := call.Args.(*proto.EndTransactionRequest).RefreshedTimestamp
+				commits = append(commits, refreshed)
+				if refreshed == nil || refreshed.Less(pushedTS) {
+					call.Reply.Header().SetGoError(&proto.TransactionRetryError{
+						Txn: proto.Transaction{Timestamp: pushedTS},
+					})
+				}
+			}
+		}), nil, nil, &TransactionOptions{})
+
+		for _, key := range []string{"a", "b"} {
+			reply := &proto.GetResponse{}
+			ts.Send(&Call{Method: proto.Get, Args: proto.GetArgs(proto.Key(key)), Reply: reply})
+			if err := reply.GoError(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reply := &proto.EndTransactionResponse{}
+		ts.Send(&Call{Method: proto.EndTransaction, Args: &proto.EndTransactionRequest{Commit: true}, Reply: reply})
+
+		if refreshOK {
+			if err := reply.GoError(); err != nil {
+				t.Fatalf("expected commit after refresh; got %s", err)
+			}
+			if expRefreshes := []proto.Key{proto.Key("a"), proto.Key("b")}; !reflect.DeepEqual(refreshes, expRefreshes) {
+				t.Errorf("expected refreshes of %v; got %v", expRefreshes, refreshes)
+			}
+			if expCommits := []*proto.Timestamp{nil, &pushedTS}; !reflect.DeepEqual(commits, expCommits) {
+				t.Errorf("expected commits refreshed at %v; got %v", expCommits, commits)
+			}
+			if ts.txn.Epoch != 0 {
+				t.Errorf("expected no restart; got epoch %d", ts.txn.Epoch)
+			}
+		} else {
+			if _, ok := reply.GoError().(*proto.TransactionRetryError); !ok {
+				t.Fatalf("expected txn retry error; got %s", reply.GoError())
+			}
+			if len(refreshes) != 1 || len(commits) != 1 {
+				t.Errorf("expected a single failed refresh and commit; got %v, %v", refreshes, commits)
+			}
+			if ts.txn.Epoch != 1 || len(ts.refreshSpans) != 0 {
+				t.Errorf("expected restart with no spans to refresh; got epoch %d, spans %v", ts.txn.Epoch, ts.refreshSpans)
+			}
+		}
+	}
+}
+
 // TestTxnSenderWriteTooOldError verifies immediate retry of the
 // operation using a timestamp one greater than existing timestamp.
 func TestTxnSenderWriteTooOldError(t *testing.T) {
@@ -339,7 +437,7 @@ func TestTxnSenderWriteTooOldError(t *testing.T) {
 		if !call.Args.Header().Timestamp.Equal(makeTS(10, 11)) {
 			t.Errorf("expected args timestamp to be %s + 1; got %s", existingTS, call.Args.Header().Timestamp)
 		}
-	}), nil, &TransactionOptions{})
+	}), nil, nil, &TransactionOptions{})
 	reply := &proto.PutResponse{}
 	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 	if count != 2 {
@@ -383,7 +481,7 @@ func TestTxnSenderWriteIntentError(t *testing.T) {
 			if !call.Args.Header().Timestamp.Equal(expTS) {
 				t.Errorf("resolved? %t: expected args timestamp to be %s; got %s", resolved, expTS, call.Args.Header().Timestamp)
 			}
-		}), nil, &TransactionOptions{})
+		}), nil, nil, &TransactionOptions{})
 		reply := &proto.PutResponse{}
 		ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: reply})
 		if count != 2 {
@@ -0,0 +1,192 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// WatchInterval is the duration for which a Watcher's long-poll waits
+// for a change to its key prefix before the prefix is scanned anyway.
+var WatchInterval = 10 * time.Second
+
+// watchPageSize is the number of rows fetched per scan when polling
+// a watched key prefix.
+const watchPageSize = 1000
+
+// A WatchEvent describes a change to a key under a watched prefix.
+type WatchEvent struct {
+	Key proto.Key
+	// Value is the new value of the key, or nil if the key was deleted.
+	Value *proto.Value
+}
+
+// A Watcher delivers notifications of changes to keys under a prefix.
+// The watcher long-polls the prefix: a scan with a wait timeout is
+// held by the range until a key under the prefix is written after the
+// watcher's previous scan (see ScanRequest.WaitTimeout). The prefix is
+// then scanned and the timestamps of the values found are compared
+// with those of the previous scan; multiple writes to a key between
+// scans are reported as a single event. Events are delivered on C,
+// which is closed when the watcher is stopped or encounters an error.
+type Watcher struct {
+	C <-chan WatchEvent
+
+	kv      *KV
+	prefix  proto.Key
+	c       chan WatchEvent
+	stopper *util.Stopper
+	last    map[string]proto.Timestamp // Value timestamps from last scan
+	waitTS  proto.Timestamp            // Timestamp of the last long-poll
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch returns a Watcher which delivers notifications when keys
+// beginning with prefix are written or deleted. Only changes made
+// after Watch is invoked are reported. The caller must invoke Stop
+// when the watcher is no longer needed.
+func (kv *KV) Watch(prefix proto.Key) *Watcher {
+	c := make(chan WatchEvent)
+	w := &Watcher{
+		C:       c,
+		kv:      kv,
+		prefix:  prefix,
+		c:       c,
+		stopper: util.NewStopper(),
+	}
+	// Establish the initial state synchronously so that changes made
+	// as soon as Watch returns are reported.
+	ok := w.wait(0) && w.poll()
+	w.stopper.RunWorker(func() {
+		if ok {
+			w.run()
+		}
+		close(w.c)
+	})
+	return w
+}
+
+// Stop stops the watcher and closes C.
+func (w *Watcher) Stop() {
+	w.stopper.Stop()
+}
+
+// Err returns the error, if any, which caused the watcher to stop.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// run long-polls the watched prefix until the watcher is stopped or
+// a scan fails.
+func (w *Watcher) run() {
+	for {
+		select {
+		case <-w.stopper.ShouldStop():
+			return
+		default:
+		}
+		if !w.wait(WatchInterval) || !w.poll() {
+			return
+		}
+	}
+}
+
+// wait blocks until a key under the watched prefix is written after
+// the previous wait returned, or the timeout elapses, and records the
+// timestamp at which it returned. The wait is abandoned if the
+// watcher is stopped. Returns false if the watcher should exit.
+func (w *Watcher) wait(timeout time.Duration) bool {
+	args := &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:    w.prefix,
+			EndKey: w.prefix.PrefixEnd(),
+		},
+		MaxResults:    1,
+		WaitTimestamp: w.waitTS,
+		WaitTimeout:   timeout.Nanoseconds(),
+	}
+	reply := &proto.ScanResponse{}
+	errC := make(chan error, 1)
+	go func() { errC <- w.kv.Call(proto.Scan, args, reply) }()
+	select {
+	case err := <-errC:
+		if err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+			return false
+		}
+	case <-w.stopper.ShouldStop():
+		return false
+	}
+	w.waitTS = reply.Timestamp
+	return true
+}
+
+// poll scans the watched prefix and delivers an event for each key
+// which was added, modified or deleted since the previous scan. The
+// first scan establishes the initial state and delivers no events.
+// Returns false if the watcher should exit.
+func (w *Watcher) poll() bool {
+	cur := map[string]proto.Timestamp{}
+	var events []WatchEvent
+	iter := w.kv.NewIterator(w.prefix, w.prefix.PrefixEnd(), watchPageSize)
+	for iter.Next() {
+		key := iter.Key()
+		var ts proto.Timestamp
+		if value := iter.Value(); value.Timestamp != nil {
+			ts = *value.Timestamp
+		}
+		cur[string(key)] = ts
+		if w.last == nil {
+			continue
+		}
+		if lastTS, ok := w.last[string(key)]; !ok || !lastTS.Equal(ts) {
+			events = append(events, WatchEvent{Key: key, Value: iter.Value()})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+		return false
+	}
+	for key := range w.last {
+		if _, ok := cur[key]; !ok {
+			events = append(events, WatchEvent{Key: proto.Key(key)})
+		}
+	}
+	w.last = cur
+
+	for _, event := range events {
+		select {
+		case w.c <- event:
+		case <-w.stopper.ShouldStop():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package client
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// watchTestStore is a simple in-memory map of keys to values which
+// services Scan requests for a test sender.
+type watchTestStore struct {
+	sync.Mutex
+	wallTime int64
+	values   map[string]proto.Value
+	scanErr  error
+}
+
+func (s *watchTestStore) put(key string, val []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.wallTime++
+	s.values[key] = proto.Value{Bytes: val, Timestamp: &proto.Timestamp{WallTime: s.wallTime}}
+}
+
+func (s *watchTestStore) del(key string) {
+	s.Lock()
+	defer s.Unlock()
+	s.wallTime++
+	delete(s.values, key)
+}
+
+// wait emulates a range holding a scan with a wait timeout until a
+// value is written after the wait timestamp.
+func (s *watchTestStore) wait(args *proto.ScanRequest) {
+	for deadline := time.Now().Add(time.Duration(args.WaitTimeout)); time.Now().Before(deadline); {
+		s.Lock()
+		written := args.WaitTimestamp.WallTime < s.wallTime
+		s.Unlock()
+		if written {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *watchTestStore) scan(call *Call) {
+	if call.Method != proto.Scan {
+		return
+	}
+	args := call.Args.(*proto.ScanRequest)
+	reply := call.Reply.(*proto.ScanResponse)
+	if args.WaitTimeout > 0 {
+		s.wait(args)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.scanErr != nil {
+		call.Reply.Header().SetGoError(s.scanErr)
+		return
+	}
+	reply.Timestamp = proto.Timestamp{WallTime: s.wallTime}
+	var keys []string
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		k := proto.Key(key)
+		if !k.Less(args.Key) && k.Less(args.EndKey) && int64(len(reply.Rows)) < args.MaxResults {
+			reply.Rows = append(reply.Rows, proto.KeyValue{Key: k, Value: s.values[key]})
+		}
+	}
+}
+
+// expectWatchEvent reads the next event from the watcher and verifies
+// its key and value.
+func expectWatchEvent(t *testing.T, w *Watcher, key string, val []byte) {
+	select {
+	case e, ok := <-w.C:
+		if !ok {
+			t.Fatalf("watcher closed unexpectedly: %v", w.Err())
+		}
+		if string(e.Key) != key {
+			t.Errorf("expected event for key %q; got %q", key, e.Key)
+		}
+		if val == nil && e.Value != nil {
+			t.Errorf("expected deletion of %q; got value %q", key, e.Value.Bytes)
+		} else if val != nil && (e.Value == nil || string(e.Value.Bytes) != string(val)) {
+			t.Errorf("expected value %q for key %q; got %+v", val, key, e.Value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event on key %q", key)
+	}
+}
+
+// TestWatch verifies that writes and deletions of keys under the
+// watched prefix are delivered, and other keys are ignored. The
+// interval is long enough that events are only delivered in time if
+// the long-poll returns on writes.
+func TestWatch(t *testing.T) {
+	defer func(interval time.Duration) { WatchInterval = interval }(WatchInterval)
+	WatchInterval = 1 * time.Minute
+
+	s := &watchTestStore{values: map[string]proto.Value{}}
+	s.put("config-a", []byte("1"))
+	kv := NewKV(newTestSender(s.scan), nil)
+	w := kv.Watch(proto.Key("config-"))
+	defer w.Stop()
+
+	// Writes outside of the prefix are not reported.
+	s.put("other", []byte("x"))
+	s.put("config-b", []byte("2"))
+	expectWatchEvent(t, w, "config-b", []byte("2"))
+	s.put("config-a", []byte("3"))
+	expectWatchEvent(t, w, "config-a", []byte("3"))
+	s.del("config-b")
+	expectWatchEvent(t, w, "config-b", nil)
+
+	w.Stop()
+	if _, ok := <-w.C; ok {
+		t.Error("expected channel to be closed after stop")
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// TestWatchError verifies that a scan error stops the watcher and is
+// returned by Err.
+func TestWatchError(t *testing.T) {
+	defer func(interval time.Duration) { WatchInterval = interval }(WatchInterval)
+	WatchInterval = 1 * time.Millisecond
+
+	s := &watchTestStore{values: map[string]proto.Value{}, scanErr: &proto.RangeNotFoundError{}}
+	w := NewKV(newTestSender(s.scan), nil).Watch(proto.Key("config-"))
+	defer w.Stop()
+	if _, ok := <-w.C; ok {
+		t.Fatal("expected channel to be closed on error")
+	}
+	if _, ok := w.Err().(*proto.RangeNotFoundError); !ok {
+		t.Errorf("expected range not found error; got %v", w.Err())
+	}
+}
@@ -73,6 +73,9 @@ func (c *client) start(g *Gossip, done chan *client) {
 		c.err = util.Errorf("gossip client failed to connect")
 		done <- c
 		return
+	case <-c.closer:
+		done <- c
+		return
 	}
 
 	// Start gossipping and wait for disconnect or error.
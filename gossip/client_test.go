@@ -34,35 +34,39 @@ func init() {
 }
 
 // startGossip creates local and remote gossip instances.
-// The remote gossip instance launches its gossip service.
-func startGossip(t *testing.T) (local, remote *Gossip, lserver, rserver *rpc.Server) {
+// Both gossip instances launch their gossip services. Stop the
+// returned stopper when done.
+func startGossip(t *testing.T) (local, remote *Gossip, stopper *util.Stopper) {
 	tlsConfig := rpc.LoadInsecureTLSConfig()
+	stopper = util.NewStopper()
 	lclock := hlc.NewClock(hlc.UnixNano)
-	lRPCContext := rpc.NewContext(lclock, tlsConfig)
+	lRPCContext := rpc.NewContext(lclock, tlsConfig, stopper)
 
 	laddr := util.CreateTestAddr("unix")
-	lserver = rpc.NewServer(laddr, lRPCContext)
+	lserver := rpc.NewServer(laddr, lRPCContext)
 	if err := lserver.Start(); err != nil {
 		t.Fatal(err)
 	}
 	local = New(lRPCContext)
 	rclock := hlc.NewClock(hlc.UnixNano)
 	raddr := util.CreateTestAddr("unix")
-	rRPCContext := rpc.NewContext(rclock, tlsConfig)
-	rserver = rpc.NewServer(raddr, rRPCContext)
+	rRPCContext := rpc.NewContext(rclock, tlsConfig, stopper)
+	rserver := rpc.NewServer(raddr, rRPCContext)
 	if err := rserver.Start(); err != nil {
 		t.Fatal(err)
 	}
 	remote = New(rRPCContext)
-	local.start(lserver)
-	remote.start(rserver)
+	local.stopper = stopper
+	remote.stopper = stopper
+	local.server.start(lserver, stopper)
+	remote.server.start(rserver, stopper)
 	time.Sleep(time.Millisecond)
 	return
 }
 
 // TestClientGossip verifies a client can gossip a delta to the server.
 func TestClientGossip(t *testing.T) {
-	local, remote, lserver, rserver := startGossip(t)
+	local, remote, stopper := startGossip(t)
 	local.AddInfo("local-key", "local value", time.Second)
 	remote.AddInfo("remote-key", "remote value", time.Second)
 	disconnected := make(chan *client, 1)
@@ -78,10 +82,7 @@ func TestClientGossip(t *testing.T) {
 		t.Errorf("gossip exchange failed or taking too long")
 	}
 
-	remote.stop()
-	local.stop()
-	lserver.Close()
-	rserver.Close()
+	stopper.Stop()
 	log.Info("done serving")
 	if client != <-disconnected {
 		t.Errorf("expected client disconnect after remote close")
@@ -92,7 +93,8 @@ func TestClientGossip(t *testing.T) {
 // will drop an outgoing client connection that is already an
 // inbound client connection of another node.
 func TestClientDisconnectRedundant(t *testing.T) {
-	local, remote, _, _ := startGossip(t)
+	local, remote, stopper := startGossip(t)
+	defer stopper.Stop()
 	// startClient doesn't lock the underlying gossip
 	// object, so we acquire those locks here.
 	local.mu.Lock()
@@ -103,8 +105,8 @@ func TestClientDisconnectRedundant(t *testing.T) {
 	remote.startClient(lAddr)
 	local.mu.Unlock()
 	remote.mu.Unlock()
-	go local.manage()
-	go remote.manage()
+	stopper.RunWorker(local.manage)
+	stopper.RunWorker(remote.manage)
 	wasConnected1, wasConnected2 := false, false
 	if err := util.IsTrueWithin(func() bool {
 		// Check which of the clients is connected to the other.
@@ -46,5 +46,12 @@ Gossip.RegisterGroup(). Info objects are added to groups if their key
 matches. Info can be queried for single-valued keys via
 Gossip.GetInfo. Sorted values for groups are queried via
 Gossip.GetGroupInfos().
+
+Gossip does not listen on a port of its own. Gossip.Start() registers
+the "Gossip" service with the node's rpc.Server, so gossip exchanges
+share the node's single RPC address along with its TLS configuration
+and client certificate checks. A client's Gossip.Gossip call is held
+by the server until the next gossip interval elapses, so each outgoing
+client streams a sequence of deltas over one ordinary RPC connection.
 */
 package gossip
@@ -116,8 +116,8 @@ type Gossip struct {
 	clientsMu    sync.Mutex         // Mutex protects the clients map
 	clients      map[string]*client // Map from address to client
 	disconnected chan *client       // Channel of disconnected clients
-	exited       chan error         // Channel to signal exit
 	stalled      *sync.Cond         // Indicates bootstrap is required
+	stopper      *util.Stopper      // Stops gossip workers; set on Start()
 	clock        *hlc.Clock         // The server hlc clock.
 }
 
@@ -241,30 +241,28 @@ func (g *Gossip) Outgoing() []net.Addr {
 // bootstrap addresses specified via command-line flag: -gossip.
 //
 // This method starts bootstrap loop, gossip server, and client
-// management in separate goroutines and returns.
-func (g *Gossip) Start(rpcServer *rpc.Server) {
+// management in separate workers and returns. The gossip instance
+// shuts down once all outgoing clients are closed when the stopper
+// is signaled.
+func (g *Gossip) Start(rpcServer *rpc.Server, stopper *util.Stopper) {
+	g.stopper = stopper
 	// Start up asynchronous processors.
-	g.server.start(rpcServer) // serve gossip protocol
-	go g.bootstrap()          // bootstrap gossip client
-	go g.manage()             // manage gossip clients
-	go g.maybeWarnAboutInit()
+	g.server.start(rpcServer, stopper) // serve gossip protocol
+	stopper.RunWorker(g.bootstrap)     // bootstrap gossip client
+	stopper.RunWorker(g.manage)        // manage gossip clients
+	stopper.RunWorker(g.maybeWarnAboutInit)
 }
 
-// Stop shuts down the gossip server. Returns a channel which signals
-// exit once all outgoing clients are closed and the management loop
-// for the gossip instance is finished.
-func (g *Gossip) Stop() <-chan error {
-	// Set server's closed boolean and exit server.
-	g.stop()
-	// Wake up bootstrap goroutine so it can exit.
+// stopClients sets the server's closed boolean, wakes up the
+// bootstrap worker so it can exit and closes all outgoing clients.
+// Requires that g.mu is held.
+func (g *Gossip) stopClients() {
+	g.closed = true
+	g.ready.Broadcast()
 	g.stalled.Signal()
-	// Close all outgoing clients.
-	g.mu.Lock()
 	for _, addr := range g.outgoing.asSlice() {
 		g.closeClient(addr)
 	}
-	g.mu.Unlock()
-	return g.exited
 }
 
 // maxToleratedHops computes the maximum number of hops which the
@@ -331,12 +329,13 @@ func (g *Gossip) filterExtant(addrs *addrSet) *addrSet {
 // receives notifications that gossip network connectivity has been
 // lost and requires re-bootstrapping.
 //
-// This method will block and should be run via goroutine.
+// This method will block and should be run via stopper worker.
 func (g *Gossip) bootstrap() {
 	for {
 		g.mu.Lock()
 		g.parseBootstrapAddresses()
 		if g.closed {
+			g.mu.Unlock()
 			break
 		}
 		// Find list of available bootstrap hosts.
@@ -368,12 +367,21 @@ func (g *Gossip) bootstrap() {
 // clients are processed via the disconnected channel and taken out of
 // the outgoing address set. If there are no longer any outgoing
 // connections or the sentinel gossip is unavailable, the bootstrapper
-// is notified via the stalled conditional variable.
+// is notified via the stalled conditional variable. When the stopper
+// is signaled, all outgoing clients are closed and the method returns
+// once each has disconnected.
 func (g *Gossip) manage() {
 	checkTimeout := time.Tick(g.jitteredGossipInterval())
+	stopper := g.stopper.ShouldStop()
 	// Loop until closed and there are no remaining outgoing connections.
 	for {
 		select {
+		case <-stopper:
+			g.mu.Lock()
+			g.stopClients()
+			// Stop selecting on the closed channel.
+			stopper = nil
+
 		case c := <-g.disconnected:
 			g.mu.Lock()
 			if c.err != nil {
@@ -428,13 +436,11 @@ func (g *Gossip) manage() {
 
 		// The exit condition.
 		if g.closed && g.outgoing.len() == 0 {
+			g.mu.Unlock()
 			break
 		}
 		g.mu.Unlock()
 	}
-
-	// Signal exit.
-	g.exited <- nil
 }
 
 // maybeWarnAboutInit looks for signs indicating a cluster which
@@ -448,13 +454,18 @@ func (g *Gossip) manage() {
 // connected, and whether the node itself is a bootstrap host, but
 // there is still no sentinel gossip.
 func (g *Gossip) maybeWarnAboutInit() {
-	time.Sleep(5 * time.Second)
+	select {
+	case <-time.After(5 * time.Second):
+	case <-g.stopper.ShouldStop():
+		return
+	}
 	retryOptions := util.RetryOptions{
 		Tag:         "check cluster initialization",
 		Backoff:     5 * time.Second,  // first backoff at 5s
 		MaxBackoff:  60 * time.Second, // max backoff is 60s
 		Constant:    2,                // doubles
 		MaxAttempts: 0,                // indefinite retries
+		Stopper:     g.stopper,        // stop no matter what on stopper
 	}
 	util.RetryWithBackoff(retryOptions, func() (util.RetryStatus, error) {
 		g.mu.Lock()
@@ -497,7 +508,9 @@ func (g *Gossip) startClient(addr net.Addr) {
 	g.clientsMu.Lock()
 	g.clients[c.addr.String()] = c
 	g.clientsMu.Unlock()
-	go c.start(g, g.disconnected)
+	g.stopper.RunWorker(func() {
+		c.start(g, g.disconnected)
+	})
 }
 
 // closeClient closes an existing client specified by client's
@@ -25,6 +25,10 @@ const (
 	// The value is a string UUID for the cluster.
 	KeyClusterID = "cluster-id"
 
+	// KeyClusterVersion is the active cluster version. The value is
+	// an int64 proto.ClusterVersion.
+	KeyClusterVersion = "cluster-version"
+
 	// KeyConfigAccounting is the accounting configuration map.
 	KeyConfigAccounting = "accounting"
 
@@ -52,6 +56,20 @@ const (
 	// string address of the node. E.g. node-1bfa: fwd56.sjcb1:24001
 	KeyNodeIDPrefix = "node-"
 
+	// KeyStoreDescriptorPrefix is the key prefix for gossiping store
+	// descriptors by store ID. The actual key is suffixed with a period
+	// and the hexadecimal representation of the store id, so that the
+	// descriptors may be registered as a group, and the value is a
+	// storage.StoreDescriptor struct.
+	KeyStoreDescriptorPrefix = "store"
+
+	// KeyRangeEventPrefix is the key prefix for gossiping recent changes
+	// to range boundaries. The actual key is suffixed with a period and
+	// the hexadecimal representation of the Raft ID of the range created
+	// by the change, so that the events may be registered as a group,
+	// and the value is a storage.RangeEvent struct.
+	KeyRangeEventPrefix = "range-event"
+
 	// KeySentinel is a key for gossip which must not expire or else the
 	// node considers itself partitioned and will retry with bootstrap hosts.
 	KeySentinel = KeyClusterID
@@ -67,3 +85,15 @@ const (
 func MakeNodeIDGossipKey(nodeID int32) string {
 	return KeyNodeIDPrefix + strconv.FormatInt(int64(nodeID), 16)
 }
+
+// MakeStoreDescriptorGossipKey returns the gossip key for the
+// descriptor of the store with the given ID.
+func MakeStoreDescriptorGossipKey(storeID int32) string {
+	return KeyStoreDescriptorPrefix + "." + strconv.FormatInt(int64(storeID), 16)
+}
+
+// MakeRangeEventGossipKey returns the gossip key for the event which
+// created the range with the given Raft ID.
+func MakeRangeEventGossipKey(raftID int64) string {
+	return KeyRangeEventPrefix + "." + strconv.FormatInt(raftID, 16)
+}
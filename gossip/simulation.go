@@ -43,6 +43,7 @@ type SimulationNetwork struct {
 	Addrs          []net.Addr
 	NetworkType    string        // "tcp" or "unix"
 	GossipInterval time.Duration // The length of a round of gossip
+	Stopper        *util.Stopper
 }
 
 // DefaultTestGossipInterval is one possible compressed simulation
@@ -60,7 +61,8 @@ func NewSimulationNetwork(nodeCount int, networkType string,
 
 	tlsConfig := rpc.LoadInsecureTLSConfig()
 	clock := hlc.NewClock(hlc.UnixNano)
-	rpcContext := rpc.NewContext(clock, tlsConfig)
+	stopper := util.NewStopper()
+	rpcContext := rpc.NewContext(clock, tlsConfig, stopper)
 
 	log.Infof("simulating gossip network with %d nodes", nodeCount)
 	servers := make([]*rpc.Server, nodeCount)
@@ -86,7 +88,7 @@ func NewSimulationNetwork(nodeCount int, networkType string,
 		node.Name = fmt.Sprintf("Node%d", i)
 		node.SetBootstrap(bootstrap)
 		node.SetInterval(gossipInterval)
-		node.Start(servers[i])
+		node.Start(servers[i], stopper)
 		// Node 0 gossips node count.
 		if i == 0 {
 			node.AddInfo(KeyNodeCount, int64(nodeCount), time.Hour)
@@ -98,7 +100,9 @@ func NewSimulationNetwork(nodeCount int, networkType string,
 		Nodes:          nodes,
 		Addrs:          addrs,
 		NetworkType:    networkType,
-		GossipInterval: gossipInterval}
+		GossipInterval: gossipInterval,
+		Stopper:        stopper,
+	}
 }
 
 // GetNodeFromAddr returns the simulation node associated
@@ -145,10 +149,7 @@ func (n *SimulationNetwork) SimulateNetwork(
 
 // Stop all servers and gossip nodes.
 func (n *SimulationNetwork) Stop() {
-	for i := 0; i < len(n.Nodes); i++ {
-		n.Nodes[i].Server.Close()
-		n.Nodes[i].Gossip.Stop()
-	}
+	n.Stopper.Stop()
 }
 
 // RunUntilFullyConnected blocks until the gossip network has received gossip
@@ -0,0 +1,294 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+/*
+Package keys renders internal keys as human-readable strings and
+parses them back. System and range-local keys are rendered as a
+slash-separated path naming the key's type followed by its decoded
+components, for example:
+
+	/Meta2/"apple"
+	/Local/RangeMVCCStats/3
+	/Local/ResponseCache/3/1418078437000000000/12345
+	/Local/Transaction/"apple\x8a..."
+
+Ordinary keys are rendered quoted. MVCC-encoded keys are rendered
+with the timestamp of versioned values appended, as in
+"apple"@1418078437000000000,2.
+
+Values are decoded as the protocol buffer message type registered for
+the longest prefix of their key (see RegisterValueType), so that
+system values such as range descriptors and transaction records are
+rendered in text format rather than as opaque bytes.
+*/
+package keys
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// suffixType describes how the portion of a key following its
+// prefix is encoded.
+type suffixType int
+
+const (
+	suffixNone   suffixType = iota // No suffix
+	suffixRaw                      // Arbitrary bytes
+	suffixInt                      // One encoded int
+	suffixIntRaw                   // Encoded int followed by arbitrary bytes
+	suffixIntInt                   // Two encoded ints
+	suffixInt3                     // Three encoded ints
+)
+
+// A keyPrefix associates a key prefix with its printed name and the
+// encoding of the remainder of the key.
+type keyPrefix struct {
+	name   string
+	prefix proto.Key
+	suffix suffixType
+}
+
+// prefixes lists all known key prefixes. Longer prefixes must precede
+// any prefix of which they are an extension.
+var prefixes = []keyPrefix{
+	{"/Local/Ident", engine.KeyLocalIdent, suffixNone},
+	{"/Local/RangeDescriptor", engine.KeyLocalRangeDescriptorPrefix, suffixRaw},
+	{"/Local/RangeGCThreshold", engine.KeyLocalRangeGCThresholdPrefix, suffixInt},
+	{"/Local/RangeLeaderLease", engine.KeyLocalRangeLeaderLeasePrefix, suffixInt},
+	{"/Local/RangeMVCCStats", engine.KeyLocalRangeMVCCStatsPrefix, suffixInt},
+	{"/Local/RangeStat", engine.KeyLocalRangeStatPrefix, suffixIntRaw},
+	{"/Local/ResponseCache", engine.KeyLocalResponseCachePrefix, suffixInt3},
+	{"/Local/ResponseCacheSession", engine.KeyLocalResponseCacheSessionPrefix, suffixIntInt},
+	{"/Local/StoreStat", engine.KeyLocalStoreStatPrefix, suffixIntRaw},
+	{"/Local/Transaction", engine.KeyLocalTransactionPrefix, suffixRaw},
+	{"/Local/SnapshotIDGenerator", engine.KeyLocalSnapshotIDGenerator, suffixNone},
+	{"/Local/StoreVersion", engine.KeyLocalStoreVersion, suffixNone},
+	{"/Local", engine.KeyLocalPrefix, suffixRaw},
+	{"/Meta1", engine.KeyMeta1Prefix, suffixRaw},
+	{"/Meta2", engine.KeyMeta2Prefix, suffixRaw},
+	{"/AcctRecord", engine.KeyAcctRecordPrefix, suffixRaw},
+	{"/AuditLogHead", engine.KeyAuditLogHead, suffixNone},
+	{"/AuditLog", engine.KeyAuditLogPrefix, suffixInt},
+	{"/ClusterVersion", engine.KeyClusterVersion, suffixNone},
+	{"/Config/Accounting", engine.KeyConfigAccountingPrefix, suffixRaw},
+	{"/Config/Permission", engine.KeyConfigPermissionPrefix, suffixRaw},
+	{"/Config/Zone", engine.KeyConfigZonePrefix, suffixRaw},
+	{"/IndexBackfill", engine.KeyIndexBackfillPrefix, suffixIntInt},
+	{"/NodeIDGenerator", engine.KeyNodeIDGenerator, suffixNone},
+	{"/RaftIDGenerator", engine.KeyRaftIDGenerator, suffixNone},
+	{"/RangeIDGenerator", engine.KeyRangeIDGenerator, suffixNone},
+	{"/Schema", engine.KeySchemaPrefix, suffixRaw},
+	{"/StoreIDGenerator", engine.KeyStoreIDGeneratorPrefix, suffixRaw},
+	{"/TableDescriptor", engine.KeyTableDescriptorPrefix, suffixInt},
+	{"/TableIDGenerator", engine.KeyTableIDGenerator, suffixNone},
+	{"/TableLease", engine.KeyTableLeasePrefix, suffixInt},
+	{"/TableName", engine.KeyTableNamePrefix, suffixRaw},
+	{"/System", engine.KeySystemPrefix, suffixRaw},
+}
+
+// PrettyPrint returns a human-readable rendering of key. Keys which
+// cannot be decoded according to their prefix are rendered quoted.
+func PrettyPrint(key proto.Key) string {
+	if len(key) == 0 {
+		return "/Min"
+	}
+	if key.Equal(engine.KeyMax) {
+		return "/Max"
+	}
+	for _, p := range prefixes {
+		if !bytes.HasPrefix(key, p.prefix) {
+			continue
+		}
+		if s, ok := printSuffix(p.suffix, key[len(p.prefix):]); ok {
+			return p.name + s
+		}
+		break
+	}
+	return strconv.Quote(string(key))
+}
+
+// printSuffix renders the suffix of a key according to its type.
+// Returns false if the suffix cannot be decoded.
+func printSuffix(typ suffixType, b []byte) (s string, ok bool) {
+	// The encoding package panics on malformed input.
+	defer func() {
+		if recover() != nil {
+			s, ok = "", false
+		}
+	}()
+	switch typ {
+	case suffixNone:
+		return "", len(b) == 0
+	case suffixRaw:
+		return "/" + strconv.Quote(string(b)), true
+	case suffixIntRaw:
+		b, i := encoding.DecodeInt(b)
+		return fmt.Sprintf("/%d/%s", i, strconv.Quote(string(b))), true
+	case suffixInt:
+		b, i := encoding.DecodeInt(b)
+		return fmt.Sprintf("/%d", i), len(b) == 0
+	case suffixIntInt:
+		b, i1 := encoding.DecodeInt(b)
+		b, i2 := encoding.DecodeInt(b)
+		return fmt.Sprintf("/%d/%d", i1, i2), len(b) == 0
+	case suffixInt3:
+		b, i1 := encoding.DecodeInt(b)
+		b, i2 := encoding.DecodeInt(b)
+		b, i3 := encoding.DecodeInt(b)
+		return fmt.Sprintf("/%d/%d/%d", i1, i2, i3), len(b) == 0
+	}
+	return "", false
+}
+
+// Parse parses a key rendered by PrettyPrint.
+func Parse(s string) (proto.Key, error) {
+	if s == "/Min" {
+		return engine.KeyMin, nil
+	}
+	if s == "/Max" {
+		return engine.KeyMax, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return unquote(s)
+	}
+	for _, p := range prefixes {
+		rest := strings.TrimPrefix(s, p.name)
+		if len(rest) == len(s) || (len(rest) > 0 && rest[0] != '/') {
+			continue
+		}
+		suffix, err := parseSuffix(p.suffix, strings.TrimPrefix(rest, "/"))
+		if err != nil {
+			return nil, util.Errorf("unable to parse key %q: %s", s, err)
+		}
+		return proto.MakeKey(p.prefix, suffix), nil
+	}
+	return nil, util.Errorf("unable to parse key %q: unknown prefix", s)
+}
+
+// parseSuffix parses the rendering of a key suffix of the given type,
+// without its leading slash.
+func parseSuffix(typ suffixType, s string) (proto.Key, error) {
+	switch typ {
+	case suffixNone:
+		if s != "" {
+			return nil, util.Errorf("unexpected suffix %q", s)
+		}
+		return nil, nil
+	case suffixRaw:
+		return unquote(s)
+	case suffixIntRaw:
+		parts := strings.SplitN(s, "/", 2)
+		if len(parts) != 2 {
+			return nil, util.Errorf("expected integer and quoted suffix: %q", s)
+		}
+		i, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := unquote(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return proto.MakeKey(encoding.EncodeInt(nil, i), raw), nil
+	}
+	var count int
+	switch typ {
+	case suffixInt:
+		count = 1
+	case suffixIntInt:
+		count = 2
+	case suffixInt3:
+		count = 3
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != count {
+		return nil, util.Errorf("expected %d integers: %q", count, s)
+	}
+	var b []byte
+	for _, part := range parts {
+		i, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		b = encoding.EncodeInt(b, i)
+	}
+	return proto.Key(b), nil
+}
+
+// unquote parses a quoted key.
+func unquote(s string) (proto.Key, error) {
+	u, err := strconv.Unquote(s)
+	if err != nil {
+		return nil, util.Errorf("invalid quoted key %s: %s", s, err)
+	}
+	return proto.Key(u), nil
+}
+
+// PrettyPrintEncoded returns a human-readable rendering of an MVCC
+// encoded key. The timestamp of versioned values is appended.
+func PrettyPrintEncoded(encKey proto.EncodedKey) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = strconv.Quote(string(encKey))
+		}
+	}()
+	key, ts, isValue := engine.MVCCDecodeKey(encKey)
+	if !isValue {
+		return PrettyPrint(key)
+	}
+	return fmt.Sprintf("%s@%d,%d", PrettyPrint(key), ts.WallTime, ts.Logical)
+}
+
+// ParseEncoded parses an MVCC encoded key rendered by
+// PrettyPrintEncoded.
+func ParseEncoded(s string) (proto.EncodedKey, error) {
+	// Quoted components never contain an unescaped '@', so the last
+	// '@' following the final quote delimits the timestamp.
+	idx := strings.LastIndex(s, "@")
+	if idx == -1 || idx < strings.LastIndex(s, `"`) {
+		key, err := Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		return engine.MVCCEncodeKey(key), nil
+	}
+	key, err := Parse(s[:idx])
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s[idx+1:], ",")
+	if len(parts) != 2 {
+		return nil, util.Errorf("invalid timestamp in key %q", s)
+	}
+	wallTime, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	logical, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return engine.MVCCEncodeVersionKey(key, proto.Timestamp{WallTime: wallTime, Logical: int32(logical)}), nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package keys
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// TestPrettyPrint verifies the rendering of keys and that each
+// rendering parses back to the original key.
+func TestPrettyPrint(t *testing.T) {
+	testCases := []struct {
+		key    proto.Key
+		pretty string
+	}{
+		{engine.KeyMin, "/Min"},
+		{engine.KeyMax, "/Max"},
+		{proto.Key("apple"), `"apple"`},
+		{proto.Key("a/b@c"), `"a/b@c"`},
+		{engine.KeyLocalIdent, "/Local/Ident"},
+		{engine.KeyLocalSnapshotIDGenerator, "/Local/SnapshotIDGenerator"},
+		{engine.KeyLocalStoreVersion, "/Local/StoreVersion"},
+		{engine.MakeKey(engine.KeyLocalRangeDescriptorPrefix, proto.Key("apple")), `/Local/RangeDescriptor/"apple"`},
+		{engine.MakeRangeGCThresholdKey(3), "/Local/RangeGCThreshold/3"},
+		{engine.MakeRangeLeaderLeaseKey(3), "/Local/RangeLeaderLease/3"},
+		{engine.MakeRangeMVCCStatsKey(3), "/Local/RangeMVCCStats/3"},
+		{engine.MakeRangeStatKey(3, engine.StatKeyBytes), `/Local/RangeStat/3/"key-bytes"`},
+		{engine.MakeStoreStatKey(2, engine.StatLiveBytes), `/Local/StoreStat/2/"live-bytes"`},
+		{engine.MakeKey(engine.KeyLocalTransactionPrefix, proto.Key("apple\x00id")), `/Local/Transaction/"apple\x00id"`},
+		{engine.MakeKey(engine.KeyLocalResponseCachePrefix, encoding.EncodeInt(encoding.EncodeInt(encoding.EncodeInt(nil, 3), 1000), -5)),
+			"/Local/ResponseCache/3/1000/-5"},
+		{engine.MakeKey(engine.KeyLocalResponseCacheSessionPrefix, encoding.EncodeInt(encoding.EncodeInt(nil, 3), 7)),
+			"/Local/ResponseCacheSession/3/7"},
+		{engine.MakeKey(engine.KeyLocalPrefix, proto.Key("abcdefg")), `/Local/"abcdefg"`},
+		{engine.MakeKey(engine.KeyMeta1Prefix, proto.Key("apple")), `/Meta1/"apple"`},
+		{engine.MakeKey(engine.KeyMeta2Prefix, proto.Key("apple")), `/Meta2/"apple"`},
+		{engine.KeyMeta1Prefix, `/Meta1/""`},
+		{engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key("db1")), `/Config/Zone/"db1"`},
+		{engine.MakeKey(engine.KeyConfigAccountingPrefix, proto.Key("db1")), `/Config/Accounting/"db1"`},
+		{engine.MakeKey(engine.KeyConfigPermissionPrefix, proto.Key("db1")), `/Config/Permission/"db1"`},
+		{engine.MakeKey(engine.KeyAcctRecordPrefix, proto.Key("app1")), `/AcctRecord/"app1"`},
+		{engine.KeyAuditLogHead, "/AuditLogHead"},
+		{engine.MakeKey(engine.KeyAuditLogPrefix, encoding.EncodeInt(nil, 7)), "/AuditLog/7"},
+		{engine.KeyClusterVersion, "/ClusterVersion"},
+		{engine.MakeKey(engine.KeyIndexBackfillPrefix, encoding.EncodeInt(encoding.EncodeInt(nil, 5), 2)), "/IndexBackfill/5/2"},
+		{engine.KeyNodeIDGenerator, "/NodeIDGenerator"},
+		{engine.KeyRaftIDGenerator, "/RaftIDGenerator"},
+		{engine.KeyRangeIDGenerator, "/RangeIDGenerator"},
+		{engine.MakeKey(engine.KeyStoreIDGeneratorPrefix, proto.Key("1")), `/StoreIDGenerator/"1"`},
+		{engine.MakeKey(engine.KeySchemaPrefix, proto.Key("s")), `/Schema/"s"`},
+		{engine.MakeKey(engine.KeyTableDescriptorPrefix, encoding.EncodeInt(nil, 5)), "/TableDescriptor/5"},
+		{engine.KeyTableIDGenerator, "/TableIDGenerator"},
+		{engine.MakeKey(engine.KeyTableLeasePrefix, encoding.EncodeInt(nil, 5)), "/TableLease/5"},
+		{engine.MakeKey(engine.KeyTableNamePrefix, proto.Key("users")), `/TableName/"users"`},
+		{engine.MakeKey(engine.KeySystemPrefix, proto.Key("foo")), `/System/"foo"`},
+	}
+	for i, test := range testCases {
+		if pretty := PrettyPrint(test.key); pretty != test.pretty {
+			t.Errorf("%d: expected %s; got %s", i, test.pretty, pretty)
+		}
+		key, err := Parse(test.pretty)
+		if err != nil {
+			t.Errorf("%d: unexpected error parsing %s: %s", i, test.pretty, err)
+			continue
+		}
+		if !bytes.Equal(key, test.key) {
+			t.Errorf("%d: expected parsed key %q; got %q", i, test.key, key)
+		}
+	}
+}
+
+// TestPrettyPrintMalformed verifies that keys which cannot be decoded
+// according to their prefix are rendered quoted.
+func TestPrettyPrintMalformed(t *testing.T) {
+	key := engine.MakeKey(engine.KeyLocalResponseCacheSessionPrefix, proto.Key("\xff"))
+	if pretty, expected := PrettyPrint(key), `"\x00\x00\x00rss-\xff"`; pretty != expected {
+		t.Errorf("expected %s; got %s", expected, pretty)
+	}
+}
+
+// TestParseErrors verifies that invalid renderings fail to parse.
+func TestParseErrors(t *testing.T) {
+	testCases := []string{
+		"apple",
+		`"apple`,
+		"/Unknown/\"apple\"",
+		"/Meta2/apple",
+		"/Local/Ident/\"a\"",
+		"/Local/RangeStat/x/\"key-bytes\"",
+		"/Local/RangeStat/3",
+		"/Local/RangeMVCCStats/3/4",
+		"/Local/ResponseCache/3/1000",
+		"/Local/ResponseCacheSession/3/a",
+	}
+	for i, s := range testCases {
+		if key, err := Parse(s); err == nil {
+			t.Errorf("%d: expected error parsing %s; got %q", i, s, key)
+		}
+	}
+}
+
+// TestPrettyPrintEncoded verifies the rendering and parsing of MVCC
+// encoded keys with and without timestamps.
+func TestPrettyPrintEncoded(t *testing.T) {
+	metaKey := engine.MakeKey(engine.KeyMeta2Prefix, proto.Key("a@b"))
+	testCases := []struct {
+		encKey proto.EncodedKey
+		pretty string
+	}{
+		{engine.MVCCEncodeKey(proto.Key("apple")), `"apple"`},
+		{engine.MVCCEncodeVersionKey(proto.Key("apple"), proto.Timestamp{WallTime: 10, Logical: 2}), `"apple"@10,2`},
+		{engine.MVCCEncodeKey(metaKey), `/Meta2/"a@b"`},
+		{engine.MVCCEncodeVersionKey(metaKey, proto.Timestamp{WallTime: 1}), `/Meta2/"a@b"@1,0`},
+	}
+	for i, test := range testCases {
+		if pretty := PrettyPrintEncoded(test.encKey); pretty != test.pretty {
+			t.Errorf("%d: expected %s; got %s", i, test.pretty, pretty)
+		}
+		encKey, err := ParseEncoded(test.pretty)
+		if err != nil {
+			t.Errorf("%d: unexpected error parsing %s: %s", i, test.pretty, err)
+			continue
+		}
+		if !bytes.Equal(encKey, test.encKey) {
+			t.Errorf("%d: expected parsed key %q; got %q", i, test.encKey, encKey)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package keys
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// A ValueType associates a key prefix with the protobuf message type
+// of the values stored under it.
+type ValueType struct {
+	Prefix proto.Key
+	// MVCC is set if values are written via MVCC, in which case the
+	// message is stored in the bytes of each versioned proto.Value.
+	// Otherwise, the message is stored unversioned at the MVCC encoded
+	// key, as are range-local metadata and transaction records.
+	MVCC bool
+	// New returns an empty message of the type.
+	New func() gogoproto.Message
+}
+
+var (
+	valueTypesMu sync.RWMutex
+	valueTypes   []ValueType
+)
+
+func init() {
+	for _, vt := range []ValueType{
+		{engine.KeyLocalIdent, false, func() gogoproto.Message { return &proto.StoreIdent{} }},
+		{engine.KeyLocalRangeDescriptorPrefix, true, func() gogoproto.Message { return &proto.RangeDescriptor{} }},
+		{engine.KeyLocalRangeGCThresholdPrefix, false, func() gogoproto.Message { return &proto.Timestamp{} }},
+		{engine.KeyLocalRangeLeaderLeasePrefix, false, func() gogoproto.Message { return &proto.Lease{} }},
+		{engine.KeyLocalRangeMVCCStatsPrefix, false, func() gogoproto.Message { return &proto.MVCCStats{} }},
+		{engine.KeyLocalRangeTombstonePrefix, false, func() gogoproto.Message { return &proto.RangeTombstone{} }},
+		{engine.KeyLocalResponseCachePrefix, false, func() gogoproto.Message { return &proto.ReadWriteCmdResponse{} }},
+		{engine.KeyLocalResponseCacheSessionPrefix, false, func() gogoproto.Message { return &proto.ResponseCacheSession{} }},
+		{engine.KeyLocalTransactionPrefix, false, func() gogoproto.Message { return &proto.Transaction{} }},
+		{engine.KeyMeta1Prefix, true, func() gogoproto.Message { return &proto.RangeDescriptor{} }},
+		{engine.KeyMeta2Prefix, true, func() gogoproto.Message { return &proto.RangeDescriptor{} }},
+		{engine.KeyAcctRecordPrefix, true, func() gogoproto.Message { return &proto.AcctRecord{} }},
+		{engine.KeyAuditLogHead, true, func() gogoproto.Message { return &proto.AuditEntry{} }},
+		{engine.KeyAuditLogPrefix, true, func() gogoproto.Message { return &proto.AuditEntry{} }},
+		{engine.KeyConfigAccountingPrefix, true, func() gogoproto.Message { return &proto.AcctConfig{} }},
+		{engine.KeyConfigPermissionPrefix, true, func() gogoproto.Message { return &proto.PermConfig{} }},
+		{engine.KeyConfigZonePrefix, true, func() gogoproto.Message { return &proto.ZoneConfig{} }},
+	} {
+		RegisterValueType(vt)
+	}
+}
+
+// RegisterValueType registers the message type of the values stored
+// under a key prefix, replacing any type previously registered for the
+// same prefix. Packages which store messages under their own prefixes
+// register them from init.
+func RegisterValueType(vt ValueType) {
+	valueTypesMu.Lock()
+	defer valueTypesMu.Unlock()
+	for i := range valueTypes {
+		if valueTypes[i].Prefix.Equal(vt.Prefix) {
+			valueTypes[i] = vt
+			return
+		}
+	}
+	valueTypes = append(valueTypes, vt)
+}
+
+// LookupValueType returns the type registered for the longest prefix
+// of key. Returns false if no prefix of key is registered.
+func LookupValueType(key proto.Key) (ValueType, bool) {
+	valueTypesMu.RLock()
+	defer valueTypesMu.RUnlock()
+	var found ValueType
+	ok := false
+	for _, vt := range valueTypes {
+		if bytes.HasPrefix(key, vt.Prefix) && (!ok || len(vt.Prefix) > len(found.Prefix)) {
+			found, ok = vt, true
+		}
+	}
+	return found, ok
+}
+
+// PrettyPrintValue returns a human-readable rendering of the message
+// bytes b stored at key. Bytes which cannot be decoded as the type
+// registered for key, if any, are rendered quoted.
+func PrettyPrintValue(key proto.Key, b []byte) string {
+	if vt, ok := LookupValueType(key); ok {
+		msg := vt.New()
+		if err := gogoproto.Unmarshal(b, msg); err == nil {
+			return gogoproto.CompactTextString(msg)
+		}
+	}
+	return strconv.Quote(string(b))
+}
+
+// PrettyPrintRawValue returns a human-readable rendering of the raw
+// engine value b stored at the MVCC encoded key encKey, decoding
+// versioned values, MVCC metadata and unversioned messages as
+// appropriate for the key.
+func PrettyPrintRawValue(encKey proto.EncodedKey, b []byte) (s string) {
+	// MVCCDecodeKey panics on malformed keys.
+	defer func() {
+		if recover() != nil {
+			s = strconv.Quote(string(b))
+		}
+	}()
+	key, _, isValue := engine.MVCCDecodeKey(encKey)
+	vt, ok := LookupValueType(key)
+	if !isValue {
+		if ok && !vt.MVCC {
+			return PrettyPrintValue(key, b)
+		}
+		meta := &proto.MVCCMetadata{}
+		if err := gogoproto.Unmarshal(b, meta); err != nil {
+			return strconv.Quote(string(b))
+		}
+		return "meta: " + gogoproto.CompactTextString(meta)
+	}
+	var value proto.MVCCValue
+	if err := engine.UnmarshalMVCCValue(b, &value); err != nil {
+		return strconv.Quote(string(b))
+	}
+	switch {
+	case value.Deleted:
+		return "<deleted>"
+	case value.Value == nil:
+		return "<nil>"
+	case value.Value.Integer != nil:
+		return fmt.Sprintf("%d", value.Value.GetInteger())
+	}
+	return PrettyPrintValue(key, value.Value.Bytes)
+}
@@ -0,0 +1,89 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package keys
+
+import (
+	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestPrettyPrintRawValue verifies that raw engine values are decoded
+// according to the type registered for their key.
+func TestPrettyPrintRawValue(t *testing.T) {
+	marshal := func(msg gogoproto.Message) []byte {
+		b, err := gogoproto.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	desc := &proto.RangeDescriptor{RaftID: 1, StartKey: proto.Key("a"), EndKey: proto.Key("z")}
+	ident := &proto.StoreIdent{ClusterID: "cluster", NodeID: 1, StoreID: 2}
+	ts := proto.Timestamp{WallTime: 1}
+	metaKey := engine.MakeKey(engine.KeyMeta2Prefix, proto.Key("z"))
+
+	testCases := []struct {
+		encKey proto.EncodedKey
+		value  []byte
+		expect string
+	}{
+		// A versioned message.
+		{engine.MVCCEncodeVersionKey(metaKey, ts),
+			marshal(&proto.MVCCValue{Value: &proto.Value{Bytes: marshal(desc)}}),
+			gogoproto.CompactTextString(desc)},
+		// The MVCC metadata of a versioned message.
+		{engine.MVCCEncodeKey(metaKey),
+			marshal(&proto.MVCCMetadata{Timestamp: ts}),
+			"meta: " + gogoproto.CompactTextString(&proto.MVCCMetadata{Timestamp: ts})},
+		// An unversioned message.
+		{engine.MVCCEncodeKey(engine.KeyLocalIdent), marshal(ident), gogoproto.CompactTextString(ident)},
+		// Integers, deletions and values of unregistered keys.
+		{engine.MVCCEncodeVersionKey(engine.KeyNodeIDGenerator, ts),
+			marshal(&proto.MVCCValue{Value: &proto.Value{Integer: gogoproto.Int64(5)}}), "5"},
+		{engine.MVCCEncodeVersionKey(metaKey, ts), marshal(&proto.MVCCValue{Deleted: true}), "<deleted>"},
+		{engine.MVCCEncodeVersionKey(proto.Key("apple"), ts),
+			marshal(&proto.MVCCValue{Value: &proto.Value{Bytes: []byte("pie")}}), `"pie"`},
+		// Bytes which don't decode as the registered type.
+		{engine.MVCCEncodeKey(engine.KeyLocalIdent), []byte("\xff"), `"\xff"`},
+	}
+	for i, test := range testCases {
+		if s := PrettyPrintRawValue(test.encKey, test.value); s != test.expect {
+			t.Errorf("%d: expected %s; got %s", i, test.expect, s)
+		}
+	}
+}
+
+// TestRegisterValueType verifies that the type registered for the
+// longest prefix of a key is used.
+func TestRegisterValueType(t *testing.T) {
+	prefix := engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key("test-"))
+	RegisterValueType(ValueType{prefix, true, func() gogoproto.Message { return &proto.Lease{} }})
+	if vt, ok := LookupValueType(engine.MakeKey(prefix, proto.Key("a"))); !ok || !vt.Prefix.Equal(prefix) {
+		t.Errorf("expected the type registered for %q; got %+v", prefix, vt)
+	}
+	if vt, ok := LookupValueType(engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key("a"))); !ok ||
+		!vt.Prefix.Equal(engine.KeyConfigZonePrefix) {
+		t.Errorf("expected the zone config type; got %+v", vt)
+	}
+	if _, ok := LookupValueType(proto.Key("apple")); ok {
+		t.Error("expected no type registered for an ordinary key")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// ConformanceReportPath is the path at which a ConformanceServer
+// serves its ConformanceReport.
+const ConformanceReportPath = "/conformance/report"
+
+// A ConformanceReport summarizes how closely a client's requests
+// followed the key-value protocol described in the package
+// documentation.
+type ConformanceReport struct {
+	// Requests is the number of key-value requests received.
+	Requests int
+	// InjectedFailures is the number of read-write requests which
+	// were failed with a retryable status code.
+	InjectedFailures int
+	// RetriedFailures is the number of injected failures which the
+	// client retried with the same client command ID.
+	RetriedFailures int
+	// Violations describes each departure from the protocol.
+	Violations []string
+}
+
+// A ConformanceServer serves the key-value API like a DBServer, while
+// checking that clients follow the protocol. It's intended as a
+// harness against which non-Go client implementations are tested:
+// run the client's test suite against the server, then fetch the
+// report from ConformanceReportPath and verify that it lists no
+// violations and that every injected failure was retried.
+//
+// Requests are executed as the root user; the conformance server
+// performs no authentication.
+type ConformanceServer struct {
+	db        *DBServer
+	failEvery int
+
+	mu       sync.Mutex
+	report   ConformanceReport
+	injected map[cmdKey]bool // true once retried
+}
+
+// cmdKey identifies a client command ID.
+type cmdKey struct {
+	wallTime, random int64
+}
+
+// NewConformanceServer returns a ConformanceServer executing requests
+// via sender. If failEvery is positive, the first attempt of every
+// failEvery-th read-write request is failed with 503 Service
+// Unavailable, which clients must retry with the same command ID.
+func NewConformanceServer(sender client.KVSender, budget *MemoryBudget, failEvery int) *ConformanceServer {
+	return &ConformanceServer{
+		db:        NewDBServer(sender, budget),
+		failEvery: failEvery,
+		injected:  map[cmdKey]bool{},
+	}
+}
+
+// Report returns a copy of the current conformance report.
+func (s *ConformanceServer) Report() ConformanceReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := s.report
+	report.Violations = append([]string(nil), s.report.Violations...)
+	return report
+}
+
+// violation records a departure from the protocol. The server lock
+// must be held.
+func (s *ConformanceServer) violation(format string, args ...interface{}) {
+	s.report.Violations = append(s.report.Violations, fmt.Sprintf(format, args...))
+}
+
+// ServeHTTP serves the conformance report at ConformanceReportPath
+// and otherwise validates key-value requests before passing them
+// to the underlying DBServer.
+func (s *ConformanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == ConformanceReportPath {
+		body, err := json.Marshal(s.Report())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(util.ContentTypeHeader, util.JSONContentType)
+		w.Write(body)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, DBPrefix)
+	if !strings.HasPrefix(r.URL.Path, DBPrefix) || !proto.IsPublic(method) {
+		s.mu.Lock()
+		s.violation("request to unknown endpoint %q", r.URL.Path)
+		s.mu.Unlock()
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	args, _, err := proto.CreateArgsAndReply(method)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unmarshalErr := util.UnmarshalRequest(r, reqBody, args, allowedEncodings)
+
+	s.mu.Lock()
+	s.report.Requests++
+	if r.Method != "POST" {
+		s.violation("%s %s: requests must be sent via POST", r.Method, method)
+	}
+	if unmarshalErr != nil {
+		s.violation("%s: unable to decode request of type %q: %s", method, r.Header.Get(util.ContentTypeHeader), unmarshalErr)
+	}
+	cmdID := args.Header().CmdID
+	key := cmdKey{cmdID.WallTime, cmdID.Random}
+	if unmarshalErr == nil && proto.IsReadWrite(method) {
+		if cmdID.IsEmpty() {
+			s.violation("%s: read-write requests must carry a client command ID", method)
+		} else if retried, ok := s.injected[key]; ok {
+			if !retried {
+				s.injected[key] = true
+				s.report.RetriedFailures++
+			}
+		} else if s.failEvery > 0 && s.report.Requests%s.failEvery == 0 {
+			s.injected[key] = false
+			s.report.InjectedFailures++
+			s.mu.Unlock()
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	r.Header.Set(util.UserHeader, storage.UserRoot)
+	s.db.ServeHTTP(w, r)
+}
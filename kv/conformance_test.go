@@ -0,0 +1,120 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/server"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// startConformanceServer starts a ConformanceServer backed by a
+// bootstrapped in-memory store, failing every failEvery-th
+// read-write request.
+func startConformanceServer(t *testing.T, failEvery int) (*httptest.Server, *kv.ConformanceServer) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	db, err := server.BootstrapCluster("test-cluster", e, util.NewStopper())
+	if err != nil {
+		t.Fatalf("could not bootstrap test cluster: %s", err)
+	}
+	cs := kv.NewConformanceServer(db.Sender(), kv.NewMemoryBudget(0), failEvery)
+	return httptest.NewServer(cs), cs
+}
+
+// TestConformanceServerRetries verifies that the Go client conforms
+// to the key-value protocol, retrying each injected failure.
+func TestConformanceServerRetries(t *testing.T) {
+	defer func(opts util.RetryOptions) { client.HTTPRetryOptions = opts }(client.HTTPRetryOptions)
+	client.HTTPRetryOptions.Backoff = 1 * time.Millisecond
+
+	s, _ := startConformanceServer(t, 3)
+	defer s.Close()
+	kvClient := createTestClient(s.Listener.Addr().String())
+
+	for i := 0; i < 10; i++ {
+		key := proto.Key(fmt.Sprintf("key-%d", i))
+		if err := kvClient.Call(proto.Put, proto.PutArgs(key, []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+		getResp := &proto.GetResponse{}
+		if err := kvClient.Call(proto.Get, proto.GetArgs(key), getResp); err != nil {
+			t.Fatal(err)
+		}
+		if getResp.Value == nil || !bytes.Equal(getResp.Value.Bytes, []byte("value")) {
+			t.Errorf("%d: expected value \"value\"; got %+v", i, getResp.Value)
+		}
+	}
+
+	resp, err := http.Get(s.URL + kv.ConformanceReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report kv.ConformanceReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Violations) != 0 {
+		t.Errorf("unexpected violations: %v", report.Violations)
+	}
+	if report.InjectedFailures == 0 || report.RetriedFailures != report.InjectedFailures {
+		t.Errorf("expected all injected failures to be retried; got %+v", report)
+	}
+}
+
+// TestConformanceServerViolations verifies that departures from the
+// protocol are reported.
+func TestConformanceServerViolations(t *testing.T) {
+	s, cs := startConformanceServer(t, 0)
+	defer s.Close()
+
+	// A put without a client command ID.
+	body, err := gogoproto.Marshal(proto.PutArgs(proto.Key("a"), []byte("value")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(s.URL+kv.DBPrefix+proto.Put, util.ProtoContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// A request for an unknown method.
+	if resp, err = http.Post(s.URL+kv.DBPrefix+"Foo", util.ProtoContentType, nil); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d; got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	if report := cs.Report(); len(report.Violations) != 2 {
+		t.Errorf("expected 2 violations; got %v", report.Violations)
+	}
+}
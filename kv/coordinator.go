@@ -101,8 +101,9 @@ func (tm *txnMetadata) addKeyRange(start, end proto.Key) {
 
 // close sends resolve intent commands for all key ranges this
 // transaction has covered, clears the keys cache and closes the
-// metadata heartbeat.
-func (tm *txnMetadata) close(txn *proto.Transaction, sender client.KVSender) {
+// metadata heartbeat. Resolve intent commands are sent as async
+// stopper tasks and are skipped if the stopper is draining.
+func (tm *txnMetadata) close(txn *proto.Transaction, sender client.KVSender, stopper *util.Stopper) {
 	if tm.keys.Len() > 0 {
 		log.V(1).Infof("cleaning up intents for transaction %s", txn)
 	}
@@ -127,13 +128,13 @@ func (tm *txnMetadata) close(txn *proto.Transaction, sender client.KVSender) {
 		}
 		// We don't care about the reply channel; these are best
 		// effort. We simply fire and forget, each in its own goroutine.
-		go func() {
+		stopper.RunAsyncTask(func() {
 			log.V(1).Infof("cleaning up intent %q for txn %s", call.Args.Header().Key, txn)
 			sender.Send(call)
 			if call.Reply.Header().Error != nil {
 				log.Warningf("failed to cleanup %q intent: %s", call.Args.Header().Key, call.Reply.Header().GoError())
 			}
-		}()
+		})
 	}
 	tm.keys.Clear()
 	close(tm.closer)
@@ -153,19 +154,22 @@ type Coordinator struct {
 	clock             *hlc.Clock
 	heartbeatInterval time.Duration
 	clientTimeout     time.Duration
+	stopper           *util.Stopper
 	sync.Mutex                                // Protects the txns map.
 	txns              map[string]*txnMetadata // txn key to metadata
 }
 
 // NewCoordinator creates a new Coordinator for use from a KV
-// distributed DB instance. Coordinators should be closed when no
+// distributed DB instance. Transaction heartbeats are run as workers
+// of the supplied stopper. Coordinators should be closed when no
 // longer in use via Close().
-func NewCoordinator(wrapped client.KVSender, clock *hlc.Clock) *Coordinator {
+func NewCoordinator(wrapped client.KVSender, clock *hlc.Clock, stopper *util.Stopper) *Coordinator {
 	tc := &Coordinator{
 		wrapped:           wrapped,
 		clock:             clock,
 		heartbeatInterval: storage.DefaultHeartbeatInterval,
 		clientTimeout:     defaultClientTimeout,
+		stopper:           stopper,
 		txns:              map[string]*txnMetadata{},
 	}
 	return tc
@@ -208,11 +212,20 @@ func (tc *Coordinator) Send(call *client.Call) {
 			// for each active transaction. Spencer suggests a heap
 			// containing next heartbeat timeouts which is processed by a
 			// single goroutine.
-			go tc.heartbeat(header.Txn, txnMeta.closer)
+			tc.stopper.RunWorker(func() {
+				tc.heartbeat(header.Txn, txnMeta.closer)
+			})
 		}
 		txnMeta.lastUpdateTS = tc.clock.Now()
 	}
 
+	// Supply the transaction's intents when ending it, so that the
+	// range holding the txn record resolves them in bulk instead of
+	// this Coordinator resolving each separately.
+	if call.Method == proto.EndTransaction && header.Txn != nil {
+		call.Args.(*proto.EndTransactionRequest).Intents = tc.txnIntents(header.Txn.ID)
+	}
+
 	// Send the call on to the wrapped sender.
 	tc.wrapped.Send(call)
 
@@ -232,7 +245,14 @@ func (tc *Coordinator) Send(call *client.Call) {
 	case nil:
 		var txn *proto.Transaction
 		if call.Method == proto.EndTransaction {
-			txn = call.Reply.(*proto.EndTransactionResponse).Txn
+			etReply := call.Reply.(*proto.EndTransactionResponse)
+			txn = etReply.Txn
+			// Intents resolved with the commit, or dispatched for
+			// resolution by the txn record's range, needn't be resolved
+			// again on cleanup.
+			if etReply.Resolved && txn != nil {
+				tc.clearIntents(txn.ID)
+			}
 		} else if call.Method == proto.InternalEndTxn {
 			txn = call.Reply.(*proto.InternalEndTxnResponse).Txn
 		}
@@ -266,6 +286,37 @@ func (tc *Coordinator) beginTxn(args *proto.BeginTransactionRequest, reply *prot
 	reply.Txn = txn
 }
 
+// txnIntents returns the intents written by the transaction with the
+// given ID through this Coordinator.
+func (tc *Coordinator) txnIntents(txnID proto.Key) []proto.Intent {
+	tc.Lock()
+	defer tc.Unlock()
+	txnMeta, ok := tc.txns[string(txnID)]
+	if !ok {
+		return nil
+	}
+	var intents []proto.Intent
+	for _, o := range txnMeta.keys.GetOverlaps(engine.KeyMin, engine.KeyMax) {
+		intent := proto.Intent{Key: o.Key.Start().(proto.Key)}
+		// As in txnMetadata.close, single keys are stored as [key, key.Next()).
+		if endKey := o.Key.End().(proto.Key); !intent.Key.Next().Equal(endKey) {
+			intent.EndKey = endKey
+		}
+		intents = append(intents, intent)
+	}
+	return intents
+}
+
+// clearIntents forgets the intents of the transaction with the given
+// ID, which have been resolved.
+func (tc *Coordinator) clearIntents(txnID proto.Key) {
+	tc.Lock()
+	defer tc.Unlock()
+	if txnMeta, ok := tc.txns[string(txnID)]; ok {
+		txnMeta.keys.Clear()
+	}
+}
+
 // cleanupTxn is called to resolve write intents which were set down over
 // the course of the transaction. The txnMetadata object is removed from
 // the txns map.
@@ -276,7 +327,7 @@ func (tc *Coordinator) cleanupTxn(txn *proto.Transaction) {
 	if !ok {
 		return
 	}
-	txnMeta.close(txn, tc.wrapped)
+	txnMeta.close(txn, tc.wrapped, tc.stopper)
 	delete(tc.txns, string(txn.ID))
 }
 
@@ -302,7 +353,8 @@ func (tc *Coordinator) hasClientAbandonedCoord(txnID proto.Key) bool {
 
 // heartbeat periodically sends an InternalHeartbeatTxn RPC to an
 // extant transaction, stopping in the event the transaction is
-// aborted or committed or if the Coordinator is closed.
+// aborted or committed, if the Coordinator is closed or if the
+// stopper is signaled.
 func (tc *Coordinator) heartbeat(txn *proto.Transaction, closer chan struct{}) {
 	ticker := time.NewTicker(tc.heartbeatInterval)
 	request := &proto.InternalHeartbeatTxnRequest{
@@ -342,6 +394,8 @@ func (tc *Coordinator) heartbeat(txn *proto.Transaction, closer chan struct{}) {
 			}
 		case <-closer:
 			return
+		case <-tc.stopper.ShouldStop():
+			return
 		}
 	}
 }
@@ -35,19 +35,21 @@ import (
 
 // createTestDB creates a *client.KV using a LocalSender object built
 // with a store using an in-memory engine. Returns the created kv
-// client and associated clock's manual time.
+// client and associated clock's manual time. The caller is
+// responsible for stopping the returned stopper.
 // TODO(spencer): return a struct.
-func createTestDB(t *testing.T) (*client.KV, engine.Engine, *hlc.Clock, *hlc.ManualClock, *LocalSender) {
-	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig())
+func createTestDB(t *testing.T) (*client.KV, engine.Engine, *hlc.Clock, *hlc.ManualClock, *LocalSender, *util.Stopper) {
+	stopper := util.NewStopper()
+	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig(), stopper)
 	g := gossip.New(rpcContext)
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	eng := engine.NewInMem(proto.Attributes{}, 50<<20)
 	lSender := NewLocalSender()
-	sender := NewCoordinator(lSender, clock)
-	db := client.NewKV(sender, nil)
+	sender := NewCoordinator(lSender, clock, stopper)
+	db := client.NewKV(sender, manual)
 	db.User = storage.UserRoot
-	store := storage.NewStore(clock, eng, db, g)
+	store := storage.NewStore(clock, eng, db, g, stopper)
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -58,7 +60,7 @@ func createTestDB(t *testing.T) (*client.KV, engine.Engine, *hlc.Clock, *hlc.Man
 	if err := store.Init(); err != nil {
 		t.Fatal(err)
 	}
-	return db, eng, clock, &manual, lSender
+	return db, eng, clock, manual, lSender, stopper
 }
 
 // getCoord type casts the db's sender to a coordinator and returns it.
@@ -88,7 +90,8 @@ func createPutRequest(key proto.Key, value []byte, txn *proto.Transaction) *prot
 // transaction metadata and adding multiple requests with same
 // transaction ID updates the last update timestamp.
 func TestCoordinatorAddRequest(t *testing.T) {
-	db, _, clock, manual, _ := createTestDB(t)
+	db, _, clock, manual, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	coord := getCoord(db)
 	defer db.Close()
 
@@ -111,7 +114,7 @@ func TestCoordinatorAddRequest(t *testing.T) {
 	// Advance time and send another put request. Lock the coordinator
 	// to prevent a data race.
 	coord.Lock()
-	*manual = hlc.ManualClock(1)
+	manual.Set(1)
 	coord.Unlock()
 	if err := db.Call(proto.Put, putReq, &proto.PutResponse{}); err != nil {
 		t.Fatal(err)
@@ -120,13 +123,14 @@ func TestCoordinatorAddRequest(t *testing.T) {
 		t.Errorf("expected length of transactions map to be 1; got %d", len(coord.txns))
 	}
 	txnMeta = coord.txns[string(txn.ID)]
-	if !ts.Less(txnMeta.lastUpdateTS) || txnMeta.lastUpdateTS.WallTime != int64(*manual) {
+	if !ts.Less(txnMeta.lastUpdateTS) || txnMeta.lastUpdateTS.WallTime != manual.UnixNano() {
 		t.Errorf("expected last update time to advance; got %+v", txnMeta.lastUpdateTS)
 	}
 }
 
 func TestCoordinatorBeginTransaction(t *testing.T) {
-	db, _, _, _, _ := createTestDB(t)
+	db, _, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	defer db.Close()
 
 	reply := &proto.BeginTransactionResponse{}
@@ -176,7 +180,8 @@ func TestCoordinatorKeyRanges(t *testing.T) {
 		{proto.Key("b"), proto.Key("c")},
 	}
 
-	db, _, clock, _, _ := createTestDB(t)
+	db, _, clock, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	coord := getCoord(db)
 	defer db.Close()
 	txn := newTxn(db, clock, proto.Key("a"))
@@ -205,7 +210,8 @@ func TestCoordinatorKeyRanges(t *testing.T) {
 // TestCoordinatorMultipleTxns verifies correct operation with
 // multiple outstanding transactions.
 func TestCoordinatorMultipleTxns(t *testing.T) {
-	db, _, clock, _, _ := createTestDB(t)
+	db, _, clock, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	coord := getCoord(db)
 	defer db.Close()
 
@@ -226,7 +232,8 @@ func TestCoordinatorMultipleTxns(t *testing.T) {
 // TestCoordinatorHeartbeat verifies periodic heartbeat of the
 // transaction record.
 func TestCoordinatorHeartbeat(t *testing.T) {
-	db, _, clock, manual, _ := createTestDB(t)
+	db, _, clock, manual, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	coord := getCoord(db)
 	defer db.Close()
 
@@ -249,7 +256,7 @@ func TestCoordinatorHeartbeat(t *testing.T) {
 			// Advance clock by 1ns.
 			// Locking the Coordinator to prevent a data race.
 			coord.Lock()
-			*manual = hlc.ManualClock(*manual + 1)
+			manual.Increment(1)
 			coord.Unlock()
 			if heartbeatTS.Less(*txn.LastHeartbeat) {
 				heartbeatTS = *txn.LastHeartbeat
@@ -297,7 +304,8 @@ func verifyCleanup(key proto.Key, db *client.KV, eng engine.Engine, t *testing.T
 // sends resolve write intent requests and removes the transaction
 // from the txns map.
 func TestCoordinatorEndTxn(t *testing.T) {
-	db, eng, clock, _, _ := createTestDB(t)
+	db, eng, clock, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	defer db.Close()
 
 	txn := newTxn(db, clock, proto.Key("a"))
@@ -328,10 +336,53 @@ func TestCoordinatorEndTxn(t *testing.T) {
 	verifyCleanup(key, db, eng, t)
 }
 
+// TestCoordinatorEndTxnLocalIntents verifies that the coordinator
+// supplies the txn's intents with EndTransaction, so that when they're
+// all on the txn record's range they're resolved with the commit.
+func TestCoordinatorEndTxnLocalIntents(t *testing.T) {
+	db, eng, clock, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
+	defer db.Close()
+
+	txn := newTxn(db, clock, proto.Key("a"))
+	for _, key := range []proto.Key{proto.Key("a"), proto.Key("b")} {
+		if err := db.Call(proto.Put, createPutRequest(key, []byte("value"), txn), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	etReply := &proto.EndTransactionResponse{}
+	db.Sender().Send(&client.Call{
+		Method: proto.EndTransaction,
+		Args: &proto.EndTransactionRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       txn.ID,
+				Timestamp: txn.Timestamp,
+				Txn:       txn,
+			},
+			Commit: true,
+		},
+		Reply: etReply,
+	})
+	if etReply.Error != nil {
+		t.Fatal(etReply.GoError())
+	}
+	if !etReply.Resolved {
+		t.Error("expected intents to be resolved with the commit")
+	}
+	// No intents remain, without waiting on asynchronous resolution.
+	if err := engine.MVCCIterateIntents(eng, engine.KeyMin, engine.KeyMax, func(key proto.Key, _ *proto.MVCCMetadata) (bool, error) {
+		t.Errorf("unexpected intent at %q", key)
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestCoordinatorCleanupOnAborted verifies that if a txn receives a
 // TransactionAbortedError, the coordinator cleans up the transaction.
 func TestCoordinatorCleanupOnAborted(t *testing.T) {
-	db, eng, clock, _, _ := createTestDB(t)
+	db, eng, clock, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	defer db.Close()
 
 	// Create a transaction with intent at "a".
@@ -382,7 +433,8 @@ func TestCoordinatorCleanupOnAborted(t *testing.T) {
 // TestCoordinatorGC verifies that the coordinator cleans up extant
 // transactions after the lastUpdateTS exceeds the timeout.
 func TestCoordinatorGC(t *testing.T) {
-	db, _, clock, manual, _ := createTestDB(t)
+	db, _, clock, manual, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	coord := getCoord(db)
 	defer db.Close()
 
@@ -397,7 +449,7 @@ func TestCoordinatorGC(t *testing.T) {
 	// Now, advance clock past the default client timeout.
 	// Locking the Coordinator to prevent a data race.
 	coord.Lock()
-	*manual = hlc.ManualClock(defaultClientTimeout.Nanoseconds() + 1)
+	manual.Set(defaultClientTimeout.Nanoseconds() + 1)
 	coord.Unlock()
 
 	if err := util.IsTrueWithin(func() bool {
@@ -18,32 +18,44 @@
 package kv
 
 import (
+	"encoding/binary"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
 )
 
 const (
 	// DBPrefix is the prefix for the key-value database endpoint used
 	// to interact with the key-value datastore via HTTP RPC.
 	DBPrefix = client.KVDBEndpoint
+	// DBStreamParam is the query parameter with which clients request
+	// that the results of a scan be streamed. Its value is the maximum
+	// number of rows in each streamed chunk.
+	DBStreamParam = "stream"
 )
 
 var allowedEncodings = []util.EncodingType{util.JSONEncoding, util.ProtoEncoding}
 
 // A DBServer provides an HTTP server endpoint serving the key-value API.
-// It accepts either JSON or serialized protobuf content types.
+// It accepts either JSON or serialized protobuf content types. The
+// bytes buffered for each request and its reply are reserved against
+// a memory budget shared by all requests served by the node.
 type DBServer struct {
 	sender client.KVSender
+	budget *MemoryBudget
 }
 
-// NewDBServer allocates and returns a new DBServer.
-func NewDBServer(sender client.KVSender) *DBServer {
-	return &DBServer{sender: sender}
+// NewDBServer allocates and returns a new DBServer which reserves
+// memory for requests in flight against the supplied budget.
+func NewDBServer(sender client.KVSender, budget *MemoryBudget) *DBServer {
+	return &DBServer{sender: sender, budget: budget}
 }
 
 // ServeHTTP serves the key-value API by treating the request URL path
@@ -53,7 +65,11 @@ func NewDBServer(sender client.KVSender) *DBServer {
 // and JSON-encoded requests are supported. The response body is
 // encoded according the the request's Accept header, or if not
 // present, in the same format as the request's incoming Content-Type
-// header.
+// header. The results of scans are streamed in chunks if requested
+// via the DBStreamParam query parameter; see serveScanStream.
+// Requests are executed on behalf of the user authenticated
+// by the server, as specified by the util.UserHeader request header;
+// unauthenticated requests are rejected.
 func (s *DBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	method := r.URL.Path
 	if !strings.HasPrefix(method, DBPrefix) {
@@ -65,6 +81,11 @@ func (s *DBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
+	user := r.Header.Get(util.UserHeader)
+	if user == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
 
 	// Unmarshal the request.
 	reqBody, err := ioutil.ReadAll(r.Body)
@@ -82,16 +103,50 @@ func (s *DBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// Execute the request on behalf of the authenticated user,
+	// regardless of the user specified by the client.
+	args.Header().User = user
+	// Scans may request that their results be streamed.
+	var streamChunkSize int64
+	if v := r.URL.Query().Get(DBStreamParam); v != "" && method == proto.Scan {
+		if streamChunkSize, err = strconv.ParseInt(v, 10, 64); err != nil || streamChunkSize <= 0 {
+			http.Error(w, "stream chunk size must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Create a call and invoke through sender.
-	call := &client.Call{
-		Method: method,
-		Args:   args,
-		Reply:  reply,
+	// Reserve memory for the buffered request, and once a read-only
+	// request has been executed, for its reply. If either reservation
+	// fails, the client receives a reply carrying only the budget
+	// error. The replies of mutating requests are not subject to the
+	// budget, as failing them would obscure the mutation's outcome.
+	acct := s.budget.NewAccount(args.Header().Txn)
+	defer acct.Close()
+	if err := acct.Grow(int64(len(reqBody))); err != nil {
+		reply.Header().SetGoError(err)
+	} else if streamChunkSize > 0 {
+		s.serveScanStream(w, r, args.(*proto.ScanRequest), streamChunkSize)
+		return
+	} else {
+		// Create a call and invoke through sender.
+		call := &client.Call{
+			Method: method,
+			Args:   args,
+			Reply:  reply,
+		}
+		s.sender.Send(call)
+		if proto.IsReadOnly(method) {
+			if err := acct.Grow(int64(gogoproto.Size(reply))); err != nil {
+				_, reply, _ = proto.CreateArgsAndReply(method)
+				reply.Header().SetGoError(err)
+			}
+		}
 	}
-	s.sender.Send(call)
 
-	// Marshal the response.
+	// Marshal the response, compressing scanned keys if requested.
+	if scanArgs, ok := args.(*proto.ScanRequest); ok && scanArgs.CompressKeys {
+		reply.(*proto.ScanResponse).CompressKeys()
+	}
 	body, contentType, err := util.MarshalResponse(r, reply, allowedEncodings)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -100,3 +155,83 @@ func (s *DBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", contentType)
 	w.Write(body)
 }
+
+// serveScanStream executes the scan in chunks of at most chunkSize
+// rows and streams each chunk to the client as a ScanResponse as
+// soon as it has been read, so that large scans are served without
+// buffering their complete results. Chunks are encoded according to
+// the request's Accept header, as for replies to other requests.
+// Protobuf-encoded chunks are each preceded by their length as a
+// varint; JSON-encoded chunks are each followed by a newline. The
+// stream ends after a chunk which carries an error or holds fewer
+// than chunkSize rows.
+//
+// All chunks of a non-transactional scan are read at the timestamp
+// of the first chunk, yielding a consistent snapshot.
+func (s *DBServer) serveScanStream(w http.ResponseWriter, r *http.Request, args *proto.ScanRequest, chunkSize int64) {
+	flusher, _ := w.(http.Flusher)
+	maxResults := args.MaxResults
+	var count int64
+	for first := true; ; first = false {
+		chunkArgs := *args
+		chunkArgs.MaxResults = chunkSize
+		if maxResults > 0 && maxResults-count < chunkSize {
+			chunkArgs.MaxResults = maxResults - count
+		}
+		reply := &proto.ScanResponse{}
+		s.sender.Send(&client.Call{
+			Method: proto.Scan,
+			Args:   &chunkArgs,
+			Reply:  reply,
+		})
+		// Each chunk is reserved against the budget only while it's
+		// being written.
+		acct := s.budget.NewAccount(args.Txn)
+		if err := acct.Grow(int64(gogoproto.Size(reply))); err != nil {
+			reply = &proto.ScanResponse{}
+			reply.SetGoError(err)
+		}
+		rows := int64(len(reply.Rows))
+		var nextKey proto.Key
+		if rows > 0 {
+			nextKey = reply.Rows[rows-1].Key.Next()
+		}
+		if args.CompressKeys {
+			reply.CompressKeys()
+		}
+		body, contentType, err := util.MarshalResponse(r, reply, allowedEncodings)
+		if err != nil {
+			acct.Close()
+			if first {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			} else {
+				log.Errorf("unable to marshal scan stream chunk: %s", err)
+			}
+			return
+		}
+		if first {
+			w.Header().Set(util.ContentTypeHeader, contentType)
+		}
+		if contentType == util.ProtoContentType {
+			var lenBuf [binary.MaxVarintLen64]byte
+			w.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(body)))])
+			w.Write(body)
+		} else {
+			w.Write(append(body, '\n'))
+		}
+		acct.Close()
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		count += rows
+		if reply.Error != nil || rows < chunkArgs.MaxResults || (maxResults > 0 && count >= maxResults) {
+			return
+		}
+		// Continue after the last row read, at the same timestamp.
+		args.Key = nextKey
+		if args.Txn == nil {
+			args.Timestamp = reply.Timestamp
+		}
+	}
+}
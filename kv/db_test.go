@@ -18,9 +18,16 @@
 package kv_test
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
@@ -28,6 +35,7 @@ import (
 	"github.com/cockroachdb/cockroach/kv"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/util"
 	yaml "gopkg.in/yaml.v1"
 )
@@ -186,6 +194,51 @@ func TestKVDBInternalMethods(t *testing.T) {
 	}
 }
 
+// TestKVDBMemoryBudget verifies that requests whose bodies or
+// read-only replies exceed the gateway's memory budget fail with a
+// MemoryBudgetExceededError, and that the budget is released once
+// each request completes.
+func TestKVDBMemoryBudget(t *testing.T) {
+	budget := kv.NewMemoryBudget(1024)
+	addr, server, db := startServerWithBudget(t, budget)
+	defer server.Close()
+	kvClient := createTestClient(addr)
+
+	// A put carrying a value larger than the budget is rejected.
+	largeValue := bytes.Repeat([]byte("x"), 2048)
+	putResp := &proto.PutResponse{}
+	err := kvClient.Call(proto.Put, proto.PutArgs(proto.Key("a"), largeValue), putResp)
+	if _, ok := err.(*proto.MemoryBudgetExceededError); !ok {
+		t.Fatalf("expected memory budget exceeded error; got %v", err)
+	}
+
+	// Write the large value directly and verify that reading it
+	// through the gateway is rejected, while small reads succeed.
+	if err := db.Call(proto.Put, proto.PutArgs(proto.Key("a"), largeValue), putResp); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvClient.Call(proto.Put, proto.PutArgs(proto.Key("b"), []byte("value")), putResp); err != nil {
+		t.Fatal(err)
+	}
+	getResp := &proto.GetResponse{}
+	err = kvClient.Call(proto.Get, proto.GetArgs(proto.Key("a")), getResp)
+	if _, ok := err.(*proto.MemoryBudgetExceededError); !ok {
+		t.Fatalf("expected memory budget exceeded error; got %v", err)
+	}
+	if getResp.Value != nil {
+		t.Errorf("expected no value in rejected reply; got %+v", getResp.Value)
+	}
+	if err := kvClient.Call(proto.Get, proto.GetArgs(proto.Key("b")), getResp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(getResp.Value.Bytes, []byte("value")) {
+		t.Errorf("expected value %q; got %q", "value", getResp.Value.Bytes)
+	}
+	if used := budget.Used(); used != 0 {
+		t.Errorf("expected budget to be released; %d bytes in use", used)
+	}
+}
+
 // TestKVDBContentTypes verifies all combinations of request /
 // response content encodings are supported.
 func TestKVDBContentType(t *testing.T) {
@@ -257,6 +310,249 @@ func TestKVDBContentType(t *testing.T) {
 	}
 }
 
+// userRecordingSender records the user of each call it sends.
+type userRecordingSender struct {
+	users []string
+}
+
+func (s *userRecordingSender) Send(call *client.Call) {
+	s.users = append(s.users, call.Args.Header().User)
+}
+
+func (s *userRecordingSender) Close() {}
+
+// TestKVDBAuthenticatedUser verifies that the KV DB endpoint rejects
+// unauthenticated requests and executes requests on behalf of the
+// authenticated user, ignoring the user specified by the client.
+func TestKVDBAuthenticatedUser(t *testing.T) {
+	sender := &userRecordingSender{}
+	dbServer := kv.NewDBServer(sender, kv.NewMemoryBudget(0))
+	body, err := gogoproto.Marshal(&proto.GetRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:  proto.Key("a"),
+			User: storage.UserRoot,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, user := range []string{"", "alice"} {
+		httpReq, err := http.NewRequest("POST", kv.DBPrefix+proto.Get, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Header.Set(util.ContentTypeHeader, util.ProtoContentType)
+		if user != "" {
+			httpReq.Header.Set(util.UserHeader, user)
+		}
+		w := httptest.NewRecorder()
+		dbServer.ServeHTTP(w, httpReq)
+		if user == "" {
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%d: expected unauthorized status; got %d", i, w.Code)
+			}
+		} else if w.Code != http.StatusOK {
+			t.Errorf("%d: expected status ok; got %d", i, w.Code)
+		}
+	}
+	if !reflect.DeepEqual(sender.users, []string{"alice"}) {
+		t.Errorf("expected a single call by user alice; got %q", sender.users)
+	}
+}
+
+// TestKVDBScanStream verifies that scan results are streamed in
+// chunks using either protobuf or JSON encoding.
+func TestKVDBScanStream(t *testing.T) {
+	addr, server, db := startServer(t)
+	defer server.Close()
+
+	for i := 0; i < 25; i++ {
+		key := proto.Key(fmt.Sprintf("key-%02d", i))
+		if err := db.Call(proto.Put, proto.PutArgs(key, []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		contentType string
+		maxResults  int64
+		expChunks   []int
+	}{
+		{util.ProtoContentType, 0, []int{10, 10, 5}},
+		{util.JSONContentType, 0, []int{10, 10, 5}},
+		{util.ProtoContentType, 15, []int{10, 5}},
+		{util.JSONContentType, 20, []int{10, 10}},
+	}
+	for i, test := range testCases {
+		scanReq := &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    proto.Key("key-"),
+				EndKey: proto.Key("key-").PrefixEnd(),
+			},
+			MaxResults: test.maxResults,
+		}
+		var body []byte
+		var err error
+		if test.contentType == util.ProtoContentType {
+			body, err = gogoproto.Marshal(scanReq)
+		} else {
+			body, err = json.Marshal(scanReq)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := fmt.Sprintf("http://%s%s%s?%s=10", addr, kv.DBPrefix, proto.Scan, kv.DBStreamParam)
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Header.Set(util.ContentTypeHeader, test.contentType)
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cType := resp.Header.Get(util.ContentTypeHeader); cType != test.contentType {
+			t.Errorf("%d: expected content type %s; got %s", i, test.contentType, cType)
+		}
+
+		// Decode the chunks.
+		var chunks []*proto.ScanResponse
+		r := bufio.NewReader(resp.Body)
+		dec := json.NewDecoder(r)
+		for {
+			chunk := &proto.ScanResponse{}
+			if test.contentType == util.ProtoContentType {
+				size, err := binary.ReadUvarint(r)
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, size)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					t.Fatal(err)
+				}
+				err = gogoproto.Unmarshal(buf, chunk)
+			} else {
+				if err = dec.Decode(chunk); err == io.EOF {
+					break
+				}
+			}
+			if err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			if err := chunk.GoError(); err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			chunks = append(chunks, chunk)
+		}
+		resp.Body.Close()
+
+		var chunkSizes []int
+		var rowIdx int
+		for _, chunk := range chunks {
+			chunkSizes = append(chunkSizes, len(chunk.Rows))
+			for _, row := range chunk.Rows {
+				if expKey := proto.Key(fmt.Sprintf("key-%02d", rowIdx)); !row.Key.Equal(expKey) {
+					t.Errorf("%d: expected row %d to have key %q; got %q", i, rowIdx, expKey, row.Key)
+				}
+				rowIdx++
+			}
+			if !chunk.Timestamp.Equal(chunks[0].Timestamp) {
+				t.Errorf("%d: expected chunks to share timestamp %s; got %s", i, chunks[0].Timestamp, chunk.Timestamp)
+			}
+		}
+		if !reflect.DeepEqual(chunkSizes, test.expChunks) {
+			t.Errorf("%d: expected chunk sizes %v; got %v", i, test.expChunks, chunkSizes)
+		}
+	}
+}
+
+// TestKVDBScanCompressKeys verifies that scans requesting compressed
+// keys receive smaller, prefix-compressed responses, both buffered
+// and streamed, and that the HTTP client decompresses them
+// transparently.
+func TestKVDBScanCompressKeys(t *testing.T) {
+	addr, server, db := startServer(t)
+	defer server.Close()
+
+	prefix := "/table/users/index/email/"
+	for i := 0; i < 20; i++ {
+		key := proto.Key(fmt.Sprintf("%s%02d", prefix, i))
+		if err := db.Call(proto.Put, proto.PutArgs(key, []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verifyRows := func(rows []proto.KeyValue) {
+		if len(rows) != 20 {
+			t.Fatalf("expected 20 rows; got %d", len(rows))
+		}
+		for i, row := range rows {
+			if expKey := proto.Key(fmt.Sprintf("%s%02d", prefix, i)); !row.Key.Equal(expKey) {
+				t.Errorf("expected row %d to have key %q; got %q", i, expKey, row.Key)
+			}
+		}
+	}
+
+	// Scan via the HTTP client, which requests compressed keys.
+	scanReq := &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.Key(prefix), EndKey: proto.Key(prefix).PrefixEnd()},
+	}
+	scanResp := &proto.ScanResponse{}
+	if err := createTestClient(addr).Call(proto.Scan, scanReq, scanResp); err != nil {
+		t.Fatal(err)
+	}
+	if !scanReq.CompressKeys || scanResp.KeyPrefixLengths != nil {
+		t.Errorf("expected compressed keys to be requested and decompressed")
+	}
+	verifyRows(scanResp.Rows)
+
+	// Post the scan with and without compression, buffered and streamed.
+	post := func(compress bool, query string) []byte {
+		scanReq.CompressKeys = compress
+		body, err := gogoproto.Marshal(scanReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := fmt.Sprintf("http://%s%s%s%s", addr, kv.DBPrefix, proto.Scan, query)
+		resp, err := http.Post(url, util.ProtoContentType, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	full, compressed := post(false, ""), post(true, "")
+	// Each row after the first should save its shared prefix, less the
+	// encoding of its prefix length.
+	if saved, expSaved := len(full)-len(compressed), 19*(len(prefix)-2); saved < expSaved {
+		t.Errorf("expected compression to save at least %d bytes; saved %d", expSaved, saved)
+	}
+	// The first streamed chunk holds all rows.
+	stream := post(true, fmt.Sprintf("?%s=20", kv.DBStreamParam))
+	size, n := binary.Uvarint(stream)
+	if n <= 0 || n+int(size) > len(stream) {
+		t.Fatalf("unable to decode stream chunk length")
+	}
+	for i, b := range [][]byte{compressed, stream[n : n+int(size)]} {
+		reply := &proto.ScanResponse{}
+		if err := gogoproto.Unmarshal(b, reply); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(reply.KeyPrefixLengths) != len(reply.Rows) {
+			t.Errorf("%d: expected compressed keys; got %d prefix lengths", i, len(reply.KeyPrefixLengths))
+		}
+		if err := reply.DecompressKeys(); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		verifyRows(reply.Rows)
+	}
+}
+
 // TestKVDBTransaction verifies that transactions work properly over
 // the KV DB endpoint.
 func TestKVDBTransaction(t *testing.T) {
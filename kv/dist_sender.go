@@ -21,6 +21,9 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/cockroach/client"
@@ -31,6 +34,7 @@ import (
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 )
@@ -46,6 +50,19 @@ const (
 	// Maximum number of ranges to return from an internal range lookup.
 	// TODO(mrtracy): This value should be configurable.
 	rangeLookupMaxRanges = 8
+
+	// Maximum number of ranges scanned in parallel by a scan which
+	// spans multiple ranges.
+	maxParallelScans = 4
+
+	// Minimum number of follower read latencies recorded before the
+	// hedging delay is derived from them; until then, hedged reads
+	// wait defaultSendNextTimeout.
+	minHedgeSamples = 100
+
+	// rangeEventPollInterval is the minimum interval at which gossiped
+	// range events are applied to the range descriptor cache.
+	rangeEventPollInterval = 1 * time.Second
 )
 
 var rpcRetryOpts = util.RetryOptions{
@@ -80,6 +97,35 @@ func (n noNodeAddrsAvailError) Error() string {
 // CanRetry implements the Retryable interface.
 func (n noNodeAddrsAvailError) CanRetry() bool { return true }
 
+// distSenderMetrics holds the routing metrics tracked by a
+// DistSender. The metrics of its range descriptor cache are linked
+// into the registry under "rangecache.".
+type distSenderMetrics struct {
+	registry        *metric.Registry
+	rpcs            *metric.Counter   // RPCs sent for requests, excluding range lookups
+	mismatchRetries *metric.Counter   // Retries after a RangeKeyMismatchError
+	notFoundRetries *metric.Counter   // Retries after a RangeNotFoundError
+	notLeader       *metric.Counter   // Replies with a NotLeaderError
+	hops            *metric.Histogram // RPCs and range lookups per single-range request
+	readLatency     *metric.Histogram // Latency in nanoseconds of follower-readable reads
+	hedges          *metric.Counter   // Hedged RPCs sent for follower-readable reads
+}
+
+func newDistSenderMetrics(rangeCache *RangeDescriptorCache) *distSenderMetrics {
+	r := metric.NewRegistry()
+	r.MustAdd("rangecache.", rangeCache.Registry())
+	return &distSenderMetrics{
+		registry:        r,
+		rpcs:            r.Counter("rpcs"),
+		mismatchRetries: r.Counter("retries.mismatch"),
+		notFoundRetries: r.Counter("retries.notfound"),
+		notLeader:       r.Counter("notleader"),
+		hops:            r.Histogram("hops"),
+		readLatency:     r.Histogram("reads.latency"),
+		hedges:          r.Counter("reads.hedged"),
+	}
+}
+
 // A DistSender provides methods to access Cockroach's monolithic,
 // distributed key value store. Each method invocation triggers a
 // lookup or lookups to find replica metadata for implicated key
@@ -92,6 +138,20 @@ type DistSender struct {
 	gossip *gossip.Gossip
 	// rangeCache caches replica metadata for key ranges.
 	rangeCache *RangeDescriptorCache
+	metrics    *distSenderMetrics
+	// hedgePercentile, if non-zero, is the percentile of recent
+	// follower read latencies after which a read which may be served
+	// by any replica is also sent to another replica.
+	hedgePercentile float64
+
+	// rangeEventPoll is the time in nanoseconds at which gossiped range
+	// events were last polled.
+	rangeEventPoll int64
+	// rangeEventMu protects rangeEventLast.
+	rangeEventMu sync.Mutex
+	// rangeEventLast is the timestamp of the last range event applied
+	// to the range cache.
+	rangeEventLast int64
 }
 
 // NewDistSender returns a client.KVSender instance which connects to the
@@ -101,16 +161,54 @@ func NewDistSender(gossip *gossip.Gossip) *DistSender {
 		gossip: gossip,
 	}
 	ds.rangeCache = NewRangeDescriptorCache(ds)
+	ds.metrics = newDistSenderMetrics(ds.rangeCache)
 	return ds
 }
 
+// Registry returns the registry of routing metrics.
+func (ds *DistSender) Registry() *metric.Registry {
+	return ds.metrics.registry
+}
+
+// SetHedgePercentile enables hedging of follower-readable reads: a
+// read which is not answered within the given percentile (0, 100) of
+// recent read latencies is also sent to another replica, and the
+// first reply wins. Hedging cuts tail latency caused by a single slow
+// node at the price of extra load. A percentile of 0 disables
+// hedging; reads then move to another replica only after
+// defaultSendNextTimeout.
+func (ds *DistSender) SetHedgePercentile(percentile float64) {
+	ds.hedgePercentile = percentile
+}
+
+// isHedgeable returns true if the request may be served by any
+// replica, as bounded staleness and inconsistent reads may, and is
+// therefore eligible for hedging.
+func isHedgeable(method string, header *proto.RequestHeader) bool {
+	return header.EffectiveReadBound(method) != proto.EXACT
+}
+
+// hedgeDelay returns the duration after which a hedgeable read is
+// sent to another replica.
+func (ds *DistSender) hedgeDelay() time.Duration {
+	if ds.hedgePercentile <= 0 || ds.metrics.readLatency.Count() < minHedgeSamples {
+		return defaultSendNextTimeout
+	}
+	delay := time.Duration(ds.metrics.readLatency.ValueAtQuantile(ds.hedgePercentile))
+	if delay > defaultSendNextTimeout {
+		return defaultSendNextTimeout
+	}
+	return delay
+}
+
 // verifyPermissions verifies that the requesting user (header.User)
 // has permission to read/write (capabilities depend on method
 // name). In the event that multiple permission configs apply to the
 // key range implicated by the command, the lowest common denominator
 // for permission. For example, if a scan crosses two permission
 // configs, both configs must allow read permissions or the entire
-// scan will fail.
+// scan will fail. If the request carries an accounting tag, each
+// config must also allow the tag.
 func (ds *DistSender) verifyPermissions(method string, header *proto.RequestHeader) error {
 	// The root user can always proceed.
 	if header.User == storage.UserRoot {
@@ -148,6 +246,10 @@ func (ds *DistSender) verifyPermissions(method string, header *proto.RequestHead
 				return util.Errorf("user %q cannot invoke %s at %q; permissions: %+v",
 					header.User, method, string(start), perm)
 			}
+			if header.Tag != "" && !perm.CanTag(header.Tag) {
+				return util.Errorf("user %q cannot invoke %s with tag %q at %q; permissions: %+v",
+					header.User, method, header.Tag, string(start), perm)
+			}
 			return nil
 		})
 }
@@ -242,7 +344,9 @@ func (ds *DistSender) getRangeDescriptor(key proto.Key) ([]proto.RangeDescriptor
 // sendRPC sends one or more RPCs to replicas from the supplied
 // proto.Replica slice. First, replicas which have gossipped
 // addresses are corraled and then sent via rpc.Send, with requirement
-// that one RPC to a server must succeed.
+// that one RPC to a server must succeed. Hedgeable reads move on to
+// the next replica after the hedging delay; the reply of the slower
+// RPC is discarded, even if it arrives after sendRPC returns.
 func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args proto.Request, reply proto.Response) error {
 	if len(desc.Replicas) == 0 {
 		return util.Errorf("%s: replicas set is empty", method)
@@ -271,6 +375,35 @@ func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args p
 		SendNextTimeout: defaultSendNextTimeout,
 		Timeout:         defaultRPCTimeout,
 	}
+	// A scan which waits for changes mustn't time out or move on to
+	// another replica while waiting.
+	if scanArgs, ok := args.(*proto.ScanRequest); ok && scanArgs.WaitTimeout > 0 {
+		rpcOpts.SendNextTimeout = time.Duration(scanArgs.WaitTimeout) + defaultSendNextTimeout
+		rpcOpts.Timeout = time.Duration(scanArgs.WaitTimeout) + defaultRPCTimeout
+	}
+	hedgeable := isHedgeable(method, args.Header())
+	// A hedged read is won by the first successful reply; a replica
+	// which refuses the read, for instance because its closed timestamp
+	// is too stale, must not win over one still serving it. The first
+	// such refusal is returned if no replica succeeds.
+	var refusalMu sync.Mutex
+	var refusal proto.Response
+	if hedgeable {
+		rpcOpts.SendNextTimeout = ds.hedgeDelay()
+		rpcOpts.ReplyError = func(r interface{}) error {
+			resp := r.(proto.Response)
+			err := resp.Header().GoError()
+			if err == nil {
+				return nil
+			}
+			refusalMu.Lock()
+			if refusal == nil {
+				refusal = resp
+			}
+			refusalMu.Unlock()
+			return err
+		}
+	}
 	// getArgs clones the arguments on demand for all but the first replica.
 	firstArgs := true
 	getArgs := func(addr net.Addr) interface{} {
@@ -282,11 +415,18 @@ func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args p
 		} else {
 			// Otherwise, copy the args value and set the replica in the header.
 			a = gogoproto.Clone(args).(proto.Request)
+			if hedgeable {
+				ds.metrics.hedges.Inc(1)
+			}
 		}
 		a.Header().Replica = *replicaMap[addr.String()]
 		return a
 	}
-	firstReply := true
+	// The slower RPCs of a hedged read are not canceled and may still
+	// decode their replies after Send returns, so each RPC of a
+	// hedgeable read gets its own reply and only the winner (or the
+	// recorded refusal) is copied into reply.
+	firstReply := !hedgeable
 	getReply := func() interface{} {
 		if firstReply {
 			firstReply = false
@@ -294,8 +434,123 @@ func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args p
 		}
 		return gogoproto.Clone(reply)
 	}
-	_, err := rpc.Send(rpcOpts, "Node."+method, addrs, getArgs, getReply, ds.gossip.RPCContext)
-	return err
+	start := time.Now()
+	replies, err := rpc.Send(rpcOpts, "Node."+method, addrs, getArgs, getReply, ds.gossip.RPCContext)
+	if err != nil {
+		refusalMu.Lock()
+		defer refusalMu.Unlock()
+		if _, ok := err.(rpc.SendError); ok && refusal != nil {
+			reply.Reset()
+			gogoproto.Merge(reply, refusal)
+			return nil
+		}
+		return err
+	}
+	if hedgeable {
+		ds.metrics.readLatency.RecordValue(time.Since(start).Nanoseconds())
+	}
+	// The winning reply may be a clone if a later replica answered first.
+	if winner := replies[0].(proto.Response); winner != reply {
+		reply.Reset()
+		gogoproto.Merge(reply, winner)
+	}
+	return nil
+}
+
+// sendScan executes a scan which spans multiple ranges, beginning
+// with the range described by desc. The first range is scanned on its
+// own, which often satisfies the scan's limits. If the scan has no
+// timestamp, subsequent ranges are read at the timestamp of the first
+// range's reply. Remaining ranges are scanned in parallel batches of
+// up to maxParallelScans ranges, each limited to the results still
+// outstanding. Rows are merged in key order and truncated to the
+// scan's MaxResults and MaxBytes limits. If the scan requests a
+// checksum, the reply of each range is verified by Send and the
+// merged rows are checksummed anew.
+func (ds *DistSender) sendScan(desc *proto.RangeDescriptor, args *proto.ScanRequest, reply *proto.ScanResponse) error {
+	header := args.RequestHeader
+	reply.Timestamp = proto.Timestamp{}
+	rows := []proto.KeyValue{}
+	var size int64 // Total size of keys and values in rows
+	key := args.Key
+	for batch := 1; key.Less(args.EndKey); batch = maxParallelScans {
+		// Determine the boundaries of the next batch of ranges.
+		bounds := []proto.Key{key}
+		for len(bounds) <= batch && key.Less(args.EndKey) {
+			if desc == nil {
+				var err error
+				if desc, err = ds.rangeCache.LookupRangeDescriptor(key); err != nil {
+					return err
+				}
+			}
+			if key = desc.EndKey; args.EndKey.Less(key) {
+				key = args.EndKey
+			}
+			bounds = append(bounds, key)
+			desc = nil
+		}
+
+		// Scan the ranges in parallel, each via Send to handle retries
+		// and ranges which have split since their lookup.
+		replies := make([]*proto.ScanResponse, len(bounds)-1)
+		var wg sync.WaitGroup
+		for i := range replies {
+			subArgs := &proto.ScanRequest{
+				RequestHeader: header,
+				Projection:    args.Projection,
+				CompressKeys:  args.CompressKeys,
+				Checksum:      args.Checksum,
+			}
+			subArgs.Key, subArgs.EndKey = bounds[i], bounds[i+1]
+			// Only the first range waits for changes; see
+			// ScanRequest.WaitTimeout.
+			if batch == 1 {
+				subArgs.WaitTimestamp, subArgs.WaitTimeout = args.WaitTimestamp, args.WaitTimeout
+			}
+			if args.MaxResults > 0 {
+				subArgs.MaxResults = args.MaxResults - int64(len(rows))
+			}
+			if args.MaxBytes > 0 {
+				subArgs.MaxBytes = args.MaxBytes - size
+			}
+			replies[i] = &proto.ScanResponse{}
+			wg.Add(1)
+			go func(call *client.Call) {
+				defer wg.Done()
+				ds.Send(call)
+			}(&client.Call{Method: proto.Scan, Args: subArgs, Reply: replies[i]})
+		}
+		wg.Wait()
+
+		// Merge the rows in key order until the limits are reached.
+		for _, r := range replies {
+			if err := r.GoError(); err != nil {
+				return err
+			}
+			if reply.Timestamp.Less(r.Timestamp) {
+				reply.Timestamp = r.Timestamp
+			}
+			var full bool
+			if rows, full = proto.LimitRows(append(rows, r.Rows...), args.MaxResults, args.MaxBytes); full {
+				reply.Rows = rows
+				if args.Checksum {
+					reply.SetChecksum()
+				}
+				return nil
+			}
+			for _, kv := range r.Rows {
+				size += int64(len(kv.Key) + len(kv.Value.Bytes))
+			}
+		}
+		if header.Timestamp.Equal(proto.MinTimestamp) {
+			header.Timestamp = reply.Timestamp
+		}
+	}
+	reply.Rows = rows
+	if args.Checksum {
+		reply.SetChecksum()
+	}
+	return nil
 }
 
 // Send implements the clent.KVSender interface. It verifies
@@ -303,6 +558,7 @@ func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args p
 // supplied key and sends the RPC according to the specified
 // options.
 func (ds *DistSender) Send(call *client.Call) {
+	ds.maybeApplyRangeEvents()
 	// Verify permissions.
 	if err := ds.verifyPermissions(call.Method, call.Args.Header()); err != nil {
 		call.Reply.Header().SetGoError(err)
@@ -310,12 +566,58 @@ func (ds *DistSender) Send(call *client.Call) {
 	}
 
 	// Retry logic for lookup of range by key and RPCs to range replicas.
+	// Retries reuse the call's client command ID, so a retry of a
+	// read-write command which was executed by an earlier attempt
+	// returns the result stored in the range's response cache.
 	retryOpts := rpcRetryOpts
 	retryOpts.Tag = fmt.Sprintf("routing %s rpc", call.Method)
+	var ambiguous bool  // true if a read-write RPC may have been executed without a definitive reply
+	var multiRange bool // true if the request was split across ranges
+	var hops int64      // RPCs and range lookups sent for a single-range request
 	err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
-		desc, err := ds.rangeCache.LookupRangeDescriptor(call.Args.Header().Key)
+		desc, cached, err := ds.rangeCache.lookupRangeDescriptor(call.Args.Header().Key)
+		if !cached {
+			hops++
+		}
+		// A compare-and-swap is only atomic within a single range. As
+		// ranges only shrink through splits, a cached descriptor which
+		// doesn't contain its keys shows they span multiple ranges.
+		if err == nil && call.Method == proto.CompareAndSwap && proto.Key(desc.EndKey).Less(call.Args.Header().EndKey) {
+			return util.RetryBreak, proto.NewOpRequiresTxnError(call.Method)
+		}
 		if err == nil {
-			err = ds.sendRPC(desc, call.Method, call.Args, call.Reply)
+			if args, ok := call.Args.(*proto.ScanRequest); ok && proto.Key(desc.EndKey).Less(args.EndKey) {
+				// The scans of the individual ranges are recorded separately.
+				multiRange = true
+				err = ds.sendScan(desc, args, call.Reply.(*proto.ScanResponse))
+			} else {
+				hops++
+				ds.metrics.rpcs.Inc(1)
+				if err = ds.sendRPC(desc, call.Method, call.Args, call.Reply); err == nil {
+					if reply, ok := call.Reply.(*proto.ScanResponse); ok {
+						if err := reply.DecompressKeys(); err != nil {
+							return util.RetryBreak, err
+						}
+						if err := reply.VerifyChecksum(); err != nil {
+							return util.RetryBreak, err
+						}
+					}
+					// Addressing errors returned by the range indicate a stale
+					// descriptor; clear them from the reply to retry below.
+					switch replyErr := call.Reply.Header().GoError(); replyErr.(type) {
+					case *proto.RangeNotFoundError, *proto.RangeKeyMismatchError:
+						call.Reply.Header().Error = nil
+						err = replyErr
+					case *proto.NotLeaderError:
+						ds.metrics.notLeader.Inc(1)
+					default:
+						// The range answered the command. As retries reuse the
+						// command ID, the reply reflects any execution by an
+						// earlier attempt and is definitive.
+						ambiguous = false
+					}
+				}
+			}
 		}
 		if err != nil {
 			log.Warningf("failed to invoke %s: %s", call.Method, err)
@@ -323,12 +625,25 @@ func (ds *DistSender) Send(call *client.Call) {
 			// or range key mismatch errors special. In these cases, we don't want
 			// to backoff on the retry, but reset the backoff loop so we can retry
 			// immediately.
-			switch err.(type) {
+			switch t := err.(type) {
 			case *proto.RangeNotFoundError, *proto.RangeKeyMismatchError:
+				if _, ok := t.(*proto.RangeKeyMismatchError); ok {
+					ds.metrics.mismatchRetries.Inc(1)
+				} else {
+					ds.metrics.notFoundRetries.Inc(1)
+				}
 				// Range descriptor might be out of date - evict it.
 				ds.rangeCache.EvictCachedRangeDescriptor(call.Args.Header().Key)
 				// On addressing errors, don't backoff and retry immediately.
 				return util.RetryReset, nil
+			case rpc.SendError:
+				// The RPC may have failed after the command was executed.
+				if proto.IsReadWrite(call.Method) {
+					ambiguous = true
+				}
+				if t.CanRetry() {
+					return util.RetryContinue, nil
+				}
 			default:
 				if retryErr, ok := err.(util.Retryable); ok && retryErr.CanRetry() {
 					return util.RetryContinue, nil
@@ -337,11 +652,65 @@ func (ds *DistSender) Send(call *client.Call) {
 		}
 		return util.RetryBreak, err
 	})
+	if !multiRange {
+		ds.metrics.hops.RecordValue(hops)
+	}
 	if err != nil {
+		// If no attempt definitively succeeded or failed after an
+		// earlier attempt may have executed the command, its result
+		// is unknown.
+		if ambiguous {
+			err = proto.NewAmbiguousResultError(err)
+		}
 		call.Reply.Header().SetGoError(err)
 	}
 }
 
+// maybeApplyRangeEvents applies range events gossiped since the last
+// poll to the range cache, in the order in which they occurred, at
+// most once per rangeEventPollInterval. Requests are thereby routed
+// according to recent splits without first being sent to the wrong
+// range.
+func (ds *DistSender) maybeApplyRangeEvents() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&ds.rangeEventPoll)
+	if now-last < rangeEventPollInterval.Nanoseconds() ||
+		!atomic.CompareAndSwapInt64(&ds.rangeEventPoll, last, now) {
+		return
+	}
+	infos, err := ds.gossip.GetGroupInfos(gossip.KeyRangeEventPrefix)
+	if err != nil {
+		// The group of range events isn't registered.
+		return
+	}
+	ds.rangeEventMu.Lock()
+	defer ds.rangeEventMu.Unlock()
+	var events rangeEvents
+	for _, info := range infos {
+		if event, ok := info.(storage.RangeEvent); ok && event.Timestamp > ds.rangeEventLast {
+			events = append(events, event)
+		}
+	}
+	sort.Sort(events)
+	for _, event := range events {
+		ds.rangeCache.updateCachedRangeDescriptors(event.Descs)
+		ds.rangeEventLast = event.Timestamp
+	}
+}
+
+// rangeEvents sorts range events by time.
+type rangeEvents []storage.RangeEvent
+
+func (r rangeEvents) Len() int           { return len(r) }
+func (r rangeEvents) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r rangeEvents) Less(i, j int) bool { return r[i].Timestamp < r[j].Timestamp }
+
+// LookupRangeDescriptor implements the client.RangeLookup interface
+// via the sender's range descriptor cache.
+func (ds *DistSender) LookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error) {
+	return ds.rangeCache.LookupRangeDescriptor(key)
+}
+
 // Close implements the client.KVSender interface. It's a noop for the
 // distributed sender.
 func (ds *DistSender) Close() {}
@@ -23,10 +23,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
 )
 
 func TestGetFirstRangeDescriptor(t *testing.T) {
@@ -69,10 +73,12 @@ func TestVerifyPermissions(t *testing.T) {
 	ds := NewDistSender(n.Nodes[0].Gossip)
 	config1 := &proto.PermConfig{
 		Read:  []string{"read1", "readAll", "rw", "rwAll"},
-		Write: []string{"write1", "writeAll", "rw", "rwAll"}}
+		Write: []string{"write1", "writeAll", "rw", "rwAll"},
+		Tags:  []string{"tag1", "tagAll"}}
 	config2 := &proto.PermConfig{
 		Read:  []string{"read2", "readAll", "rw2", "rwAll"},
-		Write: []string{"write2", "writeAll", "rw2", "rwAll"}}
+		Write: []string{"write2", "writeAll", "rw2", "rwAll"},
+		Tags:  []string{"tag2", "tagAll"}}
 	configs := []*storage.PrefixConfig{
 		{engine.KeyMin, nil, config1},
 		{proto.Key("a"), nil, config2},
@@ -156,5 +162,232 @@ func TestVerifyPermissions(t *testing.T) {
 			}
 		}
 	}
+
+	// Test accounting tags, representatively using rw methods.
+	tagData := []struct {
+		tag              string
+		startKey, endKey proto.Key
+		hasPermission    bool
+	}{
+		{"", engine.KeyMin, proto.Key("b"), true},
+		{"tag1", engine.KeyMin, engine.KeyMin, true},
+		{"tag2", engine.KeyMin, engine.KeyMin, false},
+		{"tag2", proto.Key("a"), proto.Key("b"), true},
+		{"tagAll", engine.KeyMin, proto.Key("b"), true},
+		{"tag1", engine.KeyMin, proto.Key("b"), false},
+		{"random", engine.KeyMin, engine.KeyMin, false},
+	}
+	for _, test := range tagData {
+		for _, method := range readWriteMethods {
+			err := ds.verifyPermissions(
+				method,
+				&proto.RequestHeader{
+					User: "rwAll", Tag: test.tag, Key: test.startKey, EndKey: test.endKey})
+			if err != nil && test.hasPermission {
+				t.Errorf("tag: %q should have been permitted for %s, err: %s",
+					test.tag, method, err.Error())
+			} else if err == nil && !test.hasPermission {
+				t.Errorf("tag: %q should not have been permitted for %s",
+					test.tag, method)
+			}
+		}
+	}
 	n.Stop()
 }
+
+// TestHedgeDelay verifies the delay after which hedgeable reads are
+// sent to another replica.
+func TestHedgeDelay(t *testing.T) {
+	ds := NewDistSender(nil)
+	if d := ds.hedgeDelay(); d != defaultSendNextTimeout {
+		t.Errorf("expected default delay with hedging disabled; got %s", d)
+	}
+	ds.SetHedgePercentile(90)
+	// Too few samples to derive the delay from.
+	for i := 0; i < minHedgeSamples-1; i++ {
+		ds.metrics.readLatency.RecordValue(int64(time.Millisecond))
+	}
+	if d := ds.hedgeDelay(); d != defaultSendNextTimeout {
+		t.Errorf("expected default delay with too few samples; got %s", d)
+	}
+	ds.metrics.readLatency.RecordValue(int64(time.Millisecond))
+	if d := ds.hedgeDelay(); d <= 0 || d > 2*time.Millisecond {
+		t.Errorf("expected delay of ~1ms; got %s", d)
+	}
+	// The delay never exceeds the default.
+	for i := 0; i < 10*minHedgeSamples; i++ {
+		ds.metrics.readLatency.RecordValue(int64(time.Minute))
+	}
+	if d := ds.hedgeDelay(); d != defaultSendNextTimeout {
+		t.Errorf("expected delay capped at default; got %s", d)
+	}
+
+	testCases := []struct {
+		method    string
+		header    proto.RequestHeader
+		hedgeable bool
+	}{
+		{proto.Get, proto.RequestHeader{MaxStaleness: 1}, true},
+		{proto.Get, proto.RequestHeader{}, false},
+		{proto.Get, proto.RequestHeader{MaxStaleness: 1, Txn: &proto.Transaction{}}, false},
+		{proto.Put, proto.RequestHeader{MaxStaleness: 1}, false},
+		{proto.Scan, proto.RequestHeader{ReadBound: proto.INCONSISTENT}, true},
+		{proto.Get, proto.RequestHeader{ReadBound: proto.BOUNDED_STALENESS}, false},
+		{proto.Put, proto.RequestHeader{ReadBound: proto.INCONSISTENT}, false},
+	}
+	for i, test := range testCases {
+		if hedgeable := isHedgeable(test.method, &test.header); hedgeable != test.hedgeable {
+			t.Errorf("%d: expected hedgeable %t; got %t", i, test.hedgeable, hedgeable)
+		}
+	}
+}
+
+// A hedgeTestNode answers Get requests with its name after a delay.
+type hedgeTestNode struct {
+	name  string
+	delay time.Duration
+}
+
+func (n *hedgeTestNode) Get(args *proto.GetRequest, reply *proto.GetResponse) error {
+	time.Sleep(n.delay)
+	reply.Value = &proto.Value{Bytes: []byte(n.name)}
+	return nil
+}
+
+// TestDistSenderHedgedReadSlowReplica verifies that a hedged read is
+// won by the fast replica and that the reply of a slow replica, which
+// arrives after the read has returned, doesn't overwrite the result.
+func TestDistSenderHedgedReadSlowReplica(t *testing.T) {
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig(), stopper)
+	g := gossip.New(rpcContext)
+	desc := &proto.RangeDescriptor{RaftID: 1, StartKey: proto.KeyMin, EndKey: proto.KeyMax}
+	const slowDelay = 100 * time.Millisecond
+	for i, node := range []*hedgeTestNode{{"slow", slowDelay}, {"fast", 0}} {
+		s := rpc.NewServer(util.CreateTestAddr("tcp"), rpcContext)
+		if err := s.RegisterName("Node", node); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		nodeID := int32(i + 1)
+		if err := g.AddInfo(gossip.MakeNodeIDGossipKey(nodeID), s.Addr(), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		desc.Replicas = append(desc.Replicas, proto.Replica{NodeID: nodeID, StoreID: nodeID})
+	}
+	ds := NewDistSender(g)
+	ds.SetHedgePercentile(90)
+	for i := 0; i < minHedgeSamples; i++ {
+		ds.metrics.readLatency.RecordValue(int64(time.Millisecond))
+	}
+
+	// Replicas are tried in random order; whenever the slow replica is
+	// tried first, the fast one is sent a hedged read and wins.
+	for i := 0; i < 4; i++ {
+		args := &proto.GetRequest{
+			RequestHeader: proto.RequestHeader{Key: proto.Key("a"), User: storage.UserRoot, MaxStaleness: 1},
+		}
+		reply := &proto.GetResponse{}
+		if err := ds.sendRPC(desc, proto.Get, args, reply); err != nil {
+			t.Fatal(err)
+		}
+		if v := string(reply.Value.Bytes); v != "fast" {
+			t.Fatalf("%d: expected the fast replica to win; got %q", i, v)
+		}
+		// Wait for the slow replica's reply to arrive.
+		time.Sleep(2 * slowDelay)
+		if v := string(reply.Value.Bytes); v != "fast" {
+			t.Fatalf("%d: expected the slow replica's late reply to be discarded; got %q", i, v)
+		}
+	}
+}
+
+// TestDistSenderRangeEvents verifies that gossiped range events are
+// applied to the range cache in the order in which they occurred, and
+// only once.
+func TestDistSenderRangeEvents(t *testing.T) {
+	n := gossip.NewSimulationNetwork(1, "unix", gossip.DefaultTestGossipInterval)
+	defer n.Stop()
+	g := n.Nodes[0].Gossip
+	if err := g.RegisterGroup(gossip.KeyRangeEventPrefix, 10, gossip.MaxGroup); err != nil {
+		t.Fatal(err)
+	}
+	ds := NewDistSender(g)
+
+	addEvent := func(raftID int64, timestamp int64, descs ...proto.RangeDescriptor) {
+		event := storage.RangeEvent{Timestamp: timestamp, Descs: descs}
+		if err := g.AddInfo(gossip.MakeRangeEventGossipKey(raftID), event, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// [a,z) splits at m, then [a,m) splits at f. The later event is
+	// gossiped first.
+	addEvent(3, 2, proto.RangeDescriptor{RaftID: 1, StartKey: proto.Key("a"), EndKey: proto.Key("f")},
+		proto.RangeDescriptor{RaftID: 3, StartKey: proto.Key("f"), EndKey: proto.Key("m")})
+	addEvent(2, 1, proto.RangeDescriptor{RaftID: 1, StartKey: proto.Key("a"), EndKey: proto.Key("m")},
+		proto.RangeDescriptor{RaftID: 2, StartKey: proto.Key("m"), EndKey: proto.Key("z")})
+	ds.maybeApplyRangeEvents()
+
+	expect := func(key string, raftID int64) {
+		if _, desc := ds.rangeCache.getCachedRangeDescriptor(proto.Key(key)); desc == nil || desc.RaftID != raftID {
+			t.Errorf("expected range %d to be cached for %q; got %+v", raftID, key, desc)
+		}
+	}
+	expect("b", 1)
+	expect("g", 3)
+	expect("n", 2)
+	if c := ds.rangeCache.updates.Count(); c != 4 {
+		t.Errorf("expected 4 updates; got %d", c)
+	}
+
+	// Events aren't polled again within the interval, nor applied
+	// again once polled.
+	addEvent(4, 3, proto.RangeDescriptor{RaftID: 2, StartKey: proto.Key("m"), EndKey: proto.Key("t")},
+		proto.RangeDescriptor{RaftID: 4, StartKey: proto.Key("t"), EndKey: proto.Key("z")})
+	ds.maybeApplyRangeEvents()
+	expect("u", 2)
+	ds.rangeEventPoll = 0
+	ds.maybeApplyRangeEvents()
+	expect("u", 4)
+	if c := ds.rangeCache.updates.Count(); c != 6 {
+		t.Errorf("expected 6 updates; got %d", c)
+	}
+}
+
+// TestDistSenderCompareAndSwapSpansRanges verifies that a
+// compare-and-swap whose keys span multiple ranges fails with an
+// OpRequiresTxnError without being sent.
+func TestDistSenderCompareAndSwapSpansRanges(t *testing.T) {
+	n := gossip.NewSimulationNetwork(1, "unix", gossip.DefaultTestGossipInterval)
+	defer n.Stop()
+	g := n.Nodes[0].Gossip
+	if err := g.RegisterGroup(gossip.KeyRangeEventPrefix, 10, gossip.MaxGroup); err != nil {
+		t.Fatal(err)
+	}
+	event := storage.RangeEvent{Timestamp: 1, Descs: []proto.RangeDescriptor{
+		{RaftID: 1, StartKey: proto.Key("a"), EndKey: proto.Key("m")},
+		{RaftID: 2, StartKey: proto.Key("m"), EndKey: proto.Key("z")},
+	}}
+	if err := g.AddInfo(gossip.MakeRangeEventGossipKey(2), event, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	ds := NewDistSender(g)
+
+	args := &proto.CompareAndSwapRequest{
+		RequestHeader: proto.RequestHeader{User: storage.UserRoot},
+		Writes:        []proto.CompareAndSwapWrite{{Key: proto.Key("b")}, {Key: proto.Key("n")}},
+	}
+	args.Key, args.EndKey = args.Span()
+	reply := &proto.CompareAndSwapResponse{}
+	ds.Send(&client.Call{Method: proto.CompareAndSwap, Args: args, Reply: reply})
+	if _, ok := reply.GoError().(*proto.OpRequiresTxnError); !ok {
+		t.Errorf("expected op requires txn error; got %v", reply.GoError())
+	}
+	if rpcs := ds.metrics.rpcs.Count(); rpcs != 0 {
+		t.Errorf("expected no RPCs to be sent; got %d", rpcs)
+	}
+}
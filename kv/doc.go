@@ -26,5 +26,60 @@ ranges which comprise the whole.
 Package kv implements the logic necessary to locate appropriate nodes
 based on keys being read or written. In some cases, requests may span
 a range of keys, in which case multiple RPCs may be sent out.
+
+Key-value protocol
+
+Clients in languages other than Go access the datastore through the
+protobuf-over-HTTP protocol served by DBServer, which the Go
+client.HTTPSender also speaks. This section specifies that protocol.
+
+Each request is an HTTP POST to DBPrefix followed by the method name,
+e.g. /kv/db/Put. Only the public methods listed in proto/api.go are
+served. The body is the method's request message (e.g. PutRequest)
+encoded according to the Content-Type header, which must be one of
+application/x-protobuf or application/json. The body of a successful
+reply is the method's response message, encoded according to the
+Accept header or, if absent, the request's Content-Type.
+
+HTTP status codes are used as follows:
+
+	200  the request was executed; errors are carried in the response
+	     header's Error field
+	400  the body could not be decoded, or a parameter is invalid
+	401  the request was not authenticated
+	404  the method is unknown or not public
+	429, 503, 504
+	     the node is unable to serve the request; retry
+	500  the node failed to encode the reply
+
+The user on whose behalf the request executes is determined by the
+node's authentication; the User field of the request header is
+ignored.
+
+Read-write requests must carry a ClientCmdID in their header, with
+WallTime and Random set. Retryable status codes and connection errors
+are retried with exponential backoff, keeping the same ClientCmdID so
+that a command which did execute is answered from the range's response
+cache instead of being applied twice. A client which exhausts its
+retries for a read-write request after a connection error must report
+the result as unknown, as client.AmbiguousResultError does.
+
+Errors carried in a 200 reply are retried as follows:
+
+	TransactionPushError  back off and retry
+	WriteTooOldError      retry immediately with a new timestamp
+	WriteIntentError      retry immediately if Resolved, otherwise
+	                      back off and retry
+	all others            return to the caller
+
+Transactional requests carry the Transaction returned by the
+previous request of the transaction, and end with EndTransaction.
+
+A Scan may stream its results by specifying the DBStreamParam query
+parameter; see DBServer.serveScanStream for the stream's framing.
+
+ConformanceServer serves this protocol while recording departures
+from it, and is served by the "cockroach conformance" command for
+testing client implementations.
 */
 package kv
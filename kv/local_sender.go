@@ -103,10 +103,17 @@ func (ls *LocalSender) Send(call *client.Call) {
 		Tag:         fmt.Sprintf("routing %s locally", call.Method),
 		MaxAttempts: 2,
 	}
+	retry := false
 	util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
 		var err error
 		var store *storage.Store
 
+		// Clear any error left in the reply by a previous attempt.
+		if retry {
+			call.Reply.Reset()
+		}
+		retry = true
+
 		// If we aren't given a Replica, then a little bending over
 		// backwards here. We need to find the Store, but all we have is the
 		// Key. So find its Range locally. This lets us use the same
@@ -129,13 +136,14 @@ func (ls *LocalSender) Send(call *client.Call) {
 			if err = store.ExecuteCmd(call.Method, call.Args, call.Reply); err != nil {
 				// Check for range key mismatch error (this could happen if
 				// range was split between lookup and execution). In this case,
-				// reset header.Replica and engage retry loop.
+				// reset header.Replica and engage retry loop. The error is
+				// set on the reply in case the retry is not attempted.
+				call.Reply.Header().SetGoError(err)
 				switch err.(type) {
 				case *proto.RangeKeyMismatchError:
 					header.Replica = proto.Replica{}
 					return util.RetryContinue, nil
 				}
-				call.Reply.Header().SetGoError(err)
 			} else {
 				if err = call.Reply.Verify(call.Args); err != nil {
 					call.Reply.Header().SetGoError(err)
@@ -25,6 +25,7 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 )
 
@@ -118,12 +119,14 @@ func splitTestRange(store *storage.Store, key, splitKey proto.Key, t *testing.T)
 }
 
 func TestLocalSenderLookupReplica(t *testing.T) {
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
 	ls := NewLocalSender()
-	db := client.NewKV(NewCoordinator(ls, clock), nil)
-	store := storage.NewStore(clock, eng, db, nil)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	db := client.NewKV(NewCoordinator(ls, clock, stopper), nil)
+	store := storage.NewStore(clock, eng, db, nil, stopper)
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -149,14 +152,16 @@ func TestLocalSenderLookupReplica(t *testing.T) {
 		{3, proto.Key("x"), proto.Key("z")},
 	}
 	for i, rng := range ranges {
-		s[i] = storage.NewStore(clock, eng, db, nil)
+		s[i] = storage.NewStore(clock, eng, db, nil, stopper)
 		s[i].Ident.StoreID = rng.storeID
 		desc, err := store.NewRangeDescriptor(rng.start, rng.end, []proto.Replica{{StoreID: rng.storeID}})
 		if err != nil {
 			t.Fatal(err)
 		}
 		newRng := storage.NewRange(desc.FindReplica(rng.storeID).RangeID, desc, s[i])
-		s[i].AddRange(newRng)
+		if err := s[i].AddRange(newRng); err != nil {
+			t.Fatal(err)
+		}
 		ls.AddStore(s[i])
 	}
 
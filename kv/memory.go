@@ -0,0 +1,153 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/metric"
+)
+
+// A MemoryBudget bounds the number of bytes buffered by requests in
+// flight at a gateway node, such as encoded request bodies and the
+// results of scans awaiting delivery to the client. Each request
+// reserves bytes through a MemoryAccount; bytes reserved by requests
+// belonging to the same transaction are additionally tallied per
+// transaction. Reservations which would exceed the budget's limit
+// fail with a MemoryBudgetExceededError, preventing a single large
+// request from exhausting the node's memory.
+type MemoryBudget struct {
+	limit int64 // Maximum bytes in use; 0 for unlimited
+
+	mu   sync.Mutex
+	used int64
+	txns map[string]int64 // Bytes in use, keyed by transaction ID
+
+	registry *metric.Registry
+	inUse    *metric.Gauge   // Bytes currently reserved
+	rejected *metric.Counter // Reservations rejected
+}
+
+// NewMemoryBudget creates a new MemoryBudget allowing at most limit
+// bytes to be reserved at once. A limit of 0 places no bound on
+// reservations, which are still tracked.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	r := metric.NewRegistry()
+	return &MemoryBudget{
+		limit:    limit,
+		txns:     map[string]int64{},
+		registry: r,
+		inUse:    r.Gauge("bytes"),
+		rejected: r.Counter("rejected"),
+	}
+}
+
+// Registry returns the registry of memory budget metrics.
+func (b *MemoryBudget) Registry() *metric.Registry {
+	return b.registry
+}
+
+// Used returns the number of bytes currently reserved.
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// TxnUsed returns the number of bytes currently reserved by requests
+// belonging to the transaction with the given ID.
+func (b *MemoryBudget) TxnUsed(txnID []byte) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.txns[string(txnID)]
+}
+
+// NewAccount returns an account through which a single request
+// reserves bytes against the budget. If txn is not nil, the
+// account's reservations are tallied against the transaction as
+// well. The account must be closed once the request completes.
+func (b *MemoryBudget) NewAccount(txn *proto.Transaction) *MemoryAccount {
+	a := &MemoryAccount{budget: b}
+	if txn != nil {
+		a.txnID = string(txn.ID)
+	}
+	return a
+}
+
+// grow reserves n bytes on behalf of the specified transaction.
+func (b *MemoryBudget) grow(txnID string, n int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used+n > b.limit {
+		b.rejected.Inc(1)
+		return proto.NewMemoryBudgetExceededError(n, b.used, b.limit)
+	}
+	b.used += n
+	if txnID != "" {
+		b.txns[txnID] += n
+	}
+	b.inUse.Update(b.used)
+	return nil
+}
+
+// shrink releases n bytes reserved on behalf of the specified
+// transaction.
+func (b *MemoryBudget) shrink(txnID string, n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	if txnID != "" {
+		b.txns[txnID] -= n
+		if b.txns[txnID] <= 0 {
+			delete(b.txns, txnID)
+		}
+	}
+	b.inUse.Update(b.used)
+}
+
+// A MemoryAccount tracks the bytes reserved by a single request. It
+// is not safe for concurrent use.
+type MemoryAccount struct {
+	budget *MemoryBudget
+	txnID  string
+	used   int64
+}
+
+// Grow reserves n additional bytes, returning a
+// MemoryBudgetExceededError if the budget cannot accommodate them.
+func (a *MemoryAccount) Grow(n int64) error {
+	if err := a.budget.grow(a.txnID, n); err != nil {
+		return err
+	}
+	a.used += n
+	return nil
+}
+
+// Used returns the number of bytes reserved through the account.
+func (a *MemoryAccount) Used() int64 {
+	return a.used
+}
+
+// Close releases all bytes reserved through the account.
+func (a *MemoryAccount) Close() {
+	if a.used > 0 {
+		a.budget.shrink(a.txnID, a.used)
+		a.used = 0
+	}
+}
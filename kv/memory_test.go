@@ -0,0 +1,91 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestMemoryBudget verifies that reservations are tracked per
+// transaction and rejected once they would exceed the budget's limit.
+func TestMemoryBudget(t *testing.T) {
+	b := NewMemoryBudget(100)
+	txn := &proto.Transaction{ID: []byte("txn")}
+	a1 := b.NewAccount(txn)
+	a2 := b.NewAccount(txn)
+	a3 := b.NewAccount(nil)
+
+	if err := a1.Grow(40); err != nil {
+		t.Fatal(err)
+	}
+	if err := a2.Grow(30); err != nil {
+		t.Fatal(err)
+	}
+	if err := a3.Grow(20); err != nil {
+		t.Fatal(err)
+	}
+	if used, txnUsed := b.Used(), b.TxnUsed(txn.ID); used != 90 || txnUsed != 70 {
+		t.Errorf("expected 90 bytes used, 70 by txn; got %d, %d", used, txnUsed)
+	}
+
+	err := a3.Grow(11)
+	if bErr, ok := err.(*proto.MemoryBudgetExceededError); !ok {
+		t.Fatalf("expected a memory budget exceeded error; got %v", err)
+	} else if bErr.Requested != 11 || bErr.Used != 90 || bErr.Limit != 100 {
+		t.Errorf("unexpected error details: %+v", bErr)
+	}
+	if a3.Used() != 20 || b.Used() != 90 {
+		t.Errorf("rejected reservation should not be tracked; got %d, %d", a3.Used(), b.Used())
+	}
+	if count := b.rejected.Count(); count != 1 {
+		t.Errorf("expected 1 rejected reservation; got %d", count)
+	}
+
+	// Closing an account releases its bytes.
+	a1.Close()
+	if used, txnUsed := b.Used(), b.TxnUsed(txn.ID); used != 50 || txnUsed != 30 {
+		t.Errorf("expected 50 bytes used, 30 by txn; got %d, %d", used, txnUsed)
+	}
+	if err := a3.Grow(50); err != nil {
+		t.Fatal(err)
+	}
+	a2.Close()
+	a3.Close()
+	if used, txnUsed := b.Used(), b.TxnUsed(txn.ID); used != 0 || txnUsed != 0 {
+		t.Errorf("expected no bytes used; got %d, %d", used, txnUsed)
+	}
+	if len(b.txns) != 0 {
+		t.Errorf("expected no transactions tracked; got %v", b.txns)
+	}
+}
+
+// TestMemoryBudgetUnlimited verifies that a budget with a zero limit
+// tracks but never rejects reservations.
+func TestMemoryBudgetUnlimited(t *testing.T) {
+	b := NewMemoryBudget(0)
+	a := b.NewAccount(nil)
+	defer a.Close()
+	if err := a.Grow(1 << 40); err != nil {
+		t.Fatal(err)
+	}
+	if b.Used() != 1<<40 {
+		t.Errorf("expected %d bytes used; got %d", 1<<40, b.Used())
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestMutex verifies mutual exclusion, lease expiration, release and
+// monotonically increasing fencing tokens.
+func TestMutex(t *testing.T) {
+	db, _, _, manual, _, stopper := createTestDB(t)
+	defer stopper.Stop()
+
+	key := proto.Key("lock")
+	m1 := db.NewMutex(key, "m1")
+	m2 := db.NewMutex(key, "m2")
+
+	if ok, err := m1.TryLock(); !ok || err != nil {
+		t.Fatalf("expected m1 to acquire lock; got %t, %v", ok, err)
+	}
+	if m1.Token() != 1 {
+		t.Errorf("expected token 1; got %d", m1.Token())
+	}
+	if ok, err := m2.TryLock(); ok || err != nil {
+		t.Fatalf("expected m2 to fail to acquire held lock; got %t, %v", ok, err)
+	}
+
+	// Once m1's lease expires, m2 may acquire the lock.
+	manual.Set(m1.LeaseDuration.Nanoseconds() + 1)
+	if ok, err := m2.TryLock(); !ok || err != nil {
+		t.Fatalf("expected m2 to acquire expired lock; got %t, %v", ok, err)
+	}
+	if m2.Token() != 2 {
+		t.Errorf("expected token 2; got %d", m2.Token())
+	}
+
+	// After release, m1 may reacquire with a higher token. m1 must
+	// first release its stale hold on the lock.
+	if err := m2.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-m2.Done():
+	default:
+		t.Error("expected m2's done channel to be closed on unlock")
+	}
+	m1.Unlock() // releasing the stale lease has no effect on the record
+	if ok, err := m1.TryLock(); !ok || err != nil {
+		t.Fatalf("expected m1 to reacquire released lock; got %t, %v", ok, err)
+	}
+	if m1.Token() != 3 {
+		t.Errorf("expected token 3; got %d", m1.Token())
+	}
+	if err := m1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMutexLostLease verifies that a holder whose lease is taken by
+// another holder detects the loss when renewing.
+func TestMutexLostLease(t *testing.T) {
+	db, _, _, manual, _, stopper := createTestDB(t)
+	defer stopper.Stop()
+
+	key := proto.Key("lock")
+	m1 := db.NewMutex(key, "m1")
+	m1.LeaseDuration = 30 * time.Millisecond
+	m2 := db.NewMutex(key, "m2")
+	if ok, err := m1.TryLock(); !ok || err != nil {
+		t.Fatalf("expected m1 to acquire lock; got %t, %v", ok, err)
+	}
+	manual.Set(time.Second.Nanoseconds())
+	if ok, err := m2.TryLock(); !ok || err != nil {
+		t.Fatalf("expected m2 to acquire expired lock; got %t, %v", ok, err)
+	}
+	select {
+	case <-m1.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected m1 to detect loss of its lease")
+	}
+	if err := m1.Unlock(); err == nil {
+		t.Error("expected error unlocking lost lock")
+	}
+	if err := m2.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestElector verifies that exactly one of several candidates is
+// elected, and that leadership passes to another candidate when the
+// leader resigns.
+func TestElector(t *testing.T) {
+	db, _, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
+
+	key := proto.Key("election")
+	electors := map[string]*client.Elector{}
+	for _, id := range []string{"a", "b"} {
+		e := db.NewElector(key, id)
+		e.Mutex().LeaseDuration = 30 * time.Millisecond
+		electors[id] = e
+		e.Start()
+	}
+
+	waitForLeader := func() string {
+		var leader string
+		if err := util.IsTrueWithin(func() bool {
+			var count int
+			for id, e := range electors {
+				if e.IsLeader() {
+					leader = id
+					count++
+				}
+			}
+			return count == 1
+		}, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if id, err := electors[leader].Leader(); err != nil || id != leader {
+			t.Errorf("expected leader %q; got %q, %v", leader, id, err)
+		}
+		return leader
+	}
+
+	first := waitForLeader()
+	electors[first].Stop()
+	delete(electors, first)
+	if second := waitForLeader(); second == first {
+		t.Errorf("expected leadership to change from %q", first)
+	}
+	for _, e := range electors {
+		e.Stop()
+	}
+}
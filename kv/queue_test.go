@@ -0,0 +1,114 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestQueueFIFO verifies that messages are dequeued in order within
+// each shard, that every message is delivered exactly once while
+// visible, and that acknowledged messages are removed.
+func TestQueueFIFO(t *testing.T) {
+	db, _, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
+
+	q := db.NewQueue(proto.Key("queue-"), 2)
+	const count = 6
+	for i := 0; i < count; i++ {
+		if err := q.Enqueue([]byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Enqueues alternate between shards, so each shard holds every
+	// other message in order.
+	last := map[int]int{0: -2, 1: -1}
+	seen := map[string]bool{}
+	for i := 0; i < count; i++ {
+		msg, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil {
+			t.Fatalf("expected message %d; got none", i)
+		}
+		var n int
+		fmt.Sscanf(string(msg.Data), "%d", &n)
+		if seen[string(msg.Data)] {
+			t.Errorf("message %q delivered twice", msg.Data)
+		}
+		seen[string(msg.Data)] = true
+		if last[n%2]+2 != n {
+			t.Errorf("expected message %d after %d; got %d", last[n%2]+2, last[n%2], n)
+		}
+		last[n%2] = n
+		if msg.Attempts != 1 {
+			t.Errorf("expected first attempt; got %d", msg.Attempts)
+		}
+		if err := q.Ack(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg, err := q.Dequeue(); err != nil || msg != nil {
+		t.Errorf("expected empty queue; got %+v, %v", msg, err)
+	}
+}
+
+// TestQueueVisibilityTimeout verifies that a dequeued message is
+// hidden until its visibility timeout expires, after which it is
+// redelivered and the stale consumer can no longer acknowledge it.
+func TestQueueVisibilityTimeout(t *testing.T) {
+	db, _, _, manual, _, stopper := createTestDB(t)
+	defer stopper.Stop()
+
+	q := db.NewQueue(proto.Key("queue-"), 1)
+	q.VisibilityTimeout = 10 * time.Second
+	if err := q.Enqueue([]byte("work")); err != nil {
+		t.Fatal(err)
+	}
+	msg1, err := q.Dequeue()
+	if err != nil || msg1 == nil {
+		t.Fatalf("expected message; got %+v, %v", msg1, err)
+	}
+	if msg, err := q.Dequeue(); err != nil || msg != nil {
+		t.Fatalf("expected no visible message; got %+v, %v", msg, err)
+	}
+
+	manual.Set(q.VisibilityTimeout.Nanoseconds() + 1)
+	msg2, err := q.Dequeue()
+	if err != nil || msg2 == nil {
+		t.Fatalf("expected redelivered message; got %+v, %v", msg2, err)
+	}
+	if string(msg2.Data) != "work" || msg2.Attempts != 2 {
+		t.Errorf("unexpected redelivered message %+v", msg2)
+	}
+	if err := q.Ack(msg1); err == nil {
+		t.Error("expected error acknowledging message after visibility timeout")
+	}
+	if err := q.Ack(msg2); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := q.Dequeue(); err != nil || msg != nil {
+		t.Errorf("expected empty queue; got %+v, %v", msg, err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/metric"
 )
 
 const (
@@ -76,21 +77,38 @@ type RangeDescriptorCache struct {
 	rangeCache *util.OrderedCache
 	// rangeCacheMu protects rangeCache for concurrent access
 	rangeCacheMu sync.RWMutex
+
+	registry  *metric.Registry
+	hits      *metric.Counter // Lookups satisfied by the cache
+	misses    *metric.Counter // Lookups which queried rangeDescriptorDB
+	evictions *metric.Counter // Descriptors evicted as stale
+	updates   *metric.Counter // Descriptors cached from gossiped range events
 }
 
 // NewRangeDescriptorCache returns a new RangeDescriptorCache which
 // uses the given rangeDescriptorDB as the underlying source of range
 // descriptors.
 func NewRangeDescriptorCache(db rangeDescriptorDB) *RangeDescriptorCache {
+	r := metric.NewRegistry()
 	return &RangeDescriptorCache{
 		db: db,
 		rangeCache: util.NewOrderedCache(util.CacheConfig{
 			Policy:      util.CacheLRU,
 			ShouldEvict: rangeCacheShouldEvict,
 		}),
+		registry:  r,
+		hits:      r.Counter("hits"),
+		misses:    r.Counter("misses"),
+		evictions: r.Counter("evictions"),
+		updates:   r.Counter("updates"),
 	}
 }
 
+// Registry returns the registry of range cache metrics.
+func (rmc *RangeDescriptorCache) Registry() *metric.Registry {
+	return rmc.registry
+}
+
 // LookupRangeDescriptor attempts to locate a descriptor for the range
 // containing the given Key. This is done by querying the two-level
 // lookup table of range descriptors which cockroach maintains.
@@ -105,21 +123,30 @@ func NewRangeDescriptorCache(db rangeDescriptorDB) *RangeDescriptorCache {
 // This method returns the RangeDescriptor for the range containing
 // the key's data, or an error if any occurred.
 func (rmc *RangeDescriptorCache) LookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error) {
+	desc, _, err := rmc.lookupRangeDescriptor(key)
+	return desc, err
+}
+
+// lookupRangeDescriptor is LookupRangeDescriptor, additionally
+// returning whether the descriptor was found in the cache.
+func (rmc *RangeDescriptorCache) lookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, bool, error) {
 	_, r := rmc.getCachedRangeDescriptor(key)
 	if r != nil {
-		return r, nil
+		rmc.hits.Inc(1)
+		return r, true, nil
 	}
 
+	rmc.misses.Inc(1)
 	rs, err := rmc.db.getRangeDescriptor(key)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	rmc.rangeCacheMu.Lock()
 	for i := range rs {
 		rmc.rangeCache.Add(rangeCacheKey(engine.RangeMetaLookupKey(&rs[i])), &rs[i])
 	}
 	rmc.rangeCacheMu.Unlock()
-	return &rs[0], nil
+	return &rs[0], false, nil
 }
 
 // EvictCachedRangeDescriptor will evict any cached range descriptors
@@ -133,6 +160,7 @@ func (rmc *RangeDescriptorCache) EvictCachedRangeDescriptor(key proto.Key) {
 			rmc.rangeCacheMu.Lock()
 			rmc.rangeCache.Del(k)
 			rmc.rangeCacheMu.Unlock()
+			rmc.evictions.Inc(1)
 		}
 		// Retrieve the metadata range key for the next level of metadata, and
 		// evict that key as well. This loop ends after the meta1 range, which
@@ -144,6 +172,38 @@ func (rmc *RangeDescriptorCache) EvictCachedRangeDescriptor(key proto.Key) {
 	}
 }
 
+// updateCachedRangeDescriptors caches descs, the descriptors of ranges
+// whose boundaries recently changed, evicting any cached descriptors
+// which overlap them. Cached descriptors are ordered by the metadata
+// keys of their end keys, so the overlapping descriptors are found by
+// scanning from the start key of each desc until a cached descriptor
+// starts at or after its end key.
+func (rmc *RangeDescriptorCache) updateCachedRangeDescriptors(descs []proto.RangeDescriptor) {
+	rmc.rangeCacheMu.Lock()
+	defer rmc.rangeCacheMu.Unlock()
+	for i := range descs {
+		desc := &descs[i]
+		key := rangeCacheKey(engine.RangeMetaKey(desc.StartKey))
+		for {
+			k, v, ok := rmc.rangeCache.Ceil(key)
+			if !ok {
+				break
+			}
+			cached := v.(*proto.RangeDescriptor)
+			if !cached.StartKey.Less(desc.EndKey) {
+				break
+			}
+			if desc.StartKey.Less(cached.EndKey) {
+				rmc.rangeCache.Del(k)
+				rmc.evictions.Inc(1)
+			}
+			key = rangeCacheKey(proto.Key(k.(rangeCacheKey)).Next())
+		}
+		rmc.rangeCache.Add(rangeCacheKey(engine.RangeMetaLookupKey(desc)), desc)
+		rmc.updates.Inc(1)
+	}
+}
+
 // getCachedRangeDescriptor is a helper function to retrieve the
 // descriptor of the range which contains the given key, if present in
 // the cache.
@@ -182,3 +182,94 @@ func TestRangeCache(t *testing.T) {
 	doLookup(t, rangeCache, "da")
 	db.assertHitCount(t, 2)
 }
+
+// TestRangeCacheMetrics verifies that lookups through the range cache
+// are counted as hits or misses, and that evicted descriptors are
+// counted.
+func TestRangeCacheMetrics(t *testing.T) {
+	db := newTestDescriptorDB()
+	for _, char := range "abcdefghij" {
+		db.splitRange(t, proto.Key(string(char)))
+	}
+	db.splitRange(t, engine.RangeMetaKey(proto.Key("d")))
+
+	rangeCache := NewRangeDescriptorCache(db)
+	db.cache = rangeCache
+
+	expect := func(hits, misses, evictions int64) {
+		if c := rangeCache.hits.Count(); c != hits {
+			t.Errorf("expected %d hits; got %d", hits, c)
+		}
+		if c := rangeCache.misses.Count(); c != misses {
+			t.Errorf("expected %d misses; got %d", misses, c)
+		}
+		if c := rangeCache.evictions.Count(); c != evictions {
+			t.Errorf("expected %d evictions; got %d", evictions, c)
+		}
+	}
+
+	// The lookup and its recursive meta2 lookup both miss.
+	doLookup(t, rangeCache, "aa")
+	expect(0, 2, 0)
+	doLookup(t, rangeCache, "ab")
+	expect(1, 2, 0)
+	// Misses, but the meta2 descriptor is cached.
+	doLookup(t, rangeCache, "ea")
+	expect(2, 3, 0)
+
+	// Eviction clears the descriptor and its meta2 descriptor.
+	rangeCache.EvictCachedRangeDescriptor(proto.Key("ea"))
+	expect(2, 3, 2)
+	doLookup(t, rangeCache, "ea")
+	expect(2, 5, 2)
+}
+
+// TestRangeCacheUpdate verifies that descriptors from range events
+// replace the cached descriptors which they overlap.
+func TestRangeCacheUpdate(t *testing.T) {
+	db := newTestDescriptorDB()
+	for _, char := range "egk" {
+		db.splitRange(t, proto.Key(string(char)))
+	}
+	rangeCache := NewRangeDescriptorCache(db)
+	db.cache = rangeCache
+
+	expect := func(key string, start, end proto.Key) {
+		_, desc := rangeCache.getCachedRangeDescriptor(proto.Key(key))
+		if desc == nil || !desc.StartKey.Equal(start) || !desc.EndKey.Equal(end) {
+			t.Errorf("expected [%q,%q) to be cached for %q; got %+v", start, end, key, desc)
+		}
+	}
+
+	// Caches [MetaMax,e), [e,g) and [g,k).
+	doLookup(t, rangeCache, "a")
+	db.assertHitCount(t, 2)
+
+	// [e,g) splits at f; its neighbors are unaffected.
+	rangeCache.updateCachedRangeDescriptors([]proto.RangeDescriptor{
+		{StartKey: proto.Key("e"), EndKey: proto.Key("f")},
+		{StartKey: proto.Key("f"), EndKey: proto.Key("g")},
+	})
+	expect("a", engine.KeyMetaMax, proto.Key("e"))
+	expect("ea", proto.Key("e"), proto.Key("f"))
+	expect("fa", proto.Key("f"), proto.Key("g"))
+	expect("ga", proto.Key("g"), proto.Key("k"))
+
+	// A merge of [MetaMax,e) through [f,g) replaces all three.
+	rangeCache.updateCachedRangeDescriptors([]proto.RangeDescriptor{
+		{StartKey: engine.KeyMetaMax, EndKey: proto.Key("g")},
+	})
+	for _, key := range []string{"a", "ea", "fa"} {
+		expect(key, engine.KeyMetaMax, proto.Key("g"))
+	}
+	expect("ga", proto.Key("g"), proto.Key("k"))
+	if c := rangeCache.updates.Count(); c != 3 {
+		t.Errorf("expected 3 updates; got %d", c)
+	}
+	if c := rangeCache.evictions.Count(); c != 4 {
+		t.Errorf("expected 4 evictions; got %d", c)
+	}
+	// The meta2 descriptor remains cached.
+	doLookup(t, rangeCache, "ka")
+	db.assertHitCount(t, 1)
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv
+
+import (
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// LookupRange returns the descriptor of the range containing key,
+// which includes the range's boundaries and replicas. The descriptor
+// is read from the meta1 or meta2 addressing records via an ordinary
+// scan, so any client, local or remote, may use it; the result is
+// consistent at the time of the scan but may be stale by the time
+// it's used. Range leadership is not recorded in the addressing
+// records and so is not reported; a command sent to a replica which
+// isn't the leader fails with a NotLeaderError naming the leader, if
+// known.
+func LookupRange(kvDB *client.KV, key proto.Key) (*proto.RangeDescriptor, error) {
+	addr := engine.KeyAddress(key)
+	if !addr.Less(engine.KeyMax) {
+		return nil, util.Errorf("key %q is not addressable", key)
+	}
+	// The descriptor of the range containing key is the first
+	// addressing record following key's metadata key. Keys in the
+	// meta1 range have an empty metadata key; the first range's
+	// descriptor is the first meta1 record.
+	metaKey := engine.RangeMetaKey(addr)
+	start := engine.KeyMeta1Prefix
+	if len(metaKey) > 0 {
+		start = metaKey.Next()
+	}
+	metaPrefix := proto.Key(start[:len(engine.KeyMeta1Prefix)])
+	reply := &proto.ScanResponse{}
+	if err := kvDB.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:    start,
+			EndKey: metaPrefix.PrefixEnd(),
+		},
+		MaxResults: 1,
+	}, reply); err != nil {
+		return nil, err
+	}
+	if len(reply.Rows) == 0 {
+		return nil, util.Errorf("no range addressing record found for key %q", key)
+	}
+	desc := &proto.RangeDescriptor{}
+	if err := gogoproto.Unmarshal(reply.Rows[0].Value.Bytes, desc); err != nil {
+		return nil, err
+	}
+	if !desc.ContainsKey(addr) {
+		return nil, util.Errorf("addressing record for key %q has descriptor %s-%s which doesn't contain it",
+			key, desc.StartKey, desc.EndKey)
+	}
+	return desc, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package kv
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestLookupRange verifies that range descriptors are looked up from
+// the addressing records, before and after a split.
+func TestLookupRange(t *testing.T) {
+	db, _, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
+
+	desc, err := LookupRange(db, proto.Key("m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !desc.StartKey.Equal(engine.KeyMin) || !desc.EndKey.Equal(engine.KeyMax) || len(desc.Replicas) != 1 {
+		t.Fatalf("unexpected descriptor of first range: %+v", desc)
+	}
+
+	splitKey := proto.Key("m")
+	req := &proto.AdminSplitRequest{RequestHeader: proto.RequestHeader{Key: splitKey}, SplitKey: splitKey}
+	if err := db.Call(proto.AdminSplit, req, &proto.AdminSplitResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		key              proto.Key
+		startKey, endKey proto.Key
+	}{
+		{engine.KeyMin, engine.KeyMin, splitKey},
+		{engine.RangeMetaKey(proto.Key("z")), engine.KeyMin, splitKey},
+		{engine.MakeKey(engine.KeyMeta1Prefix, proto.Key("z")), engine.KeyMin, splitKey},
+		{proto.Key("a"), engine.KeyMin, splitKey},
+		{proto.Key("l\xff"), engine.KeyMin, splitKey},
+		{splitKey, splitKey, engine.KeyMax},
+		{proto.Key("z"), splitKey, engine.KeyMax},
+	}
+	for i, test := range testCases {
+		desc, err := LookupRange(db, test.key)
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+			continue
+		}
+		if !desc.StartKey.Equal(test.startKey) || !desc.EndKey.Equal(test.endKey) {
+			t.Errorf("%d: expected range %q-%q for key %q; got %q-%q",
+				i, test.startKey, test.endKey, test.key, desc.StartKey, desc.EndKey)
+		}
+	}
+
+	if _, err := LookupRange(db, engine.KeyMax); err == nil {
+		t.Error("expected lookup of KeyMax to fail")
+	}
+}
@@ -18,6 +18,7 @@
 package kv
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -28,7 +29,8 @@ import (
 
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
-	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -97,7 +99,14 @@ func NewRESTServer(db *client.KV) *RESTServer {
 
 // ServeHTTP satisfies the http.Handler interface and arbitrates requests
 // to the appropriate function based on the request’s HTTP method.
+// Requests are executed on behalf of the user authenticated by the
+// server, as specified by the util.UserHeader request header.
+// Unauthenticated requests are rejected.
 func (s *RESTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if requestUser(r) == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
 	for endPoint, epRoutes := range routingTable {
 		if strings.HasPrefix(r.URL.Path, endPoint) {
 			epHandler := epRoutes[r.Method]
@@ -112,6 +121,12 @@ func (s *RESTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 }
 
+// requestUser returns the user authenticated for the request, or
+// the empty string if the request was not authenticated.
+func requestUser(r *http.Request) string {
+	return r.Header.Get(util.UserHeader)
+}
+
 // writeJSON marshals v to JSON and writes the result to w with
 // the given status code.
 func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
@@ -149,11 +164,45 @@ func dbKey(path, apiPrefix string) (proto.Key, error) {
 }
 
 const (
-	rangeParamStart = "start"
-	rangeParamEnd   = "end"
-	rangeParamLimit = "limit"
+	rangeParamStart  = "start"
+	rangeParamEnd    = "end"
+	rangeParamLimit  = "limit"
+	rangeParamResume = "resume"
 )
 
+// A scanResult is the response to a GET of a range of keys. If the
+// scan returned as many rows as its limit, more rows may follow, and
+// ResumeToken is set to an opaque token. Passed as the resume
+// parameter of a request for the same range, the token resumes the
+// scan after the last row returned, reading as of the same timestamp
+// so that all pages are drawn from a consistent snapshot.
+type scanResult struct {
+	*proto.ScanResponse
+	ResumeToken string `json:"resume_token,omitempty"`
+}
+
+// encodeResumeToken encodes the key at which a paginated scan resumes
+// and the timestamp as of which its pages are read.
+func encodeResumeToken(key proto.Key, timestamp proto.Timestamp) string {
+	b := encoding.EncodeUint64(nil, uint64(timestamp.WallTime))
+	b = encoding.EncodeUint32(b, uint32(timestamp.Logical))
+	return base64.URLEncoding.EncodeToString(append(b, key...))
+}
+
+// decodeResumeToken decodes a token encoded by encodeResumeToken.
+func decodeResumeToken(token string) (proto.Key, proto.Timestamp, error) {
+	b, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, proto.Timestamp{}, err
+	}
+	if len(b) < 12 {
+		return nil, proto.Timestamp{}, errors.New("truncated resume token")
+	}
+	b, wallTime := encoding.DecodeUint64(b)
+	b, logical := encoding.DecodeUint32(b)
+	return proto.Key(b), proto.Timestamp{WallTime: int64(wallTime), Logical: int32(logical)}, nil
+}
+
 func (s *RESTServer) handleRangeAction(w http.ResponseWriter, r *http.Request) {
 	// TODO(andybons): Allow the client to specify range parameters via
 	// request headers as well, allowing query parameters to override the
@@ -185,23 +234,46 @@ func (s *RESTServer) handleRangeAction(w http.ResponseWriter, r *http.Request) {
 	reqHeader := proto.RequestHeader{
 		Key:    startKey,
 		EndKey: endKey,
-		User:   storage.UserRoot,
+		User:   requestUser(r),
 	}
-	var results proto.Response
+	var results interface{}
 	if r.Method == methodGet {
 		scanReq := &proto.ScanRequest{RequestHeader: reqHeader}
 		if limit > 0 {
 			scanReq.MaxResults = limit
 		}
-		results = &proto.ScanResponse{}
-		err = s.db.Call(proto.Scan, scanReq, results)
+		if token := r.FormValue(rangeParamResume); len(token) > 0 {
+			key, timestamp, err := decodeResumeToken(token)
+			if err != nil {
+				http.Error(w, "error parsing resume token: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if key.Less(startKey) || endKey.Less(key) {
+				http.Error(w, "resume token doesn't match the requested range", http.StatusBadRequest)
+				return
+			}
+			scanReq.Key, scanReq.Timestamp = key, timestamp
+		}
+		scan := &proto.ScanResponse{}
+		err = s.db.Call(proto.Scan, scanReq, scan)
+		if _, ok := err.(*proto.ReadBeforeGCThresholdError); ok {
+			// The snapshot of the paginated scan has expired.
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		result := scanResult{ScanResponse: scan}
+		if limit > 0 && int64(len(scan.Rows)) == limit {
+			result.ResumeToken = encodeResumeToken(scan.Rows[len(scan.Rows)-1].Key.Next(), scan.Timestamp)
+		}
+		results = result
 	} else if r.Method == methodDelete {
 		deleteReq := &proto.DeleteRangeRequest{RequestHeader: reqHeader}
 		if limit > 0 {
 			deleteReq.MaxEntriesToDelete = limit
 		}
-		results = &proto.DeleteRangeResponse{}
-		err = s.db.Call(proto.DeleteRange, deleteReq, results)
+		deleteResp := &proto.DeleteRangeResponse{}
+		err = s.db.Call(proto.DeleteRange, deleteReq, deleteResp)
+		results = deleteResp
 	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -232,7 +304,7 @@ func (s *RESTServer) handleCounterAction(w http.ResponseWriter, r *http.Request,
 	if err := s.db.Call(proto.Increment, &proto.IncrementRequest{
 		RequestHeader: proto.RequestHeader{
 			Key:  key,
-			User: storage.UserRoot,
+			User: requestUser(r),
 		},
 		Increment: inputVal,
 	}, ir); err != nil {
@@ -253,7 +325,7 @@ func (s *RESTServer) handlePutAction(w http.ResponseWriter, r *http.Request, key
 	if err := s.db.Call(proto.Put, &proto.PutRequest{
 		RequestHeader: proto.RequestHeader{
 			Key:  key,
-			User: storage.UserRoot,
+			User: requestUser(r),
 		},
 		Value: proto.Value{Bytes: b},
 	}, pr); err != nil {
@@ -263,13 +335,18 @@ func (s *RESTServer) handlePutAction(w http.ResponseWriter, r *http.Request, key
 	writeJSON(w, http.StatusOK, pr)
 }
 
+// entryParamPath is the parameter of a GET of an entry which selects
+// the element of the entry's JSON document to return in its place.
+const entryParamPath = "path"
+
 func (s *RESTServer) handleGetAction(w http.ResponseWriter, r *http.Request, key proto.Key) {
 	gr := &proto.GetResponse{}
 	if err := s.db.Call(proto.Get, &proto.GetRequest{
 		RequestHeader: proto.RequestHeader{
 			Key:  key,
-			User: storage.UserRoot,
+			User: requestUser(r),
 		},
+		JSONPath: r.FormValue(entryParamPath),
 	}, gr); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -287,7 +364,7 @@ func (s *RESTServer) handleHeadAction(w http.ResponseWriter, r *http.Request, ke
 	if err := s.db.Call(proto.Contains, &proto.ContainsRequest{
 		RequestHeader: proto.RequestHeader{
 			Key:  key,
-			User: storage.UserRoot,
+			User: requestUser(r),
 		},
 	}, cr); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -305,7 +382,7 @@ func (s *RESTServer) handleDeleteAction(w http.ResponseWriter, r *http.Request,
 	if err := s.db.Call(proto.Delete, &proto.DeleteRequest{
 		RequestHeader: proto.RequestHeader{
 			Key:  key,
-			User: storage.UserRoot,
+			User: requestUser(r),
 		},
 	}, dr); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
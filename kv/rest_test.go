@@ -24,6 +24,7 @@ package kv_test
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -42,26 +43,42 @@ import (
 	"github.com/cockroachdb/cockroach/server"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 )
 
 // startServer returns the server, server address and a KV client for
 // access to the underlying database. The server should be closed by
 // the caller.
 func startServer(t *testing.T) (string, *httptest.Server, *client.KV) {
+	return startServerWithBudget(t, NewMemoryBudget(0))
+}
+
+// startServerWithBudget is like startServer, but reserves memory for
+// key-value requests against the supplied budget.
+func startServerWithBudget(t *testing.T, budget *MemoryBudget) (string, *httptest.Server, *client.KV) {
 	// Initialize engine, store, and localDB.
 	e := engine.NewInMem(proto.Attributes{}, 1<<20)
-	db, err := server.BootstrapCluster("test-cluster", e)
+	db, err := server.BootstrapCluster("test-cluster", e, util.NewStopper())
 	if err != nil {
 		t.Fatalf("could not bootstrap test cluster: %s", err)
 	}
 	mux := http.NewServeMux()
 	mux.Handle(RESTPrefix, NewRESTServer(db))
-	mux.Handle(DBPrefix, NewDBServer(db.Sender()))
-	server := httptest.NewServer(mux)
+	mux.Handle(DBPrefix, NewDBServer(db.Sender(), budget))
+	server := httptest.NewServer(authenticateRoot(mux))
 	addr := server.Listener.Addr().String()
 	return addr, server, db
 }
 
+// authenticateRoot wraps handler, executing all requests as the root
+// user in place of the server's authentication.
+func authenticateRoot(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(util.UserHeader, storage.UserRoot)
+		handler.ServeHTTP(w, r)
+	})
+}
+
 // HTTP methods, defined in RFC 2616.
 const (
 	methodGet     = "GET"
@@ -249,6 +266,102 @@ func TestRange(t *testing.T) {
 	}
 }
 
+// TestRangePagination verifies that a scan paged through with resume
+// tokens reads all pages as of the timestamp of the first.
+func TestRangePagination(t *testing.T) {
+	addr, server, _ := startServer(t)
+	defer server.Close()
+
+	baseURL := "http://" + addr
+	for i := 0; i < 30; i++ {
+		postURL(fmt.Sprintf("%s%skey_%.2d", baseURL, EntryPrefix, i), strings.NewReader(fmt.Sprintf("value_%.2d", i)), t)
+	}
+	type pagedScan struct {
+		proto.ScanResponse
+		ResumeToken string `json:"resume_token"`
+	}
+	getPage := func(token string) pagedScan {
+		pageURL := fmt.Sprintf("%s%s?start=key_00&end=key_99&limit=10&resume=%s", baseURL, RangePrefix, url.QueryEscape(token))
+		var page pagedScan
+		if err := json.NewDecoder(strings.NewReader(getURL(pageURL, t))).Decode(&page); err != nil {
+			t.Fatalf("unable to decode JSON into scan page: %s", err)
+		}
+		return page
+	}
+
+	var rows []proto.KeyValue
+	page := getPage("")
+	for page.ResumeToken != "" {
+		rows = append(rows, page.Rows...)
+		// Writes after the first page aren't visible to later pages.
+		postURL(baseURL+EntryPrefix+"key_25", strings.NewReader("overwritten"), t)
+		postURL(baseURL+EntryPrefix+"key_27a", strings.NewReader("inserted"), t)
+		page = getPage(page.ResumeToken)
+	}
+	rows = append(rows, page.Rows...)
+	if len(rows) != 30 {
+		t.Fatalf("expected 30 rows; got %d", len(rows))
+	}
+	for i, row := range rows {
+		verifyRangeRowIsGood(i, i, row, t)
+	}
+
+	// Malformed tokens and tokens outside of the range are rejected.
+	outside := base64.URLEncoding.EncodeToString(append(make([]byte, 12), 'a'))
+	for _, token := range []string{"not a token", "AAAA", outside} {
+		path := fmt.Sprintf("%s?start=key_00&end=key_99&resume=%s", RangePrefix, url.QueryEscape(token))
+		resp, err := httpDo(addr, methodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d for token %q; got %d", http.StatusBadRequest, token, resp.StatusCode)
+		}
+	}
+}
+
+// TestJSONDocuments verifies that JSON documents may be stored and
+// read whole or in part via the client and the REST endpoint.
+func TestJSONDocuments(t *testing.T) {
+	addr, server, db := startServer(t)
+	defer server.Close()
+
+	type address struct {
+		City string `json:"city"`
+	}
+	type user struct {
+		Name    string  `json:"name"`
+		Address address `json:"address"`
+	}
+	key := proto.Key("users/1")
+	if err := db.PutJSON(key, user{Name: "ada", Address: address{City: "london"}}); err != nil {
+		t.Fatal(err)
+	}
+	var u user
+	if ok, _, err := db.GetJSON(key, &u); !ok || err != nil || u.Address.City != "london" {
+		t.Errorf("expected user in london; got %+v, %t, %v", u, ok, err)
+	}
+	var city string
+	if ok, _, err := db.GetJSONPath(key, "$.address.city", &city); !ok || err != nil || city != "london" {
+		t.Errorf("expected london; got %q, %t, %v", city, ok, err)
+	}
+	var missing interface{}
+	if ok, _, err := db.GetJSONPath(key, "$.email", &missing); !ok || err != nil || missing != nil {
+		t.Errorf("expected null for a missing field; got %v, %t, %v", missing, ok, err)
+	}
+
+	// The REST endpoint returns only the requested field.
+	entryURL := fmt.Sprintf("http://%s%s%s?path=%s", addr, EntryPrefix, key, url.QueryEscape("$.name"))
+	var gr proto.GetResponse
+	if err := json.NewDecoder(strings.NewReader(getURL(entryURL, t))).Decode(&gr); err != nil {
+		t.Fatal(err)
+	}
+	if gr.Value == nil || string(gr.Value.Bytes) != `"ada"` {
+		t.Errorf("expected \"ada\"; got %+v", gr.Value)
+	}
+}
+
 // verifyRangeRowIsGood tests whether a row at a given index i holds the
 // appropriate values key_<n> -> value_<n> or key_<n> -> <counter value n>
 // in the case where n is greater than zero and a multiple of ten. This
@@ -90,7 +90,8 @@ func startTestWriter(db *client.KV, i int64, valBytes int32, wg *sync.WaitGroup,
 // 10 concurrent goroutines are each running successive transactions
 // composed of a random mix of puts.
 func TestRangeSplitsWithConcurrentTxns(t *testing.T) {
-	db, _, _, _, _ := createTestDB(t)
+	db, _, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	defer db.Close()
 
 	// This channel shuts the whole apparatus down.
@@ -136,7 +137,8 @@ func TestRangeSplitsWithConcurrentTxns(t *testing.T) {
 // TestRangeSplitsWithWritePressure sets the zone config max bytes for
 // a range to 256K and writes data until there are five ranges.
 func TestRangeSplitsWithWritePressure(t *testing.T) {
-	db, eng, _, _, _ := createTestDB(t)
+	db, eng, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	defer db.Close()
 	setTestRetryOptions()
 
@@ -605,7 +605,8 @@ func checkConcurrency(name string, isolations []proto.IsolationType, txns []stri
 	verify *verifier, expSuccess bool, t *testing.T) {
 	setCorrectnessRetryOptions()
 	verifier := newHistoryVerifier(name, txns, verify, expSuccess, t)
-	db, _, _, _, _ := createTestDB(t)
+	db, _, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	verifier.run(isolations, db, t)
 }
 
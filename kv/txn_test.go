@@ -40,7 +40,8 @@ import (
 // uncommitted writes cannot be read outside of the txn but can be
 // read from inside the txn.
 func TestTxnDBBasics(t *testing.T) {
-	db, _, _, _, _ := createTestDB(t)
+	db, _, _, _, _, stopper := createTestDB(t)
+	defer stopper.Stop()
 	value := []byte("value")
 
 	for _, commit := range []bool{true, false} {
@@ -106,8 +107,9 @@ func TestTxnDBBasics(t *testing.T) {
 // the maximumOffset given, verifying in the process that the correct values
 // are read (usually after one transaction restart).
 func verifyUncertainty(concurrency int, maxOffset time.Duration, t *testing.T) {
-	db, _, clock, manualClock, lSender := createTestDB(t)
-	*manualClock = 0
+	db, _, clock, manualClock, lSender, stopper := createTestDB(t)
+	defer stopper.Stop()
+	manualClock.Set(0)
 
 	txnOpts := &client.TransactionOptions{
 		Name: "test",
@@ -160,7 +162,7 @@ func verifyUncertainty(concurrency int, maxOffset time.Duration, t *testing.T) {
 			// Wait until the other goroutines are running.
 			wgStart.Wait()
 
-			txnManual := hlc.ManualClock(futureTS.WallTime)
+			txnManual := hlc.NewManualClock(futureTS.WallTime)
 			txnClock := hlc.NewClock(txnManual.UnixNano)
 			// Make sure to incorporate the logical component if the wall time
 			// hasn't changed (i=0). The logical component will change
@@ -172,7 +174,7 @@ func verifyUncertainty(concurrency int, maxOffset time.Duration, t *testing.T) {
 			// higher values require roughly offset/5 restarts.
 			txnClock.SetMaxOffset(maxOffset)
 
-			sender := NewCoordinator(lSender, txnClock)
+			sender := NewCoordinator(lSender, txnClock, stopper)
 			txnDB := client.NewKV(sender, nil)
 			txnDB.User = storage.UserRoot
 
@@ -240,3 +242,69 @@ func TestTxnDBUncertainty(t *testing.T) {
 	verifyUncertainty(7, 12*time.Nanosecond, t)
 	verifyUncertainty(100, 10*time.Nanosecond, t)
 }
+
+// TestTxnDBIsolationPush verifies that a transaction whose timestamp
+// is pushed forward, either via the timestamp cache by a later read
+// or by a later committed write (WriteTooOldError), commits at the
+// pushed timestamp without restart. SERIALIZABLE transactions do so
+// only if their reads can be refreshed at the pushed timestamp, and
+// are restarted if a key they read has since been written.
+func TestTxnDBIsolationPush(t *testing.T) {
+	for i, test := range []struct {
+		isolation proto.IsolationType
+		write     bool // conflicting op is a write (vs. a read)
+		readWrite bool // the key read by the txn is written
+		expCount  int  // expected invocations of the txn closure
+	}{
+		{proto.SERIALIZABLE, false, false, 1},
+		{proto.SERIALIZABLE, true, false, 1},
+		{proto.SERIALIZABLE, false, true, 2},
+		{proto.SERIALIZABLE, true, true, 2},
+		{proto.SNAPSHOT, false, false, 1},
+		{proto.SNAPSHOT, true, false, 1},
+		{proto.SNAPSHOT, false, true, 1},
+	} {
+		db, _, _, manual, _, stopper := createTestDB(t)
+		key := proto.Key("a")
+		count := 0
+		txnOpts := &client.TransactionOptions{Name: "test", Isolation: test.isolation}
+		err := db.RunTransaction(txnOpts, func(txn *client.KV) error {
+			count++
+			// Begin the txn with a read of an unrelated key.
+			if err := txn.Call(proto.Get, proto.GetArgs(proto.Key("b")), &proto.GetResponse{}); err != nil {
+				return err
+			}
+			// On the first attempt, access the key outside of the txn at a
+			// later timestamp.
+			if count == 1 {
+				manual.Set(int64(i*10 + 10))
+				if test.readWrite {
+					if err := db.Call(proto.Put, proto.PutArgs(proto.Key("b"), []byte("other")), &proto.PutResponse{}); err != nil {
+						return err
+					}
+				}
+				if test.write {
+					if err := db.Call(proto.Put, proto.PutArgs(key, []byte("other")), &proto.PutResponse{}); err != nil {
+						return err
+					}
+				} else {
+					if err := db.Call(proto.Get, proto.GetArgs(key), &proto.GetResponse{}); err != nil {
+						return err
+					}
+				}
+			}
+			return txn.Call(proto.Put, proto.PutArgs(key, []byte("txn")), &proto.PutResponse{})
+		})
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+		if count != test.expCount {
+			t.Errorf("%d: expected %d txn invocations; got %d", i, test.expCount, count)
+		}
+		gr := &proto.GetResponse{}
+		if err := db.Call(proto.Get, proto.GetArgs(key), gr); err != nil || gr.Value == nil || !bytes.Equal(gr.Value.Bytes, []byte("txn")) {
+			t.Errorf("%d: expected txn value; got %+v, %v", i, gr.Value, err)
+		}
+		stopper.Stop()
+	}
+}
@@ -58,12 +58,24 @@ func main() {
 	c := commander.Commander{
 		Name: "cockroach",
 		Commands: []*commander.Command{
+			server.CmdConformance,
+			server.CmdDebugAllocSim,
+			server.CmdDebugCheck,
+			server.CmdDebugKey,
+			server.CmdDebugRaft,
+			server.CmdDebugScan,
+			server.CmdExportMetadata,
+			server.CmdImportMetadata,
 			server.CmdInit,
+			server.CmdRecoverRange,
+			server.CmdReloadCerts,
 			server.CmdGetZone,
 			server.CmdLsZones,
 			server.CmdRmZone,
 			server.CmdSetZone,
+			server.CmdShell,
 			server.CmdStart,
+			server.CmdNewAuthToken,
 			&commander.Command{
 				UsageLine: "listparams",
 				Short:     "list all available parameters and their default values",
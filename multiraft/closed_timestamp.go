@@ -0,0 +1,177 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package multiraft
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// A ClosedTimestamp is a timestamp closed by the leader of a group,
+// together with the index of the last entry in the leader's log when
+// it was closed. No command proposed at or below the timestamp follows
+// that entry, so a follower may serve reads at the timestamp once it
+// has applied the entry.
+type ClosedTimestamp struct {
+	Timestamp proto.Timestamp
+	Index     uint64
+}
+
+// closedTimestamps tracks the timestamps closed in a group. All of it
+// is reset when the group's leader changes, as a new leader isn't
+// bound by the timestamps closed by its predecessor.
+type closedTimestamps struct {
+	lastIndex    uint64 // Index of the last entry appended to the log
+	appliedIndex uint64 // Index of the last committed entry sent to the application
+
+	// While this node leads the group, proposals counts the commands it
+	// has proposed and logged those of them appended to its log. A
+	// timestamp closed by the application remains pending until the
+	// commands proposed before it have been logged, as only then is
+	// lastIndex known to cover them.
+	proposals int
+	logged    int
+	pending   *pendingClose
+	closed    *ClosedTimestamp // Sent to followers on heartbeats
+
+	// received holds the timestamps closed by the leader which have yet
+	// to be reported to the application, in order, and lastReceived the
+	// latest of all those received, as heartbeats repeat it.
+	received     []ClosedTimestamp
+	lastReceived proto.Timestamp
+}
+
+// pendingClose is a timestamp closed by the application which awaits
+// the logging of the commands proposed before it.
+type pendingClose struct {
+	timestamp proto.Timestamp
+	proposals int
+}
+
+type closeTimestampOp struct {
+	groupID   uint64
+	timestamp proto.Timestamp
+	ch        chan error
+}
+
+// closeTimestamp records a timestamp closed by the application, which
+// must lead the group.
+func (s *state) closeTimestamp(op *closeTimestampOp) {
+	g, ok := s.groups[op.groupID]
+	if !ok {
+		op.ch <- util.Errorf("group %v not found", op.groupID)
+		return
+	}
+	if g.softState.Lead != s.nodeID {
+		op.ch <- util.Errorf("node %v is not the leader of group %v", s.nodeID, op.groupID)
+		return
+	}
+	g.closedTS.pending = &pendingClose{op.timestamp, g.closedTS.proposals}
+	s.maybeCloseTimestamp(g)
+	op.ch <- nil
+}
+
+// maybeCloseTimestamp closes the pending timestamp once the commands
+// proposed before it have been logged.
+func (s *state) maybeCloseTimestamp(g *group) {
+	ts := &g.closedTS
+	if ts.pending == nil || ts.logged < ts.pending.proposals {
+		return
+	}
+	if ts.closed == nil || ts.closed.Timestamp.Less(ts.pending.timestamp) {
+		ts.closed = &ClosedTimestamp{ts.pending.timestamp, ts.lastIndex}
+	}
+	ts.pending = nil
+}
+
+// observeProposal counts a command proposed by this node if it leads
+// the group.
+func (s *state) observeProposal(groupID uint64) {
+	if g, ok := s.groups[groupID]; ok && g.softState.Lead == s.nodeID {
+		g.closedTS.proposals++
+	}
+}
+
+// observeEntries advances the last index of the group past entries
+// about to be appended to its log. While this node leads the group,
+// every entry carrying data is one of its proposals.
+func (s *state) observeEntries(g *group, entries []raftpb.Entry) {
+	for _, ent := range entries {
+		g.closedTS.lastIndex = ent.Index
+		if g.softState.Lead == s.nodeID && len(ent.Data) > 0 {
+			g.closedTS.logged++
+		}
+	}
+	s.maybeCloseTimestamp(g)
+}
+
+// attachClosedTimestamps adds the closed timestamp of each group this
+// node leads to its outgoing heartbeats.
+func (s *state) attachClosedTimestamps(reqs map[uint64]*SendMessagesRequest) {
+	for _, req := range reqs {
+		for i := range req.Requests {
+			r := &req.Requests[i]
+			if g, ok := s.groups[r.GroupID]; ok && g.softState.Lead == s.nodeID && isHeartbeat(r.Message) {
+				r.ClosedTimestamp = g.closedTS.closed
+			}
+		}
+	}
+}
+
+// observeClosedTimestamp records a timestamp closed by the leader of
+// the group, reporting it once the entry at its index is applied.
+func (s *state) observeClosedTimestamp(groupID uint64, from uint64, closed ClosedTimestamp) {
+	g, ok := s.groups[groupID]
+	if !ok || from != g.softState.Lead {
+		return
+	}
+	ts := &g.closedTS
+	if !ts.lastReceived.Less(closed.Timestamp) {
+		return
+	}
+	ts.lastReceived = closed.Timestamp
+	ts.received = append(ts.received, closed)
+	s.reportClosedTimestamps(g)
+}
+
+// reportClosedTimestamps sends an EventClosedTimestamp for the latest
+// timestamp received from the leader whose index has been applied.
+func (s *state) reportClosedTimestamps(g *group) {
+	ts := &g.closedTS
+	i := -1
+	for j, closed := range ts.received {
+		if closed.Index <= ts.appliedIndex {
+			i = j
+		}
+	}
+	if i < 0 {
+		return
+	}
+	s.sendEvent(&EventClosedTimestamp{g.groupID, ts.received[i].Timestamp})
+	ts.received = ts.received[i+1:]
+}
+
+// resetClosedTimestamps discards the closed timestamps of the group
+// when its leader changes.
+func (g *group) resetClosedTimestamps() {
+	g.closedTS = closedTimestamps{
+		lastIndex:    g.closedTS.lastIndex,
+		appliedIndex: g.closedTS.appliedIndex,
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package multiraft
+
+import (
+	"strconv"
+
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
+	"github.com/coreos/etcd/Godeps/_workspace/src/code.google.com/p/go.net/context"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// groupMetrics are the election metrics of a single group. They're
+// linked into the MultiRaft registry under a per-group name and
+// exported with a group label.
+type groupMetrics struct {
+	registry *metric.Registry
+
+	leaderChanges *metric.Counter // Leaders observed by this node
+	votesIgnored  *metric.Counter // Vote requests ignored under CheckQuorum
+	term          *metric.Gauge   // Term of the group's last persisted hard state
+}
+
+func newGroupMetrics() *groupMetrics {
+	r := metric.NewRegistry()
+	return &groupMetrics{
+		registry:      r,
+		leaderChanges: r.Counter("leader.changes"),
+		votesIgnored:  r.Counter("votes.ignored"),
+		term:          r.Gauge("term"),
+	}
+}
+
+// addGroupMetrics links the metrics of the group into the registry
+// returned by MultiRaft.Metrics.
+func (s *state) addGroupMetrics(g *group) {
+	groupID := strconv.FormatUint(g.groupID, 10)
+	if err := s.metrics.registry.AddLabeled("group."+groupID+".", "group.", g.metrics.registry,
+		metric.Label{Name: "group", Value: groupID}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// tickGroups advances the election clock of every group, which
+// measures the time since the node last heard from the group's
+// leader.
+func (s *state) tickGroups() {
+	for _, g := range s.groups {
+		g.ticksSinceLeader++
+	}
+}
+
+// observeMessage resets the group's election clock if the message
+// shows that its leader is still active: it was sent by the leader,
+// or, if this node leads the group, by any member of the group.
+func (s *state) observeMessage(g *group, msg raftpb.Message) {
+	if lead := g.softState.Lead; lead != 0 && (msg.From == lead || lead == s.nodeID) {
+		g.ticksSinceLeader = 0
+	}
+}
+
+// shouldIgnoreVote returns true if the message is a vote request which
+// must be dropped because CheckQuorum is enabled and the group's
+// leader was heard from within the election timeout. Like the leader
+// stickiness of section 4.2.3 of the Raft thesis, this keeps a
+// replica rejoining after a partition, which has meanwhile increased
+// its term by campaigning, from deposing a leader still in contact
+// with a quorum of the group.
+//
+// TODO(agent): the candidate keeps its higher term and ignores the
+// leader until the group's next election. Run a pre-vote phase before
+// campaigning once etcd raft supports one, so that a candidate only
+// increases its term if it could win the election.
+func (s *state) shouldIgnoreVote(g *group, msg raftpb.Message) bool {
+	if !s.CheckQuorum || msg.Type != raftpb.MsgVote {
+		return false
+	}
+	return g.softState.Lead != 0 && g.ticksSinceLeader < s.ElectionTimeoutTicks
+}
+
+// stepMessage steps the message into its group unless it's a vote
+// request which must be ignored.
+func (s *state) stepMessage(groupID uint64, msg raftpb.Message) error {
+	if g, ok := s.groups[groupID]; ok {
+		if s.shouldIgnoreVote(g, msg) {
+			log.V(1).Infof("node %v: group %v: ignoring vote request from %v at term %v",
+				s.nodeID, groupID, msg.From, msg.Term)
+			g.metrics.votesIgnored.Inc(1)
+			return nil
+		}
+		s.observeMessage(g, msg)
+	}
+	return s.multiNode.Step(context.Background(), groupID, msg)
+}
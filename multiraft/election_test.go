@@ -0,0 +1,70 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package multiraft
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// TestCheckQuorumIgnoresVotes verifies that vote requests are ignored
+// only while the group's leader has been heard from within the
+// election timeout, and only if CheckQuorum is enabled.
+func TestCheckQuorumIgnoresVotes(t *testing.T) {
+	s := &state{
+		MultiRaft: &MultiRaft{
+			Config: Config{ElectionTimeoutTicks: 2, CheckQuorum: true},
+			nodeID: 1,
+		},
+		groups: map[uint64]*group{},
+	}
+	g := &group{groupID: 1, metrics: newGroupMetrics()}
+	s.groups[1] = g
+	vote := raftpb.Message{Type: raftpb.MsgVote, From: 3, Term: 5}
+
+	if s.shouldIgnoreVote(g, vote) {
+		t.Error("expected votes to be considered in a group without a leader")
+	}
+	g.softState = raft.SoftState{Lead: 2}
+	if !s.shouldIgnoreVote(g, vote) {
+		t.Error("expected votes to be ignored after a leader was elected")
+	}
+	if s.shouldIgnoreVote(g, raftpb.Message{Type: raftpb.MsgApp, From: 3}) {
+		t.Error("expected only vote requests to be ignored")
+	}
+	s.tickGroups()
+	s.tickGroups()
+	if s.shouldIgnoreVote(g, vote) {
+		t.Error("expected votes to be considered once the election timeout elapsed")
+	}
+	// Messages from members other than the leader don't reset the clock.
+	s.observeMessage(g, raftpb.Message{Type: raftpb.MsgAppResp, From: 3})
+	if s.shouldIgnoreVote(g, vote) {
+		t.Error("expected a message from a follower not to count as contact with the leader")
+	}
+	s.observeMessage(g, raftpb.Message{Type: raftpb.MsgApp, From: 2})
+	if !s.shouldIgnoreVote(g, vote) {
+		t.Error("expected votes to be ignored after hearing from the leader")
+	}
+	s.CheckQuorum = false
+	if s.shouldIgnoreVote(g, vote) {
+		t.Error("expected votes to be considered without CheckQuorum")
+	}
+}
@@ -17,6 +17,8 @@
 
 package multiraft
 
+import "github.com/cockroachdb/cockroach/proto"
+
 // An EventLeaderElection is broadcast when a group completes an election.
 // TODO(bdarnell): emit EventLeaderElection from follower nodes as well.
 type EventLeaderElection struct {
@@ -28,3 +30,12 @@ type EventLeaderElection struct {
 type EventCommandCommitted struct {
 	Command []byte
 }
+
+// An EventClosedTimestamp is broadcast on a follower once it has applied
+// every command proposed before the group's leader closed the
+// timestamp. As no further command at or below the timestamp will be
+// committed, reads at the timestamp may then be served locally.
+type EventClosedTimestamp struct {
+	GroupID   uint64
+	Timestamp proto.Timestamp
+}
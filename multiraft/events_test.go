@@ -25,6 +25,7 @@ import "fmt"
 type eventDemux struct {
 	LeaderElection   chan *EventLeaderElection
 	CommandCommitted chan *EventCommandCommitted
+	ClosedTimestamp  chan *EventClosedTimestamp
 
 	events  <-chan interface{}
 	stopper chan struct{}
@@ -34,6 +35,7 @@ func newEventDemux(events <-chan interface{}) *eventDemux {
 	return &eventDemux{
 		make(chan *EventLeaderElection, 1000),
 		make(chan *EventCommandCommitted, 1000),
+		make(chan *EventClosedTimestamp, 1000),
 		events,
 		make(chan struct{}),
 	}
@@ -51,6 +53,9 @@ func (e *eventDemux) start() {
 				case *EventCommandCommitted:
 					e.CommandCommitted <- event
 
+				case *EventClosedTimestamp:
+					e.ClosedTimestamp <- event
+
 				default:
 					panic(fmt.Sprintf("got unknown event type %T", event))
 				}
@@ -0,0 +1,45 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build faults
+
+package multiraft
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/util/fault"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// TestCoalesceMessagesDropped verifies that outgoing messages are
+// dropped when a fault is injected.
+func TestCoalesceMessagesDropped(t *testing.T) {
+	defer fault.Reset()
+	fault.Inject(fault.RaftSendMessage, fault.Fault{Match: func(arg interface{}) bool {
+		return arg.(raftpb.Message).To == 2
+	}})
+	readyGroups := map[uint64]raft.Ready{
+		1: {Messages: []raftpb.Message{{To: 2}, {To: 3}}},
+		2: {Messages: []raftpb.Message{{To: 2}}},
+	}
+	reqs := coalesceMessages(readyGroups)
+	if len(reqs) != 1 || reqs[3] == nil || len(reqs[3].Requests) != 1 {
+		t.Errorf("expected only the message to node 3; got %+v", reqs)
+	}
+}
@@ -23,8 +23,11 @@ import (
 	"net/rpc"
 	"time"
 
+	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/fault"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 	"github.com/coreos/etcd/Godeps/_workspace/src/code.google.com/p/go.net/context"
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
@@ -47,6 +50,16 @@ type Config struct {
 	HeartbeatIntervalTicks int
 	TickInterval           time.Duration
 
+	// SendQueueSize is the number of outgoing messages buffered for each
+	// remote node; zero uses a default. See sendQueue.
+	SendQueueSize int
+
+	// If CheckQuorum is true, a node ignores vote requests for a group
+	// whose leader it has heard from within the election timeout, so that
+	// a replica rejoining after a partition can't force a spurious
+	// election. See state.shouldIgnoreVote.
+	CheckQuorum bool
+
 	// If Strict is true, some warnings become fatal panics and additional (possibly expensive)
 	// sanity checks will be done.
 	Strict bool
@@ -80,6 +93,7 @@ type MultiRaft struct {
 	ops       chan interface{}
 	requests  chan *rpc.Call
 	stopped   chan struct{}
+	metrics   *transportMetrics
 }
 
 // NewMultiRaft creates a MultiRaft object.
@@ -105,6 +119,7 @@ func NewMultiRaft(nodeID uint64, config *Config) (*MultiRaft, error) {
 		ops:      make(chan interface{}, 100),
 		requests: make(chan *rpc.Call, 100),
 		stopped:  make(chan struct{}),
+		metrics:  newTransportMetrics(),
 	}
 
 	err = m.Transport.Listen(nodeID, m)
@@ -129,6 +144,12 @@ func (m *MultiRaft) Stop() {
 	m.multiNode.Stop()
 }
 
+// Metrics returns the registry holding the metrics of the messages
+// sent to other nodes and of the elections of each group.
+func (m *MultiRaft) Metrics() *metric.Registry {
+	return m.metrics.registry
+}
+
 // DoRPC implements ServerInterface
 func (m *MultiRaft) DoRPC(name string, req, resp interface{}) error {
 	call := &rpc.Call{
@@ -197,6 +218,17 @@ func (m *MultiRaft) SubmitCommand(groupID uint64, command []byte) error {
 	return <-op.ch
 }
 
+// CloseTimestamp promises that, after the commands already submitted
+// to the group, which this node must lead, no command with a timestamp
+// at or below the given timestamp will be submitted. The timestamp is
+// carried to followers on heartbeats once those commands have been
+// appended to the leader's log; see EventClosedTimestamp.
+func (m *MultiRaft) CloseTimestamp(groupID uint64, timestamp proto.Timestamp) error {
+	op := &closeTimestampOp{groupID, timestamp, make(chan error, 1)}
+	m.ops <- op
+	return <-op.ch
+}
+
 // ChangeGroupMembership submits a proposed membership change to the cluster.
 // TODO(bdarnell): same concerns as SubmitCommand
 // TODO(bdarnell): do we expose ChangeMembershipAdd{Member,Observer} to the application
@@ -232,6 +264,16 @@ type group struct {
 	// softState is the last value received from node.Ready() so we can compare
 	// old and new values.
 	softState raft.SoftState
+
+	// ticksSinceLeader counts the ticks since the node last heard from
+	// the group's leader. See state.observeMessage.
+	ticksSinceLeader int
+
+	// closedTS tracks the timestamps closed in the group. See
+	// MultiRaft.CloseTimestamp.
+	closedTS closedTimestamps
+
+	metrics *groupMetrics
 }
 
 type stopOp struct{}
@@ -258,7 +300,8 @@ type changeGroupMembershipOp struct {
 type node struct {
 	nodeID   uint64
 	refCount int
-	client   *asyncClient
+	conn     ClientInterface
+	queue    *sendQueue
 }
 
 // state represents the internal state of a MultiRaft object. All variables here
@@ -270,7 +313,6 @@ type state struct {
 	groups        map[uint64]*group
 	nodes         map[uint64]*node
 	electionTimer *time.Timer
-	responses     chan *rpc.Call
 	writeTask     *writeTask
 }
 
@@ -280,7 +322,6 @@ func newState(m *MultiRaft) *state {
 		rand:      util.NewPseudoRand(),
 		groups:    make(map[uint64]*group),
 		nodes:     make(map[uint64]*node),
-		responses: make(chan *rpc.Call, 100),
 		writeTask: newWriteTask(m.Storage),
 	}
 }
@@ -337,6 +378,9 @@ func (s *state) start() {
 			case *changeGroupMembershipOp:
 				s.changeGroupMembership(op)
 
+			case *closeTimestampOp:
+				s.closeTimestamp(op)
+
 			default:
 				s.strictErrorLog("unknown op: %#v", op)
 			}
@@ -348,17 +392,12 @@ func (s *state) start() {
 				s.sendMessageRequest(call.Args.(*SendMessageRequest),
 					call.Reply.(*SendMessageResponse), call)
 
-			default:
-				s.strictErrorLog("unknown rpc request: %#v", call.Args)
-			}
-
-		case call := <-s.responses:
-			log.V(6).Infof("node %v: got response %v", s.nodeID, call)
-			switch call.ServiceMethod {
-			case sendMessageName:
+			case sendMessagesName:
+				s.sendMessagesRequest(call.Args.(*SendMessagesRequest),
+					call.Reply.(*SendMessagesResponse), call)
 
 			default:
-				s.strictErrorLog("unknown rpc response: %#v", call.Reply)
+				s.strictErrorLog("unknown rpc request: %#v", call.Args)
 			}
 
 		case writeReady <- struct{}{}:
@@ -373,6 +412,7 @@ func (s *state) start() {
 		case <-s.Ticker.Chan():
 			log.V(6).Infof("node %v: got tick", s.nodeID)
 			s.multiNode.Tick()
+			s.tickGroups()
 
 		case readyGroups = <-raftReady:
 			s.handleRaftReady(readyGroups)
@@ -383,7 +423,8 @@ func (s *state) start() {
 func (s *state) stop() {
 	log.V(6).Infof("node %v stopping", s.nodeID)
 	for _, n := range s.nodes {
-		err := n.client.conn.Close()
+		n.queue.stop()
+		err := n.conn.Close()
 		if err != nil {
 			log.Warning("error stopping client:", err)
 		}
@@ -411,37 +452,70 @@ func (s *state) createGroup(op *createGroupOp) {
 			op.ch <- err
 			return
 		}
-		s.nodes[member] = &node{member, 1, &asyncClient{member, conn, s.responses}}
+		queue := newSendQueue(member, conn, s.SendQueueSize, s.metrics)
+		queue.start()
+		s.nodes[member] = &node{member, 1, conn, queue}
 	}
 	s.multiNode.CreateGroup(op.groupID, peers)
-	s.groups[op.groupID] = &group{
+	g := &group{
 		groupID: op.groupID,
+		metrics: newGroupMetrics(),
 	}
+	s.groups[op.groupID] = g
+	s.addGroupMetrics(g)
 	op.ch <- nil
 }
 
 func (s *state) submitCommand(op *submitCommandOp) {
 	log.V(6).Infof("node %v submitting command to group %v", s.nodeID, op.groupID)
 	err := s.multiNode.Propose(context.Background(), op.groupID, op.command)
+	if err == nil {
+		s.observeProposal(op.groupID)
+	}
 	op.ch <- err
 }
 
 func (s *state) changeGroupMembership(op *changeGroupMembershipOp) {
 	log.V(6).Infof("node %v proposing membership change to group %v", s.nodeID, op.groupID)
 	err := s.multiNode.ProposeConfChange(context.Background(), op.groupID, raftpb.ConfChange{})
+	if err == nil {
+		s.observeProposal(op.groupID)
+	}
 	op.ch <- err
 }
 
 func (s *state) sendMessageRequest(req *SendMessageRequest, resp *SendMessageResponse,
 	call *rpc.Call) {
-	err := s.multiNode.Step(context.Background(), req.GroupID, req.Message)
+	err := s.stepMessage(req.GroupID, req.Message)
 	if err != nil {
 		log.Errorf("raft: %s", err)
+	} else if req.ClosedTimestamp != nil {
+		s.observeClosedTimestamp(req.GroupID, req.Message.From, *req.ClosedTimestamp)
 	}
 	call.Error = err
 	call.Done <- call
 }
 
+// sendMessagesRequest demultiplexes a coalesced request, stepping each
+// message into its group. The first error encountered, if any, is
+// returned to the caller, but doesn't prevent stepping the remaining
+// messages.
+func (s *state) sendMessagesRequest(req *SendMessagesRequest, resp *SendMessagesResponse,
+	call *rpc.Call) {
+	for _, r := range req.Requests {
+		err := s.stepMessage(r.GroupID, r.Message)
+		if err != nil {
+			log.Errorf("raft: %s", err)
+			if call.Error == nil {
+				call.Error = err
+			}
+		} else if r.ClosedTimestamp != nil {
+			s.observeClosedTimestamp(r.GroupID, r.Message.From, *r.ClosedTimestamp)
+		}
+	}
+	call.Done <- call
+}
+
 func (s *state) handleRaftReady(readyGroups map[uint64]raft.Ready) {
 	// Soft state is updated immediately; everything else waits for handleWriteReady.
 	for groupID, ready := range readyGroups {
@@ -450,9 +524,17 @@ func (s *state) handleRaftReady(readyGroups map[uint64]raft.Ready) {
 		if ready.SoftState != nil {
 			if ready.SoftState.Lead != g.softState.Lead {
 				s.sendEvent(&EventLeaderElection{groupID, ready.SoftState.Lead})
+				if ready.SoftState.Lead != 0 {
+					g.metrics.leaderChanges.Inc(1)
+				}
+				g.ticksSinceLeader = 0
+				g.resetClosedTimestamps()
 			}
 			g.softState = *ready.SoftState
 		}
+		if !raft.IsEmptyHardState(ready.HardState) {
+			g.metrics.term.Update(int64(ready.HardState.Term))
+		}
 	}
 }
 
@@ -467,6 +549,7 @@ func (s *state) handleWriteReady(readyGroups map[uint64]raft.Ready) {
 				HardState: ready.HardState,
 			}
 		}
+		s.observeEntries(s.groups[groupID], ready.Entries)
 		if len(ready.Entries) > 0 {
 			gwr.entries = make([]*LogEntry, len(ready.Entries))
 			for i, ent := range ready.Entries {
@@ -483,7 +566,9 @@ func (s *state) handleWriteResponse(response *writeResponse, readyGroups map[uin
 	// Everything has been written to disk; now we can apply updates to the state machine
 	// and send outgoing messages.
 	for groupID, ready := range readyGroups {
+		g := s.groups[groupID]
 		for _, entry := range ready.CommittedEntries {
+			g.closedTS.appliedIndex = entry.Index
 			switch entry.Type {
 			case raftpb.EntryNormal:
 				// TODO(bdarnell): etcd raft adds a nil entry upon election; should this be given a different Type?
@@ -500,16 +585,42 @@ func (s *state) handleWriteResponse(response *writeResponse, readyGroups map[uin
 				s.multiNode.ApplyConfChange(groupID, cc)
 			}
 		}
+		s.reportClosedTimestamps(g)
+	}
+	// Queue outgoing messages for all groups, coalesced by destination
+	// node. Each node's queue sends them without blocking this loop.
+	reqs := coalesceMessages(readyGroups)
+	s.attachClosedTimestamps(reqs)
+	for nodeID, req := range reqs {
+		log.V(6).Infof("node %v queueing %d messages to %v", s.nodeID, len(req.Requests), nodeID)
+		s.nodes[nodeID].queue.enqueue(req.Requests)
+	}
+}
+
+// coalesceMessages groups the outgoing messages of all ready groups by
+// destination node, returning a map from node ID to the request which
+// carries that node's messages.
+func coalesceMessages(readyGroups map[uint64]raft.Ready) map[uint64]*SendMessagesRequest {
+	reqs := map[uint64]*SendMessagesRequest{}
+	for groupID, ready := range readyGroups {
 		for _, msg := range ready.Messages {
 			if msg.To == 0 {
 				// TODO(bdarnell): figure out why these are happening
 				log.Warningf("dropping message for node 0")
 				continue
 			}
-			log.V(6).Infof("node %v sending %s message to %v", s.nodeID, msg.Type, msg.To)
-			s.nodes[msg.To].client.sendMessage(&SendMessageRequest{groupID, msg})
+			if dropped, _ := fault.Hit(fault.RaftSendMessage, msg); dropped {
+				continue
+			}
+			req, ok := reqs[msg.To]
+			if !ok {
+				req = &SendMessagesRequest{}
+				reqs[msg.To] = req
+			}
+			req.Requests = append(req.Requests, SendMessageRequest{GroupID: groupID, Message: msg})
 		}
 	}
+	return reqs
 }
 
 func (s *state) addPendingCall(g *group, call *pendingCall) {
@@ -21,7 +21,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
 )
 
 type testCluster struct {
@@ -146,6 +149,47 @@ func TestCommand(t *testing.T) {
 	}
 }
 
+// TestClosedTimestamp verifies that a timestamp closed by the leader is
+// carried to the followers on heartbeats and reported by each once it
+// has applied the commands proposed before the timestamp was closed.
+func TestClosedTimestamp(t *testing.T) {
+	cluster := newTestCluster(3, t)
+	defer cluster.stop()
+	groupID := uint64(1)
+	cluster.createGroup(groupID, 3)
+	cluster.waitForElection(0)
+
+	ts := proto.Timestamp{WallTime: 10}
+	if err := cluster.nodes[1].CloseTimestamp(groupID, ts); err == nil {
+		t.Error("expected a follower to refuse to close a timestamp")
+	}
+	if err := cluster.nodes[0].SubmitCommand(groupID, []byte("command")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.nodes[0].CloseTimestamp(groupID, ts); err != nil {
+		t.Fatal(err)
+	}
+	for i, events := range cluster.events {
+		log.Infof("waiting for event to be commited on node %v", i)
+		<-events.CommandCommitted
+	}
+
+	// The next heartbeat carries the closed timestamp.
+	cluster.tickers[0].Tick()
+	for i := 1; i < 3; i++ {
+		log.Infof("waiting for closed timestamp on node %v", i)
+		closed := <-cluster.events[i].ClosedTimestamp
+		if closed.GroupID != groupID || !closed.Timestamp.Equal(ts) {
+			t.Errorf("node %v: expected timestamp %s closed in group %v; got %+v", i, ts, groupID, closed)
+		}
+	}
+	select {
+	case closed := <-cluster.events[0].ClosedTimestamp:
+		t.Errorf("didn't expect the leader to report a closed timestamp; got %+v", closed)
+	default:
+	}
+}
+
 // TODO(bdarnell): reinstate this test once we re-integrate the storage system.
 func TestSlowStorage(t *testing.T) {
 	cluster := newTestCluster(3, t)
@@ -208,3 +252,27 @@ func TestMembershipChange(t *testing.T) {
 		}
 	}
 }
+
+// TestCoalesceMessages verifies that outgoing messages from all groups
+// are coalesced into a single request per destination node.
+func TestCoalesceMessages(t *testing.T) {
+	readyGroups := map[uint64]raft.Ready{
+		1: {Messages: []raftpb.Message{{To: 2}, {To: 3}}},
+		2: {Messages: []raftpb.Message{{To: 2}, {To: 0}}},
+		3: {Messages: []raftpb.Message{{To: 3}}},
+	}
+	reqs := coalesceMessages(readyGroups)
+	if len(reqs) != 2 {
+		t.Fatalf("expected requests for 2 nodes; got %d", len(reqs))
+	}
+	for nodeID, req := range reqs {
+		if len(req.Requests) != 2 {
+			t.Errorf("node %d: expected 2 messages; got %d", nodeID, len(req.Requests))
+		}
+		for _, r := range req.Requests {
+			if r.Message.To != nodeID {
+				t.Errorf("node %d: message for group %d addressed to node %d", nodeID, r.GroupID, r.Message.To)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package multiraft
+
+import (
+	"net/rpc"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// defaultSendQueueSize is the number of outgoing messages buffered for
+// each remote node if Config.SendQueueSize is zero.
+const defaultSendQueueSize = 1024
+
+// transportMetrics are the metrics of a node's outgoing messages.
+type transportMetrics struct {
+	registry *metric.Registry
+
+	sent              *metric.Counter // Messages sent
+	rpcs              *metric.Counter // Coalesced RPCs sent
+	rpcErrors         *metric.Counter // RPCs which returned an error
+	droppedHeartbeats *metric.Counter // Heartbeats dropped from full queues
+	droppedMessages   *metric.Counter // Other messages dropped from full queues
+	queued            *metric.Gauge   // Messages awaiting sending
+}
+
+func newTransportMetrics() *transportMetrics {
+	r := metric.NewRegistry()
+	return &transportMetrics{
+		registry:          r,
+		sent:              r.Counter("messages.sent"),
+		rpcs:              r.Counter("rpcs.sent"),
+		rpcErrors:         r.Counter("rpcs.errors"),
+		droppedHeartbeats: r.Counter("heartbeats.dropped"),
+		droppedMessages:   r.Counter("messages.dropped"),
+		queued:            r.Gauge("messages.queued"),
+	}
+}
+
+// isHeartbeat returns true if the message is a heartbeat, which etcd
+// raft sends as an append carrying no entries. The next heartbeat
+// supersedes a dropped one, so they're dropped first when a queue
+// overflows.
+func isHeartbeat(msg raftpb.Message) bool {
+	return msg.Type == raftpb.MsgApp && len(msg.Entries) == 0
+}
+
+// A sendQueue buffers the outgoing messages of all groups to a single
+// remote node and sends them from its own goroutine, coalescing the
+// messages queued while an RPC is outstanding into the next. With one
+// RPC in flight per node, a slow or unreachable node delays neither
+// the state loop nor the messages to other nodes. The queue holds at
+// most maxSize messages; once it's full, the oldest heartbeat and
+// otherwise the oldest message is dropped to make room, leaving raft
+// to retransmit as it would after any lost message.
+type sendQueue struct {
+	nodeID  uint64
+	conn    ClientInterface
+	maxSize int
+	metrics *transportMetrics
+
+	mu      sync.Mutex
+	pending []SendMessageRequest
+
+	ready   chan struct{} // Signaled when messages are queued
+	stopper chan struct{}
+	done    chan struct{}
+}
+
+func newSendQueue(nodeID uint64, conn ClientInterface, maxSize int, metrics *transportMetrics) *sendQueue {
+	if maxSize <= 0 {
+		maxSize = defaultSendQueueSize
+	}
+	return &sendQueue{
+		nodeID:  nodeID,
+		conn:    conn,
+		maxSize: maxSize,
+		metrics: metrics,
+		ready:   make(chan struct{}, 1),
+		stopper: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// start begins sending queued messages in a background goroutine.
+func (q *sendQueue) start() {
+	go q.run()
+}
+
+// stop stops sending messages, discarding those still queued, and
+// waits for an outstanding RPC to complete or be abandoned.
+func (q *sendQueue) stop() {
+	close(q.stopper)
+	<-q.done
+}
+
+// enqueue appends the requests to the queue without blocking.
+func (q *sendQueue) enqueue(reqs []SendMessageRequest) {
+	q.mu.Lock()
+	q.pending = append(q.pending, reqs...)
+	for len(q.pending) > q.maxSize {
+		q.dropOldestLocked()
+	}
+	q.metrics.queued.Inc(int64(len(reqs)))
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// dropOldestLocked drops the oldest queued heartbeat, or the oldest
+// message if none is a heartbeat. q.mu must be held.
+func (q *sendQueue) dropOldestLocked() {
+	i := 0
+	for j, req := range q.pending {
+		if isHeartbeat(req.Message) {
+			i = j
+			break
+		}
+	}
+	if isHeartbeat(q.pending[i].Message) {
+		q.metrics.droppedHeartbeats.Inc(1)
+	} else {
+		q.metrics.droppedMessages.Inc(1)
+	}
+	q.pending = append(q.pending[:i], q.pending[i+1:]...)
+	q.metrics.queued.Inc(-1)
+}
+
+// run sends the queued messages until the queue is stopped.
+func (q *sendQueue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.ready:
+		case <-q.stopper:
+			return
+		}
+		q.mu.Lock()
+		reqs := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+		if len(reqs) == 0 {
+			continue
+		}
+		q.metrics.queued.Inc(-int64(len(reqs)))
+		log.V(6).Infof("sending %d messages to node %v", len(reqs), q.nodeID)
+		call := q.conn.Go(sendMessagesName, &SendMessagesRequest{Requests: reqs}, &SendMessagesResponse{}, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+		case <-q.stopper:
+			return
+		}
+		q.metrics.rpcs.Inc(1)
+		q.metrics.sent.Inc(int64(len(reqs)))
+		if call.Error != nil {
+			q.metrics.rpcErrors.Inc(1)
+			log.V(1).Infof("failed to send %d messages to node %v: %s", len(reqs), q.nodeID, call.Error)
+		}
+	}
+}
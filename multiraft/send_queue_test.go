@@ -0,0 +1,96 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package multiraft
+
+import (
+	"net/rpc"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// blockingClient is a ClientInterface whose calls are handed to the
+// test, which completes them.
+type blockingClient struct {
+	calls chan *rpc.Call
+}
+
+func (c *blockingClient) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	call := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	c.calls <- call
+	return call
+}
+
+func (c *blockingClient) Close() error {
+	return nil
+}
+
+// TestSendQueue verifies that messages queued while an RPC is
+// outstanding are coalesced into the next, and that heartbeats are
+// dropped before other messages once the queue is full.
+func TestSendQueue(t *testing.T) {
+	client := &blockingClient{calls: make(chan *rpc.Call)}
+	metrics := newTransportMetrics()
+	q := newSendQueue(2, client, 3, metrics)
+	q.start()
+	defer q.stop()
+
+	entry := func(index uint64) SendMessageRequest {
+		return SendMessageRequest{GroupID: 1, Message: raftpb.Message{Type: raftpb.MsgApp, Index: index, Entries: []raftpb.Entry{{}}}}
+	}
+	heartbeat := SendMessageRequest{GroupID: 2, Message: raftpb.Message{Type: raftpb.MsgApp}}
+	indexes := func(call *rpc.Call) []uint64 {
+		var indexes []uint64
+		for _, req := range call.Args.(*SendMessagesRequest).Requests {
+			indexes = append(indexes, req.Message.Index)
+		}
+		return indexes
+	}
+
+	q.enqueue([]SendMessageRequest{entry(1)})
+	call := <-client.calls
+	if call.ServiceMethod != sendMessagesName || !reflect.DeepEqual(indexes(call), []uint64{1}) {
+		t.Fatalf("unexpected call %s of %v", call.ServiceMethod, indexes(call))
+	}
+	// The queue overflows while the RPC is outstanding.
+	q.enqueue([]SendMessageRequest{heartbeat, entry(2), entry(3)})
+	q.enqueue([]SendMessageRequest{entry(4)})
+	q.enqueue([]SendMessageRequest{entry(5)})
+	if dropped := metrics.droppedHeartbeats.Count(); dropped != 1 {
+		t.Errorf("expected 1 dropped heartbeat; got %d", dropped)
+	}
+	if dropped := metrics.droppedMessages.Count(); dropped != 1 {
+		t.Errorf("expected 1 dropped message; got %d", dropped)
+	}
+
+	call.Done <- call
+	call = <-client.calls
+	if !reflect.DeepEqual(indexes(call), []uint64{3, 4, 5}) {
+		t.Errorf("expected the newest messages to be coalesced; got %v", indexes(call))
+	}
+	call.Done <- call
+	q.enqueue([]SendMessageRequest{entry(6)})
+	<-client.calls
+	if sent, rpcs := metrics.sent.Count(), metrics.rpcs.Count(); sent != 4 || rpcs != 2 {
+		t.Errorf("expected 4 messages sent in 2 RPCs; got %d in %d", sent, rpcs)
+	}
+	if queued := metrics.queued.Value(); queued != 0 {
+		t.Errorf("expected no queued messages; got %d", queued)
+	}
+}
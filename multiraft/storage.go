@@ -46,7 +46,10 @@ const (
 
 // ChangeMembershipPayload is the Payload of an entry with Type LogEntryChangeMembership.
 // Nodes are added or removed one at a time to minimize the risk of quorum failures in
-// the new configuration.
+// the new configuration. An atomic swap (adding one member and removing another in
+// a single change) would require joint consensus, which the underlying raft
+// implementation doesn't support, so a member is replaced by adding the new member
+// before removing the old one.
 type ChangeMembershipPayload struct {
 	Operation ChangeMembershipOperation
 	Node      uint64
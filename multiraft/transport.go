@@ -45,6 +45,9 @@ type Transport interface {
 type SendMessageRequest struct {
 	GroupID uint64
 	Message raftpb.Message
+	// ClosedTimestamp, if set on a heartbeat from the group's leader, is
+	// the latest timestamp closed by the leader.
+	ClosedTimestamp *ClosedTimestamp
 }
 
 // SendMessageResponse is empty (raft uses a one-way messaging model; if a response
@@ -52,6 +55,19 @@ type SendMessageRequest struct {
 type SendMessageResponse struct {
 }
 
+// SendMessagesRequest coalesces raft messages for any number of groups, all
+// destined for the same node, into a single RPC. This keeps the number of
+// messages sent per tick (most of which are heartbeats) proportional to the
+// number of nodes rather than the number of groups. The receiving node steps
+// each message into its group in order.
+type SendMessagesRequest struct {
+	Requests []SendMessageRequest
+}
+
+// SendMessagesResponse is empty; see SendMessageResponse.
+type SendMessagesResponse struct {
+}
+
 // ServerInterface is a generic interface based on net/rpc.
 type ServerInterface interface {
 	DoRPC(name string, req, resp interface{}) error
@@ -60,10 +76,12 @@ type ServerInterface interface {
 // RPCInterface is the methods we expose for use by net/rpc.
 type RPCInterface interface {
 	SendMessage(req *SendMessageRequest, resp *SendMessageResponse) error
+	SendMessages(req *SendMessagesRequest, resp *SendMessagesResponse) error
 }
 
 var (
-	sendMessageName = "MultiRaft.SendMessage"
+	sendMessageName  = "MultiRaft.SendMessage"
+	sendMessagesName = "MultiRaft.SendMessages"
 )
 
 // ClientInterface is the interface expected of the client provided by a transport.
@@ -83,15 +101,6 @@ func (r *rpcAdapter) SendMessage(req *SendMessageRequest, resp *SendMessageRespo
 	return r.server.DoRPC(sendMessageName, req, resp)
 }
 
-// asyncClient bridges MultiRaft's channel-oriented interface with the synchronous RPC interface.
-// Outgoing requests are run in a goroutine and their response ops are returned on the
-// given channel.
-type asyncClient struct {
-	nodeID uint64
-	conn   ClientInterface
-	ch     chan *rpc.Call
-}
-
-func (a *asyncClient) sendMessage(req *SendMessageRequest) {
-	a.conn.Go(sendMessageName, req, &SendMessageResponse{}, a.ch)
+func (r *rpcAdapter) SendMessages(req *SendMessagesRequest, resp *SendMessagesResponse) error {
+	return r.server.DoRPC(sendMessagesName, req, resp)
 }
@@ -18,10 +18,13 @@
 package proto
 
 import (
+	"bytes"
+	"hash/crc32"
 	"reflect"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
 )
 
 // TODO(spencer): change these string constants into a type.
@@ -46,6 +49,14 @@ const (
 	Increment = "Increment"
 	// Delete removes the value for the specified key.
 	Delete = "Delete"
+	// ConditionalDelete removes the value for a key if the existing
+	// value matches the value specified in the request. Specifying a
+	// null value for existing means the key must merely exist.
+	ConditionalDelete = "ConditionalDelete"
+	// CompareAndSwap checks the values of a set of keys and, only if
+	// all match the expected values, writes a set of keys atomically.
+	// All keys must lie within a single range.
+	CompareAndSwap = "CompareAndSwap"
 	// DeleteRange removes all values for keys which fall between
 	// args.RequestHeader.Key and args.RequestHeader.EndKey.
 	DeleteRange = "DeleteRange"
@@ -75,8 +86,25 @@ const (
 	EnqueueUpdate = "EnqueueUpdate"
 	// EnqueueMessage enqueues a message for delivery to an inbox.
 	EnqueueMessage = "EnqueueMessage"
+	// AcquireLock acquires a shared or exclusive lock on a key or key
+	// range for a transaction, waiting for conflicting locks held by
+	// other transactions to be released. Locks are held in memory by
+	// the leader of the range and aren't replicated; they're released
+	// when the transaction's intents are resolved.
+	AcquireLock = "AcquireLock"
+	// Query scans the rows of a structured table, filtering,
+	// projecting and limiting them. Queries are executed by the
+	// structured data layer of the gateway node, which translates them
+	// into scans of the table's key range, and not by ranges.
+	Query = "Query"
 	// AdminSplit is called to coordinate a split of a range.
 	AdminSplit = "AdminSplit"
+	// AdminRecoverReplicas rewrites the replica set of a range which
+	// has permanently lost a majority of its replicas.
+	AdminRecoverReplicas = "AdminRecoverReplicas"
+	// AdminTransferLease moves the leader lease of a range to another
+	// of its replicas.
+	AdminTransferLease = "AdminTransferLease"
 )
 
 type stringSet map[string]struct{}
@@ -94,56 +122,80 @@ func (s stringSet) keys() []string {
 
 // AllMethods specifies the complete set of methods.
 var AllMethods = stringSet{
-	Contains:              struct{}{},
-	Get:                   struct{}{},
-	Put:                   struct{}{},
-	ConditionalPut:        struct{}{},
-	Increment:             struct{}{},
-	Delete:                struct{}{},
-	DeleteRange:           struct{}{},
-	Scan:                  struct{}{},
-	BeginTransaction:      struct{}{},
-	EndTransaction:        struct{}{},
-	AccumulateTS:          struct{}{},
-	ReapQueue:             struct{}{},
-	EnqueueUpdate:         struct{}{},
-	EnqueueMessage:        struct{}{},
-	AdminSplit:            struct{}{},
-	InternalEndTxn:        struct{}{},
-	InternalHeartbeatTxn:  struct{}{},
-	InternalPushTxn:       struct{}{},
-	InternalResolveIntent: struct{}{},
-	InternalSnapshotCopy:  struct{}{},
+	Contains:                struct{}{},
+	Get:                     struct{}{},
+	Put:                     struct{}{},
+	ConditionalPut:          struct{}{},
+	Increment:               struct{}{},
+	Delete:                  struct{}{},
+	ConditionalDelete:       struct{}{},
+	CompareAndSwap:          struct{}{},
+	DeleteRange:             struct{}{},
+	Scan:                    struct{}{},
+	BeginTransaction:        struct{}{},
+	EndTransaction:          struct{}{},
+	AccumulateTS:            struct{}{},
+	ReapQueue:               struct{}{},
+	EnqueueUpdate:           struct{}{},
+	EnqueueMessage:          struct{}{},
+	AcquireLock:             struct{}{},
+	Query:                   struct{}{},
+	AdminSplit:              struct{}{},
+	AdminRecoverReplicas:    struct{}{},
+	AdminTransferLease:      struct{}{},
+	InternalEndTxn:          struct{}{},
+	InternalHeartbeatTxn:    struct{}{},
+	InternalPushTxn:         struct{}{},
+	InternalResolveIntent:   struct{}{},
+	InternalSnapshotCopy:    struct{}{},
+	InternalExecute:         struct{}{},
+	InternalRefresh:         struct{}{},
+	InternalRangeStats:      struct{}{},
+	InternalGC:              struct{}{},
+	InternalScanIntents:     struct{}{},
+	InternalReserveSnapshot: struct{}{},
 }
 
 // PublicMethods specifies the set of methods accessible via the
 // public key-value API.
 var PublicMethods = stringSet{
-	Contains:         struct{}{},
-	Get:              struct{}{},
-	Put:              struct{}{},
-	ConditionalPut:   struct{}{},
-	Increment:        struct{}{},
-	Delete:           struct{}{},
-	DeleteRange:      struct{}{},
-	Scan:             struct{}{},
-	BeginTransaction: struct{}{},
-	EndTransaction:   struct{}{},
-	AccumulateTS:     struct{}{},
-	ReapQueue:        struct{}{},
-	EnqueueUpdate:    struct{}{},
-	EnqueueMessage:   struct{}{},
-	AdminSplit:       struct{}{},
+	Contains:             struct{}{},
+	Get:                  struct{}{},
+	Put:                  struct{}{},
+	ConditionalPut:       struct{}{},
+	Increment:            struct{}{},
+	Delete:               struct{}{},
+	ConditionalDelete:    struct{}{},
+	CompareAndSwap:       struct{}{},
+	DeleteRange:          struct{}{},
+	Scan:                 struct{}{},
+	BeginTransaction:     struct{}{},
+	EndTransaction:       struct{}{},
+	AccumulateTS:         struct{}{},
+	ReapQueue:            struct{}{},
+	EnqueueUpdate:        struct{}{},
+	EnqueueMessage:       struct{}{},
+	AcquireLock:          struct{}{},
+	Query:                struct{}{},
+	AdminSplit:           struct{}{},
+	AdminRecoverReplicas: struct{}{},
+	AdminTransferLease:   struct{}{},
 }
 
 // InternalMethods specifies the set of methods accessible only
 // via the internal node RPC API.
 var InternalMethods = stringSet{
-	InternalEndTxn:        struct{}{},
-	InternalHeartbeatTxn:  struct{}{},
-	InternalPushTxn:       struct{}{},
-	InternalResolveIntent: struct{}{},
-	InternalSnapshotCopy:  struct{}{},
+	InternalEndTxn:          struct{}{},
+	InternalHeartbeatTxn:    struct{}{},
+	InternalPushTxn:         struct{}{},
+	InternalResolveIntent:   struct{}{},
+	InternalSnapshotCopy:    struct{}{},
+	InternalExecute:         struct{}{},
+	InternalRefresh:         struct{}{},
+	InternalRangeStats:      struct{}{},
+	InternalGC:              struct{}{},
+	InternalScanIntents:     struct{}{},
+	InternalReserveSnapshot: struct{}{},
 }
 
 // ReadMethods specifies the set of methods which read and return data.
@@ -151,11 +203,18 @@ var ReadMethods = stringSet{
 	Contains:             struct{}{},
 	Get:                  struct{}{},
 	ConditionalPut:       struct{}{},
+	ConditionalDelete:    struct{}{},
+	CompareAndSwap:       struct{}{},
 	Increment:            struct{}{},
 	Scan:                 struct{}{},
+	Query:                struct{}{},
 	ReapQueue:            struct{}{},
 	InternalRangeLookup:  struct{}{},
 	InternalSnapshotCopy: struct{}{},
+	InternalExecute:      struct{}{},
+	InternalRefresh:      struct{}{},
+	InternalRangeStats:   struct{}{},
+	InternalScanIntents:  struct{}{},
 }
 
 // WriteMethods specifies the set of methods which write data.
@@ -164,40 +223,49 @@ var WriteMethods = stringSet{
 	ConditionalPut:        struct{}{},
 	Increment:             struct{}{},
 	Delete:                struct{}{},
+	ConditionalDelete:     struct{}{},
+	CompareAndSwap:        struct{}{},
 	DeleteRange:           struct{}{},
 	EndTransaction:        struct{}{},
 	AccumulateTS:          struct{}{},
 	ReapQueue:             struct{}{},
 	EnqueueUpdate:         struct{}{},
 	EnqueueMessage:        struct{}{},
+	AcquireLock:           struct{}{},
 	InternalEndTxn:        struct{}{},
 	InternalHeartbeatTxn:  struct{}{},
 	InternalPushTxn:       struct{}{},
 	InternalResolveIntent: struct{}{},
+	InternalExecute:       struct{}{},
+	InternalGC:            struct{}{},
 }
 
 // TxnMethods specifies the set of methods which may be part of a
 // transaction.
 var TxnMethods = stringSet{
-	Contains:       struct{}{},
-	Get:            struct{}{},
-	Put:            struct{}{},
-	ConditionalPut: struct{}{},
-	Increment:      struct{}{},
-	Delete:         struct{}{},
-	DeleteRange:    struct{}{},
-	Scan:           struct{}{},
-	AccumulateTS:   struct{}{},
-	ReapQueue:      struct{}{},
-	EnqueueUpdate:  struct{}{},
-	EnqueueMessage: struct{}{},
+	Contains:          struct{}{},
+	Get:               struct{}{},
+	Put:               struct{}{},
+	ConditionalPut:    struct{}{},
+	Increment:         struct{}{},
+	Delete:            struct{}{},
+	ConditionalDelete: struct{}{},
+	DeleteRange:       struct{}{},
+	Scan:              struct{}{},
+	AccumulateTS:      struct{}{},
+	ReapQueue:         struct{}{},
+	EnqueueUpdate:     struct{}{},
+	EnqueueMessage:    struct{}{},
+	AcquireLock:       struct{}{},
 }
 
 // adminMethods specifies the set of methods which are neither
 // read-only nor read-write commands but instead execute directly on
 // the Raft leader.
 var adminMethods = stringSet{
-	AdminSplit: struct{}{},
+	AdminSplit:           struct{}{},
+	AdminRecoverReplicas: struct{}{},
+	AdminTransferLease:   struct{}{},
 }
 
 // NeedReadPerm returns true if the specified method requires read permissions.
@@ -280,6 +348,29 @@ func PutArgs(key Key, valueBytes []byte) *PutRequest {
 	}
 }
 
+// LimitRows truncates scanned rows to at most maxResults rows and to
+// the first row which brings the total size of keys and values to at
+// least maxBytes. Returns the truncated rows and whether either limit
+// was reached. Limits which are zero are ignored.
+func LimitRows(rows []KeyValue, maxResults, maxBytes int64) ([]KeyValue, bool) {
+	if maxResults > 0 && int64(len(rows)) >= maxResults {
+		rows = rows[:maxResults]
+		if maxBytes <= 0 {
+			return rows, true
+		}
+	}
+	if maxBytes > 0 {
+		var size int64
+		for i, kv := range rows {
+			size += int64(len(kv.Key) + len(kv.Value.Bytes))
+			if size >= maxBytes {
+				return rows[:i+1], true
+			}
+		}
+	}
+	return rows, maxResults > 0 && int64(len(rows)) >= maxResults
+}
+
 // CreateArgsAndReply returns allocated request and response pairs
 // according to the specified method.
 func CreateArgsAndReply(method string) (Request, Response, error) {
@@ -296,6 +387,10 @@ func CreateArgsAndReply(method string) (Request, Response, error) {
 		return &IncrementRequest{}, &IncrementResponse{}, nil
 	case Delete:
 		return &DeleteRequest{}, &DeleteResponse{}, nil
+	case ConditionalDelete:
+		return &ConditionalDeleteRequest{}, &ConditionalDeleteResponse{}, nil
+	case CompareAndSwap:
+		return &CompareAndSwapRequest{}, &CompareAndSwapResponse{}, nil
 	case DeleteRange:
 		return &DeleteRangeRequest{}, &DeleteRangeResponse{}, nil
 	case Scan:
@@ -312,8 +407,16 @@ func CreateArgsAndReply(method string) (Request, Response, error) {
 		return &EnqueueUpdateRequest{}, &EnqueueUpdateResponse{}, nil
 	case EnqueueMessage:
 		return &EnqueueMessageRequest{}, &EnqueueMessageResponse{}, nil
+	case AcquireLock:
+		return &AcquireLockRequest{}, &AcquireLockResponse{}, nil
+	case Query:
+		return &QueryRequest{}, &QueryResponse{}, nil
 	case AdminSplit:
 		return &AdminSplitRequest{}, &AdminSplitResponse{}, nil
+	case AdminRecoverReplicas:
+		return &AdminRecoverReplicasRequest{}, &AdminRecoverReplicasResponse{}, nil
+	case AdminTransferLease:
+		return &AdminTransferLeaseRequest{}, &AdminTransferLeaseResponse{}, nil
 	case InternalEndTxn:
 		return &InternalEndTxnRequest{}, &InternalEndTxnResponse{}, nil
 	case InternalHeartbeatTxn:
@@ -324,6 +427,18 @@ func CreateArgsAndReply(method string) (Request, Response, error) {
 		return &InternalResolveIntentRequest{}, &InternalResolveIntentResponse{}, nil
 	case InternalSnapshotCopy:
 		return &InternalSnapshotCopyRequest{}, &InternalSnapshotCopyResponse{}, nil
+	case InternalExecute:
+		return &InternalExecuteRequest{}, &InternalExecuteResponse{}, nil
+	case InternalRefresh:
+		return &InternalRefreshRequest{}, &InternalRefreshResponse{}, nil
+	case InternalRangeStats:
+		return &InternalRangeStatsRequest{}, &InternalRangeStatsResponse{}, nil
+	case InternalGC:
+		return &InternalGCRequest{}, &InternalGCResponse{}, nil
+	case InternalScanIntents:
+		return &InternalScanIntentsRequest{}, &InternalScanIntentsResponse{}, nil
+	case InternalReserveSnapshot:
+		return &InternalReserveSnapshotRequest{}, &InternalReserveSnapshotResponse{}, nil
 	}
 	return nil, nil, util.Errorf("unhandled method %s", method)
 }
@@ -354,6 +469,26 @@ func (rh *RequestHeader) Header() *RequestHeader {
 	return rh
 }
 
+// EffectiveReadBound returns the read bound with which a request for
+// the given method is served. Writes and transactional reads are
+// always EXACT, as is a BOUNDED_STALENESS read without a positive
+// MaxStaleness. For compatibility, a positive MaxStaleness without an
+// explicit read bound implies BOUNDED_STALENESS.
+func (rh *RequestHeader) EffectiveReadBound(method string) ReadBound {
+	if !IsReadOnly(method) || rh.Txn != nil {
+		return EXACT
+	}
+	switch rh.ReadBound {
+	case INCONSISTENT:
+		return INCONSISTENT
+	case EXACT, BOUNDED_STALENESS:
+		if rh.MaxStaleness > 0 {
+			return BOUNDED_STALENESS
+		}
+	}
+	return EXACT
+}
+
 // Header implements the Response interface for ResponseHeader.
 func (rh *ResponseHeader) Header() *ResponseHeader {
 	return rh
@@ -368,79 +503,13 @@ func (rh *ResponseHeader) Verify(req Request) error {
 
 // GoError returns the non-nil error from the proto.Error union.
 func (rh *ResponseHeader) GoError() error {
-	if rh.Error == nil {
-		return nil
-	}
-	switch {
-	case rh.Error.Generic != nil:
-		return rh.Error.Generic
-	case rh.Error.NotLeader != nil:
-		return rh.Error.NotLeader
-	case rh.Error.RangeNotFound != nil:
-		return rh.Error.RangeNotFound
-	case rh.Error.RangeKeyMismatch != nil:
-		return rh.Error.RangeKeyMismatch
-	case rh.Error.ReadWithinUncertaintyInterval != nil:
-		return rh.Error.ReadWithinUncertaintyInterval
-	case rh.Error.TransactionAborted != nil:
-		return rh.Error.TransactionAborted
-	case rh.Error.TransactionPush != nil:
-		return rh.Error.TransactionPush
-	case rh.Error.TransactionRetry != nil:
-		return rh.Error.TransactionRetry
-	case rh.Error.TransactionStatus != nil:
-		return rh.Error.TransactionStatus
-	case rh.Error.WriteIntent != nil:
-		return rh.Error.WriteIntent
-	case rh.Error.WriteTooOld != nil:
-		return rh.Error.WriteTooOld
-	case rh.Error.ReadWithinUncertaintyInterval != nil:
-		return rh.Error.ReadWithinUncertaintyInterval
-	default:
-		return nil
-	}
+	return rh.Error.GoError()
 }
 
 // SetGoError converts the specified type into either one of the proto-
 // defined error types or into a GenericError for all other Go errors.
 func (rh *ResponseHeader) SetGoError(err error) {
-	if err == nil {
-		rh.Error = nil
-		return
-	}
-	switch t := err.(type) {
-	case *NotLeaderError:
-		rh.Error = &Error{NotLeader: t}
-	case *RangeNotFoundError:
-		rh.Error = &Error{RangeNotFound: t}
-	case *RangeKeyMismatchError:
-		rh.Error = &Error{RangeKeyMismatch: t}
-	case *ReadWithinUncertaintyIntervalError:
-		rh.Error = &Error{ReadWithinUncertaintyInterval: t}
-	case *TransactionAbortedError:
-		rh.Error = &Error{TransactionAborted: t}
-	case *TransactionPushError:
-		rh.Error = &Error{TransactionPush: t}
-	case *TransactionRetryError:
-		rh.Error = &Error{TransactionRetry: t}
-	case *TransactionStatusError:
-		rh.Error = &Error{TransactionStatus: t}
-	case *WriteIntentError:
-		rh.Error = &Error{WriteIntent: t}
-	case *WriteTooOldError:
-		rh.Error = &Error{WriteTooOld: t}
-	default:
-		var canRetry bool
-		if r, ok := err.(util.Retryable); ok {
-			canRetry = r.CanRetry()
-		}
-		rh.Error = &Error{
-			Generic: &GenericError{
-				Message:   err.Error(),
-				Retryable: canRetry,
-			},
-		}
-	}
+	rh.Error = NewError(err)
 }
 
 // Verify verifies the integrity of the get response value.
@@ -460,8 +529,71 @@ func (cpr *ConditionalPutResponse) Verify(req Request) error {
 	return nil
 }
 
-// Verify verifies the integrity of every value returned in the scan.
+// Verify verifies the integrity of the conditional delete response's
+// actual value, if not nil.
+func (cdr *ConditionalDeleteResponse) Verify(req Request) error {
+	if cdr.ActualValue != nil {
+		return cdr.ActualValue.Verify(req.Header().Key)
+	}
+	return nil
+}
+
+// Verify verifies the integrity of the compare-and-swap response's
+// actual value, if not nil.
+func (csr *CompareAndSwapResponse) Verify(req Request) error {
+	if csr.ActualValue != nil {
+		return csr.ActualValue.Verify(csr.FailedKey)
+	}
+	return nil
+}
+
+// Span returns the smallest key span [key, endKey) containing every
+// key checked or written by the request, or nil keys if there are
+// none. The request header must span at least these keys.
+func (csr *CompareAndSwapRequest) Span() (Key, Key) {
+	var key, endKey Key
+	var found bool
+	extend := func(k Key) {
+		if !found || k.Less(key) {
+			key = k
+		}
+		if !found || !k.Less(endKey) {
+			endKey = k.Next()
+		}
+		found = true
+	}
+	for _, c := range csr.Conditions {
+		extend(c.Key)
+	}
+	for _, w := range csr.Writes {
+		extend(w.Key)
+	}
+	return key, endKey
+}
+
+// Holds returns true if existVal, the value of the condition's key or
+// nil if it has none, matches the expected value. A nil expected
+// value matches only a missing key, and an expected value with
+// neither bytes nor an integer matches any existing value.
+func (c *CompareAndSwapCondition) Holds(existVal *Value) bool {
+	if c.ExpValue == nil || existVal == nil {
+		return c.ExpValue == nil && existVal == nil
+	}
+	if c.ExpValue.Bytes != nil && !bytes.Equal(c.ExpValue.Bytes, existVal.Bytes) {
+		return false
+	}
+	if c.ExpValue.Integer != nil && (existVal.Integer == nil || c.ExpValue.GetInteger() != existVal.GetInteger()) {
+		return false
+	}
+	return true
+}
+
+// Verify verifies the integrity of the rows returned in the scan: the
+// response's checksum, if set, and the checksum of every value.
 func (sr *ScanResponse) Verify(req Request) error {
+	if err := sr.VerifyChecksum(); err != nil {
+		return err
+	}
 	for _, kv := range sr.Rows {
 		if err := kv.Value.Verify(kv.Key); err != nil {
 			return err
@@ -470,6 +602,92 @@ func (sr *ScanResponse) Verify(req Request) error {
 	return nil
 }
 
+// SetChecksum sets the response's checksum to the rolling checksum of
+// its rows. The keys of the rows must not be compressed.
+func (sr *ScanResponse) SetChecksum() {
+	sr.Checksum = gogoproto.Uint32(rowsChecksum(sr.Rows))
+}
+
+// VerifyChecksum verifies that the response's checksum matches the
+// rolling checksum of its rows. It's a noop if the response carries
+// no checksum. Compressed keys must be decompressed first.
+func (sr *ScanResponse) VerifyChecksum() error {
+	if sr.Checksum == nil {
+		return nil
+	}
+	if crc := rowsChecksum(sr.Rows); crc != sr.GetChecksum() {
+		return util.Errorf("scan response checksum mismatch over %d rows: expected %d; got %d",
+			len(sr.Rows), sr.GetChecksum(), crc)
+	}
+	return nil
+}
+
+// rowsChecksum computes the rolling CRC-32 checksum of the keys and
+// values of rows, in order. Keys and byte values are each preceded by
+// their length so that the boundaries between rows are covered too.
+func rowsChecksum(rows []KeyValue) uint32 {
+	c := crc32.NewIEEE()
+	for _, kv := range rows {
+		c.Write(encoding.EncodeUint64(nil, uint64(len(kv.Key))))
+		c.Write(kv.Key)
+		if kv.Value.Integer != nil {
+			c.Write([]byte{1})
+			c.Write(encoding.EncodeUint64(nil, uint64(kv.Value.GetInteger())))
+		} else {
+			c.Write([]byte{0})
+			c.Write(encoding.EncodeUint64(nil, uint64(len(kv.Value.Bytes))))
+			c.Write(kv.Value.Bytes)
+		}
+	}
+	return c.Sum32()
+}
+
+// CompressKeys prefix-compresses the keys of the rows, replacing each
+// key with its suffix following the bytes shared with the key of the
+// preceding row. Keys must be decompressed via DecompressKeys before
+// the rows are used.
+func (sr *ScanResponse) CompressKeys() {
+	if sr.KeyPrefixLengths != nil || len(sr.Rows) == 0 {
+		return
+	}
+	sr.KeyPrefixLengths = make([]uint32, len(sr.Rows))
+	prev := Key(nil)
+	for i := range sr.Rows {
+		key := sr.Rows[i].Key
+		n := 0
+		for n < len(prev) && n < len(key) && prev[n] == key[n] {
+			n++
+		}
+		sr.KeyPrefixLengths[i] = uint32(n)
+		sr.Rows[i].Key = key[n:]
+		prev = key
+	}
+}
+
+// DecompressKeys restores the keys of rows compressed by
+// CompressKeys. It's a noop if the keys aren't compressed.
+func (sr *ScanResponse) DecompressKeys() error {
+	if sr.KeyPrefixLengths == nil {
+		return nil
+	}
+	if len(sr.KeyPrefixLengths) != len(sr.Rows) {
+		return util.Errorf("scan response has %d key prefix lengths for %d rows", len(sr.KeyPrefixLengths), len(sr.Rows))
+	}
+	prev := Key(nil)
+	for i := range sr.Rows {
+		n := int(sr.KeyPrefixLengths[i])
+		if n > len(prev) {
+			return util.Errorf("key prefix length %d of row %d exceeds length of preceding key %q", n, i, prev)
+		}
+		key := make(Key, 0, n+len(sr.Rows[i].Key))
+		key = append(append(key, prev[:n]...), sr.Rows[i].Key...)
+		sr.Rows[i].Key = key
+		prev = key
+	}
+	sr.KeyPrefixLengths = nil
+	return nil
+}
+
 // NewReply constructs a new reply element that is compatible with the
 // supplied channel.
 func NewReply(replyChanI interface{}) Response {
@@ -20,6 +20,8 @@ package proto
 import (
 	"reflect"
 	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
 )
 
 func TestClientCmdIDIsEmpty(t *testing.T) {
@@ -52,3 +54,172 @@ func TestResponseHeaderSetGoError(t *testing.T) {
 		t.Error("expected generic error to be retryable")
 	}
 }
+
+// TestLimitRows verifies truncation of rows to result and byte limits.
+func TestLimitRows(t *testing.T) {
+	var rows []KeyValue
+	for _, key := range []string{"a", "b", "c"} {
+		rows = append(rows, KeyValue{Key: Key(key), Value: Value{Bytes: []byte("1234")}})
+	}
+	testCases := []struct {
+		maxResults, maxBytes int64
+		expCount             int
+		expFull              bool
+	}{
+		{0, 0, 3, false},
+		{3, 0, 3, true},
+		{4, 0, 3, false},
+		{2, 0, 2, true},
+		{0, 10, 2, true},
+		{0, 11, 3, true},
+		{0, 16, 3, false},
+		{2, 15, 2, true},
+		{3, 6, 2, true},
+	}
+	for i, test := range testCases {
+		limited, full := LimitRows(rows, test.maxResults, test.maxBytes)
+		if len(limited) != test.expCount || full != test.expFull {
+			t.Errorf("%d: expected %d rows, full=%t; got %d, %t", i, test.expCount, test.expFull, len(limited), full)
+		}
+	}
+}
+
+// TestScanResponseCompressKeys verifies that prefix-compressed keys
+// are restored by decompression and that invalid prefix lengths are
+// detected.
+func TestScanResponseCompressKeys(t *testing.T) {
+	keys := []string{"", "a", "abc", "abd", "b", "bcd", "bcd\x00"}
+	sr := &ScanResponse{}
+	for _, key := range keys {
+		sr.Rows = append(sr.Rows, KeyValue{Key: Key(key)})
+	}
+	sr.CompressKeys()
+	if expected := []uint32{0, 0, 1, 2, 0, 1, 3}; !reflect.DeepEqual(sr.KeyPrefixLengths, expected) {
+		t.Errorf("expected prefix lengths %v; got %v", expected, sr.KeyPrefixLengths)
+	}
+	if err := sr.DecompressKeys(); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if !sr.Rows[i].Key.Equal(Key(key)) {
+			t.Errorf("%d: expected key %q; got %q", i, key, sr.Rows[i].Key)
+		}
+	}
+	if sr.KeyPrefixLengths != nil {
+		t.Error("expected prefix lengths to be cleared")
+	}
+	// Decompressing uncompressed keys is a noop.
+	if err := sr.DecompressKeys(); err != nil || !sr.Rows[2].Key.Equal(Key("abc")) {
+		t.Errorf("expected noop decompression; got %q, %v", sr.Rows[2].Key, err)
+	}
+
+	for i, lengths := range [][]uint32{{0, 2}, {0}} {
+		bad := &ScanResponse{Rows: []KeyValue{{Key: Key("a")}, {Key: Key("b")}}, KeyPrefixLengths: lengths}
+		if err := bad.DecompressKeys(); err == nil {
+			t.Errorf("%d: expected error decompressing with prefix lengths %v", i, lengths)
+		}
+	}
+}
+
+// TestScanResponseChecksum verifies that the checksum of scanned rows
+// detects changes to keys, values and the boundaries between them.
+func TestScanResponseChecksum(t *testing.T) {
+	rows := func() []KeyValue {
+		return []KeyValue{
+			{Key: Key("a"), Value: Value{Bytes: []byte("bc")}},
+			{Key: Key("d"), Value: Value{Integer: gogoproto.Int64(1)}},
+		}
+	}
+	sr := &ScanResponse{Rows: rows()}
+	if err := sr.VerifyChecksum(); err != nil {
+		t.Errorf("expected noop verification without checksum; got %s", err)
+	}
+	sr.SetChecksum()
+	if err := sr.VerifyChecksum(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sr.Verify(&ScanRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	corruptions := []func(rows []KeyValue) []KeyValue{
+		func(rows []KeyValue) []KeyValue { rows[0].Value.Bytes[1] = 'x'; return rows },
+		func(rows []KeyValue) []KeyValue { rows[1].Key = Key("e"); return rows },
+		func(rows []KeyValue) []KeyValue { rows[1].Value.Integer = gogoproto.Int64(2); return rows },
+		func(rows []KeyValue) []KeyValue { rows[1].Value = Value{Bytes: []byte{}}; return rows },
+		func(rows []KeyValue) []KeyValue {
+			rows[0].Key, rows[0].Value.Bytes = Key("ab"), []byte("c")
+			return rows
+		},
+		func(rows []KeyValue) []KeyValue { return rows[:1] },
+		func(rows []KeyValue) []KeyValue { return []KeyValue{rows[1], rows[0]} },
+	}
+	for i, corrupt := range corruptions {
+		bad := &ScanResponse{Rows: corrupt(rows()), Checksum: sr.Checksum}
+		if err := bad.VerifyChecksum(); err == nil {
+			t.Errorf("%d: expected checksum mismatch for rows %+v", i, bad.Rows)
+		}
+		if err := bad.Verify(&ScanRequest{}); err == nil {
+			t.Errorf("%d: expected verification failure for rows %+v", i, bad.Rows)
+		}
+	}
+}
+
+// TestEffectiveReadBound verifies that only non-transactional reads
+// relax their consistency and that MaxStaleness implies a bounded
+// staleness read.
+func TestEffectiveReadBound(t *testing.T) {
+	testCases := []struct {
+		method string
+		header RequestHeader
+		expect ReadBound
+	}{
+		{Get, RequestHeader{}, EXACT},
+		{Get, RequestHeader{MaxStaleness: 1}, BOUNDED_STALENESS},
+		{Get, RequestHeader{ReadBound: BOUNDED_STALENESS, MaxStaleness: 1}, BOUNDED_STALENESS},
+		{Get, RequestHeader{ReadBound: BOUNDED_STALENESS}, EXACT},
+		{Scan, RequestHeader{ReadBound: INCONSISTENT}, INCONSISTENT},
+		{Scan, RequestHeader{ReadBound: INCONSISTENT, MaxStaleness: 1}, INCONSISTENT},
+		{Get, RequestHeader{ReadBound: INCONSISTENT, Txn: &Transaction{}}, EXACT},
+		{Put, RequestHeader{ReadBound: INCONSISTENT}, EXACT},
+		{Put, RequestHeader{MaxStaleness: 1}, EXACT},
+	}
+	for i, test := range testCases {
+		if bound := test.header.EffectiveReadBound(test.method); bound != test.expect {
+			t.Errorf("%d: expected %s; got %s", i, test.expect, bound)
+		}
+	}
+}
+
+// TestCompareAndSwapRequest verifies the span of a compare-and-swap's
+// keys and the evaluation of its conditions.
+func TestCompareAndSwapRequest(t *testing.T) {
+	req := &CompareAndSwapRequest{
+		Conditions: []CompareAndSwapCondition{{Key: Key("c")}},
+		Writes:     []CompareAndSwapWrite{{Key: Key("d")}, {Key: Key("a")}},
+	}
+	if key, endKey := req.Span(); !key.Equal(Key("a")) || !endKey.Equal(Key("d").Next()) {
+		t.Errorf("expected span [a, d\\x00); got [%q, %q)", key, endKey)
+	}
+
+	value := &Value{Bytes: []byte("v")}
+	testCases := []struct {
+		expValue, existVal *Value
+		holds              bool
+	}{
+		{nil, nil, true},
+		{nil, value, false},
+		{value, nil, false},
+		{value, value, true},
+		{&Value{Bytes: []byte("w")}, value, false},
+		{&Value{}, value, true},
+		{&Value{Integer: gogoproto.Int64(1)}, &Value{Integer: gogoproto.Int64(1)}, true},
+		{&Value{Integer: gogoproto.Int64(1)}, value, false},
+	}
+	for i, test := range testCases {
+		c := CompareAndSwapCondition{Key: Key("a"), ExpValue: test.expValue}
+		if holds := c.Holds(test.existVal); holds != test.holds {
+			t.Errorf("%d: expected holds %t; got %t", i, test.holds, holds)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// ComputeHash returns the SHA-256 hash of the entry's contents,
+// including the hash of its predecessor but excluding its own hash.
+func (e *AuditEntry) ComputeHash() ([]byte, error) {
+	unhashed := *e
+	unhashed.Hash = nil
+	data, err := gogoproto.Marshal(&unhashed)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// VerifyAuditLog verifies that entries form an unbroken chain: that
+// their sequence numbers are consecutive, that each entry links to
+// the hash of its predecessor and that each entry's hash matches its
+// contents. The first entry must link to prevHash, which is empty if
+// entries begin at the start of the log. Returns an error describing
+// the first entry which fails verification.
+func VerifyAuditLog(entries []AuditEntry, prevHash []byte) error {
+	for i := range entries {
+		e := &entries[i]
+		if i > 0 && e.Sequence != entries[i-1].Sequence+1 {
+			return util.Errorf("audit entry %d follows entry %d", e.Sequence, entries[i-1].Sequence)
+		}
+		if !bytes.Equal(e.PrevHash, prevHash) {
+			return util.Errorf("audit entry %d doesn't link to its predecessor", e.Sequence)
+		}
+		hash, err := e.ComputeHash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(e.Hash, hash) {
+			return util.Errorf("audit entry %d doesn't match its hash", e.Sequence)
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
@@ -23,6 +23,8 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+
+	"github.com/cockroachdb/cockroach/util"
 )
 
 // IsSubset returns whether attributes list b is a subset of
@@ -55,6 +57,166 @@ func (a Attributes) SortedString() string {
 	return strings.Join(attrs, ",")
 }
 
+// ParseLocality parses a locality from a comma-separated list of
+// key=value tiers, ordered from most to least inclusive, e.g.
+// "region=us-east,az=us-east-1a,rack=12". An empty string yields an
+// empty locality.
+func ParseLocality(s string) (Locality, error) {
+	var l Locality
+	if s == "" {
+		return l, nil
+	}
+	seen := map[string]struct{}{}
+	for _, tier := range strings.Split(s, ",") {
+		parts := strings.SplitN(tier, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return Locality{}, util.Errorf("locality tier %q must be of the form key=value", tier)
+		}
+		if _, ok := seen[parts[0]]; ok {
+			return Locality{}, util.Errorf("locality tier %q is specified more than once", parts[0])
+		}
+		seen[parts[0]] = struct{}{}
+		l.Tiers = append(l.Tiers, Tier{Key: parts[0], Value: parts[1]})
+	}
+	return l, nil
+}
+
+// String formats the locality as a comma-separated list of key=value
+// tiers, as accepted by ParseLocality.
+func (l Locality) String() string {
+	tiers := make([]string, len(l.Tiers))
+	for i, t := range l.Tiers {
+		tiers[i] = t.Key + "=" + t.Value
+	}
+	return strings.Join(tiers, ",")
+}
+
+// Value returns the value of the locality's tier with the given key
+// and whether the locality has the tier.
+func (l Locality) Value(key string) (string, bool) {
+	for _, t := range l.Tiers {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// DiversityScore returns how far apart two localities are, from 0 if
+// they're identical to 1 if they differ in their most inclusive tier:
+// the fraction of tiers remaining below the longest common prefix of
+// tiers. Localities which don't share their first tier, including
+// empty localities, are considered unrelated and score 0.
+func (l Locality) DiversityScore(other Locality) float64 {
+	n := len(l.Tiers)
+	if len(other.Tiers) > n {
+		n = len(other.Tiers)
+	}
+	if len(l.Tiers) == 0 || len(other.Tiers) == 0 || l.Tiers[0].Key != other.Tiers[0].Key {
+		return 0
+	}
+	for i := 0; i < n; i++ {
+		if i >= len(l.Tiers) || i >= len(other.Tiers) ||
+			l.Tiers[i].Key != other.Tiers[i].Key || l.Tiers[i].Value != other.Tiers[i].Value {
+			return float64(n-i) / float64(n)
+		}
+	}
+	return 0
+}
+
+// ConstraintType is the kind of a replica placement Constraint.
+type ConstraintType int
+
+const (
+	// ConstraintRequired places replicas only on nodes whose locality
+	// has the tier. It's written "+key=value".
+	ConstraintRequired ConstraintType = iota
+	// ConstraintProhibited places no replicas on nodes whose locality
+	// has the tier. It's written "-key=value".
+	ConstraintProhibited
+	// ConstraintUnique places at most one replica in each value of
+	// the tier, and none on nodes whose locality lacks it. It's
+	// written "unique:key".
+	ConstraintUnique
+)
+
+// A Constraint restricts the placement of a zone's replicas by the
+// locality tiers of their nodes. Constraints are written in zone
+// configs as strings (see ParseConstraint).
+type Constraint struct {
+	Type  ConstraintType
+	Key   string
+	Value string // Empty for ConstraintUnique
+}
+
+// ParseConstraint parses a constraint of the form "+key=value",
+// "-key=value" or "unique:key".
+func ParseConstraint(s string) (Constraint, error) {
+	if strings.HasPrefix(s, "unique:") {
+		if key := s[len("unique:"):]; key != "" && !strings.ContainsAny(key, "=,") {
+			return Constraint{Type: ConstraintUnique, Key: key}, nil
+		}
+	} else if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		parts := strings.SplitN(s[1:], "=", 2)
+		if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			c := Constraint{Type: ConstraintRequired, Key: parts[0], Value: parts[1]}
+			if s[0] == '-' {
+				c.Type = ConstraintProhibited
+			}
+			return c, nil
+		}
+	}
+	return Constraint{}, util.Errorf("constraint %q must be of the form +key=value, -key=value or unique:key", s)
+}
+
+// ParseConstraints parses each of the constraints of a zone config.
+func ParseConstraints(strs []string) ([]Constraint, error) {
+	constraints := make([]Constraint, 0, len(strs))
+	for _, s := range strs {
+		c, err := ParseConstraint(s)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, nil
+}
+
+// String formats the constraint as accepted by ParseConstraint.
+func (c Constraint) String() string {
+	switch c.Type {
+	case ConstraintRequired:
+		return "+" + c.Key + "=" + c.Value
+	case ConstraintProhibited:
+		return "-" + c.Key + "=" + c.Value
+	default:
+		return "unique:" + c.Key
+	}
+}
+
+// Permits returns whether a replica may be placed on a node with the
+// given locality, alongside replicas on nodes with the localities in
+// others.
+func (c Constraint) Permits(l Locality, others []Locality) bool {
+	value, ok := l.Value(c.Key)
+	switch c.Type {
+	case ConstraintRequired:
+		return ok && value == c.Value
+	case ConstraintProhibited:
+		return !ok || value != c.Value
+	default:
+		if !ok {
+			return false
+		}
+		for _, o := range others {
+			if v, ok := o.Value(c.Key); ok && v == value {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 // ContainsKey returns whether this RangeDescriptor contains the specified key.
 func (r *RangeDescriptor) ContainsKey(key []byte) bool {
 	return bytes.Compare(key, r.StartKey) >= 0 && bytes.Compare(key, r.EndKey) < 0
@@ -93,6 +255,17 @@ func (p *PermConfig) CanRead(user string) bool {
 	return false
 }
 
+// CanTag does a linear search for tag to verify that requests may
+// carry it.
+func (p *PermConfig) CanTag(tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // CanWrite does a linear search for user to verify write permission.
 func (p *PermConfig) CanWrite(user string) bool {
 	for _, u := range p.Write {
@@ -19,12 +19,13 @@ package proto
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 )
 
 func TestAttributesIsSubset(t *testing.T) {
 	a := Attributes{Attrs: []string{"a", "b", "c"}}
-	b := Attributes{Attrs: []string{"a", "b"}}
+	b := Attributes{Attrs: []string{Key: "a", Value: "b"}}
 	c := Attributes{Attrs: []string{"a"}}
 	if !b.IsSubset(a) {
 		t.Errorf("expected %+v to be a subset of %+v", b, a)
@@ -132,7 +133,8 @@ func TestRangeDescriptorContains(t *testing.T) {
 func TestPermConfig(t *testing.T) {
 	p := &PermConfig{
 		Read:  []string{"foo", "bar", "baz"},
-		Write: []string{"foo", "baz"},
+		Write: []string{Key: "foo", Value: "baz"},
+		Tags:  []string{"app1"},
 	}
 	for _, u := range p.Read {
 		if !p.CanRead(u) {
@@ -150,4 +152,138 @@ func TestPermConfig(t *testing.T) {
 	if p.CanWrite("bar") {
 		t.Errorf("unexpected read access for user \"bar\"")
 	}
+	if !p.CanTag("app1") {
+		t.Errorf("expected permission for tag \"app1\"")
+	}
+	if p.CanTag("app2") {
+		t.Errorf("unexpected permission for tag \"app2\"")
+	}
+}
+
+func TestParseLocality(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected Locality
+		expErr   bool
+	}{
+		{"", Locality{}, false},
+		{"region=us-east", Locality{Tiers: []Tier{{Key: "region", Value: "us-east"}}}, false},
+		{"region=us-east,az=us-east-1a,rack=12",
+			Locality{Tiers: []Tier{{Key: "region", Value: "us-east"}, {Key: "az", Value: "us-east-1a"}, {Key: "rack", Value: "12"}}}, false},
+		{"region", Locality{}, true},
+		{"region=", Locality{}, true},
+		{"=us-east", Locality{}, true},
+		{"region=us-east,region=us-west", Locality{}, true},
+	}
+	for i, test := range testCases {
+		l, err := ParseLocality(test.s)
+		if (err != nil) != test.expErr {
+			t.Errorf("%d: expected error %t; got %v", i, test.expErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(l, test.expected) {
+			t.Errorf("%d: expected %+v; got %+v", i, test.expected, l)
+		}
+		if err == nil && l.String() != test.s {
+			t.Errorf("%d: expected %q to format as itself; got %q", i, test.s, l.String())
+		}
+	}
+}
+
+func TestLocalityDiversityScore(t *testing.T) {
+	parse := func(s string) Locality {
+		l, err := ParseLocality(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	testCases := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"", "", 0},
+		{"region=us-east", "", 0},
+		{"region=us-east,az=a,rack=1", "region=us-east,az=a,rack=1", 0},
+		{"region=us-east,az=a,rack=1", "region=us-east,az=a,rack=2", 1.0 / 3},
+		{"region=us-east,az=a,rack=1", "region=us-east,az=b,rack=1", 2.0 / 3},
+		{"region=us-east,az=a,rack=1", "region=us-west,az=a,rack=1", 1},
+		{"region=us-east,az=a", "region=us-east", 0.5},
+		{"region=us-east", "dc=us-east", 0},
+	}
+	for i, test := range testCases {
+		if score := parse(test.a).DiversityScore(parse(test.b)); score != test.expected {
+			t.Errorf("%d: expected %f; got %f", i, test.expected, score)
+		}
+		if score := parse(test.b).DiversityScore(parse(test.a)); score != test.expected {
+			t.Errorf("%d: expected symmetric score %f; got %f", i, test.expected, score)
+		}
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected Constraint
+		expErr   bool
+	}{
+		{"+region=us-east", Constraint{ConstraintRequired, "region", "us-east"}, false},
+		{"-rack=12", Constraint{ConstraintProhibited, "rack", "12"}, false},
+		{"unique:region", Constraint{ConstraintUnique, "region", ""}, false},
+		{"region=us-east", Constraint{}, true},
+		{"+region", Constraint{}, true},
+		{"-=12", Constraint{}, true},
+		{"unique:", Constraint{}, true},
+		{"unique:region=us-east", Constraint{}, true},
+		{"", Constraint{}, true},
+	}
+	for i, test := range testCases {
+		c, err := ParseConstraint(test.s)
+		if (err != nil) != test.expErr {
+			t.Errorf("%d: expected error %t; got %v", i, test.expErr, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: expected %+v; got %+v", i, test.expected, c)
+		}
+		if err == nil && c.String() != test.s {
+			t.Errorf("%d: expected %q to format as itself; got %q", i, test.s, c.String())
+		}
+	}
+	if _, err := ParseConstraints([]string{"+region=us-east", "bogus"}); err == nil {
+		t.Error("expected invalid constraint to fail parsing")
+	}
+}
+
+func TestConstraintPermits(t *testing.T) {
+	east := Locality{Tiers: []Tier{{Key: "region", Value: "us-east"}, {Key: "rack", Value: "1"}}}
+	west := Locality{Tiers: []Tier{{Key: "region", Value: "us-west"}, {Key: "rack", Value: "1"}}}
+	none := Locality{}
+	testCases := []struct {
+		c        string
+		l        Locality
+		others   []Locality
+		expected bool
+	}{
+		{"+region=us-east", east, nil, true},
+		{"+region=us-east", west, nil, false},
+		{"+region=us-east", none, nil, false},
+		{"-region=us-east", east, nil, false},
+		{"-region=us-east", west, nil, true},
+		{"-region=us-east", none, nil, true},
+		{"unique:region", east, nil, true},
+		{"unique:region", east, []Locality{west}, true},
+		{"unique:region", east, []Locality{west, east}, false},
+		{"unique:rack", east, []Locality{west}, false},
+		{"unique:region", none, nil, false},
+	}
+	for i, test := range testCases {
+		c, err := ParseConstraint(test.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if permits := c.Permits(test.l, test.others); permits != test.expected {
+			t.Errorf("%d: expected %s to permit %s: %t", i, test.c, test.l, test.expected)
+		}
+	}
 }
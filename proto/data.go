@@ -20,6 +20,7 @@ package proto
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/rand"
@@ -158,17 +159,26 @@ func (k *EncodedKey) Unmarshal(bytes []byte) error {
 }
 
 // The following methods implement custom unmarshalling necessary
-// for key objects to be converted from JSON.
+// for key objects to be converted from JSON. Keys are encoded as
+// base64 strings, as are all byte slices marshalled to JSON.
 
 // UnmarshalJSON implements the json Unmarshaler interface.
 func (k *Key) UnmarshalJSON(bytes []byte) error {
-	*k = Key(append([]byte(nil), bytes...))
+	var b []byte
+	if err := json.Unmarshal(bytes, &b); err != nil {
+		return err
+	}
+	*k = Key(b)
 	return nil
 }
 
 // UnmarshalJSON implements the json Unmarshaler interface.
 func (k *EncodedKey) UnmarshalJSON(bytes []byte) error {
-	*k = EncodedKey(append([]byte(nil), bytes...))
+	var b []byte
+	if err := json.Unmarshal(bytes, &b); err != nil {
+		return err
+	}
+	*k = EncodedKey(b)
 	return nil
 }
 
@@ -349,6 +359,28 @@ func (t *Transaction) UpgradePriority(minPriority int32) {
 	}
 }
 
+// AddIgnoredSeqNums records that the writes of the transaction with
+// sequence numbers in the inclusive range [start, end] have been
+// rolled back.
+func (t *Transaction) AddIgnoredSeqNums(start, end int32) {
+	t.IgnoredSeqNums = append(t.IgnoredSeqNums, IgnoredSeqNumRange{Start: start, End: end})
+}
+
+// IsSeqNumIgnored returns whether the write of the transaction with the
+// specified sequence number has been rolled back. Unsequenced writes
+// are never ignored.
+func (t *Transaction) IsSeqNumIgnored(seq int32) bool {
+	if seq == 0 {
+		return false
+	}
+	for _, r := range t.GetIgnoredSeqNums() {
+		if r.Start <= seq && seq <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
 // MD5 returns the MD5 digest of the transaction ID as a string.
 // This method returns an empty string if the transaction is nil.
 func (t *Transaction) MD5() [md5.Size]byte {
@@ -363,3 +395,27 @@ func (t Transaction) String() string {
 	return fmt.Sprintf("%q {id=%s pri=%d, iso=%s, stat=%s, epo=%d, ts=%s maxts=%s}",
 		t.Name, t.ID, t.Priority, t.Isolation, t.Status, t.Epoch, t.Timestamp, t.MaxTimestamp)
 }
+
+// Add adds the counts of oms to ms.
+func (ms *MVCCStats) Add(oms MVCCStats) {
+	ms.LiveBytes += oms.LiveBytes
+	ms.KeyBytes += oms.KeyBytes
+	ms.ValBytes += oms.ValBytes
+	ms.IntentBytes += oms.IntentBytes
+	ms.LiveCount += oms.LiveCount
+	ms.KeyCount += oms.KeyCount
+	ms.ValCount += oms.ValCount
+	ms.IntentCount += oms.IntentCount
+}
+
+// Subtract subtracts the counts of oms from ms.
+func (ms *MVCCStats) Subtract(oms MVCCStats) {
+	ms.LiveBytes -= oms.LiveBytes
+	ms.KeyBytes -= oms.KeyBytes
+	ms.ValBytes -= oms.ValBytes
+	ms.IntentBytes -= oms.IntentBytes
+	ms.LiveCount -= oms.LiveCount
+	ms.KeyCount -= oms.KeyCount
+	ms.ValCount -= oms.ValCount
+	ms.IntentCount -= oms.IntentCount
+}
@@ -19,6 +19,7 @@ package proto
 
 import (
 	"bytes"
+	"encoding/json"
 	"math"
 	"testing"
 
@@ -164,6 +165,27 @@ func TestKeyString(t *testing.T) {
 	}
 }
 
+// TestKeyJSON verifies that keys survive a round trip through JSON.
+func TestKeyJSON(t *testing.T) {
+	for i, key := range []Key{nil, Key(""), Key("a"), Key("\x00\xff\"quoted\"")} {
+		b, err := json.Marshal(RequestHeader{Key: key, EndKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var header RequestHeader
+		if err := json.Unmarshal(b, &header); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !header.Key.Equal(key) || !header.EndKey.Equal(key) {
+			t.Errorf("%d: expected key %q; got %q, %q", i, key, header.Key, header.EndKey)
+		}
+	}
+	var encKey EncodedKey
+	if err := json.Unmarshal([]byte(`"YXBwbGU="`), &encKey); err != nil || string(encKey) != "apple" {
+		t.Errorf("expected encoded key %q; got %q, %v", "apple", encKey, err)
+	}
+}
+
 func makeTS(walltime int64, logical int32) Timestamp {
 	return Timestamp{
 		WallTime: walltime,
@@ -265,3 +287,19 @@ func TestValueChecksumWithInteger(t *testing.T) {
 		}
 	}
 }
+
+// TestTransactionIgnoredSeqNums verifies that sequence numbers are
+// ignored only within the ranges rolled back.
+func TestTransactionIgnoredSeqNums(t *testing.T) {
+	txn := &Transaction{}
+	txn.AddIgnoredSeqNums(3, 5)
+	txn.AddIgnoredSeqNums(8, 8)
+	for seq, expected := range []bool{false, false, false, true, true, true, false, false, true, false} {
+		if ignored := txn.IsSeqNumIgnored(int32(seq)); ignored != expected {
+			t.Errorf("sequence %d: expected ignored=%t; got %t", seq, expected, ignored)
+		}
+	}
+	if (*Transaction)(nil).IsSeqNumIgnored(1) {
+		t.Error("expected no ignored sequence numbers for nil transaction")
+	}
+}
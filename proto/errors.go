@@ -17,7 +17,103 @@
 
 package proto
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// NewError converts the specified Go error into an Error union. Errors
+// of a type represented in the union are stored as-is; all other Go
+// errors are converted into a GenericError, preserving the error
+// message and whether or not the error is retryable. Returns nil if
+// err is nil.
+func NewError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{}
+	if !e.SetValue(err) {
+		var canRetry bool
+		if r, ok := err.(util.Retryable); ok {
+			canRetry = r.CanRetry()
+		}
+		e.Generic = &GenericError{
+			Message:   err.Error(),
+			Retryable: canRetry,
+		}
+	}
+	return e
+}
+
+// GoError returns the non-nil error from the Error union, or nil if
+// e is nil or no error is set.
+func (e *Error) GoError() error {
+	if e == nil {
+		return nil
+	}
+	if v := e.GetValue(); v != nil {
+		return v.(error)
+	}
+	return nil
+}
+
+// Key returns the key at which the error was encountered for error
+// details which carry one, or nil otherwise.
+func (e *Error) Key() Key {
+	switch {
+	case e == nil:
+		return nil
+	case e.WriteIntent != nil:
+		return e.WriteIntent.Key
+	case e.WriteTooOld != nil:
+		return e.WriteTooOld.Key
+	case e.ReadWithinUncertaintyInterval != nil:
+		return e.ReadWithinUncertaintyInterval.Key
+	case e.RangeKeyMismatch != nil:
+		return e.RangeKeyMismatch.RequestStartKey
+	}
+	return nil
+}
+
+// ConflictingTxn returns the transaction which conflicted with the
+// request for error details which carry one, or nil otherwise.
+func (e *Error) ConflictingTxn() *Transaction {
+	switch {
+	case e == nil:
+		return nil
+	case e.WriteIntent != nil:
+		return &e.WriteIntent.Txn
+	case e.TransactionPush != nil:
+		return &e.TransactionPush.PusheeTxn
+	}
+	return nil
+}
+
+// ExistingTimestamp returns the timestamp of the existing value which
+// conflicted with the request for error details which carry one, or
+// nil otherwise. Retries should use a timestamp greater than this.
+func (e *Error) ExistingTimestamp() *Timestamp {
+	switch {
+	case e == nil:
+		return nil
+	case e.WriteTooOld != nil:
+		return &e.WriteTooOld.ExistingTimestamp
+	case e.ReadWithinUncertaintyInterval != nil:
+		return &e.ReadWithinUncertaintyInterval.ExistingTimestamp
+	}
+	return nil
+}
+
+// CanRetry returns whether the error indicates the request may be
+// retried.
+func (e *Error) CanRetry() bool {
+	if r, ok := e.GoError().(util.Retryable); ok {
+		return r.CanRetry()
+	}
+	return false
+}
 
 // Error implements the Go error interface.
 func (ge *GenericError) Error() string {
@@ -123,17 +219,165 @@ func (e *TransactionStatusError) Error() string {
 	return fmt.Sprintf("txn %s: %s", e.Txn, e.Msg)
 }
 
+// NewWriteIntentError initializes a new WriteIntentError.
+func NewWriteIntentError(key Key, txn *Transaction, resolved bool) *WriteIntentError {
+	return &WriteIntentError{Key: key, Txn: *txn, Resolved: resolved}
+}
+
 // Error formats error.
 func (e *WriteIntentError) Error() string {
 	return fmt.Sprintf("conflicting write intent at key %q from transaction %s: resolved? %t", e.Key, e.Txn, e.Resolved)
 }
 
+// NewWriteTooOldError initializes a new WriteTooOldError.
+func NewWriteTooOldError(key Key, timestamp, existingTimestamp Timestamp) *WriteTooOldError {
+	return &WriteTooOldError{
+		Timestamp:         timestamp,
+		ExistingTimestamp: existingTimestamp,
+		Key:               key,
+	}
+}
+
 // Error formats error.
 func (e *WriteTooOldError) Error() string {
-	return fmt.Sprintf("write too old: timestamp %s < %s", e.Timestamp, e.ExistingTimestamp)
+	return fmt.Sprintf("write too old at key %q: timestamp %s < %s", e.Key, e.Timestamp, e.ExistingTimestamp)
+}
+
+// NewReadWithinUncertaintyIntervalError initializes a new
+// ReadWithinUncertaintyIntervalError.
+func NewReadWithinUncertaintyIntervalError(key Key, timestamp, existingTimestamp Timestamp) *ReadWithinUncertaintyIntervalError {
+	return &ReadWithinUncertaintyIntervalError{
+		Timestamp:         timestamp,
+		ExistingTimestamp: existingTimestamp,
+		Key:               key,
+	}
 }
 
 // Error formats error.
 func (e *ReadWithinUncertaintyIntervalError) Error() string {
-	return fmt.Sprintf("read at time %s encountered previous write with future timestamp %s within uncertainty interval", e.Timestamp, e.ExistingTimestamp)
+	return fmt.Sprintf("read at key %q, time %s encountered previous write with future timestamp %s within uncertainty interval", e.Key, e.Timestamp, e.ExistingTimestamp)
+}
+
+// NewCommandReplayError initializes a new CommandReplayError.
+func NewCommandReplayError(sessionID, seq, ackSeq int64) *CommandReplayError {
+	return &CommandReplayError{
+		SessionID: sessionID,
+		Seq:       seq,
+		AckSeq:    ackSeq,
+	}
+}
+
+// Error formats error.
+func (e *CommandReplayError) Error() string {
+	return fmt.Sprintf("replay of command %d from session %d rejected; session has acknowledged commands through %d",
+		e.Seq, e.SessionID, e.AckSeq)
+}
+
+// NewAmbiguousResultError initializes a new AmbiguousResultError
+// describing the specified underlying error.
+func NewAmbiguousResultError(err error) *AmbiguousResultError {
+	return &AmbiguousResultError{Message: err.Error()}
+}
+
+// Error formats error.
+func (e *AmbiguousResultError) Error() string {
+	return fmt.Sprintf("result is ambiguous: %s", e.Message)
+}
+
+// NewRequestTooLargeError initializes a new RequestTooLargeError.
+func NewRequestTooLargeError(field string, size, maxSize int64) *RequestTooLargeError {
+	return &RequestTooLargeError{
+		Field:   field,
+		Size:    size,
+		MaxSize: maxSize,
+	}
+}
+
+// Error formats error.
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("%s size %d exceeds maximum of %d bytes", e.Field, e.Size, e.MaxSize)
+}
+
+// NewMemoryBudgetExceededError initializes a new
+// MemoryBudgetExceededError.
+func NewMemoryBudgetExceededError(requested, used, limit int64) *MemoryBudgetExceededError {
+	return &MemoryBudgetExceededError{
+		Requested: requested,
+		Used:      used,
+		Limit:     limit,
+	}
+}
+
+// Error formats error.
+func (e *MemoryBudgetExceededError) Error() string {
+	return fmt.Sprintf("memory budget exceeded: %d bytes requested, %d of %d bytes already in use",
+		e.Requested, e.Used, e.Limit)
+}
+
+// NewUnsupportedVersionError initializes a new UnsupportedVersionError.
+func NewUnsupportedVersionError(method string, required, active ClusterVersion) *UnsupportedVersionError {
+	return &UnsupportedVersionError{
+		Method:          method,
+		RequiredVersion: int64(required),
+		ActiveVersion:   int64(active),
+	}
+}
+
+// Error formats error.
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("%s is unsupported in this version: requires cluster version %d; active version is %d",
+		e.Method, e.RequiredVersion, e.ActiveVersion)
+}
+
+// NewKeyExistsError initializes a new KeyExistsError.
+func NewKeyExistsError(key Key, existingTimestamp Timestamp) *KeyExistsError {
+	return &KeyExistsError{
+		Key:               key,
+		ExistingTimestamp: existingTimestamp,
+	}
+}
+
+// Error formats error.
+func (e *KeyExistsError) Error() string {
+	return fmt.Sprintf("blind put encountered existing value at key %q written at %s", e.Key, e.ExistingTimestamp)
+}
+
+// NewReadBeforeGCThresholdError initializes a new
+// ReadBeforeGCThresholdError.
+func NewReadBeforeGCThresholdError(timestamp, threshold Timestamp) *ReadBeforeGCThresholdError {
+	return &ReadBeforeGCThresholdError{
+		Timestamp: timestamp,
+		Threshold: threshold,
+	}
+}
+
+// Error formats error.
+func (e *ReadBeforeGCThresholdError) Error() string {
+	return fmt.Sprintf("read at %s precedes GC threshold %s; historical values may have been garbage collected",
+		e.Timestamp, e.Threshold)
+}
+
+// NewRangeUnavailableError initializes a new RangeUnavailableError.
+func NewRangeUnavailableError(raftID int64, replica Replica, duration time.Duration) *RangeUnavailableError {
+	return &RangeUnavailableError{
+		RaftID:   raftID,
+		Replica:  replica,
+		Duration: duration.Nanoseconds(),
+	}
+}
+
+// Error formats error.
+func (e *RangeUnavailableError) Error() string {
+	return fmt.Sprintf("range %d is unavailable at replica %+v: no command has been applied for %s",
+		e.RaftID, e.Replica, time.Duration(e.Duration))
+}
+
+// NewOpRequiresTxnError initializes a new OpRequiresTxnError.
+func NewOpRequiresTxnError(method string) *OpRequiresTxnError {
+	return &OpRequiresTxnError{Method: method}
+}
+
+// Error formats error.
+func (e *OpRequiresTxnError) Error() string {
+	return fmt.Sprintf("%s spans multiple ranges and requires a transaction", e.Method)
 }
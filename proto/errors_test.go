@@ -0,0 +1,111 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+)
+
+// TestErrorRoundTrip verifies that each error type in the Error union
+// survives conversion to and from the union and its wire encoding,
+// including the structured detail fields.
+func TestErrorRoundTrip(t *testing.T) {
+	txn := &Transaction{Name: "test", ID: []byte("id"), Priority: 1}
+	testCases := []error{
+		&GenericError{Message: "generic", Retryable: true},
+		&NotLeaderError{Leader: Replica{NodeID: 1, StoreID: 2}},
+		NewRangeNotFoundError(1),
+		NewRangeKeyMismatchError(Key("a"), Key("b"), nil),
+		NewReadWithinUncertaintyIntervalError(Key("a"), makeTS(1, 0), makeTS(2, 0)),
+		NewTransactionAbortedError(txn),
+		NewTransactionPushError(nil, txn),
+		NewTransactionRetryError(txn),
+		NewTransactionStatusError(txn, "msg"),
+		NewWriteIntentError(Key("a"), txn, true),
+		NewWriteTooOldError(Key("a"), makeTS(1, 0), makeTS(2, 0)),
+		NewCommandReplayError(1, 2, 3),
+		NewAmbiguousResultError(errors.New("connection closed")),
+		NewRequestTooLargeError("value", 2, 1),
+		NewMemoryBudgetExceededError(3, 2, 4),
+		NewUnsupportedVersionError(ConditionalDelete, 2, 1),
+		NewKeyExistsError(Key("a"), makeTS(1, 0)),
+		NewReadBeforeGCThresholdError(makeTS(1, 0), makeTS(2, 0)),
+		NewRangeUnavailableError(1, Replica{NodeID: 1, StoreID: 2, RangeID: 3}, time.Minute),
+		NewOpRequiresTxnError(CompareAndSwap),
+	}
+	for i, err := range testCases {
+		data, mErr := gogoproto.Marshal(NewError(err))
+		if mErr != nil {
+			t.Fatalf("%d: %s", i, mErr)
+		}
+		e := &Error{}
+		if uErr := gogoproto.Unmarshal(data, e); uErr != nil {
+			t.Fatalf("%d: %s", i, uErr)
+		}
+		if goErr := e.GoError(); !reflect.DeepEqual(goErr, err) {
+			t.Errorf("%d: expected %+v; got %+v", i, err, goErr)
+		}
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	txn := &Transaction{Name: "test", ID: []byte("id"), Priority: 1}
+	existing := makeTS(2, 1)
+
+	e := NewError(NewWriteIntentError(Key("b"), txn, false))
+	if !e.Key().Equal(Key("b")) {
+		t.Errorf("expected key %q; got %q", Key("b"), e.Key())
+	}
+	if c := e.ConflictingTxn(); c == nil || !reflect.DeepEqual(c.ID, txn.ID) {
+		t.Errorf("expected conflicting txn %s; got %s", txn, c)
+	}
+	if e.ExistingTimestamp() != nil {
+		t.Errorf("expected no existing timestamp; got %s", e.ExistingTimestamp())
+	}
+
+	e = NewError(NewWriteTooOldError(Key("c"), makeTS(1, 0), existing))
+	if !e.Key().Equal(Key("c")) {
+		t.Errorf("expected key %q; got %q", Key("c"), e.Key())
+	}
+	if ts := e.ExistingTimestamp(); ts == nil || !ts.Equal(existing) {
+		t.Errorf("expected existing timestamp %s; got %s", existing, ts)
+	}
+	if e.ConflictingTxn() != nil {
+		t.Errorf("expected no conflicting txn; got %s", e.ConflictingTxn())
+	}
+
+	if !NewError(NewRangeNotFoundError(1)).CanRetry() {
+		t.Error("expected range not found error to be retryable")
+	}
+	if NewError(&WriteIntentError{}).CanRetry() {
+		t.Error("expected write intent error to not be retryable")
+	}
+
+	var nilErr *Error
+	if nilErr.GoError() != nil || nilErr.Key() != nil || nilErr.ConflictingTxn() != nil || nilErr.CanRetry() {
+		t.Error("expected nil error to have no details")
+	}
+	if NewError(nil) != nil {
+		t.Error("expected nil error from nil Go error")
+	}
+}
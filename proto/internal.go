@@ -45,4 +45,29 @@ const (
 	// end key up to some maximum number of results from the given snapshot_id.
 	// It will create a snapshot if snapshot_id is empty.
 	InternalSnapshotCopy = "InternalSnapshotCopy"
+	// InternalExecute invokes a named procedure registered on the
+	// server, which executes a read-modify-write against the key span
+	// of the request within a single range command.
+	InternalExecute = "InternalExecute"
+	// InternalRefresh verifies that no other transaction has written to
+	// a key or key range read by a transaction since the timestamp at
+	// which it was read, so that the transaction may commit at a later,
+	// pushed timestamp without being restarted.
+	InternalRefresh = "InternalRefresh"
+	// InternalRangeStats returns the MVCC stats of the range containing
+	// args.Key.
+	InternalRangeStats = "InternalRangeStats"
+	// InternalGC garbage collects MVCC versions of the range containing
+	// args.Key which aren't visible at or after the GC threshold, and
+	// advances the range's GC threshold.
+	InternalGC = "InternalGC"
+	// InternalScanIntents returns the write intents in the key span
+	// specified by start key through end key, which must lie within a
+	// single range.
+	InternalScanIntents = "InternalScanIntents"
+	// InternalReserveSnapshot reserves space on the store addressed by
+	// args.Replica and bytes of the snapshot receive budget of its node,
+	// ahead of the store fetching a snapshot of the range containing
+	// args.Key.
+	InternalReserveSnapshot = "InternalReserveSnapshot"
 )
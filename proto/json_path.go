@@ -0,0 +1,101 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// jsonNull is the JSON encoding of a missing element.
+var jsonNull = []byte("null")
+
+// ExtractJSONPath returns the JSON encoding of the element of the
+// JSON document data at path, or null if there is none. A path is a
+// dot-separated list of object keys and array indexes, optionally
+// prefixed by "$", the document's root; "$.a.0" selects the first
+// element of the array at key "a". An empty path or "$" selects the
+// whole document, which is returned as is.
+func ExtractJSONPath(data []byte, path string) ([]byte, error) {
+	elem := json.RawMessage(data)
+	for _, step := range splitJSONPath(path) {
+		trimmed := bytes.TrimSpace(elem)
+		if len(trimmed) == 0 {
+			return nil, util.Errorf("invalid JSON document")
+		}
+		switch trimmed[0] {
+		case '{':
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(trimmed, &obj); err != nil {
+				return nil, err
+			}
+			var ok bool
+			if elem, ok = obj[step]; !ok {
+				return jsonNull, nil
+			}
+		case '[':
+			var arr []json.RawMessage
+			if err := json.Unmarshal(trimmed, &arr); err != nil {
+				return nil, err
+			}
+			i, err := strconv.Atoi(step)
+			if err != nil || i < 0 || i >= len(arr) {
+				return jsonNull, nil
+			}
+			elem = arr[i]
+		default:
+			// Scalars have no elements.
+			return jsonNull, nil
+		}
+	}
+	return elem, nil
+}
+
+// splitJSONPath returns the steps of the path.
+func splitJSONPath(path string) []string {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// ExtractJSONValue replaces the bytes of the value, a JSON document,
+// with the JSON encoding of its element at path and recomputes the
+// value's checksum, if it has one, using key. Values without bytes
+// are left unchanged.
+func ExtractJSONValue(key Key, v *Value, path string) error {
+	if v.Bytes == nil {
+		return nil
+	}
+	extracted, err := ExtractJSONPath(v.Bytes, path)
+	if err != nil {
+		return util.Errorf("unable to extract %q from value of key %q: %s", path, key, err)
+	}
+	v.Bytes = extracted
+	if v.Checksum != nil {
+		v.Checksum = nil
+		v.InitChecksum(key)
+	}
+	return nil
+}
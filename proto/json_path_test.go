@@ -0,0 +1,67 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestExtractJSONPath verifies the extraction of object fields and
+// array elements from JSON documents.
+func TestExtractJSONPath(t *testing.T) {
+	doc := []byte(`{"a": {"b": [1, {"c": "d"}]}, "e": 2}`)
+	testCases := []struct {
+		path   string
+		expect string
+	}{
+		{"", string(doc)},
+		{"$", string(doc)},
+		{"$.e", `2`},
+		{"e", `2`},
+		{"$.a.b", `[1, {"c": "d"}]`},
+		{"$.a.b.1.c", `"d"`},
+		{"$.a.b.2", `null`},
+		{"$.a.b.x", `null`},
+		{"$.missing", `null`},
+		{"$.e.f", `null`},
+	}
+	for i, test := range testCases {
+		extracted, err := ExtractJSONPath(doc, test.path)
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+		} else if string(extracted) != test.expect {
+			t.Errorf("%d: expected %s at %q; got %s", i, test.expect, test.path, extracted)
+		}
+	}
+	if _, err := ExtractJSONPath([]byte(`{"a": `), "$.a"); err == nil {
+		t.Error("expected error extracting from an invalid document")
+	}
+
+	v := &Value{Bytes: doc}
+	v.InitChecksum(Key("k"))
+	if err := ExtractJSONValue(Key("k"), v, "$.e"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.Bytes, []byte("2")) {
+		t.Errorf("expected extracted value 2; got %s", v.Bytes)
+	}
+	if err := v.Verify(Key("k")); err != nil {
+		t.Errorf("expected checksum to be recomputed: %s", err)
+	}
+}
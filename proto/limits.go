@@ -0,0 +1,88 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import gogoproto "code.google.com/p/gogoprotobuf/proto"
+
+// RequestLimits specifies the maximum sizes of the components of a
+// request. Limits are enforced by clients, which fail fast on
+// requests which would be rejected, and authoritatively by stores
+// before a request is proposed to Raft. A limit of zero disables the
+// corresponding check.
+type RequestLimits struct {
+	MaxKeySize     int64 // Maximum size of a key in bytes
+	MaxValueSize   int64 // Maximum size of a value in bytes
+	MaxRequestSize int64 // Maximum size of an encoded request in bytes
+}
+
+// DefaultRequestLimits are the request limits used unless otherwise
+// configured.
+var DefaultRequestLimits = RequestLimits{
+	MaxKeySize:     KeyMaxLength,
+	MaxValueSize:   4 << 20, // 4 MB
+	MaxRequestSize: 8 << 20, // 8 MB
+}
+
+// VerifyKey returns a RequestTooLargeError identifying the request
+// field if key exceeds the maximum key size.
+func (l RequestLimits) VerifyKey(field string, key Key) error {
+	return verifySize(field, int64(len(key)), l.MaxKeySize)
+}
+
+// VerifyRequest verifies the sizes of any values carried by args and
+// the size of args as a whole, returning a RequestTooLargeError
+// identifying the offending field if a limit is exceeded. Keys are
+// not verified; see VerifyKey.
+func (l RequestLimits) VerifyRequest(args Request) error {
+	var values []*Value
+	switch t := args.(type) {
+	case *PutRequest:
+		values = []*Value{&t.Value}
+	case *ConditionalPutRequest:
+		values = []*Value{&t.Value, t.ExpValue}
+	case *ConditionalDeleteRequest:
+		values = []*Value{t.ExpValue}
+	case *CompareAndSwapRequest:
+		for i := range t.Conditions {
+			values = append(values, t.Conditions[i].ExpValue)
+		}
+		for i := range t.Writes {
+			values = append(values, t.Writes[i].Value)
+		}
+	case *EnqueueMessageRequest:
+		values = []*Value{&t.Msg}
+	}
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		if err := verifySize("value", int64(len(v.Bytes)), l.MaxValueSize); err != nil {
+			return err
+		}
+	}
+	return verifySize("request", int64(gogoproto.Size(args)), l.MaxRequestSize)
+}
+
+// verifySize returns a RequestTooLargeError if size exceeds a non-zero
+// maxSize.
+func verifySize(field string, size, maxSize int64) error {
+	if maxSize > 0 && size > maxSize {
+		return NewRequestTooLargeError(field, size, maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRequestLimits(t *testing.T) {
+	limits := RequestLimits{MaxKeySize: 4, MaxValueSize: 8, MaxRequestSize: 64}
+	value := func(size int) Value {
+		return Value{Bytes: bytes.Repeat([]byte("x"), size)}
+	}
+	bigValue := value(9)
+	testCases := []struct {
+		args  Request
+		field string // empty if no error expected
+	}{
+		{&PutRequest{Value: value(8)}, ""},
+		{&PutRequest{Value: value(9)}, "value"},
+		{&ConditionalPutRequest{Value: value(1), ExpValue: &bigValue}, "value"},
+		{&EnqueueMessageRequest{Msg: value(9)}, "value"},
+		{&PutRequest{RequestHeader: RequestHeader{User: string(bytes.Repeat([]byte("u"), 64))}}, "request"},
+		{&GetRequest{}, ""},
+	}
+	for i, test := range testCases {
+		err := limits.VerifyRequest(test.args)
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if tErr, ok := err.(*RequestTooLargeError); !ok || tErr.Field != test.field {
+			t.Errorf("%d: expected request too large error for %q; got %v", i, test.field, err)
+		}
+	}
+
+	if err := limits.VerifyKey("key", Key("abcd")); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err := limits.VerifyKey("end_key", Key("abcde"))
+	if tErr, ok := err.(*RequestTooLargeError); !ok || tErr.Field != "end_key" || tErr.Size != 5 || tErr.MaxSize != 4 {
+		t.Errorf("expected request too large error for end_key; got %v", err)
+	}
+	// Zero limits disable verification.
+	if err := (RequestLimits{}).VerifyRequest(&PutRequest{Value: value(1 << 10)}); err != nil {
+		t.Errorf("unexpected error with zero limits: %s", err)
+	}
+}
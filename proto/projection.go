@@ -0,0 +1,102 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Apply returns the encoded fields of the protobuf-encoded data which
+// are selected by the projection, in their original order. Repeated
+// fields are returned in their entirety. Groups are not supported.
+func (p *Projection) Apply(data []byte) ([]byte, error) {
+	projected := []byte{}
+	for pos := 0; pos < len(data); {
+		tag, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return nil, util.Errorf("invalid field tag at offset %d", pos)
+		}
+		end := pos + n
+		switch tag & 0x7 {
+		case wireVarint:
+			if _, n = binary.Uvarint(data[end:]); n <= 0 {
+				return nil, util.Errorf("invalid varint at offset %d", end)
+			}
+			end += n
+		case wireFixed64:
+			end += 8
+		case wireFixed32:
+			end += 4
+		case wireBytes:
+			length, n := binary.Uvarint(data[end:])
+			if n <= 0 {
+				return nil, util.Errorf("invalid length at offset %d", end)
+			}
+			end += n + int(length)
+		default:
+			return nil, util.Errorf("unsupported wire type %d at offset %d", tag&0x7, pos)
+		}
+		if end > len(data) || end < pos {
+			return nil, util.Errorf("truncated field at offset %d", pos)
+		}
+		if p.selects(int32(tag >> 3)) {
+			projected = append(projected, data[pos:end]...)
+		}
+		pos = end
+	}
+	return projected, nil
+}
+
+// ProjectValue replaces the bytes of the value with the fields
+// selected by the projection and recomputes the value's checksum, if
+// it has one, using key. Values without bytes are left unchanged.
+func (p *Projection) ProjectValue(key Key, v *Value) error {
+	if v.Bytes == nil {
+		return nil
+	}
+	projected, err := p.Apply(v.Bytes)
+	if err != nil {
+		return util.Errorf("unable to project value of key %q: %s", key, err)
+	}
+	v.Bytes = projected
+	if v.Checksum != nil {
+		v.Checksum = nil
+		v.InitChecksum(key)
+	}
+	return nil
+}
+
+// selects returns true if the projection selects the field number.
+func (p *Projection) selects(field int32) bool {
+	for _, f := range p.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import (
+	"reflect"
+	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+)
+
+// TestProjectionApply verifies that projected messages decode with
+// only the selected fields set, covering each supported wire type.
+func TestProjectionApply(t *testing.T) {
+	entry := &AuditEntry{
+		Sequence:  1 << 40,
+		Timestamp: Timestamp{WallTime: 10, Logical: 2},
+		User:      "root",
+		Action:    "split",
+		Details:   []byte("details"),
+		Hash:      []byte("hash"),
+	}
+	data, err := gogoproto.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		fields   []int32
+		expected *AuditEntry
+	}{
+		{nil, &AuditEntry{}},
+		{[]int32{1}, &AuditEntry{Sequence: 1 << 40}},
+		{[]int32{2, 4}, &AuditEntry{Timestamp: entry.Timestamp, Action: "split"}},
+		{[]int32{8, 6, 3}, &AuditEntry{User: "root", Details: []byte("details"), Hash: []byte("hash")}},
+		{[]int32{7, 9}, &AuditEntry{}},
+		{[]int32{1, 2, 3, 4, 5, 6, 7, 8}, entry},
+	}
+	for i, test := range testCases {
+		projected, err := (&Projection{Fields: test.fields}).Apply(data)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		decoded := &AuditEntry{}
+		if err := gogoproto.Unmarshal(projected, decoded); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(decoded, test.expected) {
+			t.Errorf("%d: expected %+v; got %+v", i, test.expected, decoded)
+		}
+	}
+}
+
+// TestProjectionApplyInvalid verifies that malformed data fails to
+// project.
+func TestProjectionApplyInvalid(t *testing.T) {
+	p := &Projection{Fields: []int32{1}}
+	for i, data := range [][]byte{
+		{0x08},             // missing varint
+		{0x0a, 0x05, 'a'},  // truncated bytes
+		{0x09, 0x01},       // truncated fixed64
+		{0x0b},             // start group
+		{0xff, 0xff, 0xff}, // truncated tag
+	} {
+		if _, err := p.Apply(data); err == nil {
+			t.Errorf("%d: expected error projecting %x", i, data)
+		}
+	}
+}
+
+// TestProjectValue verifies that projected values have their checksums
+// recomputed and that values without bytes are unchanged.
+func TestProjectValue(t *testing.T) {
+	key := Key("a")
+	data, err := gogoproto.Marshal(&AuditEntry{Sequence: 1, User: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Projection{Fields: []int32{3}}
+	v := &Value{Bytes: data}
+	v.InitChecksum(key)
+	if err := p.ProjectValue(key, v); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(key); err != nil {
+		t.Error(err)
+	}
+	if len(v.Bytes) >= len(data) {
+		t.Errorf("expected projected value to be smaller than %d bytes; got %d", len(data), len(v.Bytes))
+	}
+
+	intVal := &Value{Integer: gogoproto.Int64(5)}
+	if err := p.ProjectValue(key, intVal); err != nil || intVal.GetInteger() != 5 {
+		t.Errorf("expected integer value to be unchanged; got %+v, %v", intVal, err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import "sync/atomic"
+
+// A ClusterVersion identifies the set of features which may be used
+// by the nodes of a cluster. Versions increase monotonically; each
+// version enables the features introduced with it in addition to
+// those of all prior versions. The active version is persisted at
+// KeyClusterVersion and gossiped to all nodes. A cluster's version
+// may only be advanced once every node runs a binary supporting it,
+// which allows new commands to be rolled out safely in clusters
+// running a mix of binaries.
+type ClusterVersion int64
+
+const (
+	// VersionBase is the version of clusters bootstrapped before
+	// cluster versions were introduced.
+	VersionBase ClusterVersion = 1
+	// VersionConditionalDelete introduces the ConditionalDelete
+	// command.
+	VersionConditionalDelete ClusterVersion = 2
+	// VersionCompareAndSwap introduces the CompareAndSwap command.
+	VersionCompareAndSwap ClusterVersion = 3
+
+	// MinSupportedVersion is the oldest cluster version which nodes
+	// running this binary are able to join.
+	MinSupportedVersion = VersionBase
+	// CurrentVersion is the newest cluster version supported by this
+	// binary. New clusters are bootstrapped at this version.
+	CurrentVersion = VersionCompareAndSwap
+)
+
+// methodVersions maps methods to the cluster version which
+// introduced them. Methods not listed are available at VersionBase.
+var methodVersions = map[string]ClusterVersion{
+	ConditionalDelete: VersionConditionalDelete,
+	CompareAndSwap:    VersionCompareAndSwap,
+}
+
+// MethodVersion returns the cluster version required to invoke the
+// specified method.
+func MethodVersion(method string) ClusterVersion {
+	if v, ok := methodVersions[method]; ok {
+		return v
+	}
+	return VersionBase
+}
+
+// A VersionGate tracks the active cluster version as seen by a node
+// and gates the use of features introduced by later versions. The
+// zero value gates all features beyond VersionBase. A VersionGate is
+// safe for concurrent use.
+type VersionGate struct {
+	active int64
+}
+
+// Active returns the active cluster version.
+func (g *VersionGate) Active() ClusterVersion {
+	if v := ClusterVersion(atomic.LoadInt64(&g.active)); v > VersionBase {
+		return v
+	}
+	return VersionBase
+}
+
+// SetActive sets the active cluster version. The active version
+// never decreases; attempts to set an older version are ignored.
+func (g *VersionGate) SetActive(v ClusterVersion) {
+	for {
+		old := atomic.LoadInt64(&g.active)
+		if int64(v) <= old || atomic.CompareAndSwapInt64(&g.active, old, int64(v)) {
+			return
+		}
+	}
+}
+
+// IsActive returns true if the features of the specified version may
+// be used.
+func (g *VersionGate) IsActive(v ClusterVersion) bool {
+	return g.Active() >= v
+}
+
+// CheckMethod returns an UnsupportedVersionError if the specified
+// method requires a newer cluster version than the active one.
+func (g *VersionGate) CheckMethod(method string) error {
+	if required := MethodVersion(method); !g.IsActive(required) {
+		return NewUnsupportedVersionError(method, required, g.Active())
+	}
+	return nil
+}
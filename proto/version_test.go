@@ -0,0 +1,64 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package proto
+
+import "testing"
+
+// TestMethodVersion verifies the cluster versions required by
+// methods.
+func TestMethodVersion(t *testing.T) {
+	if v := MethodVersion(Get); v != VersionBase {
+		t.Errorf("expected %s to require version %d; got %d", Get, VersionBase, v)
+	}
+	if v := MethodVersion(ConditionalDelete); v != VersionConditionalDelete {
+		t.Errorf("expected %s to require version %d; got %d", ConditionalDelete, VersionConditionalDelete, v)
+	}
+	for method := range AllMethods {
+		if v := MethodVersion(method); v < VersionBase || v > CurrentVersion {
+			t.Errorf("%s requires unsupported version %d", method, v)
+		}
+	}
+}
+
+// TestVersionGate verifies that the version gate never regresses and
+// gates methods introduced by inactive versions.
+func TestVersionGate(t *testing.T) {
+	g := &VersionGate{}
+	if v := g.Active(); v != VersionBase {
+		t.Errorf("expected zero gate at version %d; got %d", VersionBase, v)
+	}
+	if err := g.CheckMethod(Get); err != nil {
+		t.Error(err)
+	}
+	err := g.CheckMethod(ConditionalDelete)
+	if vErr, ok := err.(*UnsupportedVersionError); !ok {
+		t.Fatalf("expected unsupported version error; got %v", err)
+	} else if vErr.RequiredVersion != int64(VersionConditionalDelete) || vErr.ActiveVersion != int64(VersionBase) {
+		t.Errorf("unexpected error details: %+v", vErr)
+	}
+
+	g.SetActive(VersionConditionalDelete)
+	if err := g.CheckMethod(ConditionalDelete); err != nil {
+		t.Error(err)
+	}
+	// The active version never decreases.
+	g.SetActive(VersionBase)
+	if v := g.Active(); v != VersionConditionalDelete {
+		t.Errorf("expected version to remain %d; got %d", VersionConditionalDelete, v)
+	}
+}
@@ -24,6 +24,7 @@
 #include "rocksdb/env.h"
 #include "rocksdb/merge_operator.h"
 #include "rocksdb/options.h"
+#include "rocksdb/table_properties.h"
 #include "api.pb.h"
 #include "data.pb.h"
 #include "internal.pb.h"
@@ -123,6 +124,12 @@ const proto::ResponseHeader* GetResponseHeader(const proto::ReadWriteCmdResponse
     return &rwResp.internal_push_txn().header();
   } else if (rwResp.has_internal_resolve_intent()) {
     return &rwResp.internal_resolve_intent().header();
+  } else if (rwResp.has_internal_execute()) {
+    return &rwResp.internal_execute().header();
+  } else if (rwResp.has_conditional_delete()) {
+    return &rwResp.conditional_delete().header();
+  } else if (rwResp.has_compare_and_swap()) {
+    return &rwResp.compare_and_swap().header();
   }
   return NULL;
 }
@@ -449,6 +456,27 @@ uint64_t DBApproximateSize(DBEngine* db, DBSlice start, DBSlice end) {
   return result;
 }
 
+uint64_t DBApproximateKeyCount(DBEngine* db, DBSlice start, DBSlice end) {
+  rocksdb::TablePropertiesCollection props;
+  if (!db->rep->GetPropertiesOfAllTables(&props).ok()) {
+    return 0;
+  }
+  // Compute the average number of entries per byte over all tables
+  // and scale by the approximate size of the range.
+  uint64_t entries = 0;
+  uint64_t bytes = 0;
+  for (auto it = props.begin(); it != props.end(); ++it) {
+    entries += it->second->num_entries;
+    bytes += it->second->data_size + it->second->index_size +
+        it->second->filter_size;
+  }
+  if (bytes == 0) {
+    return 0;
+  }
+  const uint64_t size = DBApproximateSize(db, start, end);
+  return static_cast<uint64_t>(static_cast<double>(entries) * size / bytes);
+}
+
 DBStatus DBPut(DBEngine* db, DBSlice key, DBSlice value) {
   rocksdb::WriteOptions options;
   return ToDBStatus(db->rep->Put(options, ToSlice(key), ToSlice(value)));
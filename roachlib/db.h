@@ -94,6 +94,11 @@ DBStatus DBCompactRange(DBEngine* db, DBSlice* start, DBSlice* end);
 // range [start,end].
 uint64_t DBApproximateSize(DBEngine* db, DBSlice start, DBSlice end);
 
+// Returns the approximate number of keys in the range [start,end],
+// estimated from the entry counts and sizes recorded in the table
+// properties of the database's sstables.
+uint64_t DBApproximateKeyCount(DBEngine* db, DBSlice start, DBSlice end);
+
 // Sets the database entry for "key" to "value".
 DBStatus DBPut(DBEngine* db, DBSlice key, DBSlice value);
 
@@ -43,6 +43,13 @@ var (
 	clientMu          sync.Mutex         // Protects access to the client cache.
 	clients           map[string]*Client // Cache of RPC clients by server address.
 	heartbeatInterval time.Duration
+
+	// clientDrainInterval is the interval between successive clients
+	// replaced by DrainClients, which spreads out reconnections.
+	clientDrainInterval = 1 * time.Second
+	// clientDrainGrace is the time for which a replaced client remains
+	// open to allow outstanding calls to complete.
+	clientDrainGrace = 30 * time.Second
 )
 
 // clientRetryOptions specifies exponential backoff starting
@@ -75,6 +82,7 @@ type Client struct {
 	offset       RemoteOffset // Latest measured clock offset from the server
 	clock        *hlc.Clock
 	remoteClocks *RemoteClockMonitor
+	metrics      *rpcMetrics
 }
 
 // RemoteOffset keeps track of this client's estimate of its offset from a
@@ -120,9 +128,11 @@ func NewClient(addr net.Addr, opts *util.RetryOptions, context *Context) *Client
 		Closed:       make(chan struct{}),
 		clock:        context.localClock,
 		remoteClocks: context.RemoteClocks,
+		metrics:      context.metrics,
 	}
 	clients[c.Addr().String()] = c
 	clientMu.Unlock()
+	c.metrics.clientsCreated.Inc(1)
 
 	// Attempt to dial connection.
 	retryOpts := clientRetryOptions
@@ -130,8 +140,19 @@ func NewClient(addr net.Addr, opts *util.RetryOptions, context *Context) *Client
 		retryOpts = *opts
 	}
 	retryOpts.Tag = fmt.Sprintf("client %s connection", addr)
+	retryOpts.Stopper = context.Stopper
 
-	go func() {
+	// Close the client when the stopper is signaled. Closing the
+	// underlying connection unblocks any outstanding calls.
+	context.Stopper.RunWorker(func() {
+		select {
+		case <-context.Stopper.ShouldStop():
+			c.Close()
+		case <-c.Closed:
+		}
+	})
+
+	context.Stopper.RunWorker(func() {
 		err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
 			conn, err := tlsDial(addr.Network(), addr.String(), context.tlsConfig)
 			if err != nil {
@@ -139,10 +160,17 @@ func NewClient(addr net.Addr, opts *util.RetryOptions, context *Context) *Client
 				return util.RetryContinue, nil
 			}
 
+			clientMu.Lock()
+			if c.closed {
+				clientMu.Unlock()
+				conn.Close()
+				return util.RetryBreak, nil
+			}
 			c.mu.Lock()
 			c.Client = rpc.NewClient(conn)
 			c.lAddr = conn.LocalAddr()
 			c.mu.Unlock()
+			clientMu.Unlock()
 
 			// Ensure at least one heartbeat succeeds before exiting the
 			// retry loop.
@@ -156,7 +184,7 @@ func NewClient(addr net.Addr, opts *util.RetryOptions, context *Context) *Client
 			close(c.Ready)
 
 			// Launch periodic heartbeat.
-			go c.startHeartbeat()
+			context.Stopper.RunWorker(c.startHeartbeat)
 
 			return util.RetryBreak, nil
 		})
@@ -164,7 +192,7 @@ func NewClient(addr net.Addr, opts *util.RetryOptions, context *Context) *Client
 			log.Errorf("client %s failed to connect: %v", addr, err)
 			c.Close()
 		}
-	}()
+	})
 
 	return c
 }
@@ -210,25 +238,73 @@ func (c *Client) RemoteOffset() RemoteOffset {
 func (c *Client) Close() {
 	clientMu.Lock()
 	if !c.closed {
-		delete(clients, c.Addr().String())
+		c.evictLocked()
 		c.healthy = false
 		c.closed = true
 		close(c.Closed)
-		c.Client.Close()
+		// The client may have failed to connect.
+		if c.Client != nil {
+			c.Client.Close()
+		}
+	}
+	clientMu.Unlock()
+}
+
+// evictLocked removes the client from the clients map, unless it has
+// already been replaced by another client to the same address. The
+// caller must hold clientMu.
+func (c *Client) evictLocked() {
+	addr := c.Addr().String()
+	if clients[addr] == c {
+		delete(clients, addr)
+	}
+}
+
+// DrainClients gradually replaces all cached clients, for instance to
+// establish new connections after the TLS certificates have been
+// reloaded. Every clientDrainInterval, one client is removed from the
+// cache, so that subsequent calls to NewClient connect anew, and is
+// closed clientDrainGrace later, giving outstanding calls time to
+// complete.
+func DrainClients(stopper *util.Stopper) {
+	clientMu.Lock()
+	drain := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		drain = append(drain, c)
 	}
 	clientMu.Unlock()
+
+	stopper.RunWorker(func() {
+		for i, c := range drain {
+			if i > 0 {
+				select {
+				case <-time.After(clientDrainInterval):
+				case <-stopper.ShouldStop():
+					return
+				}
+			}
+			clientMu.Lock()
+			c.evictLocked()
+			clientMu.Unlock()
+			time.AfterFunc(clientDrainGrace, c.Close)
+		}
+	})
 }
 
 // startHeartbeat sends periodic heartbeats to client. Closes the
 // connection on error. Heartbeats are sent in an infinite loop until
-// an error is encountered.
+// an error is encountered or the client is closed.
 func (c *Client) startHeartbeat() {
 	log.Infof("client %s starting heartbeat", c.Addr())
 	// On heartbeat failure, remove this client from cache. A new
 	// client to this address will be created on the next call to
 	// NewClient().
 	for {
-		time.Sleep(heartbeatInterval)
+		select {
+		case <-c.Closed:
+			return
+		case <-time.After(heartbeatInterval):
+		}
 		if err := c.heartbeat(); err != nil {
 			log.Infof("client %s heartbeat failed: %v; recycling...", c.Addr(), err)
 			c.Close()
@@ -266,6 +342,12 @@ func (c *Client) heartbeat() error {
 		}
 		c.mu.Unlock()
 		c.remoteClocks.UpdateOffset(c.addr.String(), c.offset)
+		c.metrics.heartbeatLatency.RecordValue(receiveTime - sendTime)
+		if call.Error != nil {
+			c.metrics.heartbeatFailures.Inc(1)
+		} else {
+			c.metrics.heartbeats.Inc(1)
+		}
 		return call.Error
 	case <-time.After(heartbeatInterval * 2):
 		// Allowed twice gossip interval.
@@ -275,6 +357,7 @@ func (c *Client) heartbeat() error {
 		c.offset.MeasuredAt = c.clock.PhysicalNow()
 		c.mu.Unlock()
 		c.remoteClocks.UpdateOffset(c.addr.String(), c.offset)
+		c.metrics.heartbeatFailures.Inc(1)
 		log.Warningf("client %s unhealthy after %s", c.Addr(), heartbeatInterval)
 	}
 
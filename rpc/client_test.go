@@ -30,6 +30,8 @@ func init() {
 	// Setting the heartbeat interval in individual tests
 	// triggers the race detector, so this is better for now.
 	heartbeatInterval = 10 * time.Millisecond
+	clientDrainInterval = 10 * time.Millisecond
+	clientDrainGrace = 10 * time.Millisecond
 }
 
 func TestClientHeartbeat(t *testing.T) {
@@ -38,8 +40,10 @@ func TestClientHeartbeat(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	stopper := util.NewStopper()
+	defer stopper.Stop()
 	clock := hlc.NewClock(hlc.UnixNano)
-	rpcContext := NewContext(clock, tlsConfig)
+	rpcContext := NewContext(clock, tlsConfig, stopper)
 	addr := util.CreateTestAddr("tcp")
 	s := NewServer(addr, rpcContext)
 	if err := s.Start(); err != nil {
@@ -51,15 +55,46 @@ func TestClientHeartbeat(t *testing.T) {
 		t.Fatal("expected cached client to be returned while healthy")
 	}
 	<-c.Ready
-	s.Close()
+}
+
+// TestDrainClients verifies that drained clients are replaced in the
+// client cache and closed, and that closing a drained client leaves
+// its replacement cached.
+func TestDrainClients(t *testing.T) {
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	rpcContext := NewContext(hlc.NewClock(hlc.UnixNano), LoadInsecureTLSConfig(), stopper)
+	s := NewServer(util.CreateTestAddr("unix"), rpcContext)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(s.Addr(), nil, rpcContext)
+	<-c.Ready
+
+	DrainClients(stopper)
+	select {
+	case <-c.Closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected drained client to be closed")
+	}
+	c2 := NewClient(s.Addr(), nil, rpcContext)
+	if c2 == c {
+		t.Fatal("expected drained client to be replaced")
+	}
+	<-c2.Ready
+	c.Close()
+	if NewClient(s.Addr(), nil, rpcContext) != c2 {
+		t.Error("expected replacement client to remain cached")
+	}
 }
 
 // TestClientHeartbeatBadServer verifies that the client is not marked
 // as "ready" until a heartbeat request succeeds.
 func TestClientHeartbeatBadServer(t *testing.T) {
 	// Create a server without registering a heartbeat service.
-	s := createTestServer(hlc.NewClock(hlc.UnixNano), t)
-	defer s.Close()
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	s := createTestServer(hlc.NewClock(hlc.UnixNano), stopper, t)
 
 	// Now, create a client. It should attempt a heartbeat and fail,
 	// causing retry loop to activate.
@@ -72,10 +107,11 @@ func TestClientHeartbeatBadServer(t *testing.T) {
 }
 
 func TestOffsetMeasurement(t *testing.T) {
-	serverManual := hlc.ManualClock(10)
+	serverManual := hlc.NewManualClock(10)
 	serverClock := hlc.NewClock(serverManual.UnixNano)
-	s := createTestServer(serverClock, t)
-	defer s.Close()
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	s := createTestServer(serverClock, stopper, t)
 
 	heartbeat := &HeartbeatService{
 		clock:              serverClock,
@@ -86,7 +122,7 @@ func TestOffsetMeasurement(t *testing.T) {
 	// Create a client that is 10 nanoseconds behind the server.
 	advancing := AdvancingClock{time: 0, advancementInterval: 10}
 	clientClock := hlc.NewClock(advancing.UnixNano)
-	context := NewContext(clientClock, s.context.tlsConfig)
+	context := NewContext(clientClock, s.context.tlsConfig, stopper)
 	c := NewClient(s.Addr(), nil, context)
 	<-c.Ready
 
@@ -112,10 +148,11 @@ func TestOffsetMeasurement(t *testing.T) {
 // InfiniteOffset if the heartbeat reply exceeds the maximumClockReadingDelay,
 // but not the heartbeat timeout.
 func TestDelayedOffsetMeasurement(t *testing.T) {
-	serverManual := hlc.ManualClock(10)
+	serverManual := hlc.NewManualClock(10)
 	serverClock := hlc.NewClock(serverManual.UnixNano)
-	s := createTestServer(serverClock, t)
-	defer s.Close()
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	s := createTestServer(serverClock, stopper, t)
 
 	heartbeat := &HeartbeatService{
 		clock:              serverClock,
@@ -130,7 +167,7 @@ func TestDelayedOffsetMeasurement(t *testing.T) {
 		advancementInterval: maximumClockReadingDelay.Nanoseconds() + 1,
 	}
 	clientClock := hlc.NewClock(advancing.UnixNano)
-	context := NewContext(clientClock, s.context.tlsConfig)
+	context := NewContext(clientClock, s.context.tlsConfig, stopper)
 	c := NewClient(s.Addr(), nil, context)
 	<-c.Ready
 
@@ -155,10 +192,11 @@ func TestDelayedOffsetMeasurement(t *testing.T) {
 }
 
 func TestFailedOffestMeasurement(t *testing.T) {
-	serverManual := hlc.ManualClock(0)
+	serverManual := hlc.NewManualClock(0)
 	serverClock := hlc.NewClock(serverManual.UnixNano)
-	s := createTestServer(serverClock, t)
-	defer s.Close()
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	s := createTestServer(serverClock, stopper, t)
 
 	heartbeat := &ManualHeartbeatService{
 		clock:              serverClock,
@@ -168,9 +206,9 @@ func TestFailedOffestMeasurement(t *testing.T) {
 	s.RegisterName("Heartbeat", heartbeat)
 
 	// Create a client that never receives a heartbeat after the first.
-	clientManual := hlc.ManualClock(0)
+	clientManual := hlc.NewManualClock(0)
 	clientClock := hlc.NewClock(clientManual.UnixNano)
-	context := NewContext(clientClock, s.context.tlsConfig)
+	context := NewContext(clientClock, s.context.tlsConfig, stopper)
 	c := NewClient(s.Addr(), nil, context)
 	heartbeat.ready <- struct{}{} // Allow one heartbeat for initialization.
 	<-c.Ready
@@ -199,9 +237,9 @@ func (ac *AdvancingClock) UnixNano() int64 {
 }
 
 // createTestServer creates and starts a new server with a test tlsConfig and
-// addr. Be sure to close the server when done. Building the server manually
+// addr. Be sure to stop the stopper when done. Building the server manually
 // like this allows for manual registration of the heartbeat service.
-func createTestServer(serverClock *hlc.Clock, t *testing.T) *Server {
+func createTestServer(serverClock *hlc.Clock, stopper *util.Stopper, t *testing.T) *Server {
 	tlsConfig, err := LoadTestTLSConfig("..")
 	if err != nil {
 		t.Fatal(err)
@@ -209,7 +247,7 @@ func createTestServer(serverClock *hlc.Clock, t *testing.T) *Server {
 
 	// Create the server so that we can register a manual clock.
 	addr := util.CreateTestAddr("tcp")
-	serverContext := NewContext(serverClock, tlsConfig)
+	serverContext := NewContext(serverClock, tlsConfig, stopper)
 	s := &Server{
 		Server:  rpc.NewServer(),
 		context: serverContext,
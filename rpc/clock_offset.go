@@ -23,6 +23,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
 )
@@ -139,11 +140,15 @@ func (r *RemoteClockMonitor) UpdateOffset(addr string, offset RemoteOffset) {
 // MonitorRemoteOffsets periodically checks that the offset of this server's
 // clock from the true cluster time is within MaxOffset. If the offset exceeds
 // MaxOffset, then this method will trigger a fatal error, causing the node to
-// suicide.
-func (r *RemoteClockMonitor) MonitorRemoteOffsets() {
+// suicide. Monitoring continues until the stopper is signaled.
+func (r *RemoteClockMonitor) MonitorRemoteOffsets(stopper *util.Stopper) {
 	log.V(1).Infof("monitoring cluster offset")
 	for {
-		time.Sleep(monitorInterval)
+		select {
+		case <-stopper.ShouldStop():
+			return
+		case <-time.After(monitorInterval):
+		}
 		offsetInterval, err := r.findOffsetInterval()
 		// By the contract of the hlc, if the value is 0, then safety checking
 		// of the max offset is disabled. However we may still want to
@@ -122,7 +122,7 @@ func TestBuildEndpointList(t *testing.T) {
 		"2": RemoteOffset{Offset: 2, Error: 10},
 		"3": RemoteOffset{Offset: 3, Error: 10},
 	}
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	clock.SetMaxOffset(5 * time.Nanosecond)
 	remoteClocks := &RemoteClockMonitor{
@@ -170,7 +170,7 @@ func TestBuildEndpointListRemoveStagnantClocks(t *testing.T) {
 	// The stagnant offsets older than 10ns ago will be removed.
 	monitorInterval = 10 * time.Nanosecond
 
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	clock.SetMaxOffset(5 * time.Nanosecond)
 	remoteClocks := &RemoteClockMonitor{
@@ -201,7 +201,7 @@ func TestFindOffsetInterval(t *testing.T) {
 		"3": RemoteOffset{Offset: 91, Error: 31},
 	}
 
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	clock.SetMaxOffset(0 * time.Nanosecond)
 	remoteClocks := &RemoteClockMonitor{
@@ -224,7 +224,7 @@ func TestFindOffsetIntervalNoMajorityOverlap(t *testing.T) {
 		"3": RemoteOffset{Offset: 4, Error: 1},
 	}
 
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	clock.SetMaxOffset(0 * time.Nanosecond)
 	remoteClocks := &RemoteClockMonitor{
@@ -245,7 +245,7 @@ func TestFindOffsetIntervalWithInfinites(t *testing.T) {
 		"3": InfiniteOffset,
 	}
 
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	clock.SetMaxOffset(0 * time.Nanosecond)
 	remoteClocks := &RemoteClockMonitor{
@@ -263,7 +263,7 @@ func TestFindOffsetIntervalWithInfinites(t *testing.T) {
 // no recent remote clock readings.
 func TestFindOffsetIntervalNoRemotes(t *testing.T) {
 	offsets := map[string]RemoteOffset{}
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	clock.SetMaxOffset(10 * time.Nanosecond)
 	remoteClocks := &RemoteClockMonitor{
@@ -281,7 +281,7 @@ func TestFindOffsetIntervalOneClock(t *testing.T) {
 		"0": RemoteOffset{Offset: 0, Error: 10},
 	}
 
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	// The clock interval will be:
 	// [offset - error - maxOffset, offset + error + maxOffset]
@@ -298,7 +298,7 @@ func TestFindOffsetIntervalOneClock(t *testing.T) {
 func TestFindOffsetIntervalTwoClocks(t *testing.T) {
 	offsets := map[string]RemoteOffset{}
 
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	clock.SetMaxOffset(0 * time.Nanosecond)
 	remoteClocks := &RemoteClockMonitor{
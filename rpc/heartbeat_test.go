@@ -26,7 +26,7 @@ import (
 )
 
 func TestHeartbeatReply(t *testing.T) {
-	manual := hlc.ManualClock(5)
+	manual := hlc.NewManualClock(5)
 	clock := hlc.NewClock(manual.UnixNano)
 	heartbeat := &HeartbeatService{
 		clock:              clock,
@@ -49,7 +49,7 @@ func TestHeartbeatReply(t *testing.T) {
 }
 
 func TestManualHeartbeat(t *testing.T) {
-	manual := hlc.ManualClock(5)
+	manual := hlc.NewManualClock(5)
 	clock := hlc.NewClock(manual.UnixNano)
 	manualHeartbeat := &ManualHeartbeatService{
 		clock:              clock,
@@ -86,9 +86,11 @@ func TestUpdateOffsetOnHeartbeat(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	stopper := util.NewStopper()
+	defer stopper.Stop()
 	serverAddr := util.CreateTestAddr("tcp")
 	// Start heartbeat.
-	sContext := NewContext(hlc.NewClock(hlc.UnixNano), tlsConfig)
+	sContext := NewContext(hlc.NewClock(hlc.UnixNano), tlsConfig, stopper)
 	s := NewServer(serverAddr, sContext)
 	if err := s.Start(); err != nil {
 		t.Fatal(err)
@@ -115,5 +117,4 @@ func TestUpdateOffsetOnHeartbeat(t *testing.T) {
 	if o != serverOffset {
 		t.Errorf("expected updated offset %v, instead %v", serverOffset, o)
 	}
-	s.Close()
 }
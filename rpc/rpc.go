@@ -1,19 +1,55 @@
 package rpc
 
-import "github.com/cockroachdb/cockroach/util/hlc"
+import (
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+	"github.com/cockroachdb/cockroach/util/metric"
+)
 
 // Context contains the fields required by the rpc framework.
 type Context struct {
 	localClock   *hlc.Clock
 	tlsConfig    *TLSConfig
+	Stopper      *util.Stopper
 	RemoteClocks *RemoteClockMonitor
+	metrics      *rpcMetrics
+}
+
+// rpcMetrics holds the metrics tracked by the rpc servers and clients
+// created with a Context.
+type rpcMetrics struct {
+	registry          *metric.Registry
+	connsAccepted     *metric.Counter   // Connections accepted by servers
+	clientsCreated    *metric.Counter   // Clients created
+	heartbeats        *metric.Counter   // Successful client heartbeats
+	heartbeatFailures *metric.Counter   // Failed or timed out heartbeats
+	heartbeatLatency  *metric.Histogram // Heartbeat round trip in nanoseconds
+}
+
+func newRPCMetrics() *rpcMetrics {
+	r := metric.NewRegistry()
+	return &rpcMetrics{
+		registry:          r,
+		connsAccepted:     r.Counter("conns.accepted"),
+		clientsCreated:    r.Counter("clients.created"),
+		heartbeats:        r.Counter("heartbeats"),
+		heartbeatFailures: r.Counter("heartbeats.failures"),
+		heartbeatLatency:  r.Histogram("heartbeats.latency"),
+	}
 }
 
 // NewContext creates an rpc Context with the supplied values.
-func NewContext(clock *hlc.Clock, config *TLSConfig) *Context {
+func NewContext(clock *hlc.Clock, config *TLSConfig, stopper *util.Stopper) *Context {
 	return &Context{
 		localClock:   clock,
 		tlsConfig:    config,
+		Stopper:      stopper,
 		RemoteClocks: newRemoteClockMonitor(clock),
+		metrics:      newRPCMetrics(),
 	}
 }
+
+// Registry returns the registry of rpc metrics.
+func (c *Context) Registry() *metric.Registry {
+	return c.metrics.registry
+}
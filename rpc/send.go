@@ -56,6 +56,10 @@ type Options struct {
 	// Timeout is the maximum duration of an RPC before failure.
 	// 0 for no timeout.
 	Timeout time.Duration
+	// ReplyError, if not nil, is invoked on each reply and returns an
+	// error if the reply must not count as a success, in which case it
+	// counts as a failed RPC instead.
+	ReplyError func(reply interface{}) error
 }
 
 // An rpcError indicates a failure to send the RPC. rpcErrors are
@@ -96,7 +100,7 @@ func (s SendError) CanRetry() bool { return s.canRetry }
 // number of required replies.
 func Send(opts Options, method string, addrs []net.Addr, getArgs func(addr net.Addr) interface{},
 	getReply func() interface{}, context *Context) ([]interface{}, error) {
-	if opts.N < len(addrs) {
+	if len(addrs) < opts.N {
 		return nil, SendError{
 			errMsg:   fmt.Sprintf("insufficient replicas (%d) to satisfy send request of %d", len(addrs), opts.N),
 			canRetry: false,
@@ -153,7 +157,7 @@ func Send(opts Options, method string, addrs []net.Addr, getArgs func(addr net.A
 			if log.V(1) {
 				log.Infof("%s: sending request to %s: %+v", method, clients[index].Addr(), args)
 			}
-			go sendOne(clients[index], opts.Timeout, method, args, reply, helperChan)
+			go sendOne(clients[index], opts, method, args, reply, helperChan)
 		}
 		// Wait for completions.
 		select {
@@ -200,7 +204,7 @@ func Send(opts Options, method string, addrs []net.Addr, getArgs func(addr net.A
 // sendOne invokes the specified RPC on the supplied client when the
 // client is ready. On success, the reply is sent on the channel;
 // otherwise an error is sent.
-func sendOne(client *Client, timeout time.Duration, method string, args, reply interface{}, c chan interface{}) {
+func sendOne(client *Client, opts Options, method string, args, reply interface{}, c chan interface{}) {
 	<-client.Ready
 	call := client.Go(method, args, reply, nil)
 	select {
@@ -226,11 +230,17 @@ func sendOne(client *Client, timeout time.Duration, method string, args, reply i
 					}
 				}
 			}
+			if opts.ReplyError != nil {
+				if err := opts.ReplyError(reply); err != nil {
+					c <- err
+					return
+				}
+			}
 			c <- reply
 		}
 	case <-client.Closed:
 		c <- rpcError{fmt.Sprintf("rpc to %s failed as client connection was closed", method)}
-	case <-time.After(timeout):
-		c <- rpcError{fmt.Sprintf("rpc to %s timed out after %s", method, timeout)}
+	case <-time.After(opts.Timeout):
+		c <- rpcError{fmt.Sprintf("rpc to %s timed out after %s", method, opts.Timeout)}
 	}
 }
@@ -0,0 +1,88 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package rpc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// A testReplica answers pings with its name.
+type testReplica struct {
+	name string
+}
+
+func (r *testReplica) Ping(args *PingRequest, reply *PingResponse) error {
+	reply.Pong = r.name
+	return nil
+}
+
+// TestSendReplyError verifies that replies rejected by ReplyError
+// count as failed RPCs: the request moves on to the next replica
+// without waiting for SendNextTimeout, and fails once every replica's
+// reply has been rejected.
+func TestSendReplyError(t *testing.T) {
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	context := NewContext(hlc.NewClock(hlc.UnixNano), LoadInsecureTLSConfig(), stopper)
+	var addrs []net.Addr
+	for _, name := range []string{"follower", "leader"} {
+		s := NewServer(util.CreateTestAddr("tcp"), context)
+		if err := s.RegisterName("Replica", &testReplica{name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		addrs = append(addrs, s.Addr())
+	}
+
+	send := func(reject func(pong string) bool) ([]interface{}, error) {
+		opts := Options{
+			N:               1,
+			Ordering:        OrderStable,
+			SendNextTimeout: time.Minute,
+			Timeout:         time.Minute,
+			ReplyError: func(reply interface{}) error {
+				if pong := reply.(*PingResponse).Pong; reject(pong) {
+					return util.Errorf("%s refused the request", pong)
+				}
+				return nil
+			},
+		}
+		getArgs := func(addr net.Addr) interface{} { return &PingRequest{} }
+		getReply := func() interface{} { return &PingResponse{} }
+		return Send(opts, "Replica.Ping", addrs, getArgs, getReply, context)
+	}
+
+	replies, err := send(func(pong string) bool { return pong == "follower" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pong := replies[0].(*PingResponse).Pong; pong != "leader" {
+		t.Errorf("expected the leader's reply to win; got %q", pong)
+	}
+	if _, err := send(func(string) bool { return true }); err == nil {
+		t.Error("expected an error once every reply is rejected")
+	} else if _, ok := err.(SendError); !ok {
+		t.Errorf("expected a send error; got %v", err)
+	}
+}
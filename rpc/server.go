@@ -69,6 +69,7 @@ func (s *Server) AddCloseCallback(cb func(conn net.Conn)) {
 
 // Start runs the RPC server. After this method returns, the socket
 // will have been bound. Use Server.Addr() to ascertain server address.
+// The server is closed when the context's stopper is stopped.
 func (s *Server) Start() error {
 	ln, err := tlsListen(s.addr.Network(), s.addr.String(), s.context.tlsConfig)
 	if err != nil {
@@ -85,7 +86,12 @@ func (s *Server) Start() error {
 	s.addr = addr
 	s.mu.Unlock()
 
-	go func() {
+	s.context.Stopper.RunWorker(func() {
+		<-s.context.Stopper.ShouldStop()
+		s.Close()
+	})
+
+	s.context.Stopper.RunWorker(func() {
 		// Start serving in a loop until listener is closed.
 		log.Infof("serving on %+v...", s.Addr())
 		for {
@@ -98,11 +104,12 @@ func (s *Server) Start() error {
 				s.mu.Unlock()
 				break
 			}
+			s.context.metrics.connsAccepted.Inc(1)
 			// Serve connection to completion in a goroutine.
 			go s.serveConn(conn)
 		}
 		log.Infof("done serving on %+v", s.Addr())
-	}()
+	})
 	return nil
 }
 
@@ -26,17 +26,29 @@ import (
 	"errors"
 	"io/ioutil"
 	"net"
+	"os"
 	"path"
 	"sync"
+	"time"
 
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
+// certFiles are the names of the files loaded from a certificate
+// directory.
+var certFiles = []string{"ca.crt", "node.crt", "node.key"}
+
 // TLSConfig contains the TLS settings for a Cockroach node. Currently it's
 // just a wrapper for tls.Config. If config is nil, we don't use TLS.
+// Configs loaded from a certificate directory may be reloaded at
+// runtime to rotate certificates without a restart.
 type TLSConfig struct {
 	sync.Mutex
-	config *tls.Config
+	config    *tls.Config
+	certDir   string    // Directory the config was loaded from
+	modTime   time.Time // Latest modification time of the loaded files
+	callbacks []func()  // Invoked after each reload
 }
 
 // Config returns a copy of the TLS configuration.
@@ -56,6 +68,24 @@ func (c *TLSConfig) Config() *tls.Config {
 // - node.crt -- the certificate of this node; should be signed by the CA
 // - node.key -- the private key of this node
 func LoadTLSConfig(certDir string) (*TLSConfig, error) {
+	modTime, err := certFilesModTime(certDir)
+	if err != nil {
+		log.Info(err)
+		return nil, err
+	}
+	config, err := loadTLSConfig(certDir)
+	if err != nil {
+		return nil, err
+	}
+	return &TLSConfig{
+		config:  config,
+		certDir: certDir,
+		modTime: modTime,
+	}, nil
+}
+
+// loadTLSConfig loads keys and certs from certDir into a tls.Config.
+func loadTLSConfig(certDir string) (*tls.Config, error) {
 	cert, err := tls.LoadX509KeyPair(
 		path.Join(certDir, "node.crt"),
 		path.Join(certDir, "node.key"),
@@ -78,19 +108,143 @@ func LoadTLSConfig(certDir string) (*TLSConfig, error) {
 		return nil, err
 	}
 
-	return &TLSConfig{
-		config: &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			ClientAuth:   tls.RequireAndVerifyClientCert,
-			RootCAs:      certPool,
-			ClientCAs:    certPool,
-
-			// TODO(jqmp): Set CipherSuites?
-			// TODO(jqmp): Set MinVersion?
-		},
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		RootCAs:      certPool,
+		ClientCAs:    certPool,
+
+		// TODO(jqmp): Set CipherSuites?
+		// TODO(jqmp): Set MinVersion?
 	}, nil
 }
 
+// certFilesModTime returns the latest modification time of the files
+// in certDir.
+func certFilesModTime(certDir string) (time.Time, error) {
+	var modTime time.Time
+	for _, name := range certFiles {
+		fi, err := os.Stat(path.Join(certDir, name))
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// Reload reloads the keys and certs from the directory the config was
+// loaded from. Connections established from then on use the new
+// certificates; existing connections are unaffected. On error, the
+// current certificates remain in use. Callbacks registered via
+// OnReload are invoked after a successful reload.
+func (c *TLSConfig) Reload() error {
+	c.Lock()
+	certDir := c.certDir
+	c.Unlock()
+	if certDir == "" {
+		return util.Errorf("TLS is disabled; there are no certificates to reload")
+	}
+	modTime, err := certFilesModTime(certDir)
+	if err != nil {
+		return util.Errorf("unable to reload certificates from %s: %s", certDir, err)
+	}
+	config, err := loadTLSConfig(certDir)
+	if err != nil {
+		return util.Errorf("unable to reload certificates from %s: %s", certDir, err)
+	}
+	c.Lock()
+	c.config = config
+	c.modTime = modTime
+	callbacks := append([]func(){}, c.callbacks...)
+	c.Unlock()
+	log.Infof("reloaded certificates from %s", certDir)
+	for _, cb := range callbacks {
+		cb()
+	}
+	return nil
+}
+
+// OnReload registers a callback to be invoked after each successful
+// reload of the certificates.
+func (c *TLSConfig) OnReload(cb func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.callbacks = append(c.callbacks, cb)
+}
+
+// Watch polls the certificate directory every interval and reloads
+// the certificates when any of the files has been modified, until the
+// stopper is signaled. A failed reload is retried only once the files
+// are modified again, so that a partially written set of files is
+// picked up once complete. Watch is a noop if TLS is disabled.
+func (c *TLSConfig) Watch(interval time.Duration, stopper *util.Stopper) {
+	c.Lock()
+	certDir, modTime := c.certDir, c.modTime
+	c.Unlock()
+	if certDir == "" {
+		return
+	}
+	stopper.RunWorker(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				latest, err := certFilesModTime(certDir)
+				if err != nil {
+					log.Warningf("unable to stat certificates in %s: %s", certDir, err)
+					continue
+				}
+				if !latest.After(modTime) {
+					continue
+				}
+				modTime = latest
+				if err := c.Reload(); err != nil {
+					log.Error(err)
+				}
+			case <-stopper.ShouldStop():
+				return
+			}
+		}
+	})
+}
+
+// NewListener wraps ln in a listener which performs the server side
+// of the TLS handshake on accepted connections, using the
+// certificates current at the time each connection is accepted. If
+// configure is not nil, it may adjust the config used for each
+// connection. If TLS is disabled, ln is returned unchanged.
+func (c *TLSConfig) NewListener(ln net.Listener, configure func(*tls.Config)) net.Listener {
+	if c.Config() == nil {
+		return ln
+	}
+	return &tlsListener{Listener: ln, config: c, configure: configure}
+}
+
+// A tlsListener wraps accepted connections with TLS server
+// connections using the current certificates of its TLSConfig.
+type tlsListener struct {
+	net.Listener
+	config    *TLSConfig
+	configure func(*tls.Config)
+}
+
+// Accept implements the net.Listener interface.
+func (l *tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	cfg := l.config.Config()
+	if l.configure != nil {
+		l.configure(cfg)
+	}
+	return tls.Server(conn, cfg), nil
+}
+
 // LoadInsecureTLSConfig creates a TLSConfig that disables TLS.
 func LoadInsecureTLSConfig() *TLSConfig {
 	return &TLSConfig{
@@ -108,14 +262,14 @@ func LoadTestTLSConfig(projectRoot string) (*TLSConfig, error) {
 // tlsListen wraps either net.Listen or crypto/tls.Listen, depending on the contents of
 // the passed TLSConfig.
 func tlsListen(network string, address string, config *TLSConfig) (net.Listener, error) {
-	cfg := config.Config()
-	if cfg == nil {
-		if network != "unix" {
-			log.Warningf("Listening via %s to %s without TLS", network, address)
-		}
-		return net.Listen(network, address)
+	if config.Config() == nil && network != "unix" {
+		log.Warningf("Listening via %s to %s without TLS", network, address)
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
 	}
-	return tls.Listen(network, address, cfg)
+	return config.NewListener(ln, nil), nil
 }
 
 // tlsDial wraps either net.Dial or crypto/tls.Dial, depending on the contents of
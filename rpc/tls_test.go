@@ -19,7 +19,13 @@ package rpc
 
 import (
 	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
 )
 
 func TestLoadTLSConfig(t *testing.T) {
@@ -57,6 +63,92 @@ func TestLoadTLSConfig(t *testing.T) {
 	}
 }
 
+// copyTestCerts copies the test certificates to a temporary directory,
+// which the caller must remove.
+func copyTestCerts(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range certFiles {
+		data, err := ioutil.ReadFile(path.Join("..", "resources", "test_certs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTLSConfigReload(t *testing.T) {
+	dir := copyTestCerts(t)
+	defer os.RemoveAll(dir)
+	config, err := LoadTLSConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := 0
+	config.OnReload(func() { reloads++ })
+
+	orig := config.config
+	if err := config.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if reloads != 1 || config.config == orig {
+		t.Errorf("expected config to be reloaded; got %d reload(s)", reloads)
+	}
+
+	// A failed reload leaves the current config in place.
+	current := config.config
+	if err := ioutil.WriteFile(path.Join(dir, "node.crt"), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Reload(); err == nil {
+		t.Error("expected reload of invalid certificate to fail")
+	}
+	if reloads != 1 || config.config != current {
+		t.Errorf("expected config to remain in place; got %d reload(s)", reloads)
+	}
+
+	// An insecure config can't be reloaded.
+	if err := LoadInsecureTLSConfig().Reload(); err == nil {
+		t.Error("expected reload of insecure config to fail")
+	}
+}
+
+// TestTLSConfigWatch verifies that certificates are reloaded once the
+// files in the certificate directory are modified.
+func TestTLSConfigWatch(t *testing.T) {
+	dir := copyTestCerts(t)
+	defer os.RemoveAll(dir)
+	config, err := LoadTLSConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan struct{}, 1)
+	config.OnReload(func() { reloaded <- struct{}{} })
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	config.Watch(time.Millisecond, stopper)
+
+	select {
+	case <-reloaded:
+		t.Fatal("unexpected reload of unmodified certificates")
+	case <-time.After(10 * time.Millisecond):
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path.Join(dir, "node.key"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("expected modified certificates to be reloaded")
+	}
+}
+
 func verifyX509Cert(cert *x509.Certificate, dnsName string, roots *x509.CertPool) error {
 	verifyOptions := x509.VerifyOptions{
 		DNSName: dnsName,
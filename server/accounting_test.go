@@ -35,7 +35,8 @@ const testAcctConfig = `cluster_id: test`
 // ExampleSetAndGetAccts sets acct configs for a variety of key
 // prefixes and verifies they can be fetched directly.
 func ExampleSetAndGetAccts() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testAcctConfig)
 	defer os.Remove(testConfigFn)
@@ -77,7 +78,8 @@ func ExampleSetAndGetAccts() {
 // acct-ls works. First, no regexp lists all acct configs. Second,
 // regexp properly matches results.
 func ExampleLsAccts() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testAcctConfig)
 	defer os.Remove(testConfigFn)
@@ -135,7 +137,8 @@ func ExampleLsAccts() {
 // have been removed via acct-ls. Also verify the default acct config
 // cannot be removed.
 func ExampleRmAccts() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testAcctConfig)
 	defer os.Remove(testConfigFn)
@@ -168,7 +171,8 @@ func ExampleRmAccts() {
 // to control the format of the response and the Content-Type header
 // can be used to specify the format of the request.
 func ExampleAcctContentTypes() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 
 	config := &proto.AcctConfig{}
@@ -197,13 +201,13 @@ func ExampleAcctContentTypes() {
 				fmt.Println(err)
 			}
 		}
-		req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s%s", adminScheme, *addr, acctPathPrefix, key), bytes.NewReader(body))
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s%s", adminScheme(), *addr, acctPathPrefix, key), bytes.NewReader(body))
 		req.Header.Add("Content-Type", test.contentType)
 		if _, err = sendAdminRequest(req); err != nil {
 			fmt.Println(err)
 		}
 
-		req, err = http.NewRequest("GET", fmt.Sprintf("%s://%s%s%s", adminScheme, *addr, acctPathPrefix, key), nil)
+		req, err = http.NewRequest("GET", fmt.Sprintf("%s://%s%s%s", adminScheme(), *addr, acctPathPrefix, key), nil)
 		req.Header.Add("Accept", test.accept)
 		if body, err = sendAdminRequest(req); err != nil {
 			fmt.Println(err)
@@ -32,15 +32,13 @@ import (
 	"strings"
 
 	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/util"
 )
 
 const (
 	maxGetResults = 0 // TODO(spencer): maybe we need paged query support
 
-	// adminScheme is the scheme for connecting to the admin endpoint.
-	// TODO(spencer): change this to CONSTANT https. We shouldn't be
-	// supporting http here at all.
-	adminScheme = "http"
 	// adminEndpoint is the prefix for RESTful endpoints used to
 	// provide an administrative interface to the cockroach cluster.
 	adminEndpoint = "/_admin/"
@@ -68,20 +66,24 @@ type actionHandler interface {
 // A adminServer provides a RESTful HTTP API to administration of
 // the cockroach cluster.
 type adminServer struct {
-	db   *client.KV // Key-value database client
-	acct *acctHandler
-	perm *permHandler
-	zone *zoneHandler
+	db        *client.KV     // Key-value database client
+	tlsConfig *rpc.TLSConfig // The node's TLS config
+	audit     *auditLog      // Records config changes
+	acct      *acctHandler
+	perm      *permHandler
+	zone      *zoneHandler
 }
 
 // newAdminServer allocates and returns a new REST server for
 // administrative APIs.
-func newAdminServer(db *client.KV) *adminServer {
+func newAdminServer(db *client.KV, tlsConfig *rpc.TLSConfig) *adminServer {
 	return &adminServer{
-		db:   db,
-		acct: &acctHandler{db: db},
-		perm: &permHandler{db: db},
-		zone: &zoneHandler{db: db},
+		db:        db,
+		tlsConfig: tlsConfig,
+		audit:     newAuditLog(db),
+		acct:      &acctHandler{db: db},
+		perm:      &permHandler{db: db},
+		zone:      &zoneHandler{db: db},
 	}
 }
 
@@ -92,10 +94,16 @@ func (s *adminServer) RegisterHandlers(mux *http.ServeMux) {
 	// get exported variables and pprof tools.
 	mux.HandleFunc(acctPathPrefix, s.handleAcctAction)
 	mux.HandleFunc(acctPathPrefix+"/", s.handleAcctAction)
+	mux.HandleFunc(auditPath, s.handleAudit)
+	mux.HandleFunc(certsReloadPath, s.handleCertsReload)
 	mux.HandleFunc(debugEndpoint, s.handleDebug)
 	mux.HandleFunc(healthzPath, s.handleHealthz)
+	mux.HandleFunc(intentsPath, s.handleIntents)
+	mux.HandleFunc(metadataPath, s.handleMetadata)
 	mux.HandleFunc(permPathPrefix, s.handlePermAction)
 	mux.HandleFunc(permPathPrefix+"/", s.handlePermAction)
+	mux.HandleFunc(tracePathPrefix, s.handleTraceAction)
+	mux.HandleFunc(tracePathPrefix+"/", s.handleTraceAction)
 	mux.HandleFunc(zonePathPrefix, s.handleZoneAction)
 	mux.HandleFunc(zonePathPrefix+"/", s.handleZoneAction)
 }
@@ -181,6 +189,10 @@ func (s *adminServer) handlePutAction(handler actionHandler, w http.ResponseWrit
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err = s.audit.record(r.Header.Get(util.UserHeader), auditActionPutConfig, auditTarget(prefix, path), b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -209,5 +221,9 @@ func (s *adminServer) handleDeleteAction(handler actionHandler, w http.ResponseW
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err = s.audit.record(r.Header.Get(util.UserHeader), auditActionDeleteConfig, auditTarget(prefix, path), nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -27,20 +27,25 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
 // startAdminServer launches a new admin server using minimal engine
 // and local database setup. Returns the new http test server, which
-// should be cleaned up by caller via httptest.Server.Close(). The
-// Cockroach KV client address is set to the address of the test server.
-func startAdminServer() *httptest.Server {
-	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20))
+// should be cleaned up by caller via httptest.Server.Close(), and the
+// stopper of the local database, which should be stopped by caller.
+// The Cockroach KV client address is set to the address of the test
+// server.
+func startAdminServer() (*httptest.Server, *util.Stopper) {
+	stopper := util.NewStopper()
+	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20), stopper)
 	if err != nil {
 		log.Fatal(err)
 	}
-	admin := newAdminServer(db)
+	admin := newAdminServer(db, rpc.LoadInsecureTLSConfig())
 	mux := http.NewServeMux()
 	admin.RegisterHandlers(mux)
 	httpServer := httptest.NewServer(mux)
@@ -49,7 +54,7 @@ func startAdminServer() *httptest.Server {
 	} else if strings.HasPrefix(httpServer.URL, "https://") {
 		*addr = strings.TrimPrefix(httpServer.URL, "https://")
 	}
-	return httpServer
+	return httpServer, stopper
 }
 
 // getText fetches the HTTP response body as text in the form of a
@@ -81,7 +86,8 @@ func getJSON(url string) (interface{}, error) {
 // TestAdminDebugExpVar verifies that cmdline and memstats variables are
 // available via the /debug/vars link.
 func TestAdminDebugExpVar(t *testing.T) {
-	s := startAdminServer()
+	s, stopper := startAdminServer()
+	defer stopper.Stop()
 	jI, err := getJSON(s.URL + debugEndpoint + "vars")
 	if err != nil {
 		t.Fatalf("failed to fetch JSON: %v", err)
@@ -98,7 +104,8 @@ func TestAdminDebugExpVar(t *testing.T) {
 // TestAdminDebugPprof verifies that pprof tools are available.
 // via the /debug/pprof/* links.
 func TestAdminDebugPprof(t *testing.T) {
-	s := startAdminServer()
+	s, stopper := startAdminServer()
+	defer stopper.Stop()
 	body, err := getText(s.URL + debugEndpoint + "pprof/block")
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,207 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+const (
+	// auditPath is the endpoint for querying the audit log.
+	auditPath = adminEndpoint + "audit"
+
+	// auditActionPutConfig records the write of an accounting,
+	// permission or zone config.
+	auditActionPutConfig = "put-config"
+	// auditActionDeleteConfig records the deletion of an accounting,
+	// permission or zone config.
+	auditActionDeleteConfig = "delete-config"
+	// auditActionAdminSplit records a split requested via AdminSplit.
+	auditActionAdminSplit = "admin-split"
+	// auditActionRecoverReplicas records a recovery of a range from
+	// its surviving replicas via AdminRecoverReplicas.
+	auditActionRecoverReplicas = "recover-replicas"
+	// auditActionTransferLease records a transfer of a range's leader
+	// lease via AdminTransferLease.
+	auditActionTransferLease = "transfer-lease"
+	// auditActionImportMetadata records an import of cluster metadata.
+	auditActionImportMetadata = "import-metadata"
+	// auditActionReloadCerts records a reload of a node's TLS
+	// certificates.
+	auditActionReloadCerts = "reload-certs"
+	// auditActionResolveIntents records a push and resolution of
+	// outstanding write intents via the intents endpoint.
+	auditActionResolveIntents = "resolve-intents"
+)
+
+// An auditLog records administrative and permission-changing
+// operations under the system audit log keys. Each entry is chained
+// to the last by hash and written in the same transaction as the log
+// head, so entries are totally ordered and any modification of the
+// log is detected when it's read.
+type auditLog struct {
+	db *client.KV // Key-value database client
+}
+
+// newAuditLog returns an audit log writing via db.
+func newAuditLog(db *client.KV) *auditLog {
+	return &auditLog{db: db}
+}
+
+// auditLogKey returns the key of the audit entry with the specified
+// sequence number.
+func auditLogKey(sequence int64) proto.Key {
+	return engine.MakeKey(engine.KeyAuditLogPrefix, encoding.EncodeInt(nil, sequence))
+}
+
+// auditTarget returns the audited target of a config change at path
+// under the admin endpoint prefix, e.g. "zones/db1".
+func auditTarget(prefix, path string) string {
+	return strings.TrimPrefix(prefix, adminEndpoint) + path
+}
+
+// record appends an entry to the audit log, recording that user
+// performed action on target. Failures are logged and returned: the
+// operation itself has been carried out, but callers must not report
+// success for an operation which went unrecorded.
+func (a *auditLog) record(user, action, target string, details []byte) error {
+	if err := a.db.RunTransaction(&client.TransactionOptions{Name: "audit"}, func(txn *client.KV) error {
+		head := &proto.AuditEntry{}
+		if _, _, err := txn.GetProto(engine.KeyAuditLogHead, head); err != nil {
+			return err
+		}
+		entry := &proto.AuditEntry{
+			Sequence:  head.Sequence + 1,
+			Timestamp: txn.Transaction().Timestamp,
+			User:      user,
+			Action:    action,
+			Target:    target,
+			Details:   details,
+			PrevHash:  head.Hash,
+		}
+		var err error
+		if entry.Hash, err = entry.ComputeHash(); err != nil {
+			return err
+		}
+		if err := txn.PutProto(auditLogKey(entry.Sequence), entry); err != nil {
+			return err
+		}
+		return txn.PutProto(engine.KeyAuditLogHead, entry)
+	}); err != nil {
+		log.Errorf("failed to record %s of %q by %q to audit log: %s", action, target, user, err)
+		return util.Errorf("operation succeeded but could not be recorded to the audit log: %s", err)
+	}
+	return nil
+}
+
+// entries returns all entries of the audit log in sequence order,
+// along with an error if the log fails verification.
+func (a *auditLog) entries() ([]proto.AuditEntry, error) {
+	sr := &proto.ScanResponse{}
+	if err := a.db.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:    engine.KeyAuditLogPrefix,
+			EndKey: engine.KeyAuditLogPrefix.PrefixEnd(),
+			User:   storage.UserRoot,
+		},
+		MaxResults: maxGetResults,
+	}, sr); err != nil {
+		return nil, err
+	}
+	entries := make([]proto.AuditEntry, len(sr.Rows))
+	for i, kv := range sr.Rows {
+		if err := gogoproto.Unmarshal(kv.Value.Bytes, &entries[i]); err != nil {
+			return nil, util.Errorf("could not decode audit entry %q: %s", kv.Key, err)
+		}
+	}
+	head := &proto.AuditEntry{}
+	if _, _, err := a.db.GetProto(engine.KeyAuditLogHead, head); err != nil {
+		return nil, err
+	}
+	return entries, verifyAuditEntries(entries, head)
+}
+
+// verifyAuditEntries verifies that entries form an unbroken chain
+// from the start of the log which ends at head, detecting both
+// modified and removed entries.
+func verifyAuditEntries(entries []proto.AuditEntry, head *proto.AuditEntry) error {
+	if len(entries) > 0 && entries[0].Sequence != 1 {
+		return util.Errorf("audit log begins at entry %d", entries[0].Sequence)
+	}
+	if err := proto.VerifyAuditLog(entries, nil); err != nil {
+		return err
+	}
+	var last proto.AuditEntry
+	if len(entries) > 0 {
+		last = entries[len(entries)-1]
+	}
+	if last.Sequence != head.Sequence || !bytes.Equal(last.Hash, head.Hash) {
+		return util.Errorf("audit log ends at entry %d; head is entry %d", last.Sequence, head.Sequence)
+	}
+	return nil
+}
+
+// auditLogResponse is the response to audit log queries.
+type auditLogResponse struct {
+	Entries []proto.AuditEntry `json:"entries"`
+	// Error describes the first entry failing verification, if any.
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// handleAudit returns the entries of the audit log, optionally
+// filtered to those with the user and action specified as query
+// parameters. The full log is verified regardless of filters.
+func (s *adminServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	entries, verifyErr := s.audit.entries()
+	if verifyErr != nil && entries == nil {
+		http.Error(w, verifyErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := &auditLogResponse{Entries: []proto.AuditEntry{}}
+	if verifyErr != nil {
+		resp.Error = verifyErr.Error()
+	}
+	user, action := r.URL.Query().Get("user"), r.URL.Query().Get("action")
+	for _, e := range entries {
+		if (user == "" || e.User == user) && (action == "" || e.Action == action) {
+			resp.Entries = append(resp.Entries, e)
+		}
+	}
+	body, contentType, err := util.MarshalResponse(r, resp, util.AllEncodings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestAuditLogVerification verifies that audit entries are chained
+// by hash and that modified and removed entries fail verification.
+func TestAuditLogVerification(t *testing.T) {
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20), stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newAuditLog(db)
+	for i := 0; i < 3; i++ {
+		if err := a.record(fmt.Sprintf("user%d", i), auditActionPutConfig, "zones/db1", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := a.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries; got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Sequence != int64(i+1) || e.User != fmt.Sprintf("user%d", i) {
+			t.Errorf("%d: unexpected entry %+v", i, e)
+		}
+		if i > 0 && !bytes.Equal(e.PrevHash, entries[i-1].Hash) {
+			t.Errorf("%d: entry isn't chained to its predecessor", i)
+		}
+	}
+
+	// Modify an entry.
+	modified := entries[1]
+	modified.User = "user3"
+	if err := db.PutProto(auditLogKey(2), &modified); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.entries(); err == nil {
+		t.Error("expected modified entry to fail verification")
+	}
+	if err := db.PutProto(auditLogKey(2), &entries[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.entries(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the last entry.
+	if err := db.Call(proto.Delete, &proto.DeleteRequest{
+		RequestHeader: proto.RequestHeader{Key: auditLogKey(3), User: storage.UserRoot},
+	}, &proto.DeleteResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.entries(); err == nil {
+		t.Error("expected removed entry to fail verification")
+	}
+}
+
+// TestAdminAudit verifies that config changes via the admin endpoint
+// are recorded to the audit log with the authenticated user, and that
+// the log can be queried by user and action.
+func TestAdminAudit(t *testing.T) {
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
+	defer httpServer.Close()
+
+	for _, method := range []string{"POST", "DELETE"} {
+		req, err := http.NewRequest(method, fmt.Sprintf("%s://%s%s/db1", adminScheme(), *addr, zonePathPrefix), bytes.NewReader([]byte(testZoneConfig)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "text/yaml")
+		req.Header.Set(util.UserHeader, storage.UserRoot)
+		if _, err := sendAdminRequest(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		query   string
+		actions []string
+	}{
+		{"", []string{auditActionPutConfig, auditActionDeleteConfig}},
+		{"?action=" + auditActionDeleteConfig, []string{auditActionDeleteConfig}},
+		{"?user=" + storage.UserRoot, []string{auditActionPutConfig, auditActionDeleteConfig}},
+		{"?user=other", nil},
+	}
+	for i, test := range testCases {
+		body, err := getText(fmt.Sprintf("%s://%s%s%s", adminScheme(), *addr, auditPath, test.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := &auditLogResponse{}
+		if err := json.Unmarshal(body, resp); err != nil {
+			t.Fatalf("%d: %s: %s", i, err, body)
+		}
+		if resp.Error != "" {
+			t.Errorf("%d: unexpected verification error: %s", i, resp.Error)
+		}
+		if len(resp.Entries) != len(test.actions) {
+			t.Fatalf("%d: expected %d entries; got %+v", i, len(test.actions), resp.Entries)
+		}
+		for j, e := range resp.Entries {
+			if e.Action != test.actions[j] || e.User != storage.UserRoot || e.Target != "zones/db1" {
+				t.Errorf("%d: unexpected entry %+v", i, e)
+			}
+		}
+	}
+}
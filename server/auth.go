@@ -0,0 +1,190 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/sql"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/structured"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// NewAuthToken returns a token, signed with key, which authenticates
+// HTTP requests on behalf of user until the expiration time. Tokens
+// are presented in the Authorization header as "Bearer <token>".
+func NewAuthToken(key []byte, user string, expiration time.Time) string {
+	payload := user + "|" + strconv.FormatInt(expiration.Unix(), 10)
+	return base64.URLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.URLEncoding.EncodeToString(signAuthToken(key, payload))
+}
+
+// signAuthToken returns the HMAC-SHA256 of the token payload.
+func signAuthToken(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifyAuthToken verifies the token's signature and expiration and
+// returns the user it authenticates.
+func verifyAuthToken(key []byte, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", util.Errorf("malformed auth token")
+	}
+	payload, err := base64.URLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", util.Errorf("malformed auth token: %s", err)
+	}
+	sig, err := base64.URLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", util.Errorf("malformed auth token: %s", err)
+	}
+	if !hmac.Equal(sig, signAuthToken(key, string(payload))) {
+		return "", util.Errorf("invalid auth token signature")
+	}
+	idx := strings.LastIndex(string(payload), "|")
+	if idx == -1 {
+		return "", util.Errorf("malformed auth token payload")
+	}
+	user := string(payload[:idx])
+	expiration, err := strconv.ParseInt(string(payload[idx+1:]), 10, 64)
+	if err != nil {
+		return "", util.Errorf("malformed auth token expiration: %s", err)
+	}
+	if now.Unix() >= expiration {
+		return "", util.Errorf("auth token for user %q expired at %s", user, time.Unix(expiration, 0))
+	}
+	if user == "" {
+		return "", util.Errorf("auth token does not specify a user")
+	}
+	return user, nil
+}
+
+// readAuthTokenKey reads the key used to sign auth tokens from the
+// specified file. Leading and trailing whitespace is ignored.
+func readAuthTokenKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.Errorf("unable to read auth token key: %s", err)
+	}
+	key := []byte(strings.TrimSpace(string(b)))
+	if len(key) == 0 {
+		return nil, util.Errorf("auth token key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// rootOnlyPrefixes lists the HTTP endpoints which may only be
+// accessed by the root user. Administrative endpoints modify cluster
+// configuration while the structured and SQL endpoints access the
+// key-value store as the root user, bypassing permission configs.
+var rootOnlyPrefixes = []string{
+	adminEndpoint,
+	debugEndpoint,
+	structured.StructuredKeyPrefix,
+	sql.Endpoint,
+}
+
+// An authenticator is an http.Handler which authenticates HTTP
+// requests before passing them on to a wrapped handler. Requests are
+// authenticated by a TLS client certificate, whose common name
+// specifies the user, or by a token signed with the token key. The
+// authenticated user is passed to the wrapped handler via the
+// util.UserHeader request header; the key-value endpoints execute
+// requests on the user's behalf, subject to the same permission
+// configs as requests arriving via RPC.
+//
+// If insecure is true, as it is for nodes started without
+// certificates, requests without credentials are executed as the root
+// user.
+type authenticator struct {
+	handler  http.Handler
+	tokenKey []byte // Key for signed tokens; nil if tokens are disabled
+	insecure bool   // Unauthenticated requests act as root
+}
+
+// newAuthenticator returns an authenticator wrapping handler.
+func newAuthenticator(handler http.Handler, tokenKey []byte, insecure bool) *authenticator {
+	return &authenticator{
+		handler:  handler,
+		tokenKey: tokenKey,
+		insecure: insecure,
+	}
+}
+
+// authenticate returns the user authenticated by the request's
+// credentials.
+func (a *authenticator) authenticate(r *http.Request) (string, error) {
+	if auth := r.Header.Get(util.AuthorizationHeader); auth != "" {
+		if !strings.HasPrefix(auth, util.AuthTokenScheme) {
+			return "", util.Errorf("unsupported authorization scheme")
+		}
+		if a.tokenKey == nil {
+			return "", util.Errorf("auth tokens are not enabled")
+		}
+		return verifyAuthToken(a.tokenKey, strings.TrimPrefix(auth, util.AuthTokenScheme), time.Now())
+	}
+	// Certificates presented by clients have been verified against the
+	// cluster CA during the TLS handshake.
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if user := r.TLS.PeerCertificates[0].Subject.CommonName; user != "" {
+			return user, nil
+		}
+		return "", util.Errorf("client certificate does not specify a user")
+	}
+	if a.insecure {
+		return storage.UserRoot, nil
+	}
+	return "", util.Errorf("no credentials supplied")
+}
+
+// ServeHTTP authenticates the request and, if the authenticated user
+// is permitted to access the requested endpoint, passes it on to the
+// wrapped handler. The health check endpoint requires no credentials.
+func (a *authenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Header.Del(util.UserHeader)
+	if r.URL.Path == healthzPath {
+		a.handler.ServeHTTP(w, r)
+		return
+	}
+	user, err := a.authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if user != storage.UserRoot {
+		for _, prefix := range rootOnlyPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				http.Error(w, util.Errorf("user %q cannot access %s", user, r.URL.Path).Error(), http.StatusForbidden)
+				return
+			}
+		}
+	}
+	r.Header.Set(util.UserHeader, user)
+	a.handler.ServeHTTP(w, r)
+}
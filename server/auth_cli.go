@@ -0,0 +1,62 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var authTokenTTL = flag.Duration("auth_token_ttl", 24*time.Hour, "duration for "+
+	"which auth tokens minted by the token command remain valid")
+
+// A CmdNewAuthToken command mints an auth token for a user.
+var CmdNewAuthToken = &commander.Command{
+	UsageLine: "token -auth_token_key=<key-file> <user>",
+	Short:     "mints an auth token for HTTP requests",
+	Long: `
+Mints a token which authenticates HTTP requests on behalf of <user>.
+The token is signed with the key in the file specified by the
+-auth_token_key command line flag, which must match the key used by
+the cluster's nodes, and is valid for the duration specified by the
+-auth_token_ttl command line flag. Present the token in the
+Authorization header of requests as "Bearer <token>".
+`,
+	Run:  runNewAuthToken,
+	Flag: *flag.CommandLine,
+}
+
+// runNewAuthToken mints a token for the user argument and writes it
+// to stdout.
+func runNewAuthToken(cmd *commander.Command, args []string) {
+	if len(args) != 1 || *authTokenKey == "" {
+		cmd.Usage()
+		return
+	}
+	key, err := readAuthTokenKey(*authTokenKey)
+	if err != nil {
+		log.Errorf("unable to mint auth token: %s", err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, NewAuthToken(key, args[0], time.Now().Add(*authTokenTTL)))
+}
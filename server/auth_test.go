@@ -0,0 +1,147 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/sql"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestAuthToken verifies that auth tokens authenticate their user
+// until they expire and that tampered tokens are rejected.
+func TestAuthToken(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1000, 0)
+	token := NewAuthToken(key, "alice|bob", now.Add(time.Minute))
+
+	if user, err := verifyAuthToken(key, token, now); err != nil || user != "alice|bob" {
+		t.Errorf("expected user alice|bob; got %q, %v", user, err)
+	}
+	if _, err := verifyAuthToken(key, token, now.Add(time.Minute)); err == nil {
+		t.Error("expected expired token to be rejected")
+	}
+	if _, err := verifyAuthToken([]byte("other"), token, now); err == nil {
+		t.Error("expected token signed with another key to be rejected")
+	}
+	forged := NewAuthToken(key, storage.UserRoot, now.Add(time.Minute))
+	tampered := forged[:len(forged)/2] + token[len(token)/2:]
+	if _, err := verifyAuthToken(key, tampered, now); err == nil {
+		t.Error("expected tampered token to be rejected")
+	}
+	for _, malformed := range []string{"", "abc", "a.b.c", "!!.!!"} {
+		if _, err := verifyAuthToken(key, malformed, now); err == nil {
+			t.Errorf("expected malformed token %q to be rejected", malformed)
+		}
+	}
+}
+
+// TestAuthenticator verifies that requests are authenticated by
+// client certificates and auth tokens, that the authenticated user
+// is passed to the wrapped handler and that only the root user may
+// access administrative endpoints.
+func TestAuthenticator(t *testing.T) {
+	key := []byte("secret")
+	var handledUser string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handledUser = r.Header.Get(util.UserHeader)
+	})
+	certState := func(user string) *tls.ConnectionState {
+		return &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: user}}},
+		}
+	}
+	token := func(user string) string {
+		return util.AuthTokenScheme + NewAuthToken(key, user, time.Now().Add(time.Hour))
+	}
+
+	testCases := []struct {
+		insecure  bool
+		path      string
+		tls       *tls.ConnectionState
+		auth      string
+		expStatus int
+		expUser   string
+	}{
+		// Unauthenticated requests act as root only if insecure.
+		{true, kv.DBPrefix, nil, "", http.StatusOK, storage.UserRoot},
+		{false, kv.DBPrefix, nil, "", http.StatusUnauthorized, ""},
+		{false, healthzPath, nil, "", http.StatusOK, ""},
+		// Client certificates.
+		{false, kv.DBPrefix, certState("alice"), "", http.StatusOK, "alice"},
+		{false, kv.RESTPrefix, certState("alice"), "", http.StatusOK, "alice"},
+		{false, kv.DBPrefix, certState(""), "", http.StatusUnauthorized, ""},
+		// Auth tokens, which take precedence over certificates.
+		{false, kv.DBPrefix, nil, token("bob"), http.StatusOK, "bob"},
+		{true, kv.DBPrefix, nil, token("bob"), http.StatusOK, "bob"},
+		{false, kv.DBPrefix, certState("alice"), token("bob"), http.StatusOK, "bob"},
+		{false, kv.DBPrefix, nil, util.AuthTokenScheme + "bogus", http.StatusUnauthorized, ""},
+		{false, kv.DBPrefix, nil, "Basic Ym9iOmJvYg==", http.StatusUnauthorized, ""},
+		// Root-only endpoints.
+		{false, zonePathPrefix, certState("alice"), "", http.StatusForbidden, ""},
+		{false, debugEndpoint + "vars", nil, token("bob"), http.StatusForbidden, ""},
+		{false, sql.Endpoint, certState("alice"), "", http.StatusForbidden, ""},
+		{false, sql.Endpoint, nil, token("bob"), http.StatusForbidden, ""},
+		{false, sql.Endpoint, nil, token(storage.UserRoot), http.StatusOK, storage.UserRoot},
+		{false, zonePathPrefix, certState(storage.UserRoot), "", http.StatusOK, storage.UserRoot},
+		{true, zonePathPrefix, nil, "", http.StatusOK, storage.UserRoot},
+	}
+	for i, test := range testCases {
+		handledUser = ""
+		auth := newAuthenticator(handler, key, test.insecure)
+		r, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A user header supplied by the client is never trusted.
+		r.Header.Set(util.UserHeader, "mallory")
+		r.TLS = test.tls
+		if test.auth != "" {
+			r.Header.Set(util.AuthorizationHeader, test.auth)
+		}
+		w := httptest.NewRecorder()
+		auth.ServeHTTP(w, r)
+		if w.Code != test.expStatus {
+			t.Errorf("%d: expected status %d; got %d: %s", i, test.expStatus, w.Code, w.Body)
+		}
+		if handledUser != test.expUser {
+			t.Errorf("%d: expected user %q; got %q", i, test.expUser, handledUser)
+		}
+	}
+
+	// Tokens are rejected if not enabled.
+	r, err := http.NewRequest("GET", kv.DBPrefix, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(util.AuthorizationHeader, token("bob"))
+	w := httptest.NewRecorder()
+	newAuthenticator(handler, nil, true).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected tokens to be rejected when disabled; got status %d", w.Code)
+	}
+}
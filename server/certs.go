@@ -0,0 +1,90 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// certsReloadPath is the endpoint which reloads the node's TLS
+// certificates.
+const certsReloadPath = adminEndpoint + "certs/reload"
+
+// handleCertsReload reloads the node's TLS certificates and key from
+// the certificate directory. New RPC and HTTP connections use the new
+// certificates; cached RPC clients are gradually replaced.
+func (s *adminServer) handleCertsReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := s.tlsConfig.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.audit.record(r.Header.Get(util.UserHeader), auditActionReloadCerts, "certs", nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "reloaded certificates")
+}
+
+// A CmdReloadCerts command reloads a node's TLS certificates.
+var CmdReloadCerts = &commander.Command{
+	UsageLine: "reload-certs [options]",
+	Short:     "reloads a node's TLS certificates",
+	Long: `
+Reloads the TLS certificates and key of the node at -addr from its
+certificate directory (see -certs), so that certificates may be
+rotated without a restart. Connections established from then on use
+the new certificates, and the node gradually reconnects to its peers.
+Nodes also reload certificates on their own when the files change
+(see -cert_reload_interval). The command connects over TLS using the
+certificates in the same directory, and authenticates as root with
+the token specified by -auth_token.
+`,
+	Run:  runReloadCerts,
+	Flag: *flag.CommandLine,
+}
+
+// runReloadCerts invokes the REST API with POST action.
+func runReloadCerts(cmd *commander.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		return
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s", adminScheme(), *addr, certsReloadPath), nil)
+	if err != nil {
+		log.Errorf("unable to create request to admin REST endpoint: %s", err)
+		return
+	}
+	b, err := sendAdminRequest(req)
+	if err != nil {
+		log.Errorf("admin REST request failed: %s", err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%s", b)
+}
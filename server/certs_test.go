@@ -0,0 +1,126 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestCertsReload verifies that certificates are reloaded via the
+// admin API, that the reload is audited and that nodes without
+// certificates refuse to reload.
+func TestCertsReload(t *testing.T) {
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20), stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := rpc.LoadTestTLSConfig("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := false
+	tlsConfig.OnReload(func() { reloaded = true })
+
+	for _, test := range []struct {
+		tlsConfig *rpc.TLSConfig
+		expOK     bool
+	}{
+		{rpc.LoadInsecureTLSConfig(), false},
+		{tlsConfig, true},
+	} {
+		admin := newAdminServer(db, test.tlsConfig)
+		mux := http.NewServeMux()
+		admin.RegisterHandlers(mux)
+		httpServer := httptest.NewServer(mux)
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s%s", httpServer.URL, certsReloadPath), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = sendAdminRequest(req)
+		httpServer.Close()
+		if ok := err == nil; ok != test.expOK {
+			t.Errorf("expected success %t; got %v", test.expOK, err)
+		}
+	}
+	if !reloaded {
+		t.Error("expected certificates to be reloaded")
+	}
+
+	entries, err := newAuditLog(db).entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != auditActionReloadCerts {
+		t.Errorf("expected a single reload-certs audit entry; got %+v", entries)
+	}
+}
+
+// TestSecureAdminRequest verifies that admin commands connect to a
+// node started with certificates over TLS and authenticate with the
+// auth token flag.
+func TestSecureAdminRequest(t *testing.T) {
+	keyFile := createTestConfigFile("test-key")
+	defer os.Remove(keyFile)
+	defer func(key, dir, a, token string) {
+		*authTokenKey, *certDir, *addr, *authToken = key, dir, a, token
+	}(*authTokenKey, *certDir, *addr, *authToken)
+	*authTokenKey = keyFile
+
+	s := &TestServer{CertDir: "../resources/test_certs"}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	*addr = s.HTTPAddr
+
+	expiration := time.Now().Add(time.Hour)
+	testCases := []struct {
+		certDir, token string
+		expOK          bool
+	}{
+		// Nodes started with certificates don't speak plain HTTP.
+		{"", NewAuthToken([]byte("test-key"), storage.UserRoot, expiration), false},
+		// The test certificate doesn't name the root user.
+		{s.CertDir, "", false},
+		{s.CertDir, NewAuthToken([]byte("test-key"), "bob", expiration), false},
+		{s.CertDir, NewAuthToken([]byte("test-key"), storage.UserRoot, expiration), true},
+	}
+	for i, test := range testCases {
+		*certDir, *authToken = test.certDir, test.token
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s", adminScheme(), *addr, certsReloadPath), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sendAdminRequest(req); (err == nil) != test.expOK {
+			t.Errorf("%d: expected success %t; got %v", i, test.expOK, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"flag"
+	"net/http"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var conformanceFailEvery = flag.Int("conformance_fail_every", 10, "fail the first "+
+	"attempt of every nth read-write request served by the conformance command; "+
+	"0 to disable")
+
+// A CmdConformance command serves the key-value protocol for testing
+// client implementations.
+var CmdConformance = &commander.Command{
+	UsageLine: "conformance -http=<addr>",
+	Short:     "serves the key-value protocol for client conformance tests",
+	Long: `
+Starts a single node with an in-memory store and serves the key-value
+protocol specified by package kv on the address given by the -http
+command line flag. Requests are executed as the root user. The first
+attempt of every nth read-write request, as set by the
+-conformance_fail_every command line flag, fails with 503 Service
+Unavailable and must be retried by the client.
+
+After running a client's test suite against the server, fetch a JSON
+report of its requests from ` + kv.ConformanceReportPath + `; a conforming
+client causes no violations and retries every injected failure.
+`,
+	Run:  runConformance,
+	Flag: *flag.CommandLine,
+}
+
+// runConformance starts an in-memory node and serves a
+// kv.ConformanceServer backed by it until the process is killed.
+func runConformance(cmd *commander.Command, args []string) {
+	ts := &TestServer{}
+	if err := ts.Start(); err != nil {
+		log.Errorf("unable to start conformance server: %s", err)
+		return
+	}
+	defer ts.Stop()
+	cs := kv.NewConformanceServer(ts.kv.Sender(), ts.gatewayBudget, *conformanceFailEvery)
+	log.Infof("serving key-value protocol conformance tests on %s", *httpAddr)
+	if err := http.ListenAndServe(*httpAddr, cs); err != nil {
+		log.Errorf("unable to serve conformance tests: %s", err)
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	commander "code.google.com/p/go-commander"
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/keys"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+	yaml "gopkg.in/yaml.v1"
+)
+
+// A CmdDebugKey command translates between raw and pretty-printed
+// keys.
+var CmdDebugKey = &commander.Command{
+	UsageLine: "debug-key <key>",
+	Short:     "translates between raw and pretty-printed keys",
+	Long: `
+Translates between raw and human-readable renderings of a key. If
+<key> begins with a slash, it is parsed as a pretty-printed key, such
+as /Meta2/"apple" or /Local/RangeMVCCStats/1, and the raw key
+is displayed as a Go-quoted string. Otherwise, <key> is interpreted as
+a Go-quoted raw key and its pretty-printed form is displayed.
+`,
+	Run:  runDebugKey,
+	Flag: *flag.CommandLine,
+}
+
+// runDebugKey parses the key argument in either format and displays
+// both renderings.
+func runDebugKey(cmd *commander.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		return
+	}
+	var key proto.Key
+	if strings.HasPrefix(args[0], "/") {
+		var err error
+		if key, err = keys.Parse(args[0]); err != nil {
+			log.Errorf("unable to parse key: %s", err)
+			return
+		}
+	} else {
+		u, err := strconv.Unquote(`"` + args[0] + `"`)
+		if err != nil {
+			log.Errorf("unable to unquote key %q: %s", args[0], err)
+			return
+		}
+		key = proto.Key(u)
+	}
+	fmt.Fprintf(os.Stdout, "raw:    %q\npretty: %s\n", key, keys.PrettyPrint(key))
+}
+
+// A CmdDebugCheck command verifies range invariants on the stores
+// of a stopped node.
+var CmdDebugCheck = &commander.Command{
+	UsageLine: "debug-check -stores=(ssd=<data-dir>,hdd|7200rpm=<data-dir>)[,...]",
+	Short:     "checks range invariants on offline stores",
+	Long: `
+Opens each store specified via the -stores command line flag and
+verifies, for every range on the store, that the range descriptor,
+stats, response cache, and transaction records are consistent with
+the range's MVCC data. The node must not be running.
+`,
+	Run:  runDebugCheck,
+	Flag: *flag.CommandLine,
+}
+
+// runDebugCheck checks the invariants of each range on each store and
+// displays any violations found.
+func runDebugCheck(cmd *commander.Command, args []string) {
+	engines, err := initEngines(*stores)
+	if err != nil {
+		log.Errorf("Failed to initialize engines from -stores=%s: %v", *stores, err)
+		return
+	}
+	for i, e := range engines {
+		if err := e.Start(); err != nil {
+			log.Errorf("unable to start engine %d: %s", i, err)
+			continue
+		}
+		if err := checkEngine(e); err != nil {
+			log.Errorf("unable to check engine %d: %s", i, err)
+		}
+		e.Stop()
+	}
+}
+
+// checkEngine checks the invariants of each range on the store backed
+// by the specified engine.
+func checkEngine(e engine.Engine) error {
+	var ident proto.StoreIdent
+	ok, _, _, err := engine.GetProto(e, engine.MVCCEncodeKey(engine.KeyLocalIdent), &ident)
+	if err != nil {
+		return err
+	} else if !ok {
+		return util.Errorf("store is not bootstrapped")
+	}
+	start := engine.KeyLocalRangeDescriptorPrefix
+	return engine.NewMVCC(e).IterateCommitted(start, start.PrefixEnd(), func(kv proto.KeyValue) (bool, error) {
+		var desc proto.RangeDescriptor
+		if err := gogoproto.Unmarshal(kv.Value.Bytes, &desc); err != nil {
+			return false, err
+		}
+		replica := desc.FindReplica(ident.StoreID)
+		if replica == nil {
+			fmt.Fprintf(os.Stdout, "range %s-%s: no replica for store %d\n",
+				keys.PrettyPrint(desc.StartKey), keys.PrettyPrint(desc.EndKey), ident.StoreID)
+			return false, nil
+		}
+		violations, err := engine.CheckRangeInvariants(e, replica.RangeID, &desc)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range violations {
+			fmt.Fprintf(os.Stdout, "range %d: %s\n", replica.RangeID, v)
+		}
+		fmt.Fprintf(os.Stdout, "store %d, range %d (%s-%s): %d violation(s)\n", ident.StoreID, replica.RangeID,
+			keys.PrettyPrint(desc.StartKey), keys.PrettyPrint(desc.EndKey), len(violations))
+		return false, nil
+	})
+}
+
+// A CmdDebugScan command displays the raw key/value data of the
+// stores of a stopped node.
+var CmdDebugScan = &commander.Command{
+	UsageLine: "debug-scan -stores=(ssd=<data-dir>,hdd|7200rpm=<data-dir>)[,...] [<start-key> [<end-key>]]",
+	Short:     "displays the key/value data of offline stores",
+	Long: `
+Opens each store specified via the -stores command line flag and
+displays, for every key in [<start-key>, <end-key>), its MVCC metadata
+and versions. Keys are specified and displayed pretty-printed, as
+accepted by debug-key. Values of system keys, such as range
+descriptors, transaction records and configs, are decoded as the
+protocol buffer stored at the key; other values are displayed quoted.
+The start and end keys default to /Min and /Max. The node must not be
+running.
+`,
+	Run:  runDebugScan,
+	Flag: *flag.CommandLine,
+}
+
+// runDebugScan displays the key/value data in the specified span of
+// each store.
+func runDebugScan(cmd *commander.Command, args []string) {
+	if len(args) > 2 {
+		cmd.Usage()
+		return
+	}
+	span := []proto.Key{engine.KeyMin, engine.KeyMax}
+	for i, arg := range args {
+		key, err := keys.Parse(arg)
+		if err != nil {
+			log.Errorf("unable to parse key: %s", err)
+			return
+		}
+		span[i] = key
+	}
+	engines, err := initEngines(*stores)
+	if err != nil {
+		log.Errorf("Failed to initialize engines from -stores=%s: %v", *stores, err)
+		return
+	}
+	start, end := engine.MVCCEncodeKey(span[0]), engine.MVCCEncodeKey(span[1])
+	for i, e := range engines {
+		if err := e.Start(); err != nil {
+			log.Errorf("unable to start engine %d: %s", i, err)
+			continue
+		}
+		if err := e.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", keys.PrettyPrintEncoded(kv.Key), keys.PrettyPrintRawValue(kv.Key, kv.Value))
+			return false, nil
+		}); err != nil {
+			log.Errorf("unable to scan engine %d: %s", i, err)
+		}
+		e.Stop()
+	}
+}
+
+// A CmdDebugAllocSim command simulates allocator rebalancing against
+// a snapshot of store descriptors.
+var CmdDebugAllocSim = &commander.Command{
+	UsageLine: "debug-allocsim [options] <snapshot-file>",
+	Short:     "simulates allocator rebalancing for a store snapshot",
+	Long: `
+Replays the allocator's rebalancing decisions over simulated time
+against the store descriptors and range counts in <snapshot-file>,
+without contacting any node. The expected movement plan and final
+range counts are displayed.
+
+The snapshot file format has the following YAML schema:
+
+  seed: <random-seed>
+  max_steps: <max-simulated-steps>
+  stores:
+    - store_id: <store-id>
+      node_id: <node-id>
+      attrs: [comma-separated store attribute list]
+      node_attrs: [comma-separated node attribute list]
+      capacity: <size-in-bytes>
+      available: <size-in-bytes>
+      range_count: <count>
+    - ...
+`,
+	Run:  runDebugAllocSim,
+	Flag: *flag.CommandLine,
+}
+
+// allocSimSnapshot is the YAML representation of an allocator
+// simulation snapshot.
+type allocSimSnapshot struct {
+	Seed     int64
+	MaxSteps int `yaml:"max_steps"`
+	Stores   []struct {
+		StoreID    int32    `yaml:"store_id"`
+		NodeID     int32    `yaml:"node_id"`
+		Attrs      []string `yaml:"attrs"`
+		NodeAttrs  []string `yaml:"node_attrs"`
+		Capacity   int64    `yaml:"capacity"`
+		Available  int64    `yaml:"available"`
+		RangeCount int      `yaml:"range_count"`
+	}
+}
+
+// runDebugAllocSim reads the snapshot file, runs the simulation and
+// displays the movement plan.
+func runDebugAllocSim(cmd *commander.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		return
+	}
+	b, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		log.Errorf("unable to read snapshot file %q: %s", args[0], err)
+		return
+	}
+	var snapshot allocSimSnapshot
+	if err := yaml.Unmarshal(b, &snapshot); err != nil {
+		log.Errorf("unable to parse snapshot file %q: %s", args[0], err)
+		return
+	}
+	if snapshot.MaxSteps == 0 {
+		snapshot.MaxSteps = 1000
+	}
+	var stores []*storage.StoreDescriptor
+	rangeCounts := map[int32]int{}
+	for _, s := range snapshot.Stores {
+		stores = append(stores, &storage.StoreDescriptor{
+			StoreID: s.StoreID,
+			Attrs:   proto.Attributes{Attrs: s.Attrs},
+			Node: storage.NodeDescriptor{
+				NodeID: s.NodeID,
+				Attrs:  proto.Attributes{Attrs: s.NodeAttrs},
+			},
+			Capacity: engine.StoreCapacity{Capacity: s.Capacity, Available: s.Available},
+		})
+		rangeCounts[s.StoreID] = s.RangeCount
+	}
+	sim := storage.NewAllocatorSimulation(stores, rangeCounts, snapshot.Seed)
+	plan := sim.Run(snapshot.MaxSteps)
+	for _, m := range plan {
+		fmt.Fprintf(os.Stdout, "%s\n", m)
+	}
+	fmt.Fprintf(os.Stdout, "%d replica(s) moved; final range counts:\n", len(plan))
+	counts := sim.RangeCounts()
+	for _, s := range stores {
+		fmt.Fprintf(os.Stdout, "  store %d: %d -> %d\n", s.StoreID, rangeCounts[s.StoreID], counts[s.StoreID])
+	}
+}
+
+// A CmdDebugRaft command displays the replication state of the
+// replicas on a node's stores.
+var CmdDebugRaft = &commander.Command{
+	UsageLine: "debug-raft [options] [<raft-id>]",
+	Short:     "displays the replication state of a node's replicas",
+	Long: `
+Fetches the replication state of the replicas on the stores of the node
+at -addr from ` + statusLocalRaftKey + ` and displays, for each
+replica, whether it considers itself leader, the leader lease, the
+range's replicas and the commands pending proposal or in flight, along
+with each store's snapshots in progress. If <raft-id> is specified,
+only the replicas of that range are displayed.
+`,
+	Run:  runDebugRaft,
+	Flag: *flag.CommandLine,
+}
+
+// runDebugRaft invokes the REST API with GET action and displays the
+// Raft status of each store.
+func runDebugRaft(cmd *commander.Command, args []string) {
+	if len(args) > 1 {
+		cmd.Usage()
+		return
+	}
+	path := fmt.Sprintf("%s://%s%s", adminScheme(), *addr, statusLocalRaftKey)
+	if len(args) == 1 {
+		path += "?range=" + url.QueryEscape(args[0])
+	}
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		log.Errorf("unable to create request to status REST endpoint: %s", err)
+		return
+	}
+	req.Header.Add("Accept", util.JSONContentType)
+	b, err := sendAdminRequest(req)
+	if err != nil {
+		log.Errorf("status REST request failed: %s", err)
+		return
+	}
+	resp := &raftStatusResponse{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		log.Errorf("unable to decode raft status: %s", err)
+		return
+	}
+	for _, s := range resp.Stores {
+		fmt.Fprintf(os.Stdout, "store %d: %d snapshot(s) sending, %d queued, %d bytes reserved for incoming snapshots\n",
+			s.StoreID, s.SnapshotsActive, s.SnapshotsQueued, s.ReservedBytes)
+		for _, r := range s.Ranges {
+			fmt.Fprintf(os.Stdout, "  range %d (replica %d) [%s, %s):\n", r.RaftID, r.RangeID,
+				keys.PrettyPrint(r.StartKey), keys.PrettyPrint(r.EndKey))
+			fmt.Fprintf(os.Stdout, "    leader: %t; quiesced: %t; splitting: %t; unavailable: %t\n",
+				r.Leader, r.Quiesced, r.Splitting, r.Unavailable)
+			if r.Lease != nil {
+				fmt.Fprintf(os.Stdout, "    lease: store %d until %s\n", r.Lease.Replica.StoreID, r.Lease.Expiration)
+			}
+			for _, replica := range r.Replicas {
+				fmt.Fprintf(os.Stdout, "    replica %d: node %d, store %d\n", replica.RangeID, replica.NodeID, replica.StoreID)
+			}
+			fmt.Fprintf(os.Stdout, "    %d command(s) pending proposal, %d in flight\n", r.PendingProposals, r.InFlight)
+		}
+	}
+}
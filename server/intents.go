@@ -0,0 +1,271 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// intentsPath is the endpoint for listing and resolving outstanding
+// write intents.
+const intentsPath = adminEndpoint + "intents"
+
+// intentInfo describes an outstanding write intent.
+type intentInfo struct {
+	Key     proto.Key `json:"key"`
+	TxnID   proto.Key `json:"txn_id" yaml:"txn_id"`
+	TxnName string    `json:"txn_name" yaml:"txn_name"`
+	// Status is the status of the intent's transaction: as recorded
+	// on the intent when listing, and as found by the push when
+	// resolving.
+	Status string `json:"status"`
+	// Age is the time since the transaction was last heartbeat, as
+	// recorded on the intent.
+	Age time.Duration `json:"age"`
+	// Resolved is set if the intent was resolved.
+	Resolved bool `json:"resolved,omitempty" yaml:"resolved,omitempty"`
+	// Error describes the failure to push the intent's transaction or
+	// to resolve the intent, if any.
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// intentsResponse is the response to intent queries and resolutions.
+type intentsResponse struct {
+	Intents []intentInfo `json:"intents"`
+}
+
+// parseIntentSpan parses a key span of the form "start,end". If end
+// is omitted, the span covers the keys prefixed by start; if the span
+// is empty, it covers all keys.
+func parseIntentSpan(span string) (proto.Key, proto.Key, error) {
+	if span == "" {
+		return engine.KeyMin, engine.KeyMax, nil
+	}
+	parts := strings.SplitN(span, ",", 2)
+	start := proto.Key(parts[0])
+	if len(parts) == 1 {
+		return start, start.PrefixEnd(), nil
+	}
+	end := proto.Key(parts[1])
+	if !start.Less(end) {
+		return nil, nil, util.Errorf("span start %q must precede end %q", start, end)
+	}
+	return start, end, nil
+}
+
+// rangesInSpan returns the descriptors of the ranges overlapping the
+// span [start, end), read from the meta2 addressing records.
+func rangesInSpan(db *client.KV, start, end proto.Key) ([]proto.RangeDescriptor, error) {
+	// Addressing records are keyed by range end key, so the first
+	// range overlapping the span is the first with end key > start.
+	rows, err := scanSpans(db, [][2]proto.Key{{engine.MakeKey(engine.KeyMeta2Prefix, start.Next()), engine.KeyMetaMax}})
+	if err != nil {
+		return nil, err
+	}
+	var descs []proto.RangeDescriptor
+	for _, kv := range rows {
+		desc := proto.RangeDescriptor{}
+		if err := gogoproto.Unmarshal(kv.Value.Bytes, &desc); err != nil {
+			return nil, util.Errorf("could not decode range descriptor %q: %s", kv.Key, err)
+		}
+		if !desc.StartKey.Less(end) {
+			break
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+// scanIntents returns the write intents in the span [start, end)
+// whose transactions haven't been heartbeat within minAge, in key
+// order.
+func scanIntents(db *client.KV, start, end proto.Key, minAge time.Duration) ([]proto.ScannedIntent, error) {
+	descs, err := rangesInSpan(db, start, end)
+	if err != nil {
+		return nil, err
+	}
+	var intents []proto.ScannedIntent
+	for _, desc := range descs {
+		args := &proto.InternalScanIntentsRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    desc.StartKey,
+				EndKey: desc.EndKey,
+				User:   storage.UserRoot,
+			},
+		}
+		if args.Key.Less(start) {
+			args.Key = start
+		}
+		if end.Less(args.EndKey) {
+			args.EndKey = end
+		}
+		reply := &proto.InternalScanIntentsResponse{}
+		if err := db.Call(proto.InternalScanIntents, args, reply); err != nil {
+			return nil, err
+		}
+		for _, intent := range reply.Intents {
+			if intentAge(&intent.Txn) >= minAge {
+				intents = append(intents, intent)
+			}
+		}
+	}
+	return intents, nil
+}
+
+// intentAge returns the time since txn was last heartbeat or, if it
+// never was, since it began.
+func intentAge(txn *proto.Transaction) time.Duration {
+	lastHeartbeat := txn.Timestamp
+	if txn.LastHeartbeat != nil {
+		lastHeartbeat = *txn.LastHeartbeat
+	}
+	return time.Duration(time.Now().UnixNano() - lastHeartbeat.WallTime)
+}
+
+// resolveIntents pushes the transaction of each intent and resolves
+// the intents of transactions found to be committed or aborted. The
+// pushes abort transactions whose records show they've stopped
+// heartbeating; if force is set, they instead abort any pending
+// transaction at maximum priority.
+func resolveIntents(db *client.KV, intents []proto.ScannedIntent, force bool) []intentInfo {
+	infos := make([]intentInfo, len(intents))
+	pushed := map[string]*proto.Transaction{}
+	pushErrs := map[string]error{}
+	for i, intent := range intents {
+		infos[i] = newIntentInfo(intent)
+		id := string(intent.Txn.ID)
+		if _, ok := pushed[id]; !ok && pushErrs[id] == nil {
+			pushArgs := &proto.InternalPushTxnRequest{
+				RequestHeader: proto.RequestHeader{
+					Key:  intent.Txn.ID,
+					User: storage.UserRoot,
+				},
+				PusheeTxn:   intent.Txn,
+				Abort:       true,
+				ExpiredOnly: !force,
+			}
+			if force {
+				// A negative user priority is taken as an explicit priority.
+				pushArgs.UserPriority = gogoproto.Int32(-math.MaxInt32)
+			}
+			pushReply := &proto.InternalPushTxnResponse{}
+			if err := db.Call(proto.InternalPushTxn, pushArgs, pushReply); err != nil {
+				pushErrs[id] = err
+			} else {
+				pushed[id] = pushReply.PusheeTxn
+			}
+		}
+		if err := pushErrs[id]; err != nil {
+			infos[i].Error = err.Error()
+			continue
+		}
+		txn := pushed[id]
+		infos[i].Status = txn.Status.String()
+		if txn.Status == proto.PENDING {
+			continue
+		}
+		resolveArgs := &proto.InternalResolveIntentRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: txn.Timestamp,
+				Key:       intent.Key,
+				User:      storage.UserRoot,
+				Txn:       txn,
+			},
+		}
+		if err := db.Call(proto.InternalResolveIntent, resolveArgs, &proto.InternalResolveIntentResponse{}); err != nil {
+			infos[i].Error = err.Error()
+			continue
+		}
+		infos[i].Resolved = true
+	}
+	return infos
+}
+
+// newIntentInfo returns a description of intent as recorded on the
+// intent itself.
+func newIntentInfo(intent proto.ScannedIntent) intentInfo {
+	return intentInfo{
+		Key:     intent.Key,
+		TxnID:   intent.Txn.ID,
+		TxnName: intent.Txn.Name,
+		Status:  intent.Txn.Status.String(),
+		Age:     intentAge(&intent.Txn),
+	}
+}
+
+// handleIntents lists the write intents in the key span given by the
+// "span" query parameter (see parseIntentSpan) whose transactions
+// haven't been heartbeat within the "min_age" duration. A POST pushes
+// the transactions of the listed intents and resolves the intents of
+// those found to be committed or aborted, giving operators a way to
+// clear intents of abandoned transactions from hot keys. Pushes only
+// abort transactions which have stopped heartbeating unless "force"
+// is true.
+func (s *adminServer) handleIntents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	start, end, err := parseIntentSpan(query.Get("span"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var minAge time.Duration
+	if v := query.Get("min_age"); v != "" {
+		if minAge, err = time.ParseDuration(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	intents, err := scanIntents(s.db, start, end, minAge)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := &intentsResponse{Intents: []intentInfo{}}
+	if r.Method == "POST" {
+		resp.Intents = resolveIntents(s.db, intents, query.Get("force") == "true")
+		if err := s.audit.record(r.Header.Get(util.UserHeader), auditActionResolveIntents, auditTarget(intentsPath, "?"+r.URL.RawQuery), nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		for _, intent := range intents {
+			resp.Intents = append(resp.Intents, newIntentInfo(intent))
+		}
+	}
+	body, contentType, err := util.MarshalResponse(r, resp, util.AllEncodings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
@@ -0,0 +1,139 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// TestParseIntentSpan verifies parsing of the span query parameter
+// of the intents endpoint.
+func TestParseIntentSpan(t *testing.T) {
+	testCases := []struct {
+		span       string
+		start, end proto.Key
+		expErr     bool
+	}{
+		{"", engine.KeyMin, engine.KeyMax, false},
+		{"a,c", proto.Key("a"), proto.Key("c"), false},
+		{"a", proto.Key("a"), proto.Key("b"), false},
+		{"c,a", nil, nil, true},
+		{"a,a", nil, nil, true},
+	}
+	for i, test := range testCases {
+		start, end, err := parseIntentSpan(test.span)
+		if (err != nil) != test.expErr {
+			t.Errorf("%d: expected error %t; got %v", i, test.expErr, err)
+			continue
+		}
+		if !start.Equal(test.start) || !end.Equal(test.end) {
+			t.Errorf("%d: expected span [%q, %q); got [%q, %q)", i, test.start, test.end, start, end)
+		}
+	}
+}
+
+// TestAdminIntents verifies that the intents endpoint lists the
+// intents of a pending transaction, that resolution leaves live
+// transactions alone unless forced and that a forced resolution
+// aborts the transaction and clears its intents.
+func TestAdminIntents(t *testing.T) {
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20), stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := newAdminServer(db, rpc.LoadInsecureTLSConfig())
+	mux := http.NewServeMux()
+	admin.RegisterHandlers(mux)
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	clock := hlc.NewClock(hlc.UnixNano)
+	// An explicit priority of 1 loses to the forced push.
+	txn := proto.NewTransaction("stuck", proto.Key("a"), -1, proto.SERIALIZABLE, clock.Now(), clock.MaxOffset().Nanoseconds())
+	for _, key := range []string{"a", "b"} {
+		if err := db.Call(proto.Put, &proto.PutRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:  proto.Key(key),
+				User: storage.UserRoot,
+				Txn:  txn,
+			},
+			Value: proto.Value{Bytes: []byte("value")},
+		}, &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		method, query string
+		expKeys       []string
+		expResolved   bool
+	}{
+		{"GET", "?span=a,c", []string{"a", "b"}, false},
+		{"GET", "?span=b", []string{"b"}, false},
+		{"GET", "?span=a,c&min_age=1h", nil, false},
+		{"POST", "?span=a,c", []string{"a", "b"}, false},
+		{"POST", "?span=a,c&force=true", []string{"a", "b"}, true},
+		{"GET", "?span=a,c", nil, false},
+	}
+	for i, test := range testCases {
+		req, err := http.NewRequest(test.method, fmt.Sprintf("%s%s%s", httpServer.URL, intentsPath, test.query), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		body, err := sendAdminRequest(req)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		resp := &intentsResponse{}
+		if err := json.Unmarshal(body, resp); err != nil {
+			t.Fatalf("%d: %s: %s", i, err, body)
+		}
+		if len(resp.Intents) != len(test.expKeys) {
+			t.Fatalf("%d: expected intents on %v; got %+v", i, test.expKeys, resp.Intents)
+		}
+		for j, intent := range resp.Intents {
+			if !intent.Key.Equal(proto.Key(test.expKeys[j])) || !intent.TxnID.Equal(txn.ID) || intent.Resolved != test.expResolved {
+				t.Errorf("%d: unexpected intent %+v", i, intent)
+			}
+			if test.expResolved && intent.Status != proto.ABORTED.String() {
+				t.Errorf("%d: expected transaction to be aborted; got %+v", i, intent)
+			}
+		}
+	}
+
+	gReply := &proto.GetResponse{}
+	if err := db.Call(proto.Get, &proto.GetRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.Key("a"), User: storage.UserRoot},
+	}, gReply); err != nil || gReply.Value != nil {
+		t.Errorf("expected aborted write to be removed; got %v, %v", gReply.Value, err)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+const (
+	// metadataPath is the endpoint for exporting and importing
+	// cluster metadata.
+	metadataPath = adminEndpoint + "metadata"
+)
+
+// configKeySpans are the spans of system keys holding cluster
+// configuration: accounting, permission and zone configs, schemas and
+// the active cluster version.
+var configKeySpans = [][2]proto.Key{
+	{engine.KeyClusterVersion, engine.KeyClusterVersion.Next()},
+	{engine.KeyConfigAccountingPrefix, engine.KeyConfigAccountingPrefix.PrefixEnd()},
+	{engine.KeyConfigPermissionPrefix, engine.KeyConfigPermissionPrefix.PrefixEnd()},
+	{engine.KeyConfigZonePrefix, engine.KeyConfigZonePrefix.PrefixEnd()},
+	{engine.KeySchemaPrefix, engine.KeySchemaPrefix.PrefixEnd()},
+}
+
+// generatorKeySpans are the spans of system keys holding the ID
+// generator sequences for nodes, stores, raft groups and ranges.
+var generatorKeySpans = [][2]proto.Key{
+	{engine.KeyNodeIDGenerator, engine.KeyNodeIDGenerator.Next()},
+	{engine.KeyRaftIDGenerator, engine.KeyRaftIDGenerator.Next()},
+	{engine.KeyRangeIDGenerator, engine.KeyRangeIDGenerator.Next()},
+	{engine.KeyStoreIDGeneratorPrefix, engine.KeyStoreIDGeneratorPrefix.PrefixEnd()},
+}
+
+// clusterMetadata is the exported metadata of a cluster, sufficient
+// to restore its configuration and range addressing into a freshly
+// bootstrapped cluster.
+type clusterMetadata struct {
+	// Configs are the config key/value pairs; see configKeySpans.
+	Configs []proto.KeyValue `json:"configs"`
+	// Generators are the node, store, raft and range ID generator
+	// sequences; see generatorKeySpans.
+	Generators []proto.KeyValue `json:"generators"`
+	// Ranges are the range descriptors read from the meta2
+	// addressing records.
+	Ranges []proto.RangeDescriptor `json:"ranges"`
+}
+
+// scanSpans returns the key/value pairs in each of the spans, in
+// order.
+func scanSpans(db *client.KV, spans [][2]proto.Key) ([]proto.KeyValue, error) {
+	var rows []proto.KeyValue
+	for _, span := range spans {
+		sr := &proto.ScanResponse{}
+		if err := db.Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    span[0],
+				EndKey: span[1],
+				User:   storage.UserRoot,
+			},
+			MaxResults: maxGetResults,
+		}, sr); err != nil {
+			return nil, err
+		}
+		rows = append(rows, sr.Rows...)
+	}
+	return rows, nil
+}
+
+// exportMetadata reads the configs, ID generators and range
+// descriptors of the cluster.
+func exportMetadata(db *client.KV) (*clusterMetadata, error) {
+	md := &clusterMetadata{}
+	var err error
+	if md.Configs, err = scanSpans(db, configKeySpans); err != nil {
+		return nil, err
+	}
+	if md.Generators, err = scanSpans(db, generatorKeySpans); err != nil {
+		return nil, err
+	}
+	rows, err := scanSpans(db, [][2]proto.Key{{engine.KeyMeta2Prefix, engine.KeyMetaMax}})
+	if err != nil {
+		return nil, err
+	}
+	md.Ranges = make([]proto.RangeDescriptor, len(rows))
+	for i, kv := range rows {
+		if err := gogoproto.Unmarshal(kv.Value.Bytes, &md.Ranges[i]); err != nil {
+			return nil, util.Errorf("could not decode range descriptor %q: %s", kv.Key, err)
+		}
+	}
+	return md, nil
+}
+
+// inSpans returns whether key is contained in one of the spans.
+func inSpans(key proto.Key, spans [][2]proto.Key) bool {
+	for _, span := range spans {
+		if !key.Less(span[0]) && key.Less(span[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// importMetadata writes exported metadata to the cluster in a single
+// transaction. Configs overwrite the cluster's own. ID generators are
+// only ever advanced, so that IDs allocated by the exported cluster
+// aren't reused. Range descriptors are written to the meta2
+// addressing records, except for those of ranges holding addressing
+// records themselves, which are served by the cluster's own first
+// range. Returns the number of range descriptors imported.
+func importMetadata(db *client.KV, md *clusterMetadata) (int, error) {
+	for _, kv := range md.Configs {
+		if !inSpans(kv.Key, configKeySpans) {
+			return 0, util.Errorf("%q is not a config key", kv.Key)
+		}
+	}
+	for _, kv := range md.Generators {
+		if !inSpans(kv.Key, generatorKeySpans) || kv.Value.Integer == nil {
+			return 0, util.Errorf("%q is not an ID generator", kv.Key)
+		}
+	}
+	var ranges []proto.RangeDescriptor
+	for _, desc := range md.Ranges {
+		if !desc.StartKey.Less(desc.EndKey) {
+			return 0, util.Errorf("invalid range descriptor %q-%q", desc.StartKey, desc.EndKey)
+		}
+		if desc.StartKey.Less(engine.KeyMetaMax) {
+			log.Infof("not importing descriptor of range %q-%q holding addressing records", desc.StartKey, desc.EndKey)
+			continue
+		}
+		ranges = append(ranges, desc)
+	}
+
+	err := db.RunTransaction(&client.TransactionOptions{Name: "import metadata"}, func(txn *client.KV) error {
+		for _, kv := range md.Configs {
+			value := proto.Value{Bytes: kv.Value.Bytes, Integer: kv.Value.Integer}
+			value.InitChecksum(kv.Key)
+			if err := txn.Call(proto.Put, &proto.PutRequest{
+				RequestHeader: proto.RequestHeader{Key: kv.Key, User: storage.UserRoot},
+				Value:         value,
+			}, &proto.PutResponse{}); err != nil {
+				return err
+			}
+		}
+		for _, kv := range md.Generators {
+			gr := &proto.GetResponse{}
+			if err := txn.Call(proto.Get, &proto.GetRequest{
+				RequestHeader: proto.RequestHeader{Key: kv.Key, User: storage.UserRoot},
+			}, gr); err != nil {
+				return err
+			}
+			if gr.Value != nil && gr.Value.Integer != nil && *gr.Value.Integer >= *kv.Value.Integer {
+				continue
+			}
+			value := proto.Value{Integer: kv.Value.Integer}
+			value.InitChecksum(kv.Key)
+			if err := txn.Call(proto.Put, &proto.PutRequest{
+				RequestHeader: proto.RequestHeader{Key: kv.Key, User: storage.UserRoot},
+				Value:         value,
+			}, &proto.PutResponse{}); err != nil {
+				return err
+			}
+		}
+		for i := range ranges {
+			if err := txn.PutProto(engine.RangeMetaLookupKey(&ranges[i]), &ranges[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ranges), nil
+}
+
+// handleMetadata exports the cluster metadata as JSON on GET and
+// imports JSON-encoded metadata on PUT or POST, responding with a
+// summary of what was imported.
+func (s *adminServer) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	encodings := []util.EncodingType{util.JSONEncoding}
+	switch r.Method {
+	case "GET":
+		md, err := exportMetadata(s.db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, contentType, err := util.MarshalResponse(r, md, encodings)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	case "PUT", "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer r.Body.Close()
+		md := &clusterMetadata{}
+		if err := util.UnmarshalRequest(r, b, md, encodings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		count, err := importMetadata(s.db, md)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := s.audit.record(r.Header.Get(util.UserHeader), auditActionImportMetadata, "metadata", nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "imported %d config(s), %d ID generator(s) and %d range descriptor(s)\n",
+			len(md.Configs), len(md.Generators), count)
+	default:
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A CmdExportMetadata command exports cluster metadata to a file.
+var CmdExportMetadata = &commander.Command{
+	UsageLine: "export-metadata [options] <file>",
+	Short:     "exports configs and range metadata to a file",
+	Long: `
+Exports the cluster's accounting, permission and zone configs, schemas,
+cluster version, node, store, raft and range ID generators, and the
+descriptors of all ranges (as recorded in the meta2 addressing records)
+to <file> as JSON. The file may later be imported into a freshly
+bootstrapped cluster via import-metadata.
+`,
+	Run:  runExportMetadata,
+	Flag: *flag.CommandLine,
+}
+
+// runExportMetadata invokes the REST API with GET action and writes
+// the response body to the specified file.
+func runExportMetadata(cmd *commander.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		return
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", adminScheme(), *addr, metadataPath), nil)
+	if err != nil {
+		log.Errorf("unable to create request to admin REST endpoint: %s", err)
+		return
+	}
+	req.Header.Add("Accept", util.JSONContentType)
+	b, err := sendAdminRequest(req)
+	if err != nil {
+		log.Errorf("admin REST request failed: %s", err)
+		return
+	}
+	if err := ioutil.WriteFile(args[0], b, 0600); err != nil {
+		log.Errorf("unable to write metadata file %q: %s", args[0], err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "exported cluster metadata to %q\n", args[0])
+}
+
+// A CmdImportMetadata command imports cluster metadata from a file.
+var CmdImportMetadata = &commander.Command{
+	UsageLine: "import-metadata [options] <file>",
+	Short:     "imports configs and range metadata from a file",
+	Long: `
+Imports cluster metadata previously written by export-metadata from
+<file>. This is intended for recovery when the meta ranges of a
+cluster have been lost: bootstrap a new cluster, import the metadata,
+then start nodes with the stores holding the surviving replicas.
+
+Configs overwrite those of the cluster. ID generators are only ever
+advanced, so IDs allocated by the exported cluster aren't reused.
+Range descriptors are written to the meta2 addressing records, except
+for those of ranges holding addressing records themselves, which are
+served by the new cluster's first range.
+`,
+	Run:  runImportMetadata,
+	Flag: *flag.CommandLine,
+}
+
+// runImportMetadata invokes the REST API with POST action and the
+// contents of the specified file as body.
+func runImportMetadata(cmd *commander.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		return
+	}
+	body, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		log.Errorf("unable to read metadata file %q: %s", args[0], err)
+		return
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s", adminScheme(), *addr, metadataPath), bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("unable to create request to admin REST endpoint: %s", err)
+		return
+	}
+	req.Header.Add("Content-Type", util.JSONContentType)
+	b, err := sendAdminRequest(req)
+	if err != nil {
+		log.Errorf("admin REST request failed: %s", err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%s", b)
+}
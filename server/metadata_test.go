@@ -0,0 +1,132 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestExportImportMetadata verifies that configs, ID generators and
+// range descriptors exported from one cluster are imported into
+// another, that generators aren't moved backwards, and that
+// descriptors of ranges holding addressing records are skipped.
+func TestExportImportMetadata(t *testing.T) {
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	src, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20), stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := BootstrapCluster("cluster-2", engine.NewInMem(proto.Attributes{}, 1<<20), stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	increment := func(db *client.KV, key proto.Key, inc int64) int64 {
+		ir := &proto.IncrementResponse{}
+		if err := db.Call(proto.Increment, &proto.IncrementRequest{
+			RequestHeader: proto.RequestHeader{Key: key, User: storage.UserRoot},
+			Increment:     inc,
+		}, ir); err != nil {
+			t.Fatal(err)
+		}
+		return ir.NewValue
+	}
+
+	zoneKey := engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key("db1"))
+	zone := &proto.ZoneConfig{RangeMinBytes: 1 << 10, RangeMaxBytes: 1 << 20}
+	if err := src.PutProto(zoneKey, zone); err != nil {
+		t.Fatal(err)
+	}
+	nodeID := increment(src, engine.KeyNodeIDGenerator, 10)
+	rangeID := increment(dst, engine.KeyRangeIDGenerator, 100)
+	desc := &proto.RangeDescriptor{
+		RaftID:   5,
+		StartKey: proto.Key("m"),
+		EndKey:   proto.Key("z"),
+		Replicas: []proto.Replica{{NodeID: 2, StoreID: 2, RangeID: 5}},
+	}
+	if err := src.PutProto(engine.RangeMetaLookupKey(desc), desc); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := exportMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(md.Ranges) != 2 {
+		t.Fatalf("expected 2 range descriptors; got %+v", md.Ranges)
+	}
+	// Round trip through the export format.
+	b, err := json.Marshal(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md = &clusterMetadata{}
+	if err := json.Unmarshal(b, md); err != nil {
+		t.Fatal(err)
+	}
+	count, err := importMetadata(dst, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 range descriptor imported; got %d", count)
+	}
+
+	importedZone := &proto.ZoneConfig{}
+	if ok, _, err := dst.GetProto(zoneKey, importedZone); err != nil || !ok {
+		t.Fatalf("expected zone config to be imported: %v", err)
+	}
+	if importedZone.RangeMaxBytes != zone.RangeMaxBytes {
+		t.Errorf("expected imported zone config %+v; got %+v", zone, importedZone)
+	}
+	if v := increment(dst, engine.KeyNodeIDGenerator, 0); v != nodeID {
+		t.Errorf("expected node ID generator at %d; got %d", nodeID, v)
+	}
+	if v := increment(dst, engine.KeyRangeIDGenerator, 0); v != rangeID {
+		t.Errorf("expected range ID generator to remain at %d; got %d", rangeID, v)
+	}
+	importedDesc := &proto.RangeDescriptor{}
+	if ok, _, err := dst.GetProto(engine.RangeMetaLookupKey(desc), importedDesc); err != nil || !ok {
+		t.Fatalf("expected range descriptor to be imported: %v", err)
+	}
+	if importedDesc.RaftID != desc.RaftID {
+		t.Errorf("expected imported descriptor %+v; got %+v", desc, importedDesc)
+	}
+	// The destination's own first range still addresses the meta ranges.
+	firstDesc := &proto.RangeDescriptor{}
+	if _, _, err := dst.GetProto(engine.MakeKey(engine.KeyMeta2Prefix, engine.KeyMax), firstDesc); err != nil {
+		t.Fatal(err)
+	}
+	if len(firstDesc.Replicas) != 1 || firstDesc.Replicas[0].StoreID != 1 {
+		t.Errorf("unexpected first range descriptor %+v", firstDesc)
+	}
+
+	// Keys outside the exported spans are refused.
+	md.Configs = append(md.Configs, proto.KeyValue{Key: engine.KeyAuditLogHead})
+	if _, err := importMetadata(dst, md); err == nil {
+		t.Error("expected import of a non-config key to fail")
+	}
+}
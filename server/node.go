@@ -23,6 +23,7 @@ import (
 	"strconv"
 	"time"
 
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
 	"github.com/cockroachdb/cockroach/kv"
@@ -33,18 +34,23 @@ import (
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 )
 
 const (
 	// gossipGroupLimit is the size limit for gossip groups with storage
 	// topics.
 	gossipGroupLimit = 100
-	// gossipInterval is the interval for gossiping storage-related info.
-	gossipInterval = 1 * time.Minute
+	// gossipInterval is the interval at which stores check whether
+	// their descriptors are due to be gossiped.
+	gossipInterval = 10 * time.Second
 	// ttlCapacityGossip is time-to-live for capacity-related info.
 	ttlCapacityGossip = 2 * time.Minute
 	// ttlNodeIDGossip is time-to-live for node ID -> address.
 	ttlNodeIDGossip = 0 * time.Second
+	// versionRefreshInterval is the interval at which the node
+	// refreshes the active cluster version from gossip.
+	versionRefreshInterval = 10 * time.Second
 )
 
 // A Node manages a map of stores (by store ID) for which it serves
@@ -62,7 +68,10 @@ type Node struct {
 	gossip     *gossip.Gossip         // Nodes gossip cluster ID, node ID -> host:port
 	db         *client.KV             // KV DB client; used to access global id generators
 	lSender    *kv.LocalSender        // Local KV sender for access to node-local stores
-	closer     chan struct{}
+	registry   *metric.Registry       // Metrics for node-local stores
+	version    proto.VersionGate      // Active cluster version; gates commands
+	audit      *auditLog              // Records admin commands
+	load       *storage.LoadMonitor   // Samples resource usage for gossip
 
 	maxAvailPrefix string // Prefix for max avail capacity gossip topic
 }
@@ -105,8 +114,8 @@ func allocateStoreIDs(nodeID int32, inc int64, db *client.KV) (int32, error) {
 // all keys. Initial range lookup metadata is populated for the range.
 //
 // Returns a KV client for unittest purposes. Caller should close
-// the returned client.
-func BootstrapCluster(clusterID string, eng engine.Engine) (*client.KV, error) {
+// the returned client and stop the supplied stopper.
+func BootstrapCluster(clusterID string, eng engine.Engine, stopper *util.Stopper) (*client.KV, error) {
 	sIdent := proto.StoreIdent{
 		ClusterID: clusterID,
 		NodeID:    1,
@@ -115,8 +124,8 @@ func BootstrapCluster(clusterID string, eng engine.Engine) (*client.KV, error) {
 	clock := hlc.NewClock(hlc.UnixNano)
 	// Create a KV DB with a local sender.
 	lSender := kv.NewLocalSender()
-	localDB := client.NewKV(kv.NewCoordinator(lSender, clock), nil)
-	s := storage.NewStore(clock, eng, localDB, nil)
+	localDB := client.NewKV(kv.NewCoordinator(lSender, clock, stopper), nil)
+	s := storage.NewStore(clock, eng, localDB, nil, stopper)
 
 	// Verify the store isn't already part of a cluster.
 	if len(s.Ident.ClusterID) > 0 {
@@ -146,6 +155,17 @@ func BootstrapCluster(clusterID string, eng engine.Engine) (*client.KV, error) {
 			sIdent.StoreID, storeID, err)
 	}
 
+	// New clusters start at the newest version supported by this binary.
+	if err := localDB.Call(proto.Put, &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:  engine.KeyClusterVersion,
+			User: storage.UserRoot,
+		},
+		Value: proto.Value{Integer: gogoproto.Int64(int64(proto.CurrentVersion))},
+	}, &proto.PutResponse{}); err != nil {
+		return nil, util.Errorf("unable to initialize cluster version: %v", err)
+	}
+
 	return localDB, nil
 }
 
@@ -154,59 +174,97 @@ func BootstrapCluster(clusterID string, eng engine.Engine) (*client.KV, error) {
 // Stores. Registers the storage instance for the RPC service "Node".
 func NewNode(db *client.KV, gossip *gossip.Gossip) *Node {
 	n := &Node{
-		gossip:  gossip,
-		db:      db,
-		lSender: kv.NewLocalSender(),
-		closer:  make(chan struct{}),
+		gossip:   gossip,
+		db:       db,
+		lSender:  kv.NewLocalSender(),
+		registry: metric.NewRegistry(),
+		audit:    newAuditLog(db),
+		load:     storage.NewLoadMonitor(),
 	}
 	return n
 }
 
+// addStore adds the store to the local sender and links the store's
+// metrics into the node's registry. Store metrics are exported with
+// a per-store label.
+func (n *Node) addStore(s *storage.Store) {
+	n.lSender.AddStore(s)
+	storeID := strconv.Itoa(int(s.Ident.StoreID))
+	if err := n.registry.AddLabeled("store."+storeID+".", "store.", s.Registry(),
+		metric.Label{Name: "store", Value: storeID}); err != nil {
+		log.Fatal(err)
+	}
+}
+
 // initDescriptor initializes the physical/network topology attributes
 // if possible. Datacenter, PDU & Rack values are taken from environment
 // variables or command line flags.
-func (n *Node) initDescriptor(addr net.Addr, attrs proto.Attributes) {
+func (n *Node) initDescriptor(addr net.Addr, attrs proto.Attributes, locality proto.Locality) {
 	n.Descriptor = storage.NodeDescriptor{
 		// NodeID is after invocation of start()
-		Address: addr,
-		Attrs:   attrs,
+		Address:  addr,
+		Attrs:    attrs,
+		Locality: locality,
 	}
 }
 
 // start starts the node by initializing network/physical topology
 // attributes gleaned from the environment and initializing stores
 // for each specified engine. Launches periodic store gossipping
-// in a goroutine.
-func (n *Node) start(rpcServer *rpc.Server, clock *hlc.Clock,
-	engines []engine.Engine, attrs proto.Attributes) error {
-	n.initDescriptor(rpcServer.Addr(), attrs)
+// as a worker on the supplied stopper.
+func (n *Node) start(rpcServer *rpc.Server, clock *hlc.Clock, engines []engine.Engine,
+	attrs proto.Attributes, locality proto.Locality, stopper *util.Stopper) error {
+	n.initDescriptor(rpcServer.Addr(), attrs, locality)
 	rpcServer.RegisterName("Node", n)
+	// Stores find allocation targets among the gossiped descriptors
+	// of the group, which retains those with the most available
+	// capacity.
+	if err := n.gossip.RegisterGroup(gossip.KeyStoreDescriptorPrefix, gossipGroupLimit, gossip.MaxGroup); err != nil {
+		return err
+	}
+	// Gateways update their range descriptor caches from the most
+	// recent range events.
+	if err := n.gossip.RegisterGroup(gossip.KeyRangeEventPrefix, gossipGroupLimit, gossip.MaxGroup); err != nil {
+		return err
+	}
 
 	// Initialize stores, including bootstrapping new ones.
-	if err := n.initStores(clock, engines); err != nil {
+	if err := n.initStores(clock, engines, stopper); err != nil {
 		return err
 	}
-	go n.startGossip()
-	log.Infof("Started node with %v engine(s) and attributes %v", engines, attrs)
+	n.startGossip(stopper)
+	log.Infof("Started node with %v engine(s), attributes %v and locality %s", engines, attrs, locality)
 	return nil
 }
 
-// stop cleanly stops the node.
-func (n *Node) stop() {
-	close(n.closer)
-}
-
 // initStores initializes the Stores map from id to Store. Stores are
 // added to the local sender if already bootstrapped. A bootstrapped
 // Store has a valid ident with cluster, node and Store IDs set. If
 // the Store doesn't yet have a valid ident, it's added to the
 // bootstraps list for initialization once the cluster and node IDs
 // have been determined.
-func (n *Node) initStores(clock *hlc.Clock, engines []engine.Engine) error {
+func (n *Node) initStores(clock *hlc.Clock, engines []engine.Engine, stopper *util.Stopper) error {
 	bootstraps := list.New()
 
+	// Snapshot budgets are shared by the node's stores.
+	sendBudget := storage.NewSnapshotBudget(*snapshotSendBudget)
+	receiveBudget := storage.NewSnapshotBudget(*snapshotReceiveBudget)
 	for _, e := range engines {
-		s := storage.NewStore(clock, e, n.db, n.gossip)
+		s := storage.NewStore(clock, e, n.db, n.gossip, stopper)
+		s.SetUnavailableThreshold(*rangeUnavailableThreshold)
+		s.SetSnapshotLimits(*maxSnapshots, *snapshotRate)
+		s.SetSnapshotBudgets(sendBudget, receiveBudget)
+		s.SetGossipThresholds(storage.GossipThresholds{
+			CapacityFraction: *gossipCapacityFraction,
+			RangeCount:       *gossipRangeCount,
+			MaxInterval:      *gossipMaxInterval,
+		})
+		s.SetLoadThresholds(storage.LoadThresholds{
+			CPU:        *loadCPUThreshold,
+			Memory:     *loadMemoryThreshold,
+			DiskIOPS:   *loadDiskIOPSThreshold,
+			Goroutines: *loadGoroutineThreshold,
+		})
 		// Initialize each store in turn, handling un-bootstrapped errors by
 		// adding the store to the bootstraps list.
 		if err := s.Init(); err != nil {
@@ -225,7 +283,7 @@ func (n *Node) initStores(clock *hlc.Clock, engines []engine.Engine) error {
 				return err
 			}
 			log.Infof("initialized store %s: %+v", s, capacity)
-			n.lSender.AddStore(s)
+			n.addStore(s)
 		}
 	}
 
@@ -303,7 +361,7 @@ func (n *Node) bootstrapStores(bootstraps *list.List) {
 	for e := bootstraps.Front(); e != nil; e = e.Next() {
 		s := e.Value.(*storage.Store)
 		s.Bootstrap(sIdent)
-		n.lSender.AddStore(s)
+		n.addStore(s)
 		sIdent.StoreID++
 		log.Infof("bootstrapped store %s", s)
 	}
@@ -331,6 +389,15 @@ func (n *Node) connectGossip() {
 	}
 	log.Infof("node connected via gossip and verified as part of cluster %q", gossipClusterID)
 
+	// Verify this node's binary supports the active cluster version.
+	version := n.gossipClusterVersion()
+	if version < proto.MinSupportedVersion || version > proto.CurrentVersion {
+		log.Fatalf("node %d supports cluster versions %d through %d but cluster %q is at version %d",
+			n.Descriptor.NodeID, proto.MinSupportedVersion, proto.CurrentVersion, gossipClusterID, version)
+	}
+	n.version.SetActive(version)
+	log.Infof("node joined cluster %q at version %d", gossipClusterID, version)
+
 	// Gossip node address keyed by node ID.
 	if n.Descriptor.NodeID != 0 {
 		nodeIDKey := gossip.MakeNodeIDGossipKey(n.Descriptor.NodeID)
@@ -341,43 +408,90 @@ func (n *Node) connectGossip() {
 }
 
 // startGossip loops on a periodic ticker to gossip node-related
-// information. Loops until the node is closed and should be
-// invoked via goroutine.
-func (n *Node) startGossip() {
-	ticker := time.NewTicker(gossipInterval)
-	for {
-		select {
-		case <-ticker.C:
-			n.gossipCapacities()
-		case <-n.closer:
-			ticker.Stop()
-			return
+// information and to refresh the active cluster version. Starts a
+// worker on the supplied stopper which loops until the stopper is
+// stopped.
+func (n *Node) startGossip(stopper *util.Stopper) {
+	stopper.RunWorker(func() {
+		ticker := time.NewTicker(gossipInterval)
+		defer ticker.Stop()
+		versionTicker := time.NewTicker(versionRefreshInterval)
+		defer versionTicker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n.gossipCapacities()
+			case <-versionTicker.C:
+				n.refreshClusterVersion()
+			case <-stopper.ShouldStop():
+				return
+			}
 		}
+	})
+}
+
+// gossipClusterVersion returns the cluster version from gossip, or
+// proto.VersionBase if none is gossiped, as is the case while the
+// cluster version key's range is led by a node whose binary predates
+// cluster versions.
+func (n *Node) gossipClusterVersion() proto.ClusterVersion {
+	val, err := n.gossip.GetInfo(gossip.KeyClusterVersion)
+	if err != nil || val == nil {
+		return proto.VersionBase
+	}
+	return proto.ClusterVersion(val.(int64))
+}
+
+// refreshClusterVersion updates the node's active cluster version
+// from gossip. Versions newer than this node's binary supports are
+// not activated.
+func (n *Node) refreshClusterVersion() {
+	version := n.gossipClusterVersion()
+	if version > proto.CurrentVersion {
+		log.Errorf("cluster version %d exceeds newest version %d supported by node %d; upgrade its binary",
+			version, proto.CurrentVersion, n.Descriptor.NodeID)
+		version = proto.CurrentVersion
 	}
+	n.version.SetActive(version)
 }
 
-// gossipCapacities calls capacity on each store and adds it to the
-// gossip network.
+// gossipCapacities adds the descriptor of each store which is due to
+// be gossiped to the gossip network, along with the node's current
+// load.
 func (n *Node) gossipCapacities() {
+	now := time.Now()
+	nodeDesc := n.Descriptor
+	nodeDesc.Load = n.load.Sample(now)
 	n.lSender.VisitStores(func(s *storage.Store) error {
-		storeDesc, err := s.Descriptor(&n.Descriptor)
+		storeDesc, err := s.GossipDescriptor(&nodeDesc, now)
 		if err != nil {
 			log.Warningf("problem getting store descriptor for store %+v: %v", s.Ident, err)
 			return nil
 		}
+		if storeDesc == nil {
+			return nil
+		}
 		gossipPrefix := gossip.KeyMaxAvailCapacityPrefix + storeDesc.CombinedAttrs().SortedString()
 		keyMaxCapacity := gossipPrefix + strconv.FormatInt(int64(storeDesc.Node.NodeID), 10) + "-" +
 			strconv.FormatInt(int64(storeDesc.StoreID), 10)
 		// Register gossip group.
 		n.gossip.RegisterGroup(gossipPrefix, gossipGroupLimit, gossip.MaxGroup)
-		// Gossip store descriptor.
+		// Gossip store descriptor, both by available capacity and by
+		// store ID, the latter for the lease rebalancers of other stores.
 		n.gossip.AddInfo(keyMaxCapacity, *storeDesc, ttlCapacityGossip)
+		n.gossip.AddInfo(gossip.MakeStoreDescriptorGossipKey(storeDesc.StoreID), *storeDesc, ttlCapacityGossip)
 		return nil
 	})
 }
 
-// executeCmd creates a client.Call struct and sends if via our local sender.
+// executeCmd creates a client.Call struct and sends if via our local
+// sender. Commands requiring a newer cluster version than the active
+// one fail with an UnsupportedVersionError.
 func (n *Node) executeCmd(method string, args proto.Request, reply proto.Response) error {
+	if err := n.version.CheckMethod(method); err != nil {
+		reply.Header().SetGoError(err)
+		return nil
+	}
 	call := &client.Call{
 		Method: method,
 		Args:   args,
@@ -419,6 +533,16 @@ func (n *Node) Delete(args *proto.DeleteRequest, reply *proto.DeleteResponse) er
 	return n.executeCmd(proto.Delete, args, reply)
 }
 
+// ConditionalDelete .
+func (n *Node) ConditionalDelete(args *proto.ConditionalDeleteRequest, reply *proto.ConditionalDeleteResponse) error {
+	return n.executeCmd(proto.ConditionalDelete, args, reply)
+}
+
+// CompareAndSwap .
+func (n *Node) CompareAndSwap(args *proto.CompareAndSwapRequest, reply *proto.CompareAndSwapResponse) error {
+	return n.executeCmd(proto.CompareAndSwap, args, reply)
+}
+
 // DeleteRange .
 func (n *Node) DeleteRange(args *proto.DeleteRangeRequest, reply *proto.DeleteRangeResponse) error {
 	return n.executeCmd(proto.DeleteRange, args, reply)
@@ -426,7 +550,11 @@ func (n *Node) DeleteRange(args *proto.DeleteRangeRequest, reply *proto.DeleteRa
 
 // Scan .
 func (n *Node) Scan(args *proto.ScanRequest, reply *proto.ScanResponse) error {
-	return n.executeCmd(proto.Scan, args, reply)
+	err := n.executeCmd(proto.Scan, args, reply)
+	if args.CompressKeys {
+		reply.CompressKeys()
+	}
+	return err
 }
 
 // BeginTransaction .
@@ -459,9 +587,52 @@ func (n *Node) EnqueueMessage(args *proto.EnqueueMessageRequest, reply *proto.En
 	return n.executeCmd(proto.EnqueueMessage, args, reply)
 }
 
-// AdminSplit .
+// AcquireLock .
+func (n *Node) AcquireLock(args *proto.AcquireLockRequest, reply *proto.AcquireLockResponse) error {
+	return n.executeCmd(proto.AcquireLock, args, reply)
+}
+
+// AdminSplit . Successful splits are recorded to the audit log.
 func (n *Node) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSplitResponse) error {
-	return n.executeCmd(proto.AdminSplit, args, reply)
+	if err := n.executeCmd(proto.AdminSplit, args, reply); err != nil || reply.GoError() != nil {
+		return err
+	}
+	if err := n.audit.record(args.User, auditActionAdminSplit, args.Key.String(), args.SplitKey); err != nil {
+		reply.SetGoError(err)
+	}
+	return nil
+}
+
+// AdminRecoverReplicas . Recoveries are recorded to the audit log
+// along with the rewritten range descriptor.
+func (n *Node) AdminRecoverReplicas(args *proto.AdminRecoverReplicasRequest, reply *proto.AdminRecoverReplicasResponse) error {
+	if err := n.executeCmd(proto.AdminRecoverReplicas, args, reply); err != nil || reply.GoError() != nil {
+		return err
+	}
+	details, err := gogoproto.Marshal(&reply.Desc)
+	if err == nil {
+		err = n.audit.record(args.User, auditActionRecoverReplicas, args.Key.String(), details)
+	}
+	if err != nil {
+		reply.SetGoError(err)
+	}
+	return nil
+}
+
+// AdminTransferLease . Transfers are recorded to the audit log along
+// with the granted lease.
+func (n *Node) AdminTransferLease(args *proto.AdminTransferLeaseRequest, reply *proto.AdminTransferLeaseResponse) error {
+	if err := n.executeCmd(proto.AdminTransferLease, args, reply); err != nil || reply.GoError() != nil {
+		return err
+	}
+	details, err := gogoproto.Marshal(&reply.Lease)
+	if err == nil {
+		err = n.audit.record(args.User, auditActionTransferLease, args.Key.String(), details)
+	}
+	if err != nil {
+		reply.SetGoError(err)
+	}
+	return nil
 }
 
 // InternalRangeLookup .
@@ -484,6 +655,31 @@ func (n *Node) InternalPushTxn(args *proto.InternalPushTxnRequest, reply *proto.
 	return n.executeCmd(proto.InternalPushTxn, args, reply)
 }
 
+// InternalExecute .
+func (n *Node) InternalExecute(args *proto.InternalExecuteRequest, reply *proto.InternalExecuteResponse) error {
+	return n.executeCmd(proto.InternalExecute, args, reply)
+}
+
+// InternalRefresh .
+func (n *Node) InternalRefresh(args *proto.InternalRefreshRequest, reply *proto.InternalRefreshResponse) error {
+	return n.executeCmd(proto.InternalRefresh, args, reply)
+}
+
+// InternalGC .
+func (n *Node) InternalGC(args *proto.InternalGCRequest, reply *proto.InternalGCResponse) error {
+	return n.executeCmd(proto.InternalGC, args, reply)
+}
+
+// InternalScanIntents .
+func (n *Node) InternalScanIntents(args *proto.InternalScanIntentsRequest, reply *proto.InternalScanIntentsResponse) error {
+	return n.executeCmd(proto.InternalScanIntents, args, reply)
+}
+
+// InternalRangeStats .
+func (n *Node) InternalRangeStats(args *proto.InternalRangeStatsRequest, reply *proto.InternalRangeStatsResponse) error {
+	return n.executeCmd(proto.InternalRangeStats, args, reply)
+}
+
 // InternalResolveIntent .
 func (n *Node) InternalResolveIntent(args *proto.InternalResolveIntentRequest, reply *proto.InternalResolveIntentResponse) error {
 	return n.executeCmd(proto.InternalResolveIntent, args, reply)
@@ -493,3 +689,9 @@ func (n *Node) InternalResolveIntent(args *proto.InternalResolveIntentRequest, r
 func (n *Node) InternalSnapshotCopy(args *proto.InternalSnapshotCopyRequest, reply *proto.InternalSnapshotCopyResponse) error {
 	return n.executeCmd(proto.InternalSnapshotCopy, args, reply)
 }
+
+// InternalReserveSnapshot . The request must address the reserving
+// store via args.Replica, as the store holds no replica of the range.
+func (n *Node) InternalReserveSnapshot(args *proto.InternalReserveSnapshotRequest, reply *proto.InternalReserveSnapshotResponse) error {
+	return n.executeCmd(proto.InternalReserveSnapshot, args, reply)
+}
@@ -40,16 +40,18 @@ import (
 // createTestNode creates an rpc server using the specified address,
 // gossip instance, KV database and a node using the specified slice
 // of engines. The server and node are returned. If gossipBS is not
-// nil, the gossip bootstrap address is set to gossipBS.
+// nil, the gossip bootstrap address is set to gossipBS. The server
+// and node are stopped via the returned stopper.
 func createTestNode(addr net.Addr, engines []engine.Engine, gossipBS net.Addr, t *testing.T) (
-	*rpc.Server, *Node) {
+	*rpc.Server, *Node, *util.Stopper) {
 	tlsConfig, err := rpc.LoadTestTLSConfig("..")
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	stopper := util.NewStopper()
 	clock := hlc.NewClock(hlc.UnixNano)
-	rpcContext := rpc.NewContext(clock, tlsConfig)
+	rpcContext := rpc.NewContext(clock, tlsConfig, stopper)
 	rpcServer := rpc.NewServer(addr, rpcContext)
 	if err := rpcServer.Start(); err != nil {
 		t.Fatal(err)
@@ -61,14 +63,14 @@ func createTestNode(addr net.Addr, engines []engine.Engine, gossipBS net.Addr, t
 			gossipBS = rpcServer.Addr()
 		}
 		g.SetBootstrap([]net.Addr{gossipBS})
-		g.Start(rpcServer)
+		g.Start(rpcServer, stopper)
 	}
 	db := client.NewKV(kv.NewDistSender(g), nil)
 	node := NewNode(db, g)
-	if err := node.start(rpcServer, clock, engines, proto.Attributes{}); err != nil {
+	if err := node.start(rpcServer, clock, engines, proto.Attributes{}, proto.Locality{}, stopper); err != nil {
 		t.Fatal(err)
 	}
-	return rpcServer, node
+	return rpcServer, node, stopper
 }
 
 func formatKeys(keys []proto.Key) string {
@@ -83,10 +85,12 @@ func formatKeys(keys []proto.Key) string {
 // cluster. Uses an in memory engine.
 func TestBootstrapCluster(t *testing.T) {
 	e := engine.NewInMem(proto.Attributes{}, 1<<20)
-	localDB, err := BootstrapCluster("cluster-1", e)
+	stopper := util.NewStopper()
+	localDB, err := BootstrapCluster("cluster-1", e, stopper)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer stopper.Stop()
 	defer localDB.Close()
 
 	// Scan the complete contents of the local database.
@@ -109,6 +113,7 @@ func TestBootstrapCluster(t *testing.T) {
 		engine.MakeKey(proto.Key("\x00\x00meta1"), engine.KeyMax),
 		engine.MakeKey(proto.Key("\x00\x00meta2"), engine.KeyMax),
 		proto.Key("\x00acct"),
+		proto.Key("\x00cluster-version"),
 		proto.Key("\x00node-idgen"),
 		proto.Key("\x00perm"),
 		proto.Key("\x00store-idgen-1"),
@@ -126,11 +131,13 @@ func TestBootstrapCluster(t *testing.T) {
 // stores and verifies both stores are added.
 func TestBootstrapNewStore(t *testing.T) {
 	e := engine.NewInMem(proto.Attributes{}, 1<<20)
-	localDB, err := BootstrapCluster("cluster-1", e)
+	localStopper := util.NewStopper()
+	localDB, err := BootstrapCluster("cluster-1", e, localStopper)
 	if err != nil {
 		t.Fatal(err)
 	}
 	localDB.Close()
+	localStopper.Stop()
 
 	// Start a new node with two new stores which will require bootstrapping.
 	engines := []engine.Engine{
@@ -138,8 +145,8 @@ func TestBootstrapNewStore(t *testing.T) {
 		engine.NewInMem(proto.Attributes{}, 1<<20),
 		engine.NewInMem(proto.Attributes{}, 1<<20),
 	}
-	server, node := createTestNode(util.CreateTestAddr("tcp"), engines, nil, t)
-	defer server.Close()
+	_, node, stopper := createTestNode(util.CreateTestAddr("tcp"), engines, nil, t)
+	defer stopper.Stop()
 
 	// Non-initialized stores (in this case the new in-memory-based
 	// store) will be bootstrapped by the node upon start. This happens
@@ -154,24 +161,26 @@ func TestBootstrapNewStore(t *testing.T) {
 // cluster consisting of one node.
 func TestNodeJoin(t *testing.T) {
 	e := engine.NewInMem(proto.Attributes{}, 1<<20)
-	db, err := BootstrapCluster("cluster-1", e)
+	stopper := util.NewStopper()
+	db, err := BootstrapCluster("cluster-1", e, stopper)
 	if err != nil {
 		t.Fatal(err)
 	}
 	db.Close()
+	stopper.Stop()
 
 	// Set an aggressive gossip interval to make sure information is exchanged tout de suite.
 	*gossip.GossipInterval = 10 * time.Millisecond
 	// Start the bootstrap node.
 	engines1 := []engine.Engine{e}
 	addr1 := util.CreateTestAddr("tcp")
-	server1, node1 := createTestNode(addr1, engines1, addr1, t)
-	defer server1.Close()
+	server1, node1, stopper1 := createTestNode(addr1, engines1, addr1, t)
+	defer stopper1.Stop()
 
 	// Create a new node.
 	engines2 := []engine.Engine{engine.NewInMem(proto.Attributes{}, 1<<20)}
-	server2, node2 := createTestNode(util.CreateTestAddr("tcp"), engines2, server1.Addr(), t)
-	defer server2.Close()
+	server2, node2, stopper2 := createTestNode(util.CreateTestAddr("tcp"), engines2, server1.Addr(), t)
+	defer stopper2.Stop()
 
 	// Verify new node is able to bootstrap its store.
 	if err := util.IsTrueWithin(func() bool { return node2.lSender.GetStoreCount() == 1 }, 50*time.Millisecond); err != nil {
@@ -196,4 +205,80 @@ func TestNodeJoin(t *testing.T) {
 	}, 50*time.Millisecond); err != nil {
 		t.Error(err)
 	}
+
+	// Verify both nodes joined at the bootstrapped cluster version.
+	for i, n := range []*Node{node1, node2} {
+		if v := n.version.Active(); v != proto.CurrentVersion {
+			t.Errorf("node %d: expected active cluster version %d; got %d", i+1, proto.CurrentVersion, v)
+		}
+	}
+}
+
+// TestNodeVersionGate verifies that a node rejects commands requiring
+// a newer cluster version than the active one.
+func TestNodeVersionGate(t *testing.T) {
+	node := NewNode(nil, nil)
+	args := &proto.ConditionalDeleteRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a")}}
+	reply := &proto.ConditionalDeleteResponse{}
+	if err := node.ConditionalDelete(args, reply); err != nil {
+		t.Fatal(err)
+	}
+	if vErr, ok := reply.GoError().(*proto.UnsupportedVersionError); !ok {
+		t.Fatalf("expected unsupported version error; got %v", reply.GoError())
+	} else if vErr.Method != proto.ConditionalDelete || vErr.ActiveVersion != int64(proto.VersionBase) {
+		t.Errorf("unexpected error details: %+v", vErr)
+	}
+
+	// Once the version is active, the command is passed to the
+	// node's stores; none exist, so the command fails otherwise.
+	node.version.SetActive(proto.VersionConditionalDelete)
+	reply.Reset()
+	if err := node.ConditionalDelete(args, reply); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reply.GoError().(*proto.UnsupportedVersionError); ok {
+		t.Errorf("unexpected unsupported version error: %v", reply.GoError())
+	}
+}
+
+// TestSetClusterVersion verifies that the cluster version may only
+// be advanced to versions supported by the binary.
+func TestSetClusterVersion(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	localDB, err := BootstrapCluster("cluster-1", e, stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopper.Stop()
+	defer localDB.Close()
+
+	if v, err := engine.GetClusterVersion(e); err != nil || v != proto.CurrentVersion {
+		t.Fatalf("expected bootstrapped cluster version %d; got %d, %v", proto.CurrentVersion, v, err)
+	}
+	if err := SetClusterVersion(localDB, proto.CurrentVersion); err == nil {
+		t.Error("expected error setting the active cluster version")
+	}
+	if err := SetClusterVersion(localDB, proto.CurrentVersion+1); err == nil {
+		t.Error("expected error setting an unsupported cluster version")
+	}
+
+	// Simulate a cluster bootstrapped before cluster versions.
+	if err := localDB.Call(proto.Delete, &proto.DeleteRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:  engine.KeyClusterVersion,
+			User: storage.UserRoot,
+		},
+	}, &proto.DeleteResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := engine.GetClusterVersion(e); err != nil || v != proto.VersionBase {
+		t.Fatalf("expected cluster version %d; got %d, %v", proto.VersionBase, v, err)
+	}
+	if err := SetClusterVersion(localDB, proto.CurrentVersion); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := engine.GetClusterVersion(e); err != nil || v != proto.CurrentVersion {
+		t.Fatalf("expected cluster version %d; got %d, %v", proto.CurrentVersion, v, err)
+	}
 }
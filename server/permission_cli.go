@@ -108,6 +108,9 @@ The permission config format has the following YAML schema:
   	- user1
   	- user2
   	- ...
+  tags:
+    - tag1
+    - ...
 
 For example:
 
@@ -117,9 +120,12 @@ For example:
   write:
     - readWriteUser
     - WriteOnlyUser
+  tags:
+    - billingApp
 
 Setting permission configs will guarantee that users will have permissions for
-this key prefix and all sub prefixes of the one that is set
+this key prefix and all sub prefixes of the one that is set. Requests
+carrying an accounting tag are only permitted if the tag is listed.
 `,
 	Run:  runSetPerms,
 	Flag: *flag.CommandLine,
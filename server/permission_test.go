@@ -38,7 +38,8 @@ write: [readwrite, writeonly]
 // ExampleSetAndGetPerm sets perm configs for a variety of key
 // prefixes and verifies they can be fetched directly.
 func ExampleSetAndGetPerms() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testPermConfig)
 	defer os.Remove(testConfigFn)
@@ -100,7 +101,8 @@ func ExampleSetAndGetPerms() {
 // perm-ls works. First, no regexp lists all perm configs. Second,
 // regexp properly matches results.
 func ExampleLsPerms() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testPermConfig)
 	defer os.Remove(testConfigFn)
@@ -158,7 +160,8 @@ func ExampleLsPerms() {
 // have been removed via perm-ls. Also verify the default perm config
 // cannot be removed.
 func ExampleRmPerms() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testPermConfig)
 	defer os.Remove(testConfigFn)
@@ -191,7 +194,8 @@ func ExampleRmPerms() {
 // to control the format of the response and the Content-Type header
 // can be used to specify the format of the request.
 func ExamplePermContentTypes() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 
 	config := &proto.PermConfig{}
@@ -220,13 +224,13 @@ func ExamplePermContentTypes() {
 				fmt.Println(err)
 			}
 		}
-		req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s%s", adminScheme, *addr, permPathPrefix, key), bytes.NewReader(body))
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s%s", adminScheme(), *addr, permPathPrefix, key), bytes.NewReader(body))
 		req.Header.Add("Content-Type", test.contentType)
 		if _, err = sendAdminRequest(req); err != nil {
 			fmt.Println(err)
 		}
 
-		req, err = http.NewRequest("GET", fmt.Sprintf("%s://%s%s%s", adminScheme, *addr, permPathPrefix, key), nil)
+		req, err = http.NewRequest("GET", fmt.Sprintf("%s://%s%s%s", adminScheme(), *addr, permPathPrefix, key), nil)
 		req.Header.Add("Accept", test.accept)
 		if body, err = sendAdminRequest(req); err != nil {
 			fmt.Println(err)
@@ -0,0 +1,132 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/keys"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var (
+	lostStores = flag.String("lost_stores", "", "comma-separated list of the IDs of "+
+		"stores whose replicas have been permanently lost, for use with recover-range")
+	acknowledgeDataLoss = flag.Bool("acknowledge_data_loss", false, "acknowledge that "+
+		"recover-range may lose writes acknowledged only by the lost replicas")
+)
+
+// A CmdRecoverRange command recovers a range which has permanently
+// lost a majority of its replicas.
+var CmdRecoverRange = &commander.Command{
+	UsageLine: "recover-range -lost_stores=<id>[,...] -acknowledge_data_loss <key>",
+	Short:     "recovers a range from its surviving replicas",
+	Long: `
+Recovers the range containing <key> after a majority of its replicas
+have been permanently lost, such as through the failure of several
+nodes at once. Without a quorum, the range can't make progress and
+can't change its replicas by ordinary means.
+
+The range's descriptor is rewritten to a new replica set made up of
+the surviving replicas. The stores holding the lost replicas must be
+given via -lost_stores and must make up a majority of the range's
+replicas. The range is given a new Raft ID so that the lost replicas
+can't rejoin it, should their stores return. Replacements for the lost
+replicas must be added by ordinary replica changes once the range has
+recovered.
+
+THIS IS UNSAFE: writes acknowledged only by the lost replicas are
+lost. The -acknowledge_data_loss flag must be given. The key should be
+escaped via URL query escaping if it contains non-ascii bytes or
+spaces.
+
+To recover ranges on nodes started with certificates, specify the
+certificate directory via -certs; the command then connects over TLS
+and authenticates with the client certificate in that directory or
+with the token specified by -auth_token.
+`,
+	Run:  runRecoverRange,
+	Flag: *flag.CommandLine,
+}
+
+// parseStoreIDs parses a comma-separated list of store IDs.
+func parseStoreIDs(s string) ([]int32, error) {
+	var storeIDs []int32
+	for _, id := range strings.Split(s, ",") {
+		storeID, err := strconv.ParseInt(id, 10, 32)
+		if err != nil {
+			return nil, util.Errorf("invalid store ID %q: %s", id, err)
+		}
+		storeIDs = append(storeIDs, int32(storeID))
+	}
+	return storeIDs, nil
+}
+
+// runRecoverRange sends an AdminRecoverReplicas request for the range
+// containing the specified key and displays the rewritten descriptor.
+func runRecoverRange(cmd *commander.Command, args []string) {
+	if len(args) != 1 || len(*lostStores) == 0 {
+		cmd.Usage()
+		return
+	}
+	if !*acknowledgeDataLoss {
+		log.Errorf("recovery may lose data; specify -acknowledge_data_loss to proceed")
+		return
+	}
+	key, err := unescapePath(args[0], "")
+	if err != nil {
+		log.Errorf("unable to unescape key %q: %s", args[0], err)
+		return
+	}
+	lost, err := parseStoreIDs(*lostStores)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	transport, err := adminTransport()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	sender := client.NewHTTPSender(*addr, transport)
+	sender.SetAuthToken(*authToken)
+	kv := client.NewKV(sender, nil)
+	defer kv.Close()
+	reply := &proto.AdminRecoverReplicasResponse{}
+	if err := kv.Call(proto.AdminRecoverReplicas, &proto.AdminRecoverReplicasRequest{
+		RequestHeader:       proto.RequestHeader{Key: proto.Key(key)},
+		LostStoreIDs:        lost,
+		AcknowledgeDataLoss: true,
+	}, reply); err != nil {
+		log.Errorf("recovery of range containing %q failed: %s", key, err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "recovered range %s-%s with replicas:\n",
+		keys.PrettyPrint(reply.Desc.StartKey), keys.PrettyPrint(reply.Desc.EndKey))
+	for _, replica := range reply.Desc.Replicas {
+		fmt.Fprintf(os.Stdout, "  node %d, store %d, range %d\n", replica.NodeID, replica.StoreID, replica.RangeID)
+	}
+}
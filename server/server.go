@@ -20,6 +20,7 @@ package server
 
 import (
 	"compress/gzip"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
@@ -40,20 +41,45 @@ import (
 	"github.com/cockroachdb/cockroach/kv"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/sql"
+	"github.com/cockroachdb/cockroach/sql/pgwire"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/structured"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 )
 
 var (
 	rpcAddr  = flag.String("rpc", ":0", "host:port to bind for RPC traffic; 0 to pick unused port")
 	httpAddr = flag.String("http", ":8080", "host:port to bind for HTTP traffic; 0 to pick unused port")
 
+	// pgAddr enables the PostgreSQL wire protocol listener.
+	pgAddr = flag.String("pgwire", "", "host:port to bind for PostgreSQL wire "+
+		"protocol traffic; 0 to pick unused port. Postgres clients may connect to "+
+		"create, insert into and query tables. Connections are neither "+
+		"authenticated nor encrypted, so the listener may only be enabled "+
+		"without -certs. Disabled if empty.")
+
 	certDir = flag.String("certs", "", "directory containing RSA key and x509 certs")
 
+	// certReloadInterval is the interval at which the certificate
+	// directory is checked for modified certificates.
+	certReloadInterval = flag.Duration("cert_reload_interval", 1*time.Minute, "specify "+
+		"the interval at which the certificate directory is checked for "+
+		"modified files. Modified certificates and keys are reloaded without "+
+		"a restart, so that they may be rotated before they expire; they may "+
+		"also be reloaded with the reload-certs command. Specify 0 to disable.")
+
+	// authTokenKey enables authentication of HTTP requests by signed
+	// tokens in addition to client certificates.
+	authTokenKey = flag.String("auth_token_key", "", "specify a file containing "+
+		"the secret key used to sign auth tokens. If specified, HTTP requests may "+
+		"authenticate with a token minted by the token command in place of a "+
+		"client certificate. Every node should use the same key.")
+
 	// stores is specified to enable durable storage via RocksDB-backed
 	// key-value stores. Memory-backed key value stores may be
 	// optionally specified via mem=<integer byte size>.
@@ -65,6 +91,12 @@ var (
 		"attributes might also include speeds and other specs (7200rpm, 200kiops, etc.). "+
 		"For example, -store=hdd:7200rpm=/mnt/hda1,ssd=/mnt/ssd01,ssd=/mnt/ssd02,mem=1073741824")
 
+	// engineType specifies the engine implementation used for
+	// persistent stores.
+	engineType = flag.String("engine", "rocksdb", "specify the engine used for "+
+		"persistent stores: \"rocksdb\" or \"logdb\", a pure-Go engine which keeps "+
+		"all data in memory and persists it via an append-only log")
+
 	// attrs specifies node topography or machine capabilities, used to
 	// match capabilities or location preferences specified in zone configs.
 	attrs = flag.String("attrs", "", "specify a comma-separated list of node "+
@@ -74,12 +106,98 @@ var (
 		"might include specialized hardware or number of cores (e.g. \"gpu\", "+
 		"\"x16c\"). For example: -attrs=us-west-1b,gpu")
 
+	// locality specifies the location of the node, used to spread
+	// replicas across failure domains and to enforce the placement
+	// constraints of zone configs.
+	locality = flag.String("locality", "", "specify the location of the node "+
+		"as a comma-separated list of key=value tiers, ordered from most to "+
+		"least inclusive. Replicas are spread across nodes whose localities "+
+		"differ in the most inclusive tiers possible, and zone configs may "+
+		"constrain replica placement by tier. For example: "+
+		"-locality=region=us-east,az=us-east-1a,rack=12")
+
 	maxOffset = flag.Duration("max_offset", 250*time.Millisecond, "specify "+
 		"the maximum clock offset for the cluster. Clock offset is measured on all "+
 		"node-to-node links and if any node notices it has clock offset in excess "+
 		"of -max_drift, it will commit suicide. Setting this value too high may "+
 		"decrease transaction performance in the presence of contention.")
 
+	// gatewayMemory bounds the memory buffered by key-value requests
+	// in flight at this node.
+	gatewayMemory = flag.Int64("gateway_memory", 512<<20, "specify the maximum "+
+		"number of bytes which may be buffered at once by key-value requests "+
+		"served by this node, including request bodies and scan results. "+
+		"Requests which would exceed the budget fail with a "+
+		"MemoryBudgetExceededError. Specify 0 for no limit.")
+
+	hedgeReadPercentile = flag.Float64("hedge_read_percentile", 0, "specify "+
+		"the percentile of recent read latencies after which reads which may be "+
+		"served by any replica (reads with a maximum staleness) are also sent to "+
+		"another replica, taking the first reply. Hedging cuts tail latency "+
+		"caused by slow nodes at the cost of extra load. Specify 0 to disable.")
+
+	rangeUnavailableThreshold = flag.Duration("range_unavailable_threshold",
+		storage.DefaultUnavailableThreshold, "specify the duration for which "+
+			"a write may await application before its range is considered "+
+			"unavailable, after which further writes to the range fail fast "+
+			"with a RangeUnavailableError until it recovers. Specify 0 to "+
+			"queue writes indefinitely.")
+
+	maxSnapshots = flag.Int("max_snapshots", 2, "specify the maximum number "+
+		"of outgoing range snapshots each store serves concurrently. Further "+
+		"snapshots queue for a free slot. Specify 0 for no limit.")
+
+	snapshotRate = flag.Int64("snapshot_rate", 8<<20, "specify the maximum "+
+		"bandwidth in bytes per second consumed by each outgoing range "+
+		"snapshot, bounding the disk and network load of rebalancing. "+
+		"Specify 0 for no limit.")
+
+	snapshotSendBudget = flag.Int64("snapshot_send_budget", 256<<20, "specify "+
+		"the maximum bytes of range snapshots each node sends concurrently, "+
+		"across its stores. Snapshots beyond the budget are refused so that "+
+		"their recipients fetch them from other replicas. Specify 0 for no limit.")
+
+	snapshotReceiveBudget = flag.Int64("snapshot_receive_budget", 256<<20, "specify "+
+		"the maximum bytes of range snapshots each node receives concurrently, "+
+		"across its stores, as reserved via InternalReserveSnapshot ahead of "+
+		"each snapshot. Specify 0 for no limit.")
+
+	gossipCapacityFraction = flag.Float64("gossip_capacity_fraction",
+		storage.DefaultGossipThresholds.CapacityFraction, "specify the fraction "+
+			"of a store's capacity which must be written or reclaimed before the "+
+			"store's descriptor is gossiped ahead of --gossip_max_interval.")
+
+	gossipRangeCount = flag.Int("gossip_range_count",
+		storage.DefaultGossipThresholds.RangeCount, "specify the change in a "+
+			"store's range count which causes the store's descriptor to be "+
+			"gossiped ahead of --gossip_max_interval.")
+
+	gossipMaxInterval = flag.Duration("gossip_max_interval",
+		storage.DefaultGossipThresholds.MaxInterval, "specify the maximum "+
+			"interval between gossips of a store's descriptor. Must be less than "+
+			"the descriptor's gossip TTL of 2m.")
+
+	loadCPUThreshold = flag.Float64("load_cpu_threshold",
+		storage.DefaultLoadThresholds.CPU, "specify the fraction of the "+
+			"machine's CPU time used by the node beyond which the node is "+
+			"considered overloaded. Stores on overloaded nodes are avoided when "+
+			"allocating replicas. Specify 0 to ignore CPU usage.")
+
+	loadMemoryThreshold = flag.Float64("load_memory_threshold",
+		storage.DefaultLoadThresholds.Memory, "specify the fraction of the "+
+			"machine's memory obtained by the node beyond which the node is "+
+			"considered overloaded. Specify 0 to ignore memory usage.")
+
+	loadDiskIOPSThreshold = flag.Float64("load_disk_iops_threshold",
+		storage.DefaultLoadThresholds.DiskIOPS, "specify the disk reads and "+
+			"writes per second across the machine's disks beyond which the node "+
+			"is considered overloaded. Specify 0 to ignore disk usage.")
+
+	loadGoroutineThreshold = flag.Int("load_goroutine_threshold",
+		storage.DefaultLoadThresholds.Goroutines, "specify the number of "+
+			"goroutines beyond which the node is considered overloaded. Specify "+
+			"0 to ignore the goroutine count.")
+
 	bootstrapOnly = flag.Bool("bootstrap_only", false, "specify --bootstrap_only "+
 		"to avoid starting the server after bootstrapping with the init command.")
 
@@ -107,7 +225,11 @@ A node exports an HTTP API with the following endpoints:
 
   Health check:           /healthz
   Key-value REST:         ` + kv.RESTPrefix + `
-  Structured Schema REST: ` + structured.StructuredKeyPrefix
+  Structured Schema REST: ` + structured.StructuredKeyPrefix + `
+  SQL statements:         ` + sql.Endpoint + `
+
+With -pgwire, a node also serves the PostgreSQL wire protocol, so
+Postgres clients may create, insert into and query tables.`
 
 // A CmdInit command initializes a new Cockroach cluster.
 var CmdInit = &commander.Command{
@@ -151,13 +273,15 @@ func runInit(cmd *commander.Command, args []string) {
 	}
 	// Generate a new UUID for cluster ID and bootstrap the cluster.
 	clusterID := uuid.New()
-	localDB, err := BootstrapCluster(clusterID, e)
+	stopper := util.NewStopper()
+	localDB, err := BootstrapCluster(clusterID, e, stopper)
 	if err != nil {
 		log.Errorf("Failed to bootstrap cluster: %v", err)
 		return
 	}
 	// Close localDB and bootstrap engine.
 	localDB.Close()
+	stopper.Stop()
 	e.Stop()
 
 	fmt.Printf("Cockroach cluster %s has been initialized\n", clusterID)
@@ -187,13 +311,21 @@ type server struct {
 	gossip         *gossip.Gossip
 	kv             *client.KV
 	kvDB           *kv.DBServer
+	gatewayBudget  *kv.MemoryBudget
 	kvREST         *kv.RESTServer
 	node           *Node
 	admin          *adminServer
 	status         *statusServer
 	structuredDB   structured.DB
 	structuredREST *structured.RESTServer
+	sqlExecutor    *sql.Executor
+	sqlServer      *sql.HTTPServer
+	pgServer       *pgwire.Server // nil if the pgwire listener is disabled
+	tlsConfig      *rpc.TLSConfig
+	authTokenKey   []byte        // nil if auth tokens are disabled
 	httpListener   *net.Listener // holds http endpoint information
+	registry       *metric.Registry
+	stopper        *util.Stopper
 }
 
 // runStart starts the cockroach node using -stores as the list of
@@ -202,7 +334,9 @@ type server struct {
 // cluster via the gossip network.
 func runStart(cmd *commander.Command, args []string) {
 	log.Info("Starting cockroach cluster")
-	s, err := newServer(*rpcAddr, *certDir, *maxOffset)
+	clock := hlc.NewClock(hlc.UnixNano)
+	clock.SetMaxOffset(*maxOffset)
+	s, err := newServer(*rpcAddr, *certDir, clock)
 	if err != nil {
 		log.Errorf("Failed to start Cockroach server: %v", err)
 		return
@@ -277,7 +411,8 @@ func initEngines(stores string) ([]engine.Engine, error) {
 // initEngine parses the store attributes as a colon-separated list
 // and instantiates an engine based on the dir parameter. If dir parses
 // to an integer, it's taken to mean an in-memory engine; otherwise,
-// dir is treated as a path and a RocksDB engine is created.
+// dir is treated as a path and a persistent engine of the type
+// specified by the engine flag is created.
 func initEngine(attrsStr, path string) (engine.Engine, error) {
 	attrs := parseAttributes(attrsStr)
 	if size, err := strconv.ParseUint(path, 10, 64); err == nil {
@@ -288,10 +423,16 @@ func initEngine(attrsStr, path string) (engine.Engine, error) {
 		// TODO(spencer): should be using rocksdb for in-memory stores and
 		// relegate the InMem engine to usage only from unittests.
 	}
-	return engine.NewRocksDB(attrs, path), nil
+	switch *engineType {
+	case "rocksdb":
+		return engine.NewRocksDB(attrs, path), nil
+	case "logdb":
+		return engine.NewLogDB(attrs, path), nil
+	}
+	return nil, util.Errorf("unknown engine type %q", *engineType)
 }
 
-func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error) {
+func newServer(rpcAddr, certDir string, clock *hlc.Clock) (*server, error) {
 	// Determine hostname in case it hasn't been specified in -rpc or -http.
 	host, err := os.Hostname()
 	if err != nil {
@@ -317,32 +458,61 @@ func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error
 		}
 	}
 
+	var tokenKey []byte
+	if *authTokenKey != "" {
+		if tokenKey, err = readAuthTokenKey(*authTokenKey); err != nil {
+			return nil, err
+		}
+	}
+
 	s := &server{
-		host:  host,
-		mux:   http.NewServeMux(),
-		clock: hlc.NewClock(hlc.UnixNano),
+		host:          host,
+		tlsConfig:     tlsConfig,
+		authTokenKey:  tokenKey,
+		mux:           http.NewServeMux(),
+		clock:         clock,
+		registry:      metric.NewRegistry(),
+		stopper:       util.NewStopper(),
+		gatewayBudget: kv.NewMemoryBudget(*gatewayMemory),
 	}
-	s.clock.SetMaxOffset(maxOffset)
 
-	rpcContext := rpc.NewContext(s.clock, tlsConfig)
-	go rpcContext.RemoteClocks.MonitorRemoteOffsets()
+	rpcContext := rpc.NewContext(s.clock, tlsConfig, s.stopper)
+	// Once certificates are reloaded, gradually reconnect to peers
+	// using the new certificates.
+	tlsConfig.OnReload(func() {
+		rpc.DrainClients(s.stopper)
+	})
+	s.stopper.RunWorker(func() {
+		rpcContext.RemoteClocks.MonitorRemoteOffsets(s.stopper)
+	})
 
 	s.rpc = rpc.NewServer(util.MakeRawAddr("tcp", rpcAddr), rpcContext)
 	s.gossip = gossip.New(rpcContext)
 
 	// Create a client.KVSender instance for use with this node's
 	// client to the key value database as well as
-	sender := kv.NewCoordinator(kv.NewDistSender(s.gossip), s.clock)
+	distSender := kv.NewDistSender(s.gossip)
+	distSender.SetHedgePercentile(*hedgeReadPercentile)
+	sender := kv.NewCoordinator(distSender, s.clock, s.stopper)
 	s.kv = client.NewKV(sender, nil)
 	s.kv.User = storage.UserRoot
 
-	s.kvDB = kv.NewDBServer(sender)
+	s.structuredDB = structured.NewDB(s.kv, s.stopper)
+	s.structuredREST = structured.NewRESTServer(s.structuredDB)
+	s.sqlExecutor = sql.NewExecutor(s.structuredDB, s.kv)
+	s.sqlServer = sql.NewHTTPServer(s.sqlExecutor)
+	s.kvDB = kv.NewDBServer(structured.NewQuerySender(sender, s.kv), s.gatewayBudget)
 	s.kvREST = kv.NewRESTServer(s.kv)
 	s.node = NewNode(s.kv, s.gossip)
-	s.admin = newAdminServer(s.kv)
-	s.status = newStatusServer(s.kv, s.gossip)
-	s.structuredDB = structured.NewDB(s.kv)
-	s.structuredREST = structured.NewRESTServer(s.structuredDB)
+	s.admin = newAdminServer(s.kv, s.tlsConfig)
+	s.status = newStatusServer(s.kv, s.gossip, s.registry, s.node.lSender)
+
+	// Link component metrics into the server's registry.
+	s.registry.MustAdd("rpc.", rpcContext.Registry())
+	s.registry.MustAdd("client.", s.kv.Registry())
+	s.registry.MustAdd("gateway.memory.", s.gatewayBudget.Registry())
+	s.registry.MustAdd("gateway.routing.", distSender.Registry())
+	s.registry.MustAdd("node.", s.node.registry)
 
 	return s, nil
 }
@@ -361,16 +531,20 @@ func (s *server) start(engines []engine.Engine, attrs, httpAddr string, selfBoot
 	if selfBootstrap {
 		s.gossip.SetBootstrap([]net.Addr{s.rpc.Addr()})
 	}
-	s.gossip.Start(s.rpc)
+	s.gossip.Start(s.rpc, s.stopper)
 	log.Infoln("Started gossip instance")
 
-	// Init the node attributes from the -attrs command line flag and start node.
+	// Init the node attributes and locality from the -attrs and
+	// -locality command line flags and start node.
 	nodeAttrs := parseAttributes(attrs)
-	if err := s.node.start(s.rpc, s.clock, engines, nodeAttrs); err != nil {
+	nodeLocality, err := proto.ParseLocality(*locality)
+	if err != nil {
+		return err
+	}
+	if err := s.node.start(s.rpc, s.clock, engines, nodeAttrs, nodeLocality, s.stopper); err != nil {
 		return err
 	}
 
-	// TODO(spencer): add tls to the HTTP server.
 	s.initHTTP()
 	if strings.HasPrefix(httpAddr, ":") {
 		httpAddr = s.host + httpAddr
@@ -379,11 +553,38 @@ func (s *server) start(engines []engine.Engine, attrs, httpAddr string, selfBoot
 	if err != nil {
 		return util.Errorf("could not listen on %s: %s", httpAddr, err)
 	}
+	// With certificates, the HTTP server requires TLS. Clients may
+	// authenticate with either a client certificate or an auth token.
+	insecure := s.tlsConfig.Config() == nil
+	ln = s.tlsConfig.NewListener(ln, func(cfg *tls.Config) {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	})
+	if *certReloadInterval > 0 {
+		s.tlsConfig.Watch(*certReloadInterval, s.stopper)
+	}
 	// Obtaining the http end point listener is difficult using
 	// http.ListenAndServe(), so we are storing it with the server.
 	s.httpListener = &ln
 	log.Infof("Starting HTTP server at %s", ln.Addr())
-	go http.Serve(ln, s)
+	s.stopper.RunWorker(func() {
+		<-s.stopper.ShouldStop()
+		ln.Close()
+	})
+	go http.Serve(ln, newAuthenticator(s, s.authTokenKey, insecure))
+
+	if *pgAddr != "" {
+		if !insecure {
+			return util.Errorf("the PostgreSQL wire protocol listener may not be enabled with certificates")
+		}
+		addr := *pgAddr
+		if strings.HasPrefix(addr, ":") {
+			addr = s.host + addr
+		}
+		s.pgServer = pgwire.NewServer(addr, s.sqlExecutor)
+		if err := s.pgServer.Start(s.stopper); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -399,12 +600,11 @@ func (s *server) initHTTP() {
 	s.mux.Handle(kv.RESTPrefix, s.kvREST)
 	s.mux.Handle(kv.DBPrefix, s.kvDB)
 	s.mux.Handle(structured.StructuredKeyPrefix, s.structuredREST)
+	s.mux.Handle(sql.Endpoint, s.sqlServer)
 }
 
 func (s *server) stop() {
-	s.node.stop()
-	s.gossip.Stop()
-	s.rpc.Close()
+	s.stopper.Stop()
 	s.kv.Close()
 }
 
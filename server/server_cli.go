@@ -29,16 +29,67 @@ import (
 	"regexp"
 
 	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
 var addr = flag.String("addr", "127.0.0.1:8080", "address for connection to cockroach cluster")
 
+// authToken authenticates admin requests to nodes started with
+// certificates. Admin endpoints are restricted to the root user, so
+// unless the certificate in the -certs directory names root, requests
+// must present a root token minted by the token command.
+var authToken = flag.String("auth_token", "", "specify an auth token "+
+	"presented by admin commands to nodes started with certificates; "+
+	"see the token command")
+
+// adminScheme returns the scheme for connecting to the admin
+// endpoint. Nodes started with certificates serve HTTP only over TLS,
+// so with a certificate directory the scheme is https.
+func adminScheme() string {
+	if *certDir != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// adminTransport returns the HTTP transport for admin requests. With
+// a certificate directory, the transport verifies the node's
+// certificate against the cluster CA and presents the certificate
+// from the directory.
+func adminTransport() (*http.Transport, error) {
+	if *certDir == "" {
+		return &http.Transport{}, nil
+	}
+	tlsConfig, err := rpc.LoadTLSConfig(*certDir)
+	if err != nil {
+		return nil, util.Errorf("unable to load TLS config: %s", err)
+	}
+	return &http.Transport{TLSClientConfig: tlsConfig.Config()}, nil
+}
+
+// adminClient returns the HTTP client for admin requests, which uses
+// the transport returned by adminTransport.
+func adminClient() (*http.Client, error) {
+	transport, err := adminTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
 // sendAdminRequest send an HTTP request and processes the response for
 // its body or error message if a non-200 response code.
 func sendAdminRequest(req *http.Request) ([]byte, error) {
-	resp, err := http.DefaultClient.Do(req)
+	client, err := adminClient()
+	if err != nil {
+		return nil, err
+	}
+	if *authToken != "" {
+		req.Header.Set(util.AuthorizationHeader, util.AuthTokenScheme+*authToken)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, util.Errorf("admin REST request failed: %s", err)
 	}
@@ -74,13 +125,12 @@ func runGetConfig(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	friendlyName := getFriendlyNameFromPrefix(prefix)
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s/%s", adminScheme, *addr, prefix, args[0]), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s/%s", adminScheme(), *addr, prefix, args[0]), nil)
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
 	}
 	req.Header.Add("Accept", "text/yaml")
-	// TODO(spencer): need to move to SSL.
 	b, err := sendAdminRequest(req)
 	if err != nil {
 		log.Errorf("admin REST request failed: %s", err)
@@ -100,7 +150,7 @@ func runLsConfigs(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	friendlyName := getFriendlyNameFromPrefix(prefix)
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", adminScheme, *addr, prefix), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", adminScheme(), *addr, prefix), nil)
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
@@ -144,12 +194,11 @@ func runRmConfig(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	friendlyName := getFriendlyNameFromPrefix(prefix)
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s://%s%s/%s", adminScheme, *addr, prefix, args[0]), nil)
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s://%s%s/%s", adminScheme(), *addr, prefix, args[0]), nil)
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
 	}
-	// TODO(spencer): need to move to SSL.
 	_, err = sendAdminRequest(req)
 	if err != nil {
 		log.Errorf("admin REST request failed: %s", err)
@@ -175,13 +224,12 @@ func runSetConfig(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	// Send to admin REST API.
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s/%s", adminScheme, *addr, prefix, args[0]), bytes.NewReader(body))
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s/%s", adminScheme(), *addr, prefix, args[0]), bytes.NewReader(body))
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
 	}
 	req.Header.Add("Content-Type", "text/yaml")
-	// TODO(spencer): need to move to SSL.
 	_, err = sendAdminRequest(req)
 	if err != nil {
 		log.Errorf("admin REST request failed: %s", err)
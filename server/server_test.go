@@ -29,6 +29,8 @@ import (
 
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -41,12 +43,20 @@ func startServer() *server {
 	serverTestOnce.Do(func() {
 		// We update these with the actual port once the servers
 		// have been launched for the purpose of this test.
-		s, err := newServer("127.0.0.1:0", "", *maxOffset)
+		var err error
+		clock := hlc.NewClock(hlc.UnixNano)
+		clock.SetMaxOffset(*maxOffset)
+		s, err = newServer("127.0.0.1:0", "", clock)
 		if err != nil {
 			log.Fatal(err)
 		}
 		engines := []engine.Engine{engine.NewInMem(proto.Attributes{}, 1<<20)}
-		if _, err := BootstrapCluster("cluster-1", engines[0]); err != nil {
+		stopper := util.NewStopper()
+		_, err = BootstrapCluster("cluster-1", engines[0], stopper)
+		// The bootstrap database is no longer needed once the cluster is
+		// bootstrapped; the server uses its own.
+		stopper.Stop()
+		if err != nil {
 			log.Fatal(err)
 		}
 		err = s.start(engines, "", "127.0.0.1:0", true) // TODO(spencer): should shutdown server.
@@ -247,3 +257,69 @@ func TestGzip(t *testing.T) {
 		t.Errorf("expected body to contain %q, got %q", expected, string(b))
 	}
 }
+
+// TestMultiRangeScan verifies that scans spanning multiple ranges
+// return rows from all ranges in key order, subject to the scan's
+// result and byte limits, along with a checksum of the merged rows.
+func TestMultiRangeScan(t *testing.T) {
+	s := startServer()
+	// Scan at the timestamp of the latest write, which may be ahead of
+	// the clock.
+	var ts proto.Timestamp
+	for _, key := range []string{"scan-h", "scan-a", "scan-f", "scan-d", "scan-b", "scan-g"} {
+		reply := &proto.PutResponse{}
+		if err := s.kv.Call(proto.Put, proto.PutArgs(proto.Key(key), []byte("value")), reply); err != nil {
+			t.Fatal(err)
+		}
+		if ts.Less(reply.Timestamp) {
+			ts = reply.Timestamp
+		}
+	}
+	for _, split := range []string{"scan-c", "scan-e", "scan-g"} {
+		args := &proto.AdminSplitRequest{
+			RequestHeader: proto.RequestHeader{Key: proto.Key(split)},
+			SplitKey:      proto.Key(split),
+		}
+		if err := s.kv.Call(proto.AdminSplit, args, &proto.AdminSplitResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		start, end           string
+		maxResults, maxBytes int64
+		expected             []string
+	}{
+		{"scan-", "scan-z", 0, 0, []string{"scan-a", "scan-b", "scan-d", "scan-f", "scan-g", "scan-h"}},
+		{"scan-b", "scan-g", 10, 0, []string{"scan-b", "scan-d", "scan-f"}},
+		{"scan-", "scan-z", 3, 0, []string{"scan-a", "scan-b", "scan-d"}},
+		{"scan-c", "scan-z", 1, 0, []string{"scan-d"}},
+		{"scan-", "scan-z", 0, 22, []string{"scan-a", "scan-b"}},
+		{"scan-", "scan-z", 0, 23, []string{"scan-a", "scan-b", "scan-d"}},
+		{"scan-", "scan-z", 2, 1000, []string{"scan-a", "scan-b"}},
+	}
+	for i, test := range testCases {
+		args := &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{Key: proto.Key(test.start), EndKey: proto.Key(test.end), Timestamp: ts},
+			MaxResults:    test.maxResults,
+			MaxBytes:      test.maxBytes,
+			Checksum:      true,
+		}
+		reply := &proto.ScanResponse{}
+		if err := s.kv.Call(proto.Scan, args, reply); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if reply.Checksum == nil {
+			t.Errorf("%d: expected checksum of scanned rows", i)
+		} else if err := reply.VerifyChecksum(); err != nil {
+			t.Errorf("%d: %s", i, err)
+		}
+		var scanned []string
+		for _, row := range reply.Rows {
+			scanned = append(scanned, string(row.Key))
+		}
+		if fmt.Sprint(scanned) != fmt.Sprint(test.expected) {
+			t.Errorf("%d: expected rows %v; got %v", i, test.expected, scanned)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/sql"
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/peterh/liner"
+)
+
+const (
+	// shellPrompt prompts for a new statement.
+	shellPrompt = "cockroach> "
+	// shellContinuePrompt prompts for the continuation of a statement
+	// spanning multiple lines.
+	shellContinuePrompt = "        -> "
+	// shellHistoryFile is the name of the file in the user's home
+	// directory which persists statement history across sessions.
+	shellHistoryFile = ".cockroach_history"
+)
+
+// shellHelp describes the shell's meta commands.
+const shellHelp = `Statements are terminated by ';' and may span multiple lines.
+Meta commands:
+  \q          quit the shell
+  \?          show this help
+  \dt         list tables
+  \d [table]  describe a table, or list tables if none is given
+`
+
+// A CmdShell command opens an interactive SQL shell.
+var CmdShell = &commander.Command{
+	UsageLine: "shell [options]",
+	Short:     "open an interactive SQL shell",
+	Long: `
+Opens an interactive shell which executes SQL statements against the
+node at the address specified by the -addr command line flag and
+displays their results as tables. Statements are terminated by ';'
+and may span multiple lines. Statement history is kept in
+~/` + shellHistoryFile + `. Enter \? for a list of meta commands.
+`,
+	Run:  runShell,
+	Flag: *flag.CommandLine,
+}
+
+// runShell reads statements and meta commands from the terminal until
+// the user quits or closes the input.
+func runShell(cmd *commander.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		return
+	}
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+
+	historyPath := filepath.Join(os.Getenv("HOME"), shellHistoryFile)
+	if f, err := os.Open(historyPath); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+	defer func() {
+		f, err := os.Create(historyPath)
+		if err != nil {
+			log.Warningf("unable to save shell history: %s", err)
+			return
+		}
+		defer f.Close()
+		if _, err := line.WriteHistory(f); err != nil {
+			log.Warningf("unable to save shell history: %s", err)
+		}
+	}()
+
+	fmt.Fprintf(os.Stdout, "Connected to %s. Enter \\? for help.\n", *addr)
+	var stmt []string
+	for {
+		prompt := shellPrompt
+		if len(stmt) > 0 {
+			prompt = shellContinuePrompt
+		}
+		l, err := line.Prompt(prompt)
+		if err == liner.ErrPromptAborted {
+			// Ctrl-C discards the statement being entered.
+			stmt = nil
+			continue
+		} else if err == io.EOF {
+			fmt.Fprintln(os.Stdout)
+			return
+		} else if err != nil {
+			log.Errorf("unable to read input: %s", err)
+			return
+		}
+		trimmed := strings.TrimSpace(l)
+		if len(stmt) == 0 {
+			if trimmed == "" {
+				continue
+			}
+			if strings.HasPrefix(trimmed, `\`) {
+				line.AppendHistory(trimmed)
+				metaStmt, quit := parseMetaCommand(os.Stdout, trimmed)
+				if quit {
+					return
+				}
+				if metaStmt != "" {
+					runShellStatement(os.Stdout, metaStmt)
+				}
+				continue
+			}
+		}
+		stmt = append(stmt, l)
+		if !strings.HasSuffix(trimmed, ";") {
+			continue
+		}
+		full := strings.Join(stmt, "\n")
+		stmt = nil
+		line.AppendHistory(full)
+		runShellStatement(os.Stdout, full)
+	}
+}
+
+// parseMetaCommand interprets a meta command, returning the statement
+// it stands for, if any, and whether the shell should quit. Help and
+// usage errors are written to w.
+func parseMetaCommand(w io.Writer, cmd string) (stmt string, quit bool) {
+	fields := strings.Fields(cmd)
+	switch {
+	case fields[0] == `\q`:
+		return "", true
+	case fields[0] == `\?`:
+		fmt.Fprint(w, shellHelp)
+	case fields[0] == `\dt` && len(fields) == 1, fields[0] == `\d` && len(fields) == 1:
+		return "SHOW TABLES", false
+	case fields[0] == `\d` && len(fields) == 2:
+		return "SHOW COLUMNS FROM " + fields[1], false
+	default:
+		fmt.Fprintf(w, "invalid command %s; enter \\? for help\n", cmd)
+	}
+	return "", false
+}
+
+// runShellStatement executes stmt at the SQL endpoint and writes its
+// result, or the error it failed with, to w.
+func runShellStatement(w io.Writer, stmt string) {
+	resp, err := sendSQLRequest(stmt)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR: %s\n", err)
+		return
+	}
+	formatSQLResponse(w, resp)
+}
+
+// sendSQLRequest posts stmt to the SQL endpoint and decodes the
+// response.
+func sendSQLRequest(stmt string) (*sql.Response, error) {
+	body, err := json.Marshal(&sql.Request{Statement: stmt})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s", adminScheme(), *addr, sql.Endpoint), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	b, err := sendAdminRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &sql.Response{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// formatSQLResponse writes resp to w: errors with their message, query
+// results as an aligned table followed by the number of rows, and the
+// results of other statements as their tag. NULL values are written
+// as empty cells.
+func formatSQLResponse(w io.Writer, resp *sql.Response) {
+	if resp.Error != nil {
+		fmt.Fprintf(w, "ERROR: %s\n", resp.Error.Message)
+		return
+	}
+	if resp.Columns == nil {
+		if resp.Tag != "" {
+			fmt.Fprintln(w, resp.Tag)
+		}
+		return
+	}
+
+	widths := make([]int, len(resp.Columns))
+	for i, col := range resp.Columns {
+		widths[i] = utf8.RuneCountInString(col)
+	}
+	for _, row := range resp.Rows {
+		for i, v := range row {
+			if v != nil && utf8.RuneCountInString(*v) > widths[i] {
+				widths[i] = utf8.RuneCountInString(*v)
+			}
+		}
+	}
+	writeRow := func(cells []string) {
+		var buf bytes.Buffer
+		for i, cell := range cells {
+			if i > 0 {
+				buf.WriteString("|")
+			}
+			fmt.Fprintf(&buf, " %s%s ", cell, strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+		}
+		fmt.Fprintln(w, strings.TrimRight(buf.String(), " "))
+	}
+
+	writeRow(resp.Columns)
+	dashes := make([]string, len(widths))
+	for i, width := range widths {
+		dashes[i] = strings.Repeat("-", width+2)
+	}
+	fmt.Fprintln(w, strings.Join(dashes, "+"))
+	for _, row := range resp.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			if v != nil {
+				cells[i] = *v
+			}
+		}
+		writeRow(cells)
+	}
+	if len(resp.Rows) == 1 {
+		fmt.Fprintln(w, "(1 row)")
+	} else {
+		fmt.Fprintf(w, "(%d rows)\n", len(resp.Rows))
+	}
+}
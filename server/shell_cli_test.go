@@ -0,0 +1,81 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/sql"
+)
+
+func TestFormatSQLResponse(t *testing.T) {
+	str := func(s string) *string { return &s }
+	testCases := []struct {
+		resp     sql.Response
+		expected string
+	}{
+		{sql.Response{Tag: "INSERT 0 2"}, "INSERT 0 2\n"},
+		{sql.Response{}, ""},
+		{sql.Response{Error: &sql.Error{Code: sql.CodeUndefinedTable, Message: `relation "t" does not exist`}},
+			"ERROR: relation \"t\" does not exist\n"},
+		{sql.Response{Columns: []string{"id", "name"}, Tag: "SELECT 0"},
+			" id | name\n----+------\n(0 rows)\n"},
+		{sql.Response{
+			Columns: []string{"id", "name"},
+			Rows:    [][]*string{{str("1"), str("alice")}, {str("10"), nil}},
+			Tag:     "SELECT 2",
+		}, " id | name\n----+-------\n 1  | alice\n 10 |\n(2 rows)\n"},
+		{sql.Response{
+			Columns: []string{"table"},
+			Rows:    [][]*string{{str("users")}},
+			Tag:     "SELECT 1",
+		}, " table\n-------\n users\n(1 row)\n"},
+	}
+	for i, test := range testCases {
+		var buf bytes.Buffer
+		formatSQLResponse(&buf, &test.resp)
+		if buf.String() != test.expected {
+			t.Errorf("%d: expected\n%s\ngot\n%s", i, test.expected, buf.String())
+		}
+	}
+}
+
+func TestParseMetaCommand(t *testing.T) {
+	testCases := []struct {
+		cmd     string
+		expStmt string
+		expQuit bool
+		expOut  bool // whether help or an error is written
+	}{
+		{`\q`, "", true, false},
+		{`\?`, "", false, true},
+		{`\dt`, "SHOW TABLES", false, false},
+		{`\d`, "SHOW TABLES", false, false},
+		{`\d  users`, "SHOW COLUMNS FROM users", false, false},
+		{`\d users photos`, "", false, true},
+		{`\x`, "", false, true},
+	}
+	for i, test := range testCases {
+		var buf bytes.Buffer
+		stmt, quit := parseMetaCommand(&buf, test.cmd)
+		if stmt != test.expStmt || quit != test.expQuit || (buf.Len() > 0) != test.expOut {
+			t.Errorf("%d: expected %q, %t, output %t; got %q, %t, %q", i, test.expStmt, test.expQuit, test.expOut, stmt, quit, buf.String())
+		}
+	}
+}
@@ -21,11 +21,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"runtime"
+	"sort"
+	"strconv"
 
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/kv"
 	"github.com/cockroachdb/cockroach/server/status"
+	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 )
 
 const (
@@ -46,6 +51,18 @@ const (
 	// statusLocalStacksKey exposes stack traces of running goroutines.
 	statusLocalStacksKey = statusLocalKeyPrefix + "stacks"
 
+	// statusLocalMetricsKey exposes a snapshot of the node's metrics.
+	statusLocalMetricsKey = statusLocalKeyPrefix + "metrics"
+
+	// statusLocalRaftKey exposes the replication state of the replicas
+	// on the node's stores. The range query parameter restricts the
+	// response to the replicas of the range with that Raft ID.
+	statusLocalRaftKey = statusLocalKeyPrefix + "raft"
+
+	// statusVarsKey exposes the node's metrics in the Prometheus text
+	// format for scraping by external monitoring systems.
+	statusVarsKey = statusKeyPrefix + "vars"
+
 	// statusNodesKeyPrefix exposes status for each of the nodes the cluster.
 	// GETing statusNodesKeyPrefix will list all nodes.
 	// Individual node status can be queried at statusNodesKeyPrefix/NodeID.
@@ -60,15 +77,21 @@ const (
 
 // A statusServer provides a RESTful status API.
 type statusServer struct {
-	db     *client.KV
-	gossip *gossip.Gossip
+	db       *client.KV
+	gossip   *gossip.Gossip
+	registry *metric.Registry
+	stores   *kv.LocalSender
 }
 
-// newStatusServer allocates and returns a statusServer.
-func newStatusServer(db *client.KV, gossip *gossip.Gossip) *statusServer {
+// newStatusServer allocates and returns a statusServer. The registry
+// holds the metrics exported by the local metrics endpoint, and
+// stores provides access to the node's stores.
+func newStatusServer(db *client.KV, gossip *gossip.Gossip, registry *metric.Registry, stores *kv.LocalSender) *statusServer {
 	return &statusServer{
-		db:     db,
-		gossip: gossip,
+		db:       db,
+		gossip:   gossip,
+		registry: registry,
+		stores:   stores,
 	}
 }
 
@@ -79,6 +102,9 @@ func (s *statusServer) RegisterHandlers(mux *http.ServeMux) {
 	mux.HandleFunc(statusGossipKeyPrefix, s.handleGossipStatus)
 	mux.HandleFunc(statusLocalKeyPrefix, s.handleLocalStatus)
 	mux.HandleFunc(statusLocalStacksKey, s.handleLocalStacks)
+	mux.HandleFunc(statusLocalMetricsKey, s.handleLocalMetrics)
+	mux.HandleFunc(statusLocalRaftKey, s.handleLocalRaft)
+	mux.HandleFunc(statusVarsKey, s.handleVars)
 	mux.HandleFunc(statusNodesKeyPrefix, s.handleNodeStatus)
 	mux.HandleFunc(statusStoresKeyPrefix, s.handleStoresStatus)
 	mux.HandleFunc(statusTransactionsKeyPrefix, s.handleTransactionStatus)
@@ -137,6 +163,71 @@ func (s *statusServer) handleLocalStacks(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// handleLocalMetrics handles GET requests for a snapshot of the
+// local node's metrics.
+func (s *statusServer) handleLocalMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	b, err := json.Marshal(s.registry)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+// raftStatusResponse is the response to local Raft status requests.
+type raftStatusResponse struct {
+	Stores storeRaftStatuses `json:"stores"`
+}
+
+// storeRaftStatuses sorts store Raft statuses by store ID.
+type storeRaftStatuses []storage.StoreRaftStatus
+
+func (s storeRaftStatuses) Len() int           { return len(s) }
+func (s storeRaftStatuses) Less(i, j int) bool { return s[i].StoreID < s[j].StoreID }
+func (s storeRaftStatuses) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// handleLocalRaft handles GET requests for the replication state of
+// the replicas on the local node's stores.
+func (s *statusServer) handleLocalRaft(w http.ResponseWriter, r *http.Request) {
+	var raftID int64
+	if param := r.URL.Query().Get("range"); param != "" {
+		var err error
+		if raftID, err = strconv.ParseInt(param, 10, 64); err != nil || raftID <= 0 {
+			http.Error(w, "invalid range "+strconv.Quote(param), http.StatusBadRequest)
+			return
+		}
+	}
+	resp := &raftStatusResponse{Stores: storeRaftStatuses{}}
+	if err := s.stores.VisitStores(func(store *storage.Store) error {
+		resp.Stores = append(resp.Stores, store.RaftStatus(raftID))
+		return nil
+	}); err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sort.Sort(resp.Stores)
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// handleVars handles GET requests for the node's metrics in the
+// Prometheus text exposition format.
+func (s *statusServer) handleVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metric.PrometheusContentType)
+	if err := s.registry.PrintAsText(w); err != nil {
+		log.Error(err)
+	}
+}
+
 // handleNodeStatus handles GET requests for node status.
 func (s *statusServer) handleNodeStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
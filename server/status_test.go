@@ -18,36 +18,48 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"testing"
 
+	"github.com/cockroachdb/cockroach/kv"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 )
 
 // startStatusServer launches a new status server using minimal engine
 // and local database setup. Returns the new http test server, which
-// should be cleaned up by caller via httptest.Server.Close(). The
-// Cockroach KV client address is set to the address of the test server.
-func startStatusServer() *httptest.Server {
-	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20))
+// should be cleaned up by caller via httptest.Server.Close(), and the
+// stopper of the local database, which should be stopped by caller.
+// The Cockroach KV client address is set to the address of the test
+// server.
+func startStatusServer() (*httptest.Server, *util.Stopper) {
+	stopper := util.NewStopper()
+	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20), stopper)
 	if err != nil {
 		log.Fatal(err)
 	}
-	status := newStatusServer(db, nil)
+	registry := metric.NewRegistry()
+	registry.MustAdd("client.", db.Registry())
+	status := newStatusServer(db, nil, registry, kv.NewLocalSender())
 	mux := http.NewServeMux()
 	status.RegisterHandlers(mux)
 	httpServer := httptest.NewServer(mux)
-	return httpServer
+	return httpServer, stopper
 }
 
 // TestStatusStacks verifies that goroutine stack traces are available
 // via the /_status/stacks endpoint.
 func TestStatusStacks(t *testing.T) {
-	s := startStatusServer()
+	s, stopper := startStatusServer()
+	defer stopper.Stop()
 	body, err := getText(s.URL + statusLocalStacksKey)
 	if err != nil {
 		t.Fatal(err)
@@ -57,3 +69,84 @@ func TestStatusStacks(t *testing.T) {
 		t.Errorf("expected match: %t; err nil: %v", matches, err)
 	}
 }
+
+// TestStatusLocalMetrics verifies that a snapshot of the node's
+// metrics is available via the /_status/local/metrics endpoint.
+func TestStatusLocalMetrics(t *testing.T) {
+	s, stopper := startStatusServer()
+	defer stopper.Stop()
+	body, err := getText(s.URL + statusLocalMetricsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics map[string]float64
+	if err := json.Unmarshal(body, &metrics); err != nil {
+		t.Fatalf("could not unmarshal metrics %s: %s", body, err)
+	}
+	// Bootstrapping the cluster issues calls via the client.
+	if calls := metrics["client.calls"]; calls == 0 {
+		t.Errorf("expected non-zero client calls in %s", body)
+	}
+}
+
+// TestStatusVars verifies that the node's metrics are available in
+// Prometheus text format via the /_status/vars endpoint.
+func TestStatusVars(t *testing.T) {
+	s, stopper := startStatusServer()
+	defer stopper.Stop()
+	body, err := getText(s.URL + statusVarsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte("# TYPE cockroach_client_calls counter\n")) {
+		t.Errorf("expected client calls counter in vars:\n%s", body)
+	}
+}
+
+// TestStatusLocalRaft verifies that the replication state of the
+// node's replicas is available via the /_status/local/raft endpoint,
+// optionally restricted to a single range.
+func TestStatusLocalRaft(t *testing.T) {
+	s := StartTestServer(t)
+	defer s.Stop()
+	url := fmt.Sprintf("%s://%s%s", adminScheme(), s.HTTPAddr, statusLocalRaftKey)
+
+	testCases := []struct {
+		query     string
+		expRanges int
+	}{
+		{"", 1},
+		{"?range=1", 1},
+		{"?range=2", 0},
+	}
+	for i, test := range testCases {
+		body, err := getText(url + test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := &raftStatusResponse{}
+		if err := json.Unmarshal(body, resp); err != nil {
+			t.Fatalf("%d: could not unmarshal raft status %s: %s", i, body, err)
+		}
+		if len(resp.Stores) != 1 || resp.Stores[0].StoreID != 1 {
+			t.Fatalf("%d: expected the status of store 1; got %s", i, body)
+		}
+		if len(resp.Stores[0].Ranges) != test.expRanges {
+			t.Fatalf("%d: expected %d ranges; got %s", i, test.expRanges, body)
+		}
+		for _, rng := range resp.Stores[0].Ranges {
+			if rng.RaftID != 1 || !rng.Leader || len(rng.Replicas) != 1 {
+				t.Errorf("%d: unexpected status of the first range %+v", i, rng)
+			}
+		}
+	}
+
+	resp, err := http.Get(url + "?range=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for invalid range; got %s", resp.Status)
+	}
+}
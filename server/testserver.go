@@ -18,12 +18,16 @@
 package server
 
 import (
+	"net"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/kv"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
 )
 
 const (
@@ -52,6 +56,20 @@ type TestServer struct {
 	// HTTPAddr and RPCAddr default to localhost with port set
 	// at time of call to Start() to an available port.
 	HTTPAddr, RPCAddr string
+	// Clock, if set, is used as the server's clock in place of a
+	// wall clock; MaxOffset is ignored. Supply a clock driven by an
+	// hlc.ManualClock for deterministic timestamps.
+	Clock *hlc.Clock
+	// Engines, if set, are used as the server's stores. They must
+	// either be bootstrapped already or be joining an existing cluster
+	// via GossipBootstrap. If nil, a single in-memory engine is created
+	// and bootstrapped as a new cluster, and Engines is set to it so
+	// the server may later be restarted with the same data.
+	Engines []engine.Engine
+	// GossipBootstrap is the RPC address of a node in an existing
+	// cluster which this server joins. If empty, the server uses its
+	// own address as the gossip bootstrap.
+	GossipBootstrap string
 	// server is the embedded Cockroach server struct.
 	*server
 }
@@ -79,16 +97,27 @@ func (ts *TestServer) Start() error {
 	if ts.HTTPAddr == "" {
 		ts.HTTPAddr = defaultHTTPAddr
 	}
+	clock := ts.Clock
+	if clock == nil {
+		clock = hlc.NewClock(hlc.UnixNano)
+		clock.SetMaxOffset(ts.MaxOffset)
+	}
 	var err error
-	ts.server, err = newServer(ts.RPCAddr, ts.CertDir, ts.MaxOffset)
+	ts.server, err = newServer(ts.RPCAddr, ts.CertDir, clock)
 	if err != nil {
 		return util.Errorf("could not init server: %s", err)
 	}
-	engines := []engine.Engine{engine.NewInMem(proto.Attributes{}, 100<<20)}
-	if _, err := BootstrapCluster("cluster-1", engines[0]); err != nil {
-		return util.Errorf("could not bootstrap cluster: %s", err)
+	if ts.Engines == nil {
+		engines := []engine.Engine{engine.NewInMem(proto.Attributes{}, 100<<20)}
+		if _, err := BootstrapCluster("cluster-1", engines[0], ts.stopper); err != nil {
+			return util.Errorf("could not bootstrap cluster: %s", err)
+		}
+		ts.Engines = engines
+	}
+	if ts.GossipBootstrap != "" {
+		ts.gossip.SetBootstrap([]net.Addr{util.MakeRawAddr("tcp", ts.GossipBootstrap)})
 	}
-	err = ts.start(engines, "", ts.HTTPAddr, true) // TODO(spencer): should shutdown server.
+	err = ts.start(ts.Engines, "", ts.HTTPAddr, ts.GossipBootstrap == "") // TODO(spencer): should shutdown server.
 	if err != nil {
 		return util.Errorf("could not start server: %s", err)
 	}
@@ -100,6 +129,19 @@ func (ts *TestServer) Start() error {
 	return nil
 }
 
+// KV returns the server's client to the key value database. Requests
+// are routed through the server's transaction coordinator and
+// distributed sender.
+func (ts *TestServer) KV() *client.KV {
+	return ts.kv
+}
+
+// LocalSender returns the sender for the stores local to the server's
+// node, which provides access to the stores themselves.
+func (ts *TestServer) LocalSender() *kv.LocalSender {
+	return ts.node.lSender
+}
+
 // Stop stops the TestServer.
 func (ts *TestServer) Stop() {
 	ts.stop()
@@ -0,0 +1,178 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+const (
+	// tracePathPrefix is the prefix for enabling, listing and disabling
+	// traces of the commands executed by this node.
+	tracePathPrefix = adminEndpoint + "trace"
+
+	// defaultTraceDuration is the duration of traces which don't
+	// specify one.
+	defaultTraceDuration = 5 * time.Minute
+	// maxTraceDuration bounds the duration of traces, so that a
+	// forgotten trace doesn't log indefinitely.
+	maxTraceDuration = 1 * time.Hour
+	// defaultTraceRate is the maximum number of commands logged per
+	// second by traces which don't specify a rate.
+	defaultTraceRate = 10
+
+	// auditActionEnableTrace records the enabling of a trace.
+	auditActionEnableTrace = "enable-trace"
+	// auditActionDisableTrace records the disabling of a trace.
+	auditActionDisableTrace = "disable-trace"
+)
+
+// A traceConfig specifies a trace via the admin API. Traces log the
+// commands executed by the node's stores which match all of the
+// specified criteria: the key span, range ID and transaction ID.
+// Commands are executed by range leaders, so a trace must be enabled
+// on the node holding the leadership of the traced keys.
+type traceConfig struct {
+	ID           int64     `json:"id" yaml:"id"`
+	Key          string    `json:"key,omitempty" yaml:"key,omitempty"`
+	EndKey       string    `json:"end_key,omitempty" yaml:"end_key,omitempty"`
+	RangeID      int64     `json:"range_id,omitempty" yaml:"range_id,omitempty"`
+	TxnID        string    `json:"txn_id,omitempty" yaml:"txn_id,omitempty"`
+	Duration     string    `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Expiration   time.Time `json:"expiration" yaml:"expiration"`
+	MaxPerSecond int       `json:"max_per_second" yaml:"max_per_second"`
+}
+
+// newTraceConfig returns the trace config of an enabled trace.
+func newTraceConfig(spec log.TraceSpec) *traceConfig {
+	return &traceConfig{
+		ID:           spec.ID,
+		Key:          string(spec.Key),
+		EndKey:       string(spec.EndKey),
+		RangeID:      spec.RangeID,
+		TxnID:        string(spec.TxnID),
+		Expiration:   spec.Expiration,
+		MaxPerSecond: spec.MaxPerSecond,
+	}
+}
+
+// traceSpec returns the trace spec for the config, which expires
+// after the config's duration from now.
+func (tc *traceConfig) traceSpec(now time.Time) (log.TraceSpec, error) {
+	duration := defaultTraceDuration
+	if tc.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(tc.Duration); err != nil {
+			return log.TraceSpec{}, util.Errorf("invalid trace duration %q: %s", tc.Duration, err)
+		}
+	}
+	if duration <= 0 || duration > maxTraceDuration {
+		return log.TraceSpec{}, util.Errorf("trace duration %s must be positive and at most %s", duration, maxTraceDuration)
+	}
+	rate := tc.MaxPerSecond
+	if rate == 0 {
+		rate = defaultTraceRate
+	} else if rate < 0 {
+		return log.TraceSpec{}, util.Errorf("trace rate %d must be positive", rate)
+	}
+	return log.TraceSpec{
+		Key:          []byte(tc.Key),
+		EndKey:       []byte(tc.EndKey),
+		RangeID:      tc.RangeID,
+		TxnID:        []byte(tc.TxnID),
+		Expiration:   now.Add(duration),
+		MaxPerSecond: rate,
+	}, nil
+}
+
+// handleTraceAction lists the enabled traces on GET, enables a trace
+// on PUT or POST, returning it, and disables the trace with the ID
+// following the path prefix on DELETE. Traces are enabled and
+// disabled on this node only.
+func (s *adminServer) handleTraceAction(w http.ResponseWriter, r *http.Request) {
+	var resp interface{}
+	switch r.Method {
+	case "GET":
+		configs := []*traceConfig{}
+		for _, spec := range log.Traces() {
+			configs = append(configs, newTraceConfig(spec))
+		}
+		resp = configs
+	case "PUT", "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer r.Body.Close()
+		tc := &traceConfig{}
+		if err := util.UnmarshalRequest(r, b, tc, []util.EncodingType{util.JSONEncoding, util.YAMLEncoding}); err != nil {
+			http.Error(w, util.Errorf("trace config has invalid format: %q: %s", b, err).Error(), http.StatusBadRequest)
+			return
+		}
+		spec, err := tc.traceSpec(time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if spec.ID, err = log.EnableTrace(spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.audit.record(r.Header.Get(util.UserHeader), auditActionEnableTrace, strconv.FormatInt(spec.ID, 10), b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp = newTraceConfig(spec)
+	case "DELETE":
+		idStr := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, tracePathPrefix), "/")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid trace ID %q", idStr), http.StatusBadRequest)
+			return
+		}
+		if !log.DisableTrace(id) {
+			http.Error(w, fmt.Sprintf("trace %d is not enabled", id), http.StatusNotFound)
+			return
+		}
+		if err := s.audit.record(r.Header.Get(util.UserHeader), auditActionDisableTrace, idStr, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	body, contentType, err := util.MarshalResponse(r, resp, []util.EncodingType{util.JSONEncoding, util.YAMLEncoding})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
@@ -0,0 +1,97 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestAdminTrace verifies enabling, listing and disabling traces via
+// the admin API.
+func TestAdminTrace(t *testing.T) {
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
+	defer httpServer.Close()
+	traceURL := fmt.Sprintf("%s://%s%s", adminScheme(), *addr, tracePathPrefix)
+
+	// Invalid traces are rejected.
+	for i, body := range []string{
+		`{"duration": "1m"}`,
+		`{"key": "a", "duration": "2h"}`,
+		`{"key": "c", "end_key": "a"}`,
+		`{"range_id": 1, "max_per_second": -1}`,
+	} {
+		req, err := http.NewRequest("POST", traceURL, bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "application/json")
+		if _, err := sendAdminRequest(req); err == nil {
+			t.Errorf("%d: expected error enabling trace %s", i, body)
+		}
+	}
+
+	req, err := http.NewRequest("POST", traceURL, bytes.NewReader([]byte(`{"key": "a", "end_key": "c"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	body, err := sendAdminRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &traceConfig{}
+	if err := json.Unmarshal(body, tc); err != nil {
+		t.Fatal(err)
+	}
+	if tc.ID == 0 || tc.Key != "a" || tc.EndKey != "c" || tc.MaxPerSecond != defaultTraceRate {
+		t.Errorf("unexpected trace %+v", tc)
+	}
+
+	listTraces := func() []traceConfig {
+		body, err := getText(traceURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var configs []traceConfig
+		if err := json.Unmarshal(body, &configs); err != nil {
+			t.Fatalf("%s: %s", err, body)
+		}
+		return configs
+	}
+	if configs := listTraces(); len(configs) != 1 || configs[0].ID != tc.ID {
+		t.Errorf("expected trace %d; got %+v", tc.ID, configs)
+	}
+
+	for i, expErr := range []bool{false, true} {
+		req, err = http.NewRequest("DELETE", fmt.Sprintf("%s/%d", traceURL, tc.ID), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sendAdminRequest(req); expErr != (err != nil) {
+			t.Errorf("%d: expected error? %t; got %v", i, expErr, err)
+		}
+	}
+	if configs := listTraces(); len(configs) != 0 {
+		t.Errorf("expected no traces; got %+v", configs)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package server
+
+import (
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// SetClusterVersion advances the active cluster version to the
+// specified version, enabling the features it introduces on every
+// node once the new version has been gossiped. The version may not
+// exceed the newest version supported by this binary; the caller is
+// responsible for verifying that every node in the cluster runs a
+// binary supporting it. Cluster versions never decrease.
+func SetClusterVersion(db *client.KV, version proto.ClusterVersion) error {
+	if version > proto.CurrentVersion {
+		return util.Errorf("cluster version %d exceeds newest supported version %d", version, proto.CurrentVersion)
+	}
+	gReply := &proto.GetResponse{}
+	if err := db.Call(proto.Get, &proto.GetRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:  engine.KeyClusterVersion,
+			User: storage.UserRoot,
+		},
+	}, gReply); err != nil {
+		return err
+	}
+	current := proto.VersionBase
+	if gReply.Value != nil {
+		current = proto.ClusterVersion(gReply.Value.GetInteger())
+	}
+	if version <= current {
+		return util.Errorf("cluster version %d does not advance active version %d", version, current)
+	}
+	// Condition the write on the version read, failing if another
+	// client advanced the version concurrently.
+	return db.Call(proto.ConditionalPut, &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:  engine.KeyClusterVersion,
+			User: storage.UserRoot,
+		},
+		Value:    proto.Value{Integer: gogoproto.Int64(int64(version))},
+		ExpValue: gReply.Value,
+	}, &proto.ConditionalPutResponse{})
+}
@@ -47,6 +47,12 @@ func (zh *zoneHandler) Put(path string, body []byte, r *http.Request) error {
 	if err := util.UnmarshalRequest(r, body, config, util.AllEncodings); err != nil {
 		return util.Errorf("zone config has invalid format: %q: %s", body, err)
 	}
+	if _, err := engine.ParseCompression(config.Compression); err != nil {
+		return util.Errorf("zone config has invalid compression: %s", err)
+	}
+	if _, err := proto.ParseConstraints(config.Constraints); err != nil {
+		return util.Errorf("zone config has invalid constraints: %s", err)
+	}
 	zoneKey := engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key(path[1:]))
 	if err := zh.db.PutProto(zoneKey, config); err != nil {
 		return err
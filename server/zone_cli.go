@@ -105,6 +105,8 @@ The zone config format has the following YAML schema:
     - ...
   range_min_bytes: <size-in-bytes>
   range_max_bytes: <size-in-bytes>
+  range_max_qps: <requests-per-second>
+  constraints: [comma-separated constraint list]
 
 For example:
 
@@ -114,10 +116,19 @@ For example:
     - [us-west-1b, ssd]
   range_min_bytes: 8388608
   range_min_bytes: 67108864
+  constraints: [unique:region, -rack=12]
 
 Setting zone configs will guarantee that key ranges will be split
 such that no key range straddles two zone config specifications.
-This feature can be taken advantage of to pre-split ranges.
+This feature can be taken advantage of to pre-split ranges. If
+range_max_qps is set, ranges receiving more requests per second are
+split by load even when smaller than range_max_bytes.
+
+Constraints restrict replicas by the locality tiers of their nodes,
+as specified by the -locality command line flag: "+key=value"
+requires the tier, "-key=value" prohibits it and "unique:key" places
+at most one replica in each value of the tier. The example above
+places one replica per region and none on rack 12.
 `,
 	Run:  runSetZone,
 	Flag: *flag.CommandLine,
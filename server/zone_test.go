@@ -42,7 +42,8 @@ range_max_bytes: 67108864
 // ExampleSetAndGetZone sets zone configs for a variety of key
 // prefixes and verifies they can be fetched directly.
 func ExampleSetAndGetZone() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testZoneConfig)
 	defer os.Remove(testConfigFn)
@@ -104,7 +105,8 @@ func ExampleSetAndGetZone() {
 // zone-ls works. First, no regexp lists all zone configs. Second,
 // regexp properly matches results.
 func ExampleLsZones() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testZoneConfig)
 	defer os.Remove(testConfigFn)
@@ -162,7 +164,8 @@ func ExampleLsZones() {
 // have been removed via zone-ls. Also verify the default zone cannot
 // be removed.
 func ExampleRmZones() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 	testConfigFn := createTestConfigFile(testZoneConfig)
 	defer os.Remove(testConfigFn)
@@ -195,7 +198,8 @@ func ExampleRmZones() {
 // to control the format of the response and the Content-Type header
 // can be used to specify the format of the request.
 func ExampleZoneContentTypes() {
-	httpServer := startAdminServer()
+	httpServer, stopper := startAdminServer()
+	defer stopper.Stop()
 	defer httpServer.Close()
 
 	config := &proto.ZoneConfig{}
@@ -224,13 +228,13 @@ func ExampleZoneContentTypes() {
 				fmt.Println(err)
 			}
 		}
-		req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s%s", adminScheme, *addr, zonePathPrefix, key), bytes.NewReader(body))
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s%s", adminScheme(), *addr, zonePathPrefix, key), bytes.NewReader(body))
 		req.Header.Add("Content-Type", test.contentType)
 		if _, err = sendAdminRequest(req); err != nil {
 			fmt.Println(err)
 		}
 
-		req, err = http.NewRequest("GET", fmt.Sprintf("%s://%s%s%s", adminScheme, *addr, zonePathPrefix, key), nil)
+		req, err = http.NewRequest("GET", fmt.Sprintf("%s://%s%s%s", adminScheme(), *addr, zonePathPrefix, key), nil)
 		req.Header.Add("Accept", test.accept)
 		if body, err = sendAdminRequest(req); err != nil {
 			fmt.Println(err)
@@ -260,7 +264,9 @@ func ExampleZoneContentTypes() {
 	//     }
 	//   ],
 	//   "range_min_bytes": 1048576,
-	//   "range_max_bytes": 67108864
+	//   "range_max_bytes": 67108864,
+	//   "range_max_qps": 0,
+	//   "compression": ""
 	// }
 	// {
 	//   "replica_attrs": [
@@ -284,7 +290,9 @@ func ExampleZoneContentTypes() {
 	//     }
 	//   ],
 	//   "range_min_bytes": 1048576,
-	//   "range_max_bytes": 67108864
+	//   "range_max_bytes": 67108864,
+	//   "range_max_qps": 0,
+	//   "compression": ""
 	// }
 	// replicas:
 	// - attrs: [dc1, ssd]
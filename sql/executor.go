@@ -0,0 +1,478 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// Package sql executes SQL statements over the structured data layer.
+// Statements are served over HTTP and, by the pgwire package, over the
+// PostgreSQL wire protocol.
+package sql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/sql/parser"
+	"github.com/cockroachdb/cockroach/structured"
+)
+
+// SQLSTATE codes reported to clients.
+const (
+	CodeDatatypeMismatch          = "42804"
+	CodeFeatureNotSupported       = "0A000"
+	CodeInternalError             = "XX000"
+	CodeInvalidTableDefinition    = "42P16"
+	CodeInvalidTextRepresentation = "22P02"
+	CodeSyntaxError               = "42601"
+	CodeUndefinedColumn           = "42703"
+	CodeUndefinedObject           = "42704"
+	CodeUndefinedTable            = "42P01"
+)
+
+// An Error is an error reported to clients with a SQLSTATE code.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// sqlColumnTypes maps SQL type names to structured column types.
+var sqlColumnTypes = map[string]string{
+	"bigint":  "integer",
+	"int":     "integer",
+	"int8":    "integer",
+	"integer": "integer",
+	"float":   "float",
+	"float8":  "float",
+	"text":    "string",
+	"varchar": "string",
+	"bytea":   "blob",
+	"blob":    "blob",
+}
+
+// A ResultColumn describes a column of a statement's result.
+type ResultColumn struct {
+	Name string
+	Type string // Structured column type
+}
+
+// A Result is the outcome of a statement: the columns and rows of a
+// query, if any, and the tag reported on completion.
+type Result struct {
+	Columns []ResultColumn
+	Rows    []proto.QueryRow
+	Tag     string
+}
+
+// An Executor executes SQL statements over the structured data layer.
+// An Executor is safe for concurrent use.
+type Executor struct {
+	db     structured.DB
+	kvDB   *client.KV
+	tables *structured.TableCache
+}
+
+// NewExecutor returns an Executor which executes statements through
+// db, reading table data through kvDB.
+func NewExecutor(db structured.DB, kvDB *client.KV) *Executor {
+	return &Executor{db: db, kvDB: kvDB, tables: structured.NewTableCache(kvDB)}
+}
+
+// Execute parses and executes the single statement of sql. Returns a
+// nil result if sql holds no statement. Errors describing invalid or
+// unsupported statements are of type *Error.
+func (e *Executor) Execute(sql string) (*Result, error) {
+	sql = strings.TrimRight(strings.TrimSpace(sql), "; \t\r\n")
+	if sql == "" {
+		return nil, nil
+	}
+	stmt, err := parser.Parse(sql)
+	if err != nil {
+		return nil, newError(CodeSyntaxError, "%s", err)
+	}
+	switch t := stmt.(type) {
+	case *parser.CreateTable:
+		return e.createTable(t)
+	case *parser.Insert:
+		return e.insert(t)
+	case *parser.Select:
+		return e.query(t)
+	case *parser.DDL:
+		switch t.Action {
+		case "SHOW TABLES":
+			return e.showTables()
+		case "SHOW COLUMNS FROM", "SHOW FULL COLUMNS FROM":
+			return e.showColumns(t.Name)
+		}
+	}
+	return nil, newError(CodeFeatureNotSupported, "unsupported statement: %s", stmt)
+}
+
+// createTable creates a table with the columns and primary key of
+// stmt.
+func (e *Executor) createTable(stmt *parser.CreateTable) (*Result, error) {
+	desc := &structured.TableDescriptor{Name: stmt.Name}
+	var primaryKeys int
+	for _, def := range stmt.Defs {
+		switch t := def.(type) {
+		case *parser.ColumnTableDef:
+			typ, ok := sqlColumnTypes[strings.ToLower(t.Type)]
+			if !ok {
+				return nil, newError(CodeUndefinedObject, "type %q does not exist", t.Type)
+			}
+			desc.Columns = append(desc.Columns, structured.ColumnDescriptor{Name: t.Name, Type: typ})
+			if t.PrimaryKey {
+				desc.PrimaryIndex.ColumnNames = []string{t.Name}
+				primaryKeys++
+			}
+		case *parser.PrimaryKeyTableDef:
+			desc.PrimaryIndex.ColumnNames = t.Columns
+			primaryKeys++
+		}
+	}
+	if primaryKeys != 1 {
+		return nil, newError(CodeInvalidTableDefinition, "table %q must have exactly one primary key", stmt.Name)
+	}
+	if err := e.db.CreateTable(desc); err != nil {
+		return nil, err
+	}
+	return &Result{Tag: "CREATE TABLE"}, nil
+}
+
+// insert inserts the rows of stmt's VALUES clause.
+func (e *Executor) insert(stmt *parser.Insert) (*Result, error) {
+	if stmt.OnDup != nil {
+		return nil, newError(CodeFeatureNotSupported, "ON DUPLICATE KEY UPDATE is not supported")
+	}
+	desc, err := e.describeTable(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := stmt.Rows.(parser.Values)
+	if !ok {
+		return nil, newError(CodeFeatureNotSupported, "only INSERT ... VALUES is supported")
+	}
+	var columns []*structured.ColumnDescriptor
+	if stmt.Columns == nil {
+		for i := range desc.Columns {
+			columns = append(columns, &desc.Columns[i])
+		}
+	}
+	for _, expr := range stmt.Columns {
+		c, err := findColumn(desc, expr.(*parser.NonStarExpr).Expr.(*parser.ColName))
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+
+	rows := make([]structured.Row, 0, len(values))
+	for _, tuple := range values {
+		exprs, ok := tuple.(parser.ValTuple)
+		if !ok {
+			return nil, newError(CodeFeatureNotSupported, "subqueries are not supported")
+		}
+		if len(exprs) != len(columns) {
+			return nil, newError(CodeSyntaxError, "INSERT has %d expressions but %d target columns", len(exprs), len(columns))
+		}
+		row := structured.Row{}
+		for i, expr := range exprs {
+			v, err := exprValue(columns[i], expr)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				row[columns[i].Name] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := e.db.InsertRows(desc.Name, rows); err != nil {
+		return nil, err
+	}
+	return &Result{Tag: fmt.Sprintf("INSERT 0 %d", len(rows))}, nil
+}
+
+// query selects the rows of a single table which satisfy a
+// conjunction of equality filters.
+func (e *Executor) query(stmt *parser.Select) (*Result, error) {
+	if stmt.Distinct != "" || stmt.GroupBy != nil || stmt.Having != nil || stmt.OrderBy != nil || stmt.Lock != "" {
+		return nil, newError(CodeFeatureNotSupported, "only SELECT ... FROM ... WHERE ... LIMIT is supported")
+	}
+	var table *parser.TableName
+	if len(stmt.From) == 1 {
+		if t, ok := stmt.From[0].(*parser.AliasedTableExpr); ok && t.As == "" {
+			table, _ = t.Expr.(*parser.TableName)
+		}
+	}
+	if table == nil {
+		return nil, newError(CodeFeatureNotSupported, "only selection from a single, unaliased table is supported")
+	}
+	desc, err := e.describeTable(table)
+	if err != nil {
+		return nil, err
+	}
+	args := &proto.QueryRequest{Table: desc.Name}
+	for _, expr := range stmt.Exprs {
+		switch t := expr.(type) {
+		case *parser.StarExpr:
+			for _, c := range desc.Columns {
+				args.Columns = append(args.Columns, c.Name)
+			}
+		case *parser.NonStarExpr:
+			name, ok := t.Expr.(*parser.ColName)
+			if !ok || t.As != "" {
+				return nil, newError(CodeFeatureNotSupported, "only column names may be selected")
+			}
+			c, err := findColumn(desc, name)
+			if err != nil {
+				return nil, err
+			}
+			args.Columns = append(args.Columns, c.Name)
+		}
+	}
+	if stmt.Where != nil {
+		if args.Predicates, err = predicates(desc, stmt.Where.Expr, nil); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Limit != nil {
+		n, ok := stmt.Limit.Rowcount.(parser.NumVal)
+		if stmt.Limit.Offset != nil || !ok {
+			return nil, newError(CodeFeatureNotSupported, "only constant limits without offset are supported")
+		}
+		if args.Limit, err = strconv.ParseInt(string(n), 10, 64); err != nil || args.Limit < 0 {
+			return nil, newError(CodeInvalidTextRepresentation, "invalid limit %s", n)
+		}
+	}
+
+	res := &Result{}
+	for _, name := range args.Columns {
+		for _, c := range desc.Columns {
+			if c.Name == name {
+				res.Columns = append(res.Columns, ResultColumn{Name: c.Name, Type: c.Type})
+			}
+		}
+	}
+	// A query limit of zero selects all rows.
+	if stmt.Limit == nil || args.Limit > 0 {
+		reply := &proto.QueryResponse{}
+		if err := structured.ExecuteQuery(e.kvDB, e.tables, args, reply); err != nil {
+			return nil, err
+		}
+		res.Rows = reply.Rows
+	}
+	res.Tag = fmt.Sprintf("SELECT %d", len(res.Rows))
+	return res, nil
+}
+
+// showTables lists the names of all tables.
+func (e *Executor) showTables() (*Result, error) {
+	names, err := e.db.ListTables()
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Columns: []ResultColumn{{Name: "table", Type: "string"}}}
+	for _, name := range names {
+		res.Rows = append(res.Rows, proto.QueryRow{Values: []proto.Datum{{StringVal: gogoproto.String(name)}}})
+	}
+	res.Tag = fmt.Sprintf("SELECT %d", len(res.Rows))
+	return res, nil
+}
+
+// showColumns describes the columns of the named table: their names,
+// types and the indexes which include them.
+func (e *Executor) showColumns(table string) (*Result, error) {
+	desc, err := e.describeTable(&parser.TableName{Name: table})
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Columns: []ResultColumn{
+		{Name: "column", Type: "string"},
+		{Name: "type", Type: "string"},
+		{Name: "indexes", Type: "string"},
+	}}
+	for _, c := range desc.Columns {
+		var indexes []string
+		for _, index := range append([]structured.IndexDescriptor{desc.PrimaryIndex}, desc.Indexes...) {
+			name := index.Name
+			if name == "" {
+				name = "primary"
+			}
+			for _, n := range index.ColumnNames {
+				if n == c.Name {
+					indexes = append(indexes, name)
+				}
+			}
+		}
+		res.Rows = append(res.Rows, proto.QueryRow{Values: []proto.Datum{
+			{StringVal: gogoproto.String(c.Name)},
+			{StringVal: gogoproto.String(c.Type)},
+			{StringVal: gogoproto.String(strings.Join(indexes, ", "))},
+		}})
+	}
+	res.Tag = fmt.Sprintf("SELECT %d", len(res.Rows))
+	return res, nil
+}
+
+// describeTable returns the descriptor of the named table.
+func (e *Executor) describeTable(table *parser.TableName) (*structured.TableDescriptor, error) {
+	if table.Qualifier != "" {
+		return nil, newError(CodeFeatureNotSupported, "qualified table names are not supported")
+	}
+	name := strings.ToLower(table.Name)
+	desc, err := e.tables.DescribeTable(name)
+	if err != nil {
+		return nil, err
+	}
+	if desc == nil {
+		return nil, newError(CodeUndefinedTable, "relation %q does not exist", name)
+	}
+	return desc, nil
+}
+
+// predicates appends the predicates of expr, which must be a
+// conjunction of comparisons of columns to constants for equality, to
+// preds.
+func predicates(desc *structured.TableDescriptor, expr parser.BoolExpr, preds []proto.Predicate) ([]proto.Predicate, error) {
+	switch t := expr.(type) {
+	case *parser.AndExpr:
+		preds, err := predicates(desc, t.Left, preds)
+		if err != nil {
+			return nil, err
+		}
+		return predicates(desc, t.Right, preds)
+	case *parser.ParenBoolExpr:
+		return predicates(desc, t.Expr, preds)
+	case *parser.ComparisonExpr:
+		left, right := t.Left, t.Right
+		if _, ok := right.(*parser.ColName); ok {
+			left, right = right, left
+		}
+		name, ok := left.(*parser.ColName)
+		if t.Operator != "=" || !ok {
+			break
+		}
+		c, err := findColumn(desc, name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := exprValue(c, right)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, newError(CodeFeatureNotSupported, "comparison with NULL is not supported")
+		}
+		return append(preds, proto.Predicate{Column: c.Name, Op: proto.EQUAL, Value: makeDatum(v)}), nil
+	}
+	return nil, newError(CodeFeatureNotSupported, "only conjunctions of equality filters are supported")
+}
+
+// findColumn returns the descriptor of the named column of the table.
+func findColumn(desc *structured.TableDescriptor, name *parser.ColName) (*structured.ColumnDescriptor, error) {
+	if name.Qualifier == "" || strings.ToLower(name.Qualifier) == desc.Name {
+		for i := range desc.Columns {
+			if desc.Columns[i].Name == name.Name {
+				return &desc.Columns[i], nil
+			}
+		}
+	}
+	return nil, newError(CodeUndefinedColumn, "column %q does not exist", name)
+}
+
+// exprValue returns the value of the constant expr as a value of
+// column c, or nil if expr is NULL.
+func exprValue(c *structured.ColumnDescriptor, expr parser.ValExpr) (interface{}, error) {
+	numeric := c.Type == "integer" || c.Type == "float"
+	switch t := expr.(type) {
+	case *parser.NullVal:
+		return nil, nil
+	case parser.StrVal:
+		return parseValue(c, string(t))
+	case parser.NumVal:
+		if numeric {
+			return parseValue(c, string(t))
+		}
+	case *parser.UnaryExpr:
+		if n, ok := t.Expr.(parser.NumVal); ok && numeric && t.Operator == '-' {
+			return parseValue(c, "-"+string(n))
+		}
+	}
+	return nil, newError(CodeDatatypeMismatch, "column %q is of type %s but expression is %v", c.Name, c.Type, expr)
+}
+
+// parseValue parses the text representation of a value of column c.
+func parseValue(c *structured.ColumnDescriptor, s string) (interface{}, error) {
+	switch c.Type {
+	case "integer":
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, newError(CodeInvalidTextRepresentation, "invalid input syntax for integer: %q", s)
+		}
+		return i, nil
+	case "float":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, newError(CodeInvalidTextRepresentation, "invalid input syntax for float: %q", s)
+		}
+		return f, nil
+	case "blob":
+		return []byte(s), nil
+	}
+	return s, nil
+}
+
+// makeDatum returns a datum holding v.
+func makeDatum(v interface{}) proto.Datum {
+	switch t := v.(type) {
+	case int64:
+		return proto.Datum{IntVal: gogoproto.Int64(t)}
+	case float64:
+		return proto.Datum{FloatVal: gogoproto.Float64(t)}
+	case string:
+		return proto.Datum{StringVal: gogoproto.String(t)}
+	}
+	return proto.Datum{BytesVal: v.([]byte)}
+}
+
+// FormatDatum returns the text representation of d, in which blobs are
+// hex encoded, and false if d is NULL.
+func FormatDatum(d proto.Datum) (string, bool) {
+	switch {
+	case d.IntVal != nil:
+		return strconv.FormatInt(*d.IntVal, 10), true
+	case d.FloatVal != nil:
+		return strconv.FormatFloat(*d.FloatVal, 'g', -1, 64), true
+	case d.StringVal != nil:
+		return *d.StringVal, true
+	case d.BytesVal != nil:
+		return `\x` + hex.EncodeToString(d.BytesVal), true
+	}
+	return "", false
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Endpoint is the path at which statements are executed over HTTP.
+const Endpoint = "/sql"
+
+// A Request is the JSON body of a statement POSTed to Endpoint.
+type Request struct {
+	Statement string `json:"statement"`
+}
+
+// A Response is the JSON body returned for a Request. If the
+// statement failed, only Error is set. NULL values are reported as
+// nil.
+type Response struct {
+	Columns []string    `json:"columns,omitempty"`
+	Rows    [][]*string `json:"rows,omitempty"`
+	Tag     string      `json:"tag,omitempty"`
+	Error   *Error      `json:"error,omitempty"`
+}
+
+// An HTTPServer executes statements POSTed as JSON Requests.
+type HTTPServer struct {
+	executor *Executor
+}
+
+// NewHTTPServer returns an HTTPServer which executes statements with
+// executor.
+func NewHTTPServer(executor *Executor) *HTTPServer {
+	return &HTTPServer{executor: executor}
+}
+
+// ServeHTTP implements the http.Handler interface. Malformed requests
+// fail with a bad request status; statements which fail to execute
+// are reported in the Error field of the response.
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("unhandled HTTP method %s: %s", r.Method, http.StatusText(http.StatusBadRequest)), http.StatusBadRequest)
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+	var resp Response
+	res, err := s.executor.Execute(req.Statement)
+	if err != nil {
+		sqlErr, ok := err.(*Error)
+		if !ok {
+			sqlErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		resp.Error = sqlErr
+	} else if res != nil {
+		for _, col := range res.Columns {
+			resp.Columns = append(resp.Columns, col.Name)
+		}
+		for _, row := range res.Rows {
+			values := make([]*string, len(row.Values))
+			for i, d := range row.Values {
+				if v, ok := FormatDatum(d); ok {
+					values[i] = &v
+				}
+			}
+			resp.Rows = append(resp.Rows, values)
+		}
+		resp.Tag = res.Tag
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package sql_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/server"
+	"github.com/cockroachdb/cockroach/sql"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/structured"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestHTTPServer verifies the execution of statements POSTed to an
+// HTTPServer, including the listing and description of tables.
+func TestHTTPServer(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	localDB, err := server.BootstrapCluster("test-cluster", e, stopper)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	s := httptest.NewServer(sql.NewHTTPServer(sql.NewExecutor(structured.NewDB(localDB, stopper), localDB)))
+	defer s.Close()
+
+	str := func(s string) *string { return &s }
+	testCases := []struct {
+		statement string
+		expResp   sql.Response
+	}{
+		{"CREATE TABLE users (id INT PRIMARY KEY, name TEXT)", sql.Response{Tag: "CREATE TABLE"}},
+		{"INSERT INTO users VALUES (1, 'alice'), (2, NULL)", sql.Response{Tag: "INSERT 0 2"}},
+		{"SELECT * FROM users", sql.Response{
+			Columns: []string{"id", "name"},
+			Rows:    [][]*string{{str("1"), str("alice")}, {str("2"), nil}},
+			Tag:     "SELECT 2",
+		}},
+		{"SHOW TABLES", sql.Response{
+			Columns: []string{"table"},
+			Rows:    [][]*string{{str("users")}},
+			Tag:     "SELECT 1",
+		}},
+		{"SHOW COLUMNS FROM users", sql.Response{
+			Columns: []string{"column", "type", "indexes"},
+			Rows: [][]*string{
+				{str("id"), str("integer"), str("primary")},
+				{str("name"), str("string"), str("")},
+			},
+			Tag: "SELECT 2",
+		}},
+		{"", sql.Response{}},
+		{"SELECT id FROM missing", sql.Response{
+			Error: &sql.Error{Code: sql.CodeUndefinedTable, Message: `relation "missing" does not exist`},
+		}},
+	}
+	for i, test := range testCases {
+		body, err := json.Marshal(&sql.Request{Statement: test.statement})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(s.URL+sql.Endpoint, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res sql.Response
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !reflect.DeepEqual(res, test.expResp) {
+			t.Errorf("%d: expected %+v; got %+v", i, test.expResp, res)
+		}
+	}
+
+	// Malformed requests and methods other than POST are rejected.
+	if resp, err := http.Post(s.URL+sql.Endpoint, "application/json", bytes.NewReader([]byte("{"))); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for malformed body; got %s", resp.Status)
+	}
+	if resp, err := http.Get(s.URL + sql.Endpoint); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for GET; got %s", resp.Status)
+	}
+}
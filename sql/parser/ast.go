@@ -35,14 +35,15 @@ type Statement interface {
 	statement()
 }
 
-func (*Union) statement()  {}
-func (*Select) statement() {}
-func (*Insert) statement() {}
-func (*Update) statement() {}
-func (*Delete) statement() {}
-func (*Set) statement()    {}
-func (*Use) statement()    {}
-func (*DDL) statement()    {}
+func (*Union) statement()       {}
+func (*Select) statement()      {}
+func (*Insert) statement()      {}
+func (*Update) statement()      {}
+func (*Delete) statement()      {}
+func (*Set) statement()         {}
+func (*Use) statement()         {}
+func (*DDL) statement()         {}
+func (*CreateTable) statement() {}
 
 // SelectStatement any SELECT statement.
 type SelectStatement interface {
@@ -225,6 +226,77 @@ func (node *DDL) String() string {
 	}
 }
 
+// CreateTable represents a CREATE TABLE statement with table
+// definitions. A CREATE TABLE statement without definitions is
+// represented by DDL.
+type CreateTable struct {
+	Name string
+	Defs TableDefs
+}
+
+func (node *CreateTable) String() string {
+	return fmt.Sprintf("%s %s (%v)", astCreateTable, node.Name, node.Defs)
+}
+
+// TableDefs represents a list of table definitions.
+type TableDefs []TableDef
+
+func (node TableDefs) String() string {
+	var prefix string
+	var buf bytes.Buffer
+	for _, n := range node {
+		fmt.Fprintf(&buf, "%s%v", prefix, n)
+		prefix = ", "
+	}
+	return buf.String()
+}
+
+// TableDef represents a column or constraint definition within a
+// CREATE TABLE statement.
+type TableDef interface {
+	fmt.Stringer
+	tableDef()
+}
+
+func (*ColumnTableDef) tableDef()     {}
+func (*PrimaryKeyTableDef) tableDef() {}
+
+// ColumnTableDef represents a column definition.
+type ColumnTableDef struct {
+	Name       string
+	Type       string
+	PrimaryKey bool
+}
+
+func (node *ColumnTableDef) String() string {
+	var buf bytes.Buffer
+	escape(&buf, node.Name)
+	fmt.Fprintf(&buf, " %s", node.Type)
+	if node.PrimaryKey {
+		fmt.Fprintf(&buf, " PRIMARY KEY")
+	}
+	return buf.String()
+}
+
+// PrimaryKeyTableDef represents a PRIMARY KEY constraint over one or
+// more columns.
+type PrimaryKeyTableDef struct {
+	Columns []string
+}
+
+func (node *PrimaryKeyTableDef) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PRIMARY KEY (")
+	for i, n := range node.Columns {
+		if i > 0 {
+			fmt.Fprintf(&buf, ", ")
+		}
+		escape(&buf, n)
+	}
+	fmt.Fprintf(&buf, ")")
+	return buf.String()
+}
+
 // Comments represents a list of comments.
 type Comments []string
 
@@ -175,6 +175,9 @@ CREATE DATABASE a
 CREATE DATABASE IF NOT EXISTS a#CREATE DATABASE a
 CREATE TABLE a
 CREATE TABLE if NOT EXISTS a#CREATE TABLE a
+CREATE TABLE a (b int, c text)
+CREATE TABLE a (b INT PRIMARY KEY, c TEXT)#CREATE TABLE a (b int PRIMARY KEY, c text)
+CREATE TABLE IF NOT EXISTS a (b int, c text, PRIMARY KEY (c, b))#CREATE TABLE a (b int, c text, PRIMARY KEY (c, b))
 CREATE INDEX a ON b
 CREATE unique INDEX a ON b#CREATE INDEX a ON b
 CREATE unique INDEX a using foo ON b#CREATE INDEX a ON b
@@ -53,6 +53,9 @@ func forceEOF(yylex interface{}) {
   insRows     InsertRows
   updateExprs UpdateExprs
   updateExpr  *UpdateExpr
+  boolVal     bool
+  tableDefs   TableDefs
+  tableDef    TableDef
 }
 
 %token tokLexError
@@ -78,7 +81,7 @@ func forceEOF(yylex interface{}) {
 
 // DDL Tokens
 %token <empty> tokCreate tokAlter tokDrop tokRename tokTruncate tokShow
-%token <empty> tokDatabase tokTable tokTables tokIndex tokView tokColumns tokFull tokTo tokIgnore tokIf tokUnique
+%token <empty> tokDatabase tokTable tokTables tokIndex tokView tokColumns tokFull tokTo tokIgnore tokIf tokUnique tokPrimary
 
 %start any_command
 
@@ -128,6 +131,10 @@ func forceEOF(yylex interface{}) {
 %type <updateExprs> update_list
 %type <updateExpr> update_expression
 %type <empty> exists_opt not_exists_opt ignore_opt non_rename_operation to_opt constraint_opt using_opt
+%type <tableDefs> table_definition_list
+%type <tableDef> table_definition
+%type <boolVal> primary_key_opt
+%type <str2> index_column_list
 %type <str> sql_id
 %type <empty> force_eof
 
@@ -225,7 +232,11 @@ show_statement:
   }
 
 create_statement:
-  tokCreate tokTable not_exists_opt sql_id force_eof
+  tokCreate tokTable not_exists_opt sql_id '(' table_definition_list ')'
+  {
+    $$ = &CreateTable{Name: $4, Defs: $6}
+  }
+| tokCreate tokTable not_exists_opt sql_id force_eof
   {
     $$ = &DDL{Action: astCreateTable, NewName: $4}
   }
@@ -242,6 +253,45 @@ create_statement:
     $$ = &DDL{Action: astCreateDatabase, NewName: $4}
   }
 
+table_definition_list:
+  table_definition
+  {
+    $$ = TableDefs{$1}
+  }
+| table_definition_list ',' table_definition
+  {
+    $$ = append($1, $3)
+  }
+
+table_definition:
+  sql_id sql_id primary_key_opt
+  {
+    $$ = &ColumnTableDef{Name: $1, Type: $2, PrimaryKey: $3}
+  }
+| tokPrimary tokKey '(' index_column_list ')'
+  {
+    $$ = &PrimaryKeyTableDef{Columns: $4}
+  }
+
+primary_key_opt:
+  {
+    $$ = false
+  }
+| tokPrimary tokKey
+  {
+    $$ = true
+  }
+
+index_column_list:
+  sql_id
+  {
+    $$ = []string{$1}
+  }
+| index_column_list ',' sql_id
+  {
+    $$ = append($1, $3)
+  }
+
 alter_statement:
   tokAlter ignore_opt tokTable tokID non_rename_operation force_eof
   {
@@ -106,6 +106,7 @@ var keywords = map[string]int{
 	"IGNORE":   tokIgnore,
 	"IF":       tokIf,
 	"UNIQUE":   tokUnique,
+	"PRIMARY":  tokPrimary,
 	"USING":    tokUsing,
 }
 
@@ -0,0 +1,316 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package pgwire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/sql"
+)
+
+const (
+	// version30 is the startup message code of protocol version 3.0.
+	version30 = 196608
+	// versionSSL is the startup message code of SSL requests, which are
+	// declined.
+	versionSSL = 80877103
+	// maxMessageSize bounds the size of messages read from clients.
+	maxMessageSize = 1 << 24
+)
+
+// Frontend message types.
+const (
+	clientMsgQuery     = 'Q'
+	clientMsgSync      = 'S'
+	clientMsgTerminate = 'X'
+)
+
+// Backend message types.
+const (
+	serverMsgAuth            = 'R'
+	serverMsgCommandComplete = 'C'
+	serverMsgDataRow         = 'D'
+	serverMsgEmptyQuery      = 'I'
+	serverMsgErrorResponse   = 'E'
+	serverMsgParameterStatus = 'S'
+	serverMsgReady           = 'Z'
+	serverMsgRowDescription  = 'T'
+)
+
+// serverParameters are reported to clients once they've connected.
+var serverParameters = []struct{ name, value string }{
+	{"client_encoding", "UTF8"},
+	{"DateStyle", "ISO"},
+	{"integer_datetimes", "on"},
+	{"server_encoding", "UTF8"},
+	{"server_version", "9.4.0"},
+	{"standard_conforming_strings", "on"},
+}
+
+// typeOIDs maps structured column types to the OIDs and sizes of the
+// Postgres types reported for them.
+var typeOIDs = map[string]struct {
+	oid  int32
+	size int16
+}{
+	"integer": {20, 8},  // int8
+	"float":   {701, 8}, // float8
+	"string":  {25, -1}, // text
+	"blob":    {17, -1}, // bytea
+}
+
+// A conn serves a single client connection.
+type conn struct {
+	rd       *bufio.Reader
+	wr       *bufio.Writer
+	executor *sql.Executor
+}
+
+func newConn(netConn net.Conn, executor *sql.Executor) *conn {
+	return &conn{
+		rd:       bufio.NewReader(netConn),
+		wr:       bufio.NewWriter(netConn),
+		executor: executor,
+	}
+}
+
+// serve performs the startup handshake and then executes the client's
+// queries until it terminates the connection.
+func (c *conn) serve() error {
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	// Messages of the extended query protocol aren't supported. Once
+	// one has been refused, the rest are discarded until the client
+	// synchronizes.
+	discarding := false
+	for {
+		typ, body, err := c.readMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch typ {
+		case clientMsgTerminate:
+			return nil
+		case clientMsgQuery:
+			query, _, err := readString(body)
+			if err != nil {
+				return err
+			}
+			c.handleQuery(query)
+			c.sendReady()
+		case clientMsgSync:
+			discarding = false
+			c.sendReady()
+		default:
+			if !discarding {
+				c.sendError(&sql.Error{Code: sql.CodeFeatureNotSupported,
+					Message: fmt.Sprintf("unsupported message type %q: only the simple query protocol is supported", typ)})
+				discarding = true
+			}
+		}
+		if err := c.wr.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// handshake reads the client's startup message, declining any SSL
+// requests preceding it, and reports the connection ready.
+func (c *conn) handshake() error {
+	for {
+		var size int32
+		if err := binary.Read(c.rd, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		if size < 8 || size > maxMessageSize {
+			return fmt.Errorf("invalid startup message size %d", size)
+		}
+		body := make([]byte, size-4)
+		if _, err := io.ReadFull(c.rd, body); err != nil {
+			return err
+		}
+		version := binary.BigEndian.Uint32(body)
+		if version == version30 {
+			break
+		}
+		if version != versionSSL {
+			return fmt.Errorf("unsupported protocol version %d", version)
+		}
+		if err := c.wr.WriteByte('N'); err != nil {
+			return err
+		}
+		if err := c.wr.Flush(); err != nil {
+			return err
+		}
+	}
+
+	// Startup parameters, such as the user and database, are ignored.
+	var buf writeBuffer
+	buf.putInt32(0) // AuthenticationOk
+	c.writeMessage(serverMsgAuth, &buf)
+	for _, p := range serverParameters {
+		buf.putString(p.name)
+		buf.putString(p.value)
+		c.writeMessage(serverMsgParameterStatus, &buf)
+	}
+	c.sendReady()
+	return c.wr.Flush()
+}
+
+// readMessage reads a message, returning its type and body.
+func (c *conn) readMessage() (byte, []byte, error) {
+	typ, err := c.rd.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size int32
+	if err := binary.Read(c.rd, binary.BigEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	if size < 4 || size > maxMessageSize {
+		return 0, nil, fmt.Errorf("invalid size %d of message type %q", size, typ)
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(c.rd, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+// handleQuery executes the statement of a simple query and sends its
+// result.
+func (c *conn) handleQuery(query string) {
+	res, err := c.executor.Execute(query)
+	if err != nil {
+		c.sendError(err)
+		return
+	}
+	if res == nil {
+		c.writeMessage(serverMsgEmptyQuery, &writeBuffer{})
+		return
+	}
+	var buf writeBuffer
+	if res.Columns != nil {
+		buf.putInt16(int16(len(res.Columns)))
+		for _, col := range res.Columns {
+			typ := typeOIDs[col.Type]
+			buf.putString(col.Name)
+			buf.putInt32(0) // Table OID
+			buf.putInt16(0) // Column attribute number
+			buf.putInt32(typ.oid)
+			buf.putInt16(typ.size)
+			buf.putInt32(-1) // Type modifier
+			buf.putInt16(0)  // Text format
+		}
+		c.writeMessage(serverMsgRowDescription, &buf)
+		for _, row := range res.Rows {
+			buf.putInt16(int16(len(row.Values)))
+			for _, d := range row.Values {
+				buf.putDatum(d)
+			}
+			c.writeMessage(serverMsgDataRow, &buf)
+		}
+	}
+	buf.putString(res.Tag)
+	c.writeMessage(serverMsgCommandComplete, &buf)
+}
+
+// sendError sends err to the client with the SQLSTATE code of a
+// sql.Error, or that of an internal error otherwise.
+func (c *conn) sendError(err error) {
+	code := sql.CodeInternalError
+	if sqlErr, ok := err.(*sql.Error); ok {
+		code = sqlErr.Code
+	}
+	var buf writeBuffer
+	buf.WriteByte('S')
+	buf.putString("ERROR")
+	buf.WriteByte('C')
+	buf.putString(code)
+	buf.WriteByte('M')
+	buf.putString(err.Error())
+	buf.WriteByte(0)
+	c.writeMessage(serverMsgErrorResponse, &buf)
+}
+
+// sendReady reports that the connection is idle and ready for the
+// next query.
+func (c *conn) sendReady() {
+	var buf writeBuffer
+	buf.WriteByte('I')
+	c.writeMessage(serverMsgReady, &buf)
+}
+
+// writeMessage buffers a message of the given type with the contents
+// of buf as body, resetting buf. Write errors surface on flush.
+func (c *conn) writeMessage(typ byte, buf *writeBuffer) {
+	c.wr.WriteByte(typ)
+	binary.Write(c.wr, binary.BigEndian, int32(buf.Len()+4))
+	c.wr.Write(buf.Bytes())
+	buf.Reset()
+}
+
+// readString returns the null-terminated string at the head of b and
+// the remainder of b.
+func readString(b []byte) (string, []byte, error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, fmt.Errorf("missing string terminator")
+	}
+	return string(b[:i]), b[i+1:], nil
+}
+
+// A writeBuffer accumulates the body of a backend message.
+type writeBuffer struct {
+	bytes.Buffer
+}
+
+func (b *writeBuffer) putInt16(v int16) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *writeBuffer) putInt32(v int32) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+// putString writes s as a null-terminated string.
+func (b *writeBuffer) putString(s string) {
+	b.WriteString(s)
+	b.WriteByte(0)
+}
+
+// putDatum writes the length-prefixed text representation of d, or a
+// length of -1 if d is NULL.
+func (b *writeBuffer) putDatum(d proto.Datum) {
+	s, ok := sql.FormatDatum(d)
+	if !ok {
+		b.putInt32(-1)
+		return
+	}
+	b.putInt32(int32(len(s)))
+	b.WriteString(s)
+}
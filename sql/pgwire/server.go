@@ -0,0 +1,132 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// Package pgwire serves version 3 of the PostgreSQL frontend/backend
+// protocol, executing the statements of simple queries over the
+// structured data layer. It lets off-the-shelf Postgres clients and
+// tools connect during early development.
+package pgwire
+
+import (
+	"net"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/sql"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A Server accepts PostgreSQL wire protocol connections and executes
+// their queries. Only the simple query protocol is supported, and
+// only the statements supported by sql.Executor may be executed.
+// Connections are neither authenticated nor encrypted.
+type Server struct {
+	addr     string
+	executor *sql.Executor
+
+	mu       sync.Mutex            // Mutex protects the fields below
+	listener net.Listener          // nil until started
+	conns    map[net.Conn]struct{} // Open connections
+	closed   bool                  // Set once the server is closed
+}
+
+// NewServer returns a Server which listens on addr once started and
+// executes statements with executor.
+func NewServer(addr string, executor *sql.Executor) *Server {
+	return &Server{
+		addr:     addr,
+		executor: executor,
+		conns:    map[net.Conn]struct{}{},
+	}
+}
+
+// Start binds the server's address and serves connections until the
+// stopper is stopped, at which point the listener and all open
+// connections are closed. Use Addr() to ascertain the address bound.
+func (s *Server) Start(stopper *util.Stopper) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return util.Errorf("could not listen on %s: %s", s.addr, err)
+	}
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	stopper.RunWorker(func() {
+		<-stopper.ShouldStop()
+		s.close()
+	})
+	stopper.RunWorker(func() {
+		log.Infof("serving PostgreSQL wire protocol on %s", ln.Addr())
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				s.mu.Lock()
+				if !s.closed {
+					log.Errorf("PostgreSQL wire protocol server terminated: %s", err)
+				}
+				s.mu.Unlock()
+				return
+			}
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+			s.conns[conn] = struct{}{}
+			s.mu.Unlock()
+			go s.serveConn(conn)
+		}
+	})
+	return nil
+}
+
+// Addr returns the address the server is bound to, or nil if it
+// hasn't been started.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// serveConn serves a single connection to completion and closes it.
+func (s *Server) serveConn(netConn net.Conn) {
+	if err := newConn(netConn, s.executor).serve(); err != nil {
+		log.Warningf("PostgreSQL wire protocol connection from %s failed: %s", netConn.RemoteAddr(), err)
+	}
+	s.mu.Lock()
+	delete(s.conns, netConn)
+	s.mu.Unlock()
+	netConn.Close()
+}
+
+// close closes the listener and all open connections.
+func (s *Server) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
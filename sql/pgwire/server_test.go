@@ -0,0 +1,225 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package pgwire_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/server"
+	"github.com/cockroachdb/cockroach/sql"
+	"github.com/cockroachdb/cockroach/sql/pgwire"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/structured"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// testClient speaks the simple query protocol to a pgwire.Server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// queryResult holds the messages sent in response to a query.
+type queryResult struct {
+	columns []string
+	rows    [][]string // NULLs are reported as "NULL"
+	tag     string
+	err     string // the message of an error response
+}
+
+// newTestClient connects to addr, requesting SSL first to verify that
+// the request is declined.
+func newTestClient(t *testing.T, addr net.Addr) *testClient {
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, conn: conn, rd: bufio.NewReader(conn)}
+	c.write(0, 80877103) // SSLRequest
+	if b, err := c.rd.ReadByte(); err != nil || b != 'N' {
+		t.Fatalf("expected SSL request to be declined; got %q, %v", b, err)
+	}
+	c.write(0, 196608, "user", "root", "") // StartupMessage
+	if res := c.readResult(); res.err != "" {
+		t.Fatalf("startup failed: %s", res.err)
+	}
+	return c
+}
+
+// write sends a message of the given type, omitted if zero, whose body
+// holds the given int32s and null-terminated strings.
+func (c *testClient) write(typ byte, fields ...interface{}) {
+	var body bytes.Buffer
+	for _, f := range fields {
+		switch t := f.(type) {
+		case int:
+			binary.Write(&body, binary.BigEndian, int32(t))
+		case string:
+			body.WriteString(t)
+			body.WriteByte(0)
+		}
+	}
+	var msg bytes.Buffer
+	if typ != 0 {
+		msg.WriteByte(typ)
+	}
+	binary.Write(&msg, binary.BigEndian, int32(body.Len()+4))
+	msg.Write(body.Bytes())
+	if _, err := c.conn.Write(msg.Bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// readResult reads messages up to and including ReadyForQuery.
+func (c *testClient) readResult() queryResult {
+	var res queryResult
+	for {
+		typ, err := c.rd.ReadByte()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		var size int32
+		if err := binary.Read(c.rd, binary.BigEndian, &size); err != nil {
+			c.t.Fatal(err)
+		}
+		body := make([]byte, size-4)
+		if _, err := io.ReadFull(c.rd, body); err != nil {
+			c.t.Fatal(err)
+		}
+		switch typ {
+		case 'T':
+			fields := body[2:]
+			for len(fields) > 0 {
+				i := bytes.IndexByte(fields, 0)
+				res.columns = append(res.columns, string(fields[:i]))
+				fields = fields[i+1+18:]
+			}
+		case 'D':
+			var row []string
+			values := body[2:]
+			for len(values) > 0 {
+				size := int32(binary.BigEndian.Uint32(values))
+				values = values[4:]
+				if size < 0 {
+					row = append(row, "NULL")
+					continue
+				}
+				row = append(row, string(values[:size]))
+				values = values[size:]
+			}
+			res.rows = append(res.rows, row)
+		case 'C':
+			res.tag = string(body[:len(body)-1])
+		case 'E':
+			for _, field := range bytes.Split(body, []byte{0}) {
+				if len(field) > 0 && field[0] == 'M' {
+					res.err = string(field[1:])
+				}
+			}
+		case 'Z':
+			return res
+		}
+	}
+}
+
+// query executes the simple query sql.
+func (c *testClient) query(sql string) queryResult {
+	c.write('Q', sql)
+	return c.readResult()
+}
+
+// TestPGWire verifies the creation of, insertion into and querying of
+// tables through the PostgreSQL wire protocol.
+func TestPGWire(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	localDB, err := server.BootstrapCluster("test-cluster", e, stopper)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	s := pgwire.NewServer("127.0.0.1:0", sql.NewExecutor(structured.NewDB(localDB, stopper), localDB))
+	if err := s.Start(stopper); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, s.Addr())
+	defer c.conn.Close()
+
+	testCases := []struct {
+		sql     string
+		columns []string
+		rows    [][]string
+		tag     string
+		err     string
+	}{
+		{"CREATE TABLE users (id INT PRIMARY KEY, name TEXT, score FLOAT);", nil, nil, "CREATE TABLE", ""},
+		{"INSERT INTO users VALUES (1, 'alice', 1.5), (2, 'bob', NULL)", nil, nil, "INSERT 0 2", ""},
+		{"INSERT INTO users (name, id) VALUES ('carol', -3)", nil, nil, "INSERT 0 1", ""},
+		{"SELECT * FROM users", []string{"id", "name", "score"},
+			[][]string{{"-3", "carol", "NULL"}, {"1", "alice", "1.5"}, {"2", "bob", "NULL"}}, "SELECT 3", ""},
+		{"SELECT name FROM users WHERE id = 2", []string{"name"}, [][]string{{"bob"}}, "SELECT 1", ""},
+		{"SELECT id, name FROM users WHERE name = 'alice' AND id = 1", []string{"id", "name"},
+			[][]string{{"1", "alice"}}, "SELECT 1", ""},
+		{"SELECT id FROM users LIMIT 1", []string{"id"}, [][]string{{"-3"}}, "SELECT 1", ""},
+		{"SELECT id FROM users WHERE id = 4", []string{"id"}, nil, "SELECT 0", ""},
+		{"", nil, nil, "", ""},
+		{"INSERT INTO users VALUES (1, 'dave', 2.0)", nil, nil, "", "duplicate primary key"},
+		{"INSERT INTO users VALUES ('x', 'dave', 2.0)", nil, nil, "", "invalid input syntax"},
+		{"SELECT id FROM users WHERE id > 1", nil, nil, "", "equality filters"},
+		{"SELECT id FROM missing", nil, nil, "", "does not exist"},
+		{"SELECT missing FROM users", nil, nil, "", "does not exist"},
+		{"DELETE FROM users", nil, nil, "", "unsupported statement"},
+		{"SELECT FROM", nil, nil, "", "syntax error"},
+	}
+	for i, test := range testCases {
+		res := c.query(test.sql)
+		if test.err != "" {
+			if !strings.Contains(res.err, test.err) {
+				t.Errorf("%d: expected error %q; got %q", i, test.err, res.err)
+			}
+			continue
+		}
+		if res.err != "" {
+			t.Errorf("%d: unexpected error: %s", i, res.err)
+			continue
+		}
+		if !reflect.DeepEqual(res.columns, test.columns) || !reflect.DeepEqual(res.rows, test.rows) || res.tag != test.tag {
+			t.Errorf("%d: expected %v %v %q; got %v %v %q", i, test.columns, test.rows, test.tag, res.columns, res.rows, res.tag)
+		}
+	}
+
+	// Extended protocol messages are refused until the client
+	// synchronizes.
+	c.write('P', "", "SELECT id FROM users", 0)
+	c.write('S')
+	if res := c.readResult(); !strings.Contains(res.err, "simple query protocol") {
+		t.Errorf("expected extended protocol to be refused; got %+v", res)
+	}
+	if res := c.query("SELECT id FROM users WHERE id = 1"); res.err != "" || len(res.rows) != 1 {
+		t.Errorf("expected query to succeed after sync; got %+v", res)
+	}
+}
@@ -34,17 +34,29 @@ type StoreFinder func(proto.Attributes) ([]*StoreDescriptor, error)
 // engine-backed range they describe. Information on suitability and
 // availability of servers is gleaned from the gossip network.
 type allocator struct {
-	storeFinder StoreFinder
-	rand        rand.Rand
+	storeFinder    StoreFinder
+	rand           rand.Rand
+	loadThresholds LoadThresholds // Stores on overloaded nodes are avoided
 }
 
 // allocate returns a suitable store based on the supplied
-// attributes list. If none are available / suitable, returns an
-// error. It uses the allocator's StoreFinder to select the set of
-// available stores matching attributes for missing replicas and picks
-// using randomly weighted selection based on available capacities.
-func (a *allocator) allocate(required proto.Attributes, existingReplicas []proto.Replica) (
-	*StoreDescriptor, error) {
+// attributes list and placement constraints. If none are available /
+// suitable, returns an error. It uses the allocator's StoreFinder to
+// select the set of available stores matching attributes for missing
+// replicas. Of the stores on nodes without a replica which satisfy the
+// constraints, those whose localities are most diverse from the
+// existing replicas' are preferred (see diversityScore). It then picks
+// using randomly weighted selection based on available capacities,
+// discounted by each store's request load relative to the mean.
+// Stores on overloaded nodes are only picked if there are no others.
+//
+// TODO(agent): new replicas can't yet be seeded with snapshots, so
+// nothing acts on the allocator's choice. Once they can, fetch the new
+// replica's snapshot from the replica chosen by chooseSnapshotSender,
+// after InternalReserveSnapshot has reserved space on the chosen
+// store; if the reservation is refused, allocate another store.
+func (a *allocator) allocate(required proto.Attributes, constraints []proto.Constraint,
+	existingReplicas []proto.Replica) (*StoreDescriptor, error) {
 	// Get a set of current nodes -- we never want to allocate on an existing node.
 	usedNodes := make(map[int32]struct{})
 	for _, replica := range existingReplicas {
@@ -55,27 +67,188 @@ func (a *allocator) allocate(required proto.Attributes, existingReplicas []proto
 	if err != nil {
 		return nil, err
 	}
+	existing, err := a.replicaStores(existingReplicas)
+	if err != nil {
+		return nil, err
+	}
+	existingLocalities := make([]proto.Locality, len(existing))
+	for i, s := range existing {
+		existingLocalities[i] = s.Node.Locality
+	}
 
-	// Randomly pick a node weighted by capacity.
-	var candidates []*StoreDescriptor
-	var capacityTotal float64
+	// Of the stores satisfying the constraints, keep those most
+	// diverse from the existing replicas.
+	var diverse []*StoreDescriptor
+	var maxScore float64
 	for _, s := range stores {
-		if _, ok := usedNodes[s.Node.NodeID]; !ok {
+		if _, ok := usedNodes[s.Node.NodeID]; ok {
+			continue
+		}
+		if !permitsStore(constraints, s, existingLocalities) {
+			continue
+		}
+		score := diversityScore(s, existing)
+		if len(diverse) == 0 || score > maxScore {
+			diverse, maxScore = nil, score
+		}
+		if score == maxScore {
+			diverse = append(diverse, s)
+		}
+	}
+
+	// Randomly pick a node weighted by capacity and load, preferring
+	// nodes which aren't overloaded so that hot nodes don't continue to
+	// attract replicas.
+	var candidates, overloaded []*StoreDescriptor
+	for _, s := range diverse {
+		if s.Node.Load.Overloaded(a.loadThresholds) {
+			overloaded = append(overloaded, s)
+		} else {
 			candidates = append(candidates, s)
-			capacityTotal += s.Capacity.PercentAvail()
 		}
 	}
+	if len(candidates) == 0 {
+		candidates = overloaded
+	}
+	var qpsTotal float64
+	for _, c := range candidates {
+		qpsTotal += c.QPS
+	}
+	weights := make([]float64, len(candidates))
+	var weightTotal float64
+	for i, c := range candidates {
+		weights[i] = allocationWeight(c, qpsTotal/float64(len(candidates)))
+		weightTotal += weights[i]
+	}
 
-	var capacitySeen float64
-	targetCapacity := a.rand.Float64() * capacityTotal
+	var weightSeen float64
+	targetWeight := a.rand.Float64() * weightTotal
 
 	// Walk through candidates, stopping when
-	// we've passed the capacity target.
-	for _, c := range candidates {
-		capacitySeen += c.Capacity.PercentAvail()
-		if capacitySeen >= targetCapacity {
+	// we've passed the weight target.
+	for i, c := range candidates {
+		weightSeen += weights[i]
+		if weightSeen >= targetWeight {
 			return c, nil
 		}
 	}
 	return nil, util.Errorf("unable to find an appropriate store for requested replica attributes")
 }
+
+// replicaStores returns the descriptors of the stores holding
+// replicas, in the same order. Replicas on stores for which the
+// StoreFinder has no descriptor are omitted.
+func (a *allocator) replicaStores(replicas []proto.Replica) ([]*StoreDescriptor, error) {
+	if len(replicas) == 0 {
+		return nil, nil
+	}
+	stores, err := a.storeFinder(proto.Attributes{})
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int32]*StoreDescriptor, len(stores))
+	for _, s := range stores {
+		byID[s.StoreID] = s
+	}
+	var found []*StoreDescriptor
+	for _, replica := range replicas {
+		if s, ok := byID[replica.StoreID]; ok {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+// permitsStore returns whether all of the constraints permit a
+// replica on the store, alongside replicas on nodes with the
+// localities in others.
+func permitsStore(constraints []proto.Constraint, s *StoreDescriptor, others []proto.Locality) bool {
+	for _, c := range constraints {
+		if !c.Permits(s.Node.Locality, others) {
+			return false
+		}
+	}
+	return true
+}
+
+// diversityScore returns the mean diversity of the locality of the
+// store's node from those of the stores holding existing replicas,
+// from 0 if they're all alike to 1 if they all differ in their most
+// inclusive tier. Replicas spread across diverse localities survive
+// the loss of a rack, zone or region.
+func diversityScore(s *StoreDescriptor, existing []*StoreDescriptor) float64 {
+	if len(existing) == 0 {
+		return 0
+	}
+	var total float64
+	for _, e := range existing {
+		total += s.Node.Locality.DiversityScore(e.Node.Locality)
+	}
+	return total / float64(len(existing))
+}
+
+// A replicaRepair describes the replacement of a replica which
+// violates its zone's placement constraints.
+type replicaRepair struct {
+	remove     proto.Replica    // The violating replica
+	constraint proto.Constraint // The constraint it violates
+	add        *StoreDescriptor // The store to place the replacement on
+}
+
+// repair returns the replacement for the first of replicas which
+// violates the zone's placement constraints, or nil if none do. If no
+// store is suitable for the replacement, the repair is returned
+// without one, along with the allocation error. Unique
+// constraints are violated by the replicas after the first in each
+// value of the tier. The replacement is allocated as though the
+// violating replica had already been removed, with the attributes
+// the zone requires of the replica at its position. Replicas on stores
+// without a known descriptor can't be verified and are skipped.
+func (a *allocator) repair(zone *proto.ZoneConfig, replicas []proto.Replica) (*replicaRepair, error) {
+	constraints, err := proto.ParseConstraints(zone.Constraints)
+	if err != nil || len(constraints) == 0 {
+		return nil, err
+	}
+	stores, err := a.replicaStores(replicas)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int32]*StoreDescriptor, len(stores))
+	for _, s := range stores {
+		byID[s.StoreID] = s
+	}
+	var preceding []proto.Locality
+	for i, replica := range replicas {
+		s, ok := byID[replica.StoreID]
+		if !ok {
+			continue
+		}
+		for _, c := range constraints {
+			if c.Permits(s.Node.Locality, preceding) {
+				continue
+			}
+			remaining := append(append([]proto.Replica(nil), replicas[:i]...), replicas[i+1:]...)
+			var required proto.Attributes
+			if i < len(zone.ReplicaAttrs) {
+				required = zone.ReplicaAttrs[i]
+			}
+			add, err := a.allocate(required, constraints, remaining)
+			return &replicaRepair{remove: replica, constraint: c, add: add}, err
+		}
+		preceding = append(preceding, s.Node.Locality)
+	}
+	return nil, nil
+}
+
+// allocationWeight returns the weight of the store for randomly
+// weighted selection: its percentage of available capacity, halved
+// if its request rate is at the mean request rate of the candidate
+// stores, and reduced further for hotter stores. This spreads hot
+// ranges away from already busy stores.
+func allocationWeight(s *StoreDescriptor, meanQPS float64) float64 {
+	weight := s.Capacity.PercentAvail()
+	if meanQPS > 0 {
+		weight /= 1 + s.QPS/meanQPS
+	}
+	return weight
+}
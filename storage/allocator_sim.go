@@ -0,0 +1,182 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// defaultSimRangeBytes is the size assumed for each simulated range.
+const defaultSimRangeBytes = 64 << 20
+
+// A Movement describes the relocation of a range replica from one
+// store to another at a step of an allocator simulation.
+type Movement struct {
+	Step        int
+	FromStoreID int32
+	ToStoreID   int32
+}
+
+// String formats the movement for display.
+func (m Movement) String() string {
+	return fmt.Sprintf("step %d: move replica from store %d to store %d", m.Step, m.FromStoreID, m.ToStoreID)
+}
+
+// simStore tracks the simulated state of a single store.
+type simStore struct {
+	desc       StoreDescriptor
+	rangeCount int
+}
+
+// AllocatorSimulation replays the allocator's decisions against a
+// snapshot of store descriptors and per-store range counts, without
+// moving any data. At each step of simulated time the store with the
+// most ranges sheds a replica to a store chosen by the allocator, and
+// the simulated capacities are adjusted accordingly. The resulting
+// movement plan allows changes to the allocator to be evaluated
+// before they're enabled on a live cluster.
+type AllocatorSimulation struct {
+	// RangeBytes is the size of each simulated range, used to adjust
+	// available capacity as replicas move.
+	RangeBytes int64
+	// Threshold is the fraction by which a store's range count must
+	// exceed the mean before it sheds a replica.
+	Threshold float64
+
+	stores    []*simStore // Sorted by store ID
+	allocator *allocator
+	step      int
+}
+
+// NewAllocatorSimulation returns a simulation initialized with copies
+// of the supplied store descriptors and a map from store ID to range
+// count. The seed determines the allocator's random choices, making
+// the simulation repeatable.
+func NewAllocatorSimulation(stores []*StoreDescriptor, rangeCounts map[int32]int, seed int64) *AllocatorSimulation {
+	s := &AllocatorSimulation{
+		RangeBytes: defaultSimRangeBytes,
+		Threshold:  0.05,
+	}
+	for _, desc := range stores {
+		s.stores = append(s.stores, &simStore{desc: *desc, rangeCount: rangeCounts[desc.StoreID]})
+	}
+	sort.Sort(simStoresByID(s.stores))
+	s.allocator = &allocator{
+		storeFinder: s.findStores,
+		rand:        *rand.New(rand.NewSource(seed)),
+	}
+	return s
+}
+
+// findStores is the simulation's StoreFinder. It returns the current
+// simulated descriptors of stores matching the required attributes.
+func (s *AllocatorSimulation) findStores(required proto.Attributes) ([]*StoreDescriptor, error) {
+	var found []*StoreDescriptor
+	for _, ss := range s.stores {
+		if required.IsSubset(*ss.desc.CombinedAttrs()) {
+			desc := ss.desc
+			found = append(found, &desc)
+		}
+	}
+	return found, nil
+}
+
+// lookup returns the simulated store with the specified ID.
+func (s *AllocatorSimulation) lookup(storeID int32) *simStore {
+	i := sort.Search(len(s.stores), func(i int) bool { return s.stores[i].desc.StoreID >= storeID })
+	if i < len(s.stores) && s.stores[i].desc.StoreID == storeID {
+		return s.stores[i]
+	}
+	return nil
+}
+
+// Step advances the simulation by one step. If a store's range count
+// exceeds the mean by more than the threshold, the allocator picks a
+// target store with the same store attributes on a different node and
+// a replica is moved. Returns the movement and true if a replica was
+// moved; false if the stores are balanced or the allocator found no
+// target.
+func (s *AllocatorSimulation) Step() (Movement, bool) {
+	s.step++
+	if len(s.stores) == 0 {
+		return Movement{}, false
+	}
+	var total int
+	source := s.stores[0]
+	for _, ss := range s.stores {
+		total += ss.rangeCount
+		if ss.rangeCount > source.rangeCount {
+			source = ss
+		}
+	}
+	mean := float64(total) / float64(len(s.stores))
+	if float64(source.rangeCount) <= mean*(1+s.Threshold) {
+		return Movement{}, false
+	}
+	existing := []proto.Replica{{NodeID: source.desc.Node.NodeID, StoreID: source.desc.StoreID}}
+	targetDesc, err := s.allocator.allocate(source.desc.Attrs, nil, existing)
+	if err != nil {
+		return Movement{}, false
+	}
+	target := s.lookup(targetDesc.StoreID)
+	source.rangeCount--
+	source.desc.Capacity.Available += s.RangeBytes
+	if source.desc.Capacity.Available > source.desc.Capacity.Capacity {
+		source.desc.Capacity.Available = source.desc.Capacity.Capacity
+	}
+	target.rangeCount++
+	target.desc.Capacity.Available -= s.RangeBytes
+	if target.desc.Capacity.Available < 0 {
+		target.desc.Capacity.Available = 0
+	}
+	return Movement{Step: s.step, FromStoreID: source.desc.StoreID, ToStoreID: target.desc.StoreID}, true
+}
+
+// Run steps the simulation until no replica is moved or maxSteps
+// steps have elapsed, returning the movement plan.
+func (s *AllocatorSimulation) Run(maxSteps int) []Movement {
+	var plan []Movement
+	for i := 0; i < maxSteps; i++ {
+		m, ok := s.Step()
+		if !ok {
+			break
+		}
+		plan = append(plan, m)
+	}
+	return plan
+}
+
+// RangeCounts returns the simulated range count for each store.
+func (s *AllocatorSimulation) RangeCounts() map[int32]int {
+	counts := map[int32]int{}
+	for _, ss := range s.stores {
+		counts[ss.desc.StoreID] = ss.rangeCount
+	}
+	return counts
+}
+
+// simStoresByID implements sort.Interface for simulated stores.
+type simStoresByID []*simStore
+
+func (s simStoresByID) Len() int           { return len(s) }
+func (s simStoresByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s simStoresByID) Less(i, j int) bool { return s[i].desc.StoreID < s[j].desc.StoreID }
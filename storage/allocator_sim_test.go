@@ -0,0 +1,126 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// makeSimStores returns descriptors for count stores, each on its
+// own node, with the specified store attributes.
+func makeSimStores(count int, attrs ...string) []*StoreDescriptor {
+	var stores []*StoreDescriptor
+	for i := 1; i <= count; i++ {
+		stores = append(stores, &StoreDescriptor{
+			StoreID:  int32(i),
+			Attrs:    proto.Attributes{Attrs: attrs},
+			Node:     NodeDescriptor{NodeID: int32(i)},
+			Capacity: engine.StoreCapacity{Capacity: 1 << 40, Available: 1 << 40},
+		})
+	}
+	return stores
+}
+
+// TestAllocatorSimulationBalances verifies that the simulation moves
+// replicas off an overloaded store until the stores are balanced and
+// that the plan accounts for the change in range counts.
+func TestAllocatorSimulationBalances(t *testing.T) {
+	stores := makeSimStores(4, "ssd")
+	counts := map[int32]int{1: 100, 2: 0, 3: 0, 4: 0}
+	sim := NewAllocatorSimulation(stores, counts, 0)
+	plan := sim.Run(1000)
+	if len(plan) == 0 {
+		t.Fatal("expected a non-empty movement plan")
+	}
+	final := sim.RangeCounts()
+	total := 0
+	for storeID, count := range final {
+		total += count
+		if float64(count) > 25*(1+sim.Threshold) {
+			t.Errorf("store %d remains overloaded with %d ranges", storeID, count)
+		}
+	}
+	if total != 100 {
+		t.Errorf("expected 100 ranges in total; got %d", total)
+	}
+	replayed := map[int32]int{1: 100, 2: 0, 3: 0, 4: 0}
+	for i, m := range plan {
+		if m.Step != i+1 {
+			t.Errorf("expected movement %d at step %d; got %d", i, i+1, m.Step)
+		}
+		if m.FromStoreID == m.ToStoreID {
+			t.Errorf("movement %d moves replica to its own store", i)
+		}
+		replayed[m.FromStoreID]--
+		replayed[m.ToStoreID]++
+	}
+	if !reflect.DeepEqual(replayed, final) {
+		t.Errorf("replayed plan yields %v; expected %v", replayed, final)
+	}
+	// The input descriptors must not be modified.
+	if stores[0].Capacity.Available != 1<<40 {
+		t.Errorf("simulation modified input store descriptor: %+v", stores[0])
+	}
+}
+
+// TestAllocatorSimulationDeterministic verifies that simulations with
+// the same seed yield the same plan.
+func TestAllocatorSimulationDeterministic(t *testing.T) {
+	counts := map[int32]int{1: 50, 2: 10, 3: 0, 4: 5, 5: 0}
+	plan1 := NewAllocatorSimulation(makeSimStores(5, "ssd"), counts, 42).Run(1000)
+	plan2 := NewAllocatorSimulation(makeSimStores(5, "ssd"), counts, 42).Run(1000)
+	if !reflect.DeepEqual(plan1, plan2) {
+		t.Errorf("expected identical plans:\n%v\n%v", plan1, plan2)
+	}
+}
+
+// TestAllocatorSimulationAttrs verifies that replicas only move to
+// stores with matching store attributes.
+func TestAllocatorSimulationAttrs(t *testing.T) {
+	stores := append(makeSimStores(2, "ssd"), &StoreDescriptor{
+		StoreID:  3,
+		Attrs:    proto.Attributes{Attrs: []string{"hdd"}},
+		Node:     NodeDescriptor{NodeID: 3},
+		Capacity: engine.StoreCapacity{Capacity: 1 << 40, Available: 1 << 40},
+	})
+	sim := NewAllocatorSimulation(stores, map[int32]int{1: 30}, 0)
+	for _, m := range sim.Run(1000) {
+		if m.ToStoreID == 3 {
+			t.Errorf("unexpected movement to hdd store: %s", m)
+		}
+	}
+	if counts := sim.RangeCounts(); counts[3] != 0 {
+		t.Errorf("expected no ranges on hdd store; got %d", counts[3])
+	}
+}
+
+// TestAllocatorSimulationNoTarget verifies that the simulation stops
+// when the allocator finds no target store.
+func TestAllocatorSimulationNoTarget(t *testing.T) {
+	// Both stores are on the same node, which the allocator excludes.
+	stores := makeSimStores(2, "ssd")
+	stores[1].Node.NodeID = 1
+	sim := NewAllocatorSimulation(stores, map[int32]int{1: 10}, 0)
+	if plan := sim.Run(10); len(plan) != 0 {
+		t.Errorf("expected empty plan; got %v", plan)
+	}
+}
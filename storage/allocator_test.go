@@ -180,7 +180,7 @@ func TestSimpleRetrieval(t *testing.T) {
 		storeFinder: singleStore,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result, err := a.allocate(simpleZoneConfig.ReplicaAttrs[0], []proto.Replica{})
+	result, err := a.allocate(simpleZoneConfig.ReplicaAttrs[0], nil, []proto.Replica{})
 	if err != nil {
 		t.Errorf("Unable to perform allocation: %v", err)
 	}
@@ -194,7 +194,7 @@ func TestNoAvailableDisks(t *testing.T) {
 		storeFinder: noStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result, err := a.allocate(simpleZoneConfig.ReplicaAttrs[0], []proto.Replica{})
+	result, err := a.allocate(simpleZoneConfig.ReplicaAttrs[0], nil, []proto.Replica{})
 	if result != nil {
 		t.Errorf("expected nil result: %+v", result)
 	}
@@ -208,7 +208,7 @@ func TestThreeDisksSameDC(t *testing.T) {
 		storeFinder: sameDCStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result1, err := a.allocate(multiDisksConfig.ReplicaAttrs[0], []proto.Replica{})
+	result1, err := a.allocate(multiDisksConfig.ReplicaAttrs[0], nil, []proto.Replica{})
 	if err != nil {
 		t.Fatalf("Unable to perform allocation: %v", err)
 	}
@@ -222,7 +222,7 @@ func TestThreeDisksSameDC(t *testing.T) {
 			Attrs:   multiDisksConfig.ReplicaAttrs[0],
 		},
 	}
-	result2, err := a.allocate(multiDisksConfig.ReplicaAttrs[1], exReplicas)
+	result2, err := a.allocate(multiDisksConfig.ReplicaAttrs[1], nil, exReplicas)
 	if err != nil {
 		t.Errorf("Unable to perform allocation: %v", err)
 	}
@@ -232,7 +232,7 @@ func TestThreeDisksSameDC(t *testing.T) {
 	if result1.Node.NodeID == result2.Node.NodeID {
 		t.Errorf("Expected node ids to be different %+v vs %+v", result1, result2)
 	}
-	result3, err := a.allocate(multiDisksConfig.ReplicaAttrs[2], []proto.Replica{})
+	result3, err := a.allocate(multiDisksConfig.ReplicaAttrs[2], nil, []proto.Replica{})
 	if err != nil {
 		t.Errorf("Unable to perform allocation: %v", err)
 	}
@@ -246,11 +246,11 @@ func TestTwoDatacenters(t *testing.T) {
 		storeFinder: multiDCStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result1, err := a.allocate(multiDCConfig.ReplicaAttrs[0], []proto.Replica{})
+	result1, err := a.allocate(multiDCConfig.ReplicaAttrs[0], nil, []proto.Replica{})
 	if err != nil {
 		t.Fatalf("Unable to perform allocation: %v", err)
 	}
-	result2, err := a.allocate(multiDCConfig.ReplicaAttrs[1], []proto.Replica{})
+	result2, err := a.allocate(multiDCConfig.ReplicaAttrs[1], nil, []proto.Replica{})
 	if err != nil {
 		t.Fatalf("Unable to perform allocation: %v", err)
 	}
@@ -258,7 +258,7 @@ func TestTwoDatacenters(t *testing.T) {
 		t.Errorf("Expected nodes 1 & 2: %+v vs %+v", result1.Node, result2.Node)
 	}
 	// Verify that no result is forthcoming if we already have a replica.
-	_, err = a.allocate(multiDCConfig.ReplicaAttrs[1], []proto.Replica{
+	_, err = a.allocate(multiDCConfig.ReplicaAttrs[1], nil, []proto.Replica{
 		proto.Replica{
 			NodeID:  result2.Node.NodeID,
 			StoreID: result2.StoreID,
@@ -275,7 +275,7 @@ func TestExistingReplica(t *testing.T) {
 		storeFinder: sameDCStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result, err := a.allocate(multiDisksConfig.ReplicaAttrs[1], []proto.Replica{
+	result, err := a.allocate(multiDisksConfig.ReplicaAttrs[1], nil, []proto.Replica{
 		proto.Replica{
 			NodeID:  1,
 			StoreID: 1,
@@ -289,3 +289,185 @@ func TestExistingReplica(t *testing.T) {
 		t.Errorf("expected result to have node 3 and store 4: %+v", result)
 	}
 }
+
+// TestAllocationWeightLoad verifies that stores with a higher request
+// rate are weighted less for allocation.
+func TestAllocationWeightLoad(t *testing.T) {
+	capacity := engine.StoreCapacity{Capacity: 100, Available: 50}
+	idle := &StoreDescriptor{Capacity: capacity}
+	busy := &StoreDescriptor{Capacity: capacity, QPS: 300}
+	if w := allocationWeight(busy, 0); w != 0.5 {
+		t.Errorf("expected weight 0.5 with no load information; got %f", w)
+	}
+	meanQPS := busy.QPS / 2
+	wIdle, wBusy := allocationWeight(idle, meanQPS), allocationWeight(busy, meanQPS)
+	if wIdle != 0.5 {
+		t.Errorf("expected idle store weight 0.5; got %f", wIdle)
+	}
+	if wBusy >= wIdle {
+		t.Errorf("expected busy store weight %f to be less than idle store weight %f", wBusy, wIdle)
+	}
+}
+
+// TestAllocateAvoidsOverloadedNodes verifies that stores on overloaded
+// nodes are only allocated if no other stores are available.
+func TestAllocateAvoidsOverloadedNodes(t *testing.T) {
+	capacity := engine.StoreCapacity{Capacity: 100, Available: 100}
+	hot := &StoreDescriptor{
+		StoreID:  1,
+		Node:     NodeDescriptor{NodeID: 1, Load: NodeLoad{CPU: 0.95}},
+		Capacity: capacity,
+	}
+	cool := &StoreDescriptor{
+		StoreID:  2,
+		Node:     NodeDescriptor{NodeID: 2, Load: NodeLoad{CPU: 0.2}},
+		Capacity: engine.StoreCapacity{Capacity: 100, Available: 1},
+	}
+	a := allocator{
+		storeFinder: func(proto.Attributes) ([]*StoreDescriptor, error) {
+			return []*StoreDescriptor{hot, cool}, nil
+		},
+		rand:           *rand.New(rand.NewSource(0)),
+		loadThresholds: LoadThresholds{CPU: 0.9},
+	}
+	// The cool store is picked every time despite its scant capacity.
+	for i := 0; i < 10; i++ {
+		result, err := a.allocate(proto.Attributes{}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StoreID != cool.StoreID {
+			t.Fatalf("%d: expected store %d; got %d", i, cool.StoreID, result.StoreID)
+		}
+	}
+	// The hot store is picked once the cool one already has a replica.
+	result, err := a.allocate(proto.Attributes{}, nil, []proto.Replica{{NodeID: 2, StoreID: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StoreID != hot.StoreID {
+		t.Errorf("expected store %d; got %d", hot.StoreID, result.StoreID)
+	}
+}
+
+// localityStores returns a StoreFinder over stores 1 through 6, one
+// per node, in two racks in each of three regions. Store 6, in rack 2
+// of us-west, has the most available capacity.
+func localityStores() StoreFinder {
+	var stores []*StoreDescriptor
+	for i, loc := range []string{
+		"region=us-east,rack=1", "region=us-east,rack=2",
+		"region=us-central,rack=1", "region=us-central,rack=2",
+		"region=us-west,rack=1", "region=us-west,rack=2",
+	} {
+		locality, err := proto.ParseLocality(loc)
+		if err != nil {
+			panic(err)
+		}
+		id := int32(i + 1)
+		stores = append(stores, &StoreDescriptor{
+			StoreID:  id,
+			Node:     NodeDescriptor{NodeID: id, Locality: locality},
+			Capacity: engine.StoreCapacity{Capacity: 100, Available: 50 + int64(id)},
+		})
+	}
+	return func(a proto.Attributes) ([]*StoreDescriptor, error) {
+		return filterStores(a, stores)
+	}
+}
+
+// TestAllocateDiversity verifies that new replicas are placed in the
+// localities most diverse from the existing replicas'.
+func TestAllocateDiversity(t *testing.T) {
+	a := allocator{
+		storeFinder: localityStores(),
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	for i := 0; i < 10; i++ {
+		result, err := a.allocate(proto.Attributes{}, nil, []proto.Replica{{NodeID: 1, StoreID: 1}, {NodeID: 3, StoreID: 3}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StoreID != 5 && result.StoreID != 6 {
+			t.Fatalf("%d: expected a store in us-west; got %d", i, result.StoreID)
+		}
+	}
+}
+
+// TestAllocateConstraints verifies that stores are only allocated
+// if they satisfy the placement constraints.
+func TestAllocateConstraints(t *testing.T) {
+	a := allocator{
+		storeFinder: localityStores(),
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	testCases := []struct {
+		constraints []string
+		existing    []proto.Replica
+		expected    []int32 // acceptable store IDs; none if allocation fails
+	}{
+		{[]string{"+region=us-east"}, nil, []int32{1, 2}},
+		{[]string{"+region=us-east"}, []proto.Replica{{NodeID: 1, StoreID: 1}}, []int32{2}},
+		{[]string{"-region=us-east", "-region=us-west"}, nil, []int32{3, 4}},
+		{[]string{"-rack=1", "unique:region"}, []proto.Replica{{NodeID: 2, StoreID: 2}, {NodeID: 4, StoreID: 4}}, []int32{6}},
+		{[]string{"unique:region"}, []proto.Replica{{NodeID: 1, StoreID: 1}, {NodeID: 3, StoreID: 3}, {NodeID: 5, StoreID: 5}}, nil},
+		{[]string{"+dc=east"}, nil, nil},
+	}
+	for i, test := range testCases {
+		constraints, err := proto.ParseConstraints(test.constraints)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := a.allocate(proto.Attributes{}, constraints, test.existing)
+		if len(test.expected) == 0 {
+			if err == nil {
+				t.Errorf("%d: expected allocation to fail; got store %d", i, result.StoreID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+			continue
+		}
+		var ok bool
+		for _, storeID := range test.expected {
+			ok = ok || result.StoreID == storeID
+		}
+		if !ok {
+			t.Errorf("%d: expected one of stores %v; got %d", i, test.expected, result.StoreID)
+		}
+	}
+}
+
+// TestAllocatorRepair verifies that replicas violating their zone's
+// placement constraints are found and replaced.
+func TestAllocatorRepair(t *testing.T) {
+	a := allocator{
+		storeFinder: localityStores(),
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	zone := &proto.ZoneConfig{Constraints: []string{"unique:region"}}
+	replicas := []proto.Replica{{NodeID: 1, StoreID: 1}, {NodeID: 3, StoreID: 3}, {NodeID: 5, StoreID: 5}}
+	if repair, err := a.repair(zone, replicas); repair != nil || err != nil {
+		t.Errorf("expected no repair; got %+v, %v", repair, err)
+	}
+
+	// The second replica in us-east is replaced by one in us-west.
+	replicas = []proto.Replica{{NodeID: 1, StoreID: 1}, {NodeID: 2, StoreID: 2}, {NodeID: 3, StoreID: 3}}
+	repair, err := a.repair(zone, replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repair == nil || repair.remove.StoreID != 2 || repair.constraint.String() != "unique:region" ||
+		(repair.add.StoreID != 5 && repair.add.StoreID != 6) {
+		t.Errorf("expected store 2 to be replaced in us-west; got %+v", repair)
+	}
+
+	// A violation without a suitable replacement is reported with the
+	// allocation error.
+	zone = &proto.ZoneConfig{Constraints: []string{"-region=us-east", "+rack=3"}}
+	repair, err = a.repair(zone, replicas)
+	if repair == nil || repair.remove.StoreID != 1 || repair.add != nil || err == nil {
+		t.Errorf("expected store 1 to be reported without replacement; got %+v, %v", repair, err)
+	}
+}
@@ -28,91 +28,124 @@ import (
 // executing commands. New commands affecting keys or key ranges must
 // wait on already-executing commands which overlap their key range.
 //
-// Before executing, a command invokes GetWait() to initialize a
-// WaitGroup with the number of overlapping commands which are already
-// running. The wait group is waited on by the caller for confirmation
+// Each command declares the key spans it accesses as a SpanSet,
+// distinguishing spans which are only read from spans which are
+// written. Before executing, a command invokes Add() to join the
+// queue. Add initializes the supplied WaitGroup with the number of
+// commands ahead of it in the queue which declared an overlapping
+// span. The wait group is waited on by the caller for confirmation
 // that all overlapping, pending commands have completed and the
-// pending command can proceed.
+// pending command can proceed. Commands don't need to wait on
+// commands whose spans overlap theirs only where both read, so the
+// wait group doesn't include read-only on read-only overlaps as an
+// optimization. A command waits at most once on another command, no
+// matter how many of their spans overlap.
 //
-// After waiting, a command is added to the queue's already-executing
-// set via Add(). Add accepts a parameter indicating whether the
-// command is read-only. Read-only commands don't need to wait on
-// other read-only commands, so the wait group returned via GetWait()
-// doesn't include read-only on read-only overlapping commands as an
-// optimization.
+// Commands are ordered by priority. A new command which overlaps a
+// command that is itself still waiting, and whose priority is
+// strictly higher, is scheduled ahead of it: the waiting command's
+// WaitGroup is incremented so that it additionally waits on the new
+// command. This lets interactive traffic overtake queued, low-priority
+// bulk work under load. As a command can only overtake strictly
+// lower-priority waiters, the resulting wait graph remains acyclic.
 //
 // Once commands complete, Remove() is invoked to remove the executing
 // command and decrement the counts on any pending WaitGroups,
 // possibly signaling waiting commands who were gated by the executing
 // command's affected key(s).
 //
-// CommandQueue is not thread safe.
+// A single CommandQueue is shared by all ranges of a store, so that
+// commands remain ordered while a range splits. CommandQueue is safe
+// for concurrent use.
 type CommandQueue struct {
+	mu    sync.Mutex
 	cache *util.IntervalCache
+	cmds  map[*cmd]struct{} // Commands in the queue
 }
 
 type cmd struct {
-	readOnly bool
-	pending  []*sync.WaitGroup // Pending commands gated on cmd
+	priority  int32
+	wg        *sync.WaitGroup // Signaled once the command may proceed
+	waitCount int             // Number of commands gating cmd
+	pending   []*cmd          // Pending commands gated on cmd
+	keys      []interface{}   // Interval cache keys of the declared spans
+}
+
+// cmdSpan is the value of an interval cache entry: a span declared by
+// cmd with the given access.
+type cmdSpan struct {
+	cmd    *cmd
+	access SpanAccess
 }
 
 // NewCommandQueue returns a new command queue.
 func NewCommandQueue() *CommandQueue {
-	cq := &CommandQueue{
+	return &CommandQueue{
 		cache: util.NewIntervalCache(util.CacheConfig{Policy: util.CacheNone}),
-	}
-	cq.cache.OnEvicted = cq.onEvicted
-	return cq
-}
-
-// onEvicted is called when any entry is removed from the interval
-// tree. This happens on calls to Remove() and to Clear().
-func (cq *CommandQueue) onEvicted(key, value interface{}) {
-	c := value.(*cmd)
-	for _, wg := range c.pending {
-		wg.Done()
+		cmds:  map[*cmd]struct{}{},
 	}
 }
 
-// GetWait initializes the supplied wait group with the number of
-// executing commands which overlap the specified key range. If end is
-// empty, end is set to start.Next(), meaning the command affects a
-// single key. The caller should call wg.Wait() to wait for
-// confirmation that all gating commands have completed or
-// failed. readOnly is true if the requester is a read-only command;
-// false for read-write.
-func (cq *CommandQueue) GetWait(start, end proto.Key, readOnly bool, wg *sync.WaitGroup) {
-	// This gives us a memory-efficient end key if end is empty.
-	if len(end) == 0 {
-		end = start.Next()
-		start = end[:len(start)]
+// Add adds a command to the queue which accesses the specified key
+// spans. The supplied wait group is initialized with the number of
+// overlapping commands which must complete before this command may
+// proceed; the caller should call wg.Wait() before executing the
+// command. priority is the command's user priority; higher values are
+// scheduled ahead of waiting commands with lower values.
+//
+// The returned interface is the key for the command queue and must
+// be re-supplied on subsequent invocation of Remove().
+func (cq *CommandQueue) Add(spans *SpanSet, priority int32, wg *sync.WaitGroup) interface{} {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+	newCmd := &cmd{priority: priority, wg: wg}
+	seen := map[*cmd]struct{}{}
+	for access, accessSpans := range spans.spans {
+		for _, span := range accessSpans {
+			for _, o := range cq.cache.GetOverlaps(span.start, spanEnd(span)) {
+				cs := o.Value.(*cmdSpan)
+				c := cs.cmd
+				// Only wait if one of the commands writes the overlap.
+				if SpanAccess(access) == SpanReadOnly && cs.access == SpanReadOnly {
+					continue
+				}
+				if _, ok := seen[c]; ok {
+					continue
+				}
+				seen[c] = struct{}{}
+				if c.waitCount > 0 && priority > c.priority {
+					// Overtake the waiting, lower-priority command.
+					c.wg.Add(1)
+					c.waitCount++
+					newCmd.pending = append(newCmd.pending, c)
+					continue
+				}
+				c.pending = append(c.pending, newCmd)
+				wg.Add(1)
+				newCmd.waitCount++
+			}
+		}
 	}
-	for _, c := range cq.cache.GetOverlaps(start, end) {
-		c := c.Value.(*cmd)
-		// Only add to the wait group if one of the commands isn't read-only.
-		if !readOnly || !c.readOnly {
-			c.pending = append(c.pending, wg)
-			wg.Add(1)
+	// Add the spans only after computing overlaps, so that the command
+	// doesn't find itself.
+	for access, accessSpans := range spans.spans {
+		for _, span := range accessSpans {
+			key := cq.cache.NewKey(span.start, spanEnd(span))
+			cq.cache.Add(key, &cmdSpan{cmd: newCmd, access: SpanAccess(access)})
+			newCmd.keys = append(newCmd.keys, key)
 		}
 	}
+	cq.cmds[newCmd] = struct{}{}
+	return newCmd
 }
 
-// Add adds a command to the queue which affects the specified key
-// range. If end is empty, it is set to start.Next(), meaning the
-// command affects a single key. The returned interface is the key for
-// the command queue and must be re-supplied on subsequent invocation
-// of Remove().
-//
-// Add should be invoked after waiting on already-executing,
-// overlapping commands via the WaitGroup initialized through
-// GetWait().
-func (cq *CommandQueue) Add(start, end proto.Key, readOnly bool) interface{} {
-	if len(end) == 0 {
-		end = start.Next()
+// spanEnd returns the end of span, which is start.Next() if the span
+// is a single key.
+func spanEnd(span keySpan) proto.Key {
+	if len(span.end) == 0 {
+		return span.start.Next()
 	}
-	key := cq.cache.NewKey(start, end)
-	cq.cache.Add(key, &cmd{readOnly: readOnly})
-	return key
+	return span.end
 }
 
 // Remove is invoked to signal that the command associated with the
@@ -125,10 +158,37 @@ func (cq *CommandQueue) Add(start, end proto.Key, readOnly bool) interface{} {
 // Remove is invoked after a read-only command has been executed
 // against the underlying state machine.
 func (cq *CommandQueue) Remove(key interface{}) {
-	cq.cache.Del(key)
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+	cq.remove(key.(*cmd))
+}
+
+// remove removes c's spans from the interval tree and signals the
+// commands gated on it. The queue lock must be held.
+func (cq *CommandQueue) remove(c *cmd) {
+	for _, key := range c.keys {
+		cq.cache.Del(key)
+	}
+	delete(cq.cmds, c)
+	for _, p := range c.pending {
+		p.waitCount--
+		p.wg.Done()
+	}
+}
+
+// Len returns the number of commands in the queue, whether executing
+// or waiting.
+func (cq *CommandQueue) Len() int {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+	return len(cq.cmds)
 }
 
 // Clear removes all executing commands, signaling any waiting commands.
 func (cq *CommandQueue) Clear() {
-	cq.cache.Clear()
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+	for c := range cq.cmds {
+		cq.remove(c)
+	}
 }
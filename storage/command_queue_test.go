@@ -49,20 +49,46 @@ func testCmdDone(cmdDone <-chan struct{}, wait time.Duration) bool {
 	}
 }
 
+// add adds a command to the queue at the default priority and returns
+// its key along with a channel signaling completion of its wait.
+func add(cq *CommandQueue, start, end proto.Key, readOnly bool) (interface{}, <-chan struct{}) {
+	return addWithPriority(cq, start, end, readOnly, proto.Default_RequestHeader_UserPriority)
+}
+
+// addWithPriority is like add, but with the specified priority.
+func addWithPriority(cq *CommandQueue, start, end proto.Key, readOnly bool, priority int32) (interface{}, <-chan struct{}) {
+	access := SpanReadWrite
+	if readOnly {
+		access = SpanReadOnly
+	}
+	spans := &SpanSet{}
+	spans.Add(access, start, end)
+	return addSpans(cq, spans, priority)
+}
+
+// addSpans adds a command declaring the supplied spans to the queue.
+func addSpans(cq *CommandQueue, spans *SpanSet, priority int32) (interface{}, <-chan struct{}) {
+	wg := &sync.WaitGroup{}
+	key := cq.Add(spans, priority, wg)
+	return key, waitForCmd(wg)
+}
+
 func TestCommandQueue(t *testing.T) {
 	cq := NewCommandQueue()
-	wg := sync.WaitGroup{}
 
 	// Try a command with no overlapping already-running commands.
-	cq.GetWait(proto.Key("a"), nil, false, &wg)
-	wg.Wait()
-	cq.GetWait(proto.Key("a"), proto.Key("b"), false, &wg)
-	wg.Wait()
-
-	// Add a command and verify wait group is returned.
-	wk := cq.Add(proto.Key("a"), nil, false)
-	cq.GetWait(proto.Key("a"), nil, false, &wg)
-	cmdDone := waitForCmd(&wg)
+	wk, cmdDone := add(cq, proto.Key("a"), nil, false)
+	if !testCmdDone(cmdDone, 5*time.Millisecond) {
+		t.Fatal("command should finish with no commands outstanding")
+	}
+	cq.Remove(wk)
+	wk, cmdDone = add(cq, proto.Key("a"), proto.Key("b"), false)
+	if !testCmdDone(cmdDone, 5*time.Millisecond) {
+		t.Fatal("command should finish with no commands outstanding")
+	}
+
+	// Add a command and verify it waits on the one outstanding.
+	_, cmdDone = add(cq, proto.Key("a"), nil, false)
 	if testCmdDone(cmdDone, 1*time.Millisecond) {
 		t.Fatal("command should not finish with command outstanding")
 	}
@@ -74,15 +100,16 @@ func TestCommandQueue(t *testing.T) {
 
 func TestCommandQueueNoWaitOnReadOnly(t *testing.T) {
 	cq := NewCommandQueue()
-	wg := sync.WaitGroup{}
 	// Add a read-only command.
-	wk := cq.Add(proto.Key("a"), nil, true)
+	wk, _ := add(cq, proto.Key("a"), nil, true)
 	// Verify no wait on another read-only command.
-	cq.GetWait(proto.Key("a"), nil, true, &wg)
-	wg.Wait()
+	wk2, cmdDone := add(cq, proto.Key("a"), nil, true)
+	if !testCmdDone(cmdDone, 5*time.Millisecond) {
+		t.Fatal("read-only command should not wait on read-only command")
+	}
+	cq.Remove(wk2)
 	// Verify wait with a read-write command.
-	cq.GetWait(proto.Key("a"), nil, false, &wg)
-	cmdDone := waitForCmd(&wg)
+	_, cmdDone = add(cq, proto.Key("a"), nil, false)
 	if testCmdDone(cmdDone, 1*time.Millisecond) {
 		t.Fatal("command should not finish with command outstanding")
 	}
@@ -94,14 +121,12 @@ func TestCommandQueueNoWaitOnReadOnly(t *testing.T) {
 
 func TestCommandQueueMultipleExecutingCommands(t *testing.T) {
 	cq := NewCommandQueue()
-	wg := sync.WaitGroup{}
 
 	// Add multiple commands and add a command which overlaps them all.
-	wk1 := cq.Add(proto.Key("a"), nil, false)
-	wk2 := cq.Add(proto.Key("b"), proto.Key("c"), false)
-	wk3 := cq.Add(proto.Key("0"), proto.Key("d"), false)
-	cq.GetWait(proto.Key("a"), proto.Key("cc"), false, &wg)
-	cmdDone := waitForCmd(&wg)
+	wk1, _ := add(cq, proto.Key("a"), nil, false)
+	wk2, _ := add(cq, proto.Key("b"), proto.Key("c"), false)
+	wk3, _ := add(cq, proto.Key("0"), proto.Key("d"), false)
+	_, cmdDone := add(cq, proto.Key("a"), proto.Key("cc"), false)
 	cq.Remove(wk1)
 	if testCmdDone(cmdDone, 1*time.Millisecond) {
 		t.Fatal("command should not finish with two commands outstanding")
@@ -118,18 +143,12 @@ func TestCommandQueueMultipleExecutingCommands(t *testing.T) {
 
 func TestCommandQueueMultiplePendingCommands(t *testing.T) {
 	cq := NewCommandQueue()
-	wg1 := sync.WaitGroup{}
-	wg2 := sync.WaitGroup{}
-	wg3 := sync.WaitGroup{}
 
 	// Add a command which will overlap all commands.
-	wk := cq.Add(proto.Key("a"), proto.Key("d"), false)
-	cq.GetWait(proto.Key("a"), nil, false, &wg1)
-	cq.GetWait(proto.Key("b"), nil, false, &wg2)
-	cq.GetWait(proto.Key("c"), nil, false, &wg3)
-	cmdDone1 := waitForCmd(&wg1)
-	cmdDone2 := waitForCmd(&wg2)
-	cmdDone3 := waitForCmd(&wg3)
+	wk, _ := add(cq, proto.Key("a"), proto.Key("d"), false)
+	_, cmdDone1 := add(cq, proto.Key("a"), nil, false)
+	_, cmdDone2 := add(cq, proto.Key("b"), nil, false)
+	_, cmdDone3 := add(cq, proto.Key("c"), nil, false)
 
 	if testCmdDone(cmdDone1, 1*time.Millisecond) ||
 		testCmdDone(cmdDone2, 1*time.Millisecond) ||
@@ -144,18 +163,54 @@ func TestCommandQueueMultiplePendingCommands(t *testing.T) {
 	}
 }
 
+// TestCommandQueuePriority verifies that a higher priority command
+// is scheduled ahead of a waiting, overlapping command of lower
+// priority, but not ahead of one with equal priority or one which is
+// already executing.
+func TestCommandQueuePriority(t *testing.T) {
+	cq := NewCommandQueue()
+
+	wk, _ := addWithPriority(cq, proto.Key("a"), proto.Key("c"), false, 1)
+	wkLow, lowDone := addWithPriority(cq, proto.Key("a"), nil, false, 1)
+	wkEq, eqDone := addWithPriority(cq, proto.Key("b"), nil, false, 10)
+	wkHigh, highDone := addWithPriority(cq, proto.Key("a"), proto.Key("c"), false, 10)
+
+	if testCmdDone(lowDone, 1*time.Millisecond) ||
+		testCmdDone(eqDone, 1*time.Millisecond) ||
+		testCmdDone(highDone, 1*time.Millisecond) {
+		t.Fatal("no commands should finish with command outstanding")
+	}
+	cq.Remove(wk)
+	// The equal priority command proceeds; the high priority command
+	// waits on it but has overtaken the low priority command.
+	if !testCmdDone(eqDone, 5*time.Millisecond) {
+		t.Fatal("equal priority command should finish")
+	}
+	if testCmdDone(lowDone, 1*time.Millisecond) || testCmdDone(highDone, 1*time.Millisecond) {
+		t.Fatal("commands should be waiting on equal priority command")
+	}
+	cq.Remove(wkEq)
+	if !testCmdDone(highDone, 5*time.Millisecond) {
+		t.Fatal("high priority command should finish")
+	}
+	if testCmdDone(lowDone, 1*time.Millisecond) {
+		t.Fatal("low priority command should be waiting on high priority command")
+	}
+	cq.Remove(wkHigh)
+	if !testCmdDone(lowDone, 5*time.Millisecond) {
+		t.Fatal("low priority command should finish")
+	}
+	cq.Remove(wkLow)
+}
+
 func TestCommandQueueClear(t *testing.T) {
 	cq := NewCommandQueue()
-	wg1 := sync.WaitGroup{}
-	wg2 := sync.WaitGroup{}
 
 	// Add multiple commands and commands which access each.
-	cq.Add(proto.Key("a"), nil, false)
-	cq.Add(proto.Key("b"), nil, false)
-	cq.GetWait(proto.Key("a"), nil, false, &wg1)
-	cq.GetWait(proto.Key("b"), nil, false, &wg2)
-	cmdDone1 := waitForCmd(&wg1)
-	cmdDone2 := waitForCmd(&wg2)
+	add(cq, proto.Key("a"), nil, false)
+	add(cq, proto.Key("b"), nil, false)
+	_, cmdDone1 := add(cq, proto.Key("a"), nil, false)
+	_, cmdDone2 := add(cq, proto.Key("b"), nil, false)
 
 	// Clear the queue and verify both commands are signaled.
 	cq.Clear()
@@ -165,3 +220,41 @@ func TestCommandQueueClear(t *testing.T) {
 		t.Fatal("commands should finish when clearing queue")
 	}
 }
+
+// TestCommandQueueMultipleSpans verifies that a command declaring
+// several spans waits on commands overlapping any of them according
+// to each span's access, and waits only once on a command which
+// overlaps several of its spans.
+func TestCommandQueueMultipleSpans(t *testing.T) {
+	cq := NewCommandQueue()
+	pri := proto.Default_RequestHeader_UserPriority
+
+	// Write "a" and read "c"-"e".
+	spans := &SpanSet{}
+	spans.Add(SpanReadWrite, proto.Key("a"), nil)
+	spans.Add(SpanReadOnly, proto.Key("c"), proto.Key("e"))
+	wk, _ := addSpans(cq, spans, pri)
+
+	// A read of "d" doesn't wait.
+	rk, cmdDone := add(cq, proto.Key("d"), nil, true)
+	if !testCmdDone(cmdDone, 5*time.Millisecond) {
+		t.Fatal("read-only command should not wait on read span")
+	}
+	cq.Remove(rk)
+
+	// Writing both "a" and "d" waits on the command exactly once.
+	spans = &SpanSet{}
+	spans.Add(SpanReadWrite, proto.Key("a"), nil)
+	spans.Add(SpanReadWrite, proto.Key("d"), nil)
+	_, cmdDone = addSpans(cq, spans, pri)
+	if testCmdDone(cmdDone, 1*time.Millisecond) {
+		t.Fatal("command should not finish with command outstanding")
+	}
+	cq.Remove(wk)
+	if !testCmdDone(cmdDone, 5*time.Millisecond) {
+		t.Fatal("command should finish with no commands outstanding")
+	}
+	if l := cq.Len(); l != 1 {
+		t.Errorf("expected 1 command in queue; got %d", l)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// commitTrigger validates the trigger and writes its persistent side
+// effects to the batch. It returns a function applying the trigger's
+// in-memory side effects, which the caller must invoke if and only if
+// the batch commits, so that the range's in-memory metadata never
+// diverges from its persisted state.
+//
+// TODO(agent): add a merge trigger once ranges can be merged.
+func (r *Range) commitTrigger(batch engine.Engine, trigger *proto.CommitTrigger) (func(), error) {
+	switch {
+	case trigger.SplitTrigger != nil && trigger.ChangeReplicasTrigger != nil:
+		return nil, util.Errorf("at most one commit trigger may be specified: %+v", trigger)
+	case trigger.SplitTrigger != nil:
+		return r.splitTrigger(batch, trigger.SplitTrigger)
+	case trigger.ChangeReplicasTrigger != nil:
+		return r.changeReplicasTrigger(trigger.ChangeReplicasTrigger)
+	}
+	return nil, nil
+}
+
+// changeReplicasTrigger is called on a successful commit of a
+// transaction changing the replicas of the range, whose descriptor
+// the transaction has written. The returned function replaces the
+// replicas of the in-memory descriptor. The replica executing the
+// trigger can't be removed by it.
+//
+// Each change adds or removes a single replica. Without joint
+// consensus, a replica can't be swapped for another atomically, so a
+// replica is replaced by adding its replacement before removing it;
+// the range never has fewer replicas than it started with.
+//
+// TODO(agent): add replicas as non-voting learners which catch up via
+// snapshot before they're promoted to voters, so that a new, empty
+// replica doesn't count toward quorum. This requires non-voting
+// members, which the raft implementation doesn't support.
+//
+// TODO(agent): propose the Raft configuration change from the
+// returned function once ranges are replicated via multiraft.
+func (r *Range) changeReplicasTrigger(change *proto.ChangeReplicasTrigger) (func(), error) {
+	r.RLock()
+	startKey, endKey := r.Desc.StartKey, r.Desc.EndKey
+	current := make(map[int32]struct{}, len(r.Desc.Replicas))
+	for _, replica := range r.Desc.Replicas {
+		current[replica.StoreID] = struct{}{}
+	}
+	r.RUnlock()
+	if !bytes.Equal(startKey, change.UpdatedDesc.StartKey) || !bytes.Equal(endKey, change.UpdatedDesc.EndKey) {
+		return nil, util.Errorf("range %q-%q does not match updated descriptor %q-%q", startKey, endKey,
+			change.UpdatedDesc.StartKey, change.UpdatedDesc.EndKey)
+	}
+	var found bool
+	for _, replica := range change.UpdatedDesc.Replicas {
+		if replica.StoreID == r.rm.StoreID() {
+			found = true
+		}
+	}
+	if !found {
+		return nil, util.Errorf("replica of range %d on store %d can't remove itself", r.RangeID, r.rm.StoreID())
+	}
+	var changes int
+	for _, replica := range change.UpdatedDesc.Replicas {
+		if _, ok := current[replica.StoreID]; ok {
+			delete(current, replica.StoreID)
+		} else {
+			changes++
+		}
+	}
+	if changes += len(current); changes > 1 {
+		return nil, util.Errorf("range %d may add or remove only one replica at a time; got %d changes",
+			r.RangeID, changes)
+	}
+	replicas := append([]proto.Replica(nil), change.UpdatedDesc.Replicas...)
+	return func() {
+		r.Lock()
+		r.Desc.Replicas = replicas
+		r.Unlock()
+	}, nil
+}
@@ -136,7 +136,8 @@ func meta2Key(key proto.Key) proto.Key {
 // TestUpdateRangeAddressing verifies range addressing records are
 // correctly updated on creation of new range descriptors.
 func TestUpdateRangeAddressing(t *testing.T) {
-	store, _ := createTestStore(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	testCases := []struct {
 		start, end proto.Key
 		expNew     []proto.Key
@@ -232,7 +233,8 @@ func TestUpdateRangeAddressing(t *testing.T) {
 // attempt to update range addressing records that would allow a split
 // of meta1 records.
 func TestUpdateRangeAddressingSplitMeta1(t *testing.T) {
-	store, _ := createTestStore(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	desc := &proto.RangeDescriptor{StartKey: meta1Key(proto.Key("a")), EndKey: engine.KeyMax}
 	if err := UpdateRangeAddressing(store.DB(), desc); err == nil {
 		t.Error("expected failure trying to update addressing records for meta1 split")
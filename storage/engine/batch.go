@@ -21,6 +21,7 @@ import (
 	"code.google.com/p/biogo.store/llrb"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/fault"
 )
 
 // Batch wrap an instance of Engine and provides a limited subset of
@@ -214,6 +215,9 @@ func (b *Batch) Commit() error {
 	if b.committed {
 		panic("this batch was already committed")
 	}
+	if _, err := fault.Hit(fault.EngineCommit, b.engine); err != nil {
+		return err
+	}
 	var batch []interface{}
 	b.updates.DoRange(func(n llrb.Comparable) (done bool) {
 		batch = append(batch, n)
@@ -277,6 +281,11 @@ func (b *Batch) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
 	return 0, util.Errorf("cannot get approximate size from a Batch")
 }
 
+// ApproximateKeyCount returns an error if called on a Batch.
+func (b *Batch) ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error) {
+	return 0, util.Errorf("cannot get approximate key count from a Batch")
+}
+
 // NewBatch returns a new Batch instance wrapping same underlying engine.
 func (b *Batch) NewBatch() Engine {
 	return &Batch{engine: b.engine}
@@ -0,0 +1,115 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"strings"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// minCompressBytes is the size below which values are stored
+// uncompressed regardless of the compression setting; the DEFLATE
+// framing would likely outweigh any savings.
+const minCompressBytes = 64
+
+// ParseCompression returns the compression named by a zone config's
+// compression setting. The name is case-insensitive and the empty
+// string specifies UNCOMPRESSED.
+func ParseCompression(name string) (proto.Compression, error) {
+	if name == "" {
+		return proto.UNCOMPRESSED, nil
+	}
+	c, ok := proto.Compression_value[strings.ToUpper(name)]
+	if !ok {
+		return proto.UNCOMPRESSED, util.Errorf("unknown compression %q", name)
+	}
+	return proto.Compression(c), nil
+}
+
+// compressValue compresses the bytes of value with compression c.
+// The value is left unchanged, and false returned, if it holds no
+// bytes, is too small to compress or doesn't shrink when compressed.
+// Otherwise, the value's bytes are replaced by a compressed copy.
+func compressValue(value *proto.MVCCValue, c proto.Compression) (bool, error) {
+	if c == proto.UNCOMPRESSED || value.Value == nil || len(value.Value.Bytes) < minCompressBytes {
+		return false, nil
+	}
+	var buf bytes.Buffer
+	switch c {
+	case proto.DEFLATE:
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return false, err
+		}
+		if _, err := w.Write(value.Value.Bytes); err != nil {
+			return false, err
+		}
+		if err := w.Close(); err != nil {
+			return false, err
+		}
+	default:
+		return false, util.Errorf("unknown compression %s", c)
+	}
+	if buf.Len() >= len(value.Value.Bytes) {
+		return false, nil
+	}
+	// Copy the value so that the caller's bytes are left intact.
+	v := *value.Value
+	v.Bytes = buf.Bytes()
+	value.Value = &v
+	value.Compression = c.Enum()
+	return true, nil
+}
+
+// decompressValue replaces the bytes of a compressed value by their
+// decompressed form.
+func decompressValue(value *proto.MVCCValue) error {
+	c := value.GetCompression()
+	if c == proto.UNCOMPRESSED || value.Value == nil {
+		return nil
+	}
+	switch c {
+	case proto.DEFLATE:
+		r := flate.NewReader(bytes.NewReader(value.Value.Bytes))
+		defer r.Close()
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return util.Errorf("unable to decompress value: %s", err)
+		}
+		value.Value.Bytes = b
+	default:
+		return util.Errorf("unknown compression %s", c)
+	}
+	value.Compression = nil
+	return nil
+}
+
+// UnmarshalMVCCValue unmarshals an MVCC value from data and
+// decompresses its bytes.
+func UnmarshalMVCCValue(data []byte, value *proto.MVCCValue) error {
+	if err := gogoproto.Unmarshal(data, value); err != nil {
+		return err
+	}
+	return decompressValue(value)
+}
@@ -0,0 +1,174 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+func TestParseCompression(t *testing.T) {
+	testCases := []struct {
+		name   string
+		expC   proto.Compression
+		expErr bool
+	}{
+		{"", proto.UNCOMPRESSED, false},
+		{"uncompressed", proto.UNCOMPRESSED, false},
+		{"deflate", proto.DEFLATE, false},
+		{"DEFLATE", proto.DEFLATE, false},
+		{"snappy", proto.UNCOMPRESSED, true},
+	}
+	for i, test := range testCases {
+		c, err := ParseCompression(test.name)
+		if (err != nil) != test.expErr {
+			t.Errorf("%d: expected error %t; got %v", i, test.expErr, err)
+		}
+		if c != test.expC {
+			t.Errorf("%d: expected %s; got %s", i, test.expC, c)
+		}
+	}
+}
+
+// TestMVCCCompression verifies that values are compressed at rest
+// when compression is enabled, are read back intact and are
+// accounted for in the MVCC stats at their compressed size.
+func TestMVCCCompression(t *testing.T) {
+	large := proto.Value{Bytes: bytes.Repeat([]byte("compressible "), 100)}
+	large.InitChecksum(testKey1)
+	small := proto.Value{Bytes: []byte("small")}
+
+	mvcc, engine := createTestMVCC()
+	mvcc.Compression = proto.DEFLATE
+	plain, _ := createTestMVCC()
+	for _, m := range []*MVCC{mvcc, plain} {
+		if err := m.Put(testKey1, makeTS(1, 0), large, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Put(testKey2, makeTS(1, 0), small, nil); err != nil {
+			t.Fatal(err)
+		}
+		// Commit a compressed intent at a pushed timestamp, which
+		// rewrites its version.
+		if err := m.Put(testKey3, makeTS(1, 0), large, txn1); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ResolveWriteIntent(testKey3, makeTxn(txn1Commit, makeTS(2, 0))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mvcc.engine.(*Batch).Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the encoding of the values at rest.
+	for _, test := range []struct {
+		key     proto.Key
+		ts      proto.Timestamp
+		expComp proto.Compression
+	}{
+		{testKey1, makeTS(1, 0), proto.DEFLATE},
+		{testKey2, makeTS(1, 0), proto.UNCOMPRESSED},
+		{testKey3, makeTS(2, 0), proto.DEFLATE},
+	} {
+		data, err := engine.Get(MVCCEncodeVersionKey(test.key, test.ts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := &proto.MVCCValue{}
+		if err := gogoproto.Unmarshal(data, stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored.GetCompression() != test.expComp {
+			t.Errorf("%q: expected compression %s; got %s", test.key, test.expComp, stored.GetCompression())
+		}
+	}
+
+	// Verify values are decompressed on read.
+	mvcc = NewMVCC(engine)
+	for _, key := range []proto.Key{testKey1, testKey3} {
+		value, err := mvcc.Get(key, makeTS(3, 0), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value.Bytes, large.Bytes) {
+			t.Errorf("%q: expected decompressed value; got %q", key, value.Bytes)
+		}
+		// The checksum covers the uncompressed bytes.
+		if key.Equal(testKey1) {
+			if err := value.Verify(key); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	kvs, err := mvcc.Scan(testKey1, testKey4, 0, makeTS(3, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 3 || !bytes.Equal(kvs[0].Value.Bytes, large.Bytes) || !bytes.Equal(kvs[1].Value.Bytes, small.Bytes) {
+		t.Errorf("unexpected scan results %v", kvs)
+	}
+	if err := mvcc.IterateCommitted(testKey1, testKey2, func(kv proto.KeyValue) (bool, error) {
+		if !bytes.Equal(kv.Value.Bytes, large.Bytes) {
+			t.Errorf("expected decompressed value; got %q", kv.Value.Bytes)
+		}
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMVCCCompressionStats verifies the accounting of compressed
+// values.
+func TestMVCCCompressionStats(t *testing.T) {
+	large := proto.Value{Bytes: bytes.Repeat([]byte("compressible "), 100)}
+	mvcc, engine := createTestMVCC()
+	mvcc.Compression = proto.DEFLATE
+	plain, _ := createTestMVCC()
+	for _, m := range []*MVCC{mvcc, plain} {
+		if err := m.Put(testKey1, makeTS(1, 0), large, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mvcc.LogicalBytes != int64(len(large.Bytes)) {
+		t.Errorf("expected %d logical bytes; got %d", len(large.Bytes), mvcc.LogicalBytes)
+	}
+	if mvcc.CompressedBytes <= 0 || mvcc.CompressedBytes >= mvcc.LogicalBytes {
+		t.Errorf("expected compressed bytes in (0, %d); got %d", mvcc.LogicalBytes, mvcc.CompressedBytes)
+	}
+	if plain.LogicalBytes != 0 || plain.CompressedBytes != 0 {
+		t.Errorf("expected no compressed values; got %d, %d", plain.LogicalBytes, plain.CompressedBytes)
+	}
+	if mvcc.ValBytes >= plain.ValBytes {
+		t.Errorf("expected compressed val bytes %d < %d", mvcc.ValBytes, plain.ValBytes)
+	}
+	// The stats must agree with those computed from the stored data.
+	if err := mvcc.engine.(*Batch).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := MVCCComputeStats(engine, KeyMin, KeyMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.ValBytes != mvcc.ValBytes {
+		t.Errorf("expected computed val bytes %d; got %d", mvcc.ValBytes, ms.ValBytes)
+	}
+}
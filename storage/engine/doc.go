@@ -24,7 +24,9 @@ capability on top of an Engine instance.
 The Engine interface provides an API for key-value stores. InMem
 implements an in-memory engine using a sorted map. RocksDB implements
 an engine for data stored to local disk using RocksDB, a variant of
-LevelDB.
+LevelDB. LogDB implements a persistent engine in pure Go, keeping data
+in memory and persisting it via an append-only log. In builds without
+cgo, RocksDB is backed by LogDB.
 
 MVCC provides a multi-version concurrency control system on top of an
 engine. MVCC is the basis for Cockroach's support for distributed
@@ -92,6 +92,9 @@ type Engine interface {
 	// ApproximateSize returns the approximate number of bytes the engine is
 	// using to store data for the given range of keys.
 	ApproximateSize(start, end proto.EncodedKey) (uint64, error)
+	// ApproximateKeyCount returns the approximate number of keys the
+	// engine is storing in the given range of keys.
+	ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error)
 	// NewBatch returns a new instance of a batched engine which wraps
 	// this engine. Batched engines accumulate all mutations and apply
 	// them atomically on a call to Commit().
@@ -246,3 +249,8 @@ func ClearRange(engine Engine, start, end proto.EncodedKey) (int, error) {
 	}
 	return len(deletes), engine.WriteBatch(deletes)
 }
+
+// emptyKeyError returns an error for an operation on an empty key.
+func emptyKeyError() error {
+	return util.ErrorSkipFrames(1, "attempted access to empty key")
+}
@@ -67,8 +67,20 @@ func runWithAllEngines(test func(e Engine, t *testing.T), t *testing.T) {
 		}
 	}(t)
 
+	logDB := NewLogDB(proto.Attributes{Attrs: []string{"ssd"}}, util.CreateTempDirectory())
+	if err := logDB.Start(); err != nil {
+		t.Fatalf("could not start logdb instance: %v", err)
+	}
+	defer func(t *testing.T) {
+		logDB.Stop()
+		if err := logDB.Destroy(); err != nil {
+			t.Errorf("could not delete logdb: %v", err)
+		}
+	}(t)
+
 	test(inMem, t)
 	test(rocksdb, t)
+	test(logDB, t)
 }
 
 // TestEngineWriteBatch writes a batch containing 10K rows (all the
@@ -533,6 +545,32 @@ func TestApproximateSize(t *testing.T) {
 	}, t)
 }
 
+func TestApproximateKeyCount(t *testing.T) {
+	runWithAllEngines(func(engine Engine, t *testing.T) {
+		count := 10000
+		keys := make([]proto.EncodedKey, count)
+		for i := 0; i < count; i++ {
+			keys[i] = []byte(fmt.Sprintf("key%8d", i))
+		}
+		insertKeys(keys, engine, t)
+		if rocksdb, ok := engine.(*RocksDB); ok {
+			if err := rocksdb.Flush(); err != nil {
+				t.Fatalf("Error flushing RocksDB: %s", err)
+			}
+		}
+
+		for _, n := range []int{count, count / 2, count / 4} {
+			c, err := engine.ApproximateKeyCount(keys[0], keys[n-1])
+			if err != nil {
+				t.Fatalf("Error from ApproximateKeyCount(): %s", err)
+			}
+			if min, max := uint64(float64(n)*0.85), uint64(float64(n)*1.15); c < min || c > max {
+				t.Errorf("ApproximateKeyCount() of %d keys returned %d; expected between %d and %d", n, c, min, max)
+			}
+		}
+	}, t)
+}
+
 func insertKeys(keys []proto.EncodedKey, engine Engine, t *testing.T) {
 	insertKeysAndValues(keys, nil, engine, t)
 }
@@ -20,6 +20,7 @@ package engine
 import (
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
 )
@@ -50,58 +51,74 @@ func (gc *GarbageCollector) MVCCPrefix(key proto.EncodedKey) int {
 // Filter makes decisions about garbage collection based on the
 // garbage collection policy for batches of values for the same key.
 // The GC policy is determined via the policyFn specified when the
-// GarbageCollector was created. Returns a slice of deletions, one
+// GarbageCollector was created: versions which aren't visible at any
+// timestamp within the policy's TTL of the time at the start of GC
+// are deleted (see filterVersions). Returns a slice of deletions, one
 // per incoming keys. If an index in the returned array is set to
 // true, then that value will be garbage collected.
 func (gc *GarbageCollector) Filter(keys []proto.EncodedKey, values [][]byte) []bool {
 	if len(keys) == 1 {
 		return nil
 	}
-	toDelete := make([]bool, len(keys))
 	// Decode the first key and make sure it's an MVCC metadata key.
-	dKey, ts, isValue := MVCCDecodeKey(keys[0])
+	dKey, _, isValue := MVCCDecodeKey(keys[0])
 	if isValue {
 		log.Errorf("unexpected MVCC value encountered: %q", keys[0])
-		return toDelete
+		return make([]bool, len(keys))
 	}
 	// Using first key, look up the policy which applies to this set of MVCC values.
 	policy := gc.policyFn(dKey)
 	if policy == nil || policy.TTLSeconds <= 0 {
 		return nil
 	}
-	expiration := gc.now
-	expiration.WallTime -= int64(policy.TTLSeconds) * 1E9
+	threshold := gc.now
+	threshold.WallTime -= int64(policy.TTLSeconds) * 1E9
+	return filterVersions(keys, values, threshold)
+}
 
-	var survivors bool
-	// Loop over remaining values. All should be MVCC versions.
+// filterVersions marks for deletion the versions of a key which
+// aren't visible to reads at any timestamp at or after threshold:
+// versions shadowed by a newer version at or below the threshold,
+// and the version visible at the threshold itself if it's a deletion
+// tombstone or has expired by then. Versions newer than the threshold
+// are always retained, so that deleted and overwritten values remain
+// readable at historical timestamps until they fall behind the
+// threshold. If no versions survive, the MVCC metadata is marked as
+// well. keys[0] is the key's MVCC metadata and the remaining keys
+// are its versions, newest first.
+func filterVersions(keys []proto.EncodedKey, values [][]byte, threshold proto.Timestamp) []bool {
+	toDelete := make([]bool, len(keys))
+	var visible, survivors bool
 	for i, key := range keys[1:] {
-		_, ts, isValue = MVCCDecodeKey(key)
+		_, ts, isValue := MVCCDecodeKey(key)
 		if !isValue {
 			log.Errorf("unexpected MVCC metadata encountered: %q", key)
 			return make([]bool, len(keys))
 		}
+		if visible {
+			// Shadowed at the threshold by a newer version.
+			toDelete[i+1] = true
+			continue
+		}
+		if threshold.Less(ts) {
+			survivors = true
+			continue
+		}
+		// This is the version visible to reads at the threshold.
+		visible = true
 		mvccVal := proto.MVCCValue{}
 		if err := gogoproto.Unmarshal(values[i+1], &mvccVal); err != nil {
 			log.Errorf("unable to unmarshal MVCC value %q: %v", key, err)
 			return make([]bool, len(keys))
 		}
-		if i == 0 {
-			// If the first value isn't a deletion tombstone, set survivors to true.
-			if !mvccVal.Deleted {
-				survivors = true
-			}
+		if mvccVal.Deleted || isExpired(mvccVal.Value, threshold) {
+			toDelete[i+1] = true
 		} else {
-			if ts.Less(expiration) {
-				// If we encounter a version older than our GC timestamp, mark for deletion.
-				toDelete[i+1] = true
-			} else if !mvccVal.Deleted {
-				// Otherwise, if not marked for GC and not a tombstone, set survivors true.
-				survivors = true
-			}
+			survivors = true
 		}
 	}
-	// If there are no remaining non-deleted, versioned entries, mark
-	// all keys for deletion, including the MVCC metadata entry.
+	// If there are no remaining versioned entries, mark all keys for
+	// deletion, including the MVCC metadata entry.
 	if !survivors {
 		for i := range keys {
 			toDelete[i] = true
@@ -109,3 +126,97 @@ func (gc *GarbageCollector) Filter(keys []proto.EncodedKey, values [][]byte) []b
 	}
 	return toDelete
 }
+
+// isExpired returns true if the value has an expiration which is not
+// later than the supplied timestamp.
+func isExpired(value *proto.Value, timestamp proto.Timestamp) bool {
+	return value != nil && value.Expiration != nil && !timestamp.Less(*value.Expiration)
+}
+
+// MakeRangeGCThresholdKey returns the key for accessing the GC
+// threshold of the specified range ID.
+func MakeRangeGCThresholdKey(rangeID int64) proto.Key {
+	return MakeKey(KeyLocalRangeGCThresholdPrefix, encoding.EncodeInt(nil, rangeID))
+}
+
+// GetRangeGCThreshold fetches the GC threshold of the specified range
+// from the provided engine. If none is found, returns the zero
+// timestamp.
+func GetRangeGCThreshold(engine Engine, rangeID int64) (proto.Timestamp, error) {
+	var threshold proto.Timestamp
+	_, _, _, err := GetProto(engine, MVCCEncodeKey(MakeRangeGCThresholdKey(rangeID)), &threshold)
+	return threshold, err
+}
+
+// SetRangeGCThreshold writes the GC threshold of the specified range
+// via the provided engine.
+func SetRangeGCThreshold(engine Engine, rangeID int64, threshold proto.Timestamp) error {
+	_, _, err := PutProto(engine, MVCCEncodeKey(MakeRangeGCThresholdKey(rangeID)), &threshold)
+	return err
+}
+
+// GarbageCollect deletes the versions of keys in the range
+// [key, endKey) which aren't visible to reads at any timestamp at or
+// after threshold (see filterVersions), and returns the number of
+// versions deleted. Keys with unresolved intents are skipped. The
+// change to the MVCC stats is accumulated for MergeStats.
+func (mvcc *MVCC) GarbageCollect(key, endKey proto.Key, threshold proto.Timestamp) (int64, error) {
+	if key.Less(KeyLocalMax) {
+		key = KeyLocalMax
+	}
+	var toClear []proto.EncodedKey
+	var deleted int64
+	var keys []proto.EncodedKey
+	var values [][]byte
+	meta := &proto.MVCCMetadata{}
+	// collect marks the versions of the key accumulated in keys and
+	// values which are to be deleted.
+	collect := func() {
+		if len(keys) < 2 || meta.Txn != nil {
+			return
+		}
+		toDelete := filterVersions(keys, values, threshold)
+		for i := 1; i < len(keys); i++ {
+			if toDelete[i] {
+				toClear = append(toClear, keys[i])
+				mvcc.KeyBytes -= int64(len(keys[i]))
+				mvcc.ValBytes -= int64(len(values[i]))
+				mvcc.ValCount--
+				deleted++
+			}
+		}
+		if toDelete[0] {
+			toClear = append(toClear, keys[0])
+			mvcc.KeyBytes -= int64(len(keys[0]))
+			mvcc.ValBytes -= int64(len(values[0]))
+			mvcc.KeyCount--
+			if !meta.Deleted {
+				mvcc.LiveBytes -= int64(len(keys[0])+len(values[0])) + int64(len(keys[1])+len(values[1]))
+				mvcc.LiveCount--
+			}
+		}
+	}
+	err := mvcc.engine.Iterate(MVCCEncodeKey(key), MVCCEncodeKey(endKey), func(kv proto.RawKeyValue) (bool, error) {
+		if _, _, isValue := MVCCDecodeKey(kv.Key); !isValue {
+			collect()
+			keys, values = nil, nil
+			meta.Reset()
+			if err := gogoproto.Unmarshal(kv.Value, meta); err != nil {
+				return false, util.Errorf("unable to unmarshal MVCC metadata %q: %s", kv.Key, err)
+			}
+		}
+		keys = append(keys, kv.Key)
+		values = append(values, kv.Value)
+		return false, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	collect()
+	for _, k := range toClear {
+		if err := mvcc.engine.Clear(k); err != nil {
+			return 0, err
+		}
+	}
+	return deleted, nil
+}
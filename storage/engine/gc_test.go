@@ -55,6 +55,16 @@ func serializedMVCCValue(deleted bool, t *testing.T) []byte {
 	return data
 }
 
+func serializedExpiringMVCCValue(expiration int64, t *testing.T) []byte {
+	data, err := gogoproto.Marshal(&proto.MVCCValue{
+		Value: &proto.Value{Bytes: []byte("v"), Expiration: &proto.Timestamp{WallTime: expiration}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+	return data
+}
+
 // TestGarbageCollectorMVCCPrefix verifies that MVCC variants of same
 // key are grouped together and non-MVCC keys are considered singly.
 func TestGarbageCollectorMVCCPrefix(t *testing.T) {
@@ -97,6 +107,7 @@ func TestGarbageCollectorFilter(t *testing.T) {
 	e := []byte{}
 	n := serializedMVCCValue(false, t)
 	d := serializedMVCCValue(true, t)
+	x := serializedExpiringMVCCValue(25E8, t)
 	testData := []struct {
 		time      proto.Timestamp
 		keys      []proto.EncodedKey
@@ -104,9 +115,9 @@ func TestGarbageCollectorFilter(t *testing.T) {
 		expDelete []bool
 	}{
 		{makeTS(0, 0), aKeys, [][]byte{e, n, n, n}, []bool{false, false, false, false}},
-		{makeTS(0, 0), aKeys, [][]byte{e, d, d, d}, []bool{true, true, true, true}},
+		{makeTS(0, 0), aKeys, [][]byte{e, d, d, d}, []bool{false, false, false, false}},
 		{makeTS(0, 0), bKeys, [][]byte{e, n, n}, []bool{false, false, false}},
-		{makeTS(0, 0), bKeys, [][]byte{e, d, d}, []bool{true, true, true}},
+		{makeTS(0, 0), bKeys, [][]byte{e, d, d}, []bool{false, false, false}},
 		{makeTS(0, 0), cKeys, [][]byte{n}, nil},
 		{makeTS(1E9, 0), aKeys, [][]byte{e, n, n, n}, []bool{false, false, false, false}},
 		{makeTS(1E9, 0), bKeys, [][]byte{e, n, n}, []bool{false, false, false}},
@@ -116,6 +127,12 @@ func TestGarbageCollectorFilter(t *testing.T) {
 		{makeTS(2E9, 0), cKeys, [][]byte{n}, nil},
 		{makeTS(3E9, 0), aKeys, [][]byte{e, n, n, n}, []bool{false, false, true, true}},
 		{makeTS(3E9, 0), aKeys, [][]byte{e, d, n, n}, []bool{true, true, true, true}},
+		{makeTS(2E9, 0), aKeys, [][]byte{e, x, n, n}, []bool{false, false, false, false}},
+		{makeTS(3E9, 0), aKeys, [][]byte{e, x, n, n}, []bool{false, false, true, true}},
+		{makeTS(4E9, 0), bKeys, [][]byte{e, x, n}, []bool{false, false, true}},
+		{makeTS(5E9, 0), bKeys, [][]byte{e, x, n}, []bool{true, true, true}},
+		{makeTS(2E9, 0), aKeys, [][]byte{e, n, n, d}, []bool{false, false, false, true}},
+		{makeTS(3E9, 0), bKeys, [][]byte{e, d, n}, []bool{false, false, false}},
 		{makeTS(3E9, 0), bKeys, [][]byte{e, n, n}, []bool{false, false, false}},
 		{makeTS(3E9, 0), cKeys, [][]byte{n}, nil},
 		{makeTS(4E9, 0), aKeys, [][]byte{e, n, n, n}, []bool{false, false, true, true}},
@@ -135,3 +152,85 @@ func TestGarbageCollectorFilter(t *testing.T) {
 		}
 	}
 }
+
+// TestMVCCGarbageCollect verifies that garbage collection deletes
+// only versions which aren't visible at or after the threshold, that
+// keys with intents are skipped, and that the MVCC stats are updated
+// to match the remaining data.
+func TestMVCCGarbageCollect(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	puts := []struct {
+		key   proto.Key
+		ts    proto.Timestamp
+		value *proto.Value // Deletion if nil
+		txn   *proto.Transaction
+	}{
+		{testKey1, makeTS(1E9, 0), &value1, nil},
+		{testKey1, makeTS(2E9, 0), &value2, nil},
+		{testKey1, makeTS(3E9, 0), nil, nil},
+		{testKey2, makeTS(1E9, 0), &value1, nil},
+		{testKey2, makeTS(2E9, 0), nil, nil},
+		{testKey3, makeTS(1E9, 0), &value1, nil},
+		{testKey3, makeTS(2E9, 0), &value2, txn1},
+	}
+	for i, p := range puts {
+		var err error
+		if p.value == nil {
+			err = mvcc.Delete(p.key, p.ts, p.txn)
+		} else {
+			err = mvcc.Put(p.key, p.ts, *p.value, p.txn)
+		}
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+	}
+
+	gcAt := func(threshold proto.Timestamp, expDeleted int64) {
+		before, err := MVCCComputeStats(mvcc.engine, KeyMin, KeyMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mvcc.MVCCStats = proto.MVCCStats{}
+		deleted, err := mvcc.GarbageCollect(KeyMin, KeyMax, threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != expDeleted {
+			t.Errorf("expected %d versions deleted at %s; got %d", expDeleted, threshold, deleted)
+		}
+		after, err := MVCCComputeStats(mvcc.engine, KeyMin, KeyMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before.Add(mvcc.MVCCStats)
+		if !reflect.DeepEqual(before, after) {
+			t.Errorf("expected stats %+v after GC at %s; got %+v", after, threshold, before)
+		}
+	}
+	expectValue := func(key proto.Key, ts proto.Timestamp, expValue *proto.Value) {
+		val, err := mvcc.Get(key, ts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (val == nil) != (expValue == nil) || (val != nil && !bytes.Equal(val.Bytes, expValue.Bytes)) {
+			t.Errorf("expected %q at %s to be %+v; got %+v", key, ts, expValue, val)
+		}
+	}
+
+	// The version of testKey1 visible at the threshold is retained,
+	// while testKey2 has been deleted as of the threshold. testKey3
+	// has an intent and is skipped.
+	gcAt(makeTS(25E8, 0), 3)
+	expectValue(testKey1, makeTS(25E8, 0), &value2)
+	expectValue(testKey1, makeTS(3E9, 0), nil)
+	expectValue(testKey2, makeTS(15E8, 0), nil)
+	expectValue(testKey3, makeTS(15E8, 0), &value1)
+
+	// Once its deletion falls behind the threshold, testKey1 is
+	// collected entirely.
+	gcAt(makeTS(35E8, 0), 2)
+	if ok, _, _, err := GetProto(mvcc.engine, MVCCEncodeKey(testKey1), &proto.MVCCMetadata{}); ok || err != nil {
+		t.Errorf("expected metadata of %q to be deleted; got %t, %v", testKey1, ok, err)
+	}
+	expectValue(testKey3, makeTS(15E8, 0), &value1)
+}
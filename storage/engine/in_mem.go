@@ -332,6 +332,18 @@ func (in *InMem) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
 	return size, nil
 }
 
+// ApproximateKeyCount counts the keys in the given key range.
+func (in *InMem) ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error) {
+	var count uint64
+	in.RLock()
+	defer in.RUnlock()
+	in.data.DoRange(func(node llrb.Comparable) bool {
+		count++
+		return false
+	}, proto.RawKeyValue{Key: start}, proto.RawKeyValue{Key: end})
+	return count, nil
+}
+
 // Returns a new Batch wrapping this in-memory engine.
 func (in *InMem) NewBatch() Engine {
 	return &Batch{engine: in}
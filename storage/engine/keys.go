@@ -176,14 +176,36 @@ var (
 	// KeyLocalIdent stores an immutable identifier for this store,
 	// created when the store is first bootstrapped.
 	KeyLocalIdent = MakeKey(KeyLocalPrefix, proto.Key("iden"))
+	// KeyLocalStoreVersion stores the on-disk format version of this
+	// store. The value is a proto.Value containing an integer.
+	KeyLocalStoreVersion = MakeKey(KeyLocalPrefix, proto.Key("vers"))
 	// KeyLocalRangeDescriptorPrefix is the prefix for keys storing
 	// range descriptors. The value is a struct of type RangeDescriptor.
 	KeyLocalRangeDescriptorPrefix = MakeKey(KeyLocalPrefix, proto.Key("rng-"))
-	// KeyLocalRangeStatPrefix is the prefix for range statistics.
+	// KeyLocalRangeGCThresholdPrefix is the prefix for range GC
+	// thresholds. The suffix is the range ID and the value is a
+	// proto.Timestamp.
+	KeyLocalRangeGCThresholdPrefix = MakeKey(KeyLocalPrefix, proto.Key("rgc-"))
+	// KeyLocalRangeLeaderLeasePrefix is the prefix for range leader
+	// leases. The suffix is the range ID and the value is a
+	// proto.Lease.
+	KeyLocalRangeLeaderLeasePrefix = MakeKey(KeyLocalPrefix, proto.Key("rll-"))
+	// KeyLocalRangeMVCCStatsPrefix is the prefix for range statistics.
+	// The suffix is the range ID and the value is a proto.MVCCStats.
+	KeyLocalRangeMVCCStatsPrefix = MakeKey(KeyLocalPrefix, proto.Key("rms-"))
+	// KeyLocalRangeStatPrefix is the prefix for per-stat range
+	// counters, which stores migrate to a proto.MVCCStats per range.
 	KeyLocalRangeStatPrefix = MakeKey(KeyLocalPrefix, proto.Key("rst-"))
+	// KeyLocalRangeTombstonePrefix is the prefix for range tombstones.
+	// The suffix is the Raft ID and the value is a proto.RangeTombstone.
+	KeyLocalRangeTombstonePrefix = MakeKey(KeyLocalPrefix, proto.Key("rtb-"))
 	// KeyLocalResponseCachePrefix is the prefix for keys storing command
 	// responses used to guarantee idempotency (see ResponseCache).
 	KeyLocalResponseCachePrefix = MakeKey(KeyLocalPrefix, proto.Key("res-"))
+	// KeyLocalResponseCacheSessionPrefix is the prefix for keys storing
+	// the acknowledged sequence numbers of client sessions, used to
+	// reject replays of completed commands (see ResponseCache).
+	KeyLocalResponseCacheSessionPrefix = MakeKey(KeyLocalPrefix, proto.Key("rss-"))
 	// KeyLocalStoreStatPrefix is the prefix for store statistics.
 	KeyLocalStoreStatPrefix = MakeKey(KeyLocalPrefix, proto.Key("sst-"))
 	// KeyLocalTransactionPrefix specifies the key prefix for
@@ -215,6 +237,20 @@ var (
 	// KeyMetaMax is the end of the range of addressing keys.
 	KeyMetaMax = MakeKey(KeySystemPrefix, proto.Key("\x01"))
 
+	// KeyAcctRecordPrefix specifies the key prefix for accounting
+	// records of tagged requests. The suffix is the accounting tag.
+	KeyAcctRecordPrefix = MakeKey(KeySystemPrefix, proto.Key("usage-"))
+	// KeyAuditLogHead is the last entry of the audit log, which the
+	// next entry is chained to.
+	KeyAuditLogHead = MakeKey(KeySystemPrefix, proto.Key("audit-head"))
+	// KeyAuditLogPrefix specifies the key prefix for audit log
+	// entries, which are keyed by sequence number.
+	KeyAuditLogPrefix = MakeKey(KeySystemPrefix, proto.Key("audit-log-"))
+	// KeyClusterVersion is the active cluster version. The value is
+	// an integer proto.ClusterVersion; clusters bootstrapped before
+	// versions were introduced have no value and are at
+	// proto.VersionBase.
+	KeyClusterVersion = MakeKey(KeySystemPrefix, proto.Key("cluster-version"))
 	// KeyConfigAccountingPrefix specifies the key prefix for accounting
 	// configurations. The suffix is the affected key prefix.
 	KeyConfigAccountingPrefix = MakeKey(KeySystemPrefix, proto.Key("acct"))
@@ -224,6 +260,10 @@ var (
 	// KeyConfigZonePrefix specifies the key prefix for zone
 	// configurations. The suffix is the affected key prefix.
 	KeyConfigZonePrefix = MakeKey(KeySystemPrefix, proto.Key("zone"))
+	// KeyIndexBackfillPrefix specifies key prefixes for the progress
+	// checkpoints of structured index backfills, keyed by encoded
+	// table and index IDs.
+	KeyIndexBackfillPrefix = MakeKey(KeySystemPrefix, proto.Key("idx-backfill-"))
 	// KeyNodeIDGenerator is the global node ID generator sequence.
 	KeyNodeIDGenerator = MakeKey(KeySystemPrefix, proto.Key("node-idgen"))
 	// KeyRaftIDGenerator is the global Raft consensus group ID generator sequence.
@@ -235,4 +275,15 @@ var (
 	// KeyStoreIDGeneratorPrefix specifies key prefixes for sequence
 	// generators, one per node, for store IDs.
 	KeyStoreIDGeneratorPrefix = MakeKey(KeySystemPrefix, proto.Key("store-idgen-"))
+	// KeyTableDescriptorPrefix specifies key prefixes for structured
+	// table descriptors, keyed by encoded table ID.
+	KeyTableDescriptorPrefix = MakeKey(KeySystemPrefix, proto.Key("table-desc-"))
+	// KeyTableIDGenerator is the global structured table ID generator sequence.
+	KeyTableIDGenerator = MakeKey(KeySystemPrefix, proto.Key("table-idgen"))
+	// KeyTableLeasePrefix specifies key prefixes for structured table
+	// schema change leases, keyed by encoded table ID.
+	KeyTableLeasePrefix = MakeKey(KeySystemPrefix, proto.Key("table-lease-"))
+	// KeyTableNamePrefix specifies key prefixes for the mapping from
+	// structured table names to table IDs.
+	KeyTableNamePrefix = MakeKey(KeySystemPrefix, proto.Key("table-name-"))
 )
@@ -0,0 +1,47 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// MakeRangeLeaderLeaseKey returns the key for accessing the leader
+// lease of the specified range ID.
+func MakeRangeLeaderLeaseKey(rangeID int64) proto.Key {
+	return MakeKey(KeyLocalRangeLeaderLeasePrefix, encoding.EncodeInt(nil, rangeID))
+}
+
+// GetRangeLeaderLease fetches the leader lease of the specified range
+// from the provided engine. If none is found, returns nil.
+func GetRangeLeaderLease(engine Engine, rangeID int64) (*proto.Lease, error) {
+	lease := &proto.Lease{}
+	ok, _, _, err := GetProto(engine, MVCCEncodeKey(MakeRangeLeaderLeaseKey(rangeID)), lease)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// SetRangeLeaderLease writes the leader lease of the specified range
+// via the provided engine.
+func SetRangeLeaderLease(engine Engine, rangeID int64, lease *proto.Lease) error {
+	_, _, err := PutProto(engine, MVCCEncodeKey(MakeRangeLeaderLeaseKey(rangeID)), lease)
+	return err
+}
@@ -0,0 +1,471 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+const (
+	// logDBFilename is the name of the log file within the data directory.
+	logDBFilename = "logdb.log"
+	// logDBHeaderSize is the size of a log record header: the payload
+	// length followed by its CRC32 checksum.
+	logDBHeaderSize = 8
+	// defaultLogDBCompactionThreshold is the default log size in bytes
+	// past which the log is rewritten as a checkpoint.
+	defaultLogDBCompactionThreshold = 64 << 20
+)
+
+// Log record operation types.
+const (
+	logOpPut byte = iota + 1
+	logOpMerge
+	logOpDelete
+)
+
+// LogDB is a persistent engine implemented in pure Go, requiring no
+// cgo. Data is held in memory by an embedded InMem engine and made
+// durable by appending each mutation to a log file in the data
+// directory. Each write or write batch is a single checksummed
+// record, so batches are applied atomically on recovery; a torn
+// record at the tail of the log is discarded. When the log grows past
+// CompactionThreshold bytes, it's rewritten as a checkpoint containing
+// just the live key/value pairs.
+//
+// Writes are handed to the operating system but not synced, so they
+// survive a process crash but not necessarily a machine crash; call
+// Flush to sync. Snapshots are held in memory. Since all data resides
+// in memory, LogDB is intended for development, testing and small
+// embedded deployments.
+type LogDB struct {
+	*InMem
+	dir string // The data directory
+
+	// CompactionThreshold is the log size in bytes past which the log
+	// is rewritten as a checkpoint.
+	CompactionThreshold int64
+
+	mu      sync.Mutex // Serializes mutations and protects the fields below
+	file    *os.File
+	logSize int64
+	err     error // Set if applied writes couldn't be logged
+}
+
+// NewLogDB allocates and returns a new LogDB object.
+func NewLogDB(attrs proto.Attributes, dir string) *LogDB {
+	return &LogDB{
+		InMem:               NewInMem(attrs, math.MaxInt64),
+		dir:                 dir,
+		CompactionThreshold: defaultLogDBCompactionThreshold,
+	}
+}
+
+// String formatter.
+func (db *LogDB) String() string {
+	return fmt.Sprintf("%s=%s", db.attrs, db.dir)
+}
+
+// Start creates the data directory if necessary, opens the log and
+// replays it to recover the engine's contents. Subsequent calls to
+// this method on an open engine are no-ops.
+func (db *LogDB) Start() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(db.dir, 0755); err != nil {
+		return util.Errorf("unable to create data directory %s: %s", db.dir, err)
+	}
+	file, err := os.OpenFile(filepath.Join(db.dir, logDBFilename), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	// Recover into empty in-memory data in case the engine is being
+	// restarted.
+	db.InMem = NewInMem(db.attrs, math.MaxInt64)
+	size, err := db.replay(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	// Discard any torn record at the tail of the log.
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(size, 0); err != nil {
+		file.Close()
+		return err
+	}
+	db.file = file
+	db.logSize = size
+	return nil
+}
+
+// replay applies each complete record in the log to the in-memory
+// data, returning the offset following the last complete record.
+func (db *LogDB) replay(file *os.File) (int64, error) {
+	r := bufio.NewReader(file)
+	var offset int64
+	header := make([]byte, logDBHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF {
+				log.Warningf("discarding torn log record header at offset %d in %s", offset, db.dir)
+			}
+			return offset, nil
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			log.Warningf("discarding torn log record at offset %d in %s", offset, db.dir)
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			log.Warningf("discarding corrupt log record at offset %d in %s", offset, db.dir)
+			return offset, nil
+		}
+		cmds, err := decodeLogRecord(payload)
+		if err != nil {
+			return 0, util.Errorf("unable to decode log record at offset %d: %s", offset, err)
+		}
+		if err := db.InMem.WriteBatch(cmds); err != nil {
+			return 0, err
+		}
+		offset += int64(logDBHeaderSize + len(payload))
+	}
+}
+
+// Stop syncs and closes the log.
+func (db *LogDB) Stop() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return
+	}
+	if err := db.file.Sync(); err != nil {
+		log.Warningf("unable to sync log in %s: %s", db.dir, err)
+	}
+	db.file.Close()
+	db.file = nil
+}
+
+// Put sets the given key to the value provided.
+func (db *LogDB) Put(key proto.EncodedKey, value []byte) error {
+	return db.WriteBatch([]interface{}{BatchPut{proto.RawKeyValue{Key: key, Value: value}}})
+}
+
+// Merge implements a merge operation which updates the existing value
+// stored under key based on the value passed. See the documentation
+// of goMerge and goMergeInit for details.
+func (db *LogDB) Merge(key proto.EncodedKey, value []byte) error {
+	return db.WriteBatch([]interface{}{BatchMerge{proto.RawKeyValue{Key: key, Value: value}}})
+}
+
+// Clear removes the item from the db with the given key.
+func (db *LogDB) Clear(key proto.EncodedKey) error {
+	return db.WriteBatch([]interface{}{BatchDelete{proto.RawKeyValue{Key: key}}})
+}
+
+// WriteBatch applies the specified writes, merges and deletions to
+// the in-memory data and then appends them to the log as a single
+// record. The batch is applied while holding the in-memory data's
+// lock, so readers never observe part of a batch; if a command fails
+// to apply, those preceding it are undone and nothing is logged. If
+// the record can't be written, the in-memory data is ahead of the
+// log; the engine then fails all further writes. The list must only
+// contain elements of type Batch{Put,Merge,Delete}.
+func (db *LogDB) WriteBatch(cmds []interface{}) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return util.Errorf("engine at %s is not started", db.dir)
+	}
+	if db.err != nil {
+		return db.err
+	}
+	// Encoding the record validates the batch before it's applied.
+	payload, err := encodeLogRecord(cmds)
+	if err != nil {
+		return err
+	}
+	if err := db.applyBatch(cmds); err != nil {
+		return err
+	}
+	if err := db.logLocked(payload); err != nil {
+		db.err = util.Errorf("unable to log applied writes in %s: %s", db.dir, err)
+		return db.err
+	}
+	if db.logSize > db.CompactionThreshold {
+		if err := db.compactLocked(); err != nil {
+			log.Warningf("unable to compact log in %s: %s", db.dir, err)
+		}
+	}
+	return nil
+}
+
+// applyBatch applies the commands to the in-memory data while holding
+// its lock. If a command fails to apply, the prior values of the keys
+// written by the commands preceding it are restored.
+func (db *LogDB) applyBatch(cmds []interface{}) error {
+	in := db.InMem
+	in.Lock()
+	defer in.Unlock()
+	prior := make([]logDBPrior, 0, len(cmds))
+	for i, e := range cmds {
+		var err error
+		switch v := e.(type) {
+		case BatchDelete:
+			prior = append(prior, db.priorLocked(v.Key))
+			err = in.clearLocked(v.Key)
+		case BatchPut:
+			prior = append(prior, db.priorLocked(v.Key))
+			err = in.putLocked(v.Key, v.Value)
+		case BatchMerge:
+			prior = append(prior, db.priorLocked(v.Key))
+			err = in.mergeLocked(v.Key, v.Value)
+		default:
+			panic(fmt.Sprintf("illegal operation #%d passed to writeBatch: %T", i, v))
+		}
+		if err != nil {
+			for j := len(prior) - 1; j >= 0; j-- {
+				db.restoreLocked(prior[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// A logDBPrior is the state of a key before a batch wrote to it.
+type logDBPrior struct {
+	kv     proto.RawKeyValue
+	exists bool
+}
+
+// priorLocked returns the current state of key. Assumes the in-memory
+// data's lock is held.
+func (db *LogDB) priorLocked(key proto.EncodedKey) logDBPrior {
+	if val := db.InMem.data.Get(proto.RawKeyValue{Key: key}); val != nil {
+		return logDBPrior{kv: val.(proto.RawKeyValue), exists: true}
+	}
+	return logDBPrior{kv: proto.RawKeyValue{Key: key}}
+}
+
+// restoreLocked restores a key to the state returned by priorLocked.
+// Unlike a put, the restore can't fail for lack of capacity. Assumes
+// the in-memory data's lock is held.
+func (db *LogDB) restoreLocked(prior logDBPrior) {
+	in := db.InMem
+	in.clearLocked(prior.kv.Key)
+	if prior.exists {
+		in.usedBytes += computeSize(prior.kv)
+		in.data.Insert(prior.kv)
+	}
+}
+
+// logLocked appends the record payload to the log. A partially
+// written record is truncated. Assumes db.mu is held.
+func (db *LogDB) logLocked(payload []byte) error {
+	if err := writeLogRecord(db.file, payload); err != nil {
+		if tErr := db.file.Truncate(db.logSize); tErr != nil {
+			log.Warningf("unable to truncate torn log record in %s: %s", db.dir, tErr)
+		} else if _, sErr := db.file.Seek(db.logSize, 0); sErr != nil {
+			log.Warningf("unable to seek to end of log in %s: %s", db.dir, sErr)
+		}
+		return err
+	}
+	db.logSize += int64(logDBHeaderSize + len(payload))
+	return nil
+}
+
+// compactLocked rewrites the log as a checkpoint containing a single
+// put for each live key/value pair. The checkpoint is written to a
+// temporary file which atomically replaces the log. Assumes db.mu is
+// held.
+func (db *LogDB) compactLocked() error {
+	path := filepath.Join(db.dir, logDBFilename)
+	tmp, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	var size int64
+	if err = db.InMem.Iterate(proto.EncodedKey(KeyMin), proto.EncodedKey(KeyMax), func(kv proto.RawKeyValue) (bool, error) {
+		payload, err := encodeLogRecord([]interface{}{BatchPut{kv}})
+		if err != nil {
+			return true, err
+		}
+		size += int64(logDBHeaderSize + len(payload))
+		return false, writeLogRecord(w, payload)
+	}); err == nil {
+		if err = w.Flush(); err == nil {
+			err = tmp.Sync()
+		}
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		return err
+	}
+	db.file.Close()
+	db.file = tmp
+	db.logSize = size
+	return nil
+}
+
+// Capacity queries the underlying file system for disk capacity
+// information.
+func (db *LogDB) Capacity() (StoreCapacity, error) {
+	var fs syscall.Statfs_t
+	var capacity StoreCapacity
+	if err := syscall.Statfs(db.dir, &fs); err != nil {
+		return capacity, err
+	}
+	capacity.Capacity = int64(fs.Bsize) * int64(fs.Blocks)
+	capacity.Available = int64(fs.Bsize) * int64(fs.Bavail)
+	return capacity, nil
+}
+
+// Flush syncs the log to disk.
+func (db *LogDB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return nil
+	}
+	return db.file.Sync()
+}
+
+// Destroy removes the engine's data directory.
+func (db *LogDB) Destroy() error {
+	return os.RemoveAll(db.dir)
+}
+
+// NewBatch returns a new Batch wrapping this engine.
+func (db *LogDB) NewBatch() Engine {
+	return &Batch{engine: db}
+}
+
+// writeLogRecord writes the payload preceded by its header.
+func writeLogRecord(w io.Writer, payload []byte) error {
+	header := make([]byte, logDBHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// encodeLogRecord encodes a list of batch operations as a log record
+// payload. Each operation is encoded as its type followed by the
+// uvarint-prefixed key and value.
+func encodeLogRecord(cmds []interface{}) ([]byte, error) {
+	var buf []byte
+	for i, e := range cmds {
+		var op byte
+		var kv proto.RawKeyValue
+		switch v := e.(type) {
+		case BatchPut:
+			op, kv = logOpPut, v.RawKeyValue
+		case BatchMerge:
+			op, kv = logOpMerge, v.RawKeyValue
+		case BatchDelete:
+			op, kv = logOpDelete, v.RawKeyValue
+		default:
+			panic(fmt.Sprintf("illegal operation #%d passed to writeBatch: %T", i, v))
+		}
+		if len(kv.Key) == 0 {
+			return nil, emptyKeyError()
+		}
+		buf = append(buf, op)
+		buf = appendUvarintBytes(buf, kv.Key)
+		buf = appendUvarintBytes(buf, kv.Value)
+	}
+	return buf, nil
+}
+
+// appendUvarintBytes appends b to buf, prefixed by its length.
+func appendUvarintBytes(buf, b []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(b)))]...)
+	return append(buf, b...)
+}
+
+// decodeLogRecord decodes a log record payload into a list of batch
+// operations.
+func decodeLogRecord(buf []byte) ([]interface{}, error) {
+	var cmds []interface{}
+	for len(buf) > 0 {
+		op := buf[0]
+		var key, value []byte
+		var err error
+		if key, buf, err = decodeUvarintBytes(buf[1:]); err != nil {
+			return nil, err
+		}
+		if value, buf, err = decodeUvarintBytes(buf); err != nil {
+			return nil, err
+		}
+		kv := proto.RawKeyValue{Key: key, Value: value}
+		switch op {
+		case logOpPut:
+			cmds = append(cmds, BatchPut{kv})
+		case logOpMerge:
+			cmds = append(cmds, BatchMerge{kv})
+		case logOpDelete:
+			cmds = append(cmds, BatchDelete{kv})
+		default:
+			return nil, util.Errorf("unknown log operation %d", op)
+		}
+	}
+	return cmds, nil
+}
+
+// decodeUvarintBytes decodes a length-prefixed byte slice from buf,
+// returning it and the remainder of buf.
+func decodeUvarintBytes(buf []byte) ([]byte, []byte, error) {
+	n, size := binary.Uvarint(buf)
+	if size <= 0 || uint64(len(buf)-size) < n {
+		return nil, nil, util.Errorf("malformed length-prefixed bytes")
+	}
+	buf = buf[size:]
+	return buf[:n:n], buf[n:], nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// startLogDB creates and starts a LogDB in a new temporary directory.
+func startLogDB(t *testing.T) *LogDB {
+	db := NewLogDB(proto.Attributes{}, util.CreateTempDirectory())
+	if err := db.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// restartLogDB stops db and starts a new LogDB on the same directory.
+func restartLogDB(db *LogDB, t *testing.T) *LogDB {
+	db.Stop()
+	newDB := NewLogDB(proto.Attributes{}, db.dir)
+	newDB.CompactionThreshold = db.CompactionThreshold
+	if err := newDB.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return newDB
+}
+
+// verifyLogDBContents verifies that db contains exactly the expected
+// key/value pairs.
+func verifyLogDBContents(db *LogDB, expected map[string][]byte, t *testing.T) {
+	kvs, err := Scan(db, proto.EncodedKey(KeyMin), proto.EncodedKey(KeyMax), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != len(expected) {
+		t.Fatalf("expected %d key/value pairs; got %d: %v", len(expected), len(kvs), kvs)
+	}
+	for _, kv := range kvs {
+		if val, ok := expected[string(kv.Key)]; !ok || !bytes.Equal(val, kv.Value) {
+			t.Errorf("unexpected value for key %q: %q", kv.Key, kv.Value)
+		}
+	}
+}
+
+// TestLogDBRecovery verifies that puts, merges, deletions and batches
+// are recovered when the engine is restarted.
+func TestLogDBRecovery(t *testing.T) {
+	db := startLogDB(t)
+	defer func() { db.Stop(); db.Destroy() }()
+
+	if err := db.Put(proto.EncodedKey("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(proto.EncodedKey("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Clear(proto.EncodedKey("a")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Merge(proto.EncodedKey("c"), appender("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := db.NewBatch()
+	batch.Put(proto.EncodedKey("d"), []byte("4"))
+	batch.Clear(proto.EncodedKey("b"))
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := db.Get(proto.EncodedKey("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]byte{"c": merged, "d": []byte("4")}
+	verifyLogDBContents(db, expected, t)
+
+	db = restartLogDB(db, t)
+	verifyLogDBContents(db, expected, t)
+}
+
+// TestLogDBFailedApply verifies that a batch with a command which
+// fails to apply is neither applied nor logged: the commands which
+// preceded the failed one are undone.
+func TestLogDBFailedApply(t *testing.T) {
+	db := startLogDB(t)
+	defer func() { db.Stop(); db.Destroy() }()
+
+	if err := db.Put(proto.EncodedKey("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Merge(proto.EncodedKey("c"), appender("x")); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := db.Get(proto.EncodedKey("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]byte{"a": []byte("1"), "c": merged}
+
+	// Commands with an empty key are rejected before any are applied.
+	if err := db.WriteBatch([]interface{}{
+		BatchPut{proto.RawKeyValue{Key: proto.EncodedKey("b"), Value: []byte("2")}},
+		BatchPut{proto.RawKeyValue{Key: proto.EncodedKey(""), Value: []byte("3")}},
+	}); err == nil {
+		t.Fatal("expected batch with an empty key to fail")
+	}
+	verifyLogDBContents(db, expected, t)
+
+	// A put which exceeds the capacity fails after the commands
+	// preceding it have been applied; they're undone.
+	db.InMem.maxBytes = db.InMem.usedBytes + 1<<10
+	if err := db.WriteBatch([]interface{}{
+		BatchDelete{proto.RawKeyValue{Key: proto.EncodedKey("a")}},
+		BatchMerge{proto.RawKeyValue{Key: proto.EncodedKey("c"), Value: appender("y")}},
+		BatchPut{proto.RawKeyValue{Key: proto.EncodedKey("b"), Value: []byte("2")}},
+		BatchPut{proto.RawKeyValue{Key: proto.EncodedKey("d"), Value: make([]byte, 1<<20)}},
+	}); err == nil {
+		t.Fatal("expected batch exceeding capacity to fail")
+	}
+	verifyLogDBContents(db, expected, t)
+
+	db = restartLogDB(db, t)
+	verifyLogDBContents(db, expected, t)
+	if err := db.Put(proto.EncodedKey("b"), []byte("2")); err != nil {
+		t.Fatalf("expected writes to succeed after a failed apply: %s", err)
+	}
+}
+
+// TestLogDBTornRecord verifies that a partially written record at the
+// tail of the log is discarded on recovery and that subsequent writes
+// are recovered.
+func TestLogDBTornRecord(t *testing.T) {
+	db := startLogDB(t)
+	defer func() { db.Stop(); db.Destroy() }()
+
+	if err := db.Put(proto.EncodedKey("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteBatch([]interface{}{
+		BatchPut{proto.RawKeyValue{Key: proto.EncodedKey("b"), Value: []byte("2")}},
+		BatchPut{proto.RawKeyValue{Key: proto.EncodedKey("c"), Value: []byte("3")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Stop()
+
+	// Truncate the log in the middle of the batch record.
+	path := filepath.Join(db.dir, logDBFilename)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Start(); err != nil {
+		t.Fatal(err)
+	}
+	verifyLogDBContents(db, map[string][]byte{"a": []byte("1")}, t)
+
+	if err := db.Put(proto.EncodedKey("d"), []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	db = restartLogDB(db, t)
+	verifyLogDBContents(db, map[string][]byte{"a": []byte("1"), "d": []byte("4")}, t)
+}
+
+// TestLogDBCompaction verifies that the log is rewritten as a
+// checkpoint once it exceeds the compaction threshold and that the
+// checkpoint is recovered.
+func TestLogDBCompaction(t *testing.T) {
+	db := startLogDB(t)
+	defer func() { db.Stop(); db.Destroy() }()
+	db.CompactionThreshold = 1 << 10
+
+	expected := map[string][]byte{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i%10)
+		value := []byte(fmt.Sprintf("value%d", i))
+		if err := db.Put(proto.EncodedKey(key), value); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = value
+	}
+	if db.logSize > db.CompactionThreshold {
+		t.Errorf("expected log size %d to be at most %d", db.logSize, db.CompactionThreshold)
+	}
+	info, err := os.Stat(filepath.Join(db.dir, logDBFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != db.logSize {
+		t.Errorf("expected log file size %d; got %d", db.logSize, info.Size())
+	}
+	db = restartLogDB(db, t)
+	verifyLogDBContents(db, expected, t)
+}
+
+// TestLogDBEmptyKey verifies that operations on an empty key are
+// rejected without being logged.
+func TestLogDBEmptyKey(t *testing.T) {
+	db := startLogDB(t)
+	defer func() { db.Stop(); db.Destroy() }()
+
+	if err := db.Put(proto.EncodedKey(""), []byte("1")); err == nil {
+		t.Error("expected error putting empty key")
+	}
+	if db.logSize != 0 {
+		t.Errorf("expected empty log; got %d bytes", db.logSize)
+	}
+}
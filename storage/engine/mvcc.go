@@ -21,6 +21,7 @@ package engine
 import (
 	"bytes"
 	"fmt"
+	"math/big"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
@@ -32,85 +33,28 @@ import (
 const (
 	// The size of the reservoir used by FindSplitKey.
 	splitReservoirSize = 100
+	// The maximum number of bisection steps used by
+	// findApproximateSplitKey. Each step resolves one bit of the key
+	// space beyond the keys' common prefix.
+	splitBisectionSteps = 256
 )
 
-// MVCCStats tracks byte and instance counts for:
-//  - Live key/values (i.e. what a scan at current time will reveal;
-//    note that this includes intent keys and values, but not keys and
-//    values with most recent value deleted)
-//  - Key bytes (includes all keys, even those with most recent value deleted)
-//  - Value bytes (includes all versions)
-//  - Key count (count of all keys, including keys with deleted tombstones)
-//  - Value count (all versions, including deleted tombstones)
-//  - Intents (provisional values written during txns)
-type MVCCStats struct {
-	LiveBytes, KeyBytes, ValBytes, IntentBytes int64
-	LiveCount, KeyCount, ValCount, IntentCount int64
-}
-
-// GetRangeMVCCStats reads stat counters for the specified range
-// and returns an MVCCStats object on success.
-func GetRangeMVCCStats(engine Engine, rangeID int64) (*MVCCStats, error) {
-	ms := &MVCCStats{}
-	var err error
-	if ms.LiveBytes, err = GetRangeStat(engine, rangeID, StatLiveBytes); err != nil {
-		return nil, err
-	}
-	if ms.KeyBytes, err = GetRangeStat(engine, rangeID, StatKeyBytes); err != nil {
-		return nil, err
-	}
-	if ms.ValBytes, err = GetRangeStat(engine, rangeID, StatValBytes); err != nil {
-		return nil, err
-	}
-	if ms.IntentBytes, err = GetRangeStat(engine, rangeID, StatIntentBytes); err != nil {
-		return nil, err
-	}
-	if ms.LiveCount, err = GetRangeStat(engine, rangeID, StatLiveCount); err != nil {
-		return nil, err
-	}
-	if ms.KeyCount, err = GetRangeStat(engine, rangeID, StatKeyCount); err != nil {
-		return nil, err
-	}
-	if ms.ValCount, err = GetRangeStat(engine, rangeID, StatValCount); err != nil {
-		return nil, err
-	}
-	if ms.IntentCount, err = GetRangeStat(engine, rangeID, StatIntentCount); err != nil {
-		return nil, err
-	}
-	return ms, nil
-}
-
-// MergeStats merges accumulated stats to stat counters for both the
-// affected range and store.
-func (ms *MVCCStats) MergeStats(engine Engine, rangeID int64, storeID int32) {
-	MergeStat(engine, rangeID, storeID, StatLiveBytes, ms.LiveBytes)
-	MergeStat(engine, rangeID, storeID, StatKeyBytes, ms.KeyBytes)
-	MergeStat(engine, rangeID, storeID, StatValBytes, ms.ValBytes)
-	MergeStat(engine, rangeID, storeID, StatIntentBytes, ms.IntentBytes)
-	MergeStat(engine, rangeID, storeID, StatLiveCount, ms.LiveCount)
-	MergeStat(engine, rangeID, storeID, StatKeyCount, ms.KeyCount)
-	MergeStat(engine, rangeID, storeID, StatValCount, ms.ValCount)
-	MergeStat(engine, rangeID, storeID, StatIntentCount, ms.IntentCount)
-}
-
-// SetStats sets stat counters for both the affected range and store.
-func (ms *MVCCStats) SetStats(engine Engine, rangeID int64, storeID int32) {
-	SetStat(engine, rangeID, storeID, StatLiveBytes, ms.LiveBytes)
-	SetStat(engine, rangeID, storeID, StatKeyBytes, ms.KeyBytes)
-	SetStat(engine, rangeID, storeID, StatValBytes, ms.ValBytes)
-	SetStat(engine, rangeID, storeID, StatIntentBytes, ms.IntentBytes)
-	SetStat(engine, rangeID, storeID, StatLiveCount, ms.LiveCount)
-	SetStat(engine, rangeID, storeID, StatKeyCount, ms.KeyCount)
-	SetStat(engine, rangeID, storeID, StatValCount, ms.ValCount)
-	SetStat(engine, rangeID, storeID, StatIntentCount, ms.IntentCount)
-}
-
 // MVCC wraps the mvcc operations of a key/value store. MVCC instances
 // are instantiated with an Engine object, meant to carry out a single
 // operation and commit the results to the underlying engine atomically.
 type MVCC struct {
 	engine Engine
-	MVCCStats
+	proto.MVCCStats
+	// Compression is applied to the bytes of values put via this
+	// instance. Values are always decompressed on read.
+	Compression proto.Compression
+	// LogicalBytes and CompressedBytes accumulate the sizes of the
+	// values compressed by this instance before and after compression.
+	LogicalBytes, CompressedBytes int64
+	// SkipIntents, if set, makes reads skip the intents of other
+	// transactions, returning the latest committed value instead of a
+	// WriteIntentError.
+	SkipIntents bool
 }
 
 // NewMVCC returns a new instance of MVCC, wrapping engine.
@@ -128,7 +72,7 @@ func (mvcc *MVCC) GetProto(key proto.Key, timestamp proto.Timestamp, txn *proto.
 	if err != nil {
 		return false, err
 	}
-	if len(value.Bytes) == 0 {
+	if value == nil || len(value.Bytes) == 0 {
 		return false, nil
 	}
 	if msg != nil {
@@ -186,7 +130,8 @@ func (mvcc *MVCC) Get(key proto.Key, timestamp proto.Timestamp, txn *proto.Trans
 	// latest write and current read are within the same transaction.
 	if !timestamp.Less(meta.Timestamp) ||
 		(meta.Txn != nil && txn != nil && bytes.Equal(meta.Txn.ID, txn.ID)) {
-		if meta.Txn != nil && (txn == nil || !bytes.Equal(meta.Txn.ID, txn.ID)) {
+		otherIntent := meta.Txn != nil && (txn == nil || !bytes.Equal(meta.Txn.ID, txn.ID))
+		if otherIntent && !mvcc.SkipIntents {
 			// Trying to read the last value, but it's another transaction's
 			// intent; the reader will have to act on this.
 			return nil, &proto.WriteIntentError{Key: key, Txn: *meta.Txn}
@@ -197,8 +142,22 @@ func (mvcc *MVCC) Get(key proto.Key, timestamp proto.Timestamp, txn *proto.Trans
 		// but it's got a different epoch. This can happen if the
 		// txn was restarted and an earlier iteration wrote the value
 		// we're now reading. In this case, we skip the intent.
-		if meta.Txn != nil && txn.Epoch != meta.Txn.Epoch {
+		if otherIntent {
+			// Skip the intent and read the latest committed value.
+			valBytes, ts, isValue, err = mvcc.scanEarlierVersion(latestKey.Next(), metaKey.PrefixEnd())
+		} else if meta.Txn != nil && txn.Epoch != meta.Txn.Epoch {
 			valBytes, ts, isValue, err = mvcc.scanEarlierVersion(latestKey.Next(), metaKey.PrefixEnd())
+		} else if meta.Txn != nil && txn.IsSeqNumIgnored(meta.Txn.GetSequence()) {
+			// The write which laid down our intent has been rolled back.
+			// Read our latest earlier write which hasn't, if any, and
+			// otherwise skip the intent.
+			if i := latestIntentValue(meta, txn); i >= 0 {
+				valBytes, err = gogoproto.Marshal(&meta.IntentHistory[i].Value)
+				ts = meta.Timestamp
+				isValue = true
+			} else {
+				valBytes, ts, isValue, err = mvcc.scanEarlierVersion(latestKey.Next(), metaKey.PrefixEnd())
+			}
 		} else {
 			valBytes, err = mvcc.engine.Get(latestKey)
 			ts = meta.Timestamp
@@ -214,10 +173,7 @@ func (mvcc *MVCC) Get(key proto.Key, timestamp proto.Timestamp, txn *proto.Trans
 			// latest write could possibly have happened before our read in
 			// absolute time if the writer had a fast clock.
 			// The reader should try again at meta.Timestamp+1.
-			return nil, &proto.ReadWithinUncertaintyIntervalError{
-				Timestamp:         timestamp,
-				ExistingTimestamp: meta.Timestamp,
-			}
+			return nil, proto.NewReadWithinUncertaintyIntervalError(key, timestamp, meta.Timestamp)
 		}
 
 		// We want to know if anything has been written ahead of timestamp, but
@@ -229,10 +185,7 @@ func (mvcc *MVCC) Get(key proto.Key, timestamp proto.Timestamp, txn *proto.Trans
 			// value, but there is another previous write with the same issues
 			// as in the second case, so the reader will have to come again
 			// with a higher read timestamp.
-			return nil, &proto.ReadWithinUncertaintyIntervalError{
-				Timestamp:         timestamp,
-				ExistingTimestamp: ts,
-			}
+			return nil, proto.NewReadWithinUncertaintyIntervalError(key, timestamp, ts)
 		}
 		if valBytes == nil {
 			panic(fmt.Sprintf("%q, %v, %v", key, txn.MaxTimestamp, meta.Timestamp))
@@ -256,11 +209,15 @@ func (mvcc *MVCC) Get(key proto.Key, timestamp proto.Timestamp, txn *proto.Trans
 
 	// Unmarshal the mvcc value.
 	value := &proto.MVCCValue{}
-	if err := gogoproto.Unmarshal(valBytes, value); err != nil {
+	if err := UnmarshalMVCCValue(valBytes, value); err != nil {
 		return nil, err
 	}
 	// Set the timestamp if the value is not nil (i.e. not a deletion tombstone).
 	if value.Value != nil {
+		// Expired values are treated as absent.
+		if value.Value.Expiration != nil && !timestamp.Less(*value.Value.Expiration) {
+			return nil, nil
+		}
 		value.Value.Timestamp = &ts
 	} else if !value.Deleted {
 		// Sanity check.
@@ -290,23 +247,65 @@ func (mvcc *MVCC) scanEarlierVersion(nextKey, endKey proto.EncodedKey) ([]byte,
 // key metadata. We assume the range will check for an existing write
 // intent before executing any Put action at the MVCC level.
 func (mvcc *MVCC) Put(key proto.Key, timestamp proto.Timestamp, value proto.Value, txn *proto.Transaction) error {
+	return mvcc.putValue(key, timestamp, value, txn, putDefault)
+}
+
+// PutFresh sets the value for a key which the caller expects to have
+// no live value. Returns a KeyExistsError if the key has a committed
+// value which isn't a deletion tombstone.
+func (mvcc *MVCC) PutFresh(key proto.Key, timestamp proto.Timestamp, value proto.Value, txn *proto.Transaction) error {
+	return mvcc.putValue(key, timestamp, value, txn, putFresh)
+}
+
+// BlindPut sets the value for a key without reading the key's
+// existing metadata. The caller must know that the key has no
+// versions or intent, e.g. via FirstKey; a blind put over an existing
+// key corrupts its metadata and the MVCC stats.
+func (mvcc *MVCC) BlindPut(key proto.Key, timestamp proto.Timestamp, value proto.Value, txn *proto.Transaction) error {
+	return mvcc.putValue(key, timestamp, value, txn, putBlind)
+}
+
+// putValue verifies the value's timestamp and puts it via putInternal.
+func (mvcc *MVCC) putValue(key proto.Key, timestamp proto.Timestamp, value proto.Value, txn *proto.Transaction, mode putMode) error {
 	if value.Timestamp != nil && !value.Timestamp.Equal(timestamp) {
 		return util.Errorf(
 			"the timestamp %+v provided in value does not match the timestamp %+v in request",
 			value.Timestamp, timestamp)
 	}
-	return mvcc.putInternal(key, timestamp, proto.MVCCValue{Value: &value}, txn)
+	return mvcc.putInternal(key, timestamp, proto.MVCCValue{Value: &value}, txn, mode)
 }
 
 // Delete marks the key deleted and will not return in the next
 // get response.
 func (mvcc *MVCC) Delete(key proto.Key, timestamp proto.Timestamp, txn *proto.Transaction) error {
-	return mvcc.putInternal(key, timestamp, proto.MVCCValue{Deleted: true}, txn)
+	return mvcc.putInternal(key, timestamp, proto.MVCCValue{Deleted: true}, txn, putDefault)
 }
 
+// FirstKey returns the first key at or after key and before endKey
+// which has versions or an intent, including deletion tombstones.
+// Returns nil if there is none, in which case blind puts to keys in
+// the span are safe.
+func (mvcc *MVCC) FirstKey(key, endKey proto.Key) (proto.Key, error) {
+	var first proto.Key
+	err := mvcc.engine.Iterate(MVCCEncodeKey(key), MVCCEncodeKey(endKey), func(kv proto.RawKeyValue) (bool, error) {
+		first, _, _ = MVCCDecodeKey(kv.Key)
+		return true, nil
+	})
+	return first, err
+}
+
+// putMode specifies how putInternal treats a key's existing metadata.
+type putMode int
+
+const (
+	putDefault putMode = iota // Replace existing metadata
+	putFresh                  // Fail on an existing live value
+	putBlind                  // Don't read existing metadata
+)
+
 // putInternal adds a new timestamped value to the specified key.
 // If value is nil, creates a deletion tombstone value.
-func (mvcc *MVCC) putInternal(key proto.Key, timestamp proto.Timestamp, value proto.MVCCValue, txn *proto.Transaction) error {
+func (mvcc *MVCC) putInternal(key proto.Key, timestamp proto.Timestamp, value proto.MVCCValue, txn *proto.Transaction, mode putMode) error {
 	if len(key) == 0 {
 		return emptyKeyError()
 	}
@@ -316,9 +315,13 @@ func (mvcc *MVCC) putInternal(key proto.Key, timestamp proto.Timestamp, value pr
 	}
 
 	meta := &proto.MVCCMetadata{}
-	ok, origMetaKeySize, origMetaValSize, err := GetProto(mvcc.engine, metaKey, meta)
-	if err != nil {
-		return err
+	var ok bool
+	var origMetaKeySize, origMetaValSize int64
+	if mode != putBlind {
+		var err error
+		if ok, origMetaKeySize, origMetaValSize, err = GetProto(mvcc.engine, metaKey, meta); err != nil {
+			return err
+		}
 	}
 
 	var newMeta *proto.MVCCMetadata
@@ -331,21 +334,40 @@ func (mvcc *MVCC) putInternal(key proto.Key, timestamp proto.Timestamp, value pr
 		if meta.Txn != nil && (txn == nil || !bytes.Equal(meta.Txn.ID, txn.ID)) {
 			return &proto.WriteIntentError{Key: key, Txn: *meta.Txn}
 		}
+		// Replays of writes already applied by the transaction are
+		// ignored.
+		if replaysIntent(meta, txn) {
+			return nil
+		}
+		if mode == putFresh && meta.Txn == nil && !meta.Deleted {
+			return proto.NewKeyExistsError(key, meta.Timestamp)
+		}
 
 		// We can update the current metadata only if both the timestamp
 		// and epoch of the new intent are greater than or equal to
 		// existing. If either of these conditions doesn't hold, it's
 		// likely the case that an older RPC is arriving out of order.
 		if !timestamp.Less(meta.Timestamp) && (meta.Txn == nil || txn.Epoch >= meta.Txn.Epoch) {
+			newMeta = &proto.MVCCMetadata{Txn: txn, Timestamp: timestamp}
+			// Keep the value of an intent replaced by a later sequenced
+			// write of the same epoch, in case that write is rolled back.
+			if meta.Txn != nil && meta.Txn.Epoch == txn.Epoch &&
+				meta.Txn.GetSequence() > 0 && txn.GetSequence() > 0 {
+				prev := proto.MVCCValue{}
+				if _, _, _, err := GetProto(mvcc.engine, MVCCEncodeVersionKey(key, meta.Timestamp), &prev); err != nil {
+					return err
+				}
+				newMeta.IntentHistory = append(meta.IntentHistory,
+					proto.MVCCIntentValue{Sequence: meta.Txn.GetSequence(), Value: prev})
+			}
 			// If this is an intent and timestamps have changed, need to remove old version.
 			if meta.Txn != nil && !timestamp.Equal(meta.Timestamp) {
 				mvcc.engine.Clear(MVCCEncodeVersionKey(key, meta.Timestamp))
 			}
-			newMeta = &proto.MVCCMetadata{Txn: txn, Timestamp: timestamp}
 		} else if timestamp.Less(meta.Timestamp) && meta.Txn == nil {
 			// If we receive a Put request to write before an already-
 			// committed version, send write tool old error.
-			return &proto.WriteTooOldError{Timestamp: timestamp, ExistingTimestamp: meta.Timestamp}
+			return proto.NewWriteTooOldError(key, timestamp, meta.Timestamp)
 		} else {
 			// Otherwise, it's an old write to the current transaction. Just ignore.
 			return nil
@@ -364,6 +386,15 @@ func (mvcc *MVCC) putInternal(key proto.Key, timestamp proto.Timestamp, value pr
 	// into the key, so don't need it in both places).
 	if value.Value != nil {
 		value.Value.Timestamp = nil
+		logicalBytes := int64(len(value.Value.Bytes))
+		compressed, err := compressValue(&value, mvcc.Compression)
+		if err != nil {
+			return err
+		}
+		if compressed {
+			mvcc.LogicalBytes += logicalBytes
+			mvcc.CompressedBytes += int64(len(value.Value.Bytes))
+		}
 	}
 	valueKeySize, valueSize, err := PutProto(mvcc.engine, MVCCEncodeVersionKey(key, timestamp), &value)
 	if err != nil {
@@ -385,6 +416,70 @@ func (mvcc *MVCC) putInternal(key proto.Key, timestamp proto.Timestamp, value pr
 	return nil
 }
 
+// replaysIntent returns whether a write by txn replays a write which
+// the same transaction has already applied, given the metadata of the
+// key. That's the case if the key holds an intent of txn's epoch laid
+// down by a write with the same or a later sequence number. Writes
+// without a sequence number are never considered replays.
+func replaysIntent(meta *proto.MVCCMetadata, txn *proto.Transaction) bool {
+	return meta.Txn != nil && txn.GetSequence() > 0 &&
+		bytes.Equal(meta.Txn.ID, txn.ID) && meta.Txn.Epoch == txn.Epoch &&
+		txn.GetSequence() <= meta.Txn.GetSequence()
+}
+
+// latestIntentValue returns the index of the latest value in the
+// intent history of meta which was written by a write txn hasn't
+// rolled back, or -1 if there's none.
+func latestIntentValue(meta *proto.MVCCMetadata, txn *proto.Transaction) int {
+	for i := len(meta.IntentHistory) - 1; i >= 0; i-- {
+		if !txn.IsSeqNumIgnored(meta.IntentHistory[i].Sequence) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rewindIntent replaces the value of the intent described by meta
+// with the value at index i of its intent history, which becomes the
+// intent's latest write. Later values are discarded.
+func (mvcc *MVCC) rewindIntent(key proto.Key, meta *proto.MVCCMetadata, origMetaKeySize, origMetaValSize int64, i int) error {
+	entry := meta.IntentHistory[i]
+	valueKeySize, valueSize, err := PutProto(mvcc.engine, MVCCEncodeVersionKey(key, meta.Timestamp), &entry.Value)
+	if err != nil {
+		return err
+	}
+	txn := *meta.Txn
+	txn.Sequence = gogoproto.Int32(entry.Sequence)
+	newMeta := &proto.MVCCMetadata{
+		Txn:           &txn,
+		Timestamp:     meta.Timestamp,
+		Deleted:       entry.Value.Deleted,
+		KeyBytes:      valueKeySize,
+		ValBytes:      valueSize,
+		IntentHistory: meta.IntentHistory[:i],
+	}
+	metaKeySize, metaValSize, err := PutProto(mvcc.engine, MVCCEncodeKey(key), newMeta)
+	if err != nil {
+		return err
+	}
+	mvcc.updateStatsOnPut(key, origMetaKeySize, origMetaValSize, metaKeySize, metaValSize, meta, newMeta)
+	return nil
+}
+
+// isReplay returns whether a write of key by txn replays a write the
+// transaction has already applied to the key; see replaysIntent.
+func (mvcc *MVCC) isReplay(key proto.Key, txn *proto.Transaction) (bool, error) {
+	if txn.GetSequence() == 0 {
+		return false, nil
+	}
+	meta := &proto.MVCCMetadata{}
+	ok, _, _, err := GetProto(mvcc.engine, MVCCEncodeKey(key), meta)
+	if !ok || err != nil {
+		return false, err
+	}
+	return replaysIntent(meta, txn), nil
+}
+
 // Increment fetches the value for key, and assuming the value is
 // an "integer" type, increments it by inc and stores the new
 // value. The newly incremented value is returned.
@@ -406,6 +501,11 @@ func (mvcc *MVCC) Increment(key proto.Key, timestamp proto.Timestamp, txn *proto
 		}
 		int64Val = value.GetInteger()
 	}
+	// A replayed increment returns the incremented value without
+	// incrementing it again.
+	if replay, err := mvcc.isReplay(key, txn); replay || err != nil {
+		return int64Val, err
+	}
 
 	// Check for overflow and underflow.
 	if encoding.WillOverflow(int64Val, inc) {
@@ -435,21 +535,98 @@ func (mvcc *MVCC) ConditionalPut(key proto.Key, timestamp proto.Timestamp, value
 	if err != nil {
 		return nil, err
 	}
+	// A replay must not be evaluated against the value it wrote.
+	if replay, err := mvcc.isReplay(key, txn); replay || err != nil {
+		return nil, err
+	}
 
+	if err := checkExpectedValue(key, existVal, expValue); err != nil {
+		return existVal, err
+	}
+
+	return nil, mvcc.Put(key, timestamp, value, txn)
+}
+
+// checkExpectedValue returns an error unless the existing value of
+// the key matches the expected value. A nil expected value requires
+// that the key not exist.
+func checkExpectedValue(key proto.Key, existVal, expValue *proto.Value) error {
 	if expValue == nil && existVal != nil {
-		return existVal, util.Errorf("key %q already exists", key)
+		return util.Errorf("key %q already exists", key)
 	} else if expValue != nil {
 		// Handle check for existence when there is no key.
 		if existVal == nil {
-			return nil, util.Errorf("key %q does not exist", key)
+			return util.Errorf("key %q does not exist", key)
 		} else if expValue.Bytes != nil && !bytes.Equal(expValue.Bytes, existVal.Bytes) {
+			return util.Errorf("key %q does not match existing", key)
+		} else if expValue.Integer != nil && (existVal.Integer == nil || expValue.GetInteger() != existVal.GetInteger()) {
+			return util.Errorf("key %q does not match existing", key)
+		}
+	}
+	return nil
+}
+
+// CompareAndSwap checks that the value of each condition's key
+// matches its expected value and, only if all do, applies the
+// writes. A write with a nil value deletes its key. The writes are
+// only atomic if the caller commits the underlying engine batch
+// solely on success. On a mismatch, the key of the failed condition
+// and its actual value are returned along with the error.
+func (mvcc *MVCC) CompareAndSwap(conditions []proto.CompareAndSwapCondition, writes []proto.CompareAndSwapWrite,
+	timestamp proto.Timestamp, txn *proto.Transaction) (proto.Key, *proto.Value, error) {
+	for _, c := range conditions {
+		// As with ConditionalPut, read at the max timestamp in order to
+		// detect a potential write intent by another concurrent
+		// transaction with a newer timestamp.
+		existVal, err := mvcc.Get(c.Key, proto.MaxTimestamp, txn)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkExpectedValue(c.Key, existVal, c.ExpValue); err != nil {
+			return c.Key, existVal, err
+		}
+	}
+	for _, w := range writes {
+		var err error
+		if w.Value == nil {
+			err = mvcc.Delete(w.Key, timestamp, txn)
+		} else {
+			err = mvcc.Put(w.Key, timestamp, *w.Value, txn)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, nil
+}
+
+// ConditionalDelete deletes the value for a specified key only if
+// the expected value matches, or, if expValue is nil, only if the
+// key exists. If not, the return value contains the actual value.
+func (mvcc *MVCC) ConditionalDelete(key proto.Key, timestamp proto.Timestamp,
+	expValue *proto.Value, txn *proto.Transaction) (*proto.Value, error) {
+	// As with ConditionalPut, read at the max timestamp in order to
+	// detect a potential write intent by another concurrent
+	// transaction with a newer timestamp.
+	existVal, err := mvcc.Get(key, proto.MaxTimestamp, txn)
+	if err != nil {
+		return nil, err
+	}
+	if replay, err := mvcc.isReplay(key, txn); replay || err != nil {
+		return nil, err
+	}
+
+	if existVal == nil {
+		return nil, util.Errorf("key %q does not exist", key)
+	} else if expValue != nil {
+		if expValue.Bytes != nil && !bytes.Equal(expValue.Bytes, existVal.Bytes) {
 			return existVal, util.Errorf("key %q does not match existing", key)
 		} else if expValue.Integer != nil && (existVal.Integer == nil || expValue.GetInteger() != existVal.GetInteger()) {
 			return existVal, util.Errorf("key %q does not match existing", key)
 		}
 	}
 
-	return nil, mvcc.Put(key, timestamp, value, txn)
+	return nil, mvcc.Delete(key, timestamp, txn)
 }
 
 // DeleteRange deletes the range of key/value pairs specified by
@@ -585,7 +762,7 @@ func (mvcc *MVCC) IterateCommitted(key, endKey proto.Key, f func(proto.KeyValue)
 					return false, util.Errorf("expected an MVCC value at key %q", rawKV.Key)
 				}
 				value := &proto.MVCCValue{}
-				if err := gogoproto.Unmarshal(rawKV.Value, value); err != nil {
+				if err := UnmarshalMVCCValue(rawKV.Value, value); err != nil {
 					return false, err
 				}
 				if value.Deleted {
@@ -599,6 +776,41 @@ func (mvcc *MVCC) IterateCommitted(key, endKey proto.Key, f func(proto.KeyValue)
 	})
 }
 
+// WrittenBetween returns true if a version was written to the key
+// range specified by start and end keys at a timestamp within (from,
+// to] by a transaction other than txn. Such a write would be visible
+// to a read at timestamp to, but not to one at timestamp from. If
+// endKey is empty, only key is checked. Intents of other transactions
+// within the interval count as writes, as they may yet commit; the
+// intent of txn itself is ignored.
+func (mvcc *MVCC) WrittenBetween(key, endKey proto.Key, from, to proto.Timestamp, txn *proto.Transaction) (bool, error) {
+	if len(endKey) == 0 {
+		endKey = key.Next()
+	}
+	var written bool
+	var ownIntentTS *proto.Timestamp // timestamp of txn's intent on the current key
+	err := mvcc.engine.Iterate(MVCCEncodeKey(key), MVCCEncodeKey(endKey), func(kv proto.RawKeyValue) (bool, error) {
+		_, ts, isValue := MVCCDecodeKey(kv.Key)
+		if !isValue {
+			meta := &proto.MVCCMetadata{}
+			if err := gogoproto.Unmarshal(kv.Value, meta); err != nil {
+				return false, util.Errorf("unable to unmarshal MVCC metadata %q: %s", kv.Value, err)
+			}
+			ownIntentTS = nil
+			if meta.Txn != nil && txn != nil && bytes.Equal(meta.Txn.ID, txn.ID) {
+				ownIntentTS = &meta.Timestamp
+			}
+			return false, nil
+		}
+		if ownIntentTS != nil && ts.Equal(*ownIntentTS) {
+			return false, nil
+		}
+		written = from.Less(ts) && !to.Less(ts)
+		return written, nil
+	})
+	return written, err
+}
+
 // ResolveWriteIntent either commits or aborts (rolls back) an
 // extant write intent for a given txn according to commit parameter.
 // ResolveWriteIntent will skip write intents of other txns.
@@ -638,6 +850,20 @@ func (mvcc *MVCC) ResolveWriteIntent(key proto.Key, txn *proto.Transaction) erro
 	if !ok || meta.Txn == nil || !bytes.Equal(meta.Txn.ID, txn.ID) {
 		return nil
 	}
+	// An intent laid down by a write which the transaction has rolled
+	// back is first rewound to the transaction's latest write of the
+	// key which it hasn't. If there's none, the intent is removed.
+	rolledBack := meta.Txn.Epoch == txn.Epoch && txn.IsSeqNumIgnored(meta.Txn.GetSequence())
+	if i := latestIntentValue(meta, txn); rolledBack && i >= 0 {
+		if err := mvcc.rewindIntent(key, meta, origMetaKeySize, origMetaValSize, i); err != nil {
+			return err
+		}
+		meta = &proto.MVCCMetadata{}
+		if _, origMetaKeySize, origMetaValSize, err = GetProto(mvcc.engine, metaKey, meta); err != nil {
+			return err
+		}
+		rolledBack = false
+	}
 	// If we're committing, or if the commit timestamp of the intent has
 	// been moved forward, and if the proposed epoch matches the existing
 	// epoch: update the meta.Txn. For commit, it's set to nil;
@@ -646,14 +872,19 @@ func (mvcc *MVCC) ResolveWriteIntent(key proto.Key, txn *proto.Transaction) erro
 	// timestamp-encoded key) if timestamp changed.
 	commit := txn.Status == proto.COMMITTED
 	pushed := txn.Status == proto.PENDING && meta.Txn.Timestamp.Less(txn.Timestamp)
-	if (commit || pushed) && meta.Txn.Epoch == txn.Epoch {
+	if (commit || pushed) && meta.Txn.Epoch == txn.Epoch && !rolledBack {
 		origTimestamp := meta.Timestamp
 		newMeta := *meta
 		newMeta.Timestamp = txn.Timestamp
 		if pushed { // keep intent if we're pushing timestamp
-			newMeta.Txn = txn
+			// The intent retains the sequence of the write which laid
+			// it down.
+			pushedTxn := *txn
+			pushedTxn.Sequence = meta.Txn.Sequence
+			newMeta.Txn = &pushedTxn
 		} else {
 			newMeta.Txn = nil
+			newMeta.IntentHistory = nil
 		}
 		metaKeySize, metaValSize, err := PutProto(mvcc.engine, metaKey, &newMeta)
 		if err != nil {
@@ -682,7 +913,7 @@ func (mvcc *MVCC) ResolveWriteIntent(key proto.Key, txn *proto.Transaction) erro
 
 	// This method shouldn't be called with this instance, but there's
 	// nothing to do if the epochs match and the state is still PENDING.
-	if txn.Status == proto.PENDING && meta.Txn.Epoch == txn.Epoch {
+	if txn.Status == proto.PENDING && meta.Txn.Epoch == txn.Epoch && !rolledBack {
 		return nil
 	}
 
@@ -784,10 +1015,11 @@ func (mvcc *MVCC) ResolveWriteIntentRange(key, endKey proto.Key, max int64, txn
 	return num, nil
 }
 
-// MergeStats merges stats to stat counters for both the affected
-// range and store.
-func (mvcc *MVCC) MergeStats(rangeID int64, storeID int32) {
-	mvcc.MVCCStats.MergeStats(mvcc.engine, rangeID, storeID)
+// MergeStats adds the accumulated stats to the MVCC stats of the
+// affected range and to the stat counters of the store. See
+// MergeMVCCStats.
+func (mvcc *MVCC) MergeStats(rangeID int64, storeID int32) error {
+	return MergeMVCCStats(mvcc.engine, &mvcc.MVCCStats, rangeID, storeID)
 }
 
 // a splitSampleItem wraps a key along with an aggregate over key range
@@ -799,9 +1031,13 @@ type splitSampleItem struct {
 
 // MVCCFindSplitKey suggests a split key from the given user-space key
 // range that aims to roughly cut into half the total number of bytes
-// used (in raw key and value byte strings) in both subranges. It will
-// operate on a snapshot of the underlying engine if a snapshotID is
-// given, and in that case may safely be invoked in a goroutine.
+// used (in raw key and value byte strings) in both subranges. The
+// split key is first sought using the engine's size estimates, which
+// avoids a scan of the range; if estimates are unavailable, the range
+// is scanned. It will operate on a snapshot of the underlying engine
+// if a snapshotID is given, and in that case may safely be invoked in
+// a goroutine. Note that size estimates are never taken from the
+// snapshot.
 // TODO(Tobias): leverage the work done here anyways to gather stats.
 func MVCCFindSplitKey(engine Engine, key, endKey proto.Key, snapshotID string) (proto.Key, error) {
 	if key.Less(KeyLocalMax) {
@@ -810,6 +1046,10 @@ func MVCCFindSplitKey(engine Engine, key, endKey proto.Key, snapshotID string) (
 	encStartKey := MVCCEncodeKey(key)
 	encEndKey := MVCCEncodeKey(endKey)
 
+	if splitKey, ok := findApproximateSplitKey(engine, encStartKey, encEndKey, snapshotID); ok && key.Less(splitKey) {
+		return splitKey, nil
+	}
+
 	rs := util.NewWeightedReservoirSample(splitReservoirSize, nil)
 	h := rs.Heap.(*util.WeightedValueHeap)
 
@@ -852,20 +1092,142 @@ func MVCCFindSplitKey(engine Engine, key, endKey proto.Key, snapshotID string) (
 	return humanKey, nil
 }
 
+// findApproximateSplitKey bisects the encoded key span [start, end)
+// using the engine's approximate sizes to find the key at which
+// roughly half of the span's bytes precede it. The lower bisection
+// bound is snapped to an existing key after each step so that the
+// search skips empty stretches of the key space. Returns false if
+// the engine provides no estimates for the span or if the span
+// contains fewer than two keys.
+func findApproximateSplitKey(engine Engine, start, end proto.EncodedKey, snapshotID string) (proto.Key, bool) {
+	if count, err := engine.ApproximateKeyCount(start, end); err != nil || count < 2 {
+		return nil, false
+	}
+	total, err := engine.ApproximateSize(start, end)
+	if err != nil || total == 0 {
+		return nil, false
+	}
+	// Invariant: fewer than half of the span's bytes precede lo, and
+	// at least half precede hi.
+	lo, err := firstKeyInSpan(engine, start, end, snapshotID)
+	if err != nil || lo == nil {
+		return nil, false
+	}
+	hi := end
+	for i := 0; i < splitBisectionSteps; i++ {
+		mid := keyMidpoint(lo, hi)
+		if bytes.Compare(lo, mid) >= 0 {
+			break
+		}
+		size, err := engine.ApproximateSize(start, mid)
+		if err != nil {
+			return nil, false
+		}
+		if size*2 >= total {
+			hi = mid
+			continue
+		}
+		next, err := firstKeyInSpan(engine, mid, hi, snapshotID)
+		if err != nil {
+			return nil, false
+		}
+		if next == nil {
+			// No keys between the midpoint and the upper bound.
+			break
+		}
+		lo = next
+	}
+	// Split in front of the first key following the lower bound.
+	splitKey, err := firstKeyInSpan(engine, lo.Next(), end, snapshotID)
+	if err != nil || splitKey == nil {
+		return nil, false
+	}
+	humanKey, _, _ := MVCCDecodeKey(splitKey)
+	return humanKey, true
+}
+
+// firstKeyInSpan returns the first key in [start, end) as of the
+// specified snapshot, or nil if the span is empty.
+func firstKeyInSpan(engine Engine, start, end proto.EncodedKey, snapshotID string) (proto.EncodedKey, error) {
+	var key proto.EncodedKey
+	err := engine.IterateSnapshot(start, end, snapshotID, func(kv proto.RawKeyValue) (bool, error) {
+		key = kv.Key
+		return true, nil
+	})
+	return key, err
+}
+
+// keyMidpoint returns a key sorting between a and b, which must
+// satisfy a < b. The keys are interpreted as big-endian fractions,
+// padded with a trailing zero byte to leave room for a midpoint.
+// If no key sorts strictly between a and b (e.g. b is a followed
+// only by zero bytes), a is returned.
+func keyMidpoint(a, b proto.EncodedKey) proto.EncodedKey {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	n++
+	pad := func(k []byte) *big.Int {
+		padded := make([]byte, n)
+		copy(padded, k)
+		return new(big.Int).SetBytes(padded)
+	}
+	sum := new(big.Int).Add(pad(a), pad(b))
+	mid := sum.Rsh(sum, 1).Bytes()
+	// Restore any leading zero bytes dropped by big.Int.
+	result := make([]byte, n)
+	copy(result[n-len(mid):], mid)
+	// Trim trailing zero bytes beyond a's length; they don't change
+	// the key's position relative to b but only lengthen it.
+	for len(result) > len(a) && result[len(result)-1] == 0 {
+		result = result[:len(result)-1]
+	}
+	if bytes.Compare(result, a) <= 0 || bytes.Compare(result, b) >= 0 {
+		return a
+	}
+	return result
+}
+
+// MVCCIterateIntents scans the underlying engine from start to end
+// keys, invoking f with the key and MVCC metadata of each write
+// intent. As with MVCCComputeStats, local keys are skipped. If f
+// returns true (done) or an error, the iteration stops and the error
+// is propagated.
+func MVCCIterateIntents(engine Engine, key, endKey proto.Key, f func(proto.Key, *proto.MVCCMetadata) (bool, error)) error {
+	if key.Less(KeyLocalMax) {
+		key = KeyLocalMax
+	}
+	return engine.Iterate(MVCCEncodeKey(key), MVCCEncodeKey(endKey), func(kv proto.RawKeyValue) (bool, error) {
+		dKey, _, isValue := MVCCDecodeKey(kv.Key)
+		if isValue {
+			return false, nil
+		}
+		meta := &proto.MVCCMetadata{}
+		if err := gogoproto.Unmarshal(kv.Value, meta); err != nil {
+			return false, util.Errorf("unable to unmarshal MVCC metadata %q: %s", kv.Value, err)
+		}
+		if meta.Txn == nil {
+			return false, nil
+		}
+		return f(dKey, meta)
+	})
+}
+
 // MVCCComputeStats scans the underlying engine from start to end keys
 // and computes stats counters based on the values. This method is
 // used after a range is split to recompute stats for each
 // subrange. The start key is always adjusted to avoid counting local
 // keys in the event stats are being recomputed for the first range
 // (i.e. the one with start key == KeyMin).
-func MVCCComputeStats(engine Engine, key, endKey proto.Key) (MVCCStats, error) {
+func MVCCComputeStats(engine Engine, key, endKey proto.Key) (proto.MVCCStats, error) {
 	if key.Less(KeyLocalMax) {
 		key = KeyLocalMax
 	}
 	encStartKey := MVCCEncodeKey(key)
 	encEndKey := MVCCEncodeKey(endKey)
 
-	ms := MVCCStats{}
+	ms := proto.MVCCStats{}
 	first := false
 	meta := &proto.MVCCMetadata{}
 	err := engine.Iterate(encStartKey, encEndKey, func(kv proto.RawKeyValue) (bool, error) {
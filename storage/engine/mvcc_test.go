@@ -412,6 +412,46 @@ func TestMVCCGetAndDelete(t *testing.T) {
 	}
 }
 
+// TestMVCCGetExpired verifies that values are treated as absent by
+// gets and scans at or after their expiration, but remain visible to
+// earlier reads.
+func TestMVCCGetExpired(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	expiring := value1
+	expiring.Expiration = &proto.Timestamp{WallTime: 3}
+	if err := mvcc.Put(testKey1, makeTS(1, 0), expiring, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey2, makeTS(1, 0), value2, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		ts     proto.Timestamp
+		expKVs int
+	}{
+		{makeTS(2, 0), 2},
+		{makeTS(2, math.MaxInt32), 2},
+		{makeTS(3, 0), 1},
+		{makeTS(4, 0), 1},
+	} {
+		value, err := mvcc.Get(testKey1, test.ts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expired := test.expKVs == 1; expired != (value == nil) {
+			t.Errorf("at %s: expected expired=%t; got value %+v", test.ts, expired, value)
+		}
+		kvs, err := mvcc.Scan(testKey1, KeyMax, 0, test.ts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kvs) != test.expKVs {
+			t.Errorf("at %s: expected %d scanned values; got %d", test.ts, test.expKVs, len(kvs))
+		}
+	}
+}
+
 func TestMVCCDeleteMissingKey(t *testing.T) {
 	engine := NewInMem(proto.Attributes{}, 1<<20)
 	mvcc := NewMVCC(engine)
@@ -475,6 +515,38 @@ func TestMVCCGetWriteIntentError(t *testing.T) {
 	}
 }
 
+// TestMVCCGetSkipIntents verifies that reads skipping intents return
+// the latest committed value, while the intent's own transaction still
+// reads its intent.
+func TestMVCCGetSkipIntents(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	if err := mvcc.Put(testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey1, makeTS(2, 0), value2, txn1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey2, makeTS(2, 0), value2, txn1); err != nil {
+		t.Fatal(err)
+	}
+	mvcc.SkipIntents = true
+
+	value, err := mvcc.Get(testKey1, makeTS(3, 0), nil)
+	if err != nil || !bytes.Equal(value.Bytes, value1.Bytes) || !value.Timestamp.Equal(makeTS(1, 0)) {
+		t.Errorf("expected committed value %q; got %+v, %v", value1.Bytes, value, err)
+	}
+	if value, err = mvcc.Get(testKey2, makeTS(3, 0), txn2); value != nil || err != nil {
+		t.Errorf("expected no value; got %+v, %v", value, err)
+	}
+	if value, err = mvcc.Get(testKey1, makeTS(3, 0), txn1); err != nil || !bytes.Equal(value.Bytes, value2.Bytes) {
+		t.Errorf("expected intent value %q; got %+v, %v", value2.Bytes, value, err)
+	}
+	kvs, err := mvcc.Scan(testKey1, testKey3, 0, makeTS(3, 0), nil)
+	if err != nil || len(kvs) != 1 || !bytes.Equal(kvs[0].Key, testKey1) {
+		t.Errorf("expected only the committed value of %q; got %+v, %v", testKey1, kvs, err)
+	}
+}
+
 func TestMVCCScan(t *testing.T) {
 	mvcc, _ := createTestMVCC()
 	err := mvcc.Put(testKey1, makeTS(1, 0), value1, nil)
@@ -654,6 +726,89 @@ func TestMVCCIterateCommitted(t *testing.T) {
 	}
 }
 
+// TestMVCCIterateIntents verifies that only write intents are
+// visited, along with the transactions which wrote them.
+func TestMVCCIterateIntents(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	if err := mvcc.Put(testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey2, makeTS(2, 0), value2, txn1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey3, makeTS(3, 0), value3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey4, makeTS(4, 0), value4, txn2); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []proto.Key
+	var txnIDs []string
+	if err := MVCCIterateIntents(mvcc.engine, KeyMin, KeyMax, func(key proto.Key, meta *proto.MVCCMetadata) (bool, error) {
+		keys = append(keys, key)
+		txnIDs = append(txnIDs, string(meta.Txn.ID))
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if expKeys := []proto.Key{testKey2, testKey4}; !reflect.DeepEqual(keys, expKeys) {
+		t.Errorf("expected intents at keys %v; got %v", expKeys, keys)
+	}
+	if expIDs := []string{"Txn1", "Txn2"}; !reflect.DeepEqual(txnIDs, expIDs) {
+		t.Errorf("expected intents of txns %v; got %v", expIDs, txnIDs)
+	}
+}
+
+// TestMVCCWrittenBetween verifies that writes by other transactions
+// within the timestamp interval are detected, while those outside it
+// and the checking transaction's own intents are not.
+func TestMVCCWrittenBetween(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	if err := mvcc.Put(testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey2, makeTS(3, 0), value2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey3, makeTS(3, 0), value3, txn1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey4, makeTS(3, 0), value4, txn2); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		key, endKey proto.Key
+		from, to    proto.Timestamp
+		expWritten  bool
+	}{
+		// Write before the interval.
+		{testKey1, nil, makeTS(1, 0), makeTS(5, 0), false},
+		// Committed write within the interval.
+		{testKey2, nil, makeTS(2, 0), makeTS(3, 0), true},
+		// Committed write after the interval.
+		{testKey2, nil, makeTS(1, 0), makeTS(2, 0), false},
+		// Own intent within the interval.
+		{testKey3, nil, makeTS(2, 0), makeTS(5, 0), false},
+		// Another transaction's intent within the interval.
+		{testKey4, nil, makeTS(2, 0), makeTS(5, 0), true},
+		// Ranges.
+		{testKey1, testKey2, makeTS(2, 0), makeTS(5, 0), false},
+		{testKey1, testKey3, makeTS(2, 0), makeTS(5, 0), true},
+		{testKey3, KeyMax, makeTS(2, 0), makeTS(5, 0), true},
+	}
+	for i, test := range testCases {
+		written, err := mvcc.WrittenBetween(test.key, test.endKey, test.from, test.to, txn1)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if written != test.expWritten {
+			t.Errorf("%d: expected written %t; got %t", i, test.expWritten, written)
+		}
+	}
+}
+
 func TestMVCCDeleteRange(t *testing.T) {
 	mvcc, _ := createTestMVCC()
 	err := mvcc.Put(testKey1, makeTS(1, 0), value1, nil)
@@ -798,6 +953,169 @@ func TestMVCCConditionalPut(t *testing.T) {
 	}
 }
 
+func TestMVCCConditionalDelete(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	actualVal, err := mvcc.ConditionalDelete(testKey1, makeTS(0, 0), nil, nil)
+	if err == nil {
+		t.Fatal("expected error on key not exists")
+	}
+	if actualVal != nil {
+		t.Fatalf("expected missing actual value: %v", actualVal)
+	}
+
+	if err := mvcc.Put(testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Conditional delete expecting wrong value2, will fail.
+	actualVal, err = mvcc.ConditionalDelete(testKey1, makeTS(2, 0), &value2, nil)
+	if err == nil {
+		t.Fatal("expected error on key does not match")
+	}
+	if !bytes.Equal(actualVal.Bytes, value1.Bytes) {
+		t.Fatalf("the value %s in get result does not match the value %s in request",
+			actualVal.Bytes, value1.Bytes)
+	}
+	// Conditional delete expecting value1 succeeds.
+	if _, err = mvcc.ConditionalDelete(testKey1, makeTS(2, 0), &value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	value, err := mvcc.Get(testKey1, makeTS(3, 0), nil)
+	if err != nil || value != nil {
+		t.Fatalf("expected key to be deleted; got %v, %v", value, err)
+	}
+	// The deleted key no longer exists, so deleting it again fails.
+	if _, err = mvcc.ConditionalDelete(testKey1, makeTS(3, 0), nil, nil); err == nil {
+		t.Fatal("expected error on key not exists")
+	}
+	// The value is still visible at the earlier timestamp.
+	if value, err = mvcc.Get(testKey1, makeTS(1, 0), nil); err != nil || !bytes.Equal(value.Bytes, value1.Bytes) {
+		t.Fatalf("expected value1 at earlier timestamp; got %v, %v", value, err)
+	}
+}
+
+func TestMVCCConditionalDeleteInTxn(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	if err := mvcc.Put(testKey1, makeTS(0, 0), value1, txn1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another transaction encounters txn1's intent.
+	if _, err := mvcc.ConditionalDelete(testKey1, makeTS(1, 0), nil, txn2); err == nil {
+		t.Fatal("expected error on uncommitted write intent")
+	}
+
+	// txn1 sees its own intent and deletes it.
+	if _, err := mvcc.ConditionalDelete(testKey1, makeTS(0, 0), &value1, txn1); err != nil {
+		t.Fatal(err)
+	}
+	value, err := mvcc.Get(testKey1, makeTS(0, 0), txn1)
+	if err != nil || value != nil {
+		t.Fatalf("expected key to be deleted within txn; got %v, %v", value, err)
+	}
+
+	if err := mvcc.ResolveWriteIntent(testKey1, txn1Commit); err != nil {
+		t.Fatal(err)
+	}
+	if value, err = mvcc.Get(testKey1, makeTS(1, 0), nil); err != nil || value != nil {
+		t.Fatalf("expected key to be deleted after commit; got %v, %v", value, err)
+	}
+}
+
+// TestMVCCBlindPut verifies that blind puts write the same values,
+// metadata and stats as regular puts to fresh keys.
+func TestMVCCBlindPut(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	blindMVCC, _ := createTestMVCC()
+	for _, txn := range []*proto.Transaction{nil, txn1} {
+		key := testKey1
+		if txn != nil {
+			key = testKey2
+		}
+		if err := mvcc.Put(key, makeTS(1, 0), value1, txn); err != nil {
+			t.Fatal(err)
+		}
+		if err := blindMVCC.BlindPut(key, makeTS(1, 0), value1, txn); err != nil {
+			t.Fatal(err)
+		}
+		value, err := blindMVCC.Get(key, makeTS(2, 0), txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value == nil || !bytes.Equal(value.Bytes, value1.Bytes) {
+			t.Errorf("expected %q; got %+v", value1.Bytes, value)
+		}
+	}
+	if !reflect.DeepEqual(mvcc.MVCCStats, blindMVCC.MVCCStats) {
+		t.Errorf("expected stats %+v; got %+v", mvcc.MVCCStats, blindMVCC.MVCCStats)
+	}
+}
+
+// TestMVCCPutFresh verifies that fresh puts fail on existing live
+// values, but not on deletion tombstones or the putting txn's intent.
+func TestMVCCPutFresh(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	if err := mvcc.PutFresh(testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	err := mvcc.PutFresh(testKey1, makeTS(2, 0), value2, nil)
+	if keErr, ok := err.(*proto.KeyExistsError); !ok {
+		t.Fatalf("expected KeyExistsError; got %v", err)
+	} else if !keErr.Key.Equal(testKey1) || !keErr.ExistingTimestamp.Equal(makeTS(1, 0)) {
+		t.Errorf("unexpected error details %+v", keErr)
+	}
+
+	if err := mvcc.Delete(testKey1, makeTS(3, 0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.PutFresh(testKey1, makeTS(4, 0), value2, nil); err != nil {
+		t.Errorf("expected fresh put over tombstone to succeed; got %v", err)
+	}
+
+	if err := mvcc.PutFresh(testKey2, makeTS(1, 0), value1, txn1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.PutFresh(testKey2, makeTS(1, 0), value2, txn1); err != nil {
+		t.Errorf("expected fresh put over own intent to succeed; got %v", err)
+	}
+	if _, ok := mvcc.PutFresh(testKey2, makeTS(1, 0), value2, txn2).(*proto.WriteIntentError); !ok {
+		t.Error("expected WriteIntentError on another txn's intent")
+	}
+}
+
+// TestMVCCFirstKey verifies that the first key with versions or an
+// intent is found, including keys with deletion tombstones.
+func TestMVCCFirstKey(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	if err := mvcc.Put(testKey2, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Delete(testKey2, makeTS(2, 0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey4, makeTS(1, 0), value1, txn1); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		key, endKey, expKey proto.Key
+	}{
+		{testKey1, KeyMax, testKey2},
+		{testKey2, KeyMax, testKey2},
+		{testKey2.Next(), KeyMax, testKey4},
+		{testKey1, testKey2, nil},
+		{testKey4.Next(), KeyMax, nil},
+	}
+	for i, test := range testCases {
+		key, err := mvcc.FirstKey(test.key, test.endKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.Equal(test.expKey) {
+			t.Errorf("%d: expected first key %q; got %q", i, test.expKey, key)
+		}
+	}
+}
+
 func TestMVCCResolveTxn(t *testing.T) {
 	mvcc, _ := createTestMVCC()
 	err := mvcc.Put(testKey1, makeTS(0, 0), value1, txn1)
@@ -875,6 +1193,146 @@ func TestMVCCAbortTxnWithPreviousVersion(t *testing.T) {
 	}
 }
 
+// TestMVCCTxnSequenceReplay verifies that replays of sequenced writes
+// already applied by a transaction within its epoch are ignored,
+// while later writes and writes of a new epoch are applied.
+func TestMVCCTxnSequenceReplay(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	txnSeq := func(base *proto.Transaction, seq int32) *proto.Transaction {
+		txn := makeTxn(base, makeTS(0, 1))
+		txn.Sequence = gogoproto.Int32(seq)
+		return txn
+	}
+	expectValue := func(key proto.Key, txn *proto.Transaction, expected proto.Value) {
+		value, err := mvcc.Get(key, makeTS(0, 1), txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value == nil || !reflect.DeepEqual(value.Bytes, expected.Bytes) ||
+			value.GetInteger() != expected.GetInteger() {
+			t.Errorf("expected value %+v; got %+v", expected, value)
+		}
+	}
+
+	if err := mvcc.Put(testKey1, makeTS(0, 1), value1, txnSeq(txn1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// A replay of the put with a different value is ignored.
+	if err := mvcc.Put(testKey1, makeTS(0, 1), value2, txnSeq(txn1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(testKey1, txn1, value1)
+	// A later write is applied, after which the replay of the first
+	// still doesn't overwrite it.
+	if err := mvcc.Put(testKey1, makeTS(0, 1), value2, txnSeq(txn1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.Put(testKey1, makeTS(0, 1), value1, txnSeq(txn1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(testKey1, txn1, value2)
+
+	// A replayed conditional put succeeds without being evaluated
+	// against the value it wrote.
+	cput := txnSeq(txn1, 3)
+	if _, err := mvcc.ConditionalPut(testKey1, makeTS(0, 1), value3, &value2, cput); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mvcc.ConditionalPut(testKey1, makeTS(0, 1), value3, &value2, cput); err != nil {
+		t.Errorf("expected replayed conditional put to succeed; got %s", err)
+	}
+	expectValue(testKey1, txn1, value3)
+
+	// A replayed increment returns the incremented value without
+	// incrementing it again.
+	for i := 0; i < 2; i++ {
+		if r, err := mvcc.Increment(testKey2, makeTS(0, 1), txnSeq(txn1, 4), 5); err != nil || r != 5 {
+			t.Errorf("%d: expected increment to 5; got %d, %v", i, r, err)
+		}
+	}
+	expectValue(testKey2, txn1, proto.Value{Integer: gogoproto.Int64(5)})
+
+	// Writes of a new epoch, and unsequenced writes, aren't replays.
+	if err := mvcc.Put(testKey1, makeTS(0, 1), value4, txnSeq(txn1e2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(testKey1, txn1e2, value4)
+	if err := mvcc.Put(testKey1, makeTS(0, 1), value1, txn1e2); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(testKey1, txn1e2, value1)
+}
+
+// TestMVCCIgnoredSeqNums verifies that reads by a transaction skip the
+// values of its writes which it has rolled back, falling back to its
+// earlier writes or the committed value, and that resolving its
+// intents on commit discards the rolled back writes.
+func TestMVCCIgnoredSeqNums(t *testing.T) {
+	mvcc, _ := createTestMVCC()
+	if err := mvcc.Put(testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	txn := makeTxn(txn1, makeTS(2, 0))
+	writes := []struct {
+		key   proto.Key
+		value proto.Value
+	}{
+		{testKey1, value2},
+		{testKey1, value3},
+		{testKey2, value4},
+	}
+	for i, w := range writes {
+		txn.Sequence = gogoproto.Int32(int32(i + 1))
+		if err := mvcc.Put(w.key, txn.Timestamp, w.value, txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectValue := func(key proto.Key, txn *proto.Transaction, expected *proto.Value) {
+		value, err := mvcc.Get(key, makeTS(3, 0), txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (value == nil) != (expected == nil) || (value != nil && !bytes.Equal(value.Bytes, expected.Bytes)) {
+			t.Errorf("key %q: expected value %+v; got %+v", key, expected, value)
+		}
+	}
+	expectValue(testKey1, txn, &value3)
+	expectValue(testKey2, txn, &value4)
+
+	// Roll back the second and third writes.
+	txn.AddIgnoredSeqNums(2, 3)
+	expectValue(testKey1, txn, &value2)
+	expectValue(testKey2, txn, nil)
+	kvs, err := mvcc.Scan(testKey1, testKey3, 0, makeTS(3, 0), txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || !kvs[0].Key.Equal(testKey1) || !bytes.Equal(kvs[0].Value.Bytes, value2.Bytes) {
+		t.Errorf("expected only %q=%q; got %+v", testKey1, value2.Bytes, kvs)
+	}
+	// Rolling back the first write too reveals the committed value.
+	allTxn := makeTxn(txn, txn.Timestamp)
+	allTxn.AddIgnoredSeqNums(1, 1)
+	expectValue(testKey1, allTxn, &value1)
+
+	// Committing resolves the intents to the writes which weren't
+	// rolled back.
+	commitTxn := makeTxn(txn, txn.Timestamp)
+	commitTxn.Status = proto.COMMITTED
+	for _, key := range []proto.Key{testKey1, testKey2} {
+		if err := mvcc.ResolveWriteIntent(key, commitTxn); err != nil {
+			t.Fatal(err)
+		}
+		ms, err := MVCCComputeStats(mvcc.engine, KeyMin, KeyMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyStats(fmt.Sprintf("resolve %q", key), mvcc, ms, t)
+	}
+	expectValue(testKey1, nil, &value2)
+	expectValue(testKey2, nil, nil)
+}
+
 func TestMVCCWriteWithDiffTimestampsAndEpochs(t *testing.T) {
 	mvcc, _ := createTestMVCC()
 	// Start with epoch 1.
@@ -1173,6 +1631,79 @@ func TestFindSplitKey(t *testing.T) {
 	}
 }
 
+// noEstimatesEngine wraps an engine to provide no size estimates.
+type noEstimatesEngine struct {
+	Engine
+}
+
+func (e noEstimatesEngine) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
+	return 0, nil
+}
+
+// TestFindSplitKeyScan verifies that MVCCFindSplitKey falls back to
+// scanning the range if the engine provides no size estimates, and
+// that both methods yield a split key near the middle of the range.
+func TestFindSplitKeyScan(t *testing.T) {
+	engine := NewInMem(proto.Attributes{}, 1<<20)
+	mvcc := NewMVCC(engine)
+	// Each key has a metadata and a version entry; keep the total below
+	// the reservoir size so that the scan considers every entry.
+	numKeys := splitReservoirSize / 2
+	for i := 0; i < numKeys; i++ {
+		k := fmt.Sprintf("%09d", i)
+		if err := mvcc.Put([]byte(k), makeTS(0, 0), proto.Value{Bytes: []byte("X")}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := engine.CreateSnapshot("snap1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []Engine{engine, noEstimatesEngine{engine}} {
+		if _, ok := findApproximateSplitKey(e, MVCCEncodeKey(KeyMin), MVCCEncodeKey(KeyMax), "snap1"); ok != (e == engine) {
+			t.Errorf("%T: expected approximate split key to be found: %t", e, e == engine)
+		}
+		humanSplitKey, err := MVCCFindSplitKey(e, KeyMin, KeyMax, "snap1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ind, _ := strconv.Atoi(string(humanSplitKey))
+		if diff := numKeys/2 - ind; diff > 1 || diff < -1 {
+			t.Errorf("%T: wanted key #%d+-1, but got %d", e, numKeys/2, ind)
+		}
+	}
+}
+
+// TestKeyMidpoint verifies that keyMidpoint returns a key sorting
+// strictly between its arguments, or the lower key if no such key
+// exists.
+func TestKeyMidpoint(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		adjacent bool
+	}{
+		{"", "\x00", true},
+		{"a", "b", false},
+		{"a", "a\x00", true},
+		{"a", "a\x00\x00", true},
+		{"a", "a\x00\x01", false},
+		{"abc", "abd", false},
+		{"\xff", "\xff\xff", false},
+		{"a", "z", false},
+	}
+	for i, test := range testCases {
+		mid := keyMidpoint(proto.EncodedKey(test.a), proto.EncodedKey(test.b))
+		if test.adjacent {
+			if !bytes.Equal(mid, []byte(test.a)) {
+				t.Errorf("%d: expected no midpoint between %q and %q; got %q", i, test.a, test.b, mid)
+			}
+			continue
+		}
+		if bytes.Compare([]byte(test.a), mid) >= 0 || bytes.Compare(mid, []byte(test.b)) >= 0 {
+			t.Errorf("%d: expected %q < %q < %q", i, test.a, mid, test.b)
+		}
+	}
+}
+
 // encodedSize returns the encoded size of the protobuf message.
 func encodedSize(msg gogoproto.Message, t *testing.T) int64 {
 	data, err := gogoproto.Marshal(msg)
@@ -1182,7 +1713,7 @@ func encodedSize(msg gogoproto.Message, t *testing.T) int64 {
 	return int64(len(data))
 }
 
-func verifyStats(debug string, mvcc *MVCC, ms MVCCStats, t *testing.T) {
+func verifyStats(debug string, mvcc *MVCC, ms proto.MVCCStats, t *testing.T) {
 	// ...And verify stats.
 	if ms.LiveBytes != mvcc.LiveBytes {
 		t.Errorf("%s: mvcc live bytes %d; measured %d", debug, mvcc.LiveBytes, ms.LiveBytes)
@@ -1228,7 +1759,7 @@ func TestMVCCStatsBasic(t *testing.T) {
 	vKeySize := int64(len(MVCCEncodeVersionKey(key, ts)))
 	vValSize := encodedSize(&proto.MVCCValue{Value: &value}, t)
 
-	ms := MVCCStats{
+	ms := proto.MVCCStats{
 		LiveBytes: mKeySize + mValSize + vKeySize + vValSize,
 		LiveCount: 1,
 		KeyBytes:  mKeySize + vKeySize,
@@ -1247,7 +1778,7 @@ func TestMVCCStatsBasic(t *testing.T) {
 	m2ValSize := encodedSize(&proto.MVCCMetadata{Timestamp: ts2, Deleted: true, Txn: txn}, t)
 	v2KeySize := int64(len(MVCCEncodeVersionKey(key, ts2)))
 	v2ValSize := encodedSize(&proto.MVCCValue{Deleted: true}, t)
-	ms2 := MVCCStats{
+	ms2 := proto.MVCCStats{
 		KeyBytes:    mKeySize + vKeySize + v2KeySize,
 		KeyCount:    1,
 		ValBytes:    m2ValSize + vValSize + v2ValSize,
@@ -1279,7 +1810,7 @@ func TestMVCCStatsBasic(t *testing.T) {
 		t.Fatal(err)
 	}
 	m3ValSize := encodedSize(&proto.MVCCMetadata{Timestamp: ts3, Deleted: true}, t)
-	ms3 := MVCCStats{
+	ms3 := proto.MVCCStats{
 		KeyBytes: mKeySize + vKeySize + v2KeySize,
 		KeyCount: 1,
 		ValBytes: m3ValSize + vValSize + v2ValSize,
@@ -1303,7 +1834,7 @@ func TestMVCCStatsWithRandomRuns(t *testing.T) {
 	mvcc, _ := createTestMVCC()
 
 	// Test with empty mvcc.
-	verifyStats("empty test", mvcc, MVCCStats{}, t)
+	verifyStats("empty test", mvcc, proto.MVCCStats{}, t)
 
 	// Now, generate a rngom sequence of puts, deletes and resolves.
 	// Each put and delete may or may not involve a txn. Resolves may
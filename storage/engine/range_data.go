@@ -0,0 +1,175 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// A keySpan is a half-open interval [start, end) of encoded keys.
+type keySpan struct {
+	start, end proto.EncodedKey
+}
+
+// makeRangeIDPrefixSpan returns the span of keys beginning with
+// prefix followed by the encoded range ID.
+func makeRangeIDPrefixSpan(prefix proto.Key, rangeID int64) keySpan {
+	key := MakeKey(prefix, encoding.EncodeInt(nil, rangeID))
+	return keySpan{start: MVCCEncodeKey(key), end: MVCCEncodeKey(key.PrefixEnd())}
+}
+
+// rangeLocalSpans returns the spans containing all range-local keys
+// for the specified range, in sorted order: response cache entries,
+// range stats, the range descriptor, response cache sessions, and
+// transaction records addressed to keys within the range.
+func rangeLocalSpans(rangeID int64, desc *proto.RangeDescriptor) []keySpan {
+	descKey := MVCCEncodeKey(MakeKey(KeyLocalRangeDescriptorPrefix, desc.StartKey))
+	statsKey := MVCCEncodeKey(MakeRangeMVCCStatsKey(rangeID))
+	return []keySpan{
+		makeRangeIDPrefixSpan(KeyLocalResponseCachePrefix, rangeID),
+		{start: statsKey, end: statsKey.Next()},
+		{start: descKey, end: descKey.PrefixEnd()},
+		makeRangeIDPrefixSpan(KeyLocalResponseCacheSessionPrefix, rangeID),
+		{
+			start: MVCCEncodeKey(MakeKey(KeyLocalTransactionPrefix, desc.StartKey)),
+			end:   MVCCEncodeKey(MakeKey(KeyLocalTransactionPrefix, desc.EndKey)),
+		},
+	}
+}
+
+// IterateRangeLocal invokes f on each range-local key/value pair
+// belonging to the specified range, in key order. This includes the
+// range descriptor (all versions), range stats, response cache
+// entries and sessions, and transaction records addressed to keys
+// within the range. Iteration stops early if f returns true or an
+// error.
+func IterateRangeLocal(engine Engine, rangeID int64, desc *proto.RangeDescriptor, f func(proto.RawKeyValue) (bool, error)) error {
+	for _, span := range rangeLocalSpans(rangeID, desc) {
+		done := false
+		if err := engine.Iterate(span.start, span.end, func(kv proto.RawKeyValue) (bool, error) {
+			var err error
+			done, err = f(kv)
+			return done, err
+		}); err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckRangeInvariants verifies the consistency of the range-local
+// data for the specified range against the supplied descriptor and
+// the range's MVCC data. The following invariants are checked:
+//
+//   - The descriptor's start key sorts before its end key.
+//   - The stored descriptor matches the supplied descriptor.
+//   - The descriptor contains a replica with the range ID.
+//   - The stored stats match stats computed from the MVCC data.
+//   - Response cache and session keys are well-formed.
+//   - Transaction records decode as transactions.
+//
+// Returns a description of each violation found; the slice is empty
+// if the range is consistent. An error is returned only if the data
+// could not be read.
+func CheckRangeInvariants(engine Engine, rangeID int64, desc *proto.RangeDescriptor) ([]string, error) {
+	var violations []string
+	violatef := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	if !proto.Key(desc.StartKey).Less(desc.EndKey) {
+		violatef("descriptor start key %q does not sort before end key %q", desc.StartKey, desc.EndKey)
+	}
+
+	descKey := MakeKey(KeyLocalRangeDescriptorPrefix, desc.StartKey)
+	stored := &proto.RangeDescriptor{}
+	if ok, err := NewMVCC(engine).GetProto(descKey, proto.MaxTimestamp, nil, stored); err != nil {
+		violatef("unable to read descriptor at %q: %s", descKey, err)
+	} else if !ok {
+		violatef("no descriptor stored at %q", descKey)
+	} else if !reflect.DeepEqual(stored, desc) {
+		violatef("stored descriptor %+v does not match %+v", stored, desc)
+	}
+	found := false
+	for _, replica := range desc.Replicas {
+		if replica.RangeID == rangeID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		violatef("descriptor contains no replica for range %d", rangeID)
+	}
+
+	recorded, err := GetRangeMVCCStats(engine, rangeID)
+	if err != nil {
+		return nil, util.Errorf("unable to read stats for range %d: %s", rangeID, err)
+	}
+	computed, err := MVCCComputeStats(engine, desc.StartKey, desc.EndKey)
+	if err != nil {
+		violatef("unable to compute stats: %s", err)
+	} else if !reflect.DeepEqual(*recorded, computed) {
+		violatef("recorded stats %+v do not match computed stats %+v", *recorded, computed)
+	}
+
+	rcPrefix := MakeKey(KeyLocalResponseCachePrefix, encoding.EncodeInt(nil, rangeID))
+	rcsPrefix := MakeKey(KeyLocalResponseCacheSessionPrefix, encoding.EncodeInt(nil, rangeID))
+	txn := &proto.Transaction{}
+	if err := IterateRangeLocal(engine, rangeID, desc, func(kv proto.RawKeyValue) (bool, error) {
+		key, _, isValue := MVCCDecodeKey(kv.Key)
+		switch {
+		case bytes.HasPrefix(key, rcPrefix):
+			if !isWellFormed(key[len(rcPrefix):], 2) {
+				violatef("malformed response cache key %q", key)
+			}
+		case bytes.HasPrefix(key, rcsPrefix):
+			if !isWellFormed(key[len(rcsPrefix):], 1) {
+				violatef("malformed response cache session key %q", key)
+			}
+		case bytes.HasPrefix(key, KeyLocalTransactionPrefix) && !isValue:
+			if err := gogoproto.Unmarshal(kv.Value, txn); err != nil {
+				violatef("unable to decode transaction record %q: %s", key, err)
+			}
+		}
+		return false, nil
+	}); err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
+
+// isWellFormed returns whether b consists of exactly count encoded
+// integers.
+func isWellFormed(b []byte, count int) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	for i := 0; i < count; i++ {
+		b, _ = encoding.DecodeInt(b)
+	}
+	return len(b) == 0
+}
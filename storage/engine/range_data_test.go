@@ -0,0 +1,173 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// createRangeData writes a descriptor, MVCC data with stats, response
+// cache entries and a transaction record for a range with ID 1
+// spanning ["a", "c"), plus local data for a neighboring range with
+// ID 2 spanning ["c", KeyMax). Returns the engine, the descriptor of
+// range 1 and the expected range-local keys of range 1 in order.
+func createRangeData(t *testing.T) (Engine, *proto.RangeDescriptor, []proto.EncodedKey) {
+	e := NewInMem(proto.Attributes{}, 1<<20)
+	desc := &proto.RangeDescriptor{
+		RaftID:   1,
+		StartKey: proto.Key("a"),
+		EndKey:   proto.Key("c"),
+		Replicas: []proto.Replica{{NodeID: 1, StoreID: 1, RangeID: 1}},
+	}
+	desc2 := &proto.RangeDescriptor{
+		RaftID:   2,
+		StartKey: proto.Key("c"),
+		EndKey:   KeyMax,
+		Replicas: []proto.Replica{{NodeID: 1, StoreID: 1, RangeID: 2}},
+	}
+	ts := makeTS(1, 0)
+	mvcc := NewMVCC(e)
+	descKey := MakeKey(KeyLocalRangeDescriptorPrefix, desc.StartKey)
+	if err := mvcc.PutProto(descKey, ts, nil, desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvcc.PutProto(MakeKey(KeyLocalRangeDescriptorPrefix, desc2.StartKey), ts, nil, desc2); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "bb"} {
+		if err := mvcc.Put(proto.Key(k), ts, proto.Value{Bytes: []byte("value")}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mvcc.MergeStats(1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var rangeKeys []proto.EncodedKey
+	for _, rangeID := range []int64{1, 2} {
+		rcKey := MakeKey(KeyLocalResponseCachePrefix, encoding.EncodeInt(encoding.EncodeInt(encoding.EncodeInt(nil, rangeID), 10), 20))
+		rcsKey := MakeKey(KeyLocalResponseCacheSessionPrefix, encoding.EncodeInt(encoding.EncodeInt(nil, rangeID), 30))
+		for _, key := range []proto.Key{rcKey, rcsKey} {
+			if err := e.Put(MVCCEncodeKey(key), []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rangeID == 1 {
+			rangeKeys = append(rangeKeys, MVCCEncodeKey(rcKey), MVCCEncodeKey(MakeRangeMVCCStatsKey(1)),
+				MVCCEncodeKey(descKey), MVCCEncodeVersionKey(descKey, ts), MVCCEncodeKey(rcsKey))
+		}
+	}
+	for _, k := range []string{"b", "c"} {
+		txn := &proto.Transaction{Name: k, ID: []byte("id")}
+		txnKey := MakeKey(KeyLocalTransactionPrefix, proto.Key(k), txn.ID)
+		if _, _, err := PutProto(e, MVCCEncodeKey(txnKey), txn); err != nil {
+			t.Fatal(err)
+		}
+		if desc.ContainsKey(proto.Key(k)) {
+			rangeKeys = append(rangeKeys, MVCCEncodeKey(txnKey))
+		}
+	}
+	return e, desc, rangeKeys
+}
+
+// TestIterateRangeLocal verifies that exactly the range-local keys of
+// the specified range are iterated, in order.
+func TestIterateRangeLocal(t *testing.T) {
+	e, desc, expKeys := createRangeData(t)
+	var keys []proto.EncodedKey
+	if err := IterateRangeLocal(e, 1, desc, func(kv proto.RawKeyValue) (bool, error) {
+		keys = append(keys, kv.Key)
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(expKeys) {
+		t.Fatalf("expected %d keys; got %d: %q", len(expKeys), len(keys), keys)
+	}
+	for i := range keys {
+		if !bytes.Equal(keys[i], expKeys[i]) {
+			t.Errorf("%d: expected key %q; got %q", i, expKeys[i], keys[i])
+		}
+	}
+
+	// Verify iteration stops early.
+	count := 0
+	if err := IterateRangeLocal(e, 1, desc, func(kv proto.RawKeyValue) (bool, error) {
+		count++
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected iteration to stop after 1 key; got %d", count)
+	}
+}
+
+// TestCheckRangeInvariants verifies that a consistent range reports
+// no violations and that each kind of inconsistency is detected.
+func TestCheckRangeInvariants(t *testing.T) {
+	testCases := []struct {
+		corrupt func(e Engine, desc *proto.RangeDescriptor)
+		expErr  string
+	}{
+		{func(e Engine, desc *proto.RangeDescriptor) {}, ""},
+		{func(e Engine, desc *proto.RangeDescriptor) { desc.EndKey = desc.StartKey }, "does not sort before"},
+		{func(e Engine, desc *proto.RangeDescriptor) { desc.StartKey = proto.Key("aa") }, "no descriptor stored"},
+		{func(e Engine, desc *proto.RangeDescriptor) { desc.Replicas[0].RangeID = 3 }, "contains no replica"},
+		{func(e Engine, desc *proto.RangeDescriptor) { SetRangeMVCCStats(e, 1, &proto.MVCCStats{LiveCount: 10}) }, "do not match computed stats"},
+		{func(e Engine, desc *proto.RangeDescriptor) {
+			key := MakeKey(KeyLocalResponseCachePrefix, encoding.EncodeInt(nil, 1), proto.Key("x"))
+			e.Put(MVCCEncodeKey(key), []byte("data"))
+		}, "malformed response cache key"},
+		{func(e Engine, desc *proto.RangeDescriptor) {
+			key := MakeKey(KeyLocalResponseCacheSessionPrefix, encoding.EncodeInt(nil, 1))
+			e.Put(MVCCEncodeKey(key), []byte("data"))
+		}, "malformed response cache session key"},
+		{func(e Engine, desc *proto.RangeDescriptor) {
+			e.Put(MVCCEncodeKey(MakeKey(KeyLocalTransactionPrefix, proto.Key("b"))), []byte("\xff"))
+		}, "unable to decode transaction record"},
+	}
+	for i, test := range testCases {
+		e, desc, _ := createRangeData(t)
+		test.corrupt(e, desc)
+		violations, err := CheckRangeInvariants(e, 1, desc)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if test.expErr == "" {
+			if len(violations) != 0 {
+				t.Errorf("%d: expected no violations; got %q", i, violations)
+			}
+			continue
+		}
+		found := false
+		for _, v := range violations {
+			if strings.Contains(v, test.expErr) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%d: expected violation matching %q; got %q", i, test.expErr, violations)
+		}
+	}
+}
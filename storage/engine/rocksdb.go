@@ -18,6 +18,8 @@
 // Author: Tobias Schottdorf (tobias.schottdorf@gmail.com)
 // Author: Jiang-Ming Yang (jiangming.yang@gmail.com)
 
+// +build cgo
+
 package engine
 
 // #cgo pkg-config: ./engine.pc
@@ -167,10 +169,6 @@ func (r *RocksDB) Attrs() proto.Attributes {
 	return r.attrs
 }
 
-func emptyKeyError() error {
-	return util.ErrorSkipFrames(1, "attempted access to empty key")
-}
-
 // Put sets the given key to the value provided.
 //
 // The key and value byte slices may be reused safely. put takes a copy of
@@ -385,6 +383,14 @@ func (r *RocksDB) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
 	return uint64(C.DBApproximateSize(r.rdb, goToCSlice(start), goToCSlice(end))), nil
 }
 
+// ApproximateKeyCount returns the approximate number of keys RocksDB
+// is storing in the given range of keys. The estimate is derived from
+// table properties and, like ApproximateSize, does not reflect data
+// which has not yet been flushed from the memtable.
+func (r *RocksDB) ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error) {
+	return uint64(C.DBApproximateKeyCount(r.rdb, goToCSlice(start), goToCSlice(end))), nil
+}
+
 // Flush causes RocksDB to write all in-memory data to disk immediately.
 func (r *RocksDB) Flush() error {
 	return statusToError(C.DBFlush(r.rdb))
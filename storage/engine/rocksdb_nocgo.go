@@ -0,0 +1,90 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build !cgo
+
+package engine
+
+import (
+	"math"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// RocksDB requires cgo. In builds without cgo, a RocksDB is backed by
+// the pure-Go LogDB engine, which stores its data in the same
+// directory.
+type RocksDB struct {
+	*LogDB
+}
+
+// NewRocksDB allocates and returns a new RocksDB object backed by a
+// LogDB engine.
+func NewRocksDB(attrs proto.Attributes, dir string) *RocksDB {
+	return &RocksDB{LogDB: NewLogDB(attrs, dir)}
+}
+
+// CompactRange is a noop in builds without cgo.
+func (r *RocksDB) CompactRange(start, end proto.EncodedKey) {}
+
+// NewBatch returns a new Batch wrapping this engine.
+func (r *RocksDB) NewBatch() Engine {
+	return &Batch{engine: r}
+}
+
+// goMerge is a pure-Go implementation of the merge operator used by
+// RocksDB. Byte values are appended and integer values are added; a
+// missing existing value is replaced by the update. Returns an error
+// if the values are of different types or the addition overflows.
+func goMerge(existing, update []byte) ([]byte, error) {
+	var value, updateValue proto.Value
+	if err := gogoproto.Unmarshal(existing, &value); err != nil {
+		return nil, util.Errorf("corrupted existing value: existing=%q, update=%q", existing, update)
+	}
+	if err := gogoproto.Unmarshal(update, &updateValue); err != nil {
+		return nil, util.Errorf("corrupted update value: existing=%q, update=%q", existing, update)
+	}
+	switch {
+	case value.Bytes != nil:
+		if updateValue.Bytes == nil {
+			return nil, util.Errorf("incompatible merge values: existing=%q, update=%q", existing, update)
+		}
+		value.Bytes = append(value.Bytes, updateValue.Bytes...)
+	case value.Integer != nil:
+		if updateValue.Integer == nil || willOverflow(*value.Integer, *updateValue.Integer) {
+			return nil, util.Errorf("incompatible merge values: existing=%q, update=%q", existing, update)
+		}
+		value.Integer = gogoproto.Int64(*value.Integer + *updateValue.Integer)
+	default:
+		value = updateValue
+	}
+	value.Checksum = nil
+	return gogoproto.Marshal(&value)
+}
+
+// willOverflow returns whether the sum of a and b overflows an int64.
+func willOverflow(a, b int64) bool {
+	if a > b {
+		a, b = b, a
+	}
+	if b > 0 {
+		return a > math.MaxInt64-b
+	}
+	return math.MinInt64-b > a
+}
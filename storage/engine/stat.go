@@ -70,8 +70,15 @@ func encodeStatValue(stat int64) (ok bool, enc []byte) {
 	return true, data
 }
 
-// MakeRangeStatKey returns the key for accessing the named stat
-// for the specified range ID.
+// MakeRangeMVCCStatsKey returns the key for accessing the MVCC stats
+// of the specified range ID.
+func MakeRangeMVCCStatsKey(rangeID int64) proto.Key {
+	return MakeKey(KeyLocalRangeMVCCStatsPrefix, encoding.EncodeInt(nil, rangeID))
+}
+
+// MakeRangeStatKey returns the key of the named per-stat counter for
+// the specified range ID. These counters are only read to migrate
+// them to MVCC stats.
 func MakeRangeStatKey(rangeID int64, stat proto.Key) proto.Key {
 	encRangeID := encoding.EncodeInt(nil, rangeID)
 	return MakeKey(KeyLocalRangeStatPrefix, encRangeID, stat)
@@ -84,49 +91,81 @@ func MakeStoreStatKey(storeID int32, stat proto.Key) proto.Key {
 	return MakeKey(KeyLocalStoreStatPrefix, encStoreID, stat)
 }
 
-// GetRangeStat fetches the specified stat from the provided engine.
-// If the stat could not be found, returns 0. An error is returned
-// on stat decode error.
-func GetRangeStat(engine Engine, rangeID int64, stat proto.Key) (int64, error) {
+// GetRangeMVCCStats fetches the MVCC stats of the specified range
+// from the provided engine. If none are found, returns zero stats.
+func GetRangeMVCCStats(engine Engine, rangeID int64) (*proto.MVCCStats, error) {
+	ms := &proto.MVCCStats{}
+	if _, _, _, err := GetProto(engine, MVCCEncodeKey(MakeRangeMVCCStatsKey(rangeID)), ms); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// SetRangeMVCCStats writes the MVCC stats of the specified range via
+// the provided engine.
+func SetRangeMVCCStats(engine Engine, rangeID int64, ms *proto.MVCCStats) error {
+	_, _, err := PutProto(engine, MVCCEncodeKey(MakeRangeMVCCStatsKey(rangeID)), ms)
+	return err
+}
+
+// MergeMVCCStats adds the accumulated stats ms to the MVCC stats of
+// the affected range and merges them to the stat counters of the
+// store. Range stats are read, updated and written in their
+// entirety, so the caller must serialize calls for a range and
+// provide a batch to update the stats atomically with the writes
+// they account for. Only updates range or store stats if the
+// corresponding ID is non-zero.
+func MergeMVCCStats(engine Engine, ms *proto.MVCCStats, rangeID int64, storeID int32) error {
+	if rangeID != 0 {
+		rangeMS, err := GetRangeMVCCStats(engine, rangeID)
+		if err != nil {
+			return err
+		}
+		rangeMS.Add(*ms)
+		if err := SetRangeMVCCStats(engine, rangeID, rangeMS); err != nil {
+			return err
+		}
+	}
+	if storeID != 0 {
+		MergeStoreStat(engine, storeID, StatLiveBytes, ms.LiveBytes)
+		MergeStoreStat(engine, storeID, StatKeyBytes, ms.KeyBytes)
+		MergeStoreStat(engine, storeID, StatValBytes, ms.ValBytes)
+		MergeStoreStat(engine, storeID, StatIntentBytes, ms.IntentBytes)
+		MergeStoreStat(engine, storeID, StatLiveCount, ms.LiveCount)
+		MergeStoreStat(engine, storeID, StatKeyCount, ms.KeyCount)
+		MergeStoreStat(engine, storeID, StatValCount, ms.ValCount)
+		MergeStoreStat(engine, storeID, StatIntentCount, ms.IntentCount)
+	}
+	return nil
+}
+
+// GetStoreStat fetches the named stat for the specified store from
+// the provided engine. If the stat is not found, returns 0.
+func GetStoreStat(engine Engine, storeID int32, stat proto.Key) (int64, error) {
 	val := &proto.Value{}
-	ok, _, _, err := GetProto(engine, MVCCEncodeKey(MakeRangeStatKey(rangeID, stat)), val)
-	if err != nil || !ok {
+	if _, _, _, err := GetProto(engine, MVCCEncodeKey(MakeStoreStatKey(storeID, stat)), val); err != nil {
 		return 0, err
 	}
 	return val.GetInteger(), nil
 }
 
-// MergeStat flushes the specified stat to merge counters via the
-// provided engine for both the affected range and store. Only
-// updates range or store stats if the corresponding ID is non-zero.
-func MergeStat(engine Engine, rangeID int64, storeID int32, stat proto.Key, statVal int64) {
+// MergeStoreStat flushes the specified stat to its merge counter for
+// the store via the provided engine.
+func MergeStoreStat(engine Engine, storeID int32, stat proto.Key, statVal int64) {
 	if ok, encStat := encodeStatValue(statVal); ok {
-		if rangeID != 0 {
-			engine.Merge(MVCCEncodeKey(MakeRangeStatKey(rangeID, stat)), encStat)
-		}
-		if storeID != 0 {
-			engine.Merge(MVCCEncodeKey(MakeStoreStatKey(storeID, stat)), encStat)
-		}
+		engine.Merge(MVCCEncodeKey(MakeStoreStatKey(storeID, stat)), encStat)
 	}
 }
 
-// SetStat writes the specified stat to counters via the provided
-// engine for both the affected range and store. Only updates range or
-// store stats if the corresponding ID is non-zero.
-func SetStat(engine Engine, rangeID int64, storeID int32, stat proto.Key, statVal int64) {
+// SetStoreStat writes the specified stat to its counter for the store
+// via the provided engine.
+func SetStoreStat(engine Engine, storeID int32, stat proto.Key, statVal int64) {
 	if ok, encStat := encodeStatValue(statVal); ok {
-		if rangeID != 0 {
-			engine.Put(MVCCEncodeKey(MakeRangeStatKey(rangeID, stat)), encStat)
-		}
-		if storeID != 0 {
-			engine.Put(MVCCEncodeKey(MakeStoreStatKey(storeID, stat)), encStat)
-		}
+		engine.Put(MVCCEncodeKey(MakeStoreStatKey(storeID, stat)), encStat)
 	}
 }
 
 // ClearRangeStats clears stats for the specified range.
 func ClearRangeStats(engine Engine, rangeID int64) error {
-	statStartKey := MakeKey(KeyLocalRangeStatPrefix, encoding.EncodeInt(nil, rangeID))
-	_, err := ClearRange(engine, MVCCEncodeKey(statStartKey), MVCCEncodeKey(statStartKey.PrefixEnd()))
-	return err
+	return engine.Clear(MVCCEncodeKey(MakeRangeMVCCStatsKey(rangeID)))
 }
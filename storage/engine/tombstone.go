@@ -0,0 +1,48 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// MakeRangeTombstoneKey returns the key for accessing the tombstone
+// of the range with the specified Raft ID.
+func MakeRangeTombstoneKey(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRangeTombstonePrefix, encoding.EncodeInt(nil, raftID))
+}
+
+// GetRangeTombstone fetches the tombstone of the range with the
+// specified Raft ID from the provided engine. If none is found,
+// returns nil.
+func GetRangeTombstone(engine Engine, raftID int64) (*proto.RangeTombstone, error) {
+	tombstone := &proto.RangeTombstone{}
+	ok, _, _, err := GetProto(engine, MVCCEncodeKey(MakeRangeTombstoneKey(raftID)), tombstone)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return tombstone, nil
+}
+
+// SetRangeTombstone writes the tombstone of the range with the
+// specified Raft ID via the provided engine.
+func SetRangeTombstone(engine Engine, raftID int64, tombstone *proto.RangeTombstone) error {
+	_, _, err := PutProto(engine, MVCCEncodeKey(MakeRangeTombstoneKey(raftID)), tombstone)
+	return err
+}
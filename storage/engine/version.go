@@ -0,0 +1,37 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package engine
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// GetClusterVersion returns the most recently committed cluster
+// version stored in the engine. Returns proto.VersionBase if no
+// version has been written.
+func GetClusterVersion(engine Engine) (proto.ClusterVersion, error) {
+	value, err := NewMVCC(engine).Get(KeyClusterVersion, proto.MaxTimestamp, nil)
+	if err != nil || value == nil {
+		return proto.VersionBase, err
+	}
+	if value.Integer == nil {
+		return 0, util.Errorf("cluster version value is not an integer: %+v", value)
+	}
+	return proto.ClusterVersion(value.GetInteger()), nil
+}
@@ -18,8 +18,12 @@
 package storage
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -27,21 +31,30 @@ import (
 // causes allocation of the next block of IDs.
 const allocationTrigger = 0
 
+// idAllocationRetryOpts sets the retry options for handling RPC errors.
+var idAllocationRetryOpts = util.RetryOptions{
+	Backoff:    50 * time.Millisecond,
+	MaxBackoff: 5 * time.Second,
+	Constant:   2,
+}
+
 // An IDAllocator is used to increment a key in allocation blocks
 // of arbitrary size starting at a minimum ID.
 type IDAllocator struct {
 	idKey     proto.Key
 	db        *client.KV
-	minID     int64      // Minimum ID to return
-	blockSize int64      // Block allocation size
-	ids       chan int64 // Channel of available IDs
+	minID     int64         // Minimum ID to return
+	blockSize int64         // Block allocation size
+	ids       chan int64    // Channel of available IDs
+	stopper   *util.Stopper // Aborts allocation retries on stop
 }
 
 // NewIDAllocator creates a new ID allocator which increments the
 // specified key in allocation blocks of size blockSize, with
 // allocated IDs starting at minID. Allocated IDs are positive
-// integers.
-func NewIDAllocator(idKey proto.Key, db *client.KV, minID int64, blockSize int64) *IDAllocator {
+// integers. Block allocations are run as workers of the supplied
+// stopper.
+func NewIDAllocator(idKey proto.Key, db *client.KV, minID int64, blockSize int64, stopper *util.Stopper) *IDAllocator {
 	if minID <= allocationTrigger {
 		log.Fatalf("minID must be > %d", allocationTrigger)
 	}
@@ -54,6 +67,7 @@ func NewIDAllocator(idKey proto.Key, db *client.KV, minID int64, blockSize int64
 		minID:     minID,
 		blockSize: blockSize,
 		ids:       make(chan int64, blockSize+blockSize/2+1),
+		stopper:   stopper,
 	}
 	ia.ids <- allocationTrigger
 	return ia
@@ -64,7 +78,9 @@ func (ia *IDAllocator) Allocate() int64 {
 	for {
 		id := <-ia.ids
 		if id == allocationTrigger {
-			go ia.allocateBlock(ia.blockSize)
+			ia.stopper.RunWorker(func() {
+				ia.allocateBlock(ia.blockSize)
+			})
 		} else {
 			return id
 		}
@@ -74,17 +90,27 @@ func (ia *IDAllocator) Allocate() int64 {
 // allocateBlock allocates a block of IDs using db.Increment and
 // sends all IDs on the ids channel. Midway through the block, a
 // special allocationTrigger ID is inserted which causes allocation
-// to occur before IDs run out to hide Increment latency.
+// to occur before IDs run out to hide Increment latency. Failed
+// increments are retried with backoff until the stopper is signaled.
 func (ia *IDAllocator) allocateBlock(incr int64) {
 	ir := &proto.IncrementResponse{}
-	if err := ia.db.Call(proto.Increment, &proto.IncrementRequest{
-		RequestHeader: proto.RequestHeader{
-			Key:  ia.idKey,
-			User: UserRoot,
-		},
-		Increment: incr,
-	}, ir); err != nil {
-		log.Fatalf("unable to allocate %d %q IDs: %v", ia.blockSize, ia.idKey, err)
+	retryOpts := idAllocationRetryOpts
+	retryOpts.Tag = fmt.Sprintf("allocate %d %q IDs", incr, ia.idKey)
+	retryOpts.Stopper = ia.stopper
+	if err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
+		if err := ia.db.Call(proto.Increment, &proto.IncrementRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:  ia.idKey,
+				User: UserRoot,
+			},
+			Increment: incr,
+		}, ir); err != nil {
+			return util.RetryContinue, err
+		}
+		return util.RetryBreak, nil
+	}); err != nil {
+		log.Errorf("aborting allocation of %q IDs: %v", ia.idKey, err)
+		return
 	}
 	if ir.NewValue <= ia.minID {
 		log.Warningf("allocator key is currently set at %d; minID is %d; allocating again to skip %d IDs",
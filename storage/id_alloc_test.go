@@ -31,9 +31,10 @@ import (
 // channel, which is queried at the end to ensure that all IDs
 // from 2 to 101 are present.
 func TestIDAllocator(t *testing.T) {
-	store, _ := createTestStore(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	allocd := make(chan int, 100)
-	idAlloc := NewIDAllocator(engine.KeyRaftIDGenerator, store.db, 2, 10)
+	idAlloc := NewIDAllocator(engine.KeyRaftIDGenerator, store.db, 2, 10, stopper)
 
 	for i := 0; i < 10; i++ {
 		go func() {
@@ -69,7 +70,8 @@ func TestIDAllocator(t *testing.T) {
 // the id allocator makes a double-alloc to make up the difference
 // and push the id allocation into positive integers.
 func TestIDAllocatorNegativeValue(t *testing.T) {
-	store, _ := createTestStore(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	// Increment our key to a negative value.
 	mvcc := engine.NewMVCC(store.engine)
 	newValue, err := mvcc.Increment(engine.KeyRaftIDGenerator, store.clock.Now(), nil, -1024)
@@ -79,7 +81,7 @@ func TestIDAllocatorNegativeValue(t *testing.T) {
 	if newValue != -1024 {
 		t.Errorf("expected new value to be -1024; got %d", newValue)
 	}
-	idAlloc := NewIDAllocator(engine.KeyRaftIDGenerator, store.db, 2, 10)
+	idAlloc := NewIDAllocator(engine.KeyRaftIDGenerator, store.db, 2, 10, stopper)
 	value := idAlloc.Allocate()
 	if value != 2 {
 		t.Errorf("expected id allocation to have value 2; got %d", value)
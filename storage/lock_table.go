@@ -0,0 +1,175 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// A lockTable holds the shared and exclusive locks acquired by
+// transactions on the keys and key ranges of a range, for pessimistic
+// concurrency control (e.g. SELECT FOR UPDATE). Locks are held in
+// memory only and conflict only with other locks; writes remain
+// serialized by their intents.
+//
+// Lock requests are granted in FIFO order: a request waits not only
+// for conflicting locks held by other transactions but also for
+// earlier, still waiting requests of other transactions which it
+// conflicts with, so a stream of shared locks can't starve an
+// exclusive one. A waiter which isn't granted its lock in time gives
+// up and reports the transaction it's blocked on, which the caller
+// may push. Pushes are what break deadlocks between transactions
+// waiting on each other's locks. lockTable is safe for concurrent use.
+type lockTable struct {
+	mu      sync.Mutex
+	held    []*lockRequest // Granted locks
+	waiting []*lockRequest // Requests waiting to be granted, in arrival order
+}
+
+// A lockRequest is a request by txn to lock the span [key, endKey).
+type lockRequest struct {
+	key, endKey proto.Key
+	txn         *proto.Transaction
+	strength    proto.LockStrength
+	granted     chan struct{} // Closed once a waiting request is granted
+}
+
+// newLockTable returns an empty lock table.
+func newLockTable() *lockTable {
+	return &lockTable{}
+}
+
+// conflicts returns whether lr and o are requests of different
+// transactions for overlapping spans, at least one of them exclusive.
+func (lr *lockRequest) conflicts(o *lockRequest) bool {
+	if bytes.Equal(lr.txn.ID, o.txn.ID) {
+		return false
+	}
+	if lr.strength != proto.EXCLUSIVE && o.strength != proto.EXCLUSIVE {
+		return false
+	}
+	return lr.key.Less(o.endKey) && o.key.Less(lr.endKey)
+}
+
+// conflict returns the first held lock, or else the first of the
+// earlier waiting requests, which conflicts with lr; nil if there is
+// none. The caller must hold lt.mu.
+func (lt *lockTable) conflict(lr *lockRequest, earlier []*lockRequest) *lockRequest {
+	for _, o := range lt.held {
+		if lr.conflicts(o) {
+			return o
+		}
+	}
+	for _, o := range earlier {
+		if lr.conflicts(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// acquire locks the span [key, endKey) with the given strength on
+// behalf of txn, waiting up to timeout for conflicting requests to be
+// released or granted. An empty endKey locks key alone. Returns nil
+// once the lock is held; otherwise, returns the transaction of the
+// request the lock is blocked on.
+func (lt *lockTable) acquire(key, endKey proto.Key, txn *proto.Transaction, strength proto.LockStrength, timeout time.Duration) *proto.Transaction {
+	if len(endKey) == 0 {
+		endKey = key.Next()
+	}
+	lr := &lockRequest{key: key, endKey: endKey, txn: txn, strength: strength}
+	lt.mu.Lock()
+	if lt.conflict(lr, lt.waiting) == nil {
+		lt.held = append(lt.held, lr)
+		lt.mu.Unlock()
+		return nil
+	}
+	lr.granted = make(chan struct{})
+	lt.waiting = append(lt.waiting, lr)
+	lt.mu.Unlock()
+
+	select {
+	case <-lr.granted:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	// The request may have been granted since the timeout fired.
+	select {
+	case <-lr.granted:
+		return nil
+	default:
+	}
+	var blocker *lockRequest
+	for i, o := range lt.waiting {
+		if o == lr {
+			blocker = lt.conflict(lr, lt.waiting[:i])
+			lt.waiting = append(lt.waiting[:i], lt.waiting[i+1:]...)
+			break
+		}
+	}
+	// Requests queued behind this one may now be grantable.
+	lt.grantWaiters()
+	if blocker == nil {
+		return nil
+	}
+	return blocker.txn
+}
+
+// release releases the locks held by the transaction with the given
+// ID, granting any waiting requests which are no longer blocked.
+func (lt *lockTable) release(txnID proto.Key) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	held := lt.held[:0]
+	for _, lr := range lt.held {
+		if !bytes.Equal(lr.txn.ID, txnID) {
+			held = append(held, lr)
+		}
+	}
+	for i := len(held); i < len(lt.held); i++ {
+		lt.held[i] = nil
+	}
+	if len(held) == len(lt.held) {
+		return
+	}
+	lt.held = held
+	lt.grantWaiters()
+}
+
+// grantWaiters grants, in order, the waiting requests which conflict
+// with neither a held lock nor a request still waiting ahead of them.
+// The caller must hold lt.mu.
+func (lt *lockTable) grantWaiters() {
+	var waiting []*lockRequest
+	for _, lr := range lt.waiting {
+		if lt.conflict(lr, waiting) == nil {
+			lt.held = append(lt.held, lr)
+			close(lr.granted)
+		} else {
+			waiting = append(waiting, lr)
+		}
+	}
+	lt.waiting = waiting
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+func lockTestTxn(id string) *proto.Transaction {
+	return &proto.Transaction{Name: id, ID: proto.Key(id)}
+}
+
+// acquireAsync acquires a lock in a goroutine, returning a channel
+// which receives the result of the acquisition.
+func acquireAsync(lt *lockTable, key, endKey proto.Key, txn *proto.Transaction, strength proto.LockStrength) <-chan *proto.Transaction {
+	ch := make(chan *proto.Transaction, 1)
+	go func() {
+		ch <- lt.acquire(key, endKey, txn, strength, 5*time.Second)
+	}()
+	return ch
+}
+
+// expectGranted verifies that the acquisition signaled on ch succeeds
+// within a second.
+func expectGranted(t *testing.T, ch <-chan *proto.Transaction) {
+	select {
+	case holder := <-ch:
+		if holder != nil {
+			t.Fatalf("expected lock to be granted; blocked on %s", holder.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected lock to be granted")
+	}
+}
+
+// expectWaiting verifies that the acquisition signaled on ch is still
+// waiting.
+func expectWaiting(t *testing.T, ch <-chan *proto.Transaction) {
+	select {
+	case <-ch:
+		t.Fatal("expected lock acquisition to wait")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// TestLockTableCompatibility verifies which locks conflict.
+func TestLockTableCompatibility(t *testing.T) {
+	a, b := lockTestTxn("a"), lockTestTxn("b")
+	testCases := []struct {
+		key1, endKey1 proto.Key
+		strength1     proto.LockStrength
+		txn2          *proto.Transaction
+		key2, endKey2 proto.Key
+		strength2     proto.LockStrength
+		expConflict   bool
+	}{
+		{proto.Key("a"), nil, proto.SHARED, b, proto.Key("a"), nil, proto.SHARED, false},
+		{proto.Key("a"), nil, proto.SHARED, b, proto.Key("a"), nil, proto.EXCLUSIVE, true},
+		{proto.Key("a"), nil, proto.EXCLUSIVE, b, proto.Key("a"), nil, proto.SHARED, true},
+		{proto.Key("a"), nil, proto.EXCLUSIVE, b, proto.Key("b"), nil, proto.EXCLUSIVE, false},
+		{proto.Key("a"), proto.Key("c"), proto.EXCLUSIVE, b, proto.Key("b"), nil, proto.SHARED, true},
+		{proto.Key("a"), proto.Key("c"), proto.EXCLUSIVE, b, proto.Key("c"), proto.Key("d"), proto.EXCLUSIVE, false},
+		// A transaction never conflicts with itself.
+		{proto.Key("a"), nil, proto.SHARED, a, proto.Key("a"), nil, proto.EXCLUSIVE, false},
+	}
+	for i, test := range testCases {
+		lt := newLockTable()
+		if holder := lt.acquire(test.key1, test.endKey1, a, test.strength1, time.Millisecond); holder != nil {
+			t.Fatalf("%d: unexpected conflict with %s", i, holder.ID)
+		}
+		holder := lt.acquire(test.key2, test.endKey2, test.txn2, test.strength2, time.Millisecond)
+		if conflict := holder != nil; conflict != test.expConflict {
+			t.Errorf("%d: expected conflict %t; got %t", i, test.expConflict, conflict)
+		}
+		if holder != nil && !bytes.Equal(holder.ID, a.ID) {
+			t.Errorf("%d: expected to be blocked on txn a; got %s", i, holder.ID)
+		}
+	}
+}
+
+// TestLockTableRelease verifies that releasing a transaction's locks
+// grants waiting requests.
+func TestLockTableRelease(t *testing.T) {
+	lt := newLockTable()
+	a, b := lockTestTxn("a"), lockTestTxn("b")
+	expectGranted(t, acquireAsync(lt, proto.Key("a"), proto.Key("c"), a, proto.EXCLUSIVE))
+	ch := acquireAsync(lt, proto.Key("b"), nil, b, proto.EXCLUSIVE)
+	expectWaiting(t, ch)
+	lt.release(b.ID)
+	expectWaiting(t, ch)
+	lt.release(a.ID)
+	expectGranted(t, ch)
+}
+
+// TestLockTableFairness verifies that a shared lock request queues
+// behind a waiting exclusive request, rather than starving it.
+func TestLockTableFairness(t *testing.T) {
+	lt := newLockTable()
+	a, b, c := lockTestTxn("a"), lockTestTxn("b"), lockTestTxn("c")
+	expectGranted(t, acquireAsync(lt, proto.Key("a"), nil, a, proto.SHARED))
+	exclusive := acquireAsync(lt, proto.Key("a"), nil, b, proto.EXCLUSIVE)
+	expectWaiting(t, exclusive)
+	shared := acquireAsync(lt, proto.Key("a"), nil, c, proto.SHARED)
+	expectWaiting(t, shared)
+
+	lt.release(a.ID)
+	expectGranted(t, exclusive)
+	expectWaiting(t, shared)
+	lt.release(b.ID)
+	expectGranted(t, shared)
+}
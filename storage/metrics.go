@@ -0,0 +1,96 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/util/metric"
+)
+
+// storeRateTimescale is the timescale over which store request rates
+// are averaged.
+const storeRateTimescale = 1 * time.Minute
+
+// storeMetrics holds the metrics tracked by a store and by its
+// underlying engine. All metrics are linked into the store's
+// registry.
+type storeMetrics struct {
+	registry *metric.Registry
+
+	requests              *metric.Counter   // Commands executed
+	requestErrors         *metric.Counter   // Commands which returned an error
+	requestsFailedFast    *metric.Counter   // Commands failed fast by tripped breakers
+	requestRate           *metric.Rate      // Commands per second
+	requestLatency        *metric.Histogram // Command latency in nanoseconds
+	raftProposals         *metric.Counter   // Read-write commands proposed to raft
+	intentsPushed         *metric.Counter   // Conflicting txns pushed successfully
+	intentsResolved       *metric.Counter   // Intents resolved after a push
+	txnsAbandoned         *metric.Counter   // Abandoned txns aborted by GC
+	versionsGCed          *metric.Counter   // MVCC versions deleted by GC
+	valuesLogicalBytes    *metric.Counter   // Bytes of values written compressed, before compression
+	valuesCompressedBytes *metric.Counter   // Bytes of values written compressed, after compression
+	splits                *metric.Counter   // Ranges split
+	replicasGCed          *metric.Counter   // Removed replicas deleted by GC
+	sessionsGCed          *metric.Counter   // Inactive client session records deleted by GC
+	breakerTrips          *metric.Counter   // Replica breakers tripped by unavailable ranges
+	quiescedRanges        *metric.Gauge     // Ranges which have quiesced
+	snapshotsActive       *metric.Gauge     // Outgoing snapshots in progress
+	snapshotsQueued       *metric.Gauge     // Outgoing snapshots awaiting a slot
+	snapshotBytes         *metric.Counter   // Bytes sent by outgoing snapshots
+	reservationsRefused   *metric.Counter   // Incoming snapshot reservations refused
+	reservedBytes         *metric.Gauge     // Bytes reserved for incoming snapshots
+	constraintViolations  *metric.Gauge     // Led ranges violating placement constraints
+
+	compactions *metric.Counter // Engine compactions
+	capacity    *metric.Gauge   // Engine capacity in bytes
+	available   *metric.Gauge   // Engine bytes available
+}
+
+func newStoreMetrics() *storeMetrics {
+	r := metric.NewRegistry()
+	return &storeMetrics{
+		registry:              r,
+		requests:              r.Counter("requests"),
+		requestErrors:         r.Counter("requests.errors"),
+		requestsFailedFast:    r.Counter("requests.failedfast"),
+		requestRate:           r.Rate("requests.rate", storeRateTimescale),
+		requestLatency:        r.Histogram("requests.latency"),
+		raftProposals:         r.Counter("raft.proposals"),
+		intentsPushed:         r.Counter("intents.pushed"),
+		intentsResolved:       r.Counter("intents.resolved"),
+		txnsAbandoned:         r.Counter("txns.abandoned"),
+		versionsGCed:          r.Counter("versions.gced"),
+		valuesLogicalBytes:    r.Counter("values.logical.bytes"),
+		valuesCompressedBytes: r.Counter("values.compressed.bytes"),
+		splits:                r.Counter("splits"),
+		replicasGCed:          r.Counter("replicas.gced"),
+		sessionsGCed:          r.Counter("sessions.gced"),
+		breakerTrips:          r.Counter("replicas.breaker.trips"),
+		quiescedRanges:        r.Gauge("ranges.quiesced"),
+		snapshotsActive:       r.Gauge("snapshots.active"),
+		snapshotsQueued:       r.Gauge("snapshots.queued"),
+		snapshotBytes:         r.Counter("snapshots.bytes"),
+		reservationsRefused:   r.Counter("snapshots.reservations.refused"),
+		reservedBytes:         r.Gauge("snapshots.reserved.bytes"),
+		constraintViolations:  r.Gauge("ranges.constraint.violations"),
+		compactions:           r.Counter("engine.compactions"),
+		capacity:              r.Gauge("engine.capacity"),
+		available:             r.Gauge("engine.available"),
+	}
+}
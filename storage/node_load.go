@@ -0,0 +1,181 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// NodeLoad describes the resource usage of a node. It's sampled
+// periodically and gossiped along with the descriptors of the node's
+// stores. Usage which can't be determined on the node's platform is
+// reported as zero.
+type NodeLoad struct {
+	CPU        float64 // Fraction of the machine's CPU time used by the process
+	Memory     float64 // Fraction of the machine's memory obtained by the process
+	DiskIOPS   float64 // Reads and writes per second across the machine's disks
+	Goroutines int     // Number of goroutines in the process
+}
+
+// LoadThresholds determine when a node is overloaded. A zero
+// threshold is ignored.
+type LoadThresholds struct {
+	CPU        float64
+	Memory     float64
+	DiskIOPS   float64
+	Goroutines int
+}
+
+// DefaultLoadThresholds consider a node overloaded once it uses 90% of
+// its machine's CPU or memory, or runs 100k goroutines. The IOPS a disk
+// sustains vary too widely by device for a useful default.
+var DefaultLoadThresholds = LoadThresholds{
+	CPU:        0.9,
+	Memory:     0.9,
+	Goroutines: 100000,
+}
+
+// Overloaded returns whether any resource usage of the load reaches
+// its threshold.
+func (l NodeLoad) Overloaded(t LoadThresholds) bool {
+	return (t.CPU > 0 && l.CPU >= t.CPU) ||
+		(t.Memory > 0 && l.Memory >= t.Memory) ||
+		(t.DiskIOPS > 0 && l.DiskIOPS >= t.DiskIOPS) ||
+		(t.Goroutines > 0 && l.Goroutines >= t.Goroutines)
+}
+
+// A LoadMonitor samples the resource usage of the node's process and
+// machine. CPU time and disk operations are measured as rates since
+// the previous sample; the first sample reports them as zero.
+type LoadMonitor struct {
+	procDir string // Root of the proc filesystem
+
+	mu          sync.Mutex
+	lastTime    time.Time     // Zero until sampled
+	lastCPU     time.Duration // CPU time used by the process at last sample
+	lastDiskOps int64         // Disk operations completed at last sample
+	lastDiskOK  bool          // Whether disk operations were read at last sample
+}
+
+// NewLoadMonitor returns a monitor reading machine statistics from
+// /proc, where available.
+func NewLoadMonitor() *LoadMonitor {
+	return &LoadMonitor{procDir: "/proc"}
+}
+
+// Sample returns the node's load as of now.
+func (m *LoadMonitor) Sample(now time.Time) NodeLoad {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	load := NodeLoad{Goroutines: runtime.NumGoroutine()}
+
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+	if total, err := m.readProcFile("meminfo", readMemTotal); err == nil && total > 0 {
+		load.Memory = float64(memStats.Sys) / float64(total)
+	}
+
+	var cpu time.Duration
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err == nil {
+		cpu = time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+	}
+	diskOps, diskErr := m.readProcFile("diskstats", readDiskOps)
+
+	if elapsed := now.Sub(m.lastTime).Seconds(); !m.lastTime.IsZero() && elapsed > 0 {
+		load.CPU = (cpu - m.lastCPU).Seconds() / elapsed / float64(runtime.NumCPU())
+		if diskErr == nil && m.lastDiskOK {
+			load.DiskIOPS = float64(diskOps-m.lastDiskOps) / elapsed
+		}
+	}
+	m.lastTime, m.lastCPU = now, cpu
+	m.lastDiskOps, m.lastDiskOK = diskOps, diskErr == nil
+	return load
+}
+
+// readProcFile parses the named file of the proc filesystem with
+// parse.
+func (m *LoadMonitor) readProcFile(name string, parse func(io.Reader) (int64, error)) (int64, error) {
+	f, err := os.Open(filepath.Join(m.procDir, name))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+// readMemTotal returns the bytes of memory of the machine described by
+// the contents of /proc/meminfo.
+func readMemTotal(r io.Reader) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// MemTotal:       16327412 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb << 10, err
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// readDiskOps returns the total reads and writes completed by the
+// disks described by the contents of /proc/diskstats. Partitions,
+// which are listed after their disks and named by extending them, are
+// skipped so as not to count operations twice, as are loop and RAM
+// devices.
+func readDiskOps(r io.Reader) (int64, error) {
+	var ops int64
+	var disks []string
+	scanner := bufio.NewScanner(r)
+scan:
+	for scanner.Scan() {
+		// major minor name reads merged sectors ms writes ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		for _, disk := range disks {
+			if strings.HasPrefix(name, disk) {
+				continue scan
+			}
+		}
+		disks = append(disks, name)
+		for _, i := range []int{3, 7} {
+			n, err := strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			ops += n
+		}
+	}
+	return ops, scanner.Err()
+}
@@ -0,0 +1,125 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNodeLoadOverloaded(t *testing.T) {
+	thresholds := LoadThresholds{CPU: 0.9, Memory: 0.8, DiskIOPS: 1000, Goroutines: 100}
+	testCases := []struct {
+		load     NodeLoad
+		expected bool
+	}{
+		{NodeLoad{}, false},
+		{NodeLoad{CPU: 0.89, Memory: 0.79, DiskIOPS: 999, Goroutines: 99}, false},
+		{NodeLoad{CPU: 0.9}, true},
+		{NodeLoad{Memory: 0.85}, true},
+		{NodeLoad{DiskIOPS: 2000}, true},
+		{NodeLoad{Goroutines: 100}, true},
+	}
+	for i, test := range testCases {
+		if overloaded := test.load.Overloaded(thresholds); overloaded != test.expected {
+			t.Errorf("%d: expected overloaded %t; got %t", i, test.expected, overloaded)
+		}
+	}
+	// Zero thresholds are ignored.
+	if (NodeLoad{CPU: 1, Memory: 1, DiskIOPS: 1e6, Goroutines: 1e6}).Overloaded(LoadThresholds{}) {
+		t.Error("expected no load to exceed zero thresholds")
+	}
+}
+
+func TestReadMemTotal(t *testing.T) {
+	meminfo := `MemTotal:       16327412 kB
+MemFree:         1234567 kB
+`
+	total, err := readMemTotal(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := int64(16327412) << 10; total != expected {
+		t.Errorf("expected %d; got %d", expected, total)
+	}
+}
+
+func TestReadDiskOps(t *testing.T) {
+	diskstats := `   7       0 loop0 100 0 200 10 0 0 0 0 0 10 10
+   8       0 sda 1000 10 20000 500 2000 20 40000 800 0 900 1300
+   8       1 sda1 900 10 18000 450 1900 20 38000 750 0 850 1200
+ 259       0 nvme0n1 300 0 6000 100 400 0 8000 200 0 250 300
+ 259       1 nvme0n1p1 300 0 6000 100 400 0 8000 200 0 250 300
+`
+	ops, err := readDiskOps(strings.NewReader(diskstats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reads and writes of sda and nvme0n1 only.
+	if expected := int64(1000 + 2000 + 300 + 400); ops != expected {
+		t.Errorf("expected %d; got %d", expected, ops)
+	}
+}
+
+// TestLoadMonitorSample verifies that disk operations are reported as
+// a rate since the previous sample and that memory usage is reported
+// relative to the machine's memory.
+func TestLoadMonitorSample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("meminfo", "MemTotal: 1 kB\n")
+	writeFile("diskstats", "8 0 sda 100 0 0 0 100 0 0 0 0 0 0\n")
+
+	m := &LoadMonitor{procDir: dir}
+	now := time.Unix(0, 0)
+	load := m.Sample(now)
+	if load.DiskIOPS != 0 || load.CPU != 0 {
+		t.Errorf("expected no rates on first sample; got %+v", load)
+	}
+	if load.Memory <= 1 {
+		t.Errorf("expected process memory to exceed 1 kB of machine memory; got %+v", load)
+	}
+	if load.Goroutines == 0 {
+		t.Errorf("expected goroutines to be counted; got %+v", load)
+	}
+
+	writeFile("diskstats", "8 0 sda 300 0 0 0 400 0 0 0 0 0 0\n")
+	now = now.Add(10 * time.Second)
+	if load = m.Sample(now); load.DiskIOPS != 50 {
+		t.Errorf("expected 50 disk IOPS; got %+v", load)
+	}
+
+	// Machine statistics which can't be read are reported as zero.
+	m = &LoadMonitor{procDir: filepath.Join(dir, "missing")}
+	m.Sample(now)
+	if load = m.Sample(now.Add(time.Second)); load.Memory != 0 || load.DiskIOPS != 0 {
+		t.Errorf("expected no memory or disk usage; got %+v", load)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A ProcedureContext supplies a procedure with access to the key
+// span named in an InternalExecute request.
+type ProcedureContext struct {
+	// MVCC reads and writes data within the command's batch. Writes
+	// are committed only if the procedure returns without error.
+	MVCC *engine.MVCC
+	// Key and EndKey bound the span the procedure may access. EndKey
+	// is empty if the request addresses a single key.
+	Key, EndKey proto.Key
+	// Timestamp is the timestamp at which to read and write.
+	Timestamp proto.Timestamp
+	// Txn is the transaction, if any, in which to read and write.
+	Txn *proto.Transaction
+}
+
+// A Procedure is a server-side function invoked via InternalExecute
+// which executes a read-modify-write against a key span within a
+// single range command. Procedures are executed independently by
+// every replica of the range, so they must be deterministic: their
+// effects may depend only on the supplied context and arguments.
+// The returned bytes are delivered to the client as the result.
+type Procedure func(ctx *ProcedureContext, args []byte) ([]byte, error)
+
+var procedures = struct {
+	sync.RWMutex
+	m map[string]Procedure
+}{m: map[string]Procedure{}}
+
+// RegisterProcedure makes a procedure available under the given name
+// to InternalExecute requests. Procedures are compiled into the
+// server and should be registered from an init function, so that
+// every node knows the same set of procedures. Registering a name
+// twice is a fatal error.
+func RegisterProcedure(name string, p Procedure) {
+	procedures.Lock()
+	defer procedures.Unlock()
+	if _, ok := procedures.m[name]; ok {
+		log.Fatalf("procedure %q already registered", name)
+	}
+	procedures.m[name] = p
+}
+
+// lookupProcedure returns the procedure registered under name.
+func lookupProcedure(name string) (Procedure, error) {
+	procedures.RLock()
+	defer procedures.RUnlock()
+	p, ok := procedures.m[name]
+	if !ok {
+		return nil, util.Errorf("procedure %q not registered", name)
+	}
+	return p, nil
+}
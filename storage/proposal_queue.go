@@ -0,0 +1,98 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// cmdHeap implements heap.Interface, ordering Raft commands by
+// descending priority and, among commands of equal priority, by
+// ascending sequence number so that they're proposed in FIFO order.
+type cmdHeap []*Cmd
+
+// Len, Less and Swap implement sort.Interface.
+func (h cmdHeap) Len() int { return len(h) }
+func (h cmdHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h cmdHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// Push appends an element to the slice.
+func (h *cmdHeap) Push(x interface{}) { *h = append(*h, x.(*Cmd)) }
+
+// Pop removes the last element of the slice.
+func (h *cmdHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// A proposalQueue buffers commands awaiting proposal to Raft. Commands
+// are dequeued in priority order so that low-priority bulk work
+// defers to interactive traffic when proposals back up.
+type proposalQueue struct {
+	sync.Mutex
+	cmds  cmdHeap
+	seq   int64
+	ready chan struct{} // Signaled when the queue becomes non-empty
+}
+
+// newProposalQueue returns a new, empty proposal queue.
+func newProposalQueue() *proposalQueue {
+	return &proposalQueue{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push adds the command to the queue using the supplied priority.
+func (pq *proposalQueue) push(cmd *Cmd, priority int32) {
+	pq.Lock()
+	pq.seq++
+	cmd.priority, cmd.seq = priority, pq.seq
+	heap.Push(&pq.cmds, cmd)
+	pq.Unlock()
+	select {
+	case pq.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes and returns the highest priority command in the queue,
+// or nil if the queue is empty.
+func (pq *proposalQueue) pop() *Cmd {
+	pq.Lock()
+	defer pq.Unlock()
+	if len(pq.cmds) == 0 {
+		return nil
+	}
+	return heap.Pop(&pq.cmds).(*Cmd)
+}
+
+// len returns the number of commands in the queue.
+func (pq *proposalQueue) len() int {
+	pq.Lock()
+	defer pq.Unlock()
+	return len(pq.cmds)
+}
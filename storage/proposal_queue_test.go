@@ -0,0 +1,48 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import "testing"
+
+// TestProposalQueueOrdering verifies that commands are popped in
+// descending priority order and FIFO among equal priorities.
+func TestProposalQueueOrdering(t *testing.T) {
+	pq := newProposalQueue()
+	if cmd := pq.pop(); cmd != nil {
+		t.Fatalf("expected empty queue; got %+v", cmd)
+	}
+	priorities := []int32{1, 5, 1, 10, 5}
+	cmds := make([]*Cmd, len(priorities))
+	for i, p := range priorities {
+		cmds[i] = &Cmd{}
+		pq.push(cmds[i], p)
+	}
+	select {
+	case <-pq.ready:
+	default:
+		t.Fatal("expected queue to be signaled ready")
+	}
+	for i, expIdx := range []int{3, 1, 4, 0, 2} {
+		if cmd := pq.pop(); cmd != cmds[expIdx] {
+			t.Errorf("%d: expected command %d; got %+v", i, expIdx, cmd)
+		}
+	}
+	if cmd := pq.pop(); cmd != nil {
+		t.Fatalf("expected empty queue; got %+v", cmd)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// RangeRaftStatus describes the replication state of a replica, for
+// troubleshooting unavailable ranges. The status covers the range's
+// local proposal and command state.
+//
+// TODO(agent): report the Raft term and leader, the commit and applied
+// indexes, the progress of each follower and pending snapshots once
+// ranges are replicated via multiraft.
+type RangeRaftStatus struct {
+	RaftID   int64           `json:"raft_id" yaml:"raft_id"`
+	RangeID  int64           `json:"range_id" yaml:"range_id"`
+	StartKey proto.Key       `json:"start_key" yaml:"start_key"`
+	EndKey   proto.Key       `json:"end_key" yaml:"end_key"`
+	Replicas []proto.Replica `json:"replicas"`
+	// Leader is set if this replica considers itself the leader.
+	Leader bool `json:"leader"`
+	// Lease is the leader lease last granted for the range, if any.
+	Lease *proto.Lease `json:"lease,omitempty" yaml:"lease,omitempty"`
+	// PendingProposals counts the commands awaiting proposal to Raft.
+	PendingProposals int `json:"pending_proposals" yaml:"pending_proposals"`
+	// InFlight counts the commands in the store's command queue.
+	InFlight  int  `json:"in_flight" yaml:"in_flight"`
+	Quiesced  bool `json:"quiesced"`
+	Splitting bool `json:"splitting"`
+	// Unavailable is set while the replica's breaker is tripped.
+	Unavailable bool `json:"unavailable"`
+}
+
+// StoreRaftStatus describes the replication state of a store's
+// replicas and its snapshots in progress.
+type StoreRaftStatus struct {
+	StoreID int32 `json:"store_id" yaml:"store_id"`
+	// SnapshotsActive and SnapshotsQueued count the outgoing snapshots
+	// in progress and awaiting a slot.
+	SnapshotsActive int `json:"snapshots_active" yaml:"snapshots_active"`
+	SnapshotsQueued int `json:"snapshots_queued" yaml:"snapshots_queued"`
+	// ReservedBytes is the space reserved for incoming snapshots.
+	ReservedBytes int64             `json:"reserved_bytes" yaml:"reserved_bytes"`
+	Ranges        []RangeRaftStatus `json:"ranges"`
+}
+
+// RaftStatus returns the replication state of the range's replica.
+func (r *Range) RaftStatus() RangeRaftStatus {
+	status := RangeRaftStatus{
+		RangeID:          r.RangeID,
+		Leader:           r.IsLeader(),
+		PendingProposals: r.raft.len(),
+		InFlight:         int(atomic.LoadInt32(&r.inFlight)),
+		Quiesced:         r.IsQuiesced(),
+		Splitting:        atomic.LoadInt32(&r.splitting) == 1,
+		Unavailable:      r.breaker.isTripped(),
+	}
+	if lease := r.LeaderLease(); lease != nil {
+		leaseCopy := *lease
+		status.Lease = &leaseCopy
+	}
+	r.RLock()
+	status.RaftID = r.Desc.RaftID
+	status.StartKey = r.Desc.StartKey
+	status.EndKey = r.Desc.EndKey
+	status.Replicas = append([]proto.Replica(nil), r.Desc.Replicas...)
+	r.RUnlock()
+	return status
+}
+
+// RaftStatus returns the replication state of the store's replicas, in
+// key order, and of its snapshots. If raftID is non-zero, only the
+// store's replica of that range, if any, is included.
+func (s *Store) RaftStatus(raftID int64) StoreRaftStatus {
+	s.mu.RLock()
+	ranges := append([]*Range(nil), s.rangesByKey...)
+	s.mu.RUnlock()
+	status := StoreRaftStatus{
+		StoreID:         s.StoreID(),
+		SnapshotsActive: int(s.metrics.snapshotsActive.Value()),
+		SnapshotsQueued: int(s.metrics.snapshotsQueued.Value()),
+		ReservedBytes:   s.reservations.reservedBytes(),
+	}
+	for _, rng := range ranges {
+		if raftID != 0 && rng.Desc.RaftID != raftID {
+			continue
+		}
+		status.Ranges = append(status.Ranges, rng.RaftStatus())
+	}
+	return status
+}
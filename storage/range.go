@@ -34,6 +34,7 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/fault"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -45,6 +46,7 @@ func init() {
 	gob.Register(&proto.PermConfig{})
 	gob.Register(&proto.ZoneConfig{})
 	gob.Register(proto.RangeDescriptor{})
+	gob.Register(RangeEvent{})
 	gob.Register(proto.Transaction{})
 }
 
@@ -55,6 +57,21 @@ const (
 	// transaction fails to be heartbeat within 2x the heartbeat interval,
 	// it may be aborted by conflicting txns.
 	DefaultHeartbeatInterval = 5 * time.Second
+	// txnLivenessThreshold is the time since a transaction's last
+	// heartbeat after which it's considered abandoned. Abandoned
+	// transactions may be aborted by any pusher and are aborted by the
+	// store's transaction GC.
+	txnLivenessThreshold = 2 * DefaultHeartbeatInterval
+	// txnGCInterval is the interval at which stores scan the ranges
+	// they lead for intents of abandoned transactions.
+	txnGCInterval = txnLivenessThreshold
+	// mvccGCInterval is the interval at which stores garbage collect
+	// versions which have fallen behind the GC TTL of their zone in
+	// the ranges they lead.
+	mvccGCInterval = 1 * time.Minute
+	// lockWaitTimeout is how long an AcquireLock request waits for
+	// conflicting locks before the transaction holding them is pushed.
+	lockWaitTimeout = DefaultHeartbeatInterval
 
 	// ttlClusterIDGossip is time-to-live for cluster ID. The cluster ID
 	// serves as the sentinel gossip key which informs a node whether or
@@ -63,6 +80,18 @@ const (
 	// continually re-gossipped. The replica which is the raft leader of
 	// the first range gossips it.
 	ttlClusterIDGossip = 30 * time.Second
+
+	// closedTimestampInterval is the interval at which the leader
+	// replica closes timestamps.
+	closedTimestampInterval = 1 * time.Second
+	// closedTimestampLag is how far behind the current time timestamps
+	// are closed. Writes at or below the closed timestamp have their
+	// timestamps pushed forward, so this should comfortably exceed the
+	// duration of most transactions.
+	closedTimestampLag = 5 * time.Second
+	// quiesceIdleTicks is the number of consecutive closed timestamp
+	// intervals without activity after which a range quiesces.
+	quiesceIdleTicks = 2
 )
 
 // configPrefixes describes administrative configuration maps
@@ -88,12 +117,16 @@ var tsCacheMethods = map[string]struct{}{
 	proto.Increment:             struct{}{},
 	proto.Scan:                  struct{}{},
 	proto.Delete:                struct{}{},
+	proto.ConditionalDelete:     struct{}{},
+	proto.CompareAndSwap:        struct{}{},
 	proto.DeleteRange:           struct{}{},
 	proto.AccumulateTS:          struct{}{},
 	proto.ReapQueue:             struct{}{},
 	proto.EnqueueUpdate:         struct{}{},
 	proto.EnqueueMessage:        struct{}{},
 	proto.InternalResolveIntent: struct{}{},
+	proto.InternalExecute:       struct{}{},
+	proto.InternalRefresh:       struct{}{},
 }
 
 // UsesTimestampCache returns true if the method affects or is
@@ -111,6 +144,10 @@ type Cmd struct {
 	Args   proto.Request
 	Reply  proto.Response
 	done   chan error // Used to signal waiting RPC handler
+
+	priority    int32             // Proposal priority; see proposalQueue
+	seq         int64             // Proposal sequence number; see proposalQueue
+	compression proto.Compression // Compression of values written by the command
 }
 
 // makeRangeKey returns a key addressing the range descriptor for the range
@@ -128,15 +165,35 @@ func makeRangeKey(startKey proto.Key) proto.Key {
 type Range struct {
 	RangeID   int64
 	Desc      *proto.RangeDescriptor
-	rm        RangeManager  // Makes some store methods available
-	raft      chan *Cmd     // Raft commands
-	splitting int32         // 1 if a split is underway
-	closer    chan struct{} // Channel for closing the range
-
-	sync.RWMutex                 // Protects cmdQ, tsCache & respCache (and Desc)
-	cmdQ         *CommandQueue   // Enforce at most one command is running per key(s)
+	rm        RangeManager   // Makes some store methods available
+	raft      *proposalQueue // Raft commands, ordered by priority
+	splitting int32          // 1 if a split is underway
+	closer    chan struct{}  // Channel for closing the range
+	active    int32          // 1 if a command was added since the last tick
+	quiesced  int32          // 1 if the range has stopped ticking
+	wake      chan struct{}  // Signals a quiesced range to resume ticking
+	inFlight  int32          // Commands in the store's command queue
+	// freshKeys is a span of keys known to have no values, which blind
+	// puts write without reading. Accessed only by read-write commands,
+	// which execute serially.
+	freshKeys *keySpan
+
+	sync.RWMutex                 // Protects tsCache, respCache, gcThreshold & lease (and Desc)
 	tsCache      *TimestampCache // Most recent timestamps for keys / key ranges
 	respCache    *ResponseCache  // Provides idempotence for retries
+	closedTS     proto.Timestamp // No writes will occur at or below this timestamp
+	gcThreshold  proto.Timestamp // Reads below this timestamp may miss GC'd versions
+	gcLoaded     bool            // True once gcThreshold is read from the engine
+	lease        *proto.Lease    // Leader lease last granted; nil if none
+	leaseLoaded  bool            // True once lease is read from the engine
+
+	load    *rangeLoad     // Request rates, latencies and read amplification
+	locks   *lockTable     // Unreplicated locks acquired via AcquireLock
+	breaker replicaBreaker // Fails read-write commands fast while unavailable
+
+	// writeWaiters are the scans waiting for writes to their spans,
+	// protected by the range's mutex. See waitForWrite.
+	writeWaiters map[*writeWaiter]struct{}
 }
 
 // NewRange initializes the range using the given metadata.
@@ -145,29 +202,33 @@ func NewRange(rangeID int64, desc *proto.RangeDescriptor, rm RangeManager) *Rang
 		RangeID:   rangeID,
 		Desc:      desc,
 		rm:        rm,
-		raft:      make(chan *Cmd, 10), // TODO(spencer): remove
+		raft:      newProposalQueue(), // TODO(spencer): remove
 		closer:    make(chan struct{}),
-		cmdQ:      NewCommandQueue(),
+		wake:      make(chan struct{}, 1),
 		tsCache:   NewTimestampCache(rm.Clock()),
 		respCache: NewResponseCache(rangeID, rm.Engine()),
+		load:      newRangeLoad(),
+		locks:     newLockTable(),
 	}
 	return r
 }
 
 // Start begins gossiping and starts the raft command processing
-// loop in a goroutine.
+// loop as a worker of the range manager's stopper.
 func (r *Range) Start() {
 	r.maybeGossipClusterID()
+	r.maybeGossipClusterVersion()
 	r.maybeGossipFirstRange()
 	r.maybeGossipConfigs()
-	go r.processRaft() // TODO(spencer): remove
+	r.rm.Stopper().RunWorker(r.processRaft) // TODO(spencer): remove
 	// Only start gossiping if this range is the first range.
 	if r.IsFirstRange() {
-		go r.startGossip()
+		r.rm.Stopper().RunWorker(r.startGossip)
 	}
 }
 
-// Stop ends the log processing loop.
+// Stop ends the log processing loop. Ranges are also stopped when
+// the range manager's stopper is stopped.
 func (r *Range) Stop() {
 	close(r.closer)
 }
@@ -190,20 +251,69 @@ func (r *Range) Destroy() error {
 	if err := engine.ClearRangeStats(r.rm.Engine(), r.RangeID); err != nil {
 		return util.Errorf("unable to clear range stats for range %d: %s", r.RangeID, err)
 	}
+	if err := r.rm.Engine().Clear(engine.MVCCEncodeKey(engine.MakeRangeGCThresholdKey(r.RangeID))); err != nil {
+		return util.Errorf("unable to clear GC threshold for range %d: %s", r.RangeID, err)
+	}
+	if err := r.rm.Engine().Clear(engine.MVCCEncodeKey(engine.MakeRangeLeaderLeaseKey(r.RangeID))); err != nil {
+		return util.Errorf("unable to clear leader lease for range %d: %s", r.RangeID, err)
+	}
 	if err := r.rm.Engine().Clear(engine.MVCCEncodeKey(makeRangeKey(r.Desc.StartKey))); err != nil {
 		return util.Errorf("unable to clear metadata for range %d: %s", r.RangeID, err)
 	}
 	return nil
 }
 
+// CheckInvariants verifies the consistency of the range's local data
+// with its descriptor and MVCC data, returning a description of each
+// violation found. See engine.CheckRangeInvariants.
+func (r *Range) CheckInvariants() ([]string, error) {
+	r.RLock()
+	desc := *r.Desc
+	r.RUnlock()
+	return engine.CheckRangeInvariants(r.rm.Engine(), r.RangeID, &desc)
+}
+
+// GCThreshold returns the timestamp below which versions of the
+// range's keys may have been garbage collected. The threshold is
+// read from the engine on first use.
+func (r *Range) GCThreshold() proto.Timestamp {
+	r.RLock()
+	threshold, loaded := r.gcThreshold, r.gcLoaded
+	r.RUnlock()
+	if loaded {
+		return threshold
+	}
+	r.Lock()
+	defer r.Unlock()
+	if !r.gcLoaded {
+		threshold, err := engine.GetRangeGCThreshold(r.rm.Engine(), r.RangeID)
+		if err != nil {
+			log.Errorf("unable to read GC threshold of range %d: %s", r.RangeID, err)
+			return r.gcThreshold
+		}
+		r.gcThreshold, r.gcLoaded = threshold, true
+	}
+	return r.gcThreshold
+}
+
 // IsFirstRange returns true if this is the first range.
 func (r *Range) IsFirstRange() bool {
 	return bytes.Equal(r.Desc.StartKey, engine.KeyMin)
 }
 
 // IsLeader returns true if this range replica is the raft leader.
-// TODO(spencer): this is always true for now.
+// A replica isn't the leader while another replica holds an unexpired
+// leader lease (see LeaderLease).
+// TODO(spencer): this is otherwise always true for now, except when a
+// loss of leadership is injected for testing.
 func (r *Range) IsLeader() bool {
+	if lost, _ := fault.Hit(fault.RangeLeadership, r.RangeID); lost {
+		return false
+	}
+	if lease := r.LeaderLease(); lease != nil && lease.Replica.StoreID != r.rm.StoreID() &&
+		r.rm.Clock().Now().Less(lease.Expiration) {
+		return false
+	}
 	return true
 }
 
@@ -236,9 +346,26 @@ func (r *Range) ContainsKeyRange(start, end proto.Key) bool {
 // command queue. If wait is false, read-write commands are added to
 // Raft without waiting for their completion.
 func (r *Range) AddCmd(method string, args proto.Request, reply proto.Response, wait bool) error {
-	if !r.IsLeader() {
-		// TODO(spencer): when we happen to know the leader, fill it in here via replica.
-		err := &proto.NotLeaderError{}
+	r.markActive()
+	// Snapshots may be served by any replica, so that the load of
+	// catching up new replicas doesn't fall on the leader alone.
+	if method == proto.InternalSnapshotCopy {
+		return r.addSnapshotCmd(args, reply)
+	}
+	// Inconsistent reads are served by any replica, leader or not.
+	bound := args.Header().EffectiveReadBound(method)
+	if bound == proto.INCONSISTENT {
+		return r.addInconsistentReadCmd(method, args, reply)
+	}
+	// A range which has lost a quorum of replicas has no leader, so
+	// recovery may be carried out by any surviving replica.
+	if !r.IsLeader() && method != proto.AdminRecoverReplicas {
+		// Non-transactional reads with a staleness bound may be served
+		// by followers.
+		if bound == proto.BOUNDED_STALENESS {
+			return r.addFollowerReadCmd(method, args, reply)
+		}
+		err := r.newNotLeaderError()
 		reply.Header().SetGoError(err)
 		return err
 	}
@@ -246,28 +373,54 @@ func (r *Range) AddCmd(method string, args proto.Request, reply proto.Response,
 	// Differentiate between read-only and read-write.
 	if proto.IsAdmin(method) {
 		return r.addAdminCmd(method, args, reply)
-	} else if proto.IsReadOnly(method) {
-		return r.addReadOnlyCmd(method, args, reply)
 	}
-	return r.addReadWriteCmd(method, args, reply, wait)
+	if method == proto.AcquireLock {
+		return r.addLockCmd(args.(*proto.AcquireLockRequest), reply.(*proto.AcquireLockResponse))
+	}
+	r.load.record(args.Header().Key, args.Header().EndKey)
+	start := time.Now()
+	if proto.IsReadOnly(method) {
+		err := r.addReadOnlyCmd(method, args, reply)
+		r.load.recordLatency(true, time.Since(start))
+		return err
+	}
+	err := r.addReadWriteCmd(method, args, reply, wait)
+	// The latency of a command which isn't waited on isn't meaningful.
+	if wait {
+		r.load.recordLatency(false, time.Since(start))
+	}
+	return err
+}
+
+// LoadStats returns a snapshot of the request rate, latencies and
+// engine read amplification of the range.
+func (r *Range) LoadStats() RangeLoadStats {
+	return r.load.stats()
 }
 
 // beginCmd waits for any overlapping, already-executing commands via
-// the command queue and adds itself to the queue to gate follow-on
-// commands which overlap its key range. This method will block if
-// there are any overlapping commands already in the queue. Returns
-// the command queue insertion key, to be supplied to subsequent
-// invocation of cmdQ.Remove().
-func (r *Range) beginCmd(start, end proto.Key, readOnly bool) interface{} {
-	r.Lock()
+// the store's command queue and adds itself to the queue to gate
+// follow-on commands which overlap the key spans the command
+// declares. This method will block if there are any overlapping
+// commands already in the queue. Returns the command queue insertion
+// key, to be supplied to subsequent invocation of endCmd(). Commands
+// with a higher priority are scheduled ahead of waiting, overlapping
+// commands of lower priority.
+func (r *Range) beginCmd(method string, args proto.Request) interface{} {
+	atomic.AddInt32(&r.inFlight, 1)
 	var wg sync.WaitGroup
-	r.cmdQ.GetWait(start, end, readOnly, &wg)
-	cmdKey := r.cmdQ.Add(start, end, readOnly)
-	r.Unlock()
+	cmdKey := r.rm.CommandQueue().Add(declareKeys(method, args), args.Header().GetUserPriority(), &wg)
 	wg.Wait()
 	return cmdKey
 }
 
+// endCmd removes the command from the store's command queue,
+// signaling any commands waiting on it.
+func (r *Range) endCmd(cmdKey interface{}) {
+	r.rm.CommandQueue().Remove(cmdKey)
+	atomic.AddInt32(&r.inFlight, -1)
+}
+
 // addAdminCmd executes the command directly. There is no interaction
 // with the command queue or the timestamp cache, as admin commands
 // are not meant to consistently access or modify the underlying data.
@@ -275,21 +428,56 @@ func (r *Range) addAdminCmd(method string, args proto.Request, reply proto.Respo
 	switch method {
 	case proto.AdminSplit:
 		r.AdminSplit(args.(*proto.AdminSplitRequest), reply.(*proto.AdminSplitResponse))
+	case proto.AdminRecoverReplicas:
+		r.AdminRecoverReplicas(args.(*proto.AdminRecoverReplicasRequest), reply.(*proto.AdminRecoverReplicasResponse))
+	case proto.AdminTransferLease:
+		r.AdminTransferLease(args.(*proto.AdminTransferLeaseRequest), reply.(*proto.AdminTransferLeaseResponse))
 	default:
 		return util.Errorf("unrecognized admin command type: %s", method)
 	}
 	return reply.Header().GoError()
 }
 
+// addLockCmd acquires a lock in the range's lock table on behalf of
+// the request's transaction. Locks live only in the leader's memory,
+// so the command bypasses Raft. If the lock can't be acquired within
+// lockWaitTimeout, a WriteIntentError names the transaction holding
+// the conflicting lock, so that the store pushes it.
+func (r *Range) addLockCmd(args *proto.AcquireLockRequest, reply *proto.AcquireLockResponse) error {
+	if args.Txn == nil {
+		err := util.Errorf("cannot acquire lock outside of a transaction")
+		reply.SetGoError(err)
+		return err
+	}
+	if !r.ContainsKeyRange(args.Key, args.EndKey) {
+		err := proto.NewRangeKeyMismatchError(args.Key, args.EndKey, r.Desc)
+		reply.SetGoError(err)
+		return err
+	}
+	if holder := r.locks.acquire(args.Key, args.EndKey, args.Txn, args.Strength, lockWaitTimeout); holder != nil {
+		err := proto.NewWriteIntentError(args.Key, holder, false)
+		reply.SetGoError(err)
+		return err
+	}
+	reply.Timestamp = args.Timestamp
+	return nil
+}
+
 // addReadOnlyCmd updates the read timestamp cache and waits for any
 // overlapping writes currently processing through Raft ahead of us to
-// clear via the read queue.
+// clear via the read queue. A non-transactional scan with a wait
+// timeout first waits for a write to its span, then reads at the
+// current time.
 func (r *Range) addReadOnlyCmd(method string, args proto.Request, reply proto.Response) error {
 	header := args.Header()
+	if scanArgs, ok := args.(*proto.ScanRequest); ok && scanArgs.WaitTimeout > 0 && header.Txn == nil {
+		r.waitForWrite(header.Key, header.EndKey, scanArgs.WaitTimestamp, time.Duration(scanArgs.WaitTimeout))
+		header.Timestamp = r.rm.Clock().Now()
+	}
 
 	// Add the read to the command queue to gate subsequent
 	// overlapping, commands until this command completes.
-	cmdKey := r.beginCmd(header.Key, header.EndKey, true)
+	cmdKey := r.beginCmd(method, args)
 
 	// It's possible that arbitrary delays (e.g. major GC, VM
 	// de-prioritization, etc.) could cause the execution of this read
@@ -307,22 +495,107 @@ func (r *Range) addReadOnlyCmd(method string, args proto.Request, reply proto.Re
 	// for the active leader and leadership changes force the
 	// read-timestamp-cache to reset its low water mark.
 	if !r.IsLeader() {
-		// TODO(spencer): when we happen to know the leader, fill it in here via replica.
-		return &proto.NotLeaderError{}
+		return r.newNotLeaderError()
 	}
-	err := r.executeCmd(method, args, reply)
+	err := r.executeCmd(method, args, reply, proto.UNCOMPRESSED)
 
 	// Only update the timestamp cache if the command succeeded.
 	r.Lock()
 	if err == nil && UsesTimestampCache(method) {
 		r.tsCache.Add(header.Key, header.EndKey, header.Timestamp, header.Txn.MD5(), true /* readOnly */)
 	}
-	r.cmdQ.Remove(cmdKey)
 	r.Unlock()
+	r.endCmd(cmdKey)
+
+	return err
+}
+
+// addFollowerReadCmd executes a bounded staleness read on a replica
+// which is not the leader. The read is performed at the range's
+// closed timestamp, provided it lies within the staleness bound;
+// otherwise, a NotLeaderError directs the client to the leader. As no
+// writes may occur at or below the closed timestamp, the timestamp
+// cache is not updated.
+func (r *Range) addFollowerReadCmd(method string, args proto.Request, reply proto.Response) error {
+	header := args.Header()
+	ts, ok := r.followerReadTimestamp(r.rm.Clock().Now(), header.MaxStaleness)
+	if !ok {
+		err := r.newNotLeaderError()
+		reply.Header().SetGoError(err)
+		return err
+	}
+	header.Timestamp = ts
+
+	// Wait for any overlapping writes which are still being applied.
+	cmdKey := r.beginCmd(method, args)
+	err := r.executeCmd(method, args, reply, proto.UNCOMPRESSED)
+	r.endCmd(cmdKey)
+	return err
+}
+
+// addInconsistentReadCmd executes a read on any replica, leader or
+// not, from the replica's current state. The read skips the intents
+// of pending transactions and neither consults nor updates the
+// timestamp cache, so it may miss writes which have been committed
+// but not yet applied by this replica.
+func (r *Range) addInconsistentReadCmd(method string, args proto.Request, reply proto.Response) error {
+	// Wait for any overlapping writes which are still being applied.
+	cmdKey := r.beginCmd(method, args)
+	err := r.executeCmd(method, args, reply, proto.UNCOMPRESSED)
+	r.endCmd(cmdKey)
+	return err
+}
 
+// addSnapshotCmd executes a snapshot copy on any replica, leader or
+// not. Snapshots are read from the engine directly, without regard
+// to transaction timestamps, so the timestamp cache isn't consulted
+// or updated; the command only waits for any overlapping writes which
+// are still being applied.
+func (r *Range) addSnapshotCmd(args proto.Request, reply proto.Response) error {
+	cmdKey := r.beginCmd(proto.InternalSnapshotCopy, args)
+	err := r.executeCmd(proto.InternalSnapshotCopy, args, reply, proto.UNCOMPRESSED)
+	r.endCmd(cmdKey)
 	return err
 }
 
+// followerReadTimestamp returns the closed timestamp of the range and
+// true if it's no more than maxStaleness nanoseconds behind now.
+func (r *Range) followerReadTimestamp(now proto.Timestamp, maxStaleness int64) (proto.Timestamp, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	if r.closedTS.Less(now.Add(-maxStaleness, 0)) {
+		return proto.Timestamp{}, false
+	}
+	return r.closedTS, true
+}
+
+// closeTimestamp guarantees that no subsequent writes will occur at
+// or below the supplied timestamp by ratcheting the low water mark of
+// the timestamp cache, and records it as the range's closed
+// timestamp. Only the leader closes timestamps; multiraft carries
+// them to followers on heartbeats (see MultiRaft.CloseTimestamp).
+func (r *Range) closeTimestamp(ts proto.Timestamp) {
+	r.Lock()
+	defer r.Unlock()
+	r.tsCache.SetLowWater(ts)
+	if r.closedTS.Less(ts) {
+		r.closedTS = ts
+	}
+}
+
+// observeClosedTimestamp records a timestamp closed by the range's
+// leader on a follower, which serves bounded staleness reads at it.
+// multiraft reports the timestamp with an EventClosedTimestamp once
+// the follower has applied every command proposed before it was
+// closed, so no write at or below it remains to be applied.
+func (r *Range) observeClosedTimestamp(ts proto.Timestamp) {
+	r.Lock()
+	defer r.Unlock()
+	if r.closedTS.Less(ts) {
+		r.closedTS = ts
+	}
+}
+
 // addReadWriteCmd first consults the response cache to determine whether
 // this command has already been sent to the range. If a response is
 // found, it's returned immediately and not submitted to raft. Next,
@@ -342,6 +615,12 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 		if ok { // this is a replay! extract error for return
 			return reply.Header().GoError()
 		}
+		// A stale replay of a command already acknowledged by its client
+		// session must not be executed again.
+		if _, ok := err.(*proto.CommandReplayError); ok {
+			reply.Header().SetGoError(err)
+			return err
+		}
 		// In this case there was an error reading from the response
 		// cache. Instead of failing the request just because we can't
 		// decode the reply in the response cache, we proceed as though
@@ -349,12 +628,19 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 		log.Errorf("unable to read result for %+v from the response cache: %s", args, err)
 	}
 
+	// Fail fast if the range is unavailable, rather than queueing
+	// behind commands which aren't being applied.
+	if err := r.checkBreaker(); err != nil {
+		reply.Header().SetGoError(err)
+		return err
+	}
+
 	// Add the write to the command queue to gate subsequent overlapping
 	// commands until this command completes. Note that this must be
 	// done before getting the max timestamp for the key(s), as
 	// timestamp cache is only updated after preceding commands have
 	// been run to successful completion.
-	cmdKey := r.beginCmd(header.Key, header.EndKey, false)
+	cmdKey := r.beginCmd(method, args)
 
 	// Two important invariants of Cockroach: 1) encountering a more
 	// recently written value means transaction restart. 2) values must
@@ -374,7 +660,7 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 		// write; afterall, the cause of the higher timestamp may be an
 		// intent we can push.
 		if !wTS.Less(header.Timestamp) && header.Txn != nil {
-			err := &proto.WriteTooOldError{Timestamp: header.Timestamp, ExistingTimestamp: wTS}
+			err := proto.NewWriteTooOldError(header.Key, header.Timestamp, wTS)
 			reply.Header().SetGoError(err)
 		} else if !wTS.Less(header.Timestamp) || !rTS.Less(header.Timestamp) {
 			// Otherwise, make sure we advance the request's timestamp.
@@ -397,13 +683,16 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 		Args:   args,
 		Reply:  reply,
 		done:   make(chan error, 1),
+		// The proposer chooses the compression so that every replica
+		// encodes the command's values identically.
+		compression: r.compression(),
 	}
-	r.raft <- cmd
+	r.raft.push(cmd, header.GetUserPriority())
 
 	// Create a completion func for mandatory cleanups which we either
 	// run synchronously if we're waiting or in a goroutine otherwise.
 	completionFunc := func() error {
-		err := <-cmd.done
+		err := r.awaitCmd(cmd)
 
 		// As for reads, update timestamp cache with the timestamp
 		// of this write on success. This ensures a strictly higher
@@ -411,9 +700,10 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 		r.Lock()
 		if err == nil && UsesTimestampCache(method) {
 			r.tsCache.Add(header.Key, header.EndKey, header.Timestamp, txnMD5, false /* !readOnly */)
+			r.notifyWriteWaitersLocked(header.Key, header.EndKey)
 		}
-		r.cmdQ.Remove(cmdKey)
 		r.Unlock()
+		r.endCmd(cmdKey)
 
 		// If the original client didn't wait (e.g. resolve write intent),
 		// log execution errors so they're surfaced somewhere.
@@ -426,17 +716,32 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	if wait {
 		return completionFunc()
 	}
-	go completionFunc()
+	// Run the completion as a stopper task so that stopping waits on
+	// it. If the stopper is already draining, complete synchronously.
+	if !r.rm.Stopper().RunAsyncTask(func() { completionFunc() }) {
+		return completionFunc()
+	}
 	return nil
 }
 
 // processRaft processes read/write commands, sending them to the Raft
 // consensus algorithm. This method processes indefinitely or until
-// Range.Stop() is invoked.
+// Range.Stop() is invoked or the stopper is signaled. Pending commands
+// are proposed in priority order. The leader additionally closes
+// timestamps every closedTimestampInterval.
+//
+// A range which has been idle for quiesceIdleTicks intervals quiesces:
+// it stops ticking and is registered with the range manager, which
+// closes timestamps on behalf of all quiesced ranges from a single
+// node-level heartbeat. The next command wakes the range.
 //
 // TODO(spencer): this is pretty temporary. Just executing commands
 //   immediately until Raft is in place.
 //
+// TODO(agent): once commands are proposed through Raft, coalesce
+//   small writes which accumulate in the queue while a proposal is in
+//   flight into a single Raft entry, demultiplexed when applied.
+//
 // TODO(bdarnell): when Raft elects this range replica as the leader,
 //   we need to be careful to do the following before the range is
 //   allowed to believe it's the leader and begin to accept writes and
@@ -454,16 +759,142 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 //   and be able to access the new leader's state machine BEFORE
 //   the overlapping writes are applied.
 func (r *Range) processRaft() {
+	ticker := time.NewTicker(closedTimestampInterval)
+	tickC := ticker.C
+	idleTicks := 0
+	defer func() {
+		ticker.Stop()
+		if tickC == nil {
+			r.rm.QuiesceRange(r, false)
+		}
+	}()
 	for {
 		select {
-		case cmd := <-r.raft:
-			cmd.done <- r.executeCmd(cmd.Method, cmd.Args, cmd.Reply)
+		case <-r.raft.ready:
+			for cmd := r.raft.pop(); cmd != nil; cmd = r.raft.pop() {
+				r.applyCmd(cmd)
+			}
+		case <-tickC:
+			if r.IsLeader() {
+				r.closeTimestamp(r.rm.Clock().Now().Add(-closedTimestampLag.Nanoseconds(), 0))
+			}
+			if r.isIdle() {
+				idleTicks++
+			} else {
+				idleTicks = 0
+			}
+			if idleTicks >= quiesceIdleTicks && r.quiesce() {
+				ticker.Stop()
+				tickC = nil
+				r.rm.QuiesceRange(r, true)
+			}
+		case <-r.wake:
+			if tickC == nil {
+				r.rm.QuiesceRange(r, false)
+				ticker = time.NewTicker(closedTimestampInterval)
+				tickC = ticker.C
+				idleTicks = 0
+			}
 		case <-r.closer:
 			return
+		case <-r.rm.Stopper().ShouldStop():
+			return
 		}
 	}
 }
 
+// markActive records activity on the range, waking it if quiesced.
+func (r *Range) markActive() {
+	atomic.StoreInt32(&r.active, 1)
+	if atomic.CompareAndSwapInt32(&r.quiesced, 1, 0) {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// isIdle returns whether the range has had no commands added since
+// the last call and none remain in flight. Until replication is in
+// place, in-flight commands stand in for followers lagging behind the
+// leader's log: a range which quiesced with commands outstanding
+// would never see them through to application on every replica.
+func (r *Range) isIdle() bool {
+	if atomic.SwapInt32(&r.active, 0) == 1 || r.raft.len() > 0 {
+		return false
+	}
+	return atomic.LoadInt32(&r.inFlight) == 0
+}
+
+// quiesce marks the range quiesced, returning false if a command was
+// added concurrently, in which case the range remains awake.
+func (r *Range) quiesce() bool {
+	atomic.StoreInt32(&r.quiesced, 1)
+	if atomic.LoadInt32(&r.active) == 1 {
+		atomic.StoreInt32(&r.quiesced, 0)
+		return false
+	}
+	return true
+}
+
+// IsQuiesced returns whether the range has quiesced.
+func (r *Range) IsQuiesced() bool {
+	return atomic.LoadInt32(&r.quiesced) == 1
+}
+
+// applyCmd applies a command, returning its result via its done
+// channel. Commands carrying values whose checksums don't match their
+// contents are rejected without being executed. The check depends
+// only on the command, so every replica rejects the same commands.
+func (r *Range) applyCmd(cmd *Cmd) {
+	if err := verifyValueChecksums(cmd.Args); err != nil {
+		log.Errorf("range %d: rejecting %s command: %s", r.RangeID, cmd.Method, err)
+		cmd.Reply.Header().SetGoError(err)
+		cmd.done <- err
+		return
+	}
+	cmd.done <- r.executeCmd(cmd.Method, cmd.Args, cmd.Reply, cmd.compression)
+}
+
+// verifyValueChecksums verifies the checksums of the values carried by
+// a command, which are set by clients via Value.InitChecksum. Values
+// without checksums aren't verified.
+func verifyValueChecksums(args proto.Request) error {
+	switch t := args.(type) {
+	case *proto.PutRequest:
+		return t.Value.Verify(t.Key)
+	case *proto.ConditionalPutRequest:
+		if err := t.Value.Verify(t.Key); err != nil {
+			return err
+		}
+		if t.ExpValue != nil {
+			return t.ExpValue.Verify(t.Key)
+		}
+	case *proto.ConditionalDeleteRequest:
+		if t.ExpValue != nil {
+			return t.ExpValue.Verify(t.Key)
+		}
+	case *proto.CompareAndSwapRequest:
+		for _, c := range t.Conditions {
+			if c.ExpValue != nil {
+				if err := c.ExpValue.Verify(c.Key); err != nil {
+					return err
+				}
+			}
+		}
+		for _, w := range t.Writes {
+			if w.Value != nil {
+				if err := w.Value.Verify(w.Key); err != nil {
+					return err
+				}
+			}
+		}
+	case *proto.EnqueueMessageRequest:
+		return t.Msg.Verify(t.Key)
+	}
+	return nil
+}
+
 // startGossip periodically gossips the cluster ID if it's the
 // first range and the raft leader.
 func (r *Range) startGossip() {
@@ -472,9 +903,12 @@ func (r *Range) startGossip() {
 		select {
 		case <-ticker.C:
 			r.maybeGossipClusterID()
+			r.maybeGossipClusterVersion()
 			r.maybeGossipFirstRange()
 		case <-r.closer:
 			return
+		case <-r.rm.Stopper().ShouldStop():
+			return
 		}
 	}
 }
@@ -489,6 +923,21 @@ func (r *Range) maybeGossipClusterID() {
 	}
 }
 
+// maybeGossipClusterVersion gossips the active cluster version if
+// this range contains the cluster version key and is the raft leader.
+func (r *Range) maybeGossipClusterVersion() {
+	if r.rm.Gossip() != nil && r.ContainsKey(engine.KeyClusterVersion) && r.IsLeader() {
+		version, err := engine.GetClusterVersion(r.rm.Engine())
+		if err != nil {
+			log.Errorf("failed to read cluster version: %s", err)
+			return
+		}
+		if err := r.rm.Gossip().AddInfo(gossip.KeyClusterVersion, int64(version), ttlClusterIDGossip); err != nil {
+			log.Errorf("failed to gossip cluster version %d: %s", version, err)
+		}
+	}
+}
+
 // maybeGossipFirstRange gossips the range locations if this range is
 // the start of the key space and the raft leader.
 func (r *Range) maybeGossipFirstRange() {
@@ -563,36 +1012,74 @@ func (r *Range) maybeUpdateGossipConfigs(key proto.Key) {
 	}
 }
 
-// shouldSplit returns whether the current size of the range exceeds
-// the max size specified in the zone config.
-func (r *Range) shouldSplit() bool {
+// zoneConfig returns the zone config for the zone containing this
+// range's start key, or false if this replica isn't the leader or the
+// config isn't available via gossip.
+func (r *Range) zoneConfig() (*proto.ZoneConfig, bool) {
 	// If not the leader or gossip is not enabled, ignore.
 	if !r.IsLeader() || r.rm.Gossip() == nil {
-		return false
+		return nil, false
 	}
 
 	// Fetch the zone config for the zone containing this range's start key.
 	zoneMap, err := r.rm.Gossip().GetInfo(gossip.KeyConfigZone)
 	if err != nil || zoneMap == nil {
 		log.Errorf("unable to fetch zone config from gossip: %s", err)
-		return false
+		return nil, false
 	}
 	prefixConfig := zoneMap.(PrefixConfigMap).MatchByPrefix(r.Desc.StartKey)
-	zone := prefixConfig.Config.(*proto.ZoneConfig)
+	return prefixConfig.Config.(*proto.ZoneConfig), true
+}
 
-	// Fetch the current size of this range in total bytes.
-	keyBytes, err := engine.GetRangeStat(r.rm.Engine(), r.RangeID, engine.StatKeyBytes)
+// compression returns the compression applied to values written to
+// the range, as specified by the zone config. Values are written
+// uncompressed if the zone config isn't available.
+func (r *Range) compression() proto.Compression {
+	zone, ok := r.zoneConfig()
+	if !ok {
+		return proto.UNCOMPRESSED
+	}
+	c, err := engine.ParseCompression(zone.Compression)
 	if err != nil {
-		log.Errorf("unable to fetch key bytes for range %d: %s", r.RangeID, err)
+		log.Errorf("range %d: %s", r.RangeID, err)
+	}
+	return c
+}
+
+// shouldSplit returns whether the current size of the range exceeds
+// the max size specified in the zone config, or whether its request
+// rate exceeds the zone's max QPS.
+func (r *Range) shouldSplit() bool {
+	return r.shouldSplitBySize() || r.shouldSplitByLoad()
+}
+
+// shouldSplitBySize returns whether the current size of the range
+// exceeds the max size specified in the zone config.
+func (r *Range) shouldSplitBySize() bool {
+	zone, ok := r.zoneConfig()
+	if !ok {
 		return false
 	}
-	valBytes, err := engine.GetRangeStat(r.rm.Engine(), r.RangeID, engine.StatValBytes)
+
+	// Fetch the current size of this range in total bytes.
+	ms, err := engine.GetRangeMVCCStats(r.rm.Engine(), r.RangeID)
 	if err != nil {
-		log.Errorf("unable to fetch value bytes for range %d: %s", r.RangeID, err)
+		log.Errorf("unable to fetch stats for range %d: %s", r.RangeID, err)
 		return false
 	}
 
-	return keyBytes+valBytes > zone.RangeMaxBytes
+	return ms.KeyBytes+ms.ValBytes > zone.RangeMaxBytes
+}
+
+// shouldSplitByLoad returns whether the request rate of the range
+// exceeds the max QPS specified in the zone config. A zero max QPS
+// disables load-based splitting.
+func (r *Range) shouldSplitByLoad() bool {
+	zone, ok := r.zoneConfig()
+	if !ok || zone.RangeMaxQPS <= 0 {
+		return false
+	}
+	return r.load.QPS() > float64(zone.RangeMaxQPS)
 }
 
 // maybeSplit initiates an asynchronous split via AdminSplit request
@@ -605,17 +1092,31 @@ func (r *Range) maybeSplit() {
 	}
 	// If this zone's total bytes are in excess, split the range. We omit
 	// the split key in order to have AdminSplit determine it via scan
-	// of range data.
-	if r.shouldSplit() {
-		// Admin commands run synchronously, so run this in a goroutine.
-		go r.AddCmd(proto.AdminSplit, &proto.AdminSplitRequest{
+	// of range data. If instead the range is hot, split at the
+	// access-weighted median key so that each half receives a similar
+	// share of the traffic, even though the range is under the size
+	// threshold.
+	var splitKey proto.Key
+	if !r.shouldSplitBySize() {
+		if !r.shouldSplitByLoad() {
+			return
+		}
+		if splitKey = r.load.splitKey(r.Desc.StartKey); splitKey == nil {
+			return
+		}
+	}
+	// Admin commands run synchronously, so run this as an async task.
+	r.rm.Stopper().RunAsyncTask(func() {
+		r.AddCmd(proto.AdminSplit, &proto.AdminSplitRequest{
 			RequestHeader: proto.RequestHeader{Key: r.Desc.StartKey},
+			SplitKey:      splitKey,
 		}, &proto.AdminSplitResponse{}, false)
-	}
+	})
 }
 
 // executeCmd switches over the method and multiplexes to execute the
-// appropriate storage API command.
+// appropriate storage API command. Values written by the command are
+// encoded with the compression chosen by its proposer.
 //
 // TODO(Spencer): Differentiate between errors caused by the normal culprits --
 // bad inputs from clients, stale information, etc. and errors which might
@@ -624,7 +1125,8 @@ func (r *Range) maybeSplit() {
 // errors which should be classified as a ReplicaCorruptionError--when those
 // bubble up to the point where we've just tried to execute a Raft command, the
 // Raft replica would need to stall itself.
-func (r *Range) executeCmd(method string, args proto.Request, reply proto.Response) error {
+func (r *Range) executeCmd(method string, args proto.Request, reply proto.Response,
+	compression proto.Compression) error {
 	// Verify key is contained within range here to catch any range split
 	// or merge activity.
 	header := args.Header()
@@ -633,11 +1135,28 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		reply.Header().SetGoError(err)
 		return err
 	}
+	// Reads below the GC threshold could silently miss versions which
+	// have already been garbage collected.
+	if proto.IsPublic(method) && proto.IsReadOnly(method) {
+		if threshold := r.GCThreshold(); header.Timestamp.Less(threshold) {
+			err := proto.NewReadBeforeGCThresholdError(header.Timestamp, threshold)
+			reply.Header().SetGoError(err)
+			return err
+		}
+	}
 
 	// Create a new batch for the command to ensure all or nothing semantics.
 	batch := r.rm.Engine().NewBatch()
-	// Create an MVCC instance wrapping the batch for commands which require MVCC.
-	mvcc := engine.NewMVCC(batch)
+	// The in-memory side effects of a commit trigger, applied only if
+	// the batch commits.
+	var applyTrigger func()
+	// Create an MVCC instance wrapping the batch for commands which
+	// require MVCC. Reads through MVCC are counted to measure read
+	// amplification.
+	reads := &readCountingEngine{Engine: batch}
+	mvcc := engine.NewMVCC(reads)
+	mvcc.Compression = compression
+	mvcc.SkipIntents = header.EffectiveReadBound(method) == proto.INCONSISTENT
 
 	switch method {
 	case proto.Contains:
@@ -652,12 +1171,16 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		r.Increment(mvcc, args.(*proto.IncrementRequest), reply.(*proto.IncrementResponse))
 	case proto.Delete:
 		r.Delete(mvcc, args.(*proto.DeleteRequest), reply.(*proto.DeleteResponse))
+	case proto.ConditionalDelete:
+		r.ConditionalDelete(mvcc, args.(*proto.ConditionalDeleteRequest), reply.(*proto.ConditionalDeleteResponse))
+	case proto.CompareAndSwap:
+		r.CompareAndSwap(mvcc, args.(*proto.CompareAndSwapRequest), reply.(*proto.CompareAndSwapResponse))
 	case proto.DeleteRange:
 		r.DeleteRange(mvcc, args.(*proto.DeleteRangeRequest), reply.(*proto.DeleteRangeResponse))
 	case proto.Scan:
 		r.Scan(mvcc, args.(*proto.ScanRequest), reply.(*proto.ScanResponse))
 	case proto.EndTransaction:
-		r.EndTransaction(batch, args.(*proto.EndTransactionRequest), reply.(*proto.EndTransactionResponse))
+		r.EndTransaction(mvcc, batch, args.(*proto.EndTransactionRequest), reply.(*proto.EndTransactionResponse))
 	case proto.AccumulateTS:
 		r.AccumulateTS(mvcc, args.(*proto.AccumulateTSRequest), reply.(*proto.AccumulateTSResponse))
 	case proto.ReapQueue:
@@ -669,7 +1192,7 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 	case proto.InternalRangeLookup:
 		r.InternalRangeLookup(mvcc, args.(*proto.InternalRangeLookupRequest), reply.(*proto.InternalRangeLookupResponse))
 	case proto.InternalEndTxn:
-		r.InternalEndTxn(batch, args.(*proto.InternalEndTxnRequest), reply.(*proto.InternalEndTxnResponse))
+		applyTrigger = r.InternalEndTxn(mvcc, batch, args.(*proto.InternalEndTxnRequest), reply.(*proto.InternalEndTxnResponse))
 	case proto.InternalHeartbeatTxn:
 		r.InternalHeartbeatTxn(batch, args.(*proto.InternalHeartbeatTxnRequest), reply.(*proto.InternalHeartbeatTxnResponse))
 	case proto.InternalPushTxn:
@@ -678,24 +1201,72 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		r.InternalResolveIntent(mvcc, args.(*proto.InternalResolveIntentRequest), reply.(*proto.InternalResolveIntentResponse))
 	case proto.InternalSnapshotCopy:
 		r.InternalSnapshotCopy(r.rm.Engine(), args.(*proto.InternalSnapshotCopyRequest), reply.(*proto.InternalSnapshotCopyResponse))
+	case proto.InternalExecute:
+		r.InternalExecute(mvcc, args.(*proto.InternalExecuteRequest), reply.(*proto.InternalExecuteResponse))
+	case proto.InternalRefresh:
+		r.InternalRefresh(mvcc, args.(*proto.InternalRefreshRequest), reply.(*proto.InternalRefreshResponse))
+	case proto.InternalRangeStats:
+		r.InternalRangeStats(batch, args.(*proto.InternalRangeStatsRequest), reply.(*proto.InternalRangeStatsResponse))
+	case proto.InternalScanIntents:
+		r.InternalScanIntents(batch, args.(*proto.InternalScanIntentsRequest), reply.(*proto.InternalScanIntentsResponse))
+	case proto.InternalGC:
+		r.InternalGC(mvcc, batch, args.(*proto.InternalGCRequest), reply.(*proto.InternalGCResponse))
 	default:
 		return util.Errorf("unrecognized command %q", method)
 	}
 
+	if proto.IsReadOnly(method) && reply.Header().Error == nil {
+		if keys := keysReturned(reply); keys > 0 {
+			r.load.recordReads(reads.reads, keys)
+		}
+		// A range may be hot with reads alone; potentially initiate a
+		// load-based split.
+		if r.shouldSplitByLoad() {
+			r.maybeSplit()
+		}
+	}
+
 	// On success, flush the MVCC stats to the batch and commit.
 	if proto.IsReadWrite(method) && reply.Header().Error == nil {
-		mvcc.MergeStats(r.RangeID, r.rm.StoreID())
-		if err := batch.Commit(); err != nil {
+		if err := mvcc.MergeStats(r.RangeID, r.rm.StoreID()); err != nil {
+			reply.Header().SetGoError(err)
+		} else if err := batch.Commit(); err != nil {
 			reply.Header().SetGoError(err)
 		} else {
+			if mvcc.LogicalBytes > 0 {
+				r.rm.RecordValueCompression(mvcc.LogicalBytes, mvcc.CompressedBytes)
+			}
+			if applyTrigger != nil {
+				applyTrigger()
+			}
+			r.maybeReleaseLocks(method, args, reply)
+			if method == proto.EndTransaction {
+				r.maybeResolveRemoteIntents(args.(*proto.EndTransactionRequest), reply.(*proto.EndTransactionResponse))
+			}
+			if method == proto.InternalGC {
+				r.Lock()
+				if r.gcThreshold.Less(args.(*proto.InternalGCRequest).GCThreshold) {
+					r.gcThreshold = args.(*proto.InternalGCRequest).GCThreshold
+				}
+				r.Unlock()
+			}
 			// If the commit succeeded, potentially initiate a split of this range.
 			r.maybeSplit()
 		}
 	}
 
+	// Keys written by any read-write command other than a committed
+	// blind put may no longer be fresh.
+	if proto.IsReadWrite(method) && (method != proto.Put || !args.(*proto.PutRequest).Blind || reply.Header().Error != nil) {
+		r.freshKeys = nil
+	}
+
 	// Maybe update gossip configs on a put if there was no error.
 	if (method == proto.Put || method == proto.ConditionalPut) && reply.Header().Error == nil {
 		r.maybeUpdateGossipConfigs(args.Header().Key)
+		if args.Header().Key.Equal(engine.KeyClusterVersion) {
+			r.maybeGossipClusterVersion()
+		}
 	}
 
 	// Propagate the request timestamp (which may have changed).
@@ -730,19 +1301,72 @@ func (r *Range) Contains(mvcc *engine.MVCC, args *proto.ContainsRequest, reply *
 	}
 }
 
-// Get returns the value for a specified key.
+// Get returns the value for a specified key. If the request has a
+// JSON path, only the element of the value's JSON document at the path
+// is returned.
 func (r *Range) Get(mvcc *engine.MVCC, args *proto.GetRequest, reply *proto.GetResponse) {
 	val, err := mvcc.Get(args.Key, args.Timestamp, args.Txn)
+	if err == nil && val != nil && args.JSONPath != "" {
+		if err = proto.ExtractJSONValue(args.Key, val, args.JSONPath); err != nil {
+			val = nil
+		}
+	}
 	reply.Value = val
 	reply.SetGoError(err)
 }
 
-// Put sets the value for a specified key.
+// Put sets the value for a specified key. If args.Expiration is set,
+// the value is treated as absent by reads at or after the expiration
+// and is eventually removed by garbage collection.
 func (r *Range) Put(mvcc *engine.MVCC, args *proto.PutRequest, reply *proto.PutResponse) {
+	if args.Expiration != nil {
+		if !args.Timestamp.Less(*args.Expiration) {
+			reply.SetGoError(util.Errorf("expiration %s must be later than put timestamp %s", args.Expiration, args.Timestamp))
+			return
+		}
+		args.Value.Expiration = args.Expiration
+	}
+	if args.Blind {
+		reply.SetGoError(r.blindPut(mvcc, args))
+		return
+	}
 	err := mvcc.Put(args.Key, args.Timestamp, args.Value, args.Txn)
 	reply.SetGoError(err)
 }
 
+// blindPut writes the value of a put to a key asserted to be fresh.
+// If the key is outside the range's span of known fresh keys, the
+// span is reestablished from the key to the next existing key via a
+// single seek; if the key itself exists, the put falls back to
+// reading it and fails with a KeyExistsError if it has a live value.
+// Keys within the span are written blindly. As the span is then
+// narrowed to the keys following the written key, consecutive blind
+// puts of ascending keys, as issued by log-style inserts and sorted
+// bulk loads, skip reading existing values altogether.
+func (r *Range) blindPut(mvcc *engine.MVCC, args *proto.PutRequest) error {
+	key := args.Key
+	if f := r.freshKeys; f == nil || key.Less(f.start) || !key.Less(f.end) {
+		r.freshKeys = nil
+		next, err := mvcc.FirstKey(key, r.Desc.EndKey)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			next = r.Desc.EndKey
+		}
+		if next.Equal(key) {
+			return mvcc.PutFresh(key, args.Timestamp, args.Value, args.Txn)
+		}
+		r.freshKeys = &keySpan{start: key, end: next}
+	}
+	if err := mvcc.BlindPut(key, args.Timestamp, args.Value, args.Txn); err != nil {
+		r.freshKeys = nil
+		return err
+	}
+	r.freshKeys.start = key.Next()
+	return nil
+}
+
 // ConditionalPut sets the value for a specified key only if
 // the expected value matches. If not, the return value contains
 // the actual value.
@@ -766,6 +1390,30 @@ func (r *Range) Delete(mvcc *engine.MVCC, args *proto.DeleteRequest, reply *prot
 	reply.SetGoError(mvcc.Delete(args.Key, args.Timestamp, args.Txn))
 }
 
+// ConditionalDelete deletes the key specified by key only if the
+// expected value matches. If not, the return value contains the
+// actual value.
+func (r *Range) ConditionalDelete(mvcc *engine.MVCC, args *proto.ConditionalDeleteRequest, reply *proto.ConditionalDeleteResponse) {
+	val, err := mvcc.ConditionalDelete(args.Key, args.Timestamp, args.ExpValue, args.Txn)
+	reply.ActualValue = val
+	reply.SetGoError(err)
+}
+
+// CompareAndSwap applies the writes only if all of the conditions
+// hold. Otherwise, the response contains the key of the failed
+// condition and its actual value. The request header must span all
+// keys, so that the command queue and timestamp cache cover them.
+func (r *Range) CompareAndSwap(mvcc *engine.MVCC, args *proto.CompareAndSwapRequest, reply *proto.CompareAndSwapResponse) {
+	if key, endKey := args.Span(); key.Less(args.Key) || args.EndKey.Less(endKey) {
+		reply.SetGoError(util.Errorf("keys [%q, %q) exceed request span [%q, %q)", key, endKey, args.Key, args.EndKey))
+		return
+	}
+	failedKey, val, err := mvcc.CompareAndSwap(args.Conditions, args.Writes, args.Timestamp, args.Txn)
+	reply.FailedKey = failedKey
+	reply.ActualValue = val
+	reply.SetGoError(err)
+}
+
 // DeleteRange deletes the range of key/value pairs specified by
 // start and end keys.
 func (r *Range) DeleteRange(mvcc *engine.MVCC, args *proto.DeleteRangeRequest, reply *proto.DeleteRangeResponse) {
@@ -779,13 +1427,29 @@ func (r *Range) DeleteRange(mvcc *engine.MVCC, args *proto.DeleteRangeRequest, r
 // returned with the reply.
 func (r *Range) Scan(mvcc *engine.MVCC, args *proto.ScanRequest, reply *proto.ScanResponse) {
 	kvs, err := mvcc.Scan(args.Key, args.EndKey, args.MaxResults, args.Timestamp, args.Txn)
+	if err == nil && args.Projection != nil {
+		for i := range kvs {
+			if err = args.Projection.ProjectValue(kvs[i].Key, &kvs[i].Value); err != nil {
+				kvs = nil
+				break
+			}
+		}
+	}
+	if err == nil && args.MaxBytes > 0 {
+		kvs, _ = proto.LimitRows(kvs, 0, args.MaxBytes)
+	}
 	reply.Rows = kvs
+	if err == nil && args.Checksum {
+		reply.SetChecksum()
+	}
 	reply.SetGoError(err)
 }
 
 // EndTransaction either commits or aborts (rolls back) an extant
-// transaction according to the args.Commit parameter.
-func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRequest, reply *proto.EndTransactionResponse) {
+// transaction according to the args.Commit parameter. Supplied
+// intents which lie within the range are resolved with the
+// transaction record.
+func (r *Range) EndTransaction(mvcc *engine.MVCC, batch engine.Engine, args *proto.EndTransactionRequest, reply *proto.EndTransactionResponse) {
 	if args.Txn == nil {
 		reply.SetGoError(util.Errorf("no transaction specified to EndTransaction"))
 		return
@@ -834,6 +1498,13 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 		if reply.Txn.Priority < args.Txn.Priority {
 			reply.Txn.Priority = args.Txn.Priority
 		}
+		// The requester's sequence and rolled back writes are more
+		// recent than those of the record, and intents must be resolved
+		// accordingly.
+		if reply.Txn.GetSequence() < args.Txn.GetSequence() {
+			reply.Txn.Sequence = args.Txn.Sequence
+		}
+		reply.Txn.IgnoredSeqNums = args.Txn.IgnoredSeqNums
 	} else {
 		// The transaction doesn't exist yet on disk; use the supplied version.
 		reply.Txn = gogoproto.Clone(args.Txn).(*proto.Transaction)
@@ -850,8 +1521,10 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 	if args.Commit {
 		// If the isolation level is SERIALIZABLE, return a transaction
 		// retry error if the commit timestamp isn't equal to the txn
-		// timestamp.
-		if args.Txn.Isolation == proto.SERIALIZABLE && !reply.Txn.Timestamp.Equal(args.Txn.Timestamp) {
+		// timestamp, unless the txn's reads were refreshed at or after
+		// the commit timestamp.
+		if args.Txn.Isolation == proto.SERIALIZABLE && !reply.Txn.Timestamp.Equal(args.Txn.Timestamp) &&
+			(args.RefreshedTimestamp == nil || args.RefreshedTimestamp.Less(reply.Txn.Timestamp)) {
 			reply.SetGoError(proto.NewTransactionRetryError(reply.Txn))
 			return
 		}
@@ -865,6 +1538,32 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 		reply.SetGoError(err)
 		return
 	}
+
+	// Resolve intents local to this range in the same batch. When all
+	// of a transaction's writes landed on the range holding its
+	// record, this completes the transaction with a single command.
+	// Remaining intents are resolved asynchronously by the leader once
+	// the command commits (see maybeResolveRemoteIntents), or are left
+	// to the coordinator if the store has no DB to send through.
+	reply.Resolved = true
+	for _, intent := range args.Intents {
+		if !r.ContainsKeyRange(intent.Key, intent.EndKey) {
+			if r.rm.DB() == nil {
+				reply.Resolved = false
+			}
+			continue
+		}
+		var err error
+		if len(intent.EndKey) == 0 {
+			err = mvcc.ResolveWriteIntent(intent.Key, reply.Txn)
+		} else {
+			_, err = mvcc.ResolveWriteIntentRange(intent.Key, intent.EndKey, 0, reply.Txn)
+		}
+		if err != nil {
+			reply.SetGoError(err)
+			return
+		}
+	}
 }
 
 // AccumulateTS is used internally to aggregate statistics over key
@@ -969,15 +1668,18 @@ func (r *Range) InternalRangeLookup(mvcc *engine.MVCC, args *proto.InternalRange
 	return
 }
 
-// InternalEndTxn invokes EndTransaction. On success, it executes any
-// triggers specified in args.
-func (r *Range) InternalEndTxn(batch engine.Engine, args *proto.InternalEndTxnRequest, reply *proto.InternalEndTxnResponse) {
+// InternalEndTxn invokes EndTransaction. On commit, it writes the
+// persistent side effects of the commit trigger specified in args to
+// the batch, returning a function which applies the trigger's
+// in-memory side effects once the batch has committed.
+func (r *Range) InternalEndTxn(mvcc *engine.MVCC, batch engine.Engine, args *proto.InternalEndTxnRequest, reply *proto.InternalEndTxnResponse) func() {
 	etArgs := &proto.EndTransactionRequest{}
 	etReply := &proto.EndTransactionResponse{}
 	etArgs.RequestHeader = args.RequestHeader
 	etArgs.Commit = args.Commit
+	etArgs.RefreshedTimestamp = args.RefreshedTimestamp
 
-	r.EndTransaction(batch, etArgs, etReply)
+	r.EndTransaction(mvcc, batch, etArgs, etReply)
 
 	reply.ResponseHeader = etReply.ResponseHeader
 	reply.Txn = etReply.Txn
@@ -985,11 +1687,15 @@ func (r *Range) InternalEndTxn(batch engine.Engine, args *proto.InternalEndTxnRe
 
 	// Run triggers if successfully committed. Any failures running
 	// triggers will set an error and prevent the batch from committing.
-	if reply.Txn.Status == proto.COMMITTED {
-		if args.SplitTrigger != nil {
-			reply.SetGoError(r.splitTrigger(batch, args.SplitTrigger))
-		}
+	if reply.Error != nil || reply.Txn.Status != proto.COMMITTED || args.CommitTrigger == nil {
+		return nil
+	}
+	apply, err := r.commitTrigger(batch, args.CommitTrigger)
+	if err != nil {
+		reply.SetGoError(err)
+		return nil
 	}
+	return apply
 }
 
 // InternalHeartbeatTxn updates the transaction status and heartbeat
@@ -1040,9 +1746,10 @@ func (r *Range) InternalHeartbeatTxn(batch engine.Engine, args *proto.InternalHe
 //
 // Txn Timeout: If pushee txn entry isn't present or its LastHeartbeat
 // timestamp isn't set, use PushTxn.Timestamp as LastHeartbeat. If
-// current time - LastHeartbeat > 2 * DefaultHeartbeatInterval, then
-// the pushee txn should be either pushed forward or aborted,
-// depending on value of Request.Abort.
+// current time - LastHeartbeat > txnLivenessThreshold, then the
+// pushee txn should be either pushed forward or aborted, depending on
+// value of Request.Abort. If Request.ExpiredOnly is set, a pushee txn
+// which hasn't timed out is never pushed.
 //
 // Old Txn Epoch: If persisted pushee txn entry has a newer Epoch than
 // PushTxn.Epoch, return success, as older epoch may be removed.
@@ -1058,6 +1765,11 @@ func (r *Range) InternalHeartbeatTxn(batch engine.Engine, args *proto.InternalHe
 // Higher Txn Priority: If pushee txn has a higher priority than
 // pusher, return TransactionPushError. Transaction will be retried
 // with priority one less than the pushee's higher priority.
+//
+// Equal Txn Priority: The tie is broken by pusherWinsTie. Pushes
+// never wait, so transactions which block each other can't deadlock
+// outright, but without a total order they could each fail to push
+// the other and retry indefinitely.
 func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxnRequest, reply *proto.InternalPushTxnResponse) {
 	if !bytes.Equal(args.Key, args.PusheeTxn.ID) {
 		reply.SetGoError(util.Errorf("request key %q should match pushee's txn ID %q", args.Key, args.PusheeTxn.ID))
@@ -1133,17 +1845,19 @@ func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxn
 	}
 	// Compute heartbeat expiration.
 	expiry := r.rm.Clock().Now()
-	expiry.WallTime -= 2 * DefaultHeartbeatInterval.Nanoseconds()
+	expiry.WallTime -= txnLivenessThreshold.Nanoseconds()
 	if reply.PusheeTxn.LastHeartbeat.Less(expiry) {
 		log.V(1).Infof("pushing expired txn %s", reply.PusheeTxn)
 		pusherWins = true
+	} else if args.ExpiredOnly {
+		log.V(1).Infof("not pushing live txn %s", reply.PusheeTxn)
 	} else if args.PusheeTxn.Epoch < reply.PusheeTxn.Epoch {
 		// Check for an intent from a prior epoch.
 		log.V(1).Infof("pushing intent from previous epoch for txn %s", reply.PusheeTxn)
 		pusherWins = true
 	} else if reply.PusheeTxn.Priority < priority ||
-		(reply.PusheeTxn.Priority == priority && args.Txn.Timestamp.Less(reply.PusheeTxn.Timestamp)) {
-		// Finally, choose based on priority; if priorities are equal, order by lower txn timestamp.
+		(reply.PusheeTxn.Priority == priority && pusherWinsTie(args.Txn, args.Timestamp, reply.PusheeTxn)) {
+		// Finally, choose based on priority; if priorities are equal, break the tie.
 		log.V(1).Infof("pushing intent from txn with lower priority %s vs %d", reply.PusheeTxn, priority)
 		pusherWins = true
 	} else if reply.PusheeTxn.Isolation == proto.SNAPSHOT && !args.Abort {
@@ -1176,6 +1890,22 @@ func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxn
 	}
 }
 
+// pusherWinsTie orders a pusher and pushee of equal priority,
+// returning whether the pusher prevails. The txn with the lower
+// timestamp wins; with equal timestamps, the txn with the lower ID
+// wins. Since the order is total, of two txns pushing each other,
+// exactly one prevails. A non-transactional pusher is ordered by its
+// request timestamp and loses any remaining tie.
+func pusherWinsTie(pusher *proto.Transaction, pusherTS proto.Timestamp, pushee *proto.Transaction) bool {
+	if pusher != nil {
+		pusherTS = pusher.Timestamp
+	}
+	if !pusherTS.Equal(pushee.Timestamp) {
+		return pusherTS.Less(pushee.Timestamp)
+	}
+	return pusher != nil && bytes.Compare(pusher.ID, pushee.ID) < 0
+}
+
 // InternalResolveIntent updates the transaction status and heartbeat
 // timestamp after receiving transaction heartbeat messages from
 // coordinator. The range will return the current status for this
@@ -1220,14 +1950,125 @@ func (r *Range) InternalSnapshotCopy(e engine.Engine, args *proto.InternalSnapsh
 	reply.SetGoError(err)
 }
 
+// InternalExecute invokes the procedure registered under args.Name
+// against the request's key span. The procedure's writes are
+// committed atomically with the command, and its output is returned
+// in reply.Result.
+func (r *Range) InternalExecute(mvcc *engine.MVCC, args *proto.InternalExecuteRequest, reply *proto.InternalExecuteResponse) {
+	p, err := lookupProcedure(args.Name)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	result, err := p(&ProcedureContext{
+		MVCC:      mvcc,
+		Key:       args.Key,
+		EndKey:    args.EndKey,
+		Timestamp: args.Timestamp,
+		Txn:       args.Txn,
+	}, args.Args)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	reply.Result = result
+}
+
+// InternalRefresh verifies that the span read by args.Txn hasn't been
+// written by another transaction since args.RefreshFrom, returning a
+// TransactionRetryError if it has. A successful refresh updates the
+// timestamp cache at args.Timestamp, so the transaction's reads
+// remain valid if it commits at that timestamp.
+func (r *Range) InternalRefresh(mvcc *engine.MVCC, args *proto.InternalRefreshRequest, reply *proto.InternalRefreshResponse) {
+	if args.Txn == nil {
+		reply.SetGoError(util.Errorf("no transaction specified to InternalRefresh"))
+		return
+	}
+	written, err := mvcc.WrittenBetween(args.Key, args.EndKey, args.RefreshFrom, args.Timestamp, args.Txn)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	if written {
+		reply.SetGoError(proto.NewTransactionRetryError(args.Txn))
+	}
+}
+
+// InternalRangeStats returns the MVCC stats of the range. The stats
+// are stored under a single key, so they're consistent with each
+// other as of the last applied command.
+func (r *Range) InternalRangeStats(batch engine.Engine, args *proto.InternalRangeStatsRequest, reply *proto.InternalRangeStatsResponse) {
+	ms, err := engine.GetRangeMVCCStats(batch, r.RangeID)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	reply.MVCCStats = *ms
+}
+
+// maybeReleaseLocks releases the locks held by a transaction once a
+// command ending it or resolving its intents is committed.
+func (r *Range) maybeReleaseLocks(method string, args proto.Request, reply proto.Response) {
+	var txn *proto.Transaction
+	switch method {
+	case proto.EndTransaction:
+		txn = reply.(*proto.EndTransactionResponse).Txn
+	case proto.InternalResolveIntent:
+		txn = args.Header().Txn
+	}
+	if txn != nil && txn.Status != proto.PENDING {
+		r.locks.release(txn.ID)
+	}
+}
+
+// InternalScanIntents returns the write intents in the span of the
+// request, along with the transaction as recorded on each intent.
+func (r *Range) InternalScanIntents(batch engine.Engine, args *proto.InternalScanIntentsRequest, reply *proto.InternalScanIntentsResponse) {
+	err := engine.MVCCIterateIntents(batch, args.Key, args.EndKey, func(key proto.Key, meta *proto.MVCCMetadata) (bool, error) {
+		reply.Intents = append(reply.Intents, proto.ScannedIntent{Key: key, Txn: *meta.Txn})
+		return args.MaxResults > 0 && int64(len(reply.Intents)) >= args.MaxResults, nil
+	})
+	if err != nil {
+		reply.SetGoError(err)
+	}
+}
+
+// InternalGC garbage collects the versions of the range's keys which
+// aren't visible to reads at any timestamp at or after the supplied
+// GC threshold and records the threshold, below which reads are
+// rejected from then on. The threshold never moves backwards and may
+// not be in the future.
+func (r *Range) InternalGC(mvcc *engine.MVCC, batch engine.Engine, args *proto.InternalGCRequest, reply *proto.InternalGCResponse) {
+	if r.rm.Clock().Now().Less(args.GCThreshold) {
+		reply.SetGoError(util.Errorf("GC threshold %s is in the future", args.GCThreshold))
+		return
+	}
+	threshold := args.GCThreshold
+	if current := r.GCThreshold(); threshold.Less(current) {
+		threshold = current
+	}
+	deleted, err := mvcc.GarbageCollect(r.Desc.StartKey, r.Desc.EndKey, threshold)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	if err := engine.SetRangeGCThreshold(batch, r.RangeID, threshold); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	reply.Deleted = deleted
+}
+
 // splitTrigger is called on a successful commit of an AdminSplit
 // transaction. It copies the response cache for the new range and
 // recomputes stats for both the existing, updated range and the new
-// range.
-func (r *Range) splitTrigger(batch engine.Engine, split *proto.SplitTrigger) error {
+// range. The returned function adds the new range to the store.
+func (r *Range) splitTrigger(batch engine.Engine, split *proto.SplitTrigger) (func(), error) {
 	if !bytes.Equal(r.Desc.StartKey, split.UpdatedDesc.StartKey) ||
-		!bytes.Equal(r.Desc.EndKey, split.NewDesc.EndKey) {
-		return util.Errorf("range does not match splits: %q-%q + %q-%q != %q-%q", split.UpdatedDesc.StartKey,
+		!bytes.Equal(r.Desc.EndKey, split.NewDesc.EndKey) ||
+		!bytes.Equal(split.UpdatedDesc.EndKey, split.NewDesc.StartKey) ||
+		bytes.Compare(split.NewDesc.StartKey, r.Desc.StartKey) <= 0 {
+		return nil, util.Errorf("range does not match splits: %q-%q + %q-%q != %q-%q", split.UpdatedDesc.StartKey,
 			split.UpdatedDesc.EndKey, split.NewDesc.StartKey, split.NewDesc.EndKey, r.Desc.StartKey, r.Desc.EndKey)
 	}
 	// Find range ID for this replica.
@@ -1236,30 +2077,46 @@ func (r *Range) splitTrigger(batch engine.Engine, split *proto.SplitTrigger) err
 	// Compute stats for new range.
 	ms, err := engine.MVCCComputeStats(r.rm.Engine(), split.NewDesc.StartKey, split.NewDesc.EndKey)
 	if err != nil {
-		return util.Errorf("unable to compute stats for new range after split: %s", err)
+		return nil, util.Errorf("unable to compute stats for new range after split: %s", err)
+	}
+	if err := engine.SetRangeMVCCStats(batch, newRangeID, &ms); err != nil {
+		return nil, util.Errorf("unable to write stats for new range after split: %s", err)
 	}
-	ms.SetStats(batch, newRangeID, 0)
 	// Compute stats for updated range.
 	ms, err = engine.MVCCComputeStats(r.rm.Engine(), split.UpdatedDesc.StartKey, split.UpdatedDesc.EndKey)
 	if err != nil {
-		return util.Errorf("unable to compute stats for updated range after split: %s", err)
+		return nil, util.Errorf("unable to compute stats for updated range after split: %s", err)
+	}
+	if err := engine.SetRangeMVCCStats(batch, r.RangeID, &ms); err != nil {
+		return nil, util.Errorf("unable to write stats for updated range after split: %s", err)
 	}
-	ms.SetStats(batch, r.RangeID, 0)
 
 	// Initialize the new range's response cache by copying the original's.
 	if err = r.respCache.CopyInto(batch, newRangeID); err != nil {
-		return util.Errorf("unable to copy response cache to new split range: %s", err)
+		return nil, util.Errorf("unable to copy response cache to new split range: %s", err)
+	}
+	// The new range inherits the original's GC threshold.
+	gcThreshold := r.GCThreshold()
+	if err = engine.SetRangeGCThreshold(batch, newRangeID, gcThreshold); err != nil {
+		return nil, util.Errorf("unable to write GC threshold for new split range: %s", err)
 	}
 
-	// Add the new split range to the store. This step atomically
-	// updates the EndKey of the updated range and also adds the
-	// new range to the store's range map.
+	// Once committed, add the new split range to the store. This step
+	// atomically updates the EndKey of the updated range and also adds
+	// the new range to the store's range map.
 	newRng := NewRange(newRangeID, &split.NewDesc, r.rm)
-	// Write-lock the mutex to protect Desc, as SplitRange will modify
-	// Desc.EndKey.
-	r.Lock()
-	defer r.Unlock()
-	return r.rm.SplitRange(r, newRng)
+	newRng.gcThreshold, newRng.gcLoaded = gcThreshold, true
+	return func() {
+		// Write-lock the mutex to protect Desc, as SplitRange will modify
+		// Desc.EndKey.
+		r.Lock()
+		err := r.rm.SplitRange(r, newRng)
+		r.Unlock()
+		if err != nil {
+			log.Fatalf("unable to add range %d split from range %d: %s", newRangeID, r.RangeID, err)
+		}
+		r.maybeGossipSplit(split)
+	}, nil
 }
 
 // AdminSplit divides the range into into two ranges, using either
@@ -1354,12 +2211,106 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 		return txn.Call(proto.InternalEndTxn, &proto.InternalEndTxnRequest{
 			RequestHeader: proto.RequestHeader{Key: args.Key},
 			Commit:        true,
-			SplitTrigger: &proto.SplitTrigger{
-				UpdatedDesc: updatedDesc,
-				NewDesc:     *newDesc,
+			CommitTrigger: &proto.CommitTrigger{
+				SplitTrigger: &proto.SplitTrigger{
+					UpdatedDesc: updatedDesc,
+					NewDesc:     *newDesc,
+				},
 			},
 		}, &proto.InternalEndTxnResponse{})
 	}); err != nil {
 		reply.SetGoError(util.Errorf("split at key %q failed: %s", splitKey, err))
 	}
 }
+
+// AdminRecoverReplicas rewrites the descriptor of a range which has
+// permanently lost a majority of its replicas to a new replica set
+// made up of the surviving replicas. The range is assigned a new Raft
+// ID so the lost replicas can't rejoin it. Replicas can't be added by
+// recovery, as there is no way yet to seed them with a snapshot of the
+// survivors; args.AddReplicas must be empty. As the range can't commit
+// commands without a quorum, the descriptor is written directly to
+// this replica's engine before the addressing records are updated.
+//
+// This is unsafe: writes acknowledged only by the lost replicas are
+// gone. It's refused unless args.AcknowledgeDataLoss is set, the
+// stores in args.LostStoreIDs hold a majority of the range's
+// replicas, and this replica is a survivor.
+func (r *Range) AdminRecoverReplicas(args *proto.AdminRecoverReplicasRequest, reply *proto.AdminRecoverReplicasResponse) {
+	if !args.AcknowledgeDataLoss {
+		reply.SetGoError(util.Errorf("recovery of range %d may lose data and must be acknowledged", r.RangeID))
+		return
+	}
+	r.RLock()
+	desc := *r.Desc
+	r.RUnlock()
+
+	current := map[int32]struct{}{}
+	for _, replica := range desc.Replicas {
+		current[replica.StoreID] = struct{}{}
+	}
+	lost := map[int32]struct{}{}
+	for _, storeID := range args.LostStoreIDs {
+		if _, ok := current[storeID]; !ok {
+			reply.SetGoError(util.Errorf("store %d holds no replica of range %d", storeID, r.RangeID))
+			return
+		}
+		if storeID == r.rm.StoreID() {
+			reply.SetGoError(util.Errorf("recovery must be carried out by a surviving replica; store %d is lost", storeID))
+			return
+		}
+		lost[storeID] = struct{}{}
+	}
+	var survivors []proto.Replica
+	for _, replica := range desc.Replicas {
+		if _, ok := lost[replica.StoreID]; !ok {
+			survivors = append(survivors, replica)
+		}
+	}
+	if quorum := len(desc.Replicas)/2 + 1; len(survivors) >= quorum {
+		reply.SetGoError(util.Errorf("range %d still has a quorum of %d of %d replicas; change replicas without recovery",
+			r.RangeID, len(survivors), len(desc.Replicas)))
+		return
+	}
+	if len(args.AddReplicas) > 0 {
+		reply.SetGoError(util.Errorf("replicas can't be added by recovery of range %d; add them once it has recovered",
+			r.RangeID))
+		return
+	}
+
+	// Allocate a new Raft ID; the survivors keep their range IDs, under
+	// which their data is stored.
+	newDesc, err := r.rm.NewRangeDescriptor(desc.StartKey, desc.EndKey, nil)
+	if err != nil {
+		reply.SetGoError(util.Errorf("unable to allocate new range descriptor: %s", err))
+		return
+	}
+	newDesc.Replicas = survivors
+
+	log.Warningf("recovering range %d %q-%q from replicas %+v after loss of stores %v; new replicas: %+v",
+		r.RangeID, desc.StartKey, desc.EndKey, survivors, args.LostStoreIDs, newDesc.Replicas)
+
+	batch := r.rm.Engine().NewBatch()
+	mvcc := engine.NewMVCC(batch)
+	if err := mvcc.PutProto(makeRangeKey(desc.StartKey), r.rm.Clock().Now(), nil, newDesc); err != nil {
+		reply.SetGoError(util.Errorf("unable to write range descriptor: %s", err))
+		return
+	}
+	if err := mvcc.MergeStats(r.RangeID, r.rm.StoreID()); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	if err := batch.Commit(); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	r.Lock()
+	r.Desc = newDesc
+	r.Unlock()
+
+	if err := UpdateRangeAddressing(r.rm.DB(), newDesc); err != nil {
+		reply.SetGoError(util.Errorf("range descriptor rewritten but addressing records not updated: %s", err))
+		return
+	}
+	reply.Desc = *newDesc
+}
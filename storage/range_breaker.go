@@ -0,0 +1,142 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// DefaultUnavailableThreshold is the duration for which a read-write
+// command may await application before its range is considered
+// unavailable.
+const DefaultUnavailableThreshold = 1 * time.Minute
+
+// breakerProbeInterval is the interval at which a tripped breaker
+// lets a command through to probe whether the range has recovered.
+const breakerProbeInterval = 5 * time.Second
+
+// A replicaBreaker fails the read-write commands of a replica fast once
+// a command has awaited application for longer than the store's
+// unavailable threshold, as happens when the range has lost quorum.
+// Otherwise, commands would queue indefinitely behind the unavailable
+// range, tying up the gateways which sent them. While tripped, one
+// command per breakerProbeInterval is let through as a probe. The
+// breaker resets as soon as any command is applied, whether a probe or
+// one queued before the breaker tripped.
+type replicaBreaker struct {
+	mu        sync.Mutex
+	trippedAt time.Time // Zero unless tripped
+	lastProbe time.Time // Time of the last probe let through
+}
+
+// allow returns true if a command may proceed as of now. If the
+// breaker is tripped, it also returns the time at which it tripped.
+func (b *replicaBreaker) allow(now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.trippedAt.IsZero() {
+		return true, time.Time{}
+	}
+	if now.Sub(b.lastProbe) >= breakerProbeInterval {
+		b.lastProbe = now
+		return true, b.trippedAt
+	}
+	return false, b.trippedAt
+}
+
+// trip trips the breaker as of now, returning false if it was already
+// tripped.
+func (b *replicaBreaker) trip(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.trippedAt.IsZero() {
+		return false
+	}
+	b.trippedAt, b.lastProbe = now, now
+	return true
+}
+
+// reset resets the breaker, returning false if it wasn't tripped.
+func (b *replicaBreaker) reset() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.trippedAt.IsZero() {
+		return false
+	}
+	b.trippedAt = time.Time{}
+	return true
+}
+
+// isTripped returns whether the breaker is tripped.
+func (b *replicaBreaker) isTripped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.trippedAt.IsZero()
+}
+
+// checkBreaker returns a RangeUnavailableError if the range's breaker
+// is tripped and the command isn't let through as a probe.
+func (r *Range) checkBreaker() error {
+	now := time.Now()
+	ok, trippedAt := r.breaker.allow(now)
+	if ok {
+		return nil
+	}
+	r.rm.RecordFailedFast()
+	r.RLock()
+	raftID := r.Desc.RaftID
+	r.RUnlock()
+	var replica proto.Replica
+	if rep := r.GetReplica(); rep != nil {
+		replica = *rep
+	}
+	return proto.NewRangeUnavailableError(raftID, replica, now.Sub(trippedAt))
+}
+
+// awaitCmd waits for the command to complete, tripping the range's
+// breaker if it doesn't within the store's unavailable threshold. The
+// command remains queued after the breaker trips, so that the breaker
+// resets once it's applied. A zero threshold disables the breaker.
+func (r *Range) awaitCmd(cmd *Cmd) error {
+	var err error
+	threshold := r.rm.UnavailableThreshold()
+	if threshold == 0 {
+		err = <-cmd.done
+	} else {
+		timer := time.NewTimer(threshold)
+		select {
+		case err = <-cmd.done:
+		case <-timer.C:
+			if r.breaker.trip(time.Now()) {
+				log.Warningf("range %d: %s command awaiting application for %s; failing commands fast",
+					r.Desc.RaftID, cmd.Method, threshold)
+				r.rm.RecordBreakerTrip()
+			}
+			err = <-cmd.done
+		}
+		timer.Stop()
+	}
+	if r.breaker.reset() {
+		log.Infof("range %d: commands are being applied again", r.Desc.RaftID)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// ttlRangeEventGossip is the time-to-live of gossiped range events.
+// Events need only outlive their propagation through gossip; gateways
+// which miss an event discover the change via RangeKeyMismatchError.
+const ttlRangeEventGossip = 1 * time.Minute
+
+// A RangeEvent records a change to the boundaries of ranges. Events
+// are gossiped by the leader of the changed range so that gateways
+// update their range descriptor caches, instead of discovering the
+// change when a request is sent to the wrong range. Descs are the
+// descriptors of the ranges covering the affected span after the
+// change, in key order: for a split, the updated and the new range.
+//
+// TODO(agent): gossip the merged range's descriptor once ranges can be
+// merged.
+type RangeEvent struct {
+	Timestamp int64 // Wall time of the change in nanoseconds
+	Descs     []proto.RangeDescriptor
+}
+
+// Less implements the util.Ordered interface, ordering events by time
+// so that the group of gossiped events retains the most recent.
+func (e RangeEvent) Less(b util.Ordered) bool {
+	return e.Timestamp < b.(RangeEvent).Timestamp
+}
+
+// maybeGossipSplit gossips a RangeEvent for a split of the range, if
+// this replica is the leader.
+func (r *Range) maybeGossipSplit(split *proto.SplitTrigger) {
+	if r.rm.Gossip() == nil || !r.IsLeader() {
+		return
+	}
+	event := RangeEvent{
+		Timestamp: r.rm.Clock().Now().WallTime,
+		Descs:     []proto.RangeDescriptor{split.UpdatedDesc, split.NewDesc},
+	}
+	key := gossip.MakeRangeEventGossipKey(split.NewDesc.RaftID)
+	if err := r.rm.Gossip().AddInfo(key, event, ttlRangeEventGossip); err != nil {
+		log.Errorf("failed to gossip split of range %d: %s", r.RangeID, err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// LeaderLease returns the leader lease last granted for the range, or
+// nil if none has been. The lease may have expired. It's read from
+// the engine on first use.
+func (r *Range) LeaderLease() *proto.Lease {
+	r.RLock()
+	lease, loaded := r.lease, r.leaseLoaded
+	r.RUnlock()
+	if loaded {
+		return lease
+	}
+	r.Lock()
+	defer r.Unlock()
+	if !r.leaseLoaded {
+		lease, err := engine.GetRangeLeaderLease(r.rm.Engine(), r.RangeID)
+		if err != nil {
+			log.Errorf("unable to read leader lease of range %d: %s", r.RangeID, err)
+			return r.lease
+		}
+		r.lease, r.leaseLoaded = lease, true
+	}
+	return r.lease
+}
+
+// newNotLeaderError returns a NotLeaderError naming the replica which
+// holds the range's leader lease, if it's known and unexpired.
+func (r *Range) newNotLeaderError() *proto.NotLeaderError {
+	err := &proto.NotLeaderError{}
+	if lease := r.LeaderLease(); lease != nil && lease.Replica.StoreID != r.rm.StoreID() &&
+		r.rm.Clock().Now().Less(lease.Expiration) {
+		err.Leader = lease.Replica
+	}
+	return err
+}
+
+// AdminTransferLease would grant the leader lease of the range to
+// its replica on the store args.TargetStoreID. The target must be
+// another replica of the range, and transfers are refused while the
+// range is splitting, as the split would leave the new range's lease
+// with this replica.
+//
+// Transfers which pass these checks are refused as well until ranges
+// are replicated via Raft: the lease would be written only to this
+// replica's engine and the target wouldn't learn of it, leaving the
+// range without a leader until the lease expired.
+//
+// TODO(agent): propose the lease as a command so that every replica
+// applies it, and have the holder renew it before it expires.
+func (r *Range) AdminTransferLease(args *proto.AdminTransferLeaseRequest, reply *proto.AdminTransferLeaseResponse) {
+	if args.TargetStoreID == r.rm.StoreID() {
+		reply.SetGoError(util.Errorf("range %d is already led by store %d", r.RangeID, args.TargetStoreID))
+		return
+	}
+	if atomic.LoadInt32(&r.splitting) == int32(1) {
+		reply.SetGoError(util.Errorf("cannot transfer lease of range %d while splitting", r.RangeID))
+		return
+	}
+	var found bool
+	r.RLock()
+	for _, replica := range r.Desc.Replicas {
+		if replica.StoreID == args.TargetStoreID {
+			found = true
+		}
+	}
+	r.RUnlock()
+	if !found {
+		reply.SetGoError(util.Errorf("store %d holds no replica of range %d", args.TargetStoreID, r.RangeID))
+		return
+	}
+	reply.SetGoError(util.Errorf("cannot transfer lease of range %d to store %d: leases are not yet replicated "+
+		"to the other replicas of a range", r.RangeID, args.TargetStoreID))
+}
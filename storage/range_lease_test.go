@@ -0,0 +1,111 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+func transferLeaseArgs(key []byte, storeID int32) (*proto.AdminTransferLeaseRequest, *proto.AdminTransferLeaseResponse) {
+	args := &proto.AdminTransferLeaseRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     key,
+			Replica: proto.Replica{RangeID: 1},
+		},
+		TargetStoreID: storeID,
+	}
+	return args, &proto.AdminTransferLeaseResponse{}
+}
+
+// TestRangeTransferLease verifies the safety checks of lease
+// transfers and that transfers are refused until leases are
+// replicated, leaving leadership unchanged.
+func TestRangeTransferLease(t *testing.T) {
+	rng, _, _, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+	rng.rm.(*Store).Ident.StoreID = 1
+
+	// The target must be another replica of the range.
+	for _, storeID := range []int32{1, 3} {
+		args, reply := transferLeaseArgs(engine.KeyMin, storeID)
+		if err := rng.AddCmd(proto.AdminTransferLease, args, reply, true); err == nil {
+			t.Errorf("expected transfer to store %d to fail", storeID)
+		}
+	}
+	// Transfers are refused while the range is splitting.
+	atomic.StoreInt32(&rng.splitting, 1)
+	args, reply := transferLeaseArgs(engine.KeyMin, 2)
+	if err := rng.AddCmd(proto.AdminTransferLease, args, reply, true); err == nil {
+		t.Error("expected transfer during split to fail")
+	}
+	atomic.StoreInt32(&rng.splitting, 0)
+	// Transfers to another replica are refused as well.
+	args, reply = transferLeaseArgs(engine.KeyMin, 2)
+	if err := rng.AddCmd(proto.AdminTransferLease, args, reply, true); err == nil {
+		t.Error("expected transfer to fail until leases are replicated")
+	}
+	if !rng.IsLeader() || rng.LeaderLease() != nil {
+		t.Fatal("expected failed transfers to leave leadership unchanged")
+	}
+}
+
+// TestRangeLeaderLease verifies that a replica redirects commands to
+// the holder of the range's leader lease until the lease expires.
+func TestRangeLeaderLease(t *testing.T) {
+	rng, mc, _, eng := createTestRangeWithClock(t)
+	defer rng.Stop()
+	rng.rm.(*Store).Ident.StoreID = 1
+	mc.Set((10 * time.Second).Nanoseconds())
+
+	now := rng.rm.Clock().Now()
+	lease := &proto.Lease{
+		Start:      now,
+		Expiration: now.Add(time.Minute.Nanoseconds(), 0),
+		Replica:    proto.Replica{NodeID: 2, StoreID: 2},
+	}
+	if err := engine.SetRangeLeaderLease(eng, rng.RangeID, lease); err != nil {
+		t.Fatal(err)
+	}
+	// Force the lease to be read from the engine.
+	rng.Lock()
+	rng.leaseLoaded = false
+	rng.Unlock()
+	if rng.IsLeader() {
+		t.Error("expected replica not to be leader")
+	}
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	err := rng.AddCmd(proto.Get, gArgs, gReply, true)
+	if nlErr, ok := err.(*proto.NotLeaderError); !ok || nlErr.Leader.StoreID != 2 {
+		t.Errorf("expected not leader error naming store 2; got %v", err)
+	}
+
+	// Leadership reverts once the lease expires.
+	mc.Set(lease.Expiration.WallTime + 1)
+	if !rng.IsLeader() {
+		t.Error("expected replica to regain leadership after lease expiration")
+	}
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/metric"
+)
+
+const (
+	// rangeLoadTimescale is the timescale over which range request
+	// rates are averaged.
+	rangeLoadTimescale = 1 * time.Minute
+)
+
+// RangeLoadStats is a snapshot of the load on a range, reported
+// alongside its byte stats. Latencies are in nanoseconds.
+type RangeLoadStats struct {
+	QPS               float64 // Commands per second
+	ReadLatencyP50    int64
+	ReadLatencyP99    int64
+	WriteLatencyP50   int64
+	WriteLatencyP99   int64
+	ReadAmplification float64 // Engine key/values visited per key read
+}
+
+// rangeLoad tracks request rates, latencies and engine read
+// amplification for a range. Accessed keys are sampled so that a hot
+// range may be split such that each half receives a similar share of
+// traffic; see splitSampler. rangeLoad is safe for concurrent use.
+type rangeLoad struct {
+	sync.Mutex
+	qps          *metric.Rate
+	readLatency  *metric.Histogram
+	writeLatency *metric.Histogram
+	engineReads  int64 // Engine key/values visited by reads
+	keysRead     int64 // Keys returned by reads
+	sampler      *splitSampler
+}
+
+// newRangeLoad returns a new, empty rangeLoad.
+func newRangeLoad() *rangeLoad {
+	rl := &rangeLoad{}
+	rl.resetLocked()
+	return rl
+}
+
+// reset clears all accumulated load. This is invoked on split, as
+// the range's load is then shared with the new range.
+func (rl *rangeLoad) reset() {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.resetLocked()
+}
+
+func (rl *rangeLoad) resetLocked() {
+	rl.qps = metric.NewRate(rangeLoadTimescale)
+	rl.readLatency = metric.NewHistogram()
+	rl.writeLatency = metric.NewHistogram()
+	rl.engineReads, rl.keysRead = 0, 0
+	rl.sampler = newSplitSampler()
+}
+
+// record records a command which accessed the key range from start
+// to end. If end is empty, the command accessed only start.
+func (rl *rangeLoad) record(start, end proto.Key) {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.qps.Add(1)
+	if len(end) == 0 {
+		rl.sampler.record(engine.KeyAddress(start), nil)
+	} else {
+		rl.sampler.record(engine.KeyAddress(start), engine.KeyAddress(end))
+	}
+}
+
+// recordLatency records the latency of a read-only or read-write
+// command.
+func (rl *rangeLoad) recordLatency(readOnly bool, latency time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+	if readOnly {
+		rl.readLatency.RecordValue(latency.Nanoseconds())
+	} else {
+		rl.writeLatency.RecordValue(latency.Nanoseconds())
+	}
+}
+
+// recordReads records the number of engine key/values visited by a
+// read-only command in order to return the specified number of keys.
+func (rl *rangeLoad) recordReads(engineReads, keysRead int64) {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.engineReads += engineReads
+	rl.keysRead += keysRead
+}
+
+// QPS returns the rate of commands per second.
+func (rl *rangeLoad) QPS() float64 {
+	rl.Lock()
+	defer rl.Unlock()
+	return rl.qps.Value()
+}
+
+// stats returns a snapshot of the range load.
+func (rl *rangeLoad) stats() RangeLoadStats {
+	rl.Lock()
+	defer rl.Unlock()
+	stats := RangeLoadStats{
+		QPS:               rl.qps.Value(),
+		ReadLatencyP50:    rl.readLatency.ValueAtQuantile(50),
+		ReadLatencyP99:    rl.readLatency.ValueAtQuantile(99),
+		WriteLatencyP50:   rl.writeLatency.ValueAtQuantile(50),
+		WriteLatencyP99:   rl.writeLatency.ValueAtQuantile(99),
+		ReadAmplification: 1,
+	}
+	if rl.keysRead > 0 {
+		stats.ReadAmplification = float64(rl.engineReads) / float64(rl.keysRead)
+	}
+	return stats
+}
+
+// splitKey returns the access-weighted median key of the range after
+// startKey, or nil if none can be determined from sampled requests.
+func (rl *rangeLoad) splitKey(startKey proto.Key) proto.Key {
+	rl.Lock()
+	defer rl.Unlock()
+	return rl.sampler.splitKey(startKey)
+}
+
+// keysReturned returns the number of keys returned by a read-only
+// command, for computing read amplification. Commands other than
+// reads of user data return zero.
+func keysReturned(reply proto.Response) int64 {
+	switch t := reply.(type) {
+	case *proto.GetResponse:
+		return 1
+	case *proto.ScanResponse:
+		return int64(len(t.Rows))
+	}
+	return 0
+}
+
+// readCountingEngine wraps an engine, counting the key/values read
+// from it. It's used to measure the read amplification of commands.
+type readCountingEngine struct {
+	engine.Engine
+	reads int64
+}
+
+// Get implements engine.Engine.
+func (e *readCountingEngine) Get(key proto.EncodedKey) ([]byte, error) {
+	e.reads++
+	return e.Engine.Get(key)
+}
+
+// Iterate implements engine.Engine.
+func (e *readCountingEngine) Iterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error {
+	return e.Engine.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		e.reads++
+		return f(kv)
+	})
+}
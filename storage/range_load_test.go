@@ -0,0 +1,80 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestRangeLoadStats verifies latency percentiles and read
+// amplification reported by a range load.
+func TestRangeLoadStats(t *testing.T) {
+	rl := newRangeLoad()
+	if stats := rl.stats(); stats.ReadAmplification != 1 || stats.ReadLatencyP99 != 0 {
+		t.Errorf("unexpected stats for empty range load: %+v", stats)
+	}
+	for i := 1; i <= 100; i++ {
+		rl.recordLatency(true, time.Duration(i)*time.Millisecond)
+		rl.recordLatency(false, time.Duration(2*i)*time.Millisecond)
+	}
+	rl.recordReads(30, 10)
+	stats := rl.stats()
+	if p50 := time.Duration(stats.ReadLatencyP50); p50 < 49*time.Millisecond || p50 > 51*time.Millisecond {
+		t.Errorf("expected read p50 of ~50ms; got %s", p50)
+	}
+	if p99 := time.Duration(stats.WriteLatencyP99); p99 < 196*time.Millisecond || p99 > 200*time.Millisecond {
+		t.Errorf("expected write p99 of ~198ms; got %s", p99)
+	}
+	if stats.ReadAmplification != 3 {
+		t.Errorf("expected read amplification of 3; got %f", stats.ReadAmplification)
+	}
+
+	rl.reset()
+	if stats := rl.stats(); stats.ReadAmplification != 1 || stats.ReadLatencyP99 != 0 {
+		t.Errorf("unexpected stats after reset: %+v", stats)
+	}
+}
+
+// TestRangeLoadReadAmplification verifies that reads through a range
+// record engine read amplification.
+func TestRangeLoadReadAmplification(t *testing.T) {
+	rng, _ := createTestRange(createTestEngine(t), t)
+	defer rng.Stop()
+
+	for i := 0; i < 3; i++ {
+		pArgs, pReply := putArgs([]byte("a"), []byte(fmt.Sprintf("value%d", i)), 1)
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	stats := rng.LoadStats()
+	if stats.ReadAmplification < 1 {
+		t.Errorf("expected read amplification of at least 1; got %f", stats.ReadAmplification)
+	}
+	if stats.ReadLatencyP99 == 0 || stats.WriteLatencyP99 == 0 {
+		t.Errorf("expected read and write latencies to be recorded: %+v", stats)
+	}
+}
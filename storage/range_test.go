@@ -32,6 +32,7 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 )
 
@@ -95,10 +96,10 @@ func createTestEngine(t *testing.T) engine.Engine {
 // createTestRange creates a new range initialized to the full extent
 // of the keyspace. The gossip instance is also returned for testing.
 func createTestRange(engine engine.Engine, t *testing.T) (*Range, *gossip.Gossip) {
-	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig())
+	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig(), nil)
 	g := gossip.New(rpcContext)
 	clock := hlc.NewClock(hlc.UnixNano)
-	r := NewRange(1, &testRangeDescriptor, NewStore(clock, engine, nil, g))
+	r := NewRange(1, &testRangeDescriptor, NewStore(clock, engine, nil, g, util.NewStopper()))
 	r.Start()
 	return r, g
 }
@@ -119,7 +120,7 @@ func TestRangeContains(t *testing.T) {
 	}
 
 	clock := hlc.NewClock(hlc.UnixNano)
-	r := NewRange(0, desc, NewStore(clock, nil, nil, nil))
+	r := NewRange(0, desc, NewStore(clock, nil, nil, nil, util.NewStopper()))
 	if !r.ContainsKey(proto.Key("aa")) {
 		t.Errorf("expected range to contain key \"aa\"")
 	}
@@ -228,7 +229,7 @@ func TestRangeGossipConfigUpdates(t *testing.T) {
 	}
 	reply := &proto.PutResponse{}
 
-	if err := r.executeCmd(proto.Put, req, reply); err != nil {
+	if err := r.executeCmd(proto.Put, req, reply, proto.UNCOMPRESSED); err != nil {
 		t.Fatal(err)
 	}
 	info, err := g.GetInfo(gossip.KeyConfigPermission)
@@ -313,12 +314,12 @@ func (be *blockingEngine) NewBatch() engine.Engine {
 // createTestRangeWithClock creates a range using a blocking engine. Returns
 // the range clock's manual unix nanos time and the range.
 func createTestRangeWithClock(t *testing.T) (*Range, *hlc.ManualClock, *hlc.Clock, *blockingEngine) {
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	engine := newBlockingEngine()
-	rng := NewRange(1, &testRangeDescriptor, NewStore(clock, engine, nil, nil))
+	rng := NewRange(1, &testRangeDescriptor, NewStore(clock, engine, nil, nil, util.NewStopper()))
 	rng.Start()
-	return rng, &manual, clock, engine
+	return rng, manual, clock, engine
 }
 
 // getArgs returns a GetRequest and GetResponse pair addressed to
@@ -495,6 +496,248 @@ func verifyErrorMatches(err error, regexpStr string, t *testing.T) {
 	}
 }
 
+// TestRangePutExpiration verifies that a value put with an
+// expiration is visible until the expiration and absent afterwards,
+// and that an expiration not later than the put timestamp is an error.
+func TestRangePutExpiration(t *testing.T) {
+	rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+	mc.Set(1 * time.Second.Nanoseconds())
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+	pArgs.Timestamp = clock.Now()
+	pArgs.Expiration = &proto.Timestamp{WallTime: pArgs.Timestamp.WallTime}
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err == nil {
+		t.Fatal("expected error putting value which expires immediately")
+	}
+
+	pArgs, pReply = putArgs([]byte("a"), []byte("value"), 1)
+	pArgs.Timestamp = clock.Now()
+	pArgs.Expiration = &proto.Timestamp{WallTime: 2 * time.Second.Nanoseconds()}
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		wallTime int64
+		expValue bool
+	}{
+		{1500 * time.Millisecond.Nanoseconds(), true},
+		{2 * time.Second.Nanoseconds(), false},
+		{3 * time.Second.Nanoseconds(), false},
+	} {
+		mc.Set(test.wallTime)
+		gArgs, gReply := getArgs([]byte("a"), 1)
+		gArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+			t.Fatal(err)
+		}
+		if (gReply.Value != nil) != test.expValue {
+			t.Errorf("at %d: expected value? %t; got %+v", test.wallTime, test.expValue, gReply.Value)
+		}
+	}
+}
+
+// TestRangeBlindPut verifies that blind puts of ascending keys are
+// written within the range's span of fresh keys, that a blind put of
+// an existing key fails with a KeyExistsError and that other writes
+// invalidate the fresh span.
+func TestRangeBlindPut(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	put := func(key string, blind bool) error {
+		pArgs, pReply := putArgs([]byte(key), []byte("value-"+key), 1)
+		pArgs.Timestamp = clock.Now()
+		pArgs.Blind = blind
+		return rng.AddCmd(proto.Put, pArgs, pReply, true)
+	}
+	expectFresh := func(start, end proto.Key) {
+		f := rng.freshKeys
+		if start == nil {
+			if f != nil {
+				t.Errorf("expected no fresh keys; got [%q, %q)", f.start, f.end)
+			}
+		} else if f == nil || !f.start.Equal(start) || !f.end.Equal(end) {
+			t.Errorf("expected fresh keys [%q, %q); got %+v", start, end, f)
+		}
+	}
+
+	if err := put("c", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := put("a", true); err != nil {
+		t.Fatal(err)
+	}
+	expectFresh(proto.Key("a").Next(), proto.Key("c"))
+	if err := put("b", true); err != nil {
+		t.Fatal(err)
+	}
+	expectFresh(proto.Key("b").Next(), proto.Key("c"))
+	if _, ok := put("c", true).(*proto.KeyExistsError); !ok {
+		t.Error("expected KeyExistsError on blind put of existing key")
+	}
+	expectFresh(nil, nil)
+	if err := put("d", true); err != nil {
+		t.Fatal(err)
+	}
+	expectFresh(proto.Key("d").Next(), engine.KeyMax)
+	if err := put("e", false); err != nil {
+		t.Fatal(err)
+	}
+	expectFresh(nil, nil)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		gArgs, gReply := getArgs([]byte(key), 1)
+		gArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+			t.Fatal(err)
+		}
+		if gReply.Value == nil || !bytes.Equal(gReply.Value.Bytes, []byte("value-"+key)) {
+			t.Errorf("expected value-%s; got %+v", key, gReply.Value)
+		}
+	}
+	ms, err := engine.GetRangeMVCCStats(rng.rm.Engine(), rng.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.KeyCount != 5 || ms.LiveCount != 5 {
+		t.Errorf("expected 5 keys in stats; got %+v", ms)
+	}
+}
+
+// TestRangeScanProjection verifies that scanned values are projected
+// to the requested fields, and that values which can't be projected
+// fail the scan.
+func TestRangeScanProjection(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	entry := &proto.AuditEntry{Sequence: 1, User: "root", Action: "split", Details: []byte("details")}
+	data, err := gogoproto.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pArgs, pReply := putArgs([]byte("a"), data, 1)
+	pArgs.Timestamp = clock.Now()
+	pArgs.Value.InitChecksum(pArgs.Key)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	sArgs, sReply := scanArgs([]byte("a"), []byte("z"), 1)
+	sArgs.Timestamp = clock.Now()
+	sArgs.MaxResults = 10
+	sArgs.Projection = &proto.Projection{Fields: []int32{3}}
+	if err := rng.AddCmd(proto.Scan, sArgs, sReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(sReply.Rows) != 1 {
+		t.Fatalf("expected 1 row; got %d", len(sReply.Rows))
+	}
+	row := sReply.Rows[0]
+	if err := row.Value.Verify(row.Key); err != nil {
+		t.Errorf("expected valid checksum of projected value: %s", err)
+	}
+	projected := &proto.AuditEntry{}
+	if err := gogoproto.Unmarshal(row.Value.Bytes, projected); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&proto.AuditEntry{User: "root"}); !reflect.DeepEqual(projected, expected) {
+		t.Errorf("expected projected entry %+v; got %+v", expected, projected)
+	}
+
+	// A value which isn't a valid protobuf fails the scan.
+	pArgs, pReply = putArgs([]byte("b"), []byte{0xff}, 1)
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	sArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Scan, sArgs, sReply, true); err == nil {
+		t.Error("expected error projecting invalid value")
+	}
+}
+
+// TestRangeScanChecksum verifies that scans which request a checksum
+// return one matching the scanned rows, and that other scans don't.
+func TestRangeScanChecksum(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	for _, key := range []string{"a", "b", "c"} {
+		pArgs, pReply := putArgs([]byte(key), []byte("value-"+key), 1)
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, checksum := range []bool{false, true} {
+		sArgs, sReply := scanArgs([]byte("a"), []byte("z"), 1)
+		sArgs.Timestamp = clock.Now()
+		sArgs.MaxResults = 10
+		sArgs.Checksum = checksum
+		if err := rng.AddCmd(proto.Scan, sArgs, sReply, true); err != nil {
+			t.Fatal(err)
+		}
+		if len(sReply.Rows) != 3 {
+			t.Fatalf("expected 3 rows; got %d", len(sReply.Rows))
+		}
+		if (sReply.Checksum != nil) != checksum {
+			t.Errorf("expected checksum=%t; got %v", checksum, sReply.Checksum)
+		}
+		if err := sReply.VerifyChecksum(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// TestRangeVerifyValueChecksums verifies that commands carrying values
+// whose checksums don't match their contents are rejected at apply
+// time without being executed.
+func TestRangeVerifyValueChecksums(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+	pArgs.Timestamp = clock.Now()
+	pArgs.Value.InitChecksum(pArgs.Key)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A value corrupted after its checksum was computed isn't written.
+	pArgs, pReply = putArgs([]byte("b"), []byte("value"), 1)
+	pArgs.Timestamp = clock.Now()
+	pArgs.Value.InitChecksum(pArgs.Key)
+	pArgs.Value.Bytes[0] = 'x'
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err == nil {
+		t.Error("expected put of corrupted value to fail")
+	}
+	gArgs, gReply := getArgs([]byte("b"), 1)
+	gArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value != nil {
+		t.Errorf("expected corrupted value not to be written; got %+v", gReply.Value)
+	}
+
+	// So is a conditional put whose expected value is corrupted.
+	cpArgs := &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.Key("a"), Replica: proto.Replica{RangeID: 1}},
+		Value:         proto.Value{Bytes: []byte("new")},
+		ExpValue:      &proto.Value{Bytes: []byte("value")},
+	}
+	cpArgs.Timestamp = clock.Now()
+	cpArgs.ExpValue.InitChecksum(cpArgs.Key)
+	cpArgs.ExpValue.Bytes = []byte("other")
+	if err := rng.AddCmd(proto.ConditionalPut, cpArgs, &proto.ConditionalPutResponse{}, true); err == nil {
+		t.Error("expected conditional put with corrupted expected value to fail")
+	}
+}
+
 // TestRangeUpdateTSCache verifies that reads and writes update the
 // timestamp cache.
 func TestRangeUpdateTSCache(t *testing.T) {
@@ -502,7 +745,7 @@ func TestRangeUpdateTSCache(t *testing.T) {
 	defer rng.Stop()
 	// Set clock to time 1s and do the read.
 	t0 := 1 * time.Second
-	*mc = hlc.ManualClock(t0.Nanoseconds())
+	mc.Set(t0.Nanoseconds())
 	gArgs, gReply := getArgs([]byte("a"), 1)
 	gArgs.Timestamp = clock.Now()
 	err := rng.AddCmd(proto.Get, gArgs, gReply, true)
@@ -511,7 +754,7 @@ func TestRangeUpdateTSCache(t *testing.T) {
 	}
 	// Set clock to time 2s for write.
 	t1 := 2 * time.Second
-	*mc = hlc.ManualClock(t1.Nanoseconds())
+	mc.Set(t1.Nanoseconds())
 	pArgs, pReply := putArgs([]byte("b"), []byte("1"), 1)
 	pArgs.Timestamp = clock.Now()
 	err = rng.AddCmd(proto.Put, pArgs, pReply, true)
@@ -626,6 +869,115 @@ func TestRangeCommandQueue(t *testing.T) {
 	}
 }
 
+// TestRangeQuiescence verifies that an idle range quiesces, that the
+// store continues to close its timestamps, and that the next command
+// wakes it.
+func TestRangeQuiescence(t *testing.T) {
+	rng, _ := createTestRange(createTestEngine(t), t)
+	defer rng.Stop()
+	store := rng.rm.(*Store)
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if rng.IsQuiesced() {
+		t.Fatal("expected active range not to be quiesced")
+	}
+	quiesceWait := (quiesceIdleTicks + 3) * closedTimestampInterval
+	if err := util.IsTrueWithin(rng.IsQuiesced, quiesceWait); err != nil {
+		t.Fatalf("expected idle range to quiesce within %s", quiesceWait)
+	}
+	if err := util.IsTrueWithin(func() bool {
+		return store.metrics.quiescedRanges.Value() == 1
+	}, 100*time.Millisecond); err != nil {
+		t.Fatal("expected quiesced range to be registered with the store")
+	}
+
+	// Verify the store's heartbeat closes timestamps for the range.
+	rng.RLock()
+	closedTS := rng.closedTS
+	rng.RUnlock()
+	if err := util.IsTrueWithin(func() bool {
+		rng.RLock()
+		defer rng.RUnlock()
+		return closedTS.Less(rng.closedTS)
+	}, 3*closedTimestampInterval); err != nil {
+		t.Fatal("expected store to close timestamps for quiesced range")
+	}
+
+	// The next command wakes the range.
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if rng.IsQuiesced() {
+		t.Fatal("expected range to wake on command")
+	}
+	if err := util.IsTrueWithin(func() bool {
+		return store.metrics.quiescedRanges.Value() == 0
+	}, 100*time.Millisecond); err != nil {
+		t.Fatal("expected woken range to be unregistered from the store")
+	}
+}
+
+// TestRangeBreaker verifies that a write awaiting application beyond
+// the store's unavailable threshold trips the range's breaker, failing
+// further writes fast, and that the breaker resets once writes are
+// applied again, including probes let through while it's tripped.
+func TestRangeBreaker(t *testing.T) {
+	rng, _, _, be := createTestRangeWithClock(t)
+	defer rng.Stop()
+	store := rng.rm.(*Store)
+	store.SetUnavailableThreshold(10 * time.Millisecond)
+
+	// Block the application of a write, stalling the range.
+	be.block(proto.Key("a"))
+	done := make(chan error, 1)
+	go func() {
+		pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+		done <- rng.AddCmd(proto.Put, pArgs, pReply, true)
+	}()
+	if err := util.IsTrueWithin(rng.breaker.isTripped, 500*time.Millisecond); err != nil {
+		t.Fatal("expected stalled write to trip the breaker")
+	}
+	pArgs, pReply := putArgs([]byte("b"), []byte("value"), 1)
+	err := rng.AddCmd(proto.Put, pArgs, pReply, true)
+	if _, ok := err.(*proto.RangeUnavailableError); !ok {
+		t.Fatalf("expected range unavailable error; got %v", err)
+	}
+	if trips, failed := store.metrics.breakerTrips.Count(), store.metrics.requestsFailedFast.Count(); trips != 1 || failed != 1 {
+		t.Errorf("expected 1 trip and 1 write failed fast; got %d and %d", trips, failed)
+	}
+	if status := rng.RaftStatus(); !status.Unavailable {
+		t.Error("expected raft status to report the range unavailable")
+	}
+
+	// Once the stalled write is applied, the breaker resets.
+	be.unblock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rng.breaker.isTripped() {
+		t.Fatal("expected breaker to reset once the write was applied")
+	}
+	pArgs, pReply = putArgs([]byte("b"), []byte("value"), 1)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tripped breaker lets a probe through after the probe interval,
+	// and resets if it's applied.
+	rng.breaker.trip(time.Now().Add(-breakerProbeInterval))
+	pArgs, pReply = putArgs([]byte("c"), []byte("value"), 1)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatalf("expected probe to be let through; got %s", err)
+	}
+	if rng.breaker.isTripped() {
+		t.Error("expected breaker to reset once the probe was applied")
+	}
+}
+
 // TestRangeUseTSCache verifies that write timestamps are upgraded
 // based on the read timestamp cache.
 func TestRangeUseTSCache(t *testing.T) {
@@ -633,7 +985,7 @@ func TestRangeUseTSCache(t *testing.T) {
 	defer rng.Stop()
 	// Set clock to time 1s and do the read.
 	t0 := 1 * time.Second
-	*mc = hlc.ManualClock(t0.Nanoseconds())
+	mc.Set(t0.Nanoseconds())
 	args, reply := getArgs([]byte("a"), 1)
 	args.Timestamp = clock.Now()
 	err := rng.AddCmd(proto.Get, args, reply, true)
@@ -650,6 +1002,174 @@ func TestRangeUseTSCache(t *testing.T) {
 	}
 }
 
+// TestRangeClosedTimestamp verifies that closing a timestamp pushes
+// subsequent writes past it, and that bounded staleness reads are
+// served at the closed timestamp when it lies within the bound.
+func TestRangeClosedTimestamp(t *testing.T) {
+	rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+	makeTS := func(d time.Duration) proto.Timestamp {
+		return proto.Timestamp{WallTime: d.Nanoseconds()}
+	}
+	mc.Set((10 * time.Second).Nanoseconds())
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("v1"), 1)
+	pArgs.Timestamp = makeTS(6 * time.Second)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	closedTS := makeTS(8 * time.Second)
+	rng.closeTimestamp(closedTS)
+
+	// A write below the closed timestamp is pushed past it.
+	pArgs, pReply = putArgs([]byte("a"), []byte("v2"), 1)
+	pArgs.Timestamp = makeTS(7 * time.Second)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if !closedTS.Less(pReply.Timestamp) {
+		t.Errorf("expected write timestamp to be pushed past %s; got %s", closedTS, pReply.Timestamp)
+	}
+
+	// The closed timestamp is only usable within the staleness bound.
+	if _, ok := rng.followerReadTimestamp(clock.Now(), time.Second.Nanoseconds()); ok {
+		t.Error("expected closed timestamp to exceed staleness bound of 1s")
+	}
+	if ts, ok := rng.followerReadTimestamp(clock.Now(), (5 * time.Second).Nanoseconds()); !ok || !ts.Equal(closedTS) {
+		t.Errorf("expected follower read at %s; got %s, %t", closedTS, ts, ok)
+	}
+
+	// A follower read sees the value as of the closed timestamp.
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	gArgs.MaxStaleness = (5 * time.Second).Nanoseconds()
+	if err := rng.addFollowerReadCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gReply.Value.Bytes, []byte("v1")) || !gReply.Timestamp.Equal(closedTS) {
+		t.Errorf("expected v1 at %s; got %q at %s", closedTS, gReply.Value.Bytes, gReply.Timestamp)
+	}
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	gArgs.MaxStaleness = time.Second.Nanoseconds()
+	if _, ok := rng.addFollowerReadCmd(proto.Get, gArgs, gReply).(*proto.NotLeaderError); !ok {
+		t.Errorf("expected not leader error; got %v", gReply.GoError())
+	}
+}
+
+// TestRangeFollowerClosedTimestamp verifies that a follower serves
+// bounded staleness reads at the timestamp closed by its leader once
+// it has been observed, and refuses them until then.
+func TestRangeFollowerClosedTimestamp(t *testing.T) {
+	rng, mc, _, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+	rng.rm.(*Store).Ident.StoreID = 1
+	makeTS := func(d time.Duration) proto.Timestamp {
+		return proto.Timestamp{WallTime: d.Nanoseconds()}
+	}
+	mc.Set((10 * time.Second).Nanoseconds())
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("v1"), 1)
+	pArgs.Timestamp = makeTS(6 * time.Second)
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	// Hand the leader lease to the replica on store 2.
+	rng.Lock()
+	rng.lease = &proto.Lease{Expiration: makeTS(time.Minute), Replica: proto.Replica{StoreID: 2}}
+	rng.leaseLoaded = true
+	rng.Unlock()
+	if rng.IsLeader() {
+		t.Fatal("expected replica to be a follower")
+	}
+
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	gArgs.MaxStaleness = (5 * time.Second).Nanoseconds()
+	if _, ok := rng.AddCmd(proto.Get, gArgs, gReply, true).(*proto.NotLeaderError); !ok {
+		t.Errorf("expected not leader error before a closed timestamp is observed; got %v", gReply.GoError())
+	}
+
+	closedTS := makeTS(8 * time.Second)
+	rng.observeClosedTimestamp(closedTS)
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	gArgs.MaxStaleness = (5 * time.Second).Nanoseconds()
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gReply.Value.Bytes, []byte("v1")) || !gReply.Timestamp.Equal(closedTS) {
+		t.Errorf("expected v1 at %s; got %q at %s", closedTS, gReply.Value.Bytes, gReply.Timestamp)
+	}
+
+	// An older closed timestamp doesn't move the follower's back.
+	rng.observeClosedTimestamp(makeTS(7 * time.Second))
+	if ts, ok := rng.followerReadTimestamp(makeTS(10*time.Second), (5 * time.Second).Nanoseconds()); !ok || !ts.Equal(closedTS) {
+		t.Errorf("expected follower read at %s; got %s, %t", closedTS, ts, ok)
+	}
+}
+
+// TestRangeScanWait verifies that a scan with a wait timeout is held
+// until a key in its span is written after the wait timestamp, and
+// then returns the write.
+func TestRangeScanWait(t *testing.T) {
+	rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+	mc.Set(10)
+
+	sArgs, sReply := scanArgs([]byte("a"), []byte("c"), 1)
+	sArgs.Timestamp = clock.Now()
+	sArgs.MaxResults = 10
+	if err := rng.AddCmd(proto.Scan, sArgs, sReply, true); err != nil {
+		t.Fatal(err)
+	}
+	waitScan := func() (*proto.ScanRequest, *proto.ScanResponse) {
+		args, reply := scanArgs([]byte("a"), []byte("c"), 1)
+		args.MaxResults = 10
+		args.WaitTimestamp = sArgs.Timestamp
+		args.WaitTimeout = time.Minute.Nanoseconds()
+		return args, reply
+	}
+	wArgs, wReply := waitScan()
+	errC := make(chan error, 1)
+	go func() { errC <- rng.AddCmd(proto.Scan, wArgs, wReply, true) }()
+
+	// A write outside of the span doesn't end the wait.
+	pArgs, pReply := putArgs([]byte("d"), []byte("x"), 1)
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errC:
+		t.Fatalf("expected scan to wait for a write to its span; returned %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	pArgs, pReply = putArgs([]byte("b"), []byte("v"), 1)
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errC:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected write to end the wait")
+	}
+	if len(wReply.Rows) != 1 || !bytes.Equal(wReply.Rows[0].Key, []byte("b")) {
+		t.Errorf("expected the written key to be scanned; got %+v", wReply.Rows)
+	}
+
+	// A wait for writes since a timestamp which already saw one
+	// returns at once.
+	wArgs, wReply = waitScan()
+	if err := rng.AddCmd(proto.Scan, wArgs, wReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(wReply.Rows) != 1 {
+		t.Errorf("expected one row; got %+v", wReply.Rows)
+	}
+}
+
 // TestRangeNoTSCacheUpdateOnFailure verifies that read and write
 // commands do not update the timestamp cache if they result in
 // failure.
@@ -728,6 +1248,68 @@ func TestRangeNoTimestampIncrementWithinTxn(t *testing.T) {
 	}
 }
 
+func init() {
+	// testPop deletes the first key in the span and returns its value.
+	RegisterProcedure("testPop", func(ctx *ProcedureContext, args []byte) ([]byte, error) {
+		kvs, err := ctx.MVCC.Scan(ctx.Key, ctx.EndKey, 1, ctx.Timestamp, ctx.Txn)
+		if err != nil || len(kvs) == 0 {
+			return nil, err
+		}
+		if err := ctx.MVCC.Delete(kvs[0].Key, ctx.Timestamp, ctx.Txn); err != nil {
+			return nil, err
+		}
+		return kvs[0].Value.Bytes, nil
+	})
+}
+
+// TestRangeInternalExecute verifies that registered procedures run
+// a read-modify-write within a single command, that replays return
+// the cached result, and that unknown procedures are an error.
+func TestRangeInternalExecute(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	for _, key := range []string{"q1", "q2"} {
+		pArgs, pReply := putArgs([]byte(key), []byte(key), 1)
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	execArgs := func(name string, random int64) (*proto.InternalExecuteRequest, *proto.InternalExecuteResponse) {
+		return &proto.InternalExecuteRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       proto.Key("q"),
+				EndKey:    proto.Key("r"),
+				Replica:   proto.Replica{RangeID: 1},
+				Timestamp: clock.Now(),
+				CmdID:     proto.ClientCmdID{WallTime: 1, Random: random},
+			},
+			Name: name,
+		}, &proto.InternalExecuteResponse{}
+	}
+
+	for i, expResult := range []string{"q1", "q2", "", "q2"} {
+		random := int64(i)
+		if i == 3 {
+			random = 1 // replay of second pop
+		}
+		args, reply := execArgs("testPop", random)
+		if err := rng.AddCmd(proto.InternalExecute, args, reply, true); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if string(reply.Result) != expResult {
+			t.Errorf("%d: expected result %q; got %q", i, expResult, reply.Result)
+		}
+	}
+
+	args, reply := execArgs("unknown", 10)
+	if err := rng.AddCmd(proto.InternalExecute, args, reply, true); err == nil {
+		t.Error("expected error executing unregistered procedure")
+	}
+}
+
 // TestRangeIdempotence verifies that a retry increment with
 // same client command ID receives same reply.
 func TestRangeIdempotence(t *testing.T) {
@@ -887,6 +1469,159 @@ func TestRangeSnapshot(t *testing.T) {
 	}
 }
 
+// TestRangeFollowerSnapshot verifies that snapshots are served by
+// replicas which aren't the leader.
+func TestRangeFollowerSnapshot(t *testing.T) {
+	rng, mc, _, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+	rng.rm.(*Store).Ident.StoreID = 1
+	mc.Set((10 * time.Second).Nanoseconds())
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+	pArgs.Timestamp = rng.rm.Clock().Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	args, reply := transferLeaseArgs(engine.KeyMin, 2)
+	if err := rng.AddCmd(proto.AdminTransferLease, args, reply, true); err != nil {
+		t.Fatal(err)
+	}
+	if rng.IsLeader() {
+		t.Fatal("expected replica to have lost leadership")
+	}
+	iscArgs, iscReply := internalSnapshotCopyArgs(engine.MVCCEncodeKey(engine.KeyLocalPrefix.PrefixEnd()), engine.KeyMax, 50, "", 1)
+	if err := rng.AddCmd(proto.InternalSnapshotCopy, iscArgs, iscReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(iscReply.SnapshotID) == 0 || len(iscReply.Rows) == 0 {
+		t.Errorf("expected snapshot rows; got %+v", iscReply)
+	}
+}
+
+// TestRangeCompareAndSwap verifies that a compare-and-swap applies
+// its writes only if all of its conditions hold.
+func TestRangeCompareAndSwap(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("v1"), 1)
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	cas := func(expValue []byte) (*proto.CompareAndSwapResponse, error) {
+		args := &proto.CompareAndSwapRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: clock.Now(),
+				Replica:   proto.Replica{RangeID: 1},
+			},
+			Conditions: []proto.CompareAndSwapCondition{
+				{Key: proto.Key("a"), ExpValue: &proto.Value{Bytes: expValue}},
+				{Key: proto.Key("b")},
+			},
+			Writes: []proto.CompareAndSwapWrite{
+				{Key: proto.Key("a")},
+				{Key: proto.Key("b"), Value: &proto.Value{Bytes: []byte("v2")}},
+			},
+		}
+		args.Key, args.EndKey = args.Span()
+		reply := &proto.CompareAndSwapResponse{}
+		err := rng.AddCmd(proto.CompareAndSwap, args, reply, true)
+		return reply, err
+	}
+	get := func(key string) *proto.Value {
+		gArgs, gReply := getArgs([]byte(key), 1)
+		gArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+			t.Fatal(err)
+		}
+		return gReply.Value
+	}
+
+	// A failed condition leaves all keys untouched.
+	if reply, err := cas([]byte("v0")); err == nil {
+		t.Error("expected compare-and-swap to fail")
+	} else if !reply.FailedKey.Equal(proto.Key("a")) || !bytes.Equal(reply.ActualValue.Bytes, []byte("v1")) {
+		t.Errorf("expected v1 at failed key \"a\"; got %+v", reply)
+	}
+	if v := get("a"); v == nil || get("b") != nil {
+		t.Errorf("expected no writes to be applied")
+	}
+
+	if _, err := cas([]byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if v := get("b"); get("a") != nil || v == nil || !bytes.Equal(v.Bytes, []byte("v2")) {
+		t.Errorf("expected \"a\" to be deleted and \"b\" to be v2; got %+v", v)
+	}
+
+	// Keys outside of the header's span are rejected.
+	args := &proto.CompareAndSwapRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       proto.Key("a"),
+			EndKey:    proto.Key("b"),
+			Timestamp: clock.Now(),
+			Replica:   proto.Replica{RangeID: 1},
+		},
+		Writes: []proto.CompareAndSwapWrite{{Key: proto.Key("c")}},
+	}
+	if err := rng.AddCmd(proto.CompareAndSwap, args, &proto.CompareAndSwapResponse{}, true); err == nil {
+		t.Error("expected error for a key outside of the request span")
+	}
+}
+
+// TestRangeInconsistentRead verifies that inconsistent reads skip
+// the intents of pending transactions and are served by replicas
+// which aren't the leader.
+func TestRangeInconsistentRead(t *testing.T) {
+	rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+	rng.rm.(*Store).Ident.StoreID = 1
+	mc.Set((10 * time.Second).Nanoseconds())
+	key := []byte("a")
+	pArgs, pReply := putArgs(key, []byte("v1"), 1)
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	txn := newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+	pArgs, pReply = putArgs(key, []byte("v2"), 1)
+	pArgs.Txn = txn
+	pArgs.Timestamp = txn.Timestamp
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(bound proto.ReadBound) (*proto.GetResponse, error) {
+		gArgs, gReply := getArgs(key, 1)
+		gArgs.Timestamp = clock.Now()
+		gArgs.ReadBound = bound
+		err := rng.AddCmd(proto.Get, gArgs, gReply, true)
+		return gReply, err
+	}
+	if _, err := get(proto.EXACT); err == nil {
+		t.Error("expected write intent error for an exact read")
+	} else if _, ok := err.(*proto.WriteIntentError); !ok {
+		t.Errorf("expected write intent error; got %T: %s", err, err)
+	}
+	if reply, err := get(proto.INCONSISTENT); err != nil || !bytes.Equal(reply.Value.Bytes, []byte("v1")) {
+		t.Errorf("expected v1; got %+v, %v", reply.Value, err)
+	}
+
+	args, reply := transferLeaseArgs(engine.KeyMin, 2)
+	if err := rng.AddCmd(proto.AdminTransferLease, args, reply, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(proto.EXACT); err == nil {
+		t.Error("expected not leader error for an exact read")
+	} else if _, ok := err.(*proto.NotLeaderError); !ok {
+		t.Errorf("expected not leader error; got %T: %s", err, err)
+	}
+	if reply, err := get(proto.INCONSISTENT); err != nil || !bytes.Equal(reply.Value.Bytes, []byte("v1")) {
+		t.Errorf("expected v1 from a follower; got %+v, %v", reply.Value, err)
+	}
+}
+
 // TestEndTransactionBeforeHeartbeat verifies that a transaction
 // can be committed/aborted before being heartbeat.
 func TestEndTransactionBeforeHeartbeat(t *testing.T) {
@@ -983,7 +1718,7 @@ func TestEndTransactionWithPushedTimestamp(t *testing.T) {
 		txn := newTransaction("test", key, 1, test.isolation, clock)
 		// End the transaction with args timestamp moved forward in time.
 		args, reply := endTxnArgs(txn, test.commit, 1)
-		*mc = hlc.ManualClock(1)
+		mc.Set(1)
 		args.Timestamp = clock.Now()
 		err := rng.AddCmd(proto.EndTransaction, args, reply, true)
 		if test.expErr {
@@ -1008,6 +1743,86 @@ func TestEndTransactionWithPushedTimestamp(t *testing.T) {
 	}
 }
 
+// TestEndTransactionWithRefreshedTimestamp verifies that a SERIALIZABLE
+// txn with a pushed timestamp may commit if its reads were refreshed
+// at the pushed timestamp.
+func TestEndTransactionWithRefreshedTimestamp(t *testing.T) {
+	rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	key := []byte("a")
+	txn := newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+	args, reply := endTxnArgs(txn, true, 1)
+	mc.Set(1)
+	args.Timestamp = clock.Now()
+	refreshed := txn.Timestamp
+	args.RefreshedTimestamp = &refreshed
+	if err := rng.AddCmd(proto.EndTransaction, args, reply, true); err == nil {
+		t.Errorf("expected error with stale refreshed timestamp")
+	}
+
+	txn = newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+	args, reply = endTxnArgs(txn, true, 1)
+	mc.Set(2)
+	args.Timestamp = clock.Now()
+	refreshed = args.Timestamp
+	args.RefreshedTimestamp = &refreshed
+	if err := rng.AddCmd(proto.EndTransaction, args, reply, true); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Txn.Status != proto.COMMITTED {
+		t.Errorf("expected transaction status to be %s; got %s", proto.COMMITTED, reply.Txn.Status)
+	}
+}
+
+// TestInternalRefresh verifies that a refresh fails only if the span
+// was written between the refresh's start timestamp and its timestamp.
+func TestInternalRefresh(t *testing.T) {
+	rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	mc.Set(1)
+	txn := newTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	mc.Set(2)
+	pArgs, pReply := putArgs([]byte("b"), []byte("value"), 1)
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		key, endKey proto.Key
+		from        int64
+		expErr      bool
+	}{
+		{proto.Key("a"), proto.Key("c"), 1, true},
+		{proto.Key("b"), proto.Key(nil), 1, true},
+		{proto.Key("a"), proto.Key("c"), 2, false},
+		{proto.Key("c"), proto.Key("d"), 1, false},
+	}
+	mc.Set(3)
+	for i, test := range testCases {
+		args := &proto.InternalRefreshRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       test.key,
+				EndKey:    test.endKey,
+				Timestamp: clock.Now(),
+				Replica:   proto.Replica{RangeID: 1},
+				Txn:       txn,
+			},
+			RefreshFrom: proto.Timestamp{WallTime: test.from},
+		}
+		err := rng.AddCmd(proto.InternalRefresh, args, &proto.InternalRefreshResponse{}, true)
+		if test.expErr {
+			if _, ok := err.(*proto.TransactionRetryError); !ok {
+				t.Errorf("%d: expected retry error; got %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+	}
+}
+
 // TestEndTransactionWithIncrementedEpoch verifies that txn ended with
 // a higher epoch (and priority) correctly assumes the higher epoch.
 func TestEndTransactionWithIncrementedEpoch(t *testing.T) {
@@ -1051,7 +1866,7 @@ func TestEndTransactionWithErrors(t *testing.T) {
 	defer rng.Stop()
 
 	regressTS := clock.Now()
-	*mc = hlc.ManualClock(1)
+	mc.Set(1)
 	txn := newTransaction("test", proto.Key(""), 1, proto.SERIALIZABLE, clock)
 
 	testCases := []struct {
@@ -1233,7 +2048,7 @@ func TestInternalPushTxnHeartbeatTimeout(t *testing.T) {
 		}
 
 		// Now, attempt to push the transaction with clock set to "currentTime".
-		*mc = hlc.ManualClock(test.currentTime)
+		mc.Set(test.currentTime)
 		args, reply := pushTxnArgs(pusher, pushee, true, 1)
 		err := rng.AddCmd(proto.InternalPushTxn, args, reply, true)
 		if test.expSuccess != (err == nil) {
@@ -1322,7 +2137,8 @@ func TestInternalPushTxnPriorities(t *testing.T) {
 		{1, 2, ts1, ts2, false, true},
 		// With same priorities, older txn timestamp succeeds.
 		{1, 1, ts1, ts2, true, true},
-		// With same priorities, same txn timestamp fails.
+		// With same priorities, same txn timestamp fails as the pusher's
+		// ID is greater.
 		{1, 1, ts1, ts1, true, false},
 		{1, 1, ts1, ts1, false, false},
 		// With same priorities, newer txn timestamp fails.
@@ -1338,6 +2154,9 @@ func TestInternalPushTxnPriorities(t *testing.T) {
 		pushee.Priority = test.pusheePriority
 		pusher.Timestamp = test.pusherTS
 		pushee.Timestamp = test.pusheeTS
+		if bytes.Compare(pusher.ID, pushee.ID) < 0 {
+			pusher.ID, pushee.ID = pushee.ID, pusher.ID
+		}
 
 		// Now, attempt to push the transaction with intent epoch set appropriately.
 		args, reply := pushTxnArgs(pusher, pushee, test.abort, 1)
@@ -1353,6 +2172,36 @@ func TestInternalPushTxnPriorities(t *testing.T) {
 	}
 }
 
+// TestInternalPushTxnMutualPush verifies that of two txns with equal
+// priorities and timestamps which push each other, exactly one
+// prevails, regardless of the order of the pushes.
+func TestInternalPushTxnMutualPush(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	for i, abort := range []bool{true, false} {
+		key := proto.Key(fmt.Sprintf("key-%d", i))
+		txn1 := newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+		txn2 := newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+		txn2.Priority = txn1.Priority
+		txn2.Timestamp = txn1.Timestamp
+
+		var successes int
+		for _, pair := range [][2]*proto.Transaction{{txn1, txn2}, {txn2, txn1}} {
+			args, reply := pushTxnArgs(pair[0], pair[1], abort, 1)
+			args.Timestamp = clock.Now()
+			if err := rng.AddCmd(proto.InternalPushTxn, args, reply, true); err == nil {
+				successes++
+			} else if _, ok := err.(*proto.TransactionPushError); !ok {
+				t.Errorf("%d: expected txn push error: %s", i, err)
+			}
+		}
+		if successes != 1 {
+			t.Errorf("%d: expected exactly one push to succeed; got %d", i, successes)
+		}
+	}
+}
+
 // TestInternalPushTxnPushTimestamp verifies that with args.Abort is
 // false (i.e. for read/write conflict), the pushed txn keeps status
 // PENDING, but has its txn Timestamp moved forward to the pusher's
@@ -1411,7 +2260,7 @@ func TestInternalPushTxnPushTimestampAlreadyPushed(t *testing.T) {
 	}
 }
 
-func verifyRangeStats(eng engine.Engine, rangeID int64, expMS engine.MVCCStats, t *testing.T) {
+func verifyRangeStats(eng engine.Engine, rangeID int64, expMS proto.MVCCStats, t *testing.T) {
 	ms, err := engine.GetRangeMVCCStats(eng, rangeID)
 	if err != nil {
 		t.Fatal(err)
@@ -1421,6 +2270,111 @@ func verifyRangeStats(eng engine.Engine, rangeID int64, expMS engine.MVCCStats,
 	}
 }
 
+// TestRangeAcquireLock verifies that AcquireLock requires a
+// transaction and that locks are released once the intents of the
+// transaction holding them are resolved.
+func TestRangeAcquireLock(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	lockArgs := func(txn *proto.Transaction) *proto.AcquireLockRequest {
+		return &proto.AcquireLockRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       proto.Key("a"),
+				Timestamp: clock.Now(),
+				Replica:   proto.Replica{RangeID: 1},
+				Txn:       txn,
+			},
+			Strength: proto.EXCLUSIVE,
+		}
+	}
+	if err := rng.AddCmd(proto.AcquireLock, lockArgs(nil), &proto.AcquireLockResponse{}, true); err == nil {
+		t.Error("expected error acquiring lock outside of a transaction")
+	}
+
+	txn1 := newTransaction("test1", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	txn2 := newTransaction("test2", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	if err := rng.AddCmd(proto.AcquireLock, lockArgs(txn1), &proto.AcquireLockResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	rArgs := &proto.InternalResolveIntentRequest{
+		RequestHeader: proto.RequestHeader{
+			Timestamp: txn1.Timestamp,
+			Key:       proto.Key("a"),
+			Replica:   proto.Replica{RangeID: 1},
+			Txn:       txn1,
+		},
+	}
+	rArgs.Txn.Status = proto.ABORTED
+	if err := rng.AddCmd(proto.InternalResolveIntent, rArgs, &proto.InternalResolveIntentResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is free, so acquisition doesn't wait for lockWaitTimeout.
+	start := time.Now()
+	if err := rng.AddCmd(proto.AcquireLock, lockArgs(txn2), &proto.AcquireLockResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= lockWaitTimeout {
+		t.Errorf("expected lock to be released; acquisition took %s", elapsed)
+	}
+}
+
+// TestRangeScanIntents verifies that InternalScanIntents returns the
+// write intents in its span, along with their transactions, and
+// stops after MaxResults intents.
+func TestRangeScanIntents(t *testing.T) {
+	rng, _, clock, _ := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	txn := newTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		pArgs, pReply := putArgs([]byte(key), []byte("value"), 1)
+		pArgs.Timestamp = clock.Now()
+		if key != "d" {
+			pArgs.Txn = txn
+		}
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		key, endKey proto.Key
+		maxResults  int64
+		expKeys     []string
+	}{
+		{proto.Key("a"), proto.Key("e"), 0, []string{"a", "b", "c"}},
+		{proto.Key("a"), proto.Key("e"), 2, []string{"a", "b"}},
+		{proto.Key("b"), proto.Key("d"), 0, []string{"b", "c"}},
+		{proto.Key("d"), proto.Key("e"), 0, nil},
+	}
+	for i, test := range testCases {
+		args := &proto.InternalScanIntentsRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       test.key,
+				EndKey:    test.endKey,
+				Timestamp: clock.Now(),
+				Replica:   proto.Replica{RangeID: 1},
+			},
+			MaxResults: test.maxResults,
+		}
+		reply := &proto.InternalScanIntentsResponse{}
+		if err := rng.AddCmd(proto.InternalScanIntents, args, reply, true); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(reply.Intents) != len(test.expKeys) {
+			t.Fatalf("%d: expected intents on %v; got %+v", i, test.expKeys, reply.Intents)
+		}
+		for j, intent := range reply.Intents {
+			if !intent.Key.Equal(proto.Key(test.expKeys[j])) || !bytes.Equal(intent.Txn.ID, txn.ID) {
+				t.Errorf("%d: unexpected intent %+v", i, intent)
+			}
+		}
+	}
+}
+
 // TestRangeStats verifies that commands executed against a range
 // update the range stat counters. The stat values are empirically
 // derived; we're really just testing that they increment in the right
@@ -1435,7 +2389,7 @@ func TestRangeStats(t *testing.T) {
 	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
 		t.Fatal(err)
 	}
-	expMS := engine.MVCCStats{LiveBytes: 44, KeyBytes: 20, ValBytes: 24, IntentBytes: 0, LiveCount: 1, KeyCount: 1, ValCount: 1, IntentCount: 0}
+	expMS := proto.MVCCStats{LiveBytes: 44, KeyBytes: 20, ValBytes: 24, IntentBytes: 0, LiveCount: 1, KeyCount: 1, ValCount: 1, IntentCount: 0}
 	verifyRangeStats(eng, rng.RangeID, expMS, t)
 
 	// Put a 2nd value transactionally.
@@ -1445,7 +2399,7 @@ func TestRangeStats(t *testing.T) {
 	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
 		t.Fatal(err)
 	}
-	expMS = engine.MVCCStats{LiveBytes: 118, KeyBytes: 40, ValBytes: 78, IntentBytes: 28, LiveCount: 2, KeyCount: 2, ValCount: 2, IntentCount: 1}
+	expMS = proto.MVCCStats{LiveBytes: 118, KeyBytes: 40, ValBytes: 78, IntentBytes: 28, LiveCount: 2, KeyCount: 2, ValCount: 2, IntentCount: 1}
 	verifyRangeStats(eng, rng.RangeID, expMS, t)
 
 	// Resolve the 2nd value.
@@ -1462,7 +2416,7 @@ func TestRangeStats(t *testing.T) {
 	if err := rng.AddCmd(proto.InternalResolveIntent, rArgs, rReply, true); err != nil {
 		t.Fatal(err)
 	}
-	expMS = engine.MVCCStats{LiveBytes: 88, KeyBytes: 40, ValBytes: 48, IntentBytes: 0, LiveCount: 2, KeyCount: 2, ValCount: 2, IntentCount: 0}
+	expMS = proto.MVCCStats{LiveBytes: 88, KeyBytes: 40, ValBytes: 48, IntentBytes: 0, LiveCount: 2, KeyCount: 2, ValCount: 2, IntentCount: 0}
 	verifyRangeStats(eng, rng.RangeID, expMS, t)
 
 	// Delete the 1st value.
@@ -1471,6 +2425,144 @@ func TestRangeStats(t *testing.T) {
 	if err := rng.AddCmd(proto.Delete, dArgs, dReply, true); err != nil {
 		t.Fatal(err)
 	}
-	expMS = engine.MVCCStats{LiveBytes: 44, KeyBytes: 56, ValBytes: 50, IntentBytes: 0, LiveCount: 1, KeyCount: 2, ValCount: 3, IntentCount: 0}
+	expMS = proto.MVCCStats{LiveBytes: 44, KeyBytes: 56, ValBytes: 50, IntentBytes: 0, LiveCount: 1, KeyCount: 2, ValCount: 3, IntentCount: 0}
 	verifyRangeStats(eng, rng.RangeID, expMS, t)
+
+	// The stats are also returned by InternalRangeStats.
+	sArgs := &proto.InternalRangeStatsRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       []byte("a"),
+			Timestamp: clock.Now(),
+			Replica:   proto.Replica{RangeID: 1},
+		},
+	}
+	sReply := &proto.InternalRangeStatsResponse{}
+	if err := rng.AddCmd(proto.InternalRangeStats, sArgs, sReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sReply.MVCCStats, expMS) {
+		t.Errorf("expected stats %+v; got %+v", expMS, sReply.MVCCStats)
+	}
+}
+
+// TestRangeGC verifies that InternalGC deletes only versions which
+// aren't visible at or after the GC threshold, that reads below the
+// threshold are rejected, and that the range stats stay consistent.
+func TestRangeGC(t *testing.T) {
+	rng, manual, _, eng := createTestRangeWithClock(t)
+	defer rng.Stop()
+
+	ts := func(secs float64) proto.Timestamp {
+		return proto.Timestamp{WallTime: int64(secs * float64(time.Second))}
+	}
+	// "a" is written at 1s and deleted at 2s; "b" is written at 1s
+	// and overwritten at 2s.
+	manual.Set(ts(2).WallTime)
+	for _, w := range []struct {
+		key, value string
+		at         proto.Timestamp
+	}{
+		{"a", "a1", ts(1)},
+		{"a", "", ts(2)},
+		{"b", "b1", ts(1)},
+		{"b", "b2", ts(2)},
+	} {
+		if w.value == "" {
+			dArgs, dReply := deleteArgs([]byte(w.key), 1)
+			dArgs.Timestamp = w.at
+			if err := rng.AddCmd(proto.Delete, dArgs, dReply, true); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		pArgs, pReply := putArgs([]byte(w.key), []byte(w.value), 1)
+		pArgs.Timestamp = w.at
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gc := func(threshold proto.Timestamp) (int64, error) {
+		gcArgs := &proto.InternalGCRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       engine.KeyMin,
+				EndKey:    engine.KeyMax,
+				Timestamp: threshold,
+				Replica:   proto.Replica{RangeID: 1},
+			},
+			GCThreshold: threshold,
+		}
+		gcReply := &proto.InternalGCResponse{}
+		err := rng.AddCmd(proto.InternalGC, gcArgs, gcReply, true)
+		return gcReply.Deleted, err
+	}
+	get := func(key string, at proto.Timestamp) ([]byte, error) {
+		gArgs, gReply := getArgs([]byte(key), 1)
+		gArgs.Timestamp = at
+		if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+			return nil, err
+		}
+		if gReply.Value == nil {
+			return nil, nil
+		}
+		return gReply.Value.Bytes, nil
+	}
+	verifyStats := func() {
+		ms, err := engine.MVCCComputeStats(eng, engine.KeyLocalMax, engine.KeyMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyRangeStats(eng, rng.RangeID, ms, t)
+	}
+
+	// A threshold in the future is refused.
+	if _, err := gc(ts(3)); err == nil {
+		t.Error("expected error for GC threshold in the future")
+	}
+
+	// At 1.5s, the deleted and overwritten values are still visible.
+	if deleted, err := gc(ts(1.5)); err != nil || deleted != 0 {
+		t.Fatalf("expected nothing deleted; got %d, %v", deleted, err)
+	}
+	verifyStats()
+	for _, r := range []struct {
+		key string
+		exp string
+	}{
+		{"a", "a1"},
+		{"b", "b1"},
+	} {
+		if v, err := get(r.key, ts(1.5)); err != nil || string(v) != r.exp {
+			t.Errorf("expected %q at 1.5s for %q; got %q, %v", r.exp, r.key, v, err)
+		}
+	}
+	if _, err := get("a", ts(1)); err == nil {
+		t.Error("expected error reading before the GC threshold")
+	} else if _, ok := err.(*proto.ReadBeforeGCThresholdError); !ok {
+		t.Errorf("expected ReadBeforeGCThresholdError; got %T: %s", err, err)
+	}
+
+	// At 2s, "a" is gone altogether and "b" keeps only its latest value.
+	manual.Set(ts(3).WallTime)
+	if deleted, err := gc(ts(2)); err != nil || deleted != 3 {
+		t.Fatalf("expected 3 versions deleted; got %d, %v", deleted, err)
+	}
+	verifyStats()
+	if v, err := get("a", ts(2)); err != nil || v != nil {
+		t.Errorf("expected no value for \"a\"; got %q, %v", v, err)
+	}
+	if v, err := get("b", ts(2)); err != nil || string(v) != "b2" {
+		t.Errorf("expected \"b2\"; got %q, %v", v, err)
+	}
+
+	// The threshold never moves backwards.
+	if _, err := gc(ts(1)); err != nil {
+		t.Fatal(err)
+	}
+	if threshold := rng.GCThreshold(); !threshold.Equal(ts(2)) {
+		t.Errorf("expected GC threshold 2s; got %s", threshold)
+	}
+	if threshold, err := engine.GetRangeGCThreshold(eng, rng.RangeID); err != nil || !threshold.Equal(ts(2)) {
+		t.Errorf("expected persisted GC threshold 2s; got %s, %v", threshold, err)
+	}
 }
@@ -0,0 +1,89 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// maxScanWait caps the duration for which a scan waits for a write to
+// its key span.
+const maxScanWait = 1 * time.Minute
+
+// A writeWaiter is a scan waiting for a write to its key span. c is
+// closed by the first overlapping write.
+type writeWaiter struct {
+	start, end proto.Key
+	c          chan struct{}
+}
+
+// overlaps returns true if the waiter's key span overlaps the span
+// from start to end. If end is empty, the span covers start only.
+func (w *writeWaiter) overlaps(start, end proto.Key) bool {
+	if len(end) == 0 {
+		end = start.Next()
+	}
+	return w.start.Less(end) && start.Less(w.end)
+}
+
+// waitForWrite blocks a scan of the span from start to end until a
+// key in the span is written at a timestamp above ts, the timeout
+// elapses or the range is stopped. Writes are tracked through the
+// timestamp cache, so a write which committed before the wait began
+// returns at once. As the timestamp cache is coarse once its entries
+// are evicted, the scan may return without a write to the span; the
+// caller compares the results with those of its previous scan.
+func (r *Range) waitForWrite(start, end proto.Key, ts proto.Timestamp, timeout time.Duration) {
+	if timeout > maxScanWait {
+		timeout = maxScanWait
+	}
+	w := &writeWaiter{start: start, end: end, c: make(chan struct{})}
+	r.Lock()
+	if _, wTS := r.tsCache.GetMax(start, end, proto.NoTxnMD5); ts.Less(wTS) {
+		r.Unlock()
+		return
+	}
+	if r.writeWaiters == nil {
+		r.writeWaiters = map[*writeWaiter]struct{}{}
+	}
+	r.writeWaiters[w] = struct{}{}
+	r.Unlock()
+
+	select {
+	case <-w.c:
+	case <-time.After(timeout):
+	case <-r.closer:
+	case <-r.rm.Stopper().ShouldStop():
+	}
+	r.Lock()
+	delete(r.writeWaiters, w)
+	r.Unlock()
+}
+
+// notifyWriteWaitersLocked wakes the scans waiting for writes to spans
+// overlapping the span from start to end. r.Lock must be held.
+func (r *Range) notifyWriteWaitersLocked(start, end proto.Key) {
+	for w := range r.writeWaiters {
+		if w.overlaps(start, end) {
+			close(w.c)
+			delete(r.writeWaiters, w)
+		}
+	}
+}
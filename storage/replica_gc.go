@@ -0,0 +1,163 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// replicaGCInterval is the interval at which stores verify that
+// their replicas still belong to their ranges.
+const replicaGCInterval = 10 * time.Minute
+
+// gcReplicas deletes the replicas of this store which have been
+// removed from their ranges every replicaGCInterval, until the
+// stopper is signaled. A replica removed while its store is down, or
+// which misses its removal, otherwise keeps its data on disk forever.
+func (s *Store) gcReplicas() {
+	ticker := time.NewTicker(replicaGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !s.stopper.RunTask(s.gcReplicasOnce) {
+				return
+			}
+		case <-s.stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// gcReplicasOnce looks up the canonical descriptor of the range of
+// each replica on this store and deletes the replicas which it no
+// longer includes.
+func (s *Store) gcReplicasOnce() {
+	s.mu.RLock()
+	ranges := make([]*Range, 0, len(s.ranges))
+	for _, rng := range s.ranges {
+		ranges = append(ranges, rng)
+	}
+	s.mu.RUnlock()
+	for _, rng := range ranges {
+		removed, err := s.isReplicaGCable(rng)
+		if err != nil {
+			log.Warningf("unable to look up descriptor of range %d: %s", rng.RangeID, err)
+			continue
+		}
+		if !removed {
+			continue
+		}
+		if err := s.gcReplica(rng); err != nil {
+			log.Warningf("unable to delete removed replica %d: %s", rng.RangeID, err)
+		}
+	}
+}
+
+// isReplicaGCable returns whether the replica has been removed from
+// its range, according to the range's descriptor as read from the
+// range addressing records. The lookup is consistent, failing rather
+// than returning a descriptor which is being changed. A replica which
+// merely missed a split of its range is still included by the
+// descriptor of the range containing its start key, and is kept. The
+// first range isn't addressed by meta records, so its descriptor is
+// read from the range itself.
+func (s *Store) isReplicaGCable(rng *Range) (bool, error) {
+	rng.RLock()
+	startKey := rng.Desc.StartKey
+	rng.RUnlock()
+	metaKey := engine.RangeMetaKey(startKey)
+	if len(metaKey) == 0 {
+		return s.isFirstReplicaGCable(rng)
+	}
+	reply := &proto.InternalRangeLookupResponse{}
+	if err := s.db.Call(proto.InternalRangeLookup, &proto.InternalRangeLookupRequest{
+		RequestHeader: proto.RequestHeader{
+			Timestamp: s.clock.Now(),
+			Key:       metaKey,
+			User:      UserRoot,
+		},
+		MaxRanges: 1,
+	}, reply); err != nil {
+		return false, err
+	}
+	for _, replica := range reply.Ranges[0].Replicas {
+		if replica.StoreID == s.Ident.StoreID && replica.RangeID == rng.RangeID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isFirstReplicaGCable returns whether the replica of the first range
+// has been removed from it, according to the range's descriptor as
+// read consistently from the range.
+func (s *Store) isFirstReplicaGCable(rng *Range) (bool, error) {
+	reply := &proto.GetResponse{}
+	if err := s.db.Call(proto.Get, &proto.GetRequest{
+		RequestHeader: proto.RequestHeader{
+			Timestamp: s.clock.Now(),
+			Key:       makeRangeKey(engine.KeyMin),
+			User:      UserRoot,
+		},
+	}, reply); err != nil {
+		return false, err
+	}
+	if reply.Value == nil {
+		return false, nil
+	}
+	desc := &proto.RangeDescriptor{}
+	if err := gogoproto.Unmarshal(reply.Value.Bytes, desc); err != nil {
+		return false, err
+	}
+	for _, replica := range desc.Replicas {
+		if replica.StoreID == s.Ident.StoreID && replica.RangeID == rng.RangeID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// gcReplica removes the replica from the store, leaving a tombstone
+// in its place, and deletes its data and range-local keys. The
+// replica's stats are subtracted from those of the store.
+func (s *Store) gcReplica(rng *Range) error {
+	ms, err := engine.GetRangeMVCCStats(s.engine, rng.RangeID)
+	if err != nil {
+		return err
+	}
+	if err := s.RemoveRange(rng); err != nil {
+		return err
+	}
+	if err := rng.Destroy(); err != nil {
+		return err
+	}
+	var delta proto.MVCCStats
+	delta.Subtract(*ms)
+	if err := engine.MergeMVCCStats(s.engine, &delta, 0, s.Ident.StoreID); err != nil {
+		return err
+	}
+	s.metrics.replicasGCed.Inc(1)
+	log.Infof("deleted replica %d of range %d removed from store %d", rng.RangeID, rng.Desc.RaftID, s.Ident.StoreID)
+	return nil
+}
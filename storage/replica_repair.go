@@ -0,0 +1,92 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// placementRepairInterval is the interval at which stores verify the
+// replica placement of the ranges they lead against the constraints
+// of their zones.
+const placementRepairInterval = 1 * time.Minute
+
+// repairReplicaPlacement verifies the replicas of the ranges this
+// store leads against the placement constraints of their zones every
+// placementRepairInterval, until the stopper is signaled. Replicas
+// may violate constraints which were added to a zone after they were
+// placed, or when nodes are restarted with a new locality.
+func (s *Store) repairReplicaPlacement() {
+	ticker := time.NewTicker(placementRepairInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !s.stopper.RunTask(s.repairReplicaPlacementOnce) {
+				return
+			}
+		case <-s.stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// repairReplicaPlacementOnce finds a replacement for a violating
+// replica of each range this store leads and records the number of
+// ranges with violations. Repairs are only reported, not carried out.
+//
+// TODO(agent): carry out the repair once a replacement replica can be
+// seeded with a snapshot of its range, adding the replacement before
+// removing the violating replica, one change at a time (see
+// changeReplicasTrigger).
+func (s *Store) repairReplicaPlacementOnce() {
+	s.mu.RLock()
+	ranges := make([]*Range, 0, len(s.ranges))
+	for _, rng := range s.ranges {
+		ranges = append(ranges, rng)
+	}
+	s.mu.RUnlock()
+	var violations int64
+	for _, rng := range ranges {
+		zone, ok := rng.zoneConfig()
+		if !ok || len(zone.Constraints) == 0 {
+			continue
+		}
+		rng.RLock()
+		replicas := append([]proto.Replica(nil), rng.Desc.Replicas...)
+		rng.RUnlock()
+		repair, err := s.allocator.repair(zone, replicas)
+		switch {
+		case repair != nil && err != nil:
+			log.Warningf("range %d: replica on store %d violates constraint %s and can't be replaced: %s",
+				rng.RangeID, repair.remove.StoreID, repair.constraint, err)
+		case repair != nil:
+			log.Warningf("range %d: replica on store %d violates constraint %s; replace with a replica on store %d",
+				rng.RangeID, repair.remove.StoreID, repair.constraint, repair.add.StoreID)
+		case err != nil:
+			log.Warningf("unable to verify replica placement of range %d: %s", rng.RangeID, err)
+		}
+		if repair != nil {
+			violations++
+		}
+	}
+	s.metrics.constraintViolations.Update(violations)
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// remoteIntents returns the intents of args which lie outside of the
+// range, and so aren't resolved along with the transaction record.
+func (r *Range) remoteIntents(args *proto.EndTransactionRequest) []proto.Intent {
+	var remote []proto.Intent
+	for _, intent := range args.Intents {
+		if !r.ContainsKeyRange(intent.Key, intent.EndKey) {
+			remote = append(remote, intent)
+		}
+	}
+	return remote
+}
+
+// maybeResolveRemoteIntents dispatches the resolution of the intents
+// supplied with a successful EndTransaction which lie outside of the
+// range. Only the leader dispatches them, in bulk as a single async
+// task, so that the coordinator needn't issue a request for each
+// intent once the transaction ends. Resolution is best effort:
+// intents it misses are resolved by the commands which encounter them
+// or by the leader's scan for intents of abandoned transactions.
+func (r *Range) maybeResolveRemoteIntents(args *proto.EndTransactionRequest, reply *proto.EndTransactionResponse) {
+	db := r.rm.DB()
+	if db == nil || reply.Txn == nil || !r.IsLeader() {
+		return
+	}
+	remote := r.remoteIntents(args)
+	if len(remote) == 0 {
+		return
+	}
+	txn := gogoproto.Clone(reply.Txn).(*proto.Transaction)
+	r.rm.Stopper().RunAsyncTask(func() {
+		for _, intent := range remote {
+			call := &client.Call{
+				Method: proto.InternalResolveIntent,
+				Args: &proto.InternalResolveIntentRequest{
+					RequestHeader: proto.RequestHeader{
+						Timestamp: txn.Timestamp,
+						Key:       intent.Key,
+						EndKey:    intent.EndKey,
+						User:      UserRoot,
+						Txn:       txn,
+					},
+				},
+				Reply: &proto.InternalResolveIntentResponse{},
+			}
+			db.Sender().Send(call)
+			if err := call.Reply.Header().GoError(); err != nil {
+				log.Warningf("failed to resolve intent %q of txn %s: %s", intent.Key, txn, err)
+			}
+		}
+	})
+}
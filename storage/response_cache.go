@@ -20,14 +20,30 @@ package storage
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/encoding"
+	"github.com/cockroachdb/cockroach/util/log"
 )
 
+// DefaultSessionTTL is the duration of inactivity after which the
+// records of a client session are garbage collected from the response
+// caches of the ranges it issued commands to.
+const DefaultSessionTTL = 24 * time.Hour
+
+// sessionTouchInterval is the interval at which the activity time of
+// an active session's record is refreshed, even if its acknowledged
+// sequence number doesn't advance.
+const sessionTouchInterval = 10 * time.Minute
+
+// sessionGCInterval is the interval at which stores garbage collect
+// the records of inactive client sessions.
+const sessionGCInterval = 1 * time.Hour
+
 type cmdIDKey struct {
 	walltime, random int64
 }
@@ -49,6 +65,17 @@ func makeCmdIDKey(cmdID proto.ClientCmdID) cmdIDKey {
 // keys derived from KeyLocalResponseCachePrefix, range ID and the
 // ClientCmdID.
 //
+// Commands issued within a client session additionally report the
+// sequence number through which the session's commands have been
+// acknowledged. The ResponseCache persists the highest such number
+// for each session using keys derived from
+// KeyLocalResponseCacheSessionPrefix, range ID and session ID. A
+// command at or below the acknowledged sequence number whose response
+// is no longer cached is a stale replay and is rejected with a
+// CommandReplayError instead of being executed a second time.
+// Session records are garbage collected once their session has been
+// inactive for DefaultSessionTTL (see GCSessions).
+//
 // A ResponseCache is safe for concurrent access.
 type ResponseCache struct {
 	rangeID  int64
@@ -80,13 +107,17 @@ func (rc *ResponseCache) ClearInflight() {
 	rc.inflight = map[cmdIDKey]*sync.Cond{}
 }
 
-// ClearData removes all items stored in the persistent cache. It does not alter
-// the inflight map.
+// ClearData removes all items stored in the persistent cache,
+// including client session records. It does not alter the inflight
+// map.
 func (rc *ResponseCache) ClearData() error {
-	p := responseCacheKeyPrefix(rc.rangeID)
-	end := p.PrefixEnd()
-	_, err := engine.ClearRange(rc.engine, engine.MVCCEncodeKey(p), engine.MVCCEncodeKey(end))
-	return err
+	for _, p := range []proto.Key{responseCacheKeyPrefix(rc.rangeID), responseCacheSessionKeyPrefix(rc.rangeID)} {
+		end := p.PrefixEnd()
+		if _, err := engine.ClearRange(rc.engine, engine.MVCCEncodeKey(p), engine.MVCCEncodeKey(end)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetResponse looks up a response matching the specified cmdID and
@@ -94,7 +125,9 @@ func (rc *ResponseCache) ClearData() error {
 // supplied reply parameter. If no response is found, returns
 // false. If a command is pending already for the cmdID, then this
 // method will block until the the command is completed or the
-// response cache is cleared.
+// response cache is cleared. If no response is found and the command
+// is a replay of a command its client session has already
+// acknowledged, returns a CommandReplayError.
 func (rc *ResponseCache) GetResponse(cmdID proto.ClientCmdID, reply interface{}) (bool, error) {
 	// Do nothing if command ID is empty.
 	if cmdID.IsEmpty() {
@@ -129,6 +162,19 @@ func (rc *ResponseCache) GetResponse(cmdID proto.ClientCmdID, reply interface{})
 		}
 		return ok, err
 	}
+	// If the command's session has acknowledged it, this is a stale replay.
+	if cmdID.SessionID != 0 {
+		ackSeq, err := rc.getSessionAckSeq(cmdID.SessionID)
+		if err == nil && cmdID.Seq <= ackSeq {
+			err = proto.NewCommandReplayError(cmdID.SessionID, cmdID.Seq, ackSeq)
+		}
+		if err != nil {
+			rc.Lock()
+			defer rc.Unlock()
+			rc.removeInflightLocked(cmdID)
+			return false, err
+		}
+	}
 	// There's no command result cached for this ID; but inflight was added above.
 	return false, nil
 }
@@ -144,7 +190,7 @@ func (rc *ResponseCache) CopyInto(e engine.Engine, destRangeID int64) error {
 	start := engine.MVCCEncodeKey(prefix)
 	end := engine.MVCCEncodeKey(prefix.PrefixEnd())
 
-	return rc.engine.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+	if err := rc.engine.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
 		// Decode the key into a cmd, skipping on error. Otherwise,
 		// write it to the corresponding key in the new cache.
 		cmdID, err := rc.decodeKey(kv.Key)
@@ -153,6 +199,22 @@ func (rc *ResponseCache) CopyInto(e engine.Engine, destRangeID int64) error {
 		}
 		encKey := engine.MVCCEncodeKey(responseCacheKey(destRangeID, cmdID))
 		return false, e.Put(encKey, kv.Value)
+	}); err != nil {
+		return err
+	}
+
+	// Copy the client session records.
+	prefix = responseCacheSessionKeyPrefix(rc.rangeID)
+	start = engine.MVCCEncodeKey(prefix)
+	end = engine.MVCCEncodeKey(prefix.PrefixEnd())
+
+	return rc.engine.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		sessionID, err := decodeSessionKey(kv.Key)
+		if err != nil {
+			return false, util.Errorf("could not decode a response cache session key %q: %s", kv.Key, err)
+		}
+		encKey := engine.MVCCEncodeKey(responseCacheSessionKey(destRangeID, sessionID))
+		return false, e.Put(encKey, kv.Value)
 	})
 }
 
@@ -171,6 +233,10 @@ func (rc *ResponseCache) PutResponse(cmdID proto.ClientCmdID, reply interface{})
 	rwResp := &proto.ReadWriteCmdResponse{}
 	rwResp.SetValue(reply)
 	_, _, err := engine.PutProto(rc.engine, encKey, rwResp)
+	// Advance the session's acknowledged sequence number.
+	if err == nil && cmdID.SessionID != 0 {
+		err = rc.maybeAdvanceSession(cmdID)
+	}
 
 	// Take lock after writing response to cache!
 	rc.Lock()
@@ -181,6 +247,81 @@ func (rc *ResponseCache) PutResponse(cmdID proto.ClientCmdID, reply interface{})
 	return err
 }
 
+// getSession returns the record persisted for the specified client
+// session, which is empty if none.
+func (rc *ResponseCache) getSession(sessionID int64) (proto.ResponseCacheSession, error) {
+	session := proto.ResponseCacheSession{}
+	encKey := engine.MVCCEncodeKey(responseCacheSessionKey(rc.rangeID, sessionID))
+	_, _, _, err := engine.GetProto(rc.engine, encKey, &session)
+	return session, err
+}
+
+// getSessionAckSeq returns the acknowledged sequence number persisted
+// for the specified client session, or zero if none.
+func (rc *ResponseCache) getSessionAckSeq(sessionID int64) (int64, error) {
+	session, err := rc.getSession(sessionID)
+	return session.AckSeq, err
+}
+
+// maybeAdvanceSession persists the acknowledged sequence number of the
+// command's client session if it exceeds the currently persisted
+// value. Commands from a session may arrive out of order, so the
+// acknowledged sequence number is never moved backwards. The record's
+// activity time is refreshed along with it, and at least once per
+// sessionTouchInterval while the session issues commands, so that the
+// records of active sessions aren't garbage collected.
+func (rc *ResponseCache) maybeAdvanceSession(cmdID proto.ClientCmdID) error {
+	cur, err := rc.getSession(cmdID.SessionID)
+	if err != nil {
+		return err
+	}
+	advanced := cmdID.AckSeq > cur.AckSeq
+	if !advanced && cmdID.WallTime-cur.LastActiveNanos < sessionTouchInterval.Nanoseconds() {
+		return nil
+	}
+	next := cur
+	if advanced {
+		next.AckSeq = cmdID.AckSeq
+	}
+	if cmdID.WallTime > next.LastActiveNanos {
+		next.LastActiveNanos = cmdID.WallTime
+	}
+	encKey := engine.MVCCEncodeKey(responseCacheSessionKey(rc.rangeID, cmdID.SessionID))
+	_, _, err = engine.PutProto(rc.engine, encKey, &next)
+	return err
+}
+
+// GCSessions deletes the records of client sessions which have been
+// inactive since before the specified wall time, in nanoseconds.
+// Commands of a deleted session are no longer protected against stale
+// replays, so clients must not retry commands older than the session
+// TTL. Returns the number of records deleted.
+func (rc *ResponseCache) GCSessions(inactiveBefore int64) (int, error) {
+	rc.Lock()
+	defer rc.Unlock()
+	prefix := responseCacheSessionKeyPrefix(rc.rangeID)
+	var expired []proto.EncodedKey
+	if err := rc.engine.Iterate(engine.MVCCEncodeKey(prefix), engine.MVCCEncodeKey(prefix.PrefixEnd()),
+		func(kv proto.RawKeyValue) (bool, error) {
+			session := proto.ResponseCacheSession{}
+			if err := gogoproto.Unmarshal(kv.Value, &session); err != nil {
+				return false, err
+			}
+			if session.LastActiveNanos < inactiveBefore {
+				expired = append(expired, kv.Key)
+			}
+			return false, nil
+		}); err != nil {
+		return 0, err
+	}
+	for _, key := range expired {
+		if err := rc.engine.Clear(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
 // addInflightLocked adds the supplied ClientCmdID to the inflight
 // map. Any subsequent invocations of GetResponse for the same client
 // command will block on the inflight cond var until either the
@@ -222,6 +363,41 @@ func responseCacheKey(rangeID int64, cmdID proto.ClientCmdID) proto.Key {
 	return b
 }
 
+// responseCacheSessionKeyPrefix generates the prefix under which all
+// client session records for the given range are stored in the
+// engine.
+func responseCacheSessionKeyPrefix(rangeID int64) proto.Key {
+	b := append([]byte(nil), engine.KeyLocalResponseCacheSessionPrefix...)
+	return encoding.EncodeInt(b, rangeID)
+}
+
+// responseCacheSessionKey encodes the range ID and client session ID
+// into a key for storage in the underlying engine.
+func responseCacheSessionKey(rangeID, sessionID int64) proto.Key {
+	return encoding.EncodeInt(responseCacheSessionKeyPrefix(rangeID), sessionID)
+}
+
+// decodeSessionKey decodes the client session ID from an encoded
+// session record key.
+func decodeSessionKey(encKey []byte) (int64, error) {
+	key, _, isValue := engine.MVCCDecodeKey(encKey)
+	if isValue {
+		return 0, util.Errorf("key %q is not a raw MVCC value", encKey)
+	}
+	minLen := len(engine.KeyLocalResponseCacheSessionPrefix)
+	if len(key) < minLen {
+		return 0, util.Errorf("key not long enough to be decoded: %q", key)
+	}
+	// Cut the prefix and the range ID, then read the session ID.
+	b := key[minLen:]
+	b, _ = encoding.DecodeInt(b)
+	b, sessionID := encoding.DecodeInt(b)
+	if len(b) > 0 {
+		return 0, util.Errorf("key %q has leftover bytes after decode: %q; indicates corrupt key", encKey, b)
+	}
+	return sessionID, nil
+}
+
 func (rc *ResponseCache) decodeKey(encKey []byte) (proto.ClientCmdID, error) {
 	ret := proto.ClientCmdID{}
 	key, _, isValue := engine.MVCCDecodeKey(encKey)
@@ -246,3 +422,42 @@ func (rc *ResponseCache) decodeKey(encKey []byte) (proto.ClientCmdID, error) {
 	ret.Random = rd
 	return ret, nil
 }
+
+// gcSessions deletes the records of client sessions which have been
+// inactive for longer than DefaultSessionTTL from the response caches
+// of the store's replicas every sessionGCInterval, until the stopper
+// is signaled.
+func (s *Store) gcSessions() {
+	ticker := time.NewTicker(sessionGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !s.stopper.RunTask(s.gcSessionsOnce) {
+				return
+			}
+		case <-s.stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// gcSessionsOnce deletes the records of client sessions inactive
+// since before DefaultSessionTTL ago.
+func (s *Store) gcSessionsOnce() {
+	inactiveBefore := s.clock.PhysicalNow() - DefaultSessionTTL.Nanoseconds()
+	s.mu.RLock()
+	ranges := make([]*Range, 0, len(s.ranges))
+	for _, rng := range s.ranges {
+		ranges = append(ranges, rng)
+	}
+	s.mu.RUnlock()
+	for _, rng := range ranges {
+		count, err := rng.respCache.GCSessions(inactiveBefore)
+		if err != nil {
+			log.Warningf("unable to garbage collect client sessions of range %d: %s", rng.RangeID, err)
+			continue
+		}
+		s.metrics.sessionsGCed.Inc(int64(count))
+	}
+}
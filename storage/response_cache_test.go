@@ -116,6 +116,107 @@ func TestResposeCacheCopyInto(t *testing.T) {
 
 }
 
+// TestResponseCacheSessionReplay verifies that commands issued within
+// a client session are rejected once the session has acknowledged
+// them and their responses are no longer cached, and that session
+// records are copied and cleared along with cached responses.
+func TestResponseCacheSessionReplay(t *testing.T) {
+	rc := createTestResponseCache(t, 1)
+	makeSessionCmdID := func(random, seq, ackSeq int64) proto.ClientCmdID {
+		cmdID := makeCmdID(1, random)
+		cmdID.SessionID, cmdID.Seq, cmdID.AckSeq = 1, seq, ackSeq
+		return cmdID
+	}
+	val := proto.IncrementResponse{}
+	cmd1, cmd2, cmd3 := makeSessionCmdID(1, 1, 0), makeSessionCmdID(2, 2, 0), makeSessionCmdID(3, 3, 1)
+
+	// Apply the first two commands, and the third which acknowledges the first.
+	for _, cmdID := range []proto.ClientCmdID{cmd1, cmd2, cmd3} {
+		if ok, err := rc.GetResponse(cmdID, &val); ok || err != nil {
+			t.Fatalf("expected no response for id %+v; got %+v, %v", cmdID, val, err)
+		}
+		if err := rc.PutResponse(cmdID, &incR); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An acknowledged command with a cached response is a normal replay.
+	if ok, err := rc.GetResponse(cmd1, &val); !ok || err != nil {
+		t.Errorf("expected cached response for %+v; got %t, %v", cmd1, ok, err)
+	}
+
+	// Remove the cached responses, as garbage collection would.
+	for _, cmdID := range []proto.ClientCmdID{cmd1, cmd2} {
+		if err := rc.engine.Clear(engine.MVCCEncodeKey(responseCacheKey(rc.rangeID, cmdID))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The acknowledged command is rejected; the unacknowledged is not.
+	if _, err := rc.GetResponse(cmd1, &val); err == nil {
+		t.Errorf("expected replay of acknowledged command %+v to be rejected", cmd1)
+	} else if _, ok := err.(*proto.CommandReplayError); !ok {
+		t.Errorf("expected CommandReplayError; got %v", err)
+	}
+	if ok, err := rc.GetResponse(cmd2, &val); ok || err != nil {
+		t.Errorf("expected no response for id %+v; got %t, %v", cmd2, ok, err)
+	}
+	rc.ClearInflight()
+
+	// A command arriving out of order never moves the ack backwards.
+	if err := rc.PutResponse(makeSessionCmdID(4, 4, 0), &incR); err != nil {
+		t.Fatal(err)
+	}
+	if ackSeq, err := rc.getSessionAckSeq(1); err != nil || ackSeq != 1 {
+		t.Errorf("expected ack seq 1; got %d, %v", ackSeq, err)
+	}
+
+	// Session records are copied to a split range and cleared with data.
+	rc2 := createTestResponseCache(t, 2)
+	if err := rc.CopyInto(rc2.engine, rc2.rangeID); err != nil {
+		t.Fatal(err)
+	}
+	if ackSeq, err := rc2.getSessionAckSeq(1); err != nil || ackSeq != 1 {
+		t.Errorf("expected copied ack seq 1; got %d, %v", ackSeq, err)
+	}
+	if err := rc.ClearData(); err != nil {
+		t.Fatal(err)
+	}
+	if ackSeq, err := rc.getSessionAckSeq(1); err != nil || ackSeq != 0 {
+		t.Errorf("expected cleared ack seq; got %d, %v", ackSeq, err)
+	}
+}
+
+// TestResponseCacheGCSessions verifies that the activity time of
+// session records is refreshed by their commands and that records of
+// inactive sessions are garbage collected.
+func TestResponseCacheGCSessions(t *testing.T) {
+	rc := createTestResponseCache(t, 1)
+	hour := time.Hour.Nanoseconds()
+	put := func(sessionID, wallTime, seq, ackSeq int64) {
+		cmdID := makeCmdID(wallTime, seq)
+		cmdID.SessionID, cmdID.Seq, cmdID.AckSeq = sessionID, seq, ackSeq
+		if err := rc.PutResponse(cmdID, &incR); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(1, 1*hour, 2, 1)
+	put(2, 1*hour, 2, 1)
+	// Session 2 remains active without acknowledging more commands.
+	put(2, 3*hour, 3, 1)
+	if session, err := rc.getSession(2); err != nil || session.LastActiveNanos != 3*hour {
+		t.Errorf("expected session 2 to be active at %d; got %+v, %v", 3*hour, session, err)
+	}
+
+	if count, err := rc.GCSessions(2 * hour); err != nil || count != 1 {
+		t.Fatalf("expected 1 session to be deleted; got %d, %v", count, err)
+	}
+	if ackSeq, err := rc.getSessionAckSeq(1); err != nil || ackSeq != 0 {
+		t.Errorf("expected session 1 to be deleted; got ack seq %d, %v", ackSeq, err)
+	}
+	if ackSeq, err := rc.getSessionAckSeq(2); err != nil || ackSeq != 1 {
+		t.Errorf("expected session 2 to be retained; got ack seq %d, %v", ackSeq, err)
+	}
+}
+
 // TestResponseCacheInflight verifies GetResponse invocations block on
 // inflight requests.
 func TestResponseCacheInflight(t *testing.T) {
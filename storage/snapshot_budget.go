@@ -0,0 +1,254 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// snapshotReservationTTL is the duration after which a snapshot
+// budget reservation which hasn't been renewed expires, so that the
+// bytes reserved for snapshots abandoned by a failed peer are
+// reclaimed.
+const snapshotReservationTTL = 1 * time.Minute
+
+// maxReservedCapacityFraction is the fraction of a store's capacity
+// beyond which the store refuses to reserve space for incoming
+// snapshots.
+const maxReservedCapacityFraction = 0.95
+
+// defaultMaxStoreReservations is the number of incoming snapshots for
+// which a store holds reservations at once.
+const defaultMaxStoreReservations = 4
+
+// A SnapshotBudget bounds the bytes of range snapshots which a node
+// sends or receives concurrently, and is shared by the node's stores.
+// Bytes are reserved ahead of each snapshot and released once it
+// completes. After a node failure, the surviving replicas of each
+// affected range are asked for snapshots at once; budgets spread the
+// resulting transfers out over time instead of overloading the nodes
+// involved.
+type SnapshotBudget struct {
+	maxBytes int64 // 0 for unlimited
+
+	mu           sync.Mutex
+	used         int64
+	reservations map[string]snapshotReservation
+}
+
+type snapshotReservation struct {
+	bytes      int64
+	expiration time.Time
+}
+
+// NewSnapshotBudget returns a budget allowing at most maxBytes of
+// snapshots to be reserved concurrently. Zero places no limit.
+func NewSnapshotBudget(maxBytes int64) *SnapshotBudget {
+	return &SnapshotBudget{
+		maxBytes:     maxBytes,
+		reservations: map[string]snapshotReservation{},
+	}
+}
+
+// reserve reserves bytes for the snapshot identified by id until
+// snapshotReservationTTL past now, returning false if they don't fit
+// within the budget. Reserving an existing id renews the reservation
+// with the new size. A snapshot larger than the entire budget is
+// granted while no other reservation is held, so that it isn't
+// refused indefinitely.
+func (b *SnapshotBudget) reserve(id string, bytes int64, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= expireReservations(b.reservations, now)
+	used := b.used
+	if res, ok := b.reservations[id]; ok {
+		used -= res.bytes
+	}
+	if b.maxBytes > 0 && used > 0 && used+bytes > b.maxBytes {
+		return false
+	}
+	b.reservations[id] = snapshotReservation{bytes: bytes, expiration: now.Add(snapshotReservationTTL)}
+	b.used = used + bytes
+	return true
+}
+
+// renew extends the reservation identified by id until
+// snapshotReservationTTL past now, if it's held.
+func (b *SnapshotBudget) renew(id string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if res, ok := b.reservations[id]; ok {
+		res.expiration = now.Add(snapshotReservationTTL)
+		b.reservations[id] = res
+	}
+}
+
+// release releases the reservation identified by id, if it's held.
+func (b *SnapshotBudget) release(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if res, ok := b.reservations[id]; ok {
+		b.used -= res.bytes
+		delete(b.reservations, id)
+	}
+}
+
+// expireReservations deletes the reservations which have expired as
+// of now, returning the bytes they held.
+func expireReservations(reservations map[string]snapshotReservation, now time.Time) int64 {
+	var expired int64
+	for id, res := range reservations {
+		if !now.Before(res.expiration) {
+			expired += res.bytes
+			delete(reservations, id)
+		}
+	}
+	return expired
+}
+
+// storeReservations tracks the space reserved on a store for the
+// snapshots of new replicas. The gossiped capacity of a store only
+// reflects a snapshot once it's written, so allocators on many nodes
+// may pick the same store at once; reservations are made with the
+// store itself ahead of each snapshot and refused once they would
+// fill the store, or once too many snapshots are already inbound.
+// Unlike a SnapshotBudget, which bounds the bytes a node has in
+// flight, reservations hold space for the snapshot's whole lifetime
+// on disk.
+type storeReservations struct {
+	maxReservations int
+
+	mu           sync.Mutex
+	reserved     int64
+	reservations map[string]snapshotReservation
+}
+
+func newStoreReservations(maxReservations int) *storeReservations {
+	return &storeReservations{
+		maxReservations: maxReservations,
+		reservations:    map[string]snapshotReservation{},
+	}
+}
+
+// reserve reserves bytes of the store's capacity for the snapshot
+// identified by id until snapshotReservationTTL past now, returning
+// false if the store already holds the maximum number of reservations
+// or if the reservation would leave the store more than
+// maxReservedCapacityFraction full. Reserving an existing id renews
+// the reservation with the new size.
+func (r *storeReservations) reserve(id string, bytes int64, capacity engine.StoreCapacity, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reserved -= expireReservations(r.reservations, now)
+	reserved, count := r.reserved, len(r.reservations)
+	if res, ok := r.reservations[id]; ok {
+		reserved -= res.bytes
+		count--
+	}
+	if count >= r.maxReservations {
+		return false
+	}
+	minAvailable := int64((1 - maxReservedCapacityFraction) * float64(capacity.Capacity))
+	if capacity.Available-reserved-bytes < minAvailable {
+		return false
+	}
+	r.reservations[id] = snapshotReservation{bytes: bytes, expiration: now.Add(snapshotReservationTTL)}
+	r.reserved = reserved + bytes
+	return true
+}
+
+// release releases the reservation identified by id, if it's held.
+func (r *storeReservations) release(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if res, ok := r.reservations[id]; ok {
+		r.reserved -= res.bytes
+		delete(r.reservations, id)
+	}
+}
+
+// reservedBytes returns the bytes currently reserved.
+func (r *storeReservations) reservedBytes() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reserved
+}
+
+// reserveSnapshot reserves or releases space on the store and bytes of
+// the node's snapshot receive budget, as requested by
+// InternalReserveSnapshot. Requests are made on behalf of the store
+// itself, which doesn't yet hold a replica of the snapshot's range, so
+// they execute outside of any range.
+func (s *Store) reserveSnapshot(args *proto.InternalReserveSnapshotRequest, reply *proto.InternalReserveSnapshotResponse) error {
+	defer func() {
+		s.metrics.reservedBytes.Update(s.reservations.reservedBytes())
+	}()
+	if args.Release {
+		s.recvBudget.release(args.ReservationID)
+		s.reservations.release(args.ReservationID)
+		return nil
+	}
+	capacity, err := s.Capacity()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !s.reservations.reserve(args.ReservationID, args.Bytes, capacity, now) {
+		s.metrics.reservationsRefused.Inc(1)
+		return nil
+	}
+	if reply.Reserved = s.recvBudget.reserve(args.ReservationID, args.Bytes, now); !reply.Reserved {
+		s.reservations.release(args.ReservationID)
+		s.metrics.reservationsRefused.Inc(1)
+	}
+	return nil
+}
+
+// chooseSnapshotSender returns the store among candidates, those
+// holding replicas of a range, from which a new replica of the range
+// should fetch its snapshot, or nil if there are no candidates.
+// Stores on overloaded nodes are avoided, and of the others, the
+// store with the fewest outgoing snapshots and then the lowest request
+// rate is chosen. Followers are thereby usually preferred over the
+// leader, which serves all of the range's requests.
+func chooseSnapshotSender(candidates []*StoreDescriptor, thresholds LoadThresholds) *StoreDescriptor {
+	var sender *StoreDescriptor
+	var senderOverloaded bool
+	for _, c := range candidates {
+		overloaded := c.Node.Load.Overloaded(thresholds)
+		switch {
+		case sender == nil:
+		case overloaded != senderOverloaded:
+			if overloaded {
+				continue
+			}
+		case c.Snapshots != sender.Snapshots:
+			if c.Snapshots > sender.Snapshots {
+				continue
+			}
+		case c.QPS >= sender.QPS:
+			continue
+		}
+		sender, senderOverloaded = c, overloaded
+	}
+	return sender
+}
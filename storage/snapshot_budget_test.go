@@ -0,0 +1,155 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestSnapshotBudget verifies that reservations are refused beyond
+// the budget until others are released or expire.
+func TestSnapshotBudget(t *testing.T) {
+	b := NewSnapshotBudget(100)
+	now := time.Unix(0, 0)
+
+	// A snapshot larger than the budget is granted while it's idle.
+	if !b.reserve("a", 150, now) {
+		t.Fatal("expected oversized reservation of idle budget")
+	}
+	if b.reserve("b", 10, now) {
+		t.Error("expected reservation of exhausted budget to be refused")
+	}
+	b.release("a")
+	if !b.reserve("a", 60, now) || !b.reserve("b", 40, now) {
+		t.Fatal("expected reservations within budget")
+	}
+	if b.reserve("c", 1, now) {
+		t.Error("expected reservation of exhausted budget to be refused")
+	}
+	// Reserving an existing id resizes its reservation.
+	if !b.reserve("a", 50, now) || !b.reserve("c", 10, now) {
+		t.Error("expected resized reservation to free bytes")
+	}
+	if b.used != 100 {
+		t.Errorf("expected 100 bytes used; got %d", b.used)
+	}
+
+	// Renewed reservations outlive those which aren't.
+	now = now.Add(snapshotReservationTTL / 2)
+	b.renew("a", now)
+	now = now.Add(snapshotReservationTTL / 2)
+	if !b.reserve("d", 50, now) {
+		t.Error("expected expired reservations to be reclaimed")
+	}
+	if b.used != 100 || len(b.reservations) != 2 {
+		t.Errorf("expected reservations a and d to remain; got %+v", b.reservations)
+	}
+
+	// Releasing an unknown reservation has no effect.
+	b.release("b")
+	if b.used != 100 {
+		t.Errorf("expected 100 bytes used; got %d", b.used)
+	}
+
+	// A zero budget is unlimited.
+	b = NewSnapshotBudget(0)
+	for _, id := range []string{"a", "b", "c"} {
+		if !b.reserve(id, 1<<40, now) {
+			t.Errorf("expected reservation %s of unlimited budget", id)
+		}
+	}
+}
+
+// TestStoreReservations verifies that reservations are refused once
+// they would fill the store or exceed the maximum count.
+func TestStoreReservations(t *testing.T) {
+	r := newStoreReservations(2)
+	capacity := engine.StoreCapacity{Capacity: 1000, Available: 500}
+	now := time.Unix(0, 0)
+
+	// 50 bytes must be left available.
+	if r.reserve("a", 451, capacity, now) {
+		t.Error("expected reservation filling the store to be refused")
+	}
+	if !r.reserve("a", 300, capacity, now) {
+		t.Fatal("expected reservation within capacity")
+	}
+	if r.reserve("b", 200, capacity, now) {
+		t.Error("expected reservation beyond remaining capacity to be refused")
+	}
+	if !r.reserve("b", 150, capacity, now) {
+		t.Fatal("expected reservation within remaining capacity")
+	}
+	if r.reserve("c", 1, capacity, now) {
+		t.Error("expected reservation beyond the maximum count to be refused")
+	}
+	// Reserving an existing id resizes its reservation.
+	if !r.reserve("a", 100, capacity, now) {
+		t.Error("expected resized reservation to be granted")
+	}
+	if reserved := r.reservedBytes(); reserved != 250 {
+		t.Errorf("expected 250 bytes reserved; got %d", reserved)
+	}
+	r.release("b")
+	if !r.reserve("c", 1, capacity, now) {
+		t.Error("expected reservation after release")
+	}
+
+	// Expired reservations are reclaimed.
+	now = now.Add(snapshotReservationTTL)
+	if !r.reserve("d", 400, capacity, now) {
+		t.Error("expected expired reservations to be reclaimed")
+	}
+	if reserved := r.reservedBytes(); reserved != 400 {
+		t.Errorf("expected 400 bytes reserved; got %d", reserved)
+	}
+}
+
+func TestChooseSnapshotSender(t *testing.T) {
+	thresholds := LoadThresholds{CPU: 0.9}
+	store := func(storeID int32, snapshots int, qps, cpu float64) *StoreDescriptor {
+		return &StoreDescriptor{StoreID: storeID, Snapshots: snapshots, QPS: qps,
+			Node: NodeDescriptor{NodeID: storeID, Load: NodeLoad{CPU: cpu}}}
+	}
+	testCases := []struct {
+		candidates []*StoreDescriptor
+		expected   int32 // 0 if there's no sender
+	}{
+		{nil, 0},
+		{[]*StoreDescriptor{store(1, 0, 100, 0)}, 1},
+		// The store with the lowest request rate is chosen.
+		{[]*StoreDescriptor{store(1, 0, 300, 0), store(2, 0, 50, 0), store(3, 0, 100, 0)}, 2},
+		// Outgoing snapshots outweigh request rates.
+		{[]*StoreDescriptor{store(1, 0, 300, 0), store(2, 2, 50, 0), store(3, 1, 100, 0)}, 1},
+		// Overloaded nodes are avoided while there are others.
+		{[]*StoreDescriptor{store(1, 3, 300, 0), store(2, 0, 50, 0.95)}, 1},
+		{[]*StoreDescriptor{store(1, 0, 300, 0.95), store(2, 0, 50, 0.95)}, 2},
+	}
+	for i, test := range testCases {
+		var storeID int32
+		if sender := chooseSnapshotSender(test.candidates, thresholds); sender != nil {
+			storeID = sender.StoreID
+		}
+		if storeID != test.expected {
+			t.Errorf("%d: expected store %d; got %d", i, test.expected, storeID)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// snapshotReservationSeq numbers the send budget reservations of
+// snapshots, which are unique across the stores sharing a budget.
+var snapshotReservationSeq int64
+
+// snapshotLimiter bounds the outgoing snapshots served by a store via
+// InternalSnapshotCopy. A snapshot occupies a slot from the request
+// which creates it until the request which returns no rows and
+// releases it; requests creating snapshots beyond the concurrency cap
+// queue for a free slot. A new snapshot must also reserve the size of
+// its range from the node's send budget, which it holds for the same
+// duration; if the budget is exhausted, the snapshot is refused so
+// that its recipient may fetch it from another replica. Each
+// snapshot's chunks are additionally paced so that it transfers at
+// most bytesPerSec. Waiting happens outside of range command
+// execution, so throttled snapshots don't hold up Raft. A snapshot
+// which isn't continued within snapshotReservationTTL, such as one
+// abandoned by a failed recipient, expires, releasing its slot along
+// with its send budget reservation.
+type snapshotLimiter struct {
+	sem         chan struct{}   // Concurrency slots; nil for unlimited
+	bytesPerSec int64           // Per-snapshot bandwidth; 0 for unlimited
+	send        *SnapshotBudget // Node's snapshot send budget
+	metrics     *storeMetrics
+
+	mu     sync.Mutex
+	active map[string]*activeSnapshot // By snapshot ID
+}
+
+// An activeSnapshot is an outgoing snapshot in progress.
+type activeSnapshot struct {
+	next        time.Time // Earliest time for next chunk
+	expiration  time.Time // Expiration unless continued
+	reservation string    // Key of the send budget reservation
+}
+
+// newSnapshotLimiter returns a limiter allowing at most maxConcurrent
+// outgoing snapshots, each transferring at most bytesPerSec. Zero
+// values place no limit. The send budget is unlimited until replaced
+// by the node's.
+func newSnapshotLimiter(maxConcurrent int, bytesPerSec int64, metrics *storeMetrics) *snapshotLimiter {
+	l := &snapshotLimiter{
+		bytesPerSec: bytesPerSec,
+		send:        NewSnapshotBudget(0),
+		metrics:     metrics,
+		active:      map[string]*activeSnapshot{},
+	}
+	if maxConcurrent > 0 {
+		l.sem = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// begin is invoked before a snapshot copy request executes. A request
+// creating a new snapshot waits for a free slot and then reserves
+// bytes, the size of the snapshot's range, from the send budget; a
+// request continuing an active snapshot waits until the snapshot's
+// bandwidth allows its next chunk. Returns the key of the reservation
+// of a request creating a new snapshot, or an empty key for a
+// continuation, to be supplied to end. Returns an error if the send
+// budget is exhausted or if stopper is closed while waiting.
+func (l *snapshotLimiter) begin(args *proto.InternalSnapshotCopyRequest, bytes int64, stopper <-chan struct{}) (string, error) {
+	if len(args.SnapshotID) == 0 {
+		if l.sem != nil {
+			l.metrics.snapshotsQueued.Inc(1)
+			defer l.metrics.snapshotsQueued.Inc(-1)
+			for acquired := false; !acquired; {
+				l.expire(time.Now())
+				select {
+				case l.sem <- struct{}{}:
+					acquired = true
+				case <-time.After(snapshotReservationTTL):
+					// Slots may be held by abandoned snapshots.
+				case <-stopper:
+					return "", util.Errorf("store is stopping; snapshot not started")
+				}
+			}
+		}
+		reservation := "send." + strconv.FormatInt(atomic.AddInt64(&snapshotReservationSeq, 1), 10)
+		if !l.send.reserve(reservation, bytes, time.Now()) {
+			if l.sem != nil {
+				<-l.sem
+			}
+			return "", util.Errorf("snapshot send budget exhausted; snapshot of %d bytes not started", bytes)
+		}
+		return reservation, nil
+	}
+	l.mu.Lock()
+	var next time.Time
+	snap, ok := l.active[args.SnapshotID]
+	if ok {
+		next = snap.next
+	}
+	l.mu.Unlock()
+	if wait := next.Sub(time.Now()); ok && wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-stopper:
+			return "", util.Errorf("store is stopping; snapshot %s not continued", args.SnapshotID)
+		}
+	}
+	return "", nil
+}
+
+// end is invoked after a snapshot copy request executes with the
+// result of begin. It records a newly created snapshot as active and
+// schedules its next chunk according to the bytes just returned,
+// renewing its send budget reservation, or releases the snapshot's
+// slot and reservation once it completes or fails.
+func (l *snapshotLimiter) end(reservation string, reply *proto.InternalSnapshotCopyResponse, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	snap, ok := l.active[reply.SnapshotID]
+	if !ok && len(reservation) == 0 {
+		// Not a snapshot which holds a slot.
+		return
+	}
+	if err != nil || reply.GoError() != nil || len(reply.Rows) == 0 {
+		if ok {
+			delete(l.active, reply.SnapshotID)
+			reservation = snap.reservation
+		}
+		l.send.release(reservation)
+		if l.sem != nil {
+			<-l.sem
+		}
+		l.metrics.snapshotsActive.Update(int64(len(l.active)))
+		return
+	}
+
+	var bytes int64
+	for _, kv := range reply.Rows {
+		bytes += int64(len(kv.Key) + len(kv.Value))
+	}
+	l.metrics.snapshotBytes.Inc(bytes)
+	if !ok {
+		snap = &activeSnapshot{reservation: reservation}
+		l.active[reply.SnapshotID] = snap
+	}
+	now := time.Now()
+	snap.expiration = now.Add(snapshotReservationTTL)
+	l.send.renew(snap.reservation, now)
+	if snap.next.Before(now) {
+		snap.next = now
+	}
+	if l.bytesPerSec > 0 {
+		snap.next = snap.next.Add(time.Duration(bytes * int64(time.Second) / l.bytesPerSec))
+	}
+	l.metrics.snapshotsActive.Update(int64(len(l.active)))
+}
+
+// expire releases the slots and send budget reservations of the active
+// snapshots which have expired as of now.
+func (l *snapshotLimiter) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, snap := range l.active {
+		if now.Before(snap.expiration) {
+			continue
+		}
+		delete(l.active, id)
+		l.send.release(snap.reservation)
+		if l.sem != nil {
+			<-l.sem
+		}
+	}
+	l.metrics.snapshotsActive.Update(int64(len(l.active)))
+}
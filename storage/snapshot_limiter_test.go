@@ -0,0 +1,181 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+func snapshotReply(id string, rows int, size int) *proto.InternalSnapshotCopyResponse {
+	reply := &proto.InternalSnapshotCopyResponse{SnapshotID: id}
+	for i := 0; i < rows; i++ {
+		reply.Rows = append(reply.Rows, proto.RawKeyValue{Key: proto.EncodedKey("k"), Value: make([]byte, size-1)})
+	}
+	return reply
+}
+
+// TestSnapshotLimiterConcurrency verifies that snapshots beyond the
+// concurrency cap queue until an active snapshot completes.
+func TestSnapshotLimiterConcurrency(t *testing.T) {
+	m := newStoreMetrics()
+	l := newSnapshotLimiter(1, 0, m)
+	stopper := make(chan struct{})
+
+	res, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 0, stopper)
+	if err != nil || res == "" {
+		t.Fatalf("expected new snapshot to start; got %q, %v", res, err)
+	}
+	l.end(res, snapshotReply("1", 1, 10), nil)
+	if v := m.snapshotsActive.Value(); v != 1 {
+		t.Errorf("expected 1 active snapshot; got %d", v)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		res, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 0, stopper)
+		if err != nil || res == "" {
+			t.Errorf("expected queued snapshot to start; got %q, %v", res, err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("second snapshot started while first was active")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if v := m.snapshotsQueued.Value(); v != 1 {
+		t.Errorf("expected 1 queued snapshot; got %d", v)
+	}
+
+	// Continuing the active snapshot doesn't require a slot.
+	args := &proto.InternalSnapshotCopyRequest{SnapshotID: "1"}
+	if res, err := l.begin(args, 0, stopper); err != nil || res != "" {
+		t.Fatalf("expected continuation; got %q, %v", res, err)
+	}
+	// An empty chunk completes the snapshot and frees its slot.
+	l.end("", snapshotReply("1", 0, 0), nil)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queued snapshot didn't start after slot was freed")
+	}
+	if v := m.snapshotsActive.Value(); v != 0 {
+		t.Errorf("expected 0 active snapshots; got %d", v)
+	}
+	if c := m.snapshotBytes.Count(); c != 10 {
+		t.Errorf("expected 10 snapshot bytes; got %d", c)
+	}
+
+	// A stopping store unblocks queued snapshots.
+	close(stopper)
+	if _, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 0, stopper); err == nil {
+		t.Error("expected error beginning snapshot on stopped store")
+	}
+}
+
+// TestSnapshotLimiterBandwidth verifies that chunks of a snapshot are
+// paced according to the bandwidth limit.
+func TestSnapshotLimiterBandwidth(t *testing.T) {
+	l := newSnapshotLimiter(0, 10000, newStoreMetrics())
+	stopper := make(chan struct{})
+
+	res, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 0, stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1000 bytes at 10000 bytes/sec should delay the next chunk 100ms.
+	l.end(res, snapshotReply("1", 10, 100), nil)
+	start := time.Now()
+	if _, err := l.begin(&proto.InternalSnapshotCopyRequest{SnapshotID: "1"}, 0, stopper); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected next chunk to be delayed ~100ms; waited %s", elapsed)
+	}
+}
+
+// TestSnapshotLimiterSendBudget verifies that new snapshots are
+// refused while the send budget is exhausted, without holding a slot,
+// and that completed snapshots release their reservations.
+func TestSnapshotLimiterSendBudget(t *testing.T) {
+	l := newSnapshotLimiter(2, 0, newStoreMetrics())
+	l.send = NewSnapshotBudget(1000)
+	stopper := make(chan struct{})
+
+	res, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 600, stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.end(res, snapshotReply("1", 1, 10), nil)
+	for i := 0; i < 2; i++ {
+		if _, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 600, stopper); err == nil {
+			t.Fatalf("%d: expected snapshot exceeding the send budget to be refused", i)
+		}
+	}
+	if len(l.sem) != 1 {
+		t.Errorf("expected refused snapshots to release their slots; %d held", len(l.sem))
+	}
+	// Snapshots within the remaining budget proceed.
+	res2, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 400, stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.end(res2, snapshotReply("2", 0, 0), nil)
+
+	// Completing the first snapshot releases its reservation.
+	l.end("", snapshotReply("1", 0, 0), nil)
+	if l.send.used != 0 {
+		t.Errorf("expected send budget to be released; %d bytes used", l.send.used)
+	}
+	if _, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 600, stopper); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestSnapshotLimiterExpiration verifies that a snapshot which isn't
+// continued expires, releasing its slot and send budget reservation.
+func TestSnapshotLimiterExpiration(t *testing.T) {
+	m := newStoreMetrics()
+	l := newSnapshotLimiter(1, 0, m)
+	l.send = NewSnapshotBudget(1000)
+	stopper := make(chan struct{})
+
+	res, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 600, stopper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.end(res, snapshotReply("1", 1, 10), nil)
+
+	l.expire(time.Now())
+	if v := m.snapshotsActive.Value(); v != 1 {
+		t.Fatalf("expected 1 active snapshot; got %d", v)
+	}
+	l.expire(time.Now().Add(snapshotReservationTTL))
+	if v := m.snapshotsActive.Value(); v != 0 {
+		t.Errorf("expected abandoned snapshot to expire; %d active", v)
+	}
+	if len(l.sem) != 0 || l.send.used != 0 {
+		t.Errorf("expected slot and send budget to be released; %d slots and %d bytes held", len(l.sem), l.send.used)
+	}
+	if _, err := l.begin(&proto.InternalSnapshotCopyRequest{}, 600, stopper); err != nil {
+		t.Error(err)
+	}
+}
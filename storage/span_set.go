@@ -0,0 +1,93 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// SpanAccess records whether a command reads or writes a key span.
+type SpanAccess int
+
+const (
+	// SpanReadOnly spans may be accessed concurrently by other
+	// readers, but not by writers.
+	SpanReadOnly SpanAccess = iota
+	// SpanReadWrite spans are accessed exclusively.
+	SpanReadWrite
+
+	numSpanAccess
+)
+
+// A keySpan is a single key (if end is empty) or the key range
+// [start, end).
+type keySpan struct {
+	start, end proto.Key
+}
+
+// A SpanSet holds the key spans which a command declares it will
+// access, by access type. The command queue serializes a command
+// against all commands whose declared spans overlap its own, unless
+// both only read the overlap.
+type SpanSet struct {
+	spans [numSpanAccess][]keySpan
+}
+
+// Add declares access to the key span [start, end). If end is empty,
+// the span is the single key start.
+func (ss *SpanSet) Add(access SpanAccess, start, end proto.Key) {
+	ss.spans[access] = append(ss.spans[access], keySpan{start: start, end: end})
+}
+
+// Len returns the number of declared spans.
+func (ss *SpanSet) Len() int {
+	var n int
+	for _, spans := range ss.spans {
+		n += len(spans)
+	}
+	return n
+}
+
+// declareKeys returns the key spans accessed by the command. Every
+// command accesses its header's key span; read-only methods only
+// read it. Commands which modify a transaction record additionally
+// declare the record's key and EndTransaction declares the intents
+// it resolves locally. The response cache entry written by every
+// read-write command is keyed by its unique client command ID and
+// doesn't need to be declared, nor do the range's MVCC stats, which
+// are only written as commands are applied in Raft log order.
+func declareKeys(method string, args proto.Request) *SpanSet {
+	header := args.Header()
+	spans := &SpanSet{}
+	if proto.IsReadOnly(method) {
+		spans.Add(SpanReadOnly, header.Key, header.EndKey)
+		return spans
+	}
+	spans.Add(SpanReadWrite, header.Key, header.EndKey)
+	switch method {
+	case proto.EndTransaction, proto.InternalEndTxn, proto.InternalHeartbeatTxn, proto.InternalPushTxn:
+		spans.Add(SpanReadWrite, engine.MakeKey(engine.KeyLocalTransactionPrefix, header.Key), nil)
+	}
+	if et, ok := args.(*proto.EndTransactionRequest); ok {
+		for _, intent := range et.Intents {
+			spans.Add(SpanReadWrite, intent.Key, intent.EndKey)
+		}
+	}
+	return spans
+}
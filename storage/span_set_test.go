@@ -0,0 +1,73 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+func TestDeclareKeys(t *testing.T) {
+	txnKey := engine.MakeKey(engine.KeyLocalTransactionPrefix, proto.Key("a"))
+	testCases := []struct {
+		method  string
+		args    proto.Request
+		expRead []keySpan
+		expRW   []keySpan
+	}{
+		{
+			proto.Scan,
+			&proto.ScanRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a"), EndKey: proto.Key("c")}},
+			[]keySpan{{proto.Key("a"), proto.Key("c")}},
+			nil,
+		},
+		{
+			proto.Put,
+			&proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a")}},
+			nil,
+			[]keySpan{{proto.Key("a"), nil}},
+		},
+		{
+			proto.InternalHeartbeatTxn,
+			&proto.InternalHeartbeatTxnRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a")}},
+			nil,
+			[]keySpan{{proto.Key("a"), nil}, {txnKey, nil}},
+		},
+		{
+			proto.EndTransaction,
+			&proto.EndTransactionRequest{
+				RequestHeader: proto.RequestHeader{Key: proto.Key("a")},
+				Intents:       []proto.Intent{{Key: proto.Key("b")}, {Key: proto.Key("c"), EndKey: proto.Key("d")}},
+			},
+			nil,
+			[]keySpan{{proto.Key("a"), nil}, {txnKey, nil}, {proto.Key("b"), nil}, {proto.Key("c"), proto.Key("d")}},
+		},
+	}
+	for i, test := range testCases {
+		spans := declareKeys(test.method, test.args)
+		if read := spans.spans[SpanReadOnly]; !reflect.DeepEqual(read, test.expRead) {
+			t.Errorf("%d: expected read spans %v; got %v", i, test.expRead, read)
+		}
+		if rw := spans.spans[SpanReadWrite]; !reflect.DeepEqual(rw, test.expRW) {
+			t.Errorf("%d: expected read-write spans %v; got %v", i, test.expRW, rw)
+		}
+	}
+}
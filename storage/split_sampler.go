@@ -0,0 +1,115 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"math/rand"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+const (
+	// splitSamplerCandidates is the number of split key candidates
+	// retained by a split sampler.
+	splitSamplerCandidates = 20
+	// minSplitCandidateRequests is the minimum number of requests a
+	// candidate must have observed before it's considered as a split
+	// key.
+	minSplitCandidateRequests = 100
+	// maxSplitContainedFraction is the maximum fraction of observed
+	// requests which may span a candidate split key.
+	maxSplitContainedFraction = 0.5
+)
+
+// A splitCandidate is a sampled key along with counts of subsequent
+// requests which fell to its left, to its right, or spanned it.
+type splitCandidate struct {
+	key                    proto.Key
+	left, right, contained int64
+}
+
+// A splitSampler chooses a load-based split key for a range. The
+// start keys of requests are reservoir sampled as split candidates,
+// so hotter keys are proportionally more likely to be sampled. Each
+// candidate then counts the requests which would fall to either side
+// of it after a split. The candidate which most evenly divides
+// requests, without being spanned by too many of them, is the
+// access-weighted median of the range.
+//
+// splitSampler is not thread safe.
+type splitSampler struct {
+	rand       *rand.Rand
+	count      int64 // Number of requests recorded
+	candidates []splitCandidate
+}
+
+// newSplitSampler returns a new, empty split sampler.
+func newSplitSampler() *splitSampler {
+	return &splitSampler{
+		rand: rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// record records a request affecting the key range from start to
+// end. If end is empty, the request affects only start.
+func (ss *splitSampler) record(start, end proto.Key) {
+	if len(end) == 0 {
+		end = start.Next()
+	}
+	ss.count++
+	if len(ss.candidates) < splitSamplerCandidates {
+		ss.candidates = append(ss.candidates, splitCandidate{key: start})
+	} else if i := ss.rand.Int63n(ss.count); i < splitSamplerCandidates {
+		ss.candidates[i] = splitCandidate{key: start}
+	}
+	for i := range ss.candidates {
+		c := &ss.candidates[i]
+		if !c.key.Less(end) {
+			c.left++
+		} else if !start.Less(c.key) {
+			c.right++
+		} else {
+			c.contained++
+		}
+	}
+}
+
+// splitKey returns the candidate key after startKey which most evenly
+// divides recorded requests, or nil if no candidate has observed
+// enough requests, is spanned by few enough of them, and has requests
+// on both sides.
+func (ss *splitSampler) splitKey(startKey proto.Key) proto.Key {
+	var best proto.Key
+	bestImbalance := 1.0
+	for _, c := range ss.candidates {
+		total := c.left + c.right + c.contained
+		if !startKey.Less(c.key) || total < minSplitCandidateRequests ||
+			float64(c.contained) > maxSplitContainedFraction*float64(total) {
+			continue
+		}
+		imbalance := float64(c.left - c.right)
+		if imbalance < 0 {
+			imbalance = -imbalance
+		}
+		imbalance /= float64(total)
+		if imbalance < bestImbalance {
+			best, bestImbalance = c.key, imbalance
+		}
+	}
+	return best
+}
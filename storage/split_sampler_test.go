@@ -0,0 +1,81 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestSplitSamplerUniform verifies that requests spread evenly over
+// a range yield a split key near the middle.
+func TestSplitSamplerUniform(t *testing.T) {
+	ss := newSplitSampler()
+	if key := ss.splitKey(proto.KeyMin); key != nil {
+		t.Errorf("expected no split key with no requests; got %q", key)
+	}
+	for i := 0; i < 100*minSplitCandidateRequests; i++ {
+		ss.record(proto.Key(fmt.Sprintf("%02d", i%100)), nil)
+	}
+	key := ss.splitKey(proto.KeyMin)
+	if key.Less(proto.Key("30")) || proto.Key("70").Less(key) {
+		t.Errorf("expected split key near \"50\"; got %q", key)
+	}
+}
+
+// TestSplitSamplerSkewed verifies that the split key is weighted by
+// access, rather than by the number of distinct keys.
+func TestSplitSamplerSkewed(t *testing.T) {
+	ss := newSplitSampler()
+	// Key "h" receives as many requests as keys "a"-"g" combined; keys
+	// "i"-"z" are rarely accessed.
+	for i := 0; i < 10*minSplitCandidateRequests; i++ {
+		for _, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+			ss.record(proto.Key(k), nil)
+		}
+		for j := 0; j < 7; j++ {
+			ss.record(proto.Key("h"), nil)
+		}
+		if i%10 == 0 {
+			ss.record(proto.Key("x"), nil)
+		}
+	}
+	if key := ss.splitKey(proto.KeyMin); !key.Equal(proto.Key("h")) {
+		t.Errorf("expected split key \"h\"; got %q", key)
+	}
+	// Candidates at or before the start key are ignored.
+	if key := ss.splitKey(proto.Key("h")); key != nil && !proto.Key("h").Less(key) {
+		t.Errorf("expected split key after \"h\"; got %q", key)
+	}
+}
+
+// TestSplitSamplerSpans verifies that keys spanned by most requests
+// aren't chosen as split keys.
+func TestSplitSamplerSpans(t *testing.T) {
+	ss := newSplitSampler()
+	for i := 0; i < 10*minSplitCandidateRequests; i++ {
+		ss.record(proto.Key("a"), proto.Key("z"))
+		ss.record(proto.Key("a"), proto.Key("z"))
+		ss.record(proto.Key(fmt.Sprintf("b%03d", i)), nil)
+	}
+	if key := ss.splitKey(proto.KeyMin); key != nil {
+		t.Errorf("expected no split key; got %q", key)
+	}
+}
@@ -32,8 +32,10 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/fault"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metric"
 )
 
 const (
@@ -52,12 +54,17 @@ const (
 	uuidLength = 36
 )
 
-// verifyKeyLength verifies key length. Extra key length is allowed for
-// the local key prefix (for example, a transaction record), and also for
-// keys prefixed with the meta1 or meta2 addressing prefixes. There is a
-// special case for both key-local AND meta1 or meta2 addressing prefixes.
-func verifyKeyLength(key proto.Key) error {
-	maxLength := engine.KeyMaxLength
+// verifyKeyLength verifies key length against the maximum key size of
+// the supplied limits, returning a RequestTooLargeError naming field
+// if exceeded. Extra key length is allowed for the local key prefix
+// (for example, a transaction record), and also for keys prefixed with
+// the meta1 or meta2 addressing prefixes. There is a special case for
+// both key-local AND meta1 or meta2 addressing prefixes.
+func verifyKeyLength(field string, key proto.Key, limits proto.RequestLimits) error {
+	maxLength := limits.MaxKeySize
+	if maxLength == 0 {
+		return nil
+	}
 	// Transaction records get a UUID appended, so we increase allowed max length.
 	if bytes.HasPrefix(key, engine.KeyLocalTransactionPrefix) {
 		maxLength += uuidLength
@@ -68,8 +75,8 @@ func verifyKeyLength(key proto.Key) error {
 	if bytes.HasPrefix(key, engine.KeyMetaPrefix) {
 		key = key[len(engine.KeyMeta1Prefix):]
 	}
-	if len(key) > maxLength {
-		return util.Errorf("maximum key length exceeded for %q", key)
+	if int64(len(key)) > maxLength {
+		return proto.NewRequestTooLargeError(field, int64(len(key)), maxLength)
 	}
 	return nil
 }
@@ -77,15 +84,15 @@ func verifyKeyLength(key proto.Key) error {
 // verifyKeys verifies key length for start and end. Also verifies
 // that start key is less than KeyMax and end key is less than or
 // equal to KeyMax. If end is non-empty, it must be >= start.
-func verifyKeys(start, end proto.Key) error {
-	if err := verifyKeyLength(start); err != nil {
+func verifyKeys(start, end proto.Key, limits proto.RequestLimits) error {
+	if err := verifyKeyLength("key", start, limits); err != nil {
 		return err
 	}
 	if !start.Less(engine.KeyMax) {
 		return util.Errorf("start key %q must be less than KeyMax", start)
 	}
 	if len(end) > 0 {
-		if err := verifyKeyLength(end); err != nil {
+		if err := verifyKeyLength("end_key", end, limits); err != nil {
 			return err
 		}
 		if engine.KeyMax.Less(end) {
@@ -125,18 +132,23 @@ func (e *NotBootstrappedError) Error() string {
 
 // NodeDescriptor holds details on node physical/network topology.
 type NodeDescriptor struct {
-	NodeID  int32
-	Address net.Addr
-	Attrs   proto.Attributes // node specific attributes (e.g. datacenter, machine info)
+	NodeID   int32
+	Address  net.Addr
+	Attrs    proto.Attributes // node specific attributes (e.g. datacenter, machine info)
+	Locality proto.Locality   // Location tiers (e.g. region, zone, rack)
+	Load     NodeLoad         // Resource usage as of the last gossip
 }
 
 // StoreDescriptor holds store information including store attributes,
 // node descriptor and store capacity.
 type StoreDescriptor struct {
-	StoreID  int32
-	Attrs    proto.Attributes // store specific attributes (e.g. ssd, hdd, mem)
-	Node     NodeDescriptor
-	Capacity engine.StoreCapacity
+	StoreID    int32
+	Attrs      proto.Attributes // store specific attributes (e.g. ssd, hdd, mem)
+	Node       NodeDescriptor
+	Capacity   engine.StoreCapacity
+	QPS        float64 // Requests per second served by the store
+	RangeCount int     // Number of ranges on the store
+	Snapshots  int     // Outgoing snapshots in progress
 }
 
 // CombinedAttrs returns the full list of attributes for the store,
@@ -164,22 +176,61 @@ type Store struct {
 	gossip       *gossip.Gossip // Passed to new ranges
 	raftIDAlloc  *IDAllocator   // Raft ID allocator
 	rangeIDAlloc *IDAllocator   // Range ID allocator
+	stopper      *util.Stopper  // Stops range workers and drains commands
+	metrics      *storeMetrics  // Store and engine metrics
+	limits       proto.RequestLimits
+	unavailable  time.Duration // Threshold tripping replica breakers
+	snapshots    *snapshotLimiter
+	recvBudget   *SnapshotBudget    // Node's snapshot receive budget
+	reservations *storeReservations // Space reserved for incoming snapshots
+	cmdQ         *CommandQueue      // Serializes commands with overlapping keys
+	gossipState  storeGossip        // Descriptor last gossiped
+	tagUsage     *tagUsage          // Usage of tagged requests awaiting rollup
 
 	mu          sync.RWMutex     // Protects variables below...
 	ranges      map[int64]*Range // Map of ranges by range ID
 	rangesByKey RangeSlice       // Sorted slice of ranges by StartKey
+
+	quiescedMu    sync.Mutex          // Protects quiesced
+	quiesced      map[*Range]struct{} // Quiesced ranges
+	heartbeatOnce sync.Once           // Starts heartbeatQuiescedRanges
+	txnGCOnce     sync.Once           // Starts gcAbandonedTxns
+	acctOnce      sync.Once           // Starts rollupTagUsage
+	mvccGCOnce    sync.Once           // Starts gcRangeVersions
+	repairOnce    sync.Once           // Starts repairReplicaPlacement
+	replicaGCOnce sync.Once           // Starts gcReplicas
+	sessionGCOnce sync.Once           // Starts gcSessions
 }
 
-// NewStore returns a new instance of a store.
-func NewStore(clock *hlc.Clock, eng engine.Engine, db *client.KV, gossip *gossip.Gossip) *Store {
-	return &Store{
-		clock:     clock,
-		engine:    eng,
-		db:        db,
-		allocator: &allocator{},
-		gossip:    gossip,
-		ranges:    map[int64]*Range{},
+// NewStore returns a new instance of a store. Range workers are
+// started via the supplied stopper and commands are executed as
+// stopper tasks so that the store quiesces on stop.
+func NewStore(clock *hlc.Clock, eng engine.Engine, db *client.KV, gossip *gossip.Gossip, stopper *util.Stopper) *Store {
+	metrics := newStoreMetrics()
+	s := &Store{
+		clock:        clock,
+		engine:       eng,
+		db:           db,
+		allocator:    &allocator{loadThresholds: DefaultLoadThresholds},
+		gossip:       gossip,
+		stopper:      stopper,
+		metrics:      metrics,
+		limits:       proto.DefaultRequestLimits,
+		unavailable:  DefaultUnavailableThreshold,
+		snapshots:    newSnapshotLimiter(0, 0, metrics),
+		recvBudget:   NewSnapshotBudget(0),
+		reservations: newStoreReservations(defaultMaxStoreReservations),
+		cmdQ:         NewCommandQueue(),
+		tagUsage:     newTagUsage(),
+		gossipState: storeGossip{
+			thresholds:     DefaultGossipThresholds,
+			loadThresholds: DefaultLoadThresholds,
+		},
+		ranges:   map[int64]*Range{},
+		quiesced: map[*Range]struct{}{},
 	}
+	s.allocator.storeFinder = s.findStores
+	return s
 }
 
 // Close calls Range.Stop() on all active ranges.
@@ -198,7 +249,8 @@ func (s *Store) String() string {
 	return fmt.Sprintf("store=%d:%d (%s)", s.Ident.NodeID, s.Ident.StoreID, s.engine)
 }
 
-// Init starts the engine, sets the GC and reads the StoreIdent.
+// Init starts the engine, sets the GC, reads the StoreIdent and
+// migrates the store to the current format version.
 func (s *Store) Init() error {
 	// Close store for idempotency.
 	s.Close()
@@ -209,13 +261,14 @@ func (s *Store) Init() error {
 	}
 
 	// Create ID allocators.
-	s.raftIDAlloc = NewIDAllocator(engine.KeyRaftIDGenerator, s.db, 2 /* min ID */, raftIDAllocCount)
-	s.rangeIDAlloc = NewIDAllocator(engine.KeyRangeIDGenerator, s.db, 2 /* min ID */, rangeIDAllocCount)
+	s.raftIDAlloc = NewIDAllocator(engine.KeyRaftIDGenerator, s.db, 2 /* min ID */, raftIDAllocCount, s.stopper)
+	s.rangeIDAlloc = NewIDAllocator(engine.KeyRangeIDGenerator, s.db, 2 /* min ID */, rangeIDAllocCount, s.stopper)
 
 	// GCTimeouts method is called each time an engine compaction is
 	// underway. It sets minimum timeouts for transaction records and
 	// response cache entries.
 	s.engine.SetGCTimeouts(func() (minTxnTS, minRCacheTS int64) {
+		s.metrics.compactions.Inc(1)
 		now := s.clock.Now()
 		minTxnTS = 0 // disable GC of transactions until we know minimum write intent age
 		minRCacheTS = now.WallTime - GCResponseCacheExpiration.Nanoseconds()
@@ -231,6 +284,12 @@ func (s *Store) Init() error {
 		return &NotBootstrappedError{}
 	}
 
+	// Upgrade on-disk structures written by older binaries, refusing
+	// to open stores written by newer ones.
+	if err := s.migrate(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	mvcc := engine.NewMVCC(s.engine)
@@ -247,6 +306,13 @@ func (s *Store) Init() error {
 			return false, err
 		}
 		rangeID := desc.FindReplica(s.Ident.StoreID).RangeID
+		// Descriptors of replicas removed from the store may remain.
+		if removed, err := s.isRemovedReplica(desc.RaftID, rangeID); err != nil {
+			return false, err
+		} else if removed {
+			log.V(1).Infof("skipping removed replica %d of range %d", rangeID, desc.RaftID)
+			return false, nil
+		}
 		rng := NewRange(rangeID, &desc, s)
 		rng.Start()
 		s.ranges[rangeID] = rng
@@ -258,14 +324,39 @@ func (s *Store) Init() error {
 
 	sort.Sort(s.rangesByKey)
 
+	// Start aborting abandoned transactions, rolling up the usage of
+	// tagged requests and deleting removed replicas, which require a
+	// DB through which to push the transactions, write the accounting
+	// records and look up range descriptors.
+	if s.db != nil {
+		s.txnGCOnce.Do(func() {
+			s.stopper.RunWorker(s.gcAbandonedTxns)
+		})
+		s.acctOnce.Do(func() {
+			s.stopper.RunWorker(s.rollupTagUsage)
+		})
+		s.replicaGCOnce.Do(func() {
+			s.stopper.RunWorker(s.gcReplicas)
+		})
+	}
+	s.mvccGCOnce.Do(func() {
+		s.stopper.RunWorker(s.gcRangeVersions)
+	})
+	s.sessionGCOnce.Do(func() {
+		s.stopper.RunWorker(s.gcSessions)
+	})
+	s.repairOnce.Do(func() {
+		s.stopper.RunWorker(s.repairReplicaPlacement)
+	})
+
 	return nil
 }
 
-// Bootstrap writes a new store ident to the underlying engine. To
-// ensure that no crufty data already exists in the engine, it scans
-// the engine contents before writing the new store ident. The engine
-// should be completely empty. It returns an error if called on a
-// non-empty engine.
+// Bootstrap writes a new store ident and the current store format
+// version to the underlying engine. To ensure that no crufty data
+// already exists in the engine, it scans the engine contents before
+// writing the new store ident. The engine should be completely
+// empty. It returns an error if called on a non-empty engine.
 func (s *Store) Bootstrap(ident proto.StoreIdent) error {
 	if err := s.engine.Start(); err != nil {
 		return err
@@ -278,8 +369,10 @@ func (s *Store) Bootstrap(ident proto.StoreIdent) error {
 		return util.Errorf("bootstrap failed; non-empty map with first key %q", kvs[0].Key)
 	}
 	identKey := engine.MVCCEncodeKey(engine.KeyLocalIdent)
-	_, _, err = engine.PutProto(s.engine, identKey, &s.Ident)
-	return err
+	if _, _, err = engine.PutProto(s.engine, identKey, &s.Ident); err != nil {
+		return err
+	}
+	return setStoreVersion(s.engine, storeVersionCurrent)
 }
 
 // GetRange fetches a range by ID. Returns an error if no range is found.
@@ -411,6 +504,265 @@ func (s *Store) Allocator() *allocator { return s.allocator }
 // Gossip accessor.
 func (s *Store) Gossip() *gossip.Gossip { return s.gossip }
 
+// Stopper accessor.
+func (s *Store) Stopper() *util.Stopper { return s.stopper }
+
+// Registry returns the registry of store and engine metrics.
+func (s *Store) Registry() *metric.Registry { return s.metrics.registry }
+
+// SetRequestLimits sets the size limits enforced on requests executed
+// by the store. It must be called before the store is started.
+func (s *Store) SetRequestLimits(limits proto.RequestLimits) { s.limits = limits }
+
+// SetUnavailableThreshold sets the duration for which a read-write
+// command may await application before its replica's breaker trips,
+// failing further commands fast. Zero disables the breakers. It must
+// be called before the store is started.
+func (s *Store) SetUnavailableThreshold(threshold time.Duration) { s.unavailable = threshold }
+
+// UnavailableThreshold accessor.
+func (s *Store) UnavailableThreshold() time.Duration { return s.unavailable }
+
+// CommandQueue accessor.
+func (s *Store) CommandQueue() *CommandQueue { return s.cmdQ }
+
+// SetSnapshotLimits bounds the number of outgoing snapshots served
+// concurrently and the bandwidth of each. Zero values place no limit.
+// It must be called before the store is started.
+func (s *Store) SetSnapshotLimits(maxConcurrent int, bytesPerSec int64) {
+	send := s.snapshots.send
+	s.snapshots = newSnapshotLimiter(maxConcurrent, bytesPerSec, s.metrics)
+	s.snapshots.send = send
+}
+
+// SetSnapshotBudgets sets the budgets bounding the bytes of snapshots
+// sent and received concurrently, which are shared by the stores of a
+// node. The budgets are unlimited by default. It must be called before
+// the store is started.
+func (s *Store) SetSnapshotBudgets(send, receive *SnapshotBudget) {
+	s.snapshots.send = send
+	s.recvBudget = receive
+}
+
+// QuiesceRange registers a quiesced range with the store, or
+// unregisters it on waking. Quiesced ranges don't tick; instead, the
+// store closes timestamps on their behalf from a single heartbeat,
+// started on the first call.
+func (s *Store) QuiesceRange(rng *Range, quiesced bool) {
+	s.heartbeatOnce.Do(func() {
+		s.stopper.RunWorker(s.heartbeatQuiescedRanges)
+	})
+	s.quiescedMu.Lock()
+	defer s.quiescedMu.Unlock()
+	if quiesced {
+		s.quiesced[rng] = struct{}{}
+	} else {
+		delete(s.quiesced, rng)
+	}
+	s.metrics.quiescedRanges.Update(int64(len(s.quiesced)))
+}
+
+// RecordValueCompression records the sizes of values compressed by
+// a command before and after compression.
+func (s *Store) RecordValueCompression(logicalBytes, compressedBytes int64) {
+	s.metrics.valuesLogicalBytes.Inc(logicalBytes)
+	s.metrics.valuesCompressedBytes.Inc(compressedBytes)
+}
+
+// RecordBreakerTrip records the tripping of a replica's breaker.
+func (s *Store) RecordBreakerTrip() { s.metrics.breakerTrips.Inc(1) }
+
+// RecordFailedFast records a command failed fast by a tripped breaker.
+func (s *Store) RecordFailedFast() { s.metrics.requestsFailedFast.Inc(1) }
+
+// heartbeatQuiescedRanges closes timestamps on all quiesced ranges
+// for which this store is the leader every closedTimestampInterval,
+// until the stopper is signaled. This takes the place of per-range
+// ticking, which would otherwise dominate CPU with many idle ranges.
+func (s *Store) heartbeatQuiescedRanges() {
+	ticker := time.NewTicker(closedTimestampInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.quiescedMu.Lock()
+			ranges := make([]*Range, 0, len(s.quiesced))
+			for rng := range s.quiesced {
+				ranges = append(ranges, rng)
+			}
+			s.quiescedMu.Unlock()
+			closedTS := s.clock.Now().Add(-closedTimestampLag.Nanoseconds(), 0)
+			for _, rng := range ranges {
+				if rng.IsLeader() {
+					rng.closeTimestamp(closedTS)
+				}
+			}
+		case <-s.stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// gcAbandonedTxns aborts transactions whose coordinators have
+// stopped heartbeating and resolves their intents, scanning the
+// ranges this store leads every txnGCInterval until the stopper is
+// signaled. Otherwise, intents of a crashed client's transaction are
+// only cleaned up once another transaction conflicts with them.
+func (s *Store) gcAbandonedTxns() {
+	ticker := time.NewTicker(txnGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.RLock()
+			ranges := make([]*Range, 0, len(s.ranges))
+			for _, rng := range s.ranges {
+				ranges = append(ranges, rng)
+			}
+			s.mu.RUnlock()
+			for _, rng := range ranges {
+				if !rng.IsLeader() {
+					continue
+				}
+				if !s.stopper.RunTask(func() { s.gcRangeTxns(rng) }) {
+					return
+				}
+			}
+		case <-s.stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// gcRangeTxns pushes the transaction of each intent in the range
+// which hasn't been heartbeat within txnLivenessThreshold. Pushes are
+// sent with ExpiredOnly set, so the pushee's transaction record has
+// the final say on liveness. Intents of transactions which are found
+// to be aborted or committed are resolved.
+func (s *Store) gcRangeTxns(rng *Range) {
+	now := s.clock.Now()
+	expiry := now
+	expiry.WallTime -= txnLivenessThreshold.Nanoseconds()
+
+	txns := map[string]*proto.Transaction{}
+	intents := map[string][]proto.Key{}
+	err := engine.MVCCIterateIntents(s.engine, rng.Desc.StartKey, rng.Desc.EndKey, func(key proto.Key, meta *proto.MVCCMetadata) (bool, error) {
+		// The intent's copy of the txn is stale, but a txn heartbeat
+		// since the intent was written can't make it look older.
+		lastHeartbeat := meta.Txn.Timestamp
+		if meta.Txn.LastHeartbeat != nil {
+			lastHeartbeat = *meta.Txn.LastHeartbeat
+		}
+		if !lastHeartbeat.Less(expiry) {
+			return false, nil
+		}
+		id := string(meta.Txn.ID)
+		txns[id] = meta.Txn
+		intents[id] = append(intents[id], key)
+		return false, nil
+	})
+	if err != nil {
+		log.Warningf("failed to scan range %d for intents: %s", rng.Desc.RaftID, err)
+		return
+	}
+
+	for id, txn := range txns {
+		pushArgs := &proto.InternalPushTxnRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: now,
+				Key:       txn.ID,
+				User:      UserRoot,
+			},
+			PusheeTxn:   *txn,
+			Abort:       true,
+			ExpiredOnly: true,
+		}
+		pushReply := &proto.InternalPushTxnResponse{}
+		s.db.Sender().Send(&client.Call{Method: proto.InternalPushTxn, Args: pushArgs, Reply: pushReply})
+		if pushErr := pushReply.GoError(); pushErr != nil {
+			log.V(1).Infof("push of possibly abandoned txn %q failed: %s", txn.ID, pushErr)
+			continue
+		}
+		if pushReply.PusheeTxn.Status == proto.ABORTED {
+			s.metrics.txnsAbandoned.Inc(1)
+		}
+		for _, key := range intents[id] {
+			resolveArgs := &proto.InternalResolveIntentRequest{
+				RequestHeader: proto.RequestHeader{
+					Timestamp: pushReply.PusheeTxn.Timestamp,
+					Key:       key,
+					User:      UserRoot,
+					Txn:       pushReply.PusheeTxn,
+				},
+			}
+			resolveReply := &proto.InternalResolveIntentResponse{}
+			if resolveErr := rng.AddCmd(proto.InternalResolveIntent, resolveArgs, resolveReply, false); resolveErr != nil {
+				log.Warningf("resolve %+v failed: %s", resolveArgs, resolveErr)
+			} else {
+				s.metrics.intentsResolved.Inc(1)
+			}
+		}
+	}
+}
+
+// gcRangeVersions garbage collects old versions in the ranges this
+// store leads every mvccGCInterval until the stopper is signaled.
+// Each range's GC threshold advances to the current time less the GC
+// TTL of its zone; ranges in zones without a TTL are left alone.
+func (s *Store) gcRangeVersions() {
+	ticker := time.NewTicker(mvccGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.RLock()
+			ranges := make([]*Range, 0, len(s.ranges))
+			for _, rng := range s.ranges {
+				ranges = append(ranges, rng)
+			}
+			s.mu.RUnlock()
+			for _, rng := range ranges {
+				zone, ok := rng.zoneConfig()
+				if !ok || zone.GC == nil || zone.GC.TTLSeconds <= 0 {
+					continue
+				}
+				if !s.stopper.RunTask(func() { s.gcRange(rng, zone.GC.TTLSeconds) }) {
+					return
+				}
+			}
+		case <-s.stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// gcRange advances the GC threshold of the range to the current time
+// less ttlSeconds, deleting versions which are no longer visible to
+// reads at or after the new threshold.
+func (s *Store) gcRange(rng *Range, ttlSeconds int32) {
+	now := s.clock.Now()
+	threshold := now
+	threshold.WallTime -= (time.Duration(ttlSeconds) * time.Second).Nanoseconds()
+	if !rng.GCThreshold().Less(threshold) {
+		return
+	}
+	gcArgs := &proto.InternalGCRequest{
+		RequestHeader: proto.RequestHeader{
+			Timestamp: now,
+			Key:       rng.Desc.StartKey,
+			EndKey:    rng.Desc.EndKey,
+			User:      UserRoot,
+		},
+		GCThreshold: threshold,
+	}
+	gcReply := &proto.InternalGCResponse{}
+	if err := rng.AddCmd(proto.InternalGC, gcArgs, gcReply, true); err != nil {
+		log.Warningf("failed to garbage collect range %d: %s", rng.RangeID, err)
+		return
+	}
+	s.metrics.versionsGCed.Inc(gcReply.Deleted)
+}
+
 // NewRangeDescriptor creates a new descriptor based on start and end
 // keys and the supplied proto.Replicas slice. It allocates new Raft
 // and range IDs to fill out the supplied replicas.
@@ -445,29 +797,54 @@ func (s *Store) SplitRange(origRng, newRng *Range) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	origRng.Desc.EndKey = append([]byte(nil), newRng.Desc.StartKey...)
+	// The original range's load is now shared with the new range.
+	origRng.load.reset()
 	newRng.Start()
 	s.ranges[newRng.RangeID] = newRng
 	s.rangesByKey = append(s.rangesByKey, newRng)
 	sort.Sort(s.rangesByKey)
+	s.metrics.splits.Inc(1)
 	return nil
 }
 
 // AddRange adds the range to the store's range map and to the sorted
-// rangesByKey slice.
-func (s *Store) AddRange(rng *Range) {
+// rangesByKey slice. Replicas previously removed from the store, as
+// recorded by the range's tombstone, are refused.
+func (s *Store) AddRange(rng *Range) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if removed, err := s.isRemovedReplica(rng.Desc.RaftID, rng.RangeID); err != nil {
+		return err
+	} else if removed {
+		return util.Errorf("replica %d of range %d was removed from store %d", rng.RangeID, rng.Desc.RaftID, s.Ident.StoreID)
+	}
 	rng.Start()
 	s.ranges[rng.RangeID] = rng
 	s.rangesByKey = append(s.rangesByKey, rng)
 	sort.Sort(s.rangesByKey)
+	return nil
+}
+
+// RangeCount returns the number of ranges on the store.
+func (s *Store) RangeCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.ranges)
 }
 
 // RemoveRange removes the range from the store's range map and from
-// the sorted rangesByKey slice.
+// the sorted rangesByKey slice. The range's tombstone is first
+// advanced past the removed replica, so that it can't be recreated
+// by stale Raft messages or snapshots, nor reloaded on restart.
+//
+// TODO(agent): write the tombstones of ranges merged away once ranges
+// can be merged.
 func (s *Store) RemoveRange(rng *Range) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if err := s.writeTombstone(rng.Desc.RaftID, rng.RangeID+1); err != nil {
+		return err
+	}
 	rng.Stop()
 	delete(s.ranges, rng.RangeID)
 	// Find the range in rangesByKey slice and swap it to end of slice
@@ -484,6 +861,30 @@ func (s *Store) RemoveRange(rng *Range) error {
 	return nil
 }
 
+// writeTombstone advances the tombstone of the range with the given
+// Raft ID so that replicas with IDs below nextRangeID are refused.
+// Tombstones are never moved backwards.
+func (s *Store) writeTombstone(raftID, nextRangeID int64) error {
+	tombstone, err := engine.GetRangeTombstone(s.engine, raftID)
+	if err != nil {
+		return err
+	}
+	if tombstone != nil && tombstone.NextRangeID >= nextRangeID {
+		return nil
+	}
+	return engine.SetRangeTombstone(s.engine, raftID, &proto.RangeTombstone{NextRangeID: nextRangeID})
+}
+
+// isRemovedReplica returns whether the replica with the given ID of
+// the range with the given Raft ID lies below the range's tombstone.
+func (s *Store) isRemovedReplica(raftID, rangeID int64) (bool, error) {
+	tombstone, err := engine.GetRangeTombstone(s.engine, raftID)
+	if err != nil || tombstone == nil {
+		return false, err
+	}
+	return rangeID < tombstone.NextRangeID, nil
+}
+
 // CreateSnapshot creates a new snapshot, named using an internal counter.
 func (s *Store) CreateSnapshot() (string, error) {
 	s.mu.Lock()
@@ -503,8 +904,14 @@ func (s *Store) Attrs() proto.Attributes {
 }
 
 // Capacity returns the capacity of the underlying storage engine.
+// The store's engine capacity gauges are updated as a side effect.
 func (s *Store) Capacity() (engine.StoreCapacity, error) {
-	return s.engine.Capacity()
+	capacity, err := s.engine.Capacity()
+	if err == nil {
+		s.metrics.capacity.Update(capacity.Capacity)
+		s.metrics.available.Update(capacity.Available)
+	}
+	return capacity, err
 }
 
 // Descriptor returns a StoreDescriptor including current store
@@ -514,12 +921,21 @@ func (s *Store) Descriptor(nodeDesc *NodeDescriptor) (*StoreDescriptor, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Space reserved for incoming snapshots is reported as used, so
+	// that allocators stop picking the store before it fills.
+	capacity.Available -= s.reservations.reservedBytes()
+	if capacity.Available < 0 {
+		capacity.Available = 0
+	}
 	// Initialize the store descriptor.
 	return &StoreDescriptor{
-		StoreID:  s.Ident.StoreID,
-		Attrs:    s.Attrs(),
-		Node:     *nodeDesc,
-		Capacity: capacity,
+		StoreID:    s.Ident.StoreID,
+		Attrs:      s.Attrs(),
+		Node:       *nodeDesc,
+		Capacity:   capacity,
+		QPS:        s.metrics.requestRate.Value(),
+		RangeCount: s.RangeCount(),
+		Snapshots:  int(s.metrics.snapshotsActive.Value()),
 	}, nil
 }
 
@@ -527,9 +943,63 @@ func (s *Store) Descriptor(nodeDesc *NodeDescriptor) (*StoreDescriptor, error) {
 // method, args & reply into a Raft Cmd struct and executes the
 // command using the fetched range.
 func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Response) error {
+	var err error
+	start := time.Now()
+	// Snapshot copies wait for a slot or for bandwidth before executing,
+	// outside of the stopper task so that a stopping store can drain.
+	var snapshotReservation string
+	if method == proto.InternalSnapshotCopy {
+		iscArgs := args.(*proto.InternalSnapshotCopyRequest)
+		if snapshotReservation, err = s.snapshots.begin(iscArgs, s.snapshotSize(iscArgs), s.stopper.ShouldStop()); err != nil {
+			return err
+		}
+	}
+	if !s.stopper.RunTask(func() {
+		err = s.executeCmd(method, args, reply)
+	}) {
+		err = util.Errorf("store %d is stopping; %s command not executed", s.StoreID(), method)
+	}
+	if method == proto.InternalSnapshotCopy {
+		s.snapshots.end(snapshotReservation, reply.(*proto.InternalSnapshotCopyResponse), err)
+	}
+	s.tagUsage.record(args.Header().Tag, method, args, reply, err)
+	s.metrics.requests.Inc(1)
+	s.metrics.requestRate.Add(1)
+	s.metrics.requestLatency.RecordValue(time.Since(start).Nanoseconds())
+	if err != nil {
+		s.metrics.requestErrors.Inc(1)
+	}
+	return err
+}
+
+// snapshotSize returns the size in bytes of the range addressed by a
+// snapshot copy request which creates a new snapshot, or zero if the
+// request continues an existing one.
+func (s *Store) snapshotSize(args *proto.InternalSnapshotCopyRequest) int64 {
+	if len(args.SnapshotID) > 0 {
+		return 0
+	}
+	ms, err := engine.GetRangeMVCCStats(s.engine, args.Replica.RangeID)
+	if err != nil {
+		log.Warningf("unable to fetch stats for range %d: %s", args.Replica.RangeID, err)
+		return 0
+	}
+	return ms.KeyBytes + ms.ValBytes
+}
+
+// executeCmd fetches the range for the command and adds the command
+// for execution. Invoked from ExecuteCmd as a stopper task.
+func (s *Store) executeCmd(method string, args proto.Request, reply proto.Response) error {
+	if _, err := fault.Hit(fault.StoreExecuteCmd, args); err != nil {
+		return err
+	}
 	// If the request has a zero timestamp, initialize to this node's clock.
 	header := args.Header()
-	if err := verifyKeys(header.Key, header.EndKey); err != nil {
+	if err := verifyKeys(header.Key, header.EndKey, s.limits); err != nil {
+		return err
+	}
+	// Reject oversized requests before they're proposed to Raft.
+	if err := s.limits.VerifyRequest(args); err != nil {
 		return err
 	}
 	if header.Timestamp.WallTime == 0 && header.Timestamp.Logical == 0 {
@@ -547,19 +1017,37 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 		}
 	}
 
+	// Snapshot reservations are made on behalf of the store, not of a
+	// range.
+	if method == proto.InternalReserveSnapshot {
+		return s.reserveSnapshot(args.(*proto.InternalReserveSnapshotRequest), reply.(*proto.InternalReserveSnapshotResponse))
+	}
+
 	// Get range and add command to the range for execution.
 	rng, err := s.GetRange(header.Replica.RangeID)
 	if err != nil {
 		return err
 	}
-	if err := rng.AddCmd(method, args, reply, true); err == nil {
-		return nil
+	// Locks are acquired without a Raft proposal.
+	if proto.IsReadWrite(method) && method != proto.AcquireLock {
+		s.metrics.raftProposals.Inc(1)
+	}
+	start := time.Now()
+	if err = rng.AddCmd(method, args, reply, true); err != nil {
+		// Maybe resolve a potential write intent error. We do this here
+		// because this is the code path with the requesting client
+		// waiting. We don't want every replica to attempt to resolve the
+		// intent independently, so we can't do it in Range.executeCmd.
+		err = s.maybeResolveWriteIntentError(rng, method, args, reply)
+	}
+	if log.TracesActive() {
+		target := log.TraceTarget{Key: header.Key, EndKey: header.EndKey, RangeID: rng.RangeID}
+		if header.Txn != nil {
+			target.TxnID = header.Txn.ID
+		}
+		log.Tracef(target, "range %d: %s %+v -> %+v in %s", rng.RangeID, method, args, reply, time.Since(start))
 	}
-	// Maybe resolve a potential write intent error. We do this here
-	// because this is the code path with the requesting client
-	// waiting. We don't want every replica to attempt to resolve the
-	// intent independently, so we can't do it in Range.executeCmd.
-	return s.maybeResolveWriteIntentError(rng, method, args, reply)
+	return err
 }
 
 // maybeResolveWriteIntentError checks the reply's error. If the error
@@ -611,6 +1099,8 @@ func (s *Store) maybeResolveWriteIntentError(rng *Range, method string, args pro
 		return err
 	}
 
+	s.metrics.intentsPushed.Inc(1)
+
 	// Note that even though we're setting Resolved = true here, it'll
 	// never be set in the response cache (the response containing this
 	// write intent error was cached right after the command was
@@ -637,6 +1127,8 @@ func (s *Store) maybeResolveWriteIntentError(rng *Range, method string, args pro
 	// Add resolve command with wait=false to add to Raft but not wait for completion.
 	if resolveErr := rng.AddCmd(proto.InternalResolveIntent, resolveArgs, resolveReply, false); resolveErr != nil {
 		log.Warningf("resolve %+v failed: +v", resolveArgs, resolveErr)
+	} else {
+		s.metrics.intentsResolved.Inc(1)
 	}
 
 	// If the command is read-write, we must return the error to the
@@ -660,11 +1152,18 @@ type RangeManager interface {
 	DB() *client.KV
 	Allocator() *allocator
 	Gossip() *gossip.Gossip
+	Stopper() *util.Stopper
+	UnavailableThreshold() time.Duration
+	CommandQueue() *CommandQueue
+	QuiesceRange(rng *Range, quiesced bool)
+	RecordValueCompression(logicalBytes, compressedBytes int64)
+	RecordBreakerTrip()
+	RecordFailedFast()
 
 	// Range manipulation methods.
 	NewRangeDescriptor(start, end proto.Key, replicas []proto.Replica) (*proto.RangeDescriptor, error)
 	SplitRange(origRng, newRng *Range) error
-	AddRange(rng *Range)
+	AddRange(rng *Range) error
 	RemoveRange(rng *Range) error
 	CreateSnapshot() (string, error)
 }
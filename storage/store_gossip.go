@@ -0,0 +1,151 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// GossipThresholds determine when a store's descriptor has changed
+// enough since it was last gossiped to warrant gossiping it again.
+type GossipThresholds struct {
+	// CapacityFraction is the fraction of the store's capacity which
+	// must be written or reclaimed since the last gossip.
+	CapacityFraction float64
+	// RangeCount is the number of ranges by which the store's range
+	// count must change since the last gossip.
+	RangeCount int
+	// MaxInterval bounds the time between gossips, regardless of
+	// changes. It must be less than the gossip TTL of the descriptor.
+	MaxInterval time.Duration
+}
+
+// DefaultGossipThresholds gossip a store's descriptor once 1% of its
+// capacity or 5 ranges have changed hands, and at least once a minute.
+var DefaultGossipThresholds = GossipThresholds{
+	CapacityFraction: 0.01,
+	RangeCount:       5,
+	MaxInterval:      1 * time.Minute,
+}
+
+// storeGossip tracks the store descriptor last gossiped. Changes
+// since are measured as deltas of the store's byte counters and range
+// count, which are cheap to read, so that checking whether to gossip
+// doesn't recompute the store's capacity.
+type storeGossip struct {
+	sync.Mutex
+	thresholds     GossipThresholds
+	loadThresholds LoadThresholds   // Determine whether the node is overloaded
+	lastTime       time.Time        // Zero if never gossiped
+	lastBytes      int64            // Store key and value bytes at last gossip
+	lastDesc       *StoreDescriptor // Descriptor last gossiped
+}
+
+// SetGossipThresholds sets the thresholds beyond which changes to the
+// store cause its descriptor to be gossiped.
+func (s *Store) SetGossipThresholds(thresholds GossipThresholds) {
+	s.gossipState.Lock()
+	defer s.gossipState.Unlock()
+	s.gossipState.thresholds = thresholds
+}
+
+// SetLoadThresholds sets the thresholds beyond which the store's node
+// is considered overloaded, both when allocating replicas and when
+// deciding whether to gossip the store's descriptor. It must be called
+// before the store is started.
+func (s *Store) SetLoadThresholds(thresholds LoadThresholds) {
+	s.gossipState.Lock()
+	defer s.gossipState.Unlock()
+	s.gossipState.loadThresholds = thresholds
+	s.allocator.loadThresholds = thresholds
+}
+
+// GossipDescriptor returns the store's descriptor if it's due to be
+// gossiped as of now and records it as gossiped, or nil otherwise. A
+// descriptor is due if the bytes written to the store or its range
+// count changed beyond the store's gossip thresholds since it was
+// last gossiped, if its node became or ceased to be overloaded, or if
+// it was last gossiped longer than the maximum interval ago.
+func (s *Store) GossipDescriptor(nodeDesc *NodeDescriptor, now time.Time) (*StoreDescriptor, error) {
+	s.gossipState.Lock()
+	defer s.gossipState.Unlock()
+	bytes, err := s.storeBytes()
+	if err != nil {
+		return nil, err
+	}
+	g := &s.gossipState
+	if g.lastDesc != nil && now.Sub(g.lastTime) < g.thresholds.MaxInterval {
+		bytesDelta := math.Abs(float64(bytes - g.lastBytes))
+		rangeDelta := s.RangeCount() - g.lastDesc.RangeCount
+		if rangeDelta < 0 {
+			rangeDelta = -rangeDelta
+		}
+		overloadChanged := nodeDesc.Load.Overloaded(g.loadThresholds) !=
+			g.lastDesc.Node.Load.Overloaded(g.loadThresholds)
+		if bytesDelta < g.thresholds.CapacityFraction*float64(g.lastDesc.Capacity.Capacity) &&
+			rangeDelta < g.thresholds.RangeCount && !overloadChanged {
+			return nil, nil
+		}
+	}
+	desc, err := s.Descriptor(nodeDesc)
+	if err != nil {
+		return nil, err
+	}
+	g.lastTime, g.lastBytes, g.lastDesc = now, bytes, desc
+	return desc, nil
+}
+
+// findStores is the store's StoreFinder. It returns the gossiped
+// descriptors of stores matching the required attributes.
+func (s *Store) findStores(required proto.Attributes) ([]*StoreDescriptor, error) {
+	if s.gossip == nil {
+		return nil, util.Errorf("store descriptors aren't available without gossip")
+	}
+	infos, err := s.gossip.GetGroupInfos(gossip.KeyStoreDescriptorPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var found []*StoreDescriptor
+	for _, info := range infos {
+		desc, ok := info.(StoreDescriptor)
+		if ok && required.IsSubset(*desc.CombinedAttrs()) {
+			found = append(found, &desc)
+		}
+	}
+	return found, nil
+}
+
+// storeBytes returns the total key and value bytes of the store.
+func (s *Store) storeBytes() (int64, error) {
+	keyBytes, err := engine.GetStoreStat(s.engine, s.Ident.StoreID, engine.StatKeyBytes)
+	if err != nil {
+		return 0, err
+	}
+	valBytes, err := engine.GetStoreStat(s.engine, s.Ident.StoreID, engine.StatValBytes)
+	if err != nil {
+		return 0, err
+	}
+	return keyBytes + valBytes, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestStoreGossipDescriptor verifies that a store's descriptor is
+// gossiped only when bytes written or its range count change beyond
+// the gossip thresholds, when its node becomes or ceases to be
+// overloaded, or when the maximum interval has elapsed.
+func TestStoreGossipDescriptor(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	store.SetGossipThresholds(GossipThresholds{
+		CapacityFraction: 0.01,
+		RangeCount:       2,
+		MaxInterval:      time.Minute,
+	})
+	nodeDesc := &NodeDescriptor{NodeID: 1}
+	now := time.Unix(0, 0)
+
+	expectGossip := func(expected bool) *StoreDescriptor {
+		desc, err := store.GossipDescriptor(nodeDesc, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != (desc != nil) {
+			t.Fatalf("expected gossip? %t; got %+v", expected, desc)
+		}
+		return desc
+	}
+
+	// The descriptor is always gossiped first, and then not again
+	// until something changes.
+	expectGossip(true)
+	expectGossip(false)
+
+	// Writing less than 1% of the in-memory store's capacity doesn't
+	// trigger gossip; writing more does.
+	pArgs, pReply := putArgs([]byte("a"), make([]byte, 1<<10), 1)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	expectGossip(false)
+	pArgs, pReply = putArgs([]byte("b"), make([]byte, 10<<10), 1)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	expectGossip(true)
+
+	// A single split doesn't trigger gossip; a second one does.
+	splitTestRange(store, engine.KeyMin, proto.Key("a"), t)
+	expectGossip(false)
+	splitTestRange(store, proto.Key("a"), proto.Key("b"), t)
+	if desc := expectGossip(true); desc.RangeCount != 3 {
+		t.Errorf("expected range count 3; got %d", desc.RangeCount)
+	}
+
+	// The descriptor is gossiped once the maximum interval elapses.
+	now = now.Add(59 * time.Second)
+	expectGossip(false)
+	now = now.Add(time.Second)
+	expectGossip(true)
+
+	// Changes in load don't trigger gossip unless the node becomes or
+	// ceases to be overloaded.
+	store.SetLoadThresholds(LoadThresholds{CPU: 0.9})
+	nodeDesc.Load.CPU = 0.5
+	expectGossip(false)
+	nodeDesc.Load.CPU = 0.95
+	if desc := expectGossip(true); desc.Node.Load.CPU != 0.95 {
+		t.Errorf("expected gossiped CPU load 0.95; got %+v", desc.Node.Load)
+	}
+	nodeDesc.Load.CPU = 0.99
+	expectGossip(false)
+	nodeDesc.Load.CPU = 0.1
+	expectGossip(true)
+}
@@ -50,14 +50,16 @@ var testIdent = proto.StoreIdent{
 
 // createTestStore creates a test store using an in-memory
 // engine. Returns the store clock's manual unix nanos time and the
-// store. The caller is responsible for closing the store on exit.
-func createTestStore(t *testing.T) (*Store, *hlc.ManualClock) {
-	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig())
+// store. The caller is responsible for stopping the returned stopper
+// on exit.
+func createTestStore(t *testing.T) (*Store, *hlc.ManualClock, *util.Stopper) {
+	stopper := util.NewStopper()
+	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig(), stopper)
 	g := gossip.New(rpcContext)
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
-	store := NewStore(clock, eng, nil, g)
+	store := NewStore(clock, eng, nil, g, stopper)
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -68,17 +70,18 @@ func createTestStore(t *testing.T) (*Store, *hlc.ManualClock) {
 	if _, err := store.BootstrapRange(); err != nil {
 		t.Fatal(err)
 	}
-	return store, &manual
+	return store, manual, stopper
 }
 
 // TestStoreInitAndBootstrap verifies store initialization and
 // bootstrap.
 func TestStoreInitAndBootstrap(t *testing.T) {
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
-	store := NewStore(clock, eng, nil, nil)
-	defer store.Close()
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	store := NewStore(clock, eng, nil, nil, stopper)
 
 	// Can't init as haven't bootstrapped.
 	if err := store.Init(); err == nil {
@@ -106,7 +109,7 @@ func TestStoreInitAndBootstrap(t *testing.T) {
 	}
 
 	// Now, attempt to initialize a store with a now-bootstrapped engine.
-	store = NewStore(clock, eng, nil, nil)
+	store = NewStore(clock, eng, nil, nil, stopper)
 	if err := store.Init(); err != nil {
 		t.Errorf("failure initializing bootstrapped store: %s", err)
 	}
@@ -125,10 +128,11 @@ func TestBootstrapOfNonEmptyStore(t *testing.T) {
 	if err := eng.Put(proto.EncodedKey("foo"), []byte("bar")); err != nil {
 		t.Errorf("failure putting key foo into engine: %s", err)
 	}
-	manual := hlc.ManualClock(0)
+	manual := hlc.NewManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
-	store := NewStore(clock, eng, nil, nil)
-	defer store.Close()
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	store := NewStore(clock, eng, nil, nil, stopper)
 
 	// Can't init as haven't bootstrapped.
 	if err := store.Init(); err == nil {
@@ -162,8 +166,8 @@ func TestRangeSliceSort(t *testing.T) {
 // TestStoreExecuteCmd verifies straightforward command execution
 // of both a read-only and a read-write command.
 func TestStoreExecuteCmd(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	gArgs, gReply := getArgs([]byte("a"), 1)
 
 	// Try a successful get request.
@@ -176,17 +180,50 @@ func TestStoreExecuteCmd(t *testing.T) {
 	}
 }
 
+// TestStoreMetrics verifies that command execution is reflected in
+// the store's metrics registry.
+func TestStoreMetrics(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+	pArgs, pReply := putArgs([]byte("a"), []byte("aaa"), 1)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	gArgs.Key = engine.KeyMax
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err == nil {
+		t.Fatal("expected error for start key == KeyMax")
+	}
+	snapshot := store.Registry().Snapshot()
+	expected := map[string]float64{
+		"requests":               3,
+		"requests.errors":        1,
+		"requests.latency-count": 3,
+		"raft.proposals":         1,
+	}
+	for name, val := range expected {
+		if snapshot[name] != val {
+			t.Errorf("expected %s=%f; got %f", name, val, snapshot[name])
+		}
+	}
+}
+
 // TestStoreVerifyKeys checks that key length is enforced and
 // that end keys must sort >= start.
 func TestStoreVerifyKeys(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	tooLongKey := engine.MakeKey(engine.KeyMax, []byte{0})
 
 	// Start with a too-long key on a get.
 	gArgs, gReply := getArgs(tooLongKey, 1)
 	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err == nil {
 		t.Fatal("expected error for key too long")
+	} else if tErr, ok := err.(*proto.RequestTooLargeError); !ok || tErr.Field != "key" {
+		t.Fatalf("expected request too large error for key; got %s", err)
 	}
 	// Try a start key == KeyMax.
 	gArgs.Key = engine.KeyMax
@@ -218,10 +255,28 @@ func TestStoreVerifyKeys(t *testing.T) {
 	}
 }
 
+// TestStoreRequestLimits verifies that the store rejects requests
+// which exceed its configured request limits.
+func TestStoreRequestLimits(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	store.SetRequestLimits(proto.RequestLimits{MaxKeySize: proto.KeyMaxLength, MaxValueSize: 4})
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+	err := store.ExecuteCmd(proto.Put, pArgs, pReply)
+	if tErr, ok := err.(*proto.RequestTooLargeError); !ok || tErr.Field != "value" {
+		t.Fatalf("expected request too large error for value; got %v", err)
+	}
+	pArgs, pReply = putArgs([]byte("a"), []byte("val"), 1)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestStoreExecuteCmdUpdateTime verifies that the node clock is updated.
 func TestStoreExecuteCmdUpdateTime(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	args, reply := getArgs([]byte("a"), 1)
 	args.Timestamp = store.clock.Now()
 	args.Timestamp.WallTime += (100 * time.Millisecond).Nanoseconds()
@@ -238,12 +293,12 @@ func TestStoreExecuteCmdUpdateTime(t *testing.T) {
 // TestStoreExecuteCmdWithZeroTime verifies that no timestamp causes
 // the command to assume the node's wall time.
 func TestStoreExecuteCmdWithZeroTime(t *testing.T) {
-	store, mc := createTestStore(t)
-	defer store.Close()
+	store, mc, stopper := createTestStore(t)
+	defer stopper.Stop()
 	args, reply := getArgs([]byte("a"), 1)
 
 	// Set clock to time 1.
-	*mc = hlc.ManualClock(1)
+	mc.Set(1)
 	err := store.ExecuteCmd(proto.Get, args, reply)
 	if err != nil {
 		t.Fatal(err)
@@ -260,12 +315,12 @@ func TestStoreExecuteCmdWithZeroTime(t *testing.T) {
 // specifies a timestamp further into the future than the node's
 // maximum allowed clock offset, the cmd fails with an error.
 func TestStoreExecuteCmdWithClockOffset(t *testing.T) {
-	store, mc := createTestStore(t)
-	defer store.Close()
+	store, mc, stopper := createTestStore(t)
+	defer stopper.Stop()
 	args, reply := getArgs([]byte("a"), 1)
 
 	// Set clock to time 1.
-	*mc = hlc.ManualClock(1)
+	mc.Set(1)
 	// Set clock max offset to 250ms.
 	maxOffset := 250 * time.Millisecond
 	store.clock.SetMaxOffset(maxOffset)
@@ -280,8 +335,8 @@ func TestStoreExecuteCmdWithClockOffset(t *testing.T) {
 
 // TestStoreExecuteCmdBadRange passes a bad range.
 func TestStoreExecuteCmdBadRange(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	args, reply := getArgs([]byte("0"), 2) // no range ID 2
 	err := store.ExecuteCmd(proto.Get, args, reply)
 	if err == nil {
@@ -289,6 +344,59 @@ func TestStoreExecuteCmdBadRange(t *testing.T) {
 	}
 }
 
+// TestStoreReserveSnapshot verifies that snapshot reservations are
+// made against the store's receive budget, for ranges of which the
+// store holds no replica.
+func TestStoreReserveSnapshot(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	store.SetSnapshotBudgets(NewSnapshotBudget(0), NewSnapshotBudget(100))
+	reserve := func(id string, bytes int64, release bool) bool {
+		args := &proto.InternalReserveSnapshotRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:     []byte("a"),
+				Replica: proto.Replica{StoreID: 1, RangeID: 2}, // no range ID 2
+			},
+			ReservationID: id,
+			Bytes:         bytes,
+			Release:       release,
+		}
+		reply := &proto.InternalReserveSnapshotResponse{}
+		if err := store.ExecuteCmd(proto.InternalReserveSnapshot, args, reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply.Reserved
+	}
+	if !reserve("a", 80, false) {
+		t.Fatal("expected reservation within budget")
+	}
+	if reserve("b", 80, false) {
+		t.Error("expected reservation beyond budget to be refused")
+	}
+	reserve("a", 0, true)
+	if !reserve("b", 80, false) {
+		t.Error("expected reservation after release")
+	}
+	// Reservations are refused once they would fill the store, and the
+	// reserved space is reported as used.
+	store.SetSnapshotBudgets(NewSnapshotBudget(0), NewSnapshotBudget(0))
+	if reserve("c", 1<<20, false) {
+		t.Error("expected reservation beyond the store's capacity to be refused")
+	}
+	desc, err := store.Descriptor(&NodeDescriptor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capacity, err := store.Capacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Capacity.Available != capacity.Available-80 {
+		t.Errorf("expected 80 reserved bytes to be reported as used; got %d of %d available",
+			desc.Capacity.Available, capacity.Available)
+	}
+}
+
 func splitTestRange(store *Store, key, splitKey proto.Key, t *testing.T) *Range {
 	rng := store.LookupRange(key, key)
 	if rng == nil {
@@ -308,8 +416,8 @@ func splitTestRange(store *Store, key, splitKey proto.Key, t *testing.T) *Range
 // TestStoreExecuteCmdOutOfRange passes a key not contained
 // within the range's key range.
 func TestStoreExecuteCmdOutOfRange(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	// Split the range and then remove the second half to clear up some space.
 	rng := splitTestRange(store, engine.KeyMin, proto.Key("a"), t)
 	if err := store.RemoveRange(rng); err != nil {
@@ -324,11 +432,138 @@ func TestStoreExecuteCmdOutOfRange(t *testing.T) {
 	}
 }
 
+// TestStoreRemoveRangeTombstone verifies that a replica removed from
+// a store can be neither added again nor reloaded on restart, while a
+// new replica of the same range can.
+func TestStoreRemoveRangeTombstone(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	rng := splitTestRange(store, engine.KeyMin, proto.Key("a"), t)
+	if err := store.RemoveRange(rng); err != nil {
+		t.Fatal(err)
+	}
+	tombstone, err := engine.GetRangeTombstone(store.Engine(), rng.Desc.RaftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstone == nil || tombstone.NextRangeID != rng.RangeID+1 {
+		t.Fatalf("expected tombstone with next range ID %d; got %+v", rng.RangeID+1, tombstone)
+	}
+
+	// The removed replica can't be added again.
+	if err := store.AddRange(NewRange(rng.RangeID, rng.Desc, store)); err == nil {
+		t.Error("expected removed replica to be refused")
+	}
+	// Nor is it reloaded from its descriptor on restart.
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if count := store.RangeCount(); count != 1 {
+		t.Errorf("expected 1 range after restart; got %d", count)
+	}
+
+	// A new replica of the range is accepted.
+	desc := *rng.Desc
+	desc.Replicas = []proto.Replica{{StoreID: store.StoreID(), RangeID: rng.RangeID + 1}}
+	if err := store.AddRange(NewRange(rng.RangeID+1, &desc, store)); err != nil {
+		t.Fatal(err)
+	}
+	if count := store.RangeCount(); count != 2 {
+		t.Errorf("expected 2 ranges; got %d", count)
+	}
+}
+
+// TestStoreGCReplicas verifies that a replica which its range's
+// descriptor no longer includes is removed from the store and its
+// data deleted, while other replicas are kept.
+func TestStoreGCReplicas(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	newRng := splitTestRange(store, engine.KeyMin, proto.Key("m"), t)
+	pArgs, pReply := putArgs(proto.Key("n"), []byte("value"), newRng.RangeID)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is deleted while the store's replicas are included.
+	store.gcReplicasOnce()
+	if count := store.RangeCount(); count != 2 {
+		t.Fatalf("expected 2 ranges; got %d", count)
+	}
+
+	// Move the new range's replica to another store.
+	desc := *newRng.Desc
+	desc.Replicas = []proto.Replica{{NodeID: 2, StoreID: 2, RangeID: newRng.RangeID}}
+	if err := store.DB().RunTransaction(&client.TransactionOptions{Name: "move"}, func(txn *client.KV) error {
+		if err := txn.PutProto(makeRangeKey(desc.StartKey), &desc); err != nil {
+			return err
+		}
+		return UpdateRangeAddressing(txn, &desc)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := engine.GetRangeMVCCStats(store.Engine(), newRng.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	liveBytes, err := engine.GetStoreStat(store.Engine(), store.StoreID(), engine.StatLiveBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.gcReplicasOnce()
+	if count := store.RangeCount(); count != 1 {
+		t.Errorf("expected 1 range; got %d", count)
+	}
+	if rng := store.LookupRange(proto.Key("n"), nil); rng != nil {
+		t.Errorf("expected removed replica to be gone; got range %d", rng.RangeID)
+	}
+	kvs, err := engine.Scan(store.Engine(), engine.MVCCEncodeKey(proto.Key("m")), engine.MVCCEncodeKey(engine.KeyMax), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Errorf("expected data of removed replica to be deleted; got %d keys", len(kvs))
+	}
+	if tombstone, err := engine.GetRangeTombstone(store.Engine(), desc.RaftID); err != nil || tombstone == nil {
+		t.Errorf("expected tombstone for removed replica; got %+v, %v", tombstone, err)
+	}
+	if newLiveBytes, err := engine.GetStoreStat(store.Engine(), store.StoreID(), engine.StatLiveBytes); err != nil {
+		t.Fatal(err)
+	} else if newLiveBytes != liveBytes-ms.LiveBytes {
+		t.Errorf("expected store live bytes %d; got %d", liveBytes-ms.LiveBytes, newLiveBytes)
+	}
+}
+
+// TestStoreGCFirstRangeReplica verifies that a replica removed from
+// the first range, which isn't addressed by meta records, is deleted.
+func TestStoreGCFirstRangeReplica(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	rng := store.LookupRange(engine.KeyMin, nil)
+
+	store.gcReplicasOnce()
+	if count := store.RangeCount(); count != 1 {
+		t.Fatalf("expected 1 range; got %d", count)
+	}
+
+	// Move the first range's replica to another store.
+	desc := *rng.Desc
+	desc.Replicas = []proto.Replica{{NodeID: 2, StoreID: 2, RangeID: rng.RangeID}}
+	if err := store.DB().PutProto(makeRangeKey(desc.StartKey), &desc); err != nil {
+		t.Fatal(err)
+	}
+	store.gcReplicasOnce()
+	if count := store.RangeCount(); count != 0 {
+		t.Errorf("expected removed replica of the first range to be deleted; %d ranges remain", count)
+	}
+}
+
 // TestStoreRaftIDAllocation verifies that raft IDs are
 // allocated in successive blocks.
 func TestStoreRaftIDAllocation(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	// Raft IDs should be allocated from ID 2 (first alloc'd range)
 	// to raftIDAllocCount * 3 + 1.
@@ -347,8 +582,8 @@ func TestStoreRaftIDAllocation(t *testing.T) {
 // TestStoreRangesByKey verifies we can lookup ranges by key using
 // the sorted rangesByKey slice.
 func TestStoreRangesByKey(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	r0 := store.LookupRange(engine.KeyMin, engine.KeyMin)
 	r1 := splitTestRange(store, engine.KeyMin, proto.Key("A"), t)
@@ -391,8 +626,8 @@ func TestStoreRangesByKey(t *testing.T) {
 // Retrying the put should succeed for the Resolved case and fail
 // with another WriteIntentError otherwise.
 func TestStoreResolveWriteIntent(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	for i, resolvable := range []bool{true, false} {
 		key := proto.Key(fmt.Sprintf("key-%d", i))
@@ -453,8 +688,8 @@ func TestStoreResolveWriteIntent(t *testing.T) {
 // TestStoreResolveWriteIntentRollback verifies that resolving a write
 // intent by aborting it yields the previous value.
 func TestStoreResolveWriteIntentRollback(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	key := proto.Key("a")
 	pusher := newTransaction("test", key, 1, proto.SERIALIZABLE, store.clock)
@@ -492,8 +727,8 @@ func TestStoreResolveWriteIntentRollback(t *testing.T) {
 // write intent for a read will push the timestamp. On failure to
 // push, verify a write intent error is returned with !Resolvable.
 func TestStoreResolveWriteIntentPushOnRead(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	testCases := []struct {
 		resolvable bool
@@ -596,8 +831,8 @@ func TestStoreResolveWriteIntentPushOnRead(t *testing.T) {
 // TestStoreResolveWriteIntentSnapshotIsolation verifies that the
 // timestamp can always be pushed if txn has snapshot isolation.
 func TestStoreResolveWriteIntentSnapshotIsolation(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	key := proto.Key("a")
 	pusher := newTransaction("test", key, 1, proto.SERIALIZABLE, store.clock)
@@ -649,8 +884,8 @@ func TestStoreResolveWriteIntentSnapshotIsolation(t *testing.T) {
 // TestStoreResolveWriteIntentNoTxn verifies that reads and writes
 // which are not part of a transaction can push intents.
 func TestStoreResolveWriteIntentNoTxn(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	key := proto.Key("a")
 	pushee := newTransaction("test", key, 1, proto.SERIALIZABLE, store.clock)
@@ -723,6 +958,129 @@ func TestStoreResolveWriteIntentNoTxn(t *testing.T) {
 	}
 }
 
+// TestStoreEndTxnResolvesIntents verifies that intents supplied with
+// EndTransaction are resolved along with the txn record if they lie
+// within the record's range, and are otherwise resolved
+// asynchronously on behalf of the coordinator.
+func TestStoreEndTxnResolvesIntents(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	newRng := splitTestRange(store, engine.KeyMin, proto.Key("m"), t)
+
+	hasIntent := func(key proto.Key) bool {
+		var found bool
+		if err := engine.MVCCIterateIntents(store.Engine(), key, key.Next(), func(proto.Key, *proto.MVCCMetadata) (bool, error) {
+			found = true
+			return true, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+	testCases := [][]proto.Key{
+		{proto.Key("a"), proto.Key("b")},
+		{proto.Key("c"), proto.Key("x"), proto.Key("y")},
+	}
+	for i, keys := range testCases {
+		txn := newTransaction("test", keys[0], 1, proto.SERIALIZABLE, store.clock)
+		var intents []proto.Intent
+		for _, key := range keys {
+			rangeID := int64(1)
+			if !key.Less(proto.Key("m")) {
+				rangeID = newRng.RangeID
+			}
+			pArgs, pReply := putArgs(key, []byte("value"), rangeID)
+			pArgs.Timestamp = txn.Timestamp
+			pArgs.Txn = txn
+			if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+				t.Fatal(err)
+			}
+			intents = append(intents, proto.Intent{Key: key})
+		}
+
+		etArgs, etReply := endTxnArgs(txn, true, 1)
+		etArgs.Timestamp = txn.Timestamp
+		etArgs.Intents = intents
+		if err := store.ExecuteCmd(proto.EndTransaction, etArgs, etReply); err != nil {
+			t.Fatal(err)
+		}
+		if !etReply.Resolved {
+			t.Errorf("%d: expected intents to be resolved", i)
+		}
+		for _, key := range keys {
+			// Local intents are resolved with the commit.
+			if key.Less(proto.Key("m")) {
+				if hasIntent(key) {
+					t.Errorf("%d: expected intent at %q to be resolved with the commit", i, key)
+				}
+				continue
+			}
+			if err := util.IsTrueWithin(func() bool { return !hasIntent(key) }, 500*time.Millisecond); err != nil {
+				t.Errorf("%d: expected remote intent at %q to be resolved: %s", i, key, err)
+			}
+		}
+	}
+
+}
+
+// TestStoreGCAbandonedTxns verifies that the transaction GC aborts
+// transactions which haven't been heartbeat within the liveness
+// threshold and resolves their intents, while leaving live
+// transactions untouched.
+func TestStoreGCAbandonedTxns(t *testing.T) {
+	store, manual, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	abandoned := newTransaction("abandoned", proto.Key("abandoned"), 1, proto.SERIALIZABLE, store.clock)
+	live := newTransaction("live", proto.Key("live"), 1, proto.SERIALIZABLE, store.clock)
+	for _, txn := range []*proto.Transaction{abandoned, live} {
+		pArgs, pReply := putArgs([]byte(txn.Name), []byte("value"), 1)
+		pArgs.Timestamp = store.clock.Now()
+		pArgs.Txn = txn
+		if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Move past the liveness threshold and heartbeat only the live txn.
+	manual.Set(txnLivenessThreshold.Nanoseconds() + 1)
+	hbArgs, hbReply := heartbeatArgs(live, 1)
+	hbArgs.Timestamp = store.clock.Now()
+	if err := store.ExecuteCmd(proto.InternalHeartbeatTxn, hbArgs, hbReply); err != nil {
+		t.Fatal(err)
+	}
+
+	rng, err := store.GetRange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.gcRangeTxns(rng)
+
+	if err := util.IsTrueWithin(func() bool {
+		var keys []proto.Key
+		if err := engine.MVCCIterateIntents(store.Engine(), engine.KeyMin, engine.KeyMax, func(key proto.Key, _ *proto.MVCCMetadata) (bool, error) {
+			keys = append(keys, key)
+			return false, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return reflect.DeepEqual(keys, []proto.Key{proto.Key("live")})
+	}, 500*time.Millisecond); err != nil {
+		t.Errorf("expected only the live txn's intent to remain: %s", err)
+	}
+	if c := store.metrics.txnsAbandoned.Count(); c != 1 {
+		t.Errorf("expected 1 abandoned txn; got %d", c)
+	}
+
+	// The abandoned txn can no longer commit.
+	etArgs, etReply := endTxnArgs(abandoned, true, 1)
+	etArgs.Timestamp = store.clock.Now()
+	err = store.ExecuteCmd(proto.EndTransaction, etArgs, etReply)
+	if _, ok := err.(*proto.TransactionAbortedError); !ok {
+		t.Errorf("expected transaction aborted error; got %v", err)
+	}
+}
+
 func adminSplitArgs(key, splitKey []byte, rangeID int64) (*proto.AdminSplitRequest, *proto.AdminSplitResponse) {
 	args := &proto.AdminSplitRequest{
 		RequestHeader: proto.RequestHeader{
@@ -735,11 +1093,83 @@ func adminSplitArgs(key, splitKey []byte, rangeID int64) (*proto.AdminSplitReque
 	return args, reply
 }
 
+// TestStoreRecoverReplicas verifies that a range which has lost a
+// majority of its replicas is recovered from the surviving replica,
+// and that recovery is refused when it's not acknowledged, when the
+// range still has a quorum, or when the replica sets are invalid.
+func TestStoreRecoverReplicas(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	rng, err := store.GetRange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng.Desc.Replicas = append(rng.Desc.Replicas,
+		proto.Replica{NodeID: 2, StoreID: 2, RangeID: 2},
+		proto.Replica{NodeID: 3, StoreID: 3, RangeID: 3})
+	raftID := rng.Desc.RaftID
+
+	recoverArgs := func(lost []int32, add []proto.Replica, ack bool) *proto.AdminRecoverReplicasRequest {
+		return &proto.AdminRecoverReplicasRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:     engine.KeyMin,
+				Replica: proto.Replica{RangeID: 1},
+			},
+			LostStoreIDs:        lost,
+			AddReplicas:         add,
+			AcknowledgeDataLoss: ack,
+		}
+	}
+	testCases := []*proto.AdminRecoverReplicasRequest{
+		// Not acknowledged.
+		recoverArgs([]int32{2, 3}, nil, false),
+		// The range still has a quorum.
+		recoverArgs([]int32{2}, nil, true),
+		// This replica is lost.
+		recoverArgs([]int32{1, 2}, nil, true),
+		// Store 4 holds no replica.
+		recoverArgs([]int32{2, 4}, nil, true),
+		// Replicas can't be added by recovery.
+		recoverArgs([]int32{2, 3}, []proto.Replica{{NodeID: 4, StoreID: 4}}, true),
+	}
+	for i, args := range testCases {
+		if err := store.ExecuteCmd(proto.AdminRecoverReplicas, args, &proto.AdminRecoverReplicasResponse{}); err == nil {
+			t.Errorf("%d: expected recovery to be refused", i)
+		}
+	}
+
+	reply := &proto.AdminRecoverReplicasResponse{}
+	args := recoverArgs([]int32{2, 3}, nil, true)
+	if err := store.ExecuteCmd(proto.AdminRecoverReplicas, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	replicas := reply.Desc.Replicas
+	if len(replicas) != 1 || replicas[0].StoreID != 1 || replicas[0].RangeID != 1 {
+		t.Errorf("unexpected replicas after recovery: %+v", replicas)
+	}
+	if reply.Desc.RaftID == raftID {
+		t.Errorf("expected a new raft ID; got %d", reply.Desc.RaftID)
+	}
+	if !reflect.DeepEqual(rng.Desc, &reply.Desc) {
+		t.Errorf("expected range descriptor %+v; got %+v", reply.Desc, rng.Desc)
+	}
+	// Both the range's descriptor and its addressing record are rewritten.
+	for _, key := range []proto.Key{makeRangeKey(engine.KeyMin), engine.RangeMetaLookupKey(&reply.Desc)} {
+		desc := &proto.RangeDescriptor{}
+		if ok, _, err := store.db.GetProto(key, desc); err != nil || !ok {
+			t.Fatalf("unable to read descriptor at %q: %v", key, err)
+		}
+		if !reflect.DeepEqual(desc, &reply.Desc) {
+			t.Errorf("expected descriptor %+v at %q; got %+v", reply.Desc, key, desc)
+		}
+	}
+}
+
 // TestStoreRangeSplitAtMeta1 verifies a range cannot be split at
 // a meta1 key.
 func TestStoreRangeSplitAtMeta1(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	args, reply := adminSplitArgs(engine.KeyMin, engine.MakeKey(engine.KeyMeta1Prefix, engine.KeyMax), 1)
 	err := store.ExecuteCmd(proto.AdminSplit, args, reply)
@@ -754,8 +1184,8 @@ func TestStoreRangeSplitAtMeta1(t *testing.T) {
 // arrived for same key.  first one succeeds and second would try to
 // split at the start of the newly split range.
 func TestStoreRangeSplitAtRangeBounds(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	args, reply := adminSplitArgs(engine.KeyMin, []byte("a"), 1)
 	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err != nil {
@@ -772,11 +1202,102 @@ func TestStoreRangeSplitAtRangeBounds(t *testing.T) {
 	}
 }
 
+// TestStoreRangeSplitGossipsEvent verifies that the leader of a split
+// range gossips the descriptors of both resulting ranges.
+func TestStoreRangeSplitGossipsEvent(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	args, reply := adminSplitArgs(engine.KeyMin, []byte("m"), 1)
+	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	newRng := store.LookupRange([]byte("m"), nil)
+	info, err := store.Gossip().GetInfo(gossip.MakeRangeEventGossipKey(newRng.Desc.RaftID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := info.(RangeEvent)
+	if len(event.Descs) != 2 || !bytes.Equal(event.Descs[0].EndKey, []byte("m")) ||
+		!bytes.Equal(event.Descs[1].StartKey, []byte("m")) || !bytes.Equal(event.Descs[1].EndKey, engine.KeyMax) {
+		t.Errorf("unexpected range event %+v", event)
+	}
+}
+
+// TestStoreCommitTriggers verifies that the in-memory side effects of
+// a commit trigger are applied once its transaction commits, and that
+// a trigger which fails aborts the transaction without any effect.
+func TestStoreCommitTriggers(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	rng := store.LookupRange(engine.KeyMin, nil)
+
+	endTxnWithTrigger := func(desc *proto.RangeDescriptor, trigger *proto.CommitTrigger) error {
+		return store.DB().RunTransaction(&client.TransactionOptions{Name: "trigger"}, func(txn *client.KV) error {
+			if err := txn.PutProto(makeRangeKey(desc.StartKey), desc); err != nil {
+				return err
+			}
+			return txn.Call(proto.InternalEndTxn, &proto.InternalEndTxnRequest{
+				RequestHeader: proto.RequestHeader{Key: desc.StartKey},
+				Commit:        true,
+				CommitTrigger: trigger,
+			}, &proto.InternalEndTxnResponse{})
+		})
+	}
+	withReplicas := func(storeIDs ...int32) *proto.RangeDescriptor {
+		desc := *rng.Desc
+		desc.Replicas = nil
+		for _, storeID := range storeIDs {
+			desc.Replicas = append(desc.Replicas, proto.Replica{NodeID: storeID, StoreID: storeID, RangeID: rng.RangeID})
+		}
+		return &desc
+	}
+	badSplit := &proto.SplitTrigger{UpdatedDesc: *rng.Desc, NewDesc: *rng.Desc}
+
+	testCases := []struct {
+		desc     *proto.RangeDescriptor
+		trigger  *proto.CommitTrigger
+		expErr   bool
+		expStore []int32
+	}{
+		// The replica can't remove itself.
+		{withReplicas(2), &proto.CommitTrigger{ChangeReplicasTrigger: &proto.ChangeReplicasTrigger{UpdatedDesc: *withReplicas(2)}}, true, []int32{1}},
+		// At most one trigger may be set.
+		{withReplicas(1, 2), &proto.CommitTrigger{ChangeReplicasTrigger: &proto.ChangeReplicasTrigger{UpdatedDesc: *withReplicas(1, 2)},
+			SplitTrigger: badSplit}, true, []int32{1}},
+		{withReplicas(1, 2), &proto.CommitTrigger{ChangeReplicasTrigger: &proto.ChangeReplicasTrigger{UpdatedDesc: *withReplicas(1, 2)}}, false, []int32{1, 2}},
+		// A replica can't be swapped for another in a single change.
+		{withReplicas(1, 3), &proto.CommitTrigger{ChangeReplicasTrigger: &proto.ChangeReplicasTrigger{UpdatedDesc: *withReplicas(1, 3)}}, true, []int32{1, 2}},
+		{withReplicas(1, 2, 3), &proto.CommitTrigger{ChangeReplicasTrigger: &proto.ChangeReplicasTrigger{UpdatedDesc: *withReplicas(1, 2, 3)}}, false, []int32{1, 2, 3}},
+		{withReplicas(1, 3), &proto.CommitTrigger{ChangeReplicasTrigger: &proto.ChangeReplicasTrigger{UpdatedDesc: *withReplicas(1, 3)}}, false, []int32{1, 3}},
+		// An invalid split leaves the range's replicas and the store's
+		// ranges unchanged.
+		{withReplicas(1, 3), &proto.CommitTrigger{SplitTrigger: badSplit}, true, []int32{1, 3}},
+	}
+	for i, test := range testCases {
+		if err := endTxnWithTrigger(test.desc, test.trigger); (err != nil) != test.expErr {
+			t.Errorf("%d: expected error? %t; got %v", i, test.expErr, err)
+		}
+		rng.RLock()
+		var storeIDs []int32
+		for _, replica := range rng.Desc.Replicas {
+			storeIDs = append(storeIDs, replica.StoreID)
+		}
+		rng.RUnlock()
+		if !reflect.DeepEqual(storeIDs, test.expStore) {
+			t.Errorf("%d: expected replicas on stores %v; got %v", i, test.expStore, storeIDs)
+		}
+		if count := store.RangeCount(); count != 1 {
+			t.Errorf("%d: expected 1 range; got %d", i, count)
+		}
+	}
+}
+
 // TestStoreRangeSplitConcurrent verifies that concurrent range splits
 // of the same range are disallowed.
 func TestStoreRangeSplitConcurrent(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	concurrentCount := int32(10)
 	wg := sync.WaitGroup{}
@@ -806,8 +1327,8 @@ func TestStoreRangeSplitConcurrent(t *testing.T) {
 // resulting ranges respond to the right key ranges and that their stats
 // and response caches have been properly accounted for.
 func TestStoreRangeSplit(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 	rangeID := int64(1)
 	splitKey := proto.Key("m")
 	content := proto.Key("asdvb")
@@ -837,14 +1358,11 @@ func TestStoreRangeSplit(t *testing.T) {
 	}
 
 	// Get the original stats for key and value bytes.
-	keyBytes, err := engine.GetRangeStat(store.engine, rangeID, engine.StatKeyBytes)
-	if err != nil {
-		t.Fatal(err)
-	}
-	valBytes, err := engine.GetRangeStat(store.engine, rangeID, engine.StatValBytes)
+	ms, err := engine.GetRangeMVCCStats(store.engine, rangeID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	keyBytes, valBytes := ms.KeyBytes, ms.ValBytes
 
 	// Split the range.
 	args, reply := adminSplitArgs(engine.KeyMin, splitKey, 1)
@@ -896,22 +1414,16 @@ func TestStoreRangeSplit(t *testing.T) {
 
 	// Compare stats of split ranges to ensure they are non ero and
 	// exceed the original range when summed.
-	lKeyBytes, err := engine.GetRangeStat(store.engine, rangeID, engine.StatKeyBytes)
+	msLeft, err := engine.GetRangeMVCCStats(store.engine, rangeID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	lValBytes, err := engine.GetRangeStat(store.engine, rangeID, engine.StatValBytes)
-	if err != nil {
-		t.Fatal(err)
-	}
-	rKeyBytes, err := engine.GetRangeStat(store.engine, newRng.RangeID, engine.StatKeyBytes)
-	if err != nil {
-		t.Fatal(err)
-	}
-	rValBytes, err := engine.GetRangeStat(store.engine, newRng.RangeID, engine.StatValBytes)
+	msRight, err := engine.GetRangeMVCCStats(store.engine, newRng.RangeID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	lKeyBytes, lValBytes := msLeft.KeyBytes, msLeft.ValBytes
+	rKeyBytes, rValBytes := msRight.KeyBytes, msRight.ValBytes
 
 	if lKeyBytes == 0 || rKeyBytes == 0 {
 		t.Errorf("expected non-zero key bytes; got %d, %d", lKeyBytes, rKeyBytes)
@@ -933,8 +1445,8 @@ func TestStoreRangeSplit(t *testing.T) {
 // splits it halfway and verifies the two splits have stats exactly equaling
 // the pre-split.
 func TestStoreRangeSplitStats(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	// Split the range at the first user key.
 	args, reply := adminSplitArgs(engine.KeyMin, proto.Key("\x01"), 1)
@@ -943,7 +1455,7 @@ func TestStoreRangeSplitStats(t *testing.T) {
 	}
 	// Verify empty range has empty stats.
 	rng := store.LookupRange(proto.Key("\x01"), nil)
-	verifyRangeStats(store.Engine(), rng.RangeID, engine.MVCCStats{}, t)
+	verifyRangeStats(store.Engine(), rng.RangeID, proto.MVCCStats{}, t)
 
 	// Write random data.
 	src := rand.New(rand.NewSource(0))
@@ -979,7 +1491,7 @@ func TestStoreRangeSplitStats(t *testing.T) {
 	}
 
 	// The stats should be exactly equal when added.
-	expMS := engine.MVCCStats{
+	expMS := proto.MVCCStats{
 		LiveBytes:   msLeft.LiveBytes + msRight.LiveBytes,
 		KeyBytes:    msLeft.KeyBytes + msRight.KeyBytes,
 		ValBytes:    msLeft.ValBytes + msRight.ValBytes,
@@ -999,15 +1511,11 @@ func TestStoreRangeSplitStats(t *testing.T) {
 func fillRange(store *Store, rangeID int64, prefix proto.Key, bytes int64, t *testing.T) {
 	src := rand.New(rand.NewSource(0))
 	for {
-		keyBytes, err := engine.GetRangeStat(store.engine, rangeID, engine.StatKeyBytes)
-		if err != nil {
-			t.Fatal(err)
-		}
-		valBytes, err := engine.GetRangeStat(store.engine, rangeID, engine.StatValBytes)
+		ms, err := engine.GetRangeMVCCStats(store.engine, rangeID)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if keyBytes+valBytes >= bytes {
+		if ms.KeyBytes+ms.ValBytes >= bytes {
 			return
 		}
 		key := append(append([]byte(nil), prefix...), []byte(util.RandString(src, 100))...)
@@ -1025,8 +1533,8 @@ func fillRange(store *Store, rangeID int64, prefix proto.Key, bytes int64, t *te
 // It further verifies that the range is in fact split on exceeding
 // zone's RangeMaxBytes.
 func TestStoreShouldSplit(t *testing.T) {
-	store, _ := createTestStore(t)
-	defer store.Close()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
 
 	// Rewrite zone config with range max bytes set to 256K.
 	zoneConfig := &proto.ZoneConfig{
@@ -1062,3 +1570,104 @@ func TestStoreShouldSplit(t *testing.T) {
 		t.Errorf("expected range to split in 1s")
 	}
 }
+
+// TestStoreValueCompression verifies that values written to a zone
+// configured with compression are compressed at rest, read back
+// intact and accounted for in the store's metrics.
+func TestStoreValueCompression(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	zoneConfig := gogoproto.Clone(&testDefaultZoneConfig).(*proto.ZoneConfig)
+	zoneConfig.Compression = "deflate"
+	if err := store.DB().PutProto(engine.MakeKey(engine.KeyConfigZonePrefix, engine.KeyMin), zoneConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := store.LookupRange(engine.KeyMin, nil)
+	key := proto.Key("a")
+	value := bytes.Repeat([]byte("compressible "), 100)
+	pArgs, pReply := putArgs(key, value, rng.RangeID)
+	pArgs.Timestamp = store.Clock().Now()
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := store.Engine().Get(engine.MVCCEncodeVersionKey(key, pArgs.Timestamp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := &proto.MVCCValue{}
+	if err := gogoproto.Unmarshal(data, stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.GetCompression() != proto.DEFLATE || len(stored.Value.Bytes) >= len(value) {
+		t.Errorf("expected value compressed at rest; got %s with %d bytes", stored.GetCompression(), len(stored.Value.Bytes))
+	}
+
+	gArgs, gReply := getArgs(key, rng.RangeID)
+	gArgs.Timestamp = store.Clock().Now()
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gReply.Value.Bytes, value) {
+		t.Errorf("expected decompressed value; got %q", gReply.Value.Bytes)
+	}
+
+	if logical := store.metrics.valuesLogicalBytes.Count(); logical != int64(len(value)) {
+		t.Errorf("expected %d logical bytes; got %d", len(value), logical)
+	}
+	if compressed := store.metrics.valuesCompressedBytes.Count(); compressed != int64(len(stored.Value.Bytes)) {
+		t.Errorf("expected %d compressed bytes; got %d", len(stored.Value.Bytes), compressed)
+	}
+}
+
+// TestStoreShouldSplitByLoad verifies that a range which is under the
+// zone's RangeMaxBytes is split once its request rate exceeds the
+// zone's RangeMaxQPS.
+func TestStoreShouldSplitByLoad(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	zoneConfig := &proto.ZoneConfig{
+		ReplicaAttrs: []proto.Attributes{
+			proto.Attributes{},
+			proto.Attributes{},
+			proto.Attributes{},
+		},
+		RangeMinBytes: 1 << 8,
+		RangeMaxBytes: 1 << 18,
+		RangeMaxQPS:   1,
+	}
+	if err := store.DB().PutProto(engine.MakeKey(engine.KeyConfigZonePrefix, engine.KeyMin), zoneConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := store.LookupRange(engine.KeyMin, nil)
+	if ok := rng.shouldSplit(); ok {
+		t.Errorf("range should not split with no load")
+	}
+
+	// Write small values to keys spread over the range until the
+	// request rate, averaged over a one second tick, triggers a split.
+	if err := util.IsTrueWithin(func() bool {
+		for i := 0; i < 100; i++ {
+			key := proto.Key(fmt.Sprintf("test-%02d", i))
+			pArgs, pReply := putArgs(key, []byte("value"), rng.RangeID)
+			pArgs.Timestamp = store.Clock().Now()
+			if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+				if _, ok := err.(*proto.RangeKeyMismatchError); ok {
+					break
+				}
+				t.Fatal(err)
+			}
+		}
+		newRng := store.LookupRange(proto.Key("test-99"), nil)
+		return newRng != rng
+	}, 5*time.Second); err != nil {
+		t.Fatalf("expected range to split by load in 5s")
+	}
+	if endKey := rng.Desc.EndKey; !proto.Key("test-").Less(endKey) {
+		t.Errorf("expected range to split within the accessed keys; got end key %q", endKey)
+	}
+}
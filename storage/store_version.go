@@ -0,0 +1,256 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"fmt"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// Store format versions. The store format version describes the
+// layout of store-local data on disk. It is persisted under
+// engine.KeyLocalStoreVersion and advanced by the store migrations
+// which run when a store is opened.
+const (
+	// storeVersionBase is the version of stores bootstrapped before
+	// the format version was persisted.
+	storeVersionBase int64 = 1
+	// storeVersionStoreStats rebuilds store-level stat counters from
+	// the range stat counters of all ranges on the store.
+	storeVersionStoreStats int64 = 2
+	// storeVersionResponseCache drops response cache entries which
+	// can't be decoded in the current format.
+	storeVersionResponseCache int64 = 3
+	// storeVersionRangeStats replaces the per-stat range counters
+	// with a single proto.MVCCStats per range.
+	storeVersionRangeStats int64 = 4
+
+	// storeVersionCurrent is the format version written by this
+	// binary. Stores with a newer version are refused.
+	storeVersionCurrent = storeVersionRangeStats
+)
+
+// A storeMigration upgrades the on-disk structures of a store from
+// the preceding format version to version.
+type storeMigration struct {
+	version int64
+	name    string
+	fn      func(s *Store, batch engine.Engine) error
+}
+
+// storeMigrations lists all store migrations in order of increasing
+// version. Migrations must be idempotent: a store which crashes after
+// a migration is applied but before its version is persisted re-runs
+// the migration on the next open.
+var storeMigrations = []storeMigration{
+	{storeVersionStoreStats, "rebuild store stats", migrateStoreStats},
+	{storeVersionResponseCache, "drop undecodable response cache entries", migrateResponseCache},
+	{storeVersionRangeStats, "convert range stat counters to MVCC stats", migrateRangeStats},
+}
+
+// A StoreVersionError indicates that a store was written by a binary
+// using a newer store format version than this binary supports.
+type StoreVersionError struct {
+	Version, MaxVersion int64
+}
+
+// Error formats error.
+func (e *StoreVersionError) Error() string {
+	return fmt.Sprintf("store format version %d is newer than supported version %d",
+		e.Version, e.MaxVersion)
+}
+
+// getStoreVersion returns the format version persisted in the
+// specified engine, or storeVersionBase if none has been written.
+func getStoreVersion(eng engine.Engine) (int64, error) {
+	val := &proto.Value{}
+	ok, _, _, err := engine.GetProto(eng, engine.MVCCEncodeKey(engine.KeyLocalStoreVersion), val)
+	if err != nil || !ok {
+		return storeVersionBase, err
+	}
+	return val.GetInteger(), nil
+}
+
+// setStoreVersion persists the format version to the specified engine.
+func setStoreVersion(eng engine.Engine, version int64) error {
+	_, _, err := engine.PutProto(eng, engine.MVCCEncodeKey(engine.KeyLocalStoreVersion),
+		&proto.Value{Integer: gogoproto.Int64(version)})
+	return err
+}
+
+// migrate reads the store's format version and runs all migrations
+// to bring it up to storeVersionCurrent. Each migration is committed
+// in a batch together with the version it upgrades to. Returns a
+// StoreVersionError if the store was written by a newer binary.
+func (s *Store) migrate() error {
+	version, err := getStoreVersion(s.engine)
+	if err != nil {
+		return err
+	}
+	if version > storeVersionCurrent {
+		return &StoreVersionError{Version: version, MaxVersion: storeVersionCurrent}
+	}
+	for _, m := range storeMigrations {
+		if m.version <= version {
+			continue
+		}
+		log.Infof("%s: migrating from format version %d to %d: %s", s, version, m.version, m.name)
+		batch := s.engine.NewBatch()
+		if err := m.fn(s, batch); err != nil {
+			return util.Errorf("store migration to version %d failed: %s", m.version, err)
+		}
+		if err := setStoreVersion(batch, m.version); err != nil {
+			return err
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+		version = m.version
+	}
+	return nil
+}
+
+// migrateStoreStats rewrites the store-level stat counters as the sum
+// of the stat counters of all ranges on the store.
+func migrateStoreStats(s *Store, batch engine.Engine) error {
+	storeStats := map[string]int64{}
+	start := engine.MVCCEncodeKey(engine.KeyLocalRangeStatPrefix)
+	end := engine.MVCCEncodeKey(engine.KeyLocalRangeStatPrefix.PrefixEnd())
+	if err := batch.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		key, _, _ := engine.MVCCDecodeKey(kv.Key)
+		// Cut the prefix and the range ID to get the stat name.
+		b := key[len(engine.KeyLocalRangeStatPrefix):]
+		b, _ = encoding.DecodeInt(b)
+		val := &proto.Value{}
+		if err := gogoproto.Unmarshal(kv.Value, val); err != nil {
+			return false, util.Errorf("could not decode range stat %q: %s", kv.Key, err)
+		}
+		storeStats[string(b)] += val.GetInteger()
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	// Clear the existing store stats. Batches can't clear a range of
+	// keys, so each key is cleared individually.
+	prefix := engine.MakeStoreStatKey(s.Ident.StoreID, nil)
+	kvs, err := engine.Scan(batch, engine.MVCCEncodeKey(prefix), engine.MVCCEncodeKey(prefix.PrefixEnd()), 0)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		if err := batch.Clear(kv.Key); err != nil {
+			return err
+		}
+	}
+	for stat, statVal := range storeStats {
+		engine.SetStoreStat(batch, s.Ident.StoreID, proto.Key(stat), statVal)
+	}
+	return nil
+}
+
+// migrateResponseCache clears response cache entries whose keys or
+// values can't be decoded. Such entries would otherwise fail every
+// replay of the command which wrote them.
+func migrateResponseCache(s *Store, batch engine.Engine) error {
+	rc := &ResponseCache{}
+	var invalid []proto.EncodedKey
+	start := engine.MVCCEncodeKey(engine.KeyLocalResponseCachePrefix)
+	end := engine.MVCCEncodeKey(engine.KeyLocalResponseCachePrefix.PrefixEnd())
+	if err := batch.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		if _, err := rc.decodeKey(kv.Key); err != nil {
+			invalid = append(invalid, kv.Key)
+		} else if err := gogoproto.Unmarshal(kv.Value, &proto.ReadWriteCmdResponse{}); err != nil {
+			invalid = append(invalid, kv.Key)
+		}
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range invalid {
+		log.Warningf("%s: clearing undecodable response cache entry %q", s, key)
+		if err := batch.Clear(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRangeStats replaces the per-stat counters of each range with
+// the equivalent MVCC stats.
+func migrateRangeStats(s *Store, batch engine.Engine) error {
+	rangeStats := map[int64]*proto.MVCCStats{}
+	var legacy []proto.EncodedKey
+	start := engine.MVCCEncodeKey(engine.KeyLocalRangeStatPrefix)
+	end := engine.MVCCEncodeKey(engine.KeyLocalRangeStatPrefix.PrefixEnd())
+	if err := batch.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		key, _, _ := engine.MVCCDecodeKey(kv.Key)
+		b, rangeID := encoding.DecodeInt(key[len(engine.KeyLocalRangeStatPrefix):])
+		val := &proto.Value{}
+		if err := gogoproto.Unmarshal(kv.Value, val); err != nil {
+			return false, util.Errorf("could not decode range stat %q: %s", kv.Key, err)
+		}
+		ms, ok := rangeStats[rangeID]
+		if !ok {
+			ms = &proto.MVCCStats{}
+			rangeStats[rangeID] = ms
+		}
+		switch stat := proto.Key(b); {
+		case stat.Equal(engine.StatLiveBytes):
+			ms.LiveBytes = val.GetInteger()
+		case stat.Equal(engine.StatKeyBytes):
+			ms.KeyBytes = val.GetInteger()
+		case stat.Equal(engine.StatValBytes):
+			ms.ValBytes = val.GetInteger()
+		case stat.Equal(engine.StatIntentBytes):
+			ms.IntentBytes = val.GetInteger()
+		case stat.Equal(engine.StatLiveCount):
+			ms.LiveCount = val.GetInteger()
+		case stat.Equal(engine.StatKeyCount):
+			ms.KeyCount = val.GetInteger()
+		case stat.Equal(engine.StatValCount):
+			ms.ValCount = val.GetInteger()
+		case stat.Equal(engine.StatIntentCount):
+			ms.IntentCount = val.GetInteger()
+		default:
+			log.Warningf("%s: dropping unknown range stat %q", s, kv.Key)
+		}
+		legacy = append(legacy, kv.Key)
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	for rangeID, ms := range rangeStats {
+		if err := engine.SetRangeMVCCStats(batch, rangeID, ms); err != nil {
+			return err
+		}
+	}
+	// Batches can't clear a range of keys, so each key is cleared
+	// individually.
+	for _, key := range legacy {
+		if err := batch.Clear(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
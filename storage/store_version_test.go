@@ -0,0 +1,158 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// createTestStoreVersionStore bootstraps a store on a new in-memory
+// engine without initializing it.
+func createTestStoreVersionStore(t *testing.T) (*Store, engine.Engine, *util.Stopper) {
+	clock := hlc.NewClock(hlc.NewManualClock(0).UnixNano)
+	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	store := NewStore(clock, eng, nil, nil, stopper)
+	if err := store.Bootstrap(testIdent); err != nil {
+		t.Fatal(err)
+	}
+	return store, eng, stopper
+}
+
+// TestStoreVersionBootstrap verifies that bootstrapping writes the
+// current store format version.
+func TestStoreVersionBootstrap(t *testing.T) {
+	store, eng, stopper := createTestStoreVersionStore(t)
+	defer stopper.Stop()
+	if v, err := getStoreVersion(eng); err != nil || v != storeVersionCurrent {
+		t.Fatalf("expected version %d; got %d, %v", storeVersionCurrent, v, err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStoreVersionNewer verifies that a store written by a newer
+// binary is refused.
+func TestStoreVersionNewer(t *testing.T) {
+	store, eng, stopper := createTestStoreVersionStore(t)
+	defer stopper.Stop()
+	if err := setStoreVersion(eng, storeVersionCurrent+1); err != nil {
+		t.Fatal(err)
+	}
+	err := store.Init()
+	if _, ok := err.(*StoreVersionError); !ok {
+		t.Fatalf("expected store version error; got %v", err)
+	}
+	// The version must not have been modified.
+	if v, err := getStoreVersion(eng); err != nil || v != storeVersionCurrent+1 {
+		t.Errorf("expected version %d; got %d, %v", storeVersionCurrent+1, v, err)
+	}
+}
+
+// setLegacyRangeStat writes a per-stat range counter, as stored
+// before storeVersionRangeStats.
+func setLegacyRangeStat(eng engine.Engine, rangeID int64, stat proto.Key, statVal int64, t *testing.T) {
+	key := engine.MVCCEncodeKey(engine.MakeRangeStatKey(rangeID, stat))
+	if _, _, err := engine.PutProto(eng, key, &proto.Value{Integer: gogoproto.Int64(statVal)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStoreMigrations verifies that opening a store written before
+// the format version was persisted upgrades its on-disk structures.
+func TestStoreMigrations(t *testing.T) {
+	store, eng, stopper := createTestStoreVersionStore(t)
+	defer stopper.Stop()
+	if err := eng.Clear(engine.MVCCEncodeKey(engine.KeyLocalStoreVersion)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Range stats for two ranges and a stale store stat.
+	setLegacyRangeStat(eng, 1, engine.StatLiveBytes, 10, t)
+	setLegacyRangeStat(eng, 2, engine.StatLiveBytes, 5, t)
+	setLegacyRangeStat(eng, 2, engine.StatKeyCount, 3, t)
+	engine.SetStoreStat(eng, testIdent.StoreID, engine.StatValCount, 7)
+
+	// A valid response cache entry and one with a corrupt value.
+	rc := NewResponseCache(1, eng)
+	validID := proto.ClientCmdID{WallTime: 1, Random: 1}
+	if err := rc.PutResponse(validID, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	corruptKey := engine.MVCCEncodeKey(responseCacheKey(1, proto.ClientCmdID{WallTime: 2, Random: 2}))
+	if err := eng.Put(corruptKey, []byte("\xff\xff\xff")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := getStoreVersion(eng); err != nil || v != storeVersionCurrent {
+		t.Fatalf("expected version %d; got %d, %v", storeVersionCurrent, v, err)
+	}
+
+	expStats := map[string]int64{
+		string(engine.StatLiveBytes): 15,
+		string(engine.StatKeyCount):  3,
+		string(engine.StatValCount):  0,
+	}
+	for stat, exp := range expStats {
+		val := &proto.Value{}
+		key := engine.MVCCEncodeKey(engine.MakeStoreStatKey(testIdent.StoreID, proto.Key(stat)))
+		if _, _, _, err := engine.GetProto(eng, key, val); err != nil {
+			t.Fatal(err)
+		}
+		if val.GetInteger() != exp {
+			t.Errorf("expected store stat %s=%d; got %d", stat, exp, val.GetInteger())
+		}
+	}
+
+	// Range stats are converted and the per-stat counters cleared.
+	expRangeStats := map[int64]proto.MVCCStats{
+		1: {LiveBytes: 10},
+		2: {LiveBytes: 5, KeyCount: 3},
+	}
+	for rangeID, exp := range expRangeStats {
+		ms, err := engine.GetRangeMVCCStats(eng, rangeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*ms, exp) {
+			t.Errorf("expected range %d stats %+v; got %+v", rangeID, exp, *ms)
+		}
+	}
+	prefix := engine.MVCCEncodeKey(engine.KeyLocalRangeStatPrefix)
+	if kvs, err := engine.Scan(eng, prefix, prefix.PrefixEnd(), 0); err != nil || len(kvs) != 0 {
+		t.Errorf("expected range stat counters to be cleared; got %d, %v", len(kvs), err)
+	}
+
+	if val, err := eng.Get(corruptKey); err != nil || val != nil {
+		t.Errorf("expected corrupt response cache entry to be cleared; got %q, %v", val, err)
+	}
+	if ok, err := rc.GetResponse(validID, &proto.PutResponse{}); !ok || err != nil {
+		t.Errorf("expected valid response cache entry to survive; got %t, %v", ok, err)
+	}
+}
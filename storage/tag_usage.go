@@ -0,0 +1,143 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// acctRollupInterval is the interval at which a store adds the usage
+// of the tagged requests it has executed to their accounting records.
+const acctRollupInterval = 10 * time.Second
+
+// acctRecordKey returns the key of the accounting record for tag.
+func acctRecordKey(tag string) proto.Key {
+	return engine.MakeKey(engine.KeyAcctRecordPrefix, proto.Key(tag))
+}
+
+// tagUsage aggregates by tag the usage of the tagged requests a store
+// has executed since their last rollup into accounting records.
+type tagUsage struct {
+	mu    sync.Mutex
+	usage map[string]*proto.AcctRecord
+}
+
+func newTagUsage() *tagUsage {
+	return &tagUsage{usage: map[string]*proto.AcctRecord{}}
+}
+
+// record adds a request executed on behalf of tag. The bytes of the
+// reply are counted for successful reads and the bytes of the request
+// for successful writes. Untagged requests are ignored.
+func (tu *tagUsage) record(tag, method string, args proto.Request, reply proto.Response, err error) {
+	if tag == "" {
+		return
+	}
+	var readBytes, writeBytes int64
+	if err == nil {
+		if proto.NeedReadPerm(method) {
+			readBytes = int64(gogoproto.Size(reply))
+		}
+		if proto.NeedWritePerm(method) {
+			writeBytes = int64(gogoproto.Size(args))
+		}
+	}
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+	tu.addLocked(&proto.AcctRecord{Tag: tag, Requests: 1, ReadBytes: readBytes, WriteBytes: writeBytes})
+}
+
+// addLocked adds usage to the aggregate for usage.Tag. Requires that
+// tu.mu is held.
+func (tu *tagUsage) addLocked(usage *proto.AcctRecord) {
+	rec, ok := tu.usage[usage.Tag]
+	if !ok {
+		rec = &proto.AcctRecord{Tag: usage.Tag}
+		tu.usage[usage.Tag] = rec
+	}
+	rec.Requests += usage.Requests
+	rec.ReadBytes += usage.ReadBytes
+	rec.WriteBytes += usage.WriteBytes
+}
+
+// take returns the aggregated usage and resets it.
+func (tu *tagUsage) take() map[string]*proto.AcctRecord {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+	usage := tu.usage
+	tu.usage = map[string]*proto.AcctRecord{}
+	return usage
+}
+
+// restore adds usage which could not be rolled up back into the
+// aggregate, so that it's included in the next rollup.
+func (tu *tagUsage) restore(usage *proto.AcctRecord) {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+	tu.addLocked(usage)
+}
+
+// rollupTagUsage adds the store's aggregated usage of tagged requests
+// to the accounting records every acctRollupInterval until the
+// stopper is signaled.
+func (s *Store) rollupTagUsage() {
+	ticker := time.NewTicker(acctRollupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !s.stopper.RunTask(s.flushTagUsage) {
+				return
+			}
+		case <-s.stopper.ShouldStop():
+			return
+		}
+	}
+}
+
+// flushTagUsage adds the store's aggregated usage of tagged requests
+// to the accounting record of each tag, transactionally so that the
+// rollups of concurrent stores don't clobber each other. Usage which
+// fails to be added is retained for the next rollup.
+func (s *Store) flushTagUsage() {
+	for tag, usage := range s.tagUsage.take() {
+		err := s.db.RunTransaction(&client.TransactionOptions{Name: "rollup tag usage"}, func(txn *client.KV) error {
+			rec := &proto.AcctRecord{}
+			if _, _, err := txn.GetProto(acctRecordKey(tag), rec); err != nil {
+				return err
+			}
+			rec.Tag = tag
+			rec.Requests += usage.Requests
+			rec.ReadBytes += usage.ReadBytes
+			rec.WriteBytes += usage.WriteBytes
+			rec.LastUpdateNanos = s.clock.Now().WallTime
+			return txn.PutProto(acctRecordKey(tag), rec)
+		})
+		if err != nil {
+			log.Warningf("failed to roll up usage of tag %q: %s", tag, err)
+			s.tagUsage.restore(usage)
+		}
+	}
+}
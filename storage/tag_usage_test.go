@@ -0,0 +1,92 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package storage
+
+import (
+	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestTagUsageRollup verifies that the usage of tagged requests is
+// aggregated by tag and added to the tags' accounting records on each
+// rollup, and that untagged requests aren't accounted.
+func TestTagUsageRollup(t *testing.T) {
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	getAcctRecord := func(tag string) *proto.AcctRecord {
+		rec := &proto.AcctRecord{}
+		ok, _, err := store.DB().GetProto(acctRecordKey(tag), rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return nil
+		}
+		return rec
+	}
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+	pArgs.Tag = "app1"
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	writeBytes := int64(gogoproto.Size(pArgs))
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	gArgs.Tag = "app1"
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+	readBytes := int64(gogoproto.Size(gReply))
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	gArgs.Tag = "app2"
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+
+	store.flushTagUsage()
+	if rec := getAcctRecord("app1"); rec == nil || rec.Requests != 2 ||
+		rec.ReadBytes != readBytes || rec.WriteBytes != writeBytes {
+		t.Errorf("unexpected accounting record for app1: %+v", rec)
+	}
+	if rec := getAcctRecord("app2"); rec == nil || rec.Requests != 1 ||
+		rec.ReadBytes != readBytes || rec.WriteBytes != 0 {
+		t.Errorf("unexpected accounting record for app2: %+v", rec)
+	}
+	if rec := getAcctRecord(""); rec != nil {
+		t.Errorf("unexpected accounting record for untagged requests: %+v", rec)
+	}
+
+	// A subsequent rollup adds to the existing record.
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	gArgs.Tag = "app1"
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+	store.flushTagUsage()
+	if rec := getAcctRecord("app1"); rec == nil || rec.Requests != 3 ||
+		rec.ReadBytes != 2*readBytes || rec.WriteBytes != writeBytes {
+		t.Errorf("unexpected accounting record for app1: %+v", rec)
+	}
+}
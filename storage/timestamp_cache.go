@@ -81,6 +81,16 @@ func (tc *TimestampCache) Clear(clock *hlc.Clock) {
 	tc.latest = tc.lowWater
 }
 
+// SetLowWater ratchets the low water mark to the specified timestamp
+// if it's greater than the current low water mark. Subsequent writes
+// at or below the low water mark will have their timestamps pushed
+// past it.
+func (tc *TimestampCache) SetLowWater(lowWater proto.Timestamp) {
+	if tc.lowWater.Less(lowWater) {
+		tc.lowWater = lowWater
+	}
+}
+
 // Add the specified timestamp to the cache as covering the range of
 // keys from start to end. If end is nil, the range covers the start
 // key only. txnMD5 is empty for no transaction. readOnly specifies
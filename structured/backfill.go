@@ -0,0 +1,267 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var (
+	// backfillChunkSize is the maximum number of key-value pairs of
+	// table data read by each backfill transaction.
+	backfillChunkSize = int64(1000)
+	// backfillMaxRate is the maximum number of index entries written
+	// by a backfill per second.
+	backfillMaxRate = 5000
+)
+
+// AddIndex adds the secondary index to the named table and backfills
+// its entries for the table's existing rows. The index is added in
+// the backfilling state, in which writes maintain it but it may not
+// be read, and becomes readable once the backfill completes. Both
+// steps publish a new version of the table's descriptor, and the
+// change is made under the table's schema change lease. If the
+// backfill is interrupted, BackfillIndex resumes it.
+func (db *structuredDB) AddIndex(table string, index IndexDescriptor) error {
+	desc, err := db.DescribeTable(table)
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return fmt.Errorf("table %q does not exist", table)
+	}
+	if err := db.acquireLease(desc.ID); err != nil {
+		return err
+	}
+	defer db.releaseLease(desc.ID)
+	if err := db.waitForVersionGate(desc); err != nil {
+		return err
+	}
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "add index"}, func(txn *client.KV) error {
+		current, err := lookupTable(txn, table)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.ID != desc.ID {
+			return fmt.Errorf("table %q does not exist", table)
+		}
+		index.Backfilling = true
+		current.Indexes = append(current.Indexes, index)
+		if err := current.Validate(); err != nil {
+			return err
+		}
+		// Columns and indexes are only ever appended, so existing IDs
+		// are unchanged.
+		current.allocateIDs()
+		if err := publishDescriptor(txn, current); err != nil {
+			return err
+		}
+		desc = current
+		return nil
+	}); err != nil {
+		return err
+	}
+	return db.backfillIndex(desc, index.Name)
+}
+
+// BackfillIndex resumes the backfill of the named, backfilling index
+// of the table under the table's schema change lease. Returns without
+// error if the index isn't backfilling.
+func (db *structuredDB) BackfillIndex(table, indexName string) error {
+	desc, err := db.DescribeTable(table)
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return fmt.Errorf("table %q does not exist", table)
+	}
+	if err := db.acquireLease(desc.ID); err != nil {
+		return err
+	}
+	defer db.releaseLease(desc.ID)
+	return db.backfillIndex(desc, indexName)
+}
+
+// backfillIndex writes the entries of the named, backfilling index of
+// the table described by desc for all existing rows and then makes
+// the index readable. The table's rows are read in chunks, each of
+// which is indexed by a transaction that also checkpoints the
+// progress of the backfill, so the backfill resumes where it left off
+// if interrupted. Index entries are written at no more than
+// backfillMaxRate per second. The caller must hold the table's schema
+// change lease, which is extended before each chunk.
+func (db *structuredDB) backfillIndex(desc *TableDescriptor, indexName string) error {
+	i := desc.findIndexByName(indexName)
+	if i < 0 {
+		return fmt.Errorf("table %q: unknown index %q", desc.Name, indexName)
+	}
+	if !desc.Indexes[i].Backfilling {
+		return nil
+	}
+	checkpointKey := MakeIndexBackfillKey(desc.ID, desc.Indexes[i].ID)
+	for done := false; !done; {
+		if err := db.acquireLease(desc.ID); err != nil {
+			return err
+		}
+		start := time.Now()
+		var count int
+		if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "backfill index"}, func(txn *client.KV) error {
+			var err error
+			done, count, err = backfillChunk(txn, desc, i, checkpointKey)
+			return err
+		}); err != nil {
+			return err
+		}
+		// Rate limit the writing of index entries.
+		wait := time.Duration(count)*time.Second/time.Duration(backfillMaxRate) - time.Since(start)
+		if done || wait <= 0 {
+			continue
+		}
+		select {
+		case <-time.After(wait):
+		case <-db.stopper.ShouldStop():
+			return fmt.Errorf("backfill of index %q of table %q interrupted", indexName, desc.Name)
+		}
+	}
+
+	// Make the index readable.
+	if err := db.waitForVersionGate(desc); err != nil {
+		return err
+	}
+	if err := db.acquireLease(desc.ID); err != nil {
+		return err
+	}
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "publish index"}, func(txn *client.KV) error {
+		current, err := lookupTable(txn, desc.Name)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.ID != desc.ID {
+			return fmt.Errorf("table %q was dropped during backfill", desc.Name)
+		}
+		if j := current.findIndexByName(indexName); j >= 0 {
+			current.Indexes[j].Backfilling = false
+		}
+		if err := txn.Call(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: checkpointKey},
+		}, &proto.DeleteResponse{}); err != nil {
+			return err
+		}
+		return publishDescriptor(txn, current)
+	}); err != nil {
+		return err
+	}
+	log.Infof("backfilled index %q of table %q", indexName, desc.Name)
+	return nil
+}
+
+// backfillChunk writes the entries of the index at position i in
+// desc.Indexes for the complete rows of the chunk of table data which
+// follows the backfill's checkpoint, and advances the checkpoint past
+// them. Returns whether all rows have been indexed and the number of
+// entries written.
+func backfillChunk(txn *client.KV, desc *TableDescriptor, i int, checkpointKey proto.Key) (bool, int, error) {
+	index := &desc.Indexes[i]
+	resumeKey := desc.makeIndexPrefix(&desc.PrimaryIndex)
+	endKey := resumeKey.PrefixEnd()
+	if _, _, err := txn.GetI(checkpointKey, &resumeKey); err != nil {
+		return false, 0, err
+	}
+	if !resumeKey.Less(endKey) {
+		return true, 0, nil
+	}
+	// A row has at most one pair per column, so a chunk holds at
+	// least one complete row.
+	maxResults := backfillChunkSize
+	if min := int64(2 * len(desc.Columns)); maxResults < min {
+		maxResults = min
+	}
+	sReply := &proto.ScanResponse{}
+	if err := txn.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{Key: resumeKey, EndKey: endKey},
+		MaxResults:    maxResults,
+	}, sReply); err != nil {
+		return false, 0, err
+	}
+
+	// Group the pairs by row. If the chunk is full, its last row may
+	// be incomplete and is left to the next chunk.
+	var rows [][]proto.KeyValue
+	for _, kv := range sReply.Rows {
+		if n := len(rows); n > 0 && bytes.HasPrefix(kv.Key, rows[n-1][0].Key) {
+			rows[n-1] = append(rows[n-1], kv)
+		} else {
+			rows = append(rows, []proto.KeyValue{kv})
+		}
+	}
+	done := int64(len(sReply.Rows)) < maxResults
+	if !done {
+		resumeKey = rows[len(rows)-1][0].Key
+		rows = rows[:len(rows)-1]
+	}
+
+	for _, kvs := range rows {
+		row, err := desc.DecodeRow(kvs)
+		if err != nil {
+			return false, 0, err
+		}
+		_, indexKVs, err := desc.EncodeRow(row)
+		if err != nil {
+			return false, 0, err
+		}
+		entry := indexKVs[i]
+		if index.Unique {
+			// Writes to the table may already have added the entry.
+			existing, err := getValue(txn, entry.Key)
+			if err != nil {
+				return false, 0, err
+			}
+			if existing != nil && !bytes.Equal(existing.Bytes, entry.Value.Bytes) {
+				return false, 0, fmt.Errorf("table %q: duplicate entry %q in unique index %q", desc.Name, entry.Key, index.Name)
+			}
+		}
+		if err := txn.Call(proto.Put, &proto.PutRequest{
+			RequestHeader: proto.RequestHeader{Key: entry.Key},
+			Value:         entry.Value,
+		}, &proto.PutResponse{}); err != nil {
+			return false, 0, err
+		}
+	}
+
+	if done {
+		resumeKey = endKey
+	}
+	return done, len(rows), txn.PutI(checkpointKey, resumeKey)
+}
+
+// getValue returns the value at key, or nil if there is none.
+func getValue(txn *client.KV, key proto.Key) (*proto.Value, error) {
+	reply := &proto.GetResponse{}
+	if err := txn.Call(proto.Get, &proto.GetRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+	}, reply); err != nil {
+		return nil, err
+	}
+	return reply.Value, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// putRows writes the rows of the table described by desc.
+func putRows(t *testing.T, kvDB *client.KV, desc *TableDescriptor, rows []Row) {
+	for _, row := range rows {
+		rowKVs, _, err := desc.EncodeRow(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range rowKVs {
+			if err := kvDB.Call(proto.Put, &proto.PutRequest{
+				RequestHeader: proto.RequestHeader{Key: kv.Key},
+				Value:         kv.Value,
+			}, &proto.PutResponse{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// countIndexEntries returns how many of the index entries of rows
+// exist.
+func countIndexEntries(t *testing.T, kvDB *client.KV, desc *TableDescriptor, rows []Row) int {
+	var count int
+	for _, row := range rows {
+		_, indexKVs, err := desc.EncodeRow(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range indexKVs {
+			reply := &proto.GetResponse{}
+			if err := kvDB.Call(proto.Get, &proto.GetRequest{
+				RequestHeader: proto.RequestHeader{Key: kv.Key},
+			}, reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Value != nil {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// TestAddIndex verifies that an index added to a table is backfilled
+// for existing rows and made readable, and that the backfill of a
+// unique index fails on duplicate values.
+func TestAddIndex(t *testing.T) {
+	defer setDescriptorCacheTTL(time.Millisecond)()
+	db, stopper := createTestDB(t)
+	defer stopper.Stop()
+	localDB := db.kvDB
+	desc := &TableDescriptor{
+		Name: "users",
+		Columns: []ColumnDescriptor{
+			{Name: "id", Type: columnTypeInteger},
+			{Name: "name", Type: columnTypeString},
+			{Name: "city", Type: columnTypeString},
+		},
+		PrimaryIndex: IndexDescriptor{ColumnNames: []string{"id"}},
+	}
+	if err := db.CreateTable(desc); err != nil {
+		t.Fatal(err)
+	}
+	rows := []Row{
+		{"id": int64(1), "name": "alice", "city": "nyc"},
+		{"id": int64(2), "name": "bob", "city": "sf"},
+		{"id": int64(3), "name": "carol", "city": "nyc"},
+	}
+	putRows(t, localDB, desc, rows)
+
+	if err := db.AddIndex("users", IndexDescriptor{Name: "by_name", Unique: true, ColumnNames: []string{"name"}}); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := db.DescribeTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(desc.Indexes) != 1 || desc.Indexes[0].Backfilling || desc.Indexes[0].ID == 0 {
+		t.Fatalf("expected readable index; got %+v", desc.Indexes)
+	}
+	if count := countIndexEntries(t, localDB, desc, rows); count != len(rows) {
+		t.Errorf("expected %d index entries; got %d", len(rows), count)
+	}
+
+	if err := db.AddIndex("users", IndexDescriptor{Name: "by_city", Unique: true, ColumnNames: []string{"city"}}); err == nil {
+		t.Error("expected backfill of unique index over duplicate values to fail")
+	}
+	if err := db.AddIndex("users", IndexDescriptor{Name: "by_name", ColumnNames: []string{"city"}}); err == nil {
+		t.Error("expected error adding duplicate index")
+	}
+}
+
+// TestBackfillIndexResume verifies that a backfill resumes from its
+// checkpoint.
+func TestBackfillIndexResume(t *testing.T) {
+	defer setDescriptorCacheTTL(time.Millisecond)()
+	db, stopper := createTestDB(t)
+	defer stopper.Stop()
+	localDB := db.kvDB
+	desc := &TableDescriptor{
+		Name: "users",
+		Columns: []ColumnDescriptor{
+			{Name: "id", Type: columnTypeInteger},
+			{Name: "name", Type: columnTypeString},
+		},
+		PrimaryIndex: IndexDescriptor{ColumnNames: []string{"id"}},
+		Indexes: []IndexDescriptor{
+			{Name: "by_name", ColumnNames: []string{"name"}},
+		},
+	}
+	if err := db.CreateTable(desc); err != nil {
+		t.Fatal(err)
+	}
+	var rows []Row
+	for i, name := range []string{"a", "b", "c", "d"} {
+		rows = append(rows, Row{"id": int64(i), "name": name})
+	}
+	putRows(t, localDB, desc, rows)
+
+	// Mark the index as backfilling, with the rows preceding the third
+	// already indexed.
+	desc.Indexes[0].Backfilling = true
+	if err := localDB.PutI(MakeTableDescriptorKey(desc.ID), desc); err != nil {
+		t.Fatal(err)
+	}
+	resumeKey, err := desc.PrimaryKey(rows[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpointKey := MakeIndexBackfillKey(desc.ID, desc.Indexes[0].ID)
+	if err := localDB.PutI(checkpointKey, resumeKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.BackfillIndex("users", "by_name"); err != nil {
+		t.Fatal(err)
+	}
+	if count := countIndexEntries(t, localDB, desc, rows[:2]); count != 0 {
+		t.Errorf("expected rows before checkpoint to be skipped; got %d entries", count)
+	}
+	if count := countIndexEntries(t, localDB, desc, rows[2:]); count != 2 {
+		t.Errorf("expected rows after checkpoint to be indexed; got %d entries", count)
+	}
+	if desc, err = db.DescribeTable("users"); err != nil || desc.Indexes[0].Backfilling {
+		t.Errorf("expected index to be readable; got %+v, %v", desc, err)
+	}
+	var key proto.Key
+	if found, _, err := localDB.GetI(checkpointKey, &key); err != nil || found {
+		t.Errorf("expected checkpoint to be cleared; got %q, %v", key, err)
+	}
+}
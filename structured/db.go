@@ -19,17 +19,37 @@
 package structured
 
 import (
+	"fmt"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 )
 
+// tableIDAllocCount is the number of table IDs allocated at a time.
+const tableIDAllocCount = 10
+
+// listTablesBatchSize is the maximum number of table names read by
+// each of the scans which list tables.
+const listTablesBatchSize = 100
+
 // A DB interface provides methods to access a datastore
 // using a structured data API.
 type DB interface {
 	PutSchema(*Schema) error
 	DeleteSchema(*Schema) error
 	GetSchema(string) (*Schema, error)
+	CreateTable(*TableDescriptor) error
+	DescribeTable(string) (*TableDescriptor, error)
+	DropTable(string) error
+	ListTables() ([]string, error)
+	AddIndex(string, IndexDescriptor) error
+	BackfillIndex(string, string) error
+	InsertRows(string, []Row) error
 }
 
 // A structuredDB satisfies the DB interface using the
@@ -37,12 +57,25 @@ type DB interface {
 type structuredDB struct {
 	// kvDB is a client to the monolithic key-value map.
 	kvDB *client.KV
+	// tableIDs allocates IDs for newly created tables.
+	tableIDs *storage.IDAllocator
+	// stopper interrupts schema changes.
+	stopper *util.Stopper
+	// leaseOwner identifies db as the owner of schema change leases.
+	leaseOwner string
 }
 
 // NewDB returns a key-value datastore client which connects to the
-// Cockroach cluster via the supplied gossip instance.
-func NewDB(kvDB *client.KV) DB {
-	return &structuredDB{kvDB: kvDB}
+// Cockroach cluster via the supplied gossip instance. Table ID
+// allocations are run as workers of the supplied stopper, which also
+// interrupts schema changes.
+func NewDB(kvDB *client.KV, stopper *util.Stopper) DB {
+	return &structuredDB{
+		kvDB:       kvDB,
+		tableIDs:   storage.NewIDAllocator(engine.KeyTableIDGenerator, kvDB, 1 /* min ID */, tableIDAllocCount, stopper),
+		stopper:    stopper,
+		leaseOwner: uuid.New(),
+	}
 }
 
 // PutSchema inserts s into the kv store for subsequent
@@ -76,3 +109,173 @@ func (db *structuredDB) GetSchema(key string) (*Schema, error) {
 	}
 	return s, err
 }
+
+// CreateTable validates desc, assigns it a table ID along with column
+// and index IDs and stores it under its name. Returns an error if a
+// table of the same name exists.
+func (db *structuredDB) CreateTable(desc *TableDescriptor) error {
+	if err := desc.Validate(); err != nil {
+		return err
+	}
+	// Allocate the ID outside the transaction, which may be retried.
+	desc.ID = uint32(db.tableIDs.Allocate())
+	desc.allocateIDs()
+	desc.Version = 1
+	desc.ModificationTime = time.Now().UnixNano()
+	return db.kvDB.RunTransaction(&client.TransactionOptions{Name: "create table"}, func(txn *client.KV) error {
+		nameKey := MakeTableNameKey(desc.Name)
+		var id uint32
+		found, _, err := txn.GetI(nameKey, &id)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("table %q already exists", desc.Name)
+		}
+		if err := txn.PutI(nameKey, desc.ID); err != nil {
+			return err
+		}
+		return txn.PutI(MakeTableDescriptorKey(desc.ID), desc)
+	})
+}
+
+// DescribeTable returns the descriptor of the named table, or nil if
+// one does not exist. A nil error is returned when the table cannot
+// be found.
+func (db *structuredDB) DescribeTable(name string) (*TableDescriptor, error) {
+	return lookupTable(db.kvDB, name)
+}
+
+// lookupTable reads the descriptor of the named table through kv,
+// returning nil if the table does not exist.
+func lookupTable(kv *client.KV, name string) (*TableDescriptor, error) {
+	var id uint32
+	found, _, err := kv.GetI(MakeTableNameKey(name), &id)
+	if err != nil || !found {
+		return nil, err
+	}
+	desc := &TableDescriptor{}
+	found, _, err = kv.GetI(MakeTableDescriptorKey(id), desc)
+	if err != nil || !found {
+		desc = nil
+	}
+	return desc, err
+}
+
+// DropTable removes the named table's descriptor along with all of
+// its rows and index entries. The table's schema change lease must
+// be available; it's removed along with the table.
+func (db *structuredDB) DropTable(name string) error {
+	desc, err := db.DescribeTable(name)
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return fmt.Errorf("table %q does not exist", name)
+	}
+	if err := db.acquireLease(desc.ID); err != nil {
+		return err
+	}
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "drop table"}, func(txn *client.KV) error {
+		nameKey := MakeTableNameKey(name)
+		var id uint32
+		found, _, err := txn.GetI(nameKey, &id)
+		if err != nil {
+			return err
+		}
+		if !found || id != desc.ID {
+			return fmt.Errorf("table %q does not exist", name)
+		}
+		for _, key := range []proto.Key{nameKey, MakeTableDescriptorKey(id), MakeSchemaChangeLeaseKey(id)} {
+			if err := txn.Call(proto.Delete, &proto.DeleteRequest{
+				RequestHeader: proto.RequestHeader{Key: key},
+			}, &proto.DeleteResponse{}); err != nil {
+				return err
+			}
+		}
+		prefix := MakeTablePrefix(id)
+		return txn.Call(proto.DeleteRange, &proto.DeleteRangeRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    prefix,
+				EndKey: prefix.PrefixEnd(),
+			},
+		}, &proto.DeleteRangeResponse{})
+	}); err != nil {
+		db.releaseLease(desc.ID)
+		return err
+	}
+	return nil
+}
+
+// ListTables returns the names of all tables in sorted order.
+func (db *structuredDB) ListTables() ([]string, error) {
+	var names []string
+	key := engine.KeyTableNamePrefix
+	for {
+		reply := &proto.ScanResponse{}
+		if err := db.kvDB.Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    key,
+				EndKey: engine.KeyTableNamePrefix.PrefixEnd(),
+			},
+			MaxResults: listTablesBatchSize,
+		}, reply); err != nil {
+			return nil, err
+		}
+		for _, kv := range reply.Rows {
+			names = append(names, string(kv.Key[len(engine.KeyTableNamePrefix):]))
+		}
+		if len(reply.Rows) < listTablesBatchSize {
+			return names, nil
+		}
+		key = reply.Rows[len(reply.Rows)-1].Key.Next()
+	}
+}
+
+// InsertRows inserts rows into the named table in a single
+// transaction, writing each row's entries of all secondary indexes,
+// including those being backfilled. Returns an error if a row with
+// the same primary key, or the same values of a unique index, exists.
+func (db *structuredDB) InsertRows(table string, rows []Row) error {
+	return db.kvDB.RunTransaction(&client.TransactionOptions{Name: "insert rows"}, func(txn *client.KV) error {
+		// Reading the descriptor within the transaction orders the
+		// insertion with respect to schema changes.
+		desc, err := lookupTable(txn, table)
+		if err != nil {
+			return err
+		}
+		if desc == nil {
+			return fmt.Errorf("table %q does not exist", table)
+		}
+		for _, row := range rows {
+			rowKVs, indexKVs, err := desc.EncodeRow(row)
+			if err != nil {
+				return err
+			}
+			if existing, err := getValue(txn, rowKVs[0].Key); err != nil {
+				return err
+			} else if existing != nil {
+				return fmt.Errorf("table %q: duplicate primary key %q", desc.Name, rowKVs[0].Key)
+			}
+			for i, kv := range indexKVs {
+				if !desc.Indexes[i].Unique {
+					continue
+				}
+				if existing, err := getValue(txn, kv.Key); err != nil {
+					return err
+				} else if existing != nil {
+					return fmt.Errorf("table %q: duplicate entry %q in unique index %q", desc.Name, kv.Key, desc.Indexes[i].Name)
+				}
+			}
+			for _, kv := range append(rowKVs, indexKVs...) {
+				if err := txn.Call(proto.Put, &proto.PutRequest{
+					RequestHeader: proto.RequestHeader{Key: kv.Key},
+					Value:         kv.Value,
+				}, &proto.PutResponse{}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -18,12 +18,17 @@
 package structured_test
 
 import (
+	"reflect"
 	"testing"
 
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/server"
+	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/structured"
+	"github.com/cockroachdb/cockroach/util"
 )
 
 func TestPutGetDeleteSchema(t *testing.T) {
@@ -32,11 +37,13 @@ func TestPutGetDeleteSchema(t *testing.T) {
 		t.Fatalf("could not create test schema: %v", err)
 	}
 	e := engine.NewInMem(proto.Attributes{}, 1<<20)
-	localDB, err := server.BootstrapCluster("test-cluster", e)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	localDB, err := server.BootstrapCluster("test-cluster", e, stopper)
 	if err != nil {
 		t.Fatalf("unable to boostrap cluster: %v", err)
 	}
-	db := structured.NewDB(localDB)
+	db := structured.NewDB(localDB, stopper)
 	if err := db.PutSchema(s); err != nil {
 		t.Fatalf("could not register schema: %v", err)
 	}
@@ -58,6 +65,270 @@ func TestPutGetDeleteSchema(t *testing.T) {
 	}
 }
 
+func TestCreateDescribeDropTable(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	localDB, err := server.BootstrapCluster("test-cluster", e, stopper)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	db := structured.NewDB(localDB, stopper)
+	desc := &structured.TableDescriptor{
+		Name: "users",
+		Columns: []structured.ColumnDescriptor{
+			{Name: "id", Type: "integer"},
+			{Name: "name", Type: "string"},
+		},
+		PrimaryIndex: structured.IndexDescriptor{ColumnNames: []string{"id"}},
+	}
+	if err := db.CreateTable(desc); err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	if desc.ID == 0 || desc.Columns[1].ID == 0 {
+		t.Errorf("expected IDs to be assigned; got %+v", desc)
+	}
+	dup := *desc
+	if err := db.CreateTable(&dup); err == nil {
+		t.Error("expected error creating duplicate table")
+	}
+
+	got, err := db.DescribeTable("users")
+	if err != nil {
+		t.Fatalf("could not describe table: %v", err)
+	}
+	if got == nil || got.ID != desc.ID || len(got.Columns) != 2 {
+		t.Fatalf("expected descriptor %+v; got %+v", desc, got)
+	}
+
+	// Write a row and verify that dropping the table removes it.
+	rowKVs, _, err := got.EncodeRow(structured.Row{"id": int64(1), "name": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range rowKVs {
+		if err := localDB.Call(proto.Put, &proto.PutRequest{
+			RequestHeader: proto.RequestHeader{Key: kv.Key},
+			Value:         kv.Value,
+		}, &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DropTable("users"); err != nil {
+		t.Fatalf("could not drop table: %v", err)
+	}
+	if got, err = db.DescribeTable("users"); err != nil || got != nil {
+		t.Errorf("expected table to be dropped; got %+v, %v", got, err)
+	}
+	prefix := structured.MakeTablePrefix(desc.ID)
+	sReply := &proto.ScanResponse{}
+	if err := localDB.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{Key: prefix, EndKey: prefix.PrefixEnd()},
+	}, sReply); err != nil {
+		t.Fatal(err)
+	}
+	if len(sReply.Rows) != 0 {
+		t.Errorf("expected table data to be removed; got %+v", sReply.Rows)
+	}
+	if err := db.DropTable("users"); err == nil {
+		t.Error("expected error dropping missing table")
+	}
+}
+
+func TestListTables(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	localDB, err := server.BootstrapCluster("test-cluster", e, stopper)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	db := structured.NewDB(localDB, stopper)
+	if names, err := db.ListTables(); err != nil || len(names) != 0 {
+		t.Fatalf("expected no tables; got %v, %v", names, err)
+	}
+	for _, name := range []string{"users", "photos", "albums"} {
+		if err := db.CreateTable(&structured.TableDescriptor{
+			Name:         name,
+			Columns:      []structured.ColumnDescriptor{{Name: "id", Type: "integer"}},
+			PrimaryIndex: structured.IndexDescriptor{ColumnNames: []string{"id"}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DropTable("photos"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := db.ListTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expNames := []string{"albums", "users"}; !reflect.DeepEqual(names, expNames) {
+		t.Errorf("expected %v; got %v", expNames, names)
+	}
+}
+
+// putRows writes the rows of the table described by desc.
+func putRows(t *testing.T, kvDB *client.KV, desc *structured.TableDescriptor, rows []structured.Row) {
+	for _, row := range rows {
+		rowKVs, _, err := desc.EncodeRow(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range rowKVs {
+			if err := kvDB.Call(proto.Put, &proto.PutRequest{
+				RequestHeader: proto.RequestHeader{Key: kv.Key},
+				Value:         kv.Value,
+			}, &proto.PutResponse{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestQuery verifies filtering, projection and limits of queries
+// executed through a QuerySender.
+func TestQuery(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	localDB, err := server.BootstrapCluster("test-cluster", e, stopper)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	db := structured.NewDB(localDB, stopper)
+	desc := &structured.TableDescriptor{
+		Name: "users",
+		Columns: []structured.ColumnDescriptor{
+			{Name: "id", Type: "integer"},
+			{Name: "name", Type: "string"},
+			{Name: "score", Type: "float"},
+		},
+		PrimaryIndex: structured.IndexDescriptor{ColumnNames: []string{"id"}},
+	}
+	if err := db.CreateTable(desc); err != nil {
+		t.Fatal(err)
+	}
+	rows := []structured.Row{
+		{"id": int64(1), "name": "alice", "score": 1.5},
+		{"id": int64(2), "name": "bob"},
+		{"id": int64(3), "name": "carol", "score": 3.0},
+		{"id": int64(4), "name": "dave", "score": 0.5},
+	}
+	putRows(t, localDB, desc, rows)
+
+	queryDB := client.NewKV(structured.NewQuerySender(localDB.Sender(), localDB), nil)
+	queryDB.User = storage.UserRoot
+	id := func(op proto.Comparison, v int64) proto.Predicate {
+		return proto.Predicate{Column: "id", Op: op, Value: proto.Datum{IntVal: gogoproto.Int64(v)}}
+	}
+	score := func(op proto.Comparison, v float64) proto.Predicate {
+		return proto.Predicate{Column: "score", Op: op, Value: proto.Datum{FloatVal: gogoproto.Float64(v)}}
+	}
+	testCases := []struct {
+		predicates []proto.Predicate
+		limit      int64
+		expNames   []string
+	}{
+		{nil, 0, []string{"alice", "bob", "carol", "dave"}},
+		{nil, 2, []string{"alice", "bob"}},
+		{[]proto.Predicate{id(proto.EQUAL, 3)}, 0, []string{"carol"}},
+		{[]proto.Predicate{id(proto.GREATER, 1), id(proto.LESS_EQUAL, 3)}, 0, []string{"bob", "carol"}},
+		{[]proto.Predicate{id(proto.NOT_EQUAL, 2)}, 0, []string{"alice", "carol", "dave"}},
+		// NULL scores satisfy no predicate.
+		{[]proto.Predicate{score(proto.GREATER_EQUAL, 1)}, 0, []string{"alice", "carol"}},
+		{[]proto.Predicate{score(proto.LESS, 10)}, 1, []string{"alice"}},
+		{[]proto.Predicate{id(proto.GREATER, 4)}, 0, nil},
+	}
+	for i, test := range testCases {
+		reply, err := queryDB.Query("users", test.predicates, []string{"name"}, test.limit)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(reply.Columns) != 1 || reply.Columns[0] != "name" {
+			t.Errorf("%d: expected name column; got %v", i, reply.Columns)
+		}
+		var names []string
+		for _, row := range reply.Rows {
+			names = append(names, row.Values[0].GetStringVal())
+		}
+		if !reflect.DeepEqual(names, test.expNames) {
+			t.Errorf("%d: expected %v; got %v", i, test.expNames, names)
+		}
+	}
+
+	// Omitting columns returns all of them, with NULLs unset.
+	reply, err := queryDB.Query("users", []proto.Predicate{id(proto.EQUAL, 2)}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Rows) != 1 || len(reply.Rows[0].Values) != 3 ||
+		reply.Rows[0].Values[0].GetIntVal() != 2 || reply.Rows[0].Values[2].FloatVal != nil {
+		t.Errorf("unexpected query result %+v", reply)
+	}
+	if _, err := queryDB.Query("missing", nil, nil, 0); err == nil {
+		t.Error("expected error querying missing table")
+	}
+}
+
+// TestInsertRows verifies that inserted rows may be queried and that
+// insertions violating the primary key or a unique index fail as a
+// whole.
+func TestInsertRows(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	stopper := util.NewStopper()
+	defer stopper.Stop()
+	localDB, err := server.BootstrapCluster("test-cluster", e, stopper)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	db := structured.NewDB(localDB, stopper)
+	desc := &structured.TableDescriptor{
+		Name: "users",
+		Columns: []structured.ColumnDescriptor{
+			{Name: "id", Type: "integer"},
+			{Name: "name", Type: "string"},
+		},
+		PrimaryIndex: structured.IndexDescriptor{ColumnNames: []string{"id"}},
+		Indexes: []structured.IndexDescriptor{
+			{Name: "by_name", Unique: true, ColumnNames: []string{"name"}},
+		},
+	}
+	if err := db.CreateTable(desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRows("users", []structured.Row{
+		{"id": int64(1), "name": "alice"},
+		{"id": int64(2), "name": "bob"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, rows := range [][]structured.Row{
+		{{"id": int64(3), "name": "carol"}, {"id": int64(1), "name": "dave"}},
+		{{"id": int64(3), "name": "carol"}, {"id": int64(4), "name": "bob"}},
+	} {
+		if err := db.InsertRows("users", rows); err == nil {
+			t.Errorf("%d: expected duplicate insertion to fail", i)
+		}
+	}
+	if err := db.InsertRows("missing", []structured.Row{{"id": int64(1)}}); err == nil {
+		t.Error("expected error inserting into missing table")
+	}
+
+	reply := &proto.QueryResponse{}
+	if err := structured.ExecuteQuery(localDB, structured.NewTableCache(localDB),
+		&proto.QueryRequest{Table: "users", Columns: []string{"name"}}, reply); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, row := range reply.Rows {
+		names = append(names, row.Values[0].GetStringVal())
+	}
+	if expNames := []string{"alice", "bob"}; !reflect.DeepEqual(names, expNames) {
+		t.Errorf("expected %v; got %v", expNames, names)
+	}
+}
+
 // User is a top-level table. User IDs are scattered, meaning a two
 // byte hash of the ID from the UserID sequence is prepended to yield
 // a randomly distributed keyspace.
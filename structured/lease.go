@@ -0,0 +1,189 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var (
+	// schemaChangeLeaseDuration is the duration for which a schema
+	// change lease is granted. Leases are extended as a schema change
+	// progresses; a lease which isn't extended in time expires and may
+	// be taken over by another node.
+	schemaChangeLeaseDuration = 30 * time.Second
+	// descriptorCacheTTL is the maximum age of a cached table
+	// descriptor. A new version of a descriptor is only published once
+	// the previous version has been published for at least this long,
+	// so cached descriptors are never more than one version behind.
+	descriptorCacheTTL = 10 * time.Second
+)
+
+// A SchemaChangeLease grants its owner the exclusive right to change
+// the schema of a table until it expires.
+type SchemaChangeLease struct {
+	Owner      string
+	Expiration int64 // Wall time in nanoseconds
+}
+
+// A SchemaChangeLeaseError indicates that the schema change lease of
+// a table is held by another owner.
+type SchemaChangeLeaseError struct {
+	TableID uint32
+	Lease   SchemaChangeLease
+}
+
+// Error implements the error interface.
+func (e *SchemaChangeLeaseError) Error() string {
+	return fmt.Sprintf("schema change lease of table %d is held by %s until %s",
+		e.TableID, e.Lease.Owner, time.Unix(0, e.Lease.Expiration))
+}
+
+// acquireLease acquires or extends the schema change lease of the
+// table with the given ID on behalf of db. Returns a
+// SchemaChangeLeaseError if the lease is held by another owner and
+// hasn't expired.
+func (db *structuredDB) acquireLease(tableID uint32) error {
+	key := MakeSchemaChangeLeaseKey(tableID)
+	return db.kvDB.RunTransaction(&client.TransactionOptions{Name: "acquire schema change lease"}, func(txn *client.KV) error {
+		lease := SchemaChangeLease{}
+		found, _, err := txn.GetI(key, &lease)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if found && lease.Owner != db.leaseOwner && now.UnixNano() < lease.Expiration {
+			return &SchemaChangeLeaseError{TableID: tableID, Lease: lease}
+		}
+		return txn.PutI(key, SchemaChangeLease{
+			Owner:      db.leaseOwner,
+			Expiration: now.Add(schemaChangeLeaseDuration).UnixNano(),
+		})
+	})
+}
+
+// releaseLease releases the schema change lease of the table with the
+// given ID if it's held by db. Failures are logged; the lease expires
+// regardless.
+func (db *structuredDB) releaseLease(tableID uint32) {
+	key := MakeSchemaChangeLeaseKey(tableID)
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "release schema change lease"}, func(txn *client.KV) error {
+		lease := SchemaChangeLease{}
+		found, _, err := txn.GetI(key, &lease)
+		if err != nil || !found || lease.Owner != db.leaseOwner {
+			return err
+		}
+		return txn.Call(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: key},
+		}, &proto.DeleteResponse{})
+	}); err != nil {
+		log.Warningf("failed to release schema change lease of table %d: %s", tableID, err)
+	}
+}
+
+// waitForVersionGate waits until the current version of desc has been
+// published for descriptorCacheTTL, after which no cache holds an
+// earlier version and the next version may be published.
+func (db *structuredDB) waitForVersionGate(desc *TableDescriptor) error {
+	wait := time.Unix(0, desc.ModificationTime).Add(descriptorCacheTTL).Sub(time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-db.stopper.ShouldStop():
+		return fmt.Errorf("schema change of table %q interrupted", desc.Name)
+	}
+}
+
+// publishDescriptor writes desc through txn as the next version of
+// the table's descriptor. Returns an error if the stored descriptor
+// is no longer at desc's version.
+func publishDescriptor(txn *client.KV, desc *TableDescriptor) error {
+	current := &TableDescriptor{}
+	found, _, err := txn.GetI(MakeTableDescriptorKey(desc.ID), current)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("table %q does not exist", desc.Name)
+	}
+	if current.Version != desc.Version {
+		return fmt.Errorf("table %q: descriptor changed concurrently; expected version %d, found %d",
+			desc.Name, desc.Version, current.Version)
+	}
+	desc.Version++
+	desc.ModificationTime = time.Now().UnixNano()
+	return txn.PutI(MakeTableDescriptorKey(desc.ID), desc)
+}
+
+// A TableDescriber returns table descriptors by name.
+type TableDescriber interface {
+	DescribeTable(string) (*TableDescriptor, error)
+}
+
+// A TableCache is a TableDescriber which caches table descriptors for
+// up to descriptorCacheTTL. As schema changes wait out the TTL before
+// publishing each new version of a descriptor, cached descriptors are
+// at most one version behind. A TableCache is safe for concurrent
+// use.
+type TableCache struct {
+	kvDB *client.KV
+
+	mu     sync.Mutex
+	tables map[string]cachedTable
+}
+
+// A cachedTable is a table descriptor cached by a TableCache.
+type cachedTable struct {
+	desc    *TableDescriptor
+	fetched time.Time
+}
+
+// NewTableCache returns a TableCache which reads descriptors through
+// kvDB.
+func NewTableCache(kvDB *client.KV) *TableCache {
+	return &TableCache{kvDB: kvDB, tables: map[string]cachedTable{}}
+}
+
+// DescribeTable implements the TableDescriber interface, returning
+// nil if the table does not exist. Missing tables aren't cached.
+func (tc *TableCache) DescribeTable(name string) (*TableDescriptor, error) {
+	tc.mu.Lock()
+	ct, ok := tc.tables[name]
+	tc.mu.Unlock()
+	if ok && time.Since(ct.fetched) < descriptorCacheTTL {
+		return ct.desc, nil
+	}
+	fetched := time.Now()
+	desc, err := lookupTable(tc.kvDB, name)
+	if err != nil || desc == nil {
+		return desc, err
+	}
+	tc.mu.Lock()
+	tc.tables[name] = cachedTable{desc: desc, fetched: fetched}
+	tc.mu.Unlock()
+	return desc, nil
+}
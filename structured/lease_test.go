@@ -0,0 +1,199 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// createTestDB creates a structuredDB over a single in-memory store.
+// The caller is responsible for stopping the returned stopper.
+func createTestDB(t *testing.T) (*structuredDB, *util.Stopper) {
+	stopper := util.NewStopper()
+	clock := hlc.NewClock(hlc.UnixNano)
+	rpcContext := rpc.NewContext(clock, rpc.LoadInsecureTLSConfig(), stopper)
+	lSender := kv.NewLocalSender()
+	kvDB := client.NewKV(kv.NewCoordinator(lSender, clock, stopper), nil)
+	kvDB.User = storage.UserRoot
+	store := storage.NewStore(clock, engine.NewInMem(proto.Attributes{}, 1<<20), kvDB, gossip.New(rpcContext), stopper)
+	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	lSender.AddStore(store)
+	if _, err := store.BootstrapRange(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return NewDB(kvDB, stopper).(*structuredDB), stopper
+}
+
+// setDescriptorCacheTTL sets descriptorCacheTTL for the duration of
+// a test, returning a function which restores it.
+func setDescriptorCacheTTL(ttl time.Duration) func() {
+	saved := descriptorCacheTTL
+	descriptorCacheTTL = ttl
+	return func() { descriptorCacheTTL = saved }
+}
+
+func createTestTable(t *testing.T, db *structuredDB) *TableDescriptor {
+	desc := &TableDescriptor{
+		Name: "users",
+		Columns: []ColumnDescriptor{
+			{Name: "id", Type: columnTypeInteger},
+			{Name: "name", Type: columnTypeString},
+			{Name: "city", Type: columnTypeString},
+		},
+		PrimaryIndex: IndexDescriptor{ColumnNames: []string{"id"}},
+	}
+	if err := db.CreateTable(desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+// TestSchemaChangeLease verifies that a schema change lease excludes
+// other owners until it's released or expires.
+func TestSchemaChangeLease(t *testing.T) {
+	db, stopper := createTestDB(t)
+	defer stopper.Stop()
+	other := *db
+	other.leaseOwner = "other"
+
+	if err := db.acquireLease(1); err != nil {
+		t.Fatal(err)
+	}
+	// Leases may be extended by their owner only.
+	if err := db.acquireLease(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.acquireLease(1); err == nil {
+		t.Fatal("expected lease to be held")
+	} else if _, ok := err.(*SchemaChangeLeaseError); !ok {
+		t.Fatalf("expected lease error; got %s", err)
+	}
+	// Only the owner releases the lease.
+	other.releaseLease(1)
+	if err := other.acquireLease(1); err == nil {
+		t.Fatal("expected lease to be held")
+	}
+	db.releaseLease(1)
+	if err := other.acquireLease(1); err != nil {
+		t.Fatalf("expected released lease to be acquired; got %s", err)
+	}
+
+	// Expired leases are taken over.
+	if err := db.kvDB.PutI(MakeSchemaChangeLeaseKey(1), SchemaChangeLease{
+		Owner:      other.leaseOwner,
+		Expiration: time.Now().Add(-time.Second).UnixNano(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.acquireLease(1); err != nil {
+		t.Fatalf("expected expired lease to be taken over; got %s", err)
+	}
+}
+
+// TestSchemaChangeHoldsLease verifies that schema changes of a table
+// fail while another owner holds its lease.
+func TestSchemaChangeHoldsLease(t *testing.T) {
+	defer setDescriptorCacheTTL(time.Millisecond)()
+	db, stopper := createTestDB(t)
+	defer stopper.Stop()
+	desc := createTestTable(t, db)
+	other := *db
+	other.leaseOwner = "other"
+	if err := other.acquireLease(desc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddIndex("users", IndexDescriptor{Name: "by_name", ColumnNames: []string{"name"}}); err == nil {
+		t.Error("expected index addition to fail while lease is held")
+	}
+	if err := db.DropTable("users"); err == nil {
+		t.Error("expected drop to fail while lease is held")
+	}
+	other.releaseLease(desc.ID)
+	if err := db.DropTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	// The lease is removed along with the table.
+	lease := SchemaChangeLease{}
+	if found, _, err := db.kvDB.GetI(MakeSchemaChangeLeaseKey(desc.ID), &lease); err != nil || found {
+		t.Errorf("expected lease to be removed; got %+v, %v", lease, err)
+	}
+}
+
+// TestDescriptorVersionGate verifies that schema changes publish new
+// descriptor versions no sooner than descriptorCacheTTL apart, and
+// that a TableCache serves descriptors for up to the TTL.
+func TestDescriptorVersionGate(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	defer setDescriptorCacheTTL(ttl)()
+	db, stopper := createTestDB(t)
+	defer stopper.Stop()
+	desc := createTestTable(t, db)
+	if desc.Version != 1 {
+		t.Fatalf("expected version 1; got %d", desc.Version)
+	}
+	tc := NewTableCache(db.kvDB)
+	if cached, err := tc.DescribeTable("users"); err != nil || cached.Version != 1 {
+		t.Fatalf("expected version 1; got %+v, %v", cached, err)
+	}
+
+	if err := db.AddIndex("users", IndexDescriptor{Name: "by_name", ColumnNames: []string{"name"}}); err != nil {
+		t.Fatal(err)
+	}
+	added, err := db.DescribeTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adding the index and making it readable each publish a version.
+	if added.Version != 3 {
+		t.Fatalf("expected version 3; got %d", added.Version)
+	}
+	if gap := time.Duration(added.ModificationTime - desc.ModificationTime); gap < 2*ttl {
+		t.Errorf("expected versions to be published at least %s apart; got %s", ttl, gap/2)
+	}
+	// The cache may lag by one version only until the TTL passes.
+	if cached, err := tc.DescribeTable("users"); err != nil || added.Version-cached.Version > 1 {
+		t.Errorf("expected cached descriptor at most one version behind; got %+v, %v", cached, err)
+	}
+	time.Sleep(ttl)
+	if cached, err := tc.DescribeTable("users"); err != nil || cached.Version != added.Version {
+		t.Errorf("expected cache to refresh to version %d; got %+v, %v", added.Version, cached, err)
+	}
+
+	// Publishing a stale version fails.
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "test"}, func(txn *client.KV) error {
+		return publishDescriptor(txn, desc)
+	}); err == nil {
+		t.Error("expected publication of stale descriptor to fail")
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"bytes"
+	"fmt"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// queryScanBatchSize is the maximum number of key-value pairs read by
+// each of the scans which execute a query.
+const queryScanBatchSize = 1000
+
+// A QuerySender is a client.KVSender which executes Query calls over
+// the structured data layer and passes all other calls through to the
+// wrapped sender. It lets gateway nodes serve queries alongside the
+// key-value API.
+type QuerySender struct {
+	wrapped client.KVSender
+	kvDB    *client.KV  // Reads table data
+	tables  *TableCache // Caches table descriptors
+}
+
+// NewQuerySender returns a QuerySender which executes queries by
+// reading table descriptors and data through kvDB.
+func NewQuerySender(wrapped client.KVSender, kvDB *client.KV) *QuerySender {
+	return &QuerySender{wrapped: wrapped, kvDB: kvDB, tables: NewTableCache(kvDB)}
+}
+
+// Send implements the client.KVSender interface.
+func (qs *QuerySender) Send(call *client.Call) {
+	if call.Method != proto.Query {
+		qs.wrapped.Send(call)
+		return
+	}
+	reply := call.Reply.(*proto.QueryResponse)
+	if err := ExecuteQuery(qs.kvDB, qs.tables, call.Args.(*proto.QueryRequest), reply); err != nil {
+		reply.SetGoError(err)
+	}
+}
+
+// Close implements the client.KVSender interface.
+func (qs *QuerySender) Close() {
+	qs.wrapped.Close()
+}
+
+// A queryFilter is a predicate resolved against a table descriptor.
+type queryFilter struct {
+	column *ColumnDescriptor
+	op     proto.Comparison
+	value  interface{}
+}
+
+// A queryPlan describes the execution of a query: the span of the
+// primary index to scan, the filters rows must pass and the columns
+// to return.
+type queryPlan struct {
+	desc       *TableDescriptor
+	start, end proto.Key
+	filters    []queryFilter
+	columns    []*ColumnDescriptor
+	limit      int64
+}
+
+// planQuery plans the execution of args over the table described by
+// desc. Predicates on a prefix of the primary key are pushed down to
+// the boundaries of the scan: equality predicates on leading primary
+// key columns narrow the scan to the rows with those values, and
+// range predicates on the column which follows narrow it further. All
+// predicates are nevertheless evaluated against the rows scanned.
+func planQuery(desc *TableDescriptor, args *proto.QueryRequest) (*queryPlan, error) {
+	plan := &queryPlan{desc: desc, limit: args.Limit}
+	for _, p := range args.Predicates {
+		c := desc.findColumnByName(p.Column)
+		if c == nil {
+			return nil, fmt.Errorf("table %q: unknown column %q", desc.Name, p.Column)
+		}
+		v, err := datumValue(c, p.Value)
+		if err != nil {
+			return nil, err
+		}
+		plan.filters = append(plan.filters, queryFilter{column: c, op: p.Op, value: v})
+	}
+	if len(args.Columns) == 0 {
+		for i := range desc.Columns {
+			plan.columns = append(plan.columns, &desc.Columns[i])
+		}
+	}
+	for _, name := range args.Columns {
+		c := desc.findColumnByName(name)
+		if c == nil {
+			return nil, fmt.Errorf("table %q: unknown column %q", desc.Name, name)
+		}
+		plan.columns = append(plan.columns, c)
+	}
+
+	plan.start = desc.makeIndexPrefix(&desc.PrimaryIndex)
+	plan.end = plan.start.PrefixEnd()
+	for _, id := range desc.PrimaryIndex.ColumnIDs {
+		if !plan.pushDown(id) {
+			break
+		}
+	}
+	return plan, nil
+}
+
+// pushDown narrows the scan span by the predicates on the primary key
+// column with the given ID, whose encoded value follows plan.start in
+// the keys of the span's rows. Returns true if the span was narrowed
+// to a single value of the column, in which case predicates on the
+// next primary key column may be pushed down as well.
+func (plan *queryPlan) pushDown(id uint32) bool {
+	base := plan.start
+	encode := func(v interface{}) proto.Key {
+		// Predicate values have been checked against the column type.
+		key, _ := encodeKeyValue(append(proto.Key(nil), base...), plan.desc.findColumn(id), v)
+		return key
+	}
+	for _, f := range plan.filters {
+		if f.column.ID == id && f.op == proto.EQUAL {
+			plan.start = encode(f.value)
+			plan.end = plan.start.PrefixEnd()
+			return true
+		}
+	}
+	for _, f := range plan.filters {
+		if f.column.ID != id {
+			continue
+		}
+		switch f.op {
+		case proto.GREATER_EQUAL:
+			if key := encode(f.value); plan.start.Less(key) {
+				plan.start = key
+			}
+		case proto.GREATER:
+			if key := encode(f.value).PrefixEnd(); plan.start.Less(key) {
+				plan.start = key
+			}
+		case proto.LESS:
+			if key := encode(f.value); key.Less(plan.end) {
+				plan.end = key
+			}
+		case proto.LESS_EQUAL:
+			if key := encode(f.value).PrefixEnd(); key.Less(plan.end) {
+				plan.end = key
+			}
+		}
+	}
+	return false
+}
+
+// ExecuteQuery executes the query described by args, reading the
+// table descriptor from tables and the table's rows through kvDB, and
+// sets the selected rows on reply. The rows are read by a sequence of
+// scans of the planned span; unless the query is transactional, all
+// scans read at the timestamp of the first.
+func ExecuteQuery(kvDB *client.KV, tables TableDescriber, args *proto.QueryRequest, reply *proto.QueryResponse) error {
+	desc, err := tables.DescribeTable(args.Table)
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return fmt.Errorf("table %q does not exist", args.Table)
+	}
+	plan, err := planQuery(desc, args)
+	if err != nil {
+		return err
+	}
+	reply.Columns = make([]string, len(plan.columns))
+	for i, c := range plan.columns {
+		reply.Columns[i] = c.Name
+	}
+	reply.Rows = []proto.QueryRow{}
+
+	var rowKVs []proto.KeyValue
+	// addRow evaluates the row assembled in rowKVs and returns true
+	// once the query's limit has been reached.
+	addRow := func() (bool, error) {
+		if len(rowKVs) == 0 {
+			return false, nil
+		}
+		row, err := desc.DecodeRow(rowKVs)
+		rowKVs = nil
+		if err != nil {
+			return false, err
+		}
+		if !plan.matches(row) {
+			return false, nil
+		}
+		reply.Rows = append(reply.Rows, plan.project(row))
+		return plan.limit > 0 && int64(len(reply.Rows)) >= plan.limit, nil
+	}
+
+	header := args.RequestHeader
+	header.Key, header.EndKey = plan.start, plan.end
+	for header.Key.Less(header.EndKey) {
+		sArgs := &proto.ScanRequest{RequestHeader: header, MaxResults: queryScanBatchSize}
+		sReply := &proto.ScanResponse{}
+		if err := kvDB.Call(proto.Scan, sArgs, sReply); err != nil {
+			return err
+		}
+		reply.Timestamp = sReply.Timestamp
+		reply.Txn = sReply.Txn
+		for _, kv := range sReply.Rows {
+			// A row's column keys extend the key of its first pair.
+			if len(rowKVs) > 0 && bytes.HasPrefix(kv.Key, rowKVs[0].Key) {
+				rowKVs = append(rowKVs, kv)
+				continue
+			}
+			if done, err := addRow(); done || err != nil {
+				return err
+			}
+			rowKVs = append(rowKVs, kv)
+		}
+		if int64(len(sReply.Rows)) < queryScanBatchSize {
+			break
+		}
+		// Continue after the last pair read, at the same timestamp.
+		header.Key = sReply.Rows[len(sReply.Rows)-1].Key.Next()
+		if header.Txn == nil {
+			header.Timestamp = sReply.Timestamp
+		}
+	}
+	_, err = addRow()
+	return err
+}
+
+// matches returns whether row satisfies all of the plan's filters.
+func (plan *queryPlan) matches(row Row) bool {
+	for _, f := range plan.filters {
+		v, ok := row[f.column.Name]
+		if !ok || v == nil {
+			return false
+		}
+		cmp := compareValues(v, f.value)
+		var match bool
+		switch f.op {
+		case proto.EQUAL:
+			match = cmp == 0
+		case proto.NOT_EQUAL:
+			match = cmp != 0
+		case proto.LESS:
+			match = cmp < 0
+		case proto.LESS_EQUAL:
+			match = cmp <= 0
+		case proto.GREATER:
+			match = cmp > 0
+		case proto.GREATER_EQUAL:
+			match = cmp >= 0
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// project returns the values of the plan's columns in row.
+func (plan *queryPlan) project(row Row) proto.QueryRow {
+	qr := proto.QueryRow{Values: make([]proto.Datum, len(plan.columns))}
+	for i, c := range plan.columns {
+		switch t := row[c.Name].(type) {
+		case int64:
+			qr.Values[i].IntVal = gogoproto.Int64(t)
+		case float64:
+			qr.Values[i].FloatVal = gogoproto.Float64(t)
+		case string:
+			qr.Values[i].StringVal = gogoproto.String(t)
+		case []byte:
+			qr.Values[i].BytesVal = t
+		}
+	}
+	return qr
+}
+
+// datumValue returns the value of d as a value of column c. Returns
+// an error if d is NULL or doesn't match the column's type.
+func datumValue(c *ColumnDescriptor, d proto.Datum) (interface{}, error) {
+	switch {
+	case c.Type == columnTypeInteger && d.IntVal != nil:
+		return *d.IntVal, nil
+	case c.Type == columnTypeFloat && d.FloatVal != nil:
+		return *d.FloatVal, nil
+	case c.Type == columnTypeString && d.StringVal != nil:
+		return *d.StringVal, nil
+	case c.Type == columnTypeBlob && d.BytesVal != nil:
+		return d.BytesVal, nil
+	}
+	return nil, fmt.Errorf("column %q: value %s is not a non-NULL %q", c.Name, d.String(), c.Type)
+}
+
+// compareValues returns -1, 0 or 1 as a is less than, equal to or
+// greater than b, which must be of the same type.
+func compareValues(a, b interface{}) int {
+	switch t := a.(type) {
+	case int64:
+		u := b.(int64)
+		if t < u {
+			return -1
+		} else if t > u {
+			return 1
+		}
+	case float64:
+		u := b.(float64)
+		if t < u {
+			return -1
+		} else if t > u {
+			return 1
+		}
+	case string:
+		u := b.(string)
+		if t < u {
+			return -1
+		} else if t > u {
+			return 1
+		}
+	case []byte:
+		return bytes.Compare(t, b.([]byte))
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"testing"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+func intPredicate(column string, op proto.Comparison, v int64) proto.Predicate {
+	return proto.Predicate{Column: column, Op: op, Value: proto.Datum{IntVal: gogoproto.Int64(v)}}
+}
+
+// TestPlanQuery verifies that predicates on primary key columns are
+// pushed down to the boundaries of the scan.
+func TestPlanQuery(t *testing.T) {
+	desc := &TableDescriptor{
+		ID:   4,
+		Name: "events",
+		Columns: []ColumnDescriptor{
+			{Name: "user", Type: columnTypeInteger},
+			{Name: "seq", Type: columnTypeInteger},
+			{Name: "kind", Type: columnTypeString},
+		},
+		PrimaryIndex: IndexDescriptor{ColumnNames: []string{"user", "seq"}},
+	}
+	desc.allocateIDs()
+	keyFor := func(values ...int64) proto.Key {
+		row := Row{}
+		for i, v := range values {
+			row[desc.PrimaryIndex.ColumnNames[i]] = v
+		}
+		index := desc.PrimaryIndex
+		index.ColumnIDs = index.ColumnIDs[:len(values)]
+		key, err := desc.encodeIndexKey(desc.makeIndexPrefix(&index), &index, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	prefix := desc.makeIndexPrefix(&desc.PrimaryIndex)
+
+	testCases := []struct {
+		predicates []proto.Predicate
+		start, end proto.Key
+	}{
+		// No predicates scan the whole table.
+		{nil, prefix, prefix.PrefixEnd()},
+		// Predicates on columns outside the primary key don't narrow the scan.
+		{[]proto.Predicate{{Column: "kind", Op: proto.EQUAL, Value: proto.Datum{StringVal: gogoproto.String("a")}}},
+			prefix, prefix.PrefixEnd()},
+		// Nor do predicates on a primary key column which doesn't follow
+		// an equality predicate.
+		{[]proto.Predicate{intPredicate("seq", proto.EQUAL, 3)}, prefix, prefix.PrefixEnd()},
+		{[]proto.Predicate{intPredicate("user", proto.EQUAL, 7)}, keyFor(7), keyFor(7).PrefixEnd()},
+		{[]proto.Predicate{intPredicate("user", proto.GREATER_EQUAL, 7)}, keyFor(7), prefix.PrefixEnd()},
+		{[]proto.Predicate{intPredicate("user", proto.GREATER, 7)}, keyFor(7).PrefixEnd(), prefix.PrefixEnd()},
+		{[]proto.Predicate{intPredicate("user", proto.LESS, 7)}, prefix, keyFor(7)},
+		{[]proto.Predicate{intPredicate("user", proto.LESS_EQUAL, 7), intPredicate("user", proto.LESS, 9)},
+			prefix, keyFor(7).PrefixEnd()},
+		{[]proto.Predicate{intPredicate("user", proto.NOT_EQUAL, 7)}, prefix, prefix.PrefixEnd()},
+		{[]proto.Predicate{intPredicate("user", proto.EQUAL, 7), intPredicate("seq", proto.GREATER_EQUAL, 3), intPredicate("seq", proto.LESS, 5)},
+			keyFor(7, 3), keyFor(7, 5)},
+		{[]proto.Predicate{intPredicate("seq", proto.EQUAL, 3), intPredicate("user", proto.EQUAL, 7)},
+			keyFor(7, 3), keyFor(7, 3).PrefixEnd()},
+	}
+	for i, test := range testCases {
+		plan, err := planQuery(desc, &proto.QueryRequest{Table: desc.Name, Predicates: test.predicates})
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !plan.start.Equal(test.start) || !plan.end.Equal(test.end) {
+			t.Errorf("%d: expected span [%q, %q); got [%q, %q)", i, test.start, test.end, plan.start, plan.end)
+		}
+	}
+
+	for i, args := range []*proto.QueryRequest{
+		{Predicates: []proto.Predicate{intPredicate("missing", proto.EQUAL, 1)}},
+		{Predicates: []proto.Predicate{{Column: "user", Op: proto.EQUAL, Value: proto.Datum{StringVal: gogoproto.String("a")}}}},
+		{Predicates: []proto.Predicate{{Column: "user", Op: proto.EQUAL}}},
+		{Columns: []string{"missing"}},
+	} {
+		if _, err := planQuery(desc, args); err == nil {
+			t.Errorf("%d: expected error planning %+v", i, args)
+		}
+	}
+}
@@ -20,11 +20,14 @@ package structured
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -63,6 +66,63 @@ func (db *testDB) GetSchema(key string) (*Schema, error) {
 	return nil, nil
 }
 
+func (db *testDB) CreateTable(desc *TableDescriptor) error {
+	db.Lock()
+	defer db.Unlock()
+	db.kv["table/"+desc.Name] = desc
+	return nil
+}
+
+func (db *testDB) DescribeTable(name string) (*TableDescriptor, error) {
+	db.RLock()
+	defer db.RUnlock()
+	v, ok := db.kv["table/"+name]
+	if ok {
+		return v.(*TableDescriptor), nil
+	}
+	return nil, nil
+}
+
+func (db *testDB) DropTable(name string) error {
+	db.Lock()
+	defer db.Unlock()
+	delete(db.kv, "table/"+name)
+	return nil
+}
+
+func (db *testDB) ListTables() ([]string, error) {
+	db.RLock()
+	defer db.RUnlock()
+	var names []string
+	for k := range db.kv {
+		if strings.HasPrefix(k, "table/") {
+			names = append(names, k[len("table/"):])
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (db *testDB) AddIndex(table string, index IndexDescriptor) error {
+	db.Lock()
+	defer db.Unlock()
+	v, ok := db.kv["table/"+table]
+	if !ok {
+		return fmt.Errorf("table %q does not exist", table)
+	}
+	desc := v.(*TableDescriptor)
+	desc.Indexes = append(desc.Indexes, index)
+	return nil
+}
+
+func (db *testDB) BackfillIndex(table, index string) error {
+	return nil
+}
+
+func (db *testDB) InsertRows(table string, rows []Row) error {
+	return nil
+}
+
 func newTestDB() *testDB {
 	return &testDB{kv: map[string]interface{}{}}
 }
@@ -0,0 +1,451 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// TableDataPrefix is the key prefix under which table rows and index
+// entries are stored. Keys of a table's data continue with the table
+// ID and the index ID, followed by the ordered encoding of the
+// indexed column values.
+var TableDataPrefix = proto.Key("tbl/")
+
+// primaryIndexID is the ID of every table's primary index.
+const primaryIndexID = 1
+
+// A ColumnDescriptor describes a table column. Column IDs are
+// assigned on table creation and are stable for the life of the
+// table; they, not column names, identify column data.
+type ColumnDescriptor struct {
+	ID   uint32
+	Name string
+	// Type is one of "integer", "float", "string" or "blob".
+	Type string
+}
+
+// An IndexDescriptor describes a table index over one or more
+// columns. Unique secondary indexes hold at most one row per indexed
+// value.
+type IndexDescriptor struct {
+	ID          uint32
+	Name        string
+	Unique      bool
+	ColumnNames []string
+	ColumnIDs   []uint32
+	// Backfilling is set while the entries of an index added to an
+	// existing table are being backfilled. Writes maintain the entries
+	// of a backfilling index, but it may not yet be read.
+	Backfilling bool
+}
+
+// A TableDescriptor describes a table: its columns, its primary index,
+// whose columns make up the primary key of each row, and its
+// secondary indexes.
+type TableDescriptor struct {
+	ID           uint32
+	Name         string
+	Columns      []ColumnDescriptor
+	PrimaryIndex IndexDescriptor
+	Indexes      []IndexDescriptor
+	// Version is incremented by each schema change. ModificationTime
+	// is the wall time, in nanoseconds, at which the version was
+	// published.
+	Version          uint32
+	ModificationTime int64
+}
+
+// A Row maps column names to values. Values are of type int64,
+// float64, string or []byte for columns of type "integer", "float",
+// "string" and "blob" respectively. Missing values are NULL.
+type Row map[string]interface{}
+
+// tableColumnTypes holds the column types supported by table
+// descriptors and whether each may be indexed.
+var tableColumnTypes = map[string]bool{
+	columnTypeInteger: true,
+	columnTypeFloat:   false,
+	columnTypeString:  true,
+	columnTypeBlob:    true,
+}
+
+// Validate verifies that the table descriptor is well formed: that
+// column and index names are unique, column types are supported and
+// indexes are over existing, indexable columns.
+func (desc *TableDescriptor) Validate() error {
+	if desc.Name == "" {
+		return fmt.Errorf("table must have a name")
+	}
+	if len(desc.Columns) == 0 {
+		return fmt.Errorf("table %q must have at least one column", desc.Name)
+	}
+	columns := map[string]*ColumnDescriptor{}
+	for i := range desc.Columns {
+		c := &desc.Columns[i]
+		if c.Name == "" {
+			return fmt.Errorf("table %q: column %d must have a name", desc.Name, i)
+		}
+		if _, ok := columns[c.Name]; ok {
+			return fmt.Errorf("table %q: duplicate column %q", desc.Name, c.Name)
+		}
+		if _, ok := tableColumnTypes[c.Type]; !ok {
+			return fmt.Errorf("table %q: column %q has unsupported type %q", desc.Name, c.Name, c.Type)
+		}
+		columns[c.Name] = c
+	}
+	if len(desc.PrimaryIndex.ColumnNames) == 0 {
+		return fmt.Errorf("table %q must have a primary key", desc.Name)
+	}
+	indexes := map[string]struct{}{}
+	for i, index := range append([]IndexDescriptor{desc.PrimaryIndex}, desc.Indexes...) {
+		if i > 0 {
+			if index.Name == "" {
+				return fmt.Errorf("table %q: index %d must have a name", desc.Name, i)
+			}
+			if _, ok := indexes[index.Name]; ok {
+				return fmt.Errorf("table %q: duplicate index %q", desc.Name, index.Name)
+			}
+			if len(index.ColumnNames) == 0 {
+				return fmt.Errorf("table %q: index %q must have at least one column", desc.Name, index.Name)
+			}
+			indexes[index.Name] = struct{}{}
+		}
+		for _, name := range index.ColumnNames {
+			c, ok := columns[name]
+			if !ok {
+				return fmt.Errorf("table %q: index %q references unknown column %q", desc.Name, index.Name, name)
+			}
+			if !tableColumnTypes[c.Type] {
+				return fmt.Errorf("table %q: column %q of type %q cannot be indexed", desc.Name, name, c.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// allocateIDs assigns column and index IDs and resolves the column
+// names of each index to column IDs.
+func (desc *TableDescriptor) allocateIDs() {
+	columnIDs := map[string]uint32{}
+	for i := range desc.Columns {
+		desc.Columns[i].ID = uint32(i + 1)
+		columnIDs[desc.Columns[i].Name] = desc.Columns[i].ID
+	}
+	resolve := func(index *IndexDescriptor, id uint32) {
+		index.ID = id
+		index.ColumnIDs = make([]uint32, len(index.ColumnNames))
+		for i, name := range index.ColumnNames {
+			index.ColumnIDs[i] = columnIDs[name]
+		}
+	}
+	resolve(&desc.PrimaryIndex, primaryIndexID)
+	for i := range desc.Indexes {
+		resolve(&desc.Indexes[i], uint32(primaryIndexID+i+1))
+	}
+}
+
+// findColumn returns the descriptor of the column with the given ID,
+// or nil if there is none.
+func (desc *TableDescriptor) findColumn(id uint32) *ColumnDescriptor {
+	for i := range desc.Columns {
+		if desc.Columns[i].ID == id {
+			return &desc.Columns[i]
+		}
+	}
+	return nil
+}
+
+// findIndexByName returns the position of the named secondary index
+// in desc.Indexes, or -1 if there is none.
+func (desc *TableDescriptor) findIndexByName(name string) int {
+	for i := range desc.Indexes {
+		if desc.Indexes[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// findColumnByName returns the descriptor of the named column, or nil
+// if there is none.
+func (desc *TableDescriptor) findColumnByName(name string) *ColumnDescriptor {
+	for i := range desc.Columns {
+		if desc.Columns[i].Name == name {
+			return &desc.Columns[i]
+		}
+	}
+	return nil
+}
+
+// MakeTableDescriptorKey returns the key of the descriptor of the
+// table with the given ID.
+func MakeTableDescriptorKey(id uint32) proto.Key {
+	return engine.MakeKey(engine.KeyTableDescriptorPrefix, encoding.EncodeInt(nil, int64(id)))
+}
+
+// MakeSchemaChangeLeaseKey returns the key of the schema change lease
+// of the table with the given ID.
+func MakeSchemaChangeLeaseKey(id uint32) proto.Key {
+	return engine.MakeKey(engine.KeyTableLeasePrefix, encoding.EncodeInt(nil, int64(id)))
+}
+
+// MakeTableNameKey returns the key mapping the table name to its ID.
+func MakeTableNameKey(name string) proto.Key {
+	return engine.MakeKey(engine.KeyTableNamePrefix, proto.Key(name))
+}
+
+// MakeIndexBackfillKey returns the key of the progress checkpoint of
+// the backfill of the index with the given ID.
+func MakeIndexBackfillKey(tableID, indexID uint32) proto.Key {
+	return engine.MakeKey(engine.KeyIndexBackfillPrefix,
+		encoding.EncodeInt(encoding.EncodeInt(nil, int64(tableID)), int64(indexID)))
+}
+
+// MakeTablePrefix returns the key prefix of all data of the table
+// with the given ID.
+func MakeTablePrefix(id uint32) proto.Key {
+	return encoding.EncodeVarUint64(append(proto.Key(nil), TableDataPrefix...), uint64(id))
+}
+
+// makeIndexPrefix returns the key prefix of the entries of the index.
+func (desc *TableDescriptor) makeIndexPrefix(index *IndexDescriptor) proto.Key {
+	return encoding.EncodeVarUint64(MakeTablePrefix(desc.ID), uint64(index.ID))
+}
+
+// encodeIndexKey appends the ordered encoding of the values of the
+// index's columns in row to b. All indexed values must be present.
+func (desc *TableDescriptor) encodeIndexKey(b []byte, index *IndexDescriptor, row Row) ([]byte, error) {
+	for _, id := range index.ColumnIDs {
+		c := desc.findColumn(id)
+		v, ok := row[c.Name]
+		if !ok || v == nil {
+			return nil, fmt.Errorf("table %q: missing value for indexed column %q", desc.Name, c.Name)
+		}
+		var err error
+		if b, err = encodeKeyValue(b, c, v); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// PrimaryKey returns the key of row: the primary index prefix followed
+// by the encoded primary key column values.
+func (desc *TableDescriptor) PrimaryKey(row Row) (proto.Key, error) {
+	return desc.encodeIndexKey(desc.makeIndexPrefix(&desc.PrimaryIndex), &desc.PrimaryIndex, row)
+}
+
+// EncodeRow encodes row as a sequence of key-value pairs, in key
+// order: a sentinel at the row's primary key with an empty value,
+// which marks the row's existence, followed by one pair per non-NULL
+// column outside the primary key, keyed by primary key and column ID.
+// Secondary index entries are returned separately, one per index in
+// the order of desc.Indexes; an entry's key is
+// the index prefix followed by the indexed values and, unless the
+// index is unique, the primary key values. Entries of unique indexes
+// hold the primary key as value; others are empty.
+func (desc *TableDescriptor) EncodeRow(row Row) (rowKVs, indexKVs []proto.KeyValue, err error) {
+	for name := range row {
+		if desc.findColumnByName(name) == nil {
+			return nil, nil, fmt.Errorf("table %q: unknown column %q", desc.Name, name)
+		}
+	}
+	rowKey, err := desc.PrimaryKey(row)
+	if err != nil {
+		return nil, nil, err
+	}
+	rowKVs = append(rowKVs, proto.KeyValue{Key: rowKey, Value: proto.Value{Bytes: []byte{}}})
+	pkColumns := map[uint32]struct{}{}
+	for _, id := range desc.PrimaryIndex.ColumnIDs {
+		pkColumns[id] = struct{}{}
+	}
+	for i := range desc.Columns {
+		c := &desc.Columns[i]
+		if _, ok := pkColumns[c.ID]; ok {
+			continue
+		}
+		v, ok := row[c.Name]
+		if !ok || v == nil {
+			continue
+		}
+		value, err := encodeColumnValue(c, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := encoding.EncodeVarUint64(append(proto.Key(nil), rowKey...), uint64(c.ID))
+		rowKVs = append(rowKVs, proto.KeyValue{Key: key, Value: proto.Value{Bytes: value}})
+	}
+
+	pkValues := rowKey[len(desc.makeIndexPrefix(&desc.PrimaryIndex)):]
+	for i := range desc.Indexes {
+		index := &desc.Indexes[i]
+		key, err := desc.encodeIndexKey(desc.makeIndexPrefix(index), index, row)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := []byte{}
+		if index.Unique {
+			value = append(value, pkValues...)
+		} else {
+			key = append(key, pkValues...)
+		}
+		indexKVs = append(indexKVs, proto.KeyValue{Key: key, Value: proto.Value{Bytes: value}})
+	}
+	return rowKVs, indexKVs, nil
+}
+
+// DecodeRow decodes a row from the key-value pairs produced by
+// EncodeRow, as returned by a scan of the row's primary key prefix.
+func (desc *TableDescriptor) DecodeRow(kvs []proto.KeyValue) (Row, error) {
+	if len(kvs) == 0 {
+		return nil, fmt.Errorf("table %q: no row data", desc.Name)
+	}
+	prefix := desc.makeIndexPrefix(&desc.PrimaryIndex)
+	rowKey := kvs[0].Key
+	if !bytes.HasPrefix(rowKey, prefix) {
+		return nil, fmt.Errorf("table %q: key %q is not a row key", desc.Name, rowKey)
+	}
+	row := Row{}
+	b := []byte(rowKey[len(prefix):])
+	for _, id := range desc.PrimaryIndex.ColumnIDs {
+		c := desc.findColumn(id)
+		var v interface{}
+		var err error
+		if b, v, err = decodeKeyValue(b, c); err != nil {
+			return nil, err
+		}
+		row[c.Name] = v
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("table %q: trailing bytes in row key %q", desc.Name, rowKey)
+	}
+	for _, kv := range kvs[1:] {
+		if !bytes.HasPrefix(kv.Key, rowKey) {
+			return nil, fmt.Errorf("table %q: key %q is not within row %q", desc.Name, kv.Key, rowKey)
+		}
+		_, id := encoding.DecodeVarUint64(kv.Key[len(rowKey):])
+		c := desc.findColumn(uint32(id))
+		if c == nil {
+			return nil, fmt.Errorf("table %q: unknown column ID %d", desc.Name, id)
+		}
+		v, err := decodeColumnValue(c, kv.Value.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		row[c.Name] = v
+	}
+	return row, nil
+}
+
+// encodeKeyValue appends the ordered encoding of the value v of
+// column c to b.
+func encodeKeyValue(b []byte, c *ColumnDescriptor, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case int64:
+		if c.Type == columnTypeInteger {
+			return encoding.EncodeInt(b, t), nil
+		}
+	case string:
+		if c.Type == columnTypeString {
+			return encoding.EncodeString(b, t), nil
+		}
+	case []byte:
+		if c.Type == columnTypeBlob {
+			return encoding.EncodeBinary(b, t), nil
+		}
+	}
+	return nil, fmt.Errorf("column %q: value %v of type %T cannot be encoded as %q key", c.Name, v, v, c.Type)
+}
+
+// decodeKeyValue decodes a value of column c from the ordered
+// encoding at the head of b, returning the remainder of b.
+func decodeKeyValue(b []byte, c *ColumnDescriptor) (rest []byte, v interface{}, err error) {
+	// The encoding package panics on malformed input.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("column %q: could not decode key value: %v", c.Name, r)
+		}
+	}()
+	switch c.Type {
+	case columnTypeInteger:
+		rest, i := encoding.DecodeInt(b)
+		return rest, i, nil
+	case columnTypeString:
+		rest, s := encoding.DecodeString(b)
+		return rest, s, nil
+	case columnTypeBlob:
+		rest, bs := encoding.DecodeBinary(b)
+		return rest, bs, nil
+	}
+	return nil, nil, fmt.Errorf("column %q: cannot decode %q key", c.Name, c.Type)
+}
+
+// encodeColumnValue encodes the value v of column c as stored in a
+// row's column key-value pair.
+func encodeColumnValue(c *ColumnDescriptor, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case int64:
+		if c.Type == columnTypeInteger {
+			return encoding.EncodeInt(nil, t), nil
+		}
+	case float64:
+		if c.Type == columnTypeFloat {
+			return encoding.EncodeUint64(nil, math.Float64bits(t)), nil
+		}
+	case string:
+		if c.Type == columnTypeString {
+			return []byte(t), nil
+		}
+	case []byte:
+		if c.Type == columnTypeBlob {
+			return append([]byte{}, t...), nil
+		}
+	}
+	return nil, fmt.Errorf("column %q: value %v of type %T cannot be stored as %q", c.Name, v, v, c.Type)
+}
+
+// decodeColumnValue decodes a value of column c encoded by
+// encodeColumnValue.
+func decodeColumnValue(c *ColumnDescriptor, b []byte) (v interface{}, err error) {
+	// The encoding package panics on malformed input.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("column %q: could not decode value: %v", c.Name, r)
+		}
+	}()
+	switch c.Type {
+	case columnTypeInteger:
+		_, i := encoding.DecodeInt(b)
+		return i, nil
+	case columnTypeFloat:
+		_, u := encoding.DecodeUint64(b)
+		return math.Float64frombits(u), nil
+	case columnTypeString:
+		return string(b), nil
+	case columnTypeBlob:
+		return b, nil
+	}
+	return nil, fmt.Errorf("column %q: cannot decode %q value", c.Name, c.Type)
+}
@@ -0,0 +1,114 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package structured
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func makeTestTableDescriptor() *TableDescriptor {
+	desc := &TableDescriptor{
+		ID:   3,
+		Name: "users",
+		Columns: []ColumnDescriptor{
+			{Name: "id", Type: columnTypeInteger},
+			{Name: "name", Type: columnTypeString},
+			{Name: "score", Type: columnTypeFloat},
+			{Name: "avatar", Type: columnTypeBlob},
+		},
+		PrimaryIndex: IndexDescriptor{ColumnNames: []string{"id"}},
+		Indexes: []IndexDescriptor{
+			{Name: "by_name", Unique: true, ColumnNames: []string{"name"}},
+		},
+	}
+	desc.allocateIDs()
+	return desc
+}
+
+// TestTableDescriptorValidate verifies validation of table descriptors.
+func TestTableDescriptorValidate(t *testing.T) {
+	testCases := []struct {
+		modify func(desc *TableDescriptor)
+		expErr bool
+	}{
+		{func(desc *TableDescriptor) {}, false},
+		{func(desc *TableDescriptor) { desc.Name = "" }, true},
+		{func(desc *TableDescriptor) { desc.Columns = nil }, true},
+		{func(desc *TableDescriptor) { desc.Columns[1].Name = "id" }, true},
+		{func(desc *TableDescriptor) { desc.Columns[1].Type = columnTypeStringSet }, true},
+		{func(desc *TableDescriptor) { desc.PrimaryIndex.ColumnNames = nil }, true},
+		{func(desc *TableDescriptor) { desc.PrimaryIndex.ColumnNames = []string{"missing"} }, true},
+		{func(desc *TableDescriptor) { desc.PrimaryIndex.ColumnNames = []string{"score"} }, true},
+		{func(desc *TableDescriptor) { desc.Indexes[0].Name = "" }, true},
+		{func(desc *TableDescriptor) { desc.Indexes = append(desc.Indexes, desc.Indexes[0]) }, true},
+	}
+	for i, test := range testCases {
+		desc := makeTestTableDescriptor()
+		test.modify(desc)
+		if err := desc.Validate(); (err != nil) != test.expErr {
+			t.Errorf("%d: expected error %t; got %v", i, test.expErr, err)
+		}
+	}
+}
+
+// TestEncodeDecodeRow verifies that rows round trip through their
+// key-value encoding, that NULL columns aren't stored and that row
+// keys sort by primary key.
+func TestEncodeDecodeRow(t *testing.T) {
+	desc := makeTestTableDescriptor()
+	rows := []Row{
+		{"id": int64(-5), "name": "alice", "score": 1.5, "avatar": []byte("\x00\x01")},
+		{"id": int64(7), "name": "bob"},
+	}
+	var lastKey []byte
+	for i, row := range rows {
+		rowKVs, indexKVs, err := desc.EncodeRow(row)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(rowKVs) != len(row) {
+			t.Errorf("%d: expected %d key-value pairs; got %d", i, len(row), len(rowKVs))
+		}
+		if len(indexKVs) != 1 {
+			t.Errorf("%d: expected one index entry; got %d", i, len(indexKVs))
+		}
+		if bytes.Compare(lastKey, rowKVs[0].Key) >= 0 {
+			t.Errorf("%d: expected row key %q to sort after %q", i, rowKVs[0].Key, lastKey)
+		}
+		lastKey = rowKVs[0].Key
+		decoded, err := desc.DecodeRow(rowKVs)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(decoded, row) {
+			t.Errorf("%d: expected %+v; got %+v", i, row, decoded)
+		}
+	}
+
+	for i, row := range []Row{
+		{"name": "carol"},
+		{"id": "8", "name": "carol"},
+		{"id": int64(8), "name": "carol", "unknown": int64(1)},
+	} {
+		if _, _, err := desc.EncodeRow(row); err == nil {
+			t.Errorf("%d: expected error encoding %+v", i, row)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package linearizability
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// infinity is the return time of operations whose outcome is unknown.
+// They may be linearized at any point after their invocation.
+const infinity = math.MaxInt64
+
+// A NonLinearizableError indicates that the history of a key has no
+// valid linearization.
+type NonLinearizableError struct {
+	Key string // Key whose history is not linearizable
+	Op  *Op    // Operation which could not be linearized before returning
+}
+
+// Error implements the error interface.
+func (e *NonLinearizableError) Error() string {
+	return fmt.Sprintf("history of key %q is not linearizable: no linearization of %s", e.Key, e.Op)
+}
+
+// Check verifies that the history of register operations is
+// linearizable, returning a NonLinearizableError if not. As
+// linearizability is a local property, the history of each key is
+// checked independently.
+func Check(ops []*Op) error {
+	byKey := map[string][]*Op{}
+	observed := map[string]map[string]bool{}
+	var keys []string
+	for _, op := range ops {
+		// Reads without a result and operations which definitely didn't
+		// take effect don't constrain the history. A failed CAS does, as
+		// it observes the register's value.
+		if (op.Kind == Read && op.Result != OK) || (op.Kind != CAS && op.Result == Failed) {
+			continue
+		}
+		if _, ok := byKey[op.Key]; !ok {
+			keys = append(keys, op.Key)
+			observed[op.Key] = map[string]bool{}
+		}
+		byKey[op.Key] = append(byKey[op.Key], op)
+		switch op.Kind {
+		case Read:
+			observed[op.Key][op.Value] = true
+		case CAS:
+			observed[op.Key][op.Expected] = true
+			if op.Result == Failed {
+				observed[op.Key][op.Actual] = true
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := checkKey(key, pruneUnobserved(byKey[key], observed[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneUnobserved removes operations of unknown outcome whose written
+// value was never observed. Such an operation may be assumed not to
+// have taken effect, as no operation depends on it having done so.
+// Without pruning, each would remain concurrent with the rest of the
+// history, making the search prohibitively expensive for histories
+// recorded under faults.
+func pruneUnobserved(ops []*Op, observed map[string]bool) []*Op {
+	var pruned []*Op
+	for _, op := range ops {
+		if op.Kind != Read && op.Result == Unknown && !observed[op.Value] {
+			continue
+		}
+		pruned = append(pruned, op)
+	}
+	return pruned
+}
+
+// step applies op to the register's state, returning whether op is
+// consistent with the state and the resulting state. A CAS of unknown
+// outcome is applied whenever the expected value matches; were it not
+// to take effect, it may equally be linearized after all other
+// operations.
+func step(state string, op *Op) (bool, string) {
+	switch op.Kind {
+	case Read:
+		return op.Value == state, state
+	case Write:
+		return true, op.Value
+	case CAS:
+		matches := state == op.Expected
+		switch op.Result {
+		case OK:
+			return matches, op.Value
+		case Failed:
+			return !matches && state == op.Actual, state
+		}
+		if matches {
+			return true, op.Value
+		}
+		return true, state
+	}
+	panic(fmt.Sprintf("unknown op kind %d", op.Kind))
+}
+
+// An entry is the invocation or completion of an operation in the
+// doubly-linked list of events searched by checkKey.
+type entry struct {
+	id         int    // Index of the operation in the key's history
+	op         *Op    // The operation
+	time       int64  // Logical time of the event
+	match      *entry // For invocations, the completion entry
+	prev, next *entry
+}
+
+// lift removes the invocation entry and its completion from the list.
+func (e *entry) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift reinserts the invocation entry and its completion into the
+// list, reversing lift.
+func (e *entry) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+type entriesByTime []*entry
+
+func (e entriesByTime) Len() int           { return len(e) }
+func (e entriesByTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByTime) Less(i, j int) bool { return e[i].time < e[j].time }
+
+// A bitset records which of a key's operations have been linearized.
+type bitset []uint64
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << uint(i%64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << uint(i%64) }
+
+// key returns the bitset encoded as a string, for use as a map key.
+func (b bitset) key() string {
+	buf := make([]byte, 8*len(b))
+	for i, w := range b {
+		binary.LittleEndian.PutUint64(buf[8*i:], w)
+	}
+	return string(buf)
+}
+
+// checkKey searches for a linearization of the key's history using the
+// algorithm of Wing & Gong, as improved by Lowe: operations are
+// linearized in turn from among those invoked before the earliest
+// pending completion, backtracking when a completion is reached with
+// no operation left which is consistent with the register's state.
+// Configurations of linearized operations and state which have already
+// been explored are cached and skipped.
+func checkKey(key string, ops []*Op) error {
+	entries := make([]*entry, 0, 2*len(ops))
+	for i, op := range ops {
+		ret := &entry{id: i, op: op, time: op.Return}
+		if op.Result == Unknown || op.Result == Pending {
+			ret.time = infinity
+		}
+		entries = append(entries, &entry{id: i, op: op, time: op.Call, match: ret}, ret)
+	}
+	sort.Stable(entriesByTime(entries))
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+
+	type frame struct {
+		e     *entry
+		state string
+	}
+	var stack []frame
+	linearized := make(bitset, (len(ops)+63)/64)
+	cache := map[string]struct{}{}
+	state := ""
+	for e := head.next; head.next != nil; {
+		if e.match != nil {
+			if ok, next := step(state, e.op); ok {
+				linearized.set(e.id)
+				k := linearized.key() + next
+				if _, ok := cache[k]; !ok {
+					cache[k] = struct{}{}
+					stack = append(stack, frame{e, state})
+					state = next
+					e.lift()
+					e = head.next
+					continue
+				}
+				linearized.clear(e.id)
+			}
+			e = e.next
+			continue
+		}
+		// Reached the completion of an operation which hasn't been
+		// linearized; backtrack.
+		if len(stack) == 0 {
+			return &NonLinearizableError{Key: key, Op: e.op}
+		}
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = f.state
+		linearized.clear(f.e.id)
+		f.e.unlift()
+		e = f.e.next
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package linearizability
+
+import "testing"
+
+// makeOp returns an operation on key "a" by client 0.
+func makeOp(kind OpKind, value, expected string, call, ret int64, result Result) *Op {
+	return &Op{Kind: kind, Key: "a", Value: value, Expected: expected, Call: call, Return: ret, Result: result}
+}
+
+// makeFailedCAS returns a failed CAS on key "a" by client 0 which
+// found actual instead of the expected value.
+func makeFailedCAS(value, expected, actual string, call, ret int64) *Op {
+	op := makeOp(CAS, value, expected, call, ret, Failed)
+	op.Actual = actual
+	return op
+}
+
+// TestCheck verifies that histories are correctly classified as
+// linearizable or not.
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		ops          []*Op
+		linearizable bool
+	}{
+		// Sequential write and read.
+		{[]*Op{makeOp(Write, "x", "", 1, 2, OK), makeOp(Read, "x", "", 3, 4, OK)}, true},
+		// Read which misses a completed write.
+		{[]*Op{makeOp(Write, "x", "", 1, 2, OK), makeOp(Read, "", "", 3, 4, OK)}, false},
+		// Read concurrent with a write may see either value.
+		{[]*Op{makeOp(Write, "x", "", 1, 4, OK), makeOp(Read, "", "", 2, 3, OK)}, true},
+		{[]*Op{makeOp(Write, "x", "", 1, 4, OK), makeOp(Read, "x", "", 2, 3, OK)}, true},
+		// Stale read of an overwritten value.
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 2, OK),
+			makeOp(Write, "y", "", 3, 4, OK),
+			makeOp(Read, "x", "", 5, 6, OK),
+		}, false},
+		// Concurrent reads must agree on the order of writes.
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 10, OK),
+			makeOp(Write, "y", "", 1, 10, OK),
+			makeOp(Read, "x", "", 2, 3, OK),
+			makeOp(Read, "y", "", 4, 5, OK),
+			makeOp(Read, "x", "", 6, 7, OK),
+		}, false},
+		// A write of unknown outcome may or may not take effect, but
+		// can't be undone.
+		{[]*Op{makeOp(Write, "x", "", 1, 2, Unknown), makeOp(Read, "x", "", 3, 4, OK)}, true},
+		{[]*Op{makeOp(Write, "x", "", 1, 2, Unknown), makeOp(Read, "", "", 3, 4, OK)}, true},
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 2, Unknown),
+			makeOp(Read, "x", "", 3, 4, OK),
+			makeOp(Read, "", "", 5, 6, OK),
+		}, false},
+		// Unobserved writes of unknown outcome are ignored; an observed
+		// one must take effect before it's observed.
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 2, OK),
+			makeOp(Write, "y", "", 3, 4, Unknown),
+			makeOp(Write, "z", "", 3, 4, Unknown),
+			makeOp(Read, "x", "", 5, 6, OK),
+		}, true},
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 2, OK),
+			makeOp(Write, "y", "", 3, 4, Unknown),
+			makeFailedCAS("z", "x", "y", 5, 6),
+		}, true},
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 2, OK),
+			makeOp(Write, "y", "", 3, 4, Unknown),
+			makeOp(Read, "y", "", 5, 6, OK),
+			makeOp(Read, "x", "", 7, 8, OK),
+		}, false},
+		// Reads of unknown outcome are ignored.
+		{[]*Op{makeOp(Write, "x", "", 1, 2, OK), makeOp(Read, "", "", 3, 4, Unknown)}, true},
+		// Successful and failed compare-and-swaps.
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 2, OK),
+			makeOp(CAS, "y", "x", 3, 4, OK),
+			makeFailedCAS("z", "x", "y", 5, 6),
+			makeOp(Read, "y", "", 7, 8, OK),
+		}, true},
+		{[]*Op{makeOp(Write, "x", "", 1, 2, OK), makeOp(CAS, "y", "z", 3, 4, OK)}, false},
+		{[]*Op{makeOp(Write, "x", "", 1, 2, OK), makeFailedCAS("y", "x", "x", 3, 4)}, false},
+		{[]*Op{makeOp(Write, "x", "", 1, 2, OK), makeFailedCAS("y", "z", "w", 3, 4)}, false},
+		{[]*Op{makeOp(CAS, "y", "", 1, 2, OK), makeOp(Read, "y", "", 3, 4, OK)}, true},
+		// A compare-and-swap of unknown outcome.
+		{[]*Op{
+			makeOp(Write, "x", "", 1, 2, OK),
+			makeOp(CAS, "y", "x", 3, 4, Unknown),
+			makeOp(Read, "x", "", 5, 6, OK),
+			makeOp(Read, "y", "", 7, 8, OK),
+		}, true},
+	}
+	for i, test := range testCases {
+		for j, op := range test.ops {
+			op.ID = j
+		}
+		err := Check(test.ops)
+		if test.linearizable && err != nil {
+			t.Errorf("%d: expected history to be linearizable: %s", i, err)
+		} else if !test.linearizable {
+			if _, ok := err.(*NonLinearizableError); !ok {
+				t.Errorf("%d: expected history not to be linearizable; got %v", i, err)
+			}
+		}
+	}
+}
+
+// TestCheckKeysIndependent verifies that the histories of distinct
+// keys are checked independently.
+func TestCheckKeysIndependent(t *testing.T) {
+	ops := []*Op{
+		{ID: 0, Kind: Write, Key: "a", Value: "x", Call: 1, Return: 2, Result: OK},
+		{ID: 1, Kind: Read, Key: "b", Value: "", Call: 3, Return: 4, Result: OK},
+		{ID: 2, Kind: Read, Key: "a", Value: "", Call: 5, Return: 6, Result: OK},
+	}
+	err := Check(ops)
+	if e, ok := err.(*NonLinearizableError); !ok || e.Key != "a" {
+		t.Errorf("expected history of key \"a\" not to be linearizable; got %v", err)
+	}
+	if err := Check(ops[:2]); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestHistory verifies that the history orders operations by their
+// invocation and completion.
+func TestHistory(t *testing.T) {
+	h := &History{}
+	op1 := h.Invoke(&Op{Kind: Write, Key: "a", Value: "x"})
+	op2 := h.Invoke(&Op{Kind: Read, Key: "a"})
+	h.Complete(op1, OK, "")
+	h.Complete(op2, OK, "x")
+	ops := h.Ops()
+	if len(ops) != 2 || ops[0] != op1 || ops[1] != op2 {
+		t.Fatalf("unexpected history %v", ops)
+	}
+	if op1.Call >= op2.Call || op2.Call >= op1.Return || op1.Return >= op2.Return {
+		t.Errorf("expected overlapping operations; got %s and %s", op1, op2)
+	}
+	if op2.Value != "x" || op1.Value != "x" {
+		t.Errorf("unexpected values %q, %q", op1.Value, op2.Value)
+	}
+	if err := Check(ops); err != nil {
+		t.Error(err)
+	}
+}
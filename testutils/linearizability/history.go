@@ -0,0 +1,147 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// Package linearizability runs randomized workloads of concurrent
+// clients against an in-process cluster while a nemesis disrupts it,
+// recording the history of operations and checking it for consistency
+// violations. Single-key register histories are checked for
+// linearizability; transactional bank transfers are checked for
+// serializability.
+//
+// The workload tests are skipped unless -linearizability.duration is
+// specified. "make linearizability" runs them with fault injection
+// enabled for LINEARIZABILITY_DURATION (default 1m) each.
+package linearizability
+
+import (
+	"fmt"
+	"sync"
+)
+
+// An OpKind is the kind of a register operation.
+type OpKind int
+
+// Register operation kinds.
+const (
+	Read  OpKind = iota // Reads the register's value
+	Write               // Writes the register's value
+	CAS                 // Writes the register's value if it matches an expected value
+)
+
+var opKindNames = map[OpKind]string{Read: "read", Write: "write", CAS: "cas"}
+
+// String implements the fmt.Stringer interface.
+func (k OpKind) String() string {
+	return opKindNames[k]
+}
+
+// A Result is the outcome of an operation as observed by the client.
+type Result int
+
+// Operation results.
+const (
+	// Pending operations have not yet completed.
+	Pending Result = iota
+	// OK operations succeeded.
+	OK
+	// Failed operations definitely did not take effect; for example, a
+	// CAS whose expected value didn't match.
+	Failed
+	// Unknown operations returned an error which leaves their outcome
+	// indeterminate. They may or may not have taken effect.
+	Unknown
+)
+
+var resultNames = map[Result]string{Pending: "pending", OK: "ok", Failed: "failed", Unknown: "unknown"}
+
+// String implements the fmt.Stringer interface.
+func (r Result) String() string {
+	return resultNames[r]
+}
+
+// An Op is a register operation issued by a client. An empty value
+// denotes an absent key.
+type Op struct {
+	ID       int    // Index of the operation in the history
+	Client   int    // Client which issued the operation
+	Kind     OpKind // Kind of operation
+	Key      string // Key of the register
+	Value    string // For reads, the value read; otherwise the value written
+	Expected string // For CAS, the value expected
+	Actual   string // For failed CAS, the value found instead
+	Call     int64  // Logical time at which the operation was invoked
+	Return   int64  // Logical time at which the operation completed
+	Result   Result // Outcome of the operation
+}
+
+// String implements the fmt.Stringer interface.
+func (op *Op) String() string {
+	s := fmt.Sprintf("%d: client %d %s %q", op.ID, op.Client, op.Kind, op.Key)
+	if op.Kind == CAS {
+		s += fmt.Sprintf(" %q->%q", op.Expected, op.Value)
+		if op.Result == Failed {
+			s += fmt.Sprintf(" found %q", op.Actual)
+		}
+	} else {
+		s += fmt.Sprintf(" %q", op.Value)
+	}
+	return s + fmt.Sprintf(" [%d, %d] %s", op.Call, op.Return, op.Result)
+}
+
+// A History records the operations issued by concurrent clients. The
+// invocation and completion of operations are ordered by a logical
+// clock, so that one operation precedes another in real time if it
+// returned before the other was invoked.
+type History struct {
+	mu    sync.Mutex
+	clock int64
+	ops   []*Op
+}
+
+// Invoke records the invocation of op, returning it.
+func (h *History) Invoke(op *Op) *Op {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clock++
+	op.ID = len(h.ops)
+	op.Call = h.clock
+	op.Result = Pending
+	h.ops = append(h.ops, op)
+	return op
+}
+
+// Complete records the completion of op with the specified result. For
+// reads, value is the value read; for failed CASes, the value found.
+func (h *History) Complete(op *Op, result Result, value string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clock++
+	op.Return = h.clock
+	op.Result = result
+	if op.Kind == Read {
+		op.Value = value
+	} else if op.Kind == CAS && result == Failed {
+		op.Actual = value
+	}
+}
+
+// Ops returns the operations recorded in the history.
+func (h *History) Ops() []*Op {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*Op(nil), h.ops...)
+}
@@ -0,0 +1,58 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build faults
+
+package linearizability
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/cockroachdb/cockroach/testutils/localcluster"
+	"github.com/cockroachdb/cockroach/util/fault"
+)
+
+// faultNemesisFaults are the faults injected by FaultNemesis.
+var faultNemesisFaults = []struct {
+	p fault.Point
+	f fault.Fault
+}{
+	{fault.StoreExecuteCmd, fault.Fault{Delay: 10 * time.Millisecond}},
+	{fault.StoreExecuteCmd, fault.Fault{Err: errors.New("injected store error")}},
+	{fault.EngineCommit, fault.Fault{Err: errors.New("injected engine error")}},
+	{fault.RangeLeadership, fault.Fault{}},
+}
+
+// FaultNemesis injects a random fault into all nodes: command latency,
+// command failures, engine write failures or loss of leadership.
+// Available only in builds with the "faults" tag.
+type FaultNemesis struct{}
+
+// Disrupt injects a random fault.
+func (FaultNemesis) Disrupt(c *localcluster.Cluster, rng *rand.Rand) error {
+	f := faultNemesisFaults[rng.Intn(len(faultNemesisFaults))]
+	fault.Inject(f.p, f.f)
+	return nil
+}
+
+// Heal removes all injected faults.
+func (FaultNemesis) Heal(c *localcluster.Cluster) error {
+	fault.Reset()
+	return nil
+}
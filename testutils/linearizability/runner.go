@@ -0,0 +1,405 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package linearizability
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/testutils/localcluster"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// A Nemesis disrupts a cluster while a workload runs against it. The
+// nemesis alternates between disrupting and healing the cluster. No
+// operations are in flight during calls to Disrupt and Heal.
+type Nemesis interface {
+	// Disrupt introduces a disruption to the cluster.
+	Disrupt(c *localcluster.Cluster, rng *rand.Rand) error
+	// Heal repairs the most recent disruption, if necessary.
+	Heal(c *localcluster.Cluster) error
+}
+
+// KillNemesis crashes a random node and immediately restarts it,
+// discarding all of the node's volatile state.
+type KillNemesis struct{}
+
+// Disrupt kills and restarts a random node.
+func (KillNemesis) Disrupt(c *localcluster.Cluster, rng *rand.Rand) error {
+	i := rng.Intn(len(c.Nodes))
+	c.Kill(i)
+	return c.Restart(i)
+}
+
+// Heal is a noop; nodes are restarted as soon as they're killed.
+func (KillNemesis) Heal(c *localcluster.Cluster) error {
+	return nil
+}
+
+// Config configures a workload.
+type Config struct {
+	Clients  int           // Number of concurrent clients
+	Keys     int           // Number of registers or accounts
+	Duration time.Duration // Duration of the workload
+	Interval time.Duration // Interval between nemesis disruption and healing
+	Nemesis  Nemesis       // Disrupts the cluster; nil for none
+	Seed     int64         // Seeds the random choices of clients and nemesis
+}
+
+// A runner runs concurrent clients against a cluster for the duration
+// of a workload, disrupting the cluster with the nemesis at intervals.
+type runner struct {
+	c   *localcluster.Cluster
+	cfg Config
+	// mu is held for reading by clients for the duration of each
+	// operation, and for writing by the nemesis.
+	mu sync.RWMutex
+}
+
+// run runs the client function concurrently for each client until the
+// workload's duration elapses, returning the first error encountered.
+func (r *runner) run(clientFn func(id int, rng *rand.Rand, stop <-chan struct{}) error) error {
+	stop := make(chan struct{})
+	errs := make(chan error, r.cfg.Clients+1)
+	var wg sync.WaitGroup
+	for i := 0; i < r.cfg.Clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- clientFn(i, rand.New(rand.NewSource(r.cfg.Seed+int64(i))), stop)
+		}(i)
+	}
+	if r.cfg.Nemesis != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.runNemesis(rand.New(rand.NewSource(r.cfg.Seed-1)), stop)
+		}()
+	}
+	time.Sleep(r.cfg.Duration)
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runNemesis alternately disrupts and heals the cluster at the
+// configured interval until stopped, leaving the cluster healed.
+func (r *runner) runNemesis(rng *rand.Rand, stop <-chan struct{}) error {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	disrupted := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if disrupted {
+				return r.withNemesis(func() error { return r.cfg.Nemesis.Heal(r.c) })
+			}
+			return nil
+		}
+		var err error
+		if disrupted {
+			err = r.withNemesis(func() error { return r.cfg.Nemesis.Heal(r.c) })
+		} else {
+			err = r.withNemesis(func() error { return r.cfg.Nemesis.Disrupt(r.c, rng) })
+		}
+		if err != nil {
+			return err
+		}
+		disrupted = !disrupted
+	}
+}
+
+// withNemesis invokes f once no operations are in flight.
+func (r *runner) withNemesis(f func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return f()
+}
+
+// do invokes f with a client which sends requests via the node
+// assigned to the client.
+func (r *runner) do(id int, f func(kv *client.KV) error) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return f(r.c.KV(id % len(r.c.Nodes)))
+}
+
+// stopped returns whether the stop channel has been closed.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunRegisters runs a workload of clients concurrently reading,
+// writing and compare-and-swapping a set of registers, returning the
+// history of operations. Check the history for linearizability with
+// Check. Each write is of a unique value.
+func RunRegisters(c *localcluster.Cluster, cfg Config) (*History, error) {
+	h := &History{}
+	r := &runner{c: c, cfg: cfg}
+	err := r.run(func(id int, rng *rand.Rand, stop <-chan struct{}) error {
+		// Values last observed by this client, used as the expected
+		// values of compare-and-swaps.
+		observed := map[string]string{}
+		for seq := 0; !stopped(stop); seq++ {
+			op := &Op{
+				Client: id,
+				Kind:   OpKind(rng.Intn(3)),
+				Key:    fmt.Sprintf("register-%d", rng.Intn(cfg.Keys)),
+			}
+			if op.Kind != Read {
+				op.Value = fmt.Sprintf("%d-%d", id, seq)
+				op.Expected = observed[op.Key]
+			}
+			h.Invoke(op)
+			var result Result
+			var value string
+			r.do(id, func(kv *client.KV) error {
+				result, value = execRegisterOp(kv, op)
+				return nil
+			})
+			h.Complete(op, result, value)
+			if result == OK {
+				observed[op.Key] = op.Value
+			}
+		}
+		return nil
+	})
+	return h, err
+}
+
+// execRegisterOp executes the register operation, returning its
+// result and, for reads, the value read. Errors other than a failed
+// CAS condition leave the outcome of the operation unknown.
+func execRegisterOp(kv *client.KV, op *Op) (Result, string) {
+	key := proto.Key(op.Key)
+	switch op.Kind {
+	case Read:
+		reply := &proto.GetResponse{}
+		if err := kv.Call(proto.Get, proto.GetArgs(key), reply); err != nil {
+			return Unknown, ""
+		}
+		if reply.Value == nil {
+			return OK, ""
+		}
+		return OK, string(reply.Value.Bytes)
+	case Write:
+		if err := kv.Call(proto.Put, proto.PutArgs(key, []byte(op.Value)), &proto.PutResponse{}); err != nil {
+			return Unknown, ""
+		}
+		return OK, ""
+	}
+	args := &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Value:         proto.Value{Bytes: []byte(op.Value)},
+	}
+	args.Value.InitChecksum(key)
+	if op.Expected != "" {
+		args.ExpValue = &proto.Value{Bytes: []byte(op.Expected)}
+	}
+	reply := &proto.ConditionalPutResponse{}
+	if err := kv.Call(proto.ConditionalPut, args, reply); err != nil {
+		// An actual value means the condition failed, unless the
+		// value is the one being written, in which case an earlier
+		// attempt of the command succeeded.
+		if reply.ActualValue != nil {
+			if string(reply.ActualValue.Bytes) == op.Value {
+				return OK, ""
+			}
+			return Failed, string(reply.ActualValue.Bytes)
+		}
+		return Unknown, ""
+	}
+	return OK, ""
+}
+
+// A SerializabilityError indicates that a transaction observed a state
+// of the accounts in the bank workload which no serial execution of
+// the transfers could have produced.
+type SerializabilityError struct {
+	Client   int   // Client which observed the violation
+	Total    int64 // Total balance observed
+	Expected int64 // Total balance expected
+	Accounts int   // Number of accounts observed
+}
+
+// Error implements the error interface.
+func (e *SerializabilityError) Error() string {
+	return fmt.Sprintf("client %d observed total balance %d across %d accounts; expected %d",
+		e.Client, e.Total, e.Accounts, e.Expected)
+}
+
+// initialBalance is the initial balance of each account in the bank
+// workload.
+const initialBalance = 100
+
+// RunBank runs a workload of clients concurrently transferring amounts
+// between accounts in transactions and auditing the total balance of
+// all accounts in transactions. Returns a SerializabilityError if an
+// audit observes a total balance other than the initial total.
+func RunBank(c *localcluster.Cluster, cfg Config) error {
+	total := int64(initialBalance * cfg.Keys)
+	for i := 0; i < cfg.Keys; i++ {
+		if err := putInt(c.KV(0), accountKey(i), initialBalance); err != nil {
+			return err
+		}
+	}
+	r := &runner{c: c, cfg: cfg}
+	if err := r.run(func(id int, rng *rand.Rand, stop <-chan struct{}) error {
+		for !stopped(stop) {
+			if rng.Intn(2) == 0 {
+				from, to := rng.Intn(cfg.Keys), rng.Intn(cfg.Keys)
+				amount := int64(rng.Intn(initialBalance) + 1)
+				r.do(id, func(kv *client.KV) error {
+					return kv.RunTransaction(&client.TransactionOptions{Name: "transfer"}, func(txn *client.KV) error {
+						return transfer(txn, from, to, amount)
+					})
+				})
+				continue
+			}
+			var sum int64
+			var count int
+			if err := r.do(id, func(kv *client.KV) error {
+				return kv.RunTransaction(&client.TransactionOptions{Name: "audit"}, func(txn *client.KV) error {
+					var err error
+					sum, count, err = audit(txn, cfg.Keys)
+					return err
+				})
+			}); err != nil {
+				continue
+			}
+			if sum != total || count != cfg.Keys {
+				return &SerializabilityError{Client: id, Total: sum, Expected: total, Accounts: count}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// The final audit may race with nodes recovering from the last
+	// disruption, so retry it a few times before giving up.
+	var sum int64
+	var count int
+	var auditErr error
+	retryOpts := util.RetryOptions{
+		Tag:         "final audit",
+		Backoff:     cfg.Interval,
+		MaxBackoff:  cfg.Interval,
+		Constant:    1,
+		MaxAttempts: 5,
+	}
+	if err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
+		if sum, count, auditErr = audit(c.KV(0), cfg.Keys); auditErr != nil {
+			return util.RetryContinue, nil
+		}
+		return util.RetryBreak, nil
+	}); err != nil {
+		return auditErr
+	}
+	if sum != total || count != cfg.Keys {
+		return &SerializabilityError{Client: -1, Total: sum, Expected: total, Accounts: count}
+	}
+	return nil
+}
+
+// accountKey returns the key of the i-th account.
+func accountKey(i int) proto.Key {
+	return proto.Key(fmt.Sprintf("account-%03d", i))
+}
+
+// putInt writes an integer value to the key.
+func putInt(kv *client.KV, key proto.Key, n int64) error {
+	args := &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Value:         proto.Value{Integer: gogoproto.Int64(n)},
+	}
+	args.Value.InitChecksum(key)
+	return kv.Call(proto.Put, args, &proto.PutResponse{})
+}
+
+// getInt reads an integer value from the key.
+func getInt(kv *client.KV, key proto.Key) (int64, error) {
+	reply := &proto.GetResponse{}
+	if err := kv.Call(proto.Get, proto.GetArgs(key), reply); err != nil {
+		return 0, err
+	}
+	if reply.Value == nil {
+		return 0, util.Errorf("account %q does not exist", key)
+	}
+	return reply.Value.GetInteger(), nil
+}
+
+// transfer moves amount from one account to another, if the balance
+// of the source account exceeds it. Accounts are never emptied so
+// that a zero balance can't be confused with a missing account.
+func transfer(kv *client.KV, from, to int, amount int64) error {
+	if from == to {
+		return nil
+	}
+	fromBalance, err := getInt(kv, accountKey(from))
+	if err != nil {
+		return err
+	}
+	toBalance, err := getInt(kv, accountKey(to))
+	if err != nil {
+		return err
+	}
+	if fromBalance <= amount {
+		return nil
+	}
+	if err := putInt(kv, accountKey(from), fromBalance-amount); err != nil {
+		return err
+	}
+	return putInt(kv, accountKey(to), toBalance+amount)
+}
+
+// audit scans all accounts, returning their total balance and count.
+func audit(kv *client.KV, accounts int) (int64, int, error) {
+	args := &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:    accountKey(0),
+			EndKey: accountKey(accounts),
+		},
+		MaxResults: int64(accounts),
+	}
+	reply := &proto.ScanResponse{}
+	if err := kv.Call(proto.Scan, args, reply); err != nil {
+		return 0, 0, err
+	}
+	var sum int64
+	for _, row := range reply.Rows {
+		sum += row.Value.GetInteger()
+	}
+	return sum, len(reply.Rows), nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build faults
+
+package linearizability
+
+import "testing"
+
+// TestRegistersLinearizableWithFaults verifies register histories are
+// linearizable while faults are injected.
+func TestRegistersLinearizableWithFaults(t *testing.T) {
+	runRegisters(FaultNemesis{}, t)
+}
+
+// TestBankSerializableWithFaults verifies transfers and audits are
+// serializable while faults are injected.
+func TestBankSerializableWithFaults(t *testing.T) {
+	runBank(FaultNemesis{}, t)
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package linearizability
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/testutils/localcluster"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+var duration = flag.Duration("linearizability.duration", 0,
+	"duration of each workload; workloads are skipped unless set")
+
+func init() {
+	// Set an aggressive gossip interval so that nodes join quickly.
+	*gossip.GossipInterval = gossip.DefaultTestGossipInterval
+	// Keep transaction backoff short so contended clients notice the
+	// end of a workload promptly.
+	client.TxnRetryOptions.Backoff = 1 * time.Millisecond
+	client.TxnRetryOptions.MaxBackoff = 10 * time.Millisecond
+}
+
+// testConfig returns the workload configuration for tests.
+func testConfig(nemesis Nemesis) Config {
+	return Config{
+		Clients:  6,
+		Keys:     4,
+		Duration: *duration,
+		Interval: 100 * time.Millisecond,
+		Nemesis:  nemesis,
+		Seed:     util.NewPseudoSeed(),
+	}
+}
+
+// startCluster starts a three node cluster for a workload, skipping
+// the test unless a workload duration was specified. The cluster runs
+// on the wall clock so that abandoned transactions expire.
+func startCluster(t *testing.T) *localcluster.Cluster {
+	if *duration == 0 {
+		t.Skip("-linearizability.duration not specified")
+	}
+	c := &localcluster.Cluster{NumNodes: 3, Clock: hlc.NewClock(hlc.UnixNano)}
+	if err := c.Start(); err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	return c
+}
+
+// runRegisters runs the register workload, verifying that the history
+// is linearizable.
+func runRegisters(nemesis Nemesis, t *testing.T) {
+	c := startCluster(t)
+	defer c.Stop()
+	h, err := RunRegisters(c, testConfig(nemesis))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := h.Ops()
+	if len(ops) == 0 {
+		t.Fatal("expected operations in history")
+	}
+	if err := Check(ops); err != nil {
+		t.Errorf("%s\n%v", err, ops)
+	}
+}
+
+// runBank runs the bank workload, verifying that audits are
+// serializable.
+func runBank(nemesis Nemesis, t *testing.T) {
+	c := startCluster(t)
+	defer c.Stop()
+	if err := RunBank(c, testConfig(nemesis)); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRegistersLinearizable verifies register histories are
+// linearizable while nodes are killed and restarted.
+func TestRegistersLinearizable(t *testing.T) {
+	runRegisters(KillNemesis{}, t)
+}
+
+// TestBankSerializable verifies transfers and audits are serializable
+// while nodes are killed and restarted.
+func TestBankSerializable(t *testing.T) {
+	runBank(KillNemesis{}, t)
+}
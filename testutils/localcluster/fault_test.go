@@ -0,0 +1,87 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build faults
+
+package localcluster
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/fault"
+)
+
+// TestClusterFaultRecovery verifies that commands fail while faults
+// are injected at each point along the write path and that the
+// cluster recovers once they're cleared.
+func TestClusterFaultRecovery(t *testing.T) {
+	c := StartCluster(t, 2)
+	defer c.Stop()
+	defer fault.Reset()
+
+	key := proto.Key("a")
+	isKey := func(arg interface{}) bool {
+		return proto.Key(arg.(proto.Request).Header().Key).Equal(key)
+	}
+	testCases := []struct {
+		p fault.Point
+		f fault.Fault
+	}{
+		{fault.StoreExecuteCmd, fault.Fault{Err: errors.New("store"), Match: isKey}},
+		{fault.EngineCommit, fault.Fault{Err: errors.New("engine")}},
+		{fault.RangeLeadership, fault.Fault{Match: func(arg interface{}) bool { return arg == int64(1) }}},
+	}
+	for i, test := range testCases {
+		fault.Inject(test.p, test.f)
+		if err := c.KV(1).PutI(key, "value"); err == nil {
+			t.Errorf("%d: expected put to fail with fault at %s", i, test.p)
+		}
+		fault.Clear(test.p)
+		if err := c.KV(1).PutI(key, "value"); err != nil {
+			t.Errorf("%d: expected put to succeed once fault at %s cleared: %s", i, test.p, err)
+		}
+	}
+}
+
+// TestClusterFaultLatency verifies that injected latency delays
+// commands.
+func TestClusterFaultLatency(t *testing.T) {
+	c := StartCluster(t, 2)
+	defer c.Stop()
+	defer fault.Reset()
+
+	key := proto.Key("a")
+	if err := c.KV(1).PutI(key, "value"); err != nil {
+		t.Fatal(err)
+	}
+	fault.Inject(fault.StoreExecuteCmd, fault.Fault{
+		Delay: 50 * time.Millisecond,
+		Count: 1,
+		Match: func(arg interface{}) bool {
+			_, ok := arg.(*proto.GetRequest)
+			return ok && proto.Key(arg.(proto.Request).Header().Key).Equal(key)
+		},
+	})
+	start := time.Now()
+	verifyValue(c, key, "value", t)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected reads to be delayed by at least 50ms; took %s", elapsed)
+	}
+}
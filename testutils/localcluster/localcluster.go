@@ -0,0 +1,174 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// Package localcluster provides a harness for integration tests which
+// run a cluster of nodes within the test process. Each node is a
+// server.TestServer with in-memory engines, serving real RPC and
+// gossip traffic over the loopback interface. All nodes share a
+// single manually-driven clock. Example usage:
+//
+//   c := localcluster.StartCluster(t, 3)
+//   defer c.Stop()
+//   if err := c.KV(1).Call(proto.Put, putArgs, putReply); err != nil {
+//     t.Fatal(err)
+//   }
+//   c.Kill(0)
+//   if err := c.Restart(0); err != nil {
+//     t.Fatal(err)
+//   }
+package localcluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/server"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+const (
+	// defaultEngineSize is the capacity of each node's in-memory engine.
+	defaultEngineSize = 100 << 20
+	// joinTimeout is the maximum time to wait for a node's stores to
+	// be bootstrapped upon joining the cluster.
+	joinTimeout = 10 * time.Second
+)
+
+// A Cluster is a set of in-process nodes. Node 0 bootstraps the
+// cluster and holds its initial range; the remaining nodes join via
+// gossip, using node 0 as their bootstrap host.
+type Cluster struct {
+	// NumNodes is the number of nodes started by Start().
+	NumNodes int
+	// Manual drives the clock shared by all nodes. Advance it to
+	// move time forward deterministically. Once the cluster has been
+	// bootstrapped, the clock is set to the wall time at bootstrap.
+	Manual *hlc.ManualClock
+	// Clock is the hybrid logical clock shared by all nodes. If set
+	// by the caller, Manual is ignored unless also set.
+	Clock *hlc.Clock
+	// Nodes holds the cluster's nodes, indexed by their order of
+	// creation. A killed node remains in the slice and may be
+	// restarted.
+	Nodes []*server.TestServer
+
+	killed []bool
+}
+
+// StartCluster creates and starts a Cluster of numNodes nodes; on
+// failure, causes a fatal testing error.
+func StartCluster(t *testing.T, numNodes int) *Cluster {
+	c := &Cluster{NumNodes: numNodes}
+	if err := c.Start(); err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Start starts NumNodes nodes, waiting for each to join the cluster
+// before starting the next.
+func (c *Cluster) Start() error {
+	if c.NumNodes < 1 {
+		return util.Errorf("cluster requires at least one node; got %d", c.NumNodes)
+	}
+	if c.Clock == nil {
+		if c.Manual == nil {
+			c.Manual = hlc.NewManualClock(0)
+		}
+		c.Clock = hlc.NewClock(c.Manual.UnixNano)
+	}
+	for i := 0; i < c.NumNodes; i++ {
+		ts := &server.TestServer{Clock: c.Clock}
+		if i > 0 {
+			ts.Engines = []engine.Engine{engine.NewInMem(proto.Attributes{}, defaultEngineSize)}
+			ts.GossipBootstrap = c.Nodes[0].RPCAddr
+		}
+		c.Nodes = append(c.Nodes, ts)
+		c.killed = append(c.killed, false)
+		if err := c.startNode(i); err != nil {
+			return err
+		}
+		if i == 0 && c.Manual != nil {
+			// The initial cluster data is written at the wall time by
+			// node 0's bootstrap; move the manual clock past it.
+			c.Manual.Set(hlc.UnixNano())
+		}
+	}
+	return nil
+}
+
+// startNode starts the i-th node and waits until all of its stores
+// have been bootstrapped.
+func (c *Cluster) startNode(i int) error {
+	ts := c.Nodes[i]
+	if err := ts.Start(); err != nil {
+		c.killed[i] = true
+		return util.Errorf("could not start node %d: %s", i, err)
+	}
+	if err := util.IsTrueWithin(func() bool {
+		return ts.LocalSender().GetStoreCount() == len(ts.Engines)
+	}, joinTimeout); err != nil {
+		return util.Errorf("node %d failed to join cluster: %s", i, err)
+	}
+	return nil
+}
+
+// KV returns a client to the key value database which sends requests
+// via the i-th node.
+func (c *Cluster) KV(i int) *client.KV {
+	return c.Nodes[i].KV()
+}
+
+// Kill stops the i-th node. Its engines are retained so that the node
+// may be restarted with its data intact.
+func (c *Cluster) Kill(i int) {
+	if c.killed[i] {
+		return
+	}
+	c.Nodes[i].Stop()
+	c.killed[i] = true
+}
+
+// Restart restarts the previously killed i-th node using its original
+// engines and addresses.
+func (c *Cluster) Restart(i int) error {
+	if !c.killed[i] {
+		return util.Errorf("node %d is running", i)
+	}
+	old := c.Nodes[i]
+	c.Nodes[i] = &server.TestServer{
+		Clock:           c.Clock,
+		Engines:         old.Engines,
+		GossipBootstrap: old.GossipBootstrap,
+		HTTPAddr:        old.HTTPAddr,
+		RPCAddr:         old.RPCAddr,
+	}
+	c.killed[i] = false
+	return c.startNode(i)
+}
+
+// Stop stops all running nodes.
+func (c *Cluster) Stop() {
+	for i := len(c.Nodes) - 1; i >= 0; i-- {
+		c.Kill(i)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package localcluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+func init() {
+	// Set an aggressive gossip interval so that nodes join quickly.
+	*gossip.GossipInterval = gossip.DefaultTestGossipInterval
+}
+
+// verifyValue reads key via each running node, verifying the value
+// read matches expected.
+func verifyValue(c *Cluster, key proto.Key, expected string, t *testing.T) {
+	for i := range c.Nodes {
+		if c.killed[i] {
+			continue
+		}
+		var val string
+		if ok, _, err := c.KV(i).GetI(key, &val); err != nil || !ok {
+			t.Fatalf("node %d: failed to read %q: %t, %v", i, key, ok, err)
+		}
+		if val != expected {
+			t.Errorf("node %d: expected %q; got %q", i, expected, val)
+		}
+	}
+}
+
+// TestClusterReadWrite verifies that values written via one node are
+// visible via every other node.
+func TestClusterReadWrite(t *testing.T) {
+	c := StartCluster(t, 3)
+	defer c.Stop()
+
+	for i := range c.Nodes {
+		key := proto.Key(fmt.Sprintf("key-%d", i))
+		if err := c.KV(i).PutI(key, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		verifyValue(c, key, fmt.Sprintf("value-%d", i), t)
+	}
+}
+
+// TestClusterKillRestart verifies that killed nodes may be restarted
+// with their data intact and that the cluster remains available via
+// the surviving nodes in the interim.
+func TestClusterKillRestart(t *testing.T) {
+	c := StartCluster(t, 2)
+	defer c.Stop()
+
+	key := proto.Key("a")
+	if err := c.KV(1).PutI(key, "before"); err != nil {
+		t.Fatal(err)
+	}
+	c.Kill(1)
+	if err := c.Restart(0); err == nil {
+		t.Error("expected error restarting a running node")
+	}
+	verifyValue(c, key, "before", t)
+	if err := c.Restart(1); err != nil {
+		t.Fatal(err)
+	}
+	verifyValue(c, key, "before", t)
+
+	// Node 0 holds the cluster's only range; its data must survive.
+	c.Kill(0)
+	if err := c.Restart(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.KV(1).PutI(key, "after"); err != nil {
+		t.Fatal(err)
+	}
+	verifyValue(c, key, "after", t)
+}
@@ -400,10 +400,16 @@ func (ic *IntervalCache) del(key interface{}) {
 	ic.tree.Delete(&entry{key: key}, false)
 }
 func (ic *IntervalCache) clear() {
+	// Collect the keys before deleting, as deleting from the tree
+	// while iterating over it may skip entries.
+	var keys []*IntervalKey
 	ic.tree.Do(func(e interval.Interface) (done bool) {
-		ic.Del(e.(*entry).key.(*IntervalKey))
+		keys = append(keys, e.(*entry).key.(*IntervalKey))
 		return
 	})
+	for _, key := range keys {
+		ic.Del(key)
+	}
 	ic.alloc = 0
 }
 func (ic *IntervalCache) length() int {
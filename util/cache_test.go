@@ -295,4 +295,13 @@ func TestIntervalCacheClear(t *testing.T) {
 	if _, ok := ic.Get(key1); !ok {
 		t.Error("expected reinsert to succeed")
 	}
+
+	// Verify all entries are cleared, including overlapping ones.
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		ic.Add(ic.NewKey(rangeKey(k), rangeKey("g")), k)
+	}
+	ic.Clear()
+	if l := ic.Len(); l != 0 {
+		t.Errorf("expected empty cache after clear; got %d entries", l)
+	}
 }
@@ -0,0 +1,67 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Tobias Schottdorf (tobias.schottdorf@gmail.com)
+
+// +build !nogob
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// GobEncode is a convenience function to return the gob representation
+// of the given value. If this value implements an interface, it needs
+// to be registered before GobEncode can be used.
+func GobEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode is a convenience function to return the unmarshaled value
+// of the given byte slice. If the value implements an interface, it
+// needs to be registered before GobEncode can be used.
+func GobDecode(b []byte) (interface{}, error) {
+	var result interface{}
+	err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MustGobDecode calls GobDecode and panics in case of an error.
+func MustGobDecode(b []byte) interface{} {
+	bDecoded, err := GobDecode(b)
+	if err != nil {
+		panic(err)
+	}
+	return bDecoded
+}
+
+// MustGobEncode calls GobEncode and panics in case of an error.
+func MustGobEncode(o interface{}) []byte {
+	oEncoded, err := GobEncode(o)
+	if err != nil {
+		panic(err)
+	}
+	return oEncoded
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// Package fault provides hooks which inject faults at specific points
+// in the store, engine and Raft code paths for chaos testing. Hooks
+// are compiled in only when building with the "faults" tag, for
+// example:
+//
+//	go test -tags faults ./...
+//
+// In other builds, Hit is a no-op and faults cannot be injected.
+// Tests which inject faults should carry the same build tag.
+package fault
+
+import "time"
+
+// A Point identifies a location in the code at which faults may be
+// injected.
+type Point string
+
+// Fault injection points. The argument passed to a fault's Match
+// function is noted for each.
+const (
+	// StoreExecuteCmd is hit by a store before executing each command.
+	// A fault delays the command and, if it specifies an error, fails
+	// it. The argument is the command's proto.Request.
+	StoreExecuteCmd Point = "store.execute-cmd"
+	// EngineCommit is hit before a batch of writes is committed to the
+	// underlying engine. A fault delays the commit and, if it specifies
+	// an error, fails it. The argument is the underlying engine.
+	EngineCommit Point = "engine.commit"
+	// RaftSendMessage is hit for each outgoing Raft message. A fault
+	// delays sending and drops the message. The argument is the
+	// raftpb.Message.
+	RaftSendMessage Point = "multiraft.send-message"
+	// RangeLeadership is hit whenever a range replica checks whether it
+	// is the leader. A fault forces the replica to lose leadership. The
+	// argument is the range ID.
+	RangeLeadership Point = "range.leadership"
+)
+
+// A Fault describes what happens when an injection point is hit.
+type Fault struct {
+	Delay time.Duration              // Delay before proceeding
+	Err   error                      // Error to fail with, at points which can fail
+	Count int                        // Number of hits which trigger the fault; 0 for unlimited
+	Match func(arg interface{}) bool // If not nil, only hits with a matching argument trigger
+}
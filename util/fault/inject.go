@@ -0,0 +1,80 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build faults
+
+package fault
+
+import (
+	"sync"
+	"time"
+)
+
+// Enabled is true in builds with fault injection hooks.
+const Enabled = true
+
+var (
+	mu     sync.Mutex
+	faults = map[Point]*Fault{}
+)
+
+// Inject installs the fault at the injection point, replacing any
+// fault previously installed there.
+func Inject(p Point, f Fault) {
+	mu.Lock()
+	defer mu.Unlock()
+	faults[p] = &f
+}
+
+// Clear removes any fault installed at the injection point.
+func Clear(p Point) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(faults, p)
+}
+
+// Reset removes all installed faults.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	faults = map[Point]*Fault{}
+}
+
+// Hit is invoked at the injection point with an argument describing
+// the operation. If a fault is triggered, Hit sleeps for its delay and
+// returns true along with the fault's error. Faults with a count are
+// removed once triggered that many times.
+func Hit(p Point, arg interface{}) (bool, error) {
+	mu.Lock()
+	f, ok := faults[p]
+	if !ok || (f.Match != nil && !f.Match(arg)) {
+		mu.Unlock()
+		return false, nil
+	}
+	if f.Count > 0 {
+		if f.Count--; f.Count == 0 {
+			delete(faults, p)
+		}
+	}
+	delay, err := f.Delay, f.Err
+	mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return true, err
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// +build faults
+
+package fault
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestHit verifies that hits trigger only installed faults with a
+// matching argument, and that faults with a count expire.
+func TestHit(t *testing.T) {
+	defer Reset()
+	testErr := errors.New("test")
+	if hit, err := Hit(StoreExecuteCmd, nil); hit || err != nil {
+		t.Fatalf("expected no fault; got %t, %v", hit, err)
+	}
+
+	Inject(StoreExecuteCmd, Fault{Err: testErr, Count: 2})
+	Inject(EngineCommit, Fault{Match: func(arg interface{}) bool { return arg == 1 }})
+	testCases := []struct {
+		p      Point
+		arg    interface{}
+		expHit bool
+		expErr error
+	}{
+		{StoreExecuteCmd, nil, true, testErr},
+		{EngineCommit, 2, false, nil},
+		{EngineCommit, 1, true, nil},
+		{StoreExecuteCmd, nil, true, testErr},
+		{StoreExecuteCmd, nil, false, nil}, // count exhausted
+		{EngineCommit, 1, true, nil},
+		{RangeLeadership, 1, false, nil},
+	}
+	for i, test := range testCases {
+		hit, err := Hit(test.p, test.arg)
+		if hit != test.expHit || err != test.expErr {
+			t.Errorf("%d: expected %t, %v; got %t, %v", i, test.expHit, test.expErr, hit, err)
+		}
+	}
+
+	Clear(EngineCommit)
+	if hit, _ := Hit(EngineCommit, 1); hit {
+		t.Error("expected cleared fault not to trigger")
+	}
+}
+
+// TestHitDelay verifies that triggered faults delay the caller.
+func TestHitDelay(t *testing.T) {
+	defer Reset()
+	Inject(RaftSendMessage, Fault{Delay: 10 * time.Millisecond})
+	start := time.Now()
+	if hit, _ := Hit(RaftSendMessage, nil); !hit {
+		t.Fatal("expected fault to trigger")
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("expected delay of at least 10ms; got %s", elapsed)
+	}
+}
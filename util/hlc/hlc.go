@@ -23,6 +23,7 @@ package hlc
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/cockroach/proto"
@@ -59,12 +60,37 @@ type Clock struct {
 
 // ManualClock is a convenience type to facilitate
 // creating a hybrid logical clock whose physical clock
-// is manually controlled.
-type ManualClock int64
+// is manually controlled. ManualClock is thread safe.
+type ManualClock struct {
+	nanos int64
+}
+
+// NewManualClock returns a new instance, initialized with
+// specified timestamp.
+func NewManualClock(nanos int64) *ManualClock {
+	return &ManualClock{nanos: nanos}
+}
 
 // UnixNano returns the underlying manual clock's timestamp.
 func (m *ManualClock) UnixNano() int64 {
-	return int64(*m)
+	return atomic.LoadInt64(&m.nanos)
+}
+
+// Now returns the underlying manual clock's timestamp. This
+// allows a ManualClock to be supplied where a client.Clock is
+// expected, e.g. for assigning client command IDs.
+func (m *ManualClock) Now() int64 {
+	return m.UnixNano()
+}
+
+// Increment atomically increments the manual clock's timestamp.
+func (m *ManualClock) Increment(incr int64) {
+	atomic.AddInt64(&m.nanos, incr)
+}
+
+// Set atomically sets the manual clock's timestamp.
+func (m *ManualClock) Set(nanos int64) {
+	atomic.StoreInt64(&m.nanos, nanos)
 }
 
 // UnixNano returns the local machine's physical nanosecond
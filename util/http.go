@@ -33,6 +33,15 @@ const (
 	ContentTypeHeader = "Content-Type"
 	// AcceptHeader is the canonical header name for accept.
 	AcceptHeader = "Accept"
+	// AuthorizationHeader is the canonical header name for authorization.
+	AuthorizationHeader = "Authorization"
+	// AuthTokenScheme is the authorization scheme used to present auth
+	// tokens in the Authorization header.
+	AuthTokenScheme = "Bearer "
+	// UserHeader is the header in which the server passes the user
+	// authenticated for an HTTP request on to its handlers. Values
+	// supplied by clients are always overwritten.
+	UserHeader = "X-Cockroach-User"
 	// JSON content type.
 	JSONContentType = "application/json"
 	// Alternate JSON content type.
@@ -0,0 +1,209 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A TraceSpec enables verbose logging of the commands matching all of
+// its non-empty criteria until it expires. Traces are enabled at
+// runtime, so that a production issue can be debugged without
+// restarting nodes with global verbose flags.
+type TraceSpec struct {
+	ID           int64     // Assigned by EnableTrace
+	Key          []byte    // Start of the traced key span
+	EndKey       []byte    // End of the span; empty to trace Key only
+	RangeID      int64     // The traced range
+	TxnID        []byte    // The traced transaction
+	Expiration   time.Time // Time at which the trace is disabled
+	MaxPerSecond int       // Limit on traced commands; 0 for no limit
+}
+
+// A TraceTarget describes a traced command.
+type TraceTarget struct {
+	Key, EndKey []byte // Key span accessed by the command
+	RangeID     int64  // The range executing the command
+	TxnID       []byte // The command's transaction; nil if none
+}
+
+// matches returns whether the spec's criteria match the target.
+func (s *TraceSpec) matches(t TraceTarget) bool {
+	if len(s.Key) > 0 {
+		end, tEnd := s.EndKey, t.EndKey
+		if len(end) == 0 {
+			end = append(append([]byte(nil), s.Key...), 0)
+		}
+		if len(tEnd) == 0 {
+			tEnd = append(append([]byte(nil), t.Key...), 0)
+		}
+		if bytes.Compare(t.Key, end) >= 0 || bytes.Compare(s.Key, tEnd) >= 0 {
+			return false
+		}
+	}
+	if s.RangeID != 0 && s.RangeID != t.RangeID {
+		return false
+	}
+	if len(s.TxnID) > 0 && !bytes.Equal(s.TxnID, t.TxnID) {
+		return false
+	}
+	return true
+}
+
+// trace is an enabled TraceSpec along with its rate limiting state.
+type trace struct {
+	TraceSpec
+	window     time.Time // Start of the current one second window
+	count      int       // Commands traced in the current window
+	suppressed int       // Commands suppressed since the last traced
+}
+
+// traces holds the enabled traces. activeTraces counts them so that
+// checking for traces is cheap when there are none.
+var (
+	tracesMu     sync.Mutex
+	traces       = map[int64]*trace{}
+	nextTraceID  int64
+	activeTraces int32
+)
+
+// EnableTrace enables the trace specified by spec, which must specify
+// at least one criterion and an expiration, and returns the trace's
+// ID.
+func EnableTrace(spec TraceSpec) (int64, error) {
+	if len(spec.Key) == 0 && len(spec.EndKey) > 0 {
+		return 0, errors.New("trace end key specified without start key")
+	}
+	if len(spec.EndKey) > 0 && bytes.Compare(spec.Key, spec.EndKey) >= 0 {
+		return 0, fmt.Errorf("trace end key %q must be greater than start key %q", spec.EndKey, spec.Key)
+	}
+	if len(spec.Key) == 0 && spec.RangeID == 0 && len(spec.TxnID) == 0 {
+		return 0, errors.New("trace must specify a key span, range ID or txn ID")
+	}
+	if spec.Expiration.IsZero() {
+		return 0, errors.New("trace must specify an expiration")
+	}
+	tracesMu.Lock()
+	defer tracesMu.Unlock()
+	nextTraceID++
+	spec.ID = nextTraceID
+	traces[spec.ID] = &trace{TraceSpec: spec}
+	atomic.StoreInt32(&activeTraces, int32(len(traces)))
+	return spec.ID, nil
+}
+
+// DisableTrace disables the trace with the specified ID. Returns
+// false if no such trace is enabled.
+func DisableTrace(id int64) bool {
+	tracesMu.Lock()
+	defer tracesMu.Unlock()
+	_, ok := traces[id]
+	delete(traces, id)
+	atomic.StoreInt32(&activeTraces, int32(len(traces)))
+	return ok
+}
+
+// Traces returns the enabled traces, ordered by ID.
+func Traces() []TraceSpec {
+	tracesMu.Lock()
+	defer tracesMu.Unlock()
+	expireTracesLocked(time.Now())
+	specs := make([]TraceSpec, 0, len(traces))
+	for _, t := range traces {
+		specs = append(specs, t.TraceSpec)
+	}
+	sort.Sort(traceSpecsByID(specs))
+	return specs
+}
+
+// expireTracesLocked disables traces which expired as of now.
+func expireTracesLocked(now time.Time) {
+	for id, t := range traces {
+		if !now.Before(t.Expiration) {
+			delete(traces, id)
+		}
+	}
+	atomic.StoreInt32(&activeTraces, int32(len(traces)))
+}
+
+// TracesActive returns whether any traces are enabled. Callers check
+// it before assembling a TraceTarget for Tracef.
+func TracesActive() bool {
+	return atomic.LoadInt32(&activeTraces) > 0
+}
+
+// Tracef logs to the INFO log on behalf of each enabled trace which
+// matches target and which hasn't exceeded its rate limit, prefixing
+// the message with the trace ID. Commands suppressed by a trace's
+// rate limit are counted in its next message.
+func Tracef(target TraceTarget, format string, args ...interface{}) {
+	if !TracesActive() {
+		return
+	}
+	prefixes := tracePrefixes(target, time.Now())
+	if len(prefixes) == 0 {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	for _, prefix := range prefixes {
+		Info(prefix + msg)
+	}
+}
+
+// tracePrefixes returns the message prefix of each enabled trace
+// which matches target as of now and counts the message against the
+// trace's rate limit.
+func tracePrefixes(target TraceTarget, now time.Time) []string {
+	tracesMu.Lock()
+	defer tracesMu.Unlock()
+	expireTracesLocked(now)
+	var prefixes []string
+	for _, t := range traces {
+		if !t.matches(target) {
+			continue
+		}
+		if now.Sub(t.window) >= time.Second {
+			t.window, t.count = now, 0
+		}
+		if t.MaxPerSecond > 0 && t.count >= t.MaxPerSecond {
+			t.suppressed++
+			continue
+		}
+		t.count++
+		if t.suppressed > 0 {
+			prefixes = append(prefixes, fmt.Sprintf("[trace %d, %d suppressed] ", t.ID, t.suppressed))
+			t.suppressed = 0
+		} else {
+			prefixes = append(prefixes, fmt.Sprintf("[trace %d] ", t.ID))
+		}
+	}
+	return prefixes
+}
+
+// traceSpecsByID sorts trace specs by ID.
+type traceSpecsByID []TraceSpec
+
+func (s traceSpecsByID) Len() int           { return len(s) }
+func (s traceSpecsByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s traceSpecsByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
@@ -0,0 +1,107 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTraceMatches verifies matching of trace criteria against
+// traced commands.
+func TestTraceMatches(t *testing.T) {
+	testCases := []struct {
+		spec   TraceSpec
+		target TraceTarget
+		expect bool
+	}{
+		{TraceSpec{Key: []byte("b")}, TraceTarget{Key: []byte("b")}, true},
+		{TraceSpec{Key: []byte("b")}, TraceTarget{Key: []byte("c")}, false},
+		{TraceSpec{Key: []byte("b")}, TraceTarget{Key: []byte("a"), EndKey: []byte("c")}, true},
+		{TraceSpec{Key: []byte("b"), EndKey: []byte("d")}, TraceTarget{Key: []byte("c")}, true},
+		{TraceSpec{Key: []byte("b"), EndKey: []byte("d")}, TraceTarget{Key: []byte("d")}, false},
+		{TraceSpec{Key: []byte("b"), EndKey: []byte("d")}, TraceTarget{Key: []byte("a"), EndKey: []byte("b")}, false},
+		{TraceSpec{Key: []byte("b"), EndKey: []byte("d")}, TraceTarget{Key: []byte("a"), EndKey: []byte("b\x00")}, true},
+		{TraceSpec{RangeID: 2}, TraceTarget{Key: []byte("a"), RangeID: 2}, true},
+		{TraceSpec{RangeID: 2}, TraceTarget{Key: []byte("a"), RangeID: 3}, false},
+		{TraceSpec{TxnID: []byte("txn")}, TraceTarget{Key: []byte("a"), TxnID: []byte("txn")}, true},
+		{TraceSpec{TxnID: []byte("txn")}, TraceTarget{Key: []byte("a")}, false},
+		{TraceSpec{Key: []byte("a"), RangeID: 2}, TraceTarget{Key: []byte("a"), RangeID: 3}, false},
+	}
+	for i, test := range testCases {
+		if m := test.spec.matches(test.target); m != test.expect {
+			t.Errorf("%d: expected match? %t; got %t", i, test.expect, m)
+		}
+	}
+}
+
+// TestTraceLifecycle verifies that traces are validated, rate limited
+// and disabled on expiration or on request.
+func TestTraceLifecycle(t *testing.T) {
+	now := time.Now()
+	if _, err := EnableTrace(TraceSpec{Expiration: now.Add(time.Minute)}); err == nil {
+		t.Error("expected error enabling trace without criteria")
+	}
+	if _, err := EnableTrace(TraceSpec{RangeID: 1}); err == nil {
+		t.Error("expected error enabling trace without expiration")
+	}
+	id1, err := EnableTrace(TraceSpec{RangeID: 1, Expiration: now.Add(time.Minute), MaxPerSecond: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := EnableTrace(TraceSpec{RangeID: 2, Expiration: now.Add(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DisableTrace(id1)
+	defer DisableTrace(id2)
+	if !TracesActive() || len(Traces()) != 2 {
+		t.Fatalf("expected 2 traces; got %+v", Traces())
+	}
+
+	// The third command within a second exceeds the rate limit, and is
+	// counted once the window passes.
+	target := TraceTarget{Key: []byte("a"), RangeID: 1}
+	traced := []string{fmt.Sprintf("[trace %d] ", id1)}
+	for i, expect := range [][]string{traced, traced, nil} {
+		if prefixes := tracePrefixes(target, now); !reflect.DeepEqual(prefixes, expect) {
+			t.Errorf("%d: expected %q; got %q", i, expect, prefixes)
+		}
+	}
+	expect := []string{fmt.Sprintf("[trace %d, 1 suppressed] ", id1)}
+	if prefixes := tracePrefixes(target, now.Add(time.Second)); !reflect.DeepEqual(prefixes, expect) {
+		t.Errorf("expected %q; got %q", expect, prefixes)
+	}
+
+	// The second trace expires.
+	if prefixes := tracePrefixes(TraceTarget{Key: []byte("a"), RangeID: 2}, now.Add(time.Second)); prefixes != nil {
+		t.Errorf("expected expired trace not to match; got %q", prefixes)
+	}
+	if traces := Traces(); len(traces) != 1 || traces[0].ID != id1 {
+		t.Errorf("expected only trace %d; got %+v", id1, traces)
+	}
+
+	if !DisableTrace(id1) {
+		t.Error("expected trace to be disabled")
+	}
+	if TracesActive() {
+		t.Error("expected no active traces")
+	}
+}
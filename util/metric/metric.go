@@ -0,0 +1,277 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+// Package metric provides typed metrics (counters, gauges, rates and
+// histograms) and a registry which tracks them by name. Registries
+// may be nested and flattened into a snapshot of named values, which
+// serves as the single source for status endpoints and time series.
+package metric
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// histogramPrecision causes histogram buckets to be accurate
+	// within approximately 1%.
+	histogramPrecision = 100
+	// rateTickInterval is the interval at which rates fold pending
+	// events into the moving average.
+	rateTickInterval = time.Second
+)
+
+// now is the time source used by rates. Overridden in tests.
+var now = time.Now
+
+// Iterable provides a method for synchronized access to the flattened
+// values of a metric. Each invokes f once per value, supplying a name
+// suffix (empty for single-valued metrics) and the current value.
+type Iterable interface {
+	Each(f func(suffix string, val float64))
+}
+
+// A Counter holds a monotonically increasing int64 value.
+type Counter struct {
+	count int64
+}
+
+// NewCounter creates a new Counter.
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+// Inc atomically increments the counter by delta.
+func (c *Counter) Inc(delta int64) {
+	atomic.AddInt64(&c.count, delta)
+}
+
+// Count returns the counter's current value.
+func (c *Counter) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// Each implements Iterable.
+func (c *Counter) Each(f func(string, float64)) {
+	f("", float64(c.Count()))
+}
+
+// A Gauge holds an int64 value which may go up or down.
+type Gauge struct {
+	value int64
+}
+
+// NewGauge creates a new Gauge.
+func NewGauge() *Gauge {
+	return &Gauge{}
+}
+
+// Update atomically sets the gauge's value.
+func (g *Gauge) Update(v int64) {
+	atomic.StoreInt64(&g.value, v)
+}
+
+// Inc atomically adds delta to the gauge's value.
+func (g *Gauge) Inc(delta int64) {
+	atomic.AddInt64(&g.value, delta)
+}
+
+// Value returns the gauge's current value.
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Each implements Iterable.
+func (g *Gauge) Each(f func(string, float64)) {
+	f("", float64(g.Value()))
+}
+
+// A Rate is an exponentially weighted moving average of the number
+// of events per second. Events are accumulated and folded into the
+// average once per tick; the weight given to each tick is derived
+// from the timescale supplied at construction.
+type Rate struct {
+	mu       sync.Mutex
+	alpha    float64   // Weight given to the most recent tick
+	rate     float64   // Events per second
+	pending  int64     // Events since the last tick
+	lastTick time.Time // Time of the last tick
+}
+
+// NewRate creates a Rate which averages over approximately the
+// specified timescale.
+func NewRate(timescale time.Duration) *Rate {
+	return &Rate{
+		alpha:    1 - math.Exp(-float64(rateTickInterval)/float64(timescale)),
+		lastTick: now(),
+	}
+}
+
+// Add records n events.
+func (r *Rate) Add(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tickLocked()
+	r.pending += n
+}
+
+// Value returns the current rate of events per second.
+func (r *Rate) Value() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tickLocked()
+	return r.rate
+}
+
+// Each implements Iterable.
+func (r *Rate) Each(f func(string, float64)) {
+	f("", r.Value())
+}
+
+// tickLocked folds pending events into the average for each tick
+// interval which has elapsed since the last tick. Ticks with no
+// events decay the average.
+func (r *Rate) tickLocked() {
+	ticks := int64(now().Sub(r.lastTick) / rateTickInterval)
+	if ticks <= 0 {
+		return
+	}
+	instant := float64(r.pending) / rateTickInterval.Seconds()
+	r.rate += r.alpha * (instant - r.rate)
+	r.rate *= math.Pow(1-r.alpha, float64(ticks-1))
+	r.pending = 0
+	r.lastTick = r.lastTick.Add(time.Duration(ticks) * rateTickInterval)
+}
+
+// A Histogram records a distribution of non-negative int64 values,
+// such as latencies in nanoseconds. Values are kept in logarithmic
+// buckets, so quantiles are approximate to within about 1%.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets map[int16]int64
+	count   int64
+	sum     int64
+	max     int64
+}
+
+// NewHistogram creates a new Histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{buckets: map[int16]int64{}}
+}
+
+// RecordValue adds v to the histogram. Negative values are recorded
+// as zero.
+func (h *Histogram) RecordValue(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets[compress(v)]++
+	h.count++
+	h.sum += v
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Count returns the number of values recorded.
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Max returns the largest value recorded.
+func (h *Histogram) Max() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Mean returns the mean of all values recorded, or zero if no values
+// have been recorded.
+func (h *Histogram) Mean() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.count)
+}
+
+// ValueAtQuantile returns the approximate value at quantile q, which
+// must be in the range [0, 100].
+func (h *Histogram) ValueAtQuantile(q float64) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.valueAtQuantileLocked(q)
+}
+
+func (h *Histogram) valueAtQuantileLocked(q float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	var keys []int
+	for k := range h.buckets {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+	target := int64(math.Ceil(q / 100 * float64(h.count)))
+	var seen int64
+	for _, k := range keys {
+		seen += h.buckets[int16(k)]
+		if seen >= target {
+			if v := decompress(int16(k)); v < h.max {
+				return v
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// Each implements Iterable. Histograms are flattened into count,
+// mean, max and a selection of quantiles.
+func (h *Histogram) Each(f func(string, float64)) {
+	h.mu.Lock()
+	count, max := h.count, h.max
+	var mean float64
+	if count > 0 {
+		mean = float64(h.sum) / float64(count)
+	}
+	p50, p99 := h.valueAtQuantileLocked(50), h.valueAtQuantileLocked(99)
+	h.mu.Unlock()
+	f("-count", float64(count))
+	f("-mean", mean)
+	f("-p50", float64(p50))
+	f("-p99", float64(p99))
+	f("-max", float64(max))
+}
+
+// compress maps a non-negative value to its logarithmic bucket.
+func compress(v int64) int16 {
+	return int16(math.Floor(histogramPrecision * math.Log1p(float64(v))))
+}
+
+// decompress returns the smallest value contained in bucket b.
+func decompress(b int16) int64 {
+	return int64(math.Ceil(math.Expm1(float64(b) / histogramPrecision)))
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package metric
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter()
+	c.Inc(90)
+	c.Inc(10)
+	if v := c.Count(); v != 100 {
+		t.Fatalf("expected count 100; got %d", v)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge()
+	g.Update(10)
+	g.Inc(-3)
+	if v := g.Value(); v != 7 {
+		t.Fatalf("expected value 7; got %d", v)
+	}
+}
+
+// TestRate verifies that events are folded into the rate once per
+// tick and that the rate decays when no events occur.
+func TestRate(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Unix(0, 0)
+	cur := start
+	now = func() time.Time { return cur }
+
+	r := NewRate(time.Second)
+	r.Add(100)
+	if v := r.Value(); v != 0 {
+		t.Errorf("expected rate 0 before first tick; got %f", v)
+	}
+	cur = cur.Add(time.Second)
+	first := r.Value()
+	if expected := 100 * (1 - math.Exp(-1)); math.Abs(first-expected) > 1e-9 {
+		t.Errorf("expected rate %f after first tick; got %f", expected, first)
+	}
+	cur = cur.Add(10 * time.Second)
+	if v := r.Value(); v >= first/100 {
+		t.Errorf("expected rate to decay from %f; got %f", first, v)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	if v := h.ValueAtQuantile(50); v != 0 {
+		t.Errorf("expected 0 for empty histogram; got %d", v)
+	}
+	for i := int64(1); i <= 1000; i++ {
+		h.RecordValue(i)
+	}
+	if c := h.Count(); c != 1000 {
+		t.Errorf("expected count 1000; got %d", c)
+	}
+	if m := h.Max(); m != 1000 {
+		t.Errorf("expected max 1000; got %d", m)
+	}
+	if m := h.Mean(); m != 500.5 {
+		t.Errorf("expected mean 500.5; got %f", m)
+	}
+	testCases := []struct {
+		q        float64
+		expected int64
+	}{
+		{50, 500},
+		{99, 990},
+		{100, 1000},
+	}
+	for i, test := range testCases {
+		v := h.ValueAtQuantile(test.q)
+		if math.Abs(float64(v-test.expected)) > 0.01*float64(test.expected) {
+			t.Errorf("%d: expected %d within 1%% at quantile %f; got %d", i, test.expected, test.q, v)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package metric
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PrometheusContentType is the content type of the Prometheus text
+	// exposition format written by PrintAsText.
+	PrometheusContentType = "text/plain; version=0.0.4"
+	// prometheusNamePrefix is prepended to all exported metric names.
+	prometheusNamePrefix = "cockroach_"
+)
+
+// labelValueEscaper escapes label values as required by the
+// Prometheus text exposition format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// A Label is a name/value pair which qualifies exported metrics.
+type Label struct {
+	Name, Value string
+}
+
+// promFamily holds the samples for a single exported metric name.
+type promFamily struct {
+	typ     string
+	samples []string
+}
+
+// PrintAsText writes all metrics in the registry to w in the
+// Prometheus text exposition format. Metric names are sanitized to
+// the characters allowed by Prometheus and prefixed with
+// "cockroach_". Entries added via AddLabeled contribute their labels
+// to every sample beneath them.
+func (r *Registry) PrintAsText(w io.Writer) error {
+	families := map[string]*promFamily{}
+	r.walk("", nil, func(name string, labels []Label, item Iterable) {
+		name = sanitizePrometheusName(prometheusNamePrefix + name)
+		switch t := item.(type) {
+		case *Counter:
+			addSample(families, name, "counter", name, labels, float64(t.Count()))
+		case *Gauge:
+			addSample(families, name, "gauge", name, labels, float64(t.Value()))
+		case *Rate:
+			addSample(families, name, "gauge", name, labels, t.Value())
+		case *Histogram:
+			t.mu.Lock()
+			count, sum := t.count, t.sum
+			p50, p99 := t.valueAtQuantileLocked(50), t.valueAtQuantileLocked(99)
+			t.mu.Unlock()
+			addSample(families, name, "summary", name, append(labels, Label{"quantile", "0.5"}), float64(p50))
+			addSample(families, name, "summary", name, append(labels, Label{"quantile", "0.99"}), float64(p99))
+			addSample(families, name, "summary", name+"_sum", labels, float64(sum))
+			addSample(families, name, "summary", name+"_count", labels, float64(count))
+		default:
+			item.Each(func(suffix string, val float64) {
+				n := sanitizePrometheusName(name + suffix)
+				addSample(families, n, "untyped", n, labels, val)
+			})
+		}
+	})
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fam := families[name]
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, fam.typ)
+		sort.Strings(fam.samples)
+		for _, s := range fam.samples {
+			buf.WriteString(s)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// walk invokes f for every non-registry Iterable beneath r, supplying
+// the full export name and the accumulated labels.
+func (r *Registry) walk(prefix string, labels []Label, f func(string, []Label, Iterable)) {
+	r.mu.Lock()
+	entries := make([]*entry, 0, len(r.tracked))
+	for _, e := range r.tracked {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+
+	for _, e := range entries {
+		l := labels
+		if len(e.labels) > 0 {
+			l = append(append([]Label(nil), labels...), e.labels...)
+		}
+		if sub, ok := e.item.(*Registry); ok {
+			sub.walk(prefix+e.exportName, l, f)
+			continue
+		}
+		f(prefix+e.exportName, l, e.item)
+	}
+}
+
+// addSample formats a sample line and appends it to the family with
+// the given name, creating the family if necessary.
+func addSample(families map[string]*promFamily, family, typ, name string, labels []Label, val float64) {
+	fam, ok := families[family]
+	if !ok {
+		fam = &promFamily{typ: typ}
+		families[family] = fam
+	}
+	var buf bytes.Buffer
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(&buf, "%s=\"%s\"", sanitizePrometheusName(l.Name), labelValueEscaper.Replace(l.Value))
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatFloat(val, 'g', -1, 64))
+	buf.WriteByte('\n')
+	fam.samples = append(fam.samples, buf.String())
+}
+
+// sanitizePrometheusName replaces all characters which are not valid
+// in a Prometheus metric name with underscores.
+func sanitizePrometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, name)
+}
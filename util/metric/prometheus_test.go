@@ -0,0 +1,93 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package metric
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSanitizePrometheusName(t *testing.T) {
+	testCases := []struct {
+		name, expected string
+	}{
+		{"requests", "requests"},
+		{"requests.latency-p99", "requests_latency_p99"},
+		{"rpc:conns/accepted", "rpc:conns_accepted"},
+		{"héllo world", "h_llo_world"},
+	}
+	for i, test := range testCases {
+		if s := sanitizePrometheusName(test.name); s != test.expected {
+			t.Errorf("%d: expected %q; got %q", i, test.expected, s)
+		}
+	}
+}
+
+// TestPrintAsText verifies the Prometheus text rendering of each
+// metric type, including labels contributed by nested registries.
+func TestPrintAsText(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("node.ranges").Update(5)
+	for i, id := range []string{"1", "2"} {
+		sub := NewRegistry()
+		sub.Counter("requests").Inc(int64(i + 1))
+		sub.Histogram("latency").RecordValue(10)
+		r.AddLabeled("store."+id+".", "store.", sub, Label{"store", id})
+	}
+	var buf bytes.Buffer
+	if err := r.PrintAsText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE cockroach_node_ranges gauge
+cockroach_node_ranges 5
+# TYPE cockroach_store_latency summary
+cockroach_store_latency_count{store="1"} 1
+cockroach_store_latency_count{store="2"} 1
+cockroach_store_latency_sum{store="1"} 10
+cockroach_store_latency_sum{store="2"} 10
+cockroach_store_latency{store="1",quantile="0.5"} 10
+cockroach_store_latency{store="1",quantile="0.99"} 10
+cockroach_store_latency{store="2",quantile="0.5"} 10
+cockroach_store_latency{store="2",quantile="0.99"} 10
+# TYPE cockroach_store_requests counter
+cockroach_store_requests{store="1"} 1
+cockroach_store_requests{store="2"} 2
+`
+	if s := buf.String(); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+
+	// The flattened snapshot continues to use the unique registry names.
+	snapshot := r.Snapshot()
+	if snapshot["store.2.requests"] != 2 {
+		t.Errorf("expected store.2.requests=2 in snapshot %v", snapshot)
+	}
+}
+
+func TestLabelValueEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.AddLabeled("c", "c", NewCounter(), Label{"attr", "a\"b\\c\nd"})
+	var buf bytes.Buffer
+	if err := r.PrintAsText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# TYPE cockroach_c counter\ncockroach_c{attr=\"a\\\"b\\\\c\\nd\"} 0\n"
+	if s := buf.String(); s != expected {
+		t.Errorf("expected %q; got %q", expected, s)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package metric
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A Registry is a list of metrics keyed by name. It provides a
+// simple way of iterating over them, and of flattening them into a
+// snapshot for export. A Registry is itself Iterable, so registries
+// may be nested by adding one to another under a prefix.
+type Registry struct {
+	mu      sync.Mutex
+	tracked map[string]*entry
+}
+
+// entry is an Iterable tracked by a registry. The export name and
+// labels are used in place of the registry name when rendering
+// metrics in formats which support labels.
+type entry struct {
+	item       Iterable
+	exportName string
+	labels     []Label
+}
+
+// NewRegistry creates a new Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		tracked: map[string]*entry{},
+	}
+}
+
+// Add links the given Iterable into this registry using the given
+// name. Returns an error if the name is already in use.
+func (r *Registry) Add(name string, item Iterable) error {
+	return r.AddLabeled(name, name, item)
+}
+
+// AddLabeled links the given Iterable into this registry using the
+// given name. When rendered in a format which supports labels, the
+// Iterable's values are instead named using exportName and tagged
+// with the supplied labels. This allows, for example, each store's
+// registry to be tracked under a unique name while sharing metric
+// names, distinguished by a store label, on export. Returns an error
+// if the name is already in use.
+func (r *Registry) AddLabeled(name, exportName string, item Iterable, labels ...Label) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tracked[name]; ok {
+		return util.Errorf("metric %q already registered", name)
+	}
+	r.tracked[name] = &entry{item: item, exportName: exportName, labels: labels}
+	return nil
+}
+
+// MustAdd calls Add and fails fatally on error.
+func (r *Registry) MustAdd(name string, item Iterable) {
+	if err := r.Add(name, item); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Remove unlinks the Iterable registered under the given name, if
+// any.
+func (r *Registry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tracked, name)
+}
+
+// Each calls f for every value of every metric in the registry, in
+// sorted order by name. Names of nested metrics are prefixed by the
+// name under which they were added.
+func (r *Registry) Each(f func(name string, val float64)) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.tracked))
+	for name := range r.tracked {
+		names = append(names, name)
+	}
+	items := make([]Iterable, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		items = append(items, r.tracked[name].item)
+	}
+	r.mu.Unlock()
+
+	for i, item := range items {
+		prefix := names[i]
+		item.Each(func(suffix string, val float64) {
+			f(prefix+suffix, val)
+		})
+	}
+}
+
+// Snapshot returns the current value of every metric in the
+// registry, keyed by flattened name.
+func (r *Registry) Snapshot() map[string]float64 {
+	snapshot := map[string]float64{}
+	r.Each(func(name string, val float64) {
+		snapshot[name] = val
+	})
+	return snapshot
+}
+
+// MarshalJSON marshals a snapshot of the registry to JSON.
+func (r *Registry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Snapshot())
+}
+
+// Counter registers and returns a new Counter.
+func (r *Registry) Counter(name string) *Counter {
+	c := NewCounter()
+	r.MustAdd(name, c)
+	return c
+}
+
+// Gauge registers and returns a new Gauge.
+func (r *Registry) Gauge(name string) *Gauge {
+	g := NewGauge()
+	r.MustAdd(name, g)
+	return g
+}
+
+// Rate registers and returns a new Rate averaged over the specified
+// timescale.
+func (r *Registry) Rate(name string, timescale time.Duration) *Rate {
+	e := NewRate(timescale)
+	r.MustAdd(name, e)
+	return e
+}
+
+// Histogram registers and returns a new Histogram.
+func (r *Registry) Histogram(name string) *Histogram {
+	h := NewHistogram()
+	r.MustAdd(name, h)
+	return h
+}
@@ -0,0 +1,71 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package metric
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests").Inc(3)
+	r.Gauge("bytes").Update(1024)
+
+	sub := NewRegistry()
+	sub.Counter("splits").Inc(1)
+	h := sub.Histogram("latency")
+	h.RecordValue(10)
+	r.MustAdd("store.1.", sub)
+
+	if err := r.Add("requests", NewCounter()); err == nil {
+		t.Error("expected error registering duplicate name")
+	}
+
+	expected := map[string]float64{
+		"requests":              3,
+		"bytes":                 1024,
+		"store.1.splits":        1,
+		"store.1.latency-count": 1,
+		"store.1.latency-mean":  10,
+		"store.1.latency-p50":   10,
+		"store.1.latency-p99":   10,
+		"store.1.latency-max":   10,
+	}
+	if snapshot := r.Snapshot(); !reflect.DeepEqual(snapshot, expected) {
+		t.Errorf("expected snapshot %v; got %v", expected, snapshot)
+	}
+
+	var names []string
+	r.Each(func(name string, _ float64) {
+		names = append(names, name)
+	})
+	if names[0] != "bytes" || names[len(names)-1] != "store.1.splits" {
+		t.Errorf("expected names in sorted order; got %v", names)
+	}
+
+	r.Remove("store.1.")
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"bytes":1024,"requests":3}` {
+		t.Errorf("unexpected JSON export %s", s)
+	}
+}
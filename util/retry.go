@@ -43,6 +43,17 @@ func (re *RetryMaxAttemptsError) Error() string {
 	return fmt.Sprintf("maximum number of attempts exceeded %d", re.MaxAttempts)
 }
 
+// RetryStoppedError indicates the retry loop was aborted because the
+// stopper supplied via RetryOptions was signaled to stop.
+type RetryStoppedError struct {
+	Tag string
+}
+
+// Error implements error interface.
+func (re *RetryStoppedError) Error() string {
+	return fmt.Sprintf("%s retry loop stopped", re.Tag)
+}
+
 const (
 	// RetryBreak indicates the retry loop is finished and should return
 	// the result of the retry worker function.
@@ -64,6 +75,7 @@ type RetryOptions struct {
 	Constant    float64       // Default backoff constant
 	MaxAttempts int           // Maximum number of attempts (0 for infinite)
 	UseV1Info   bool          // Use verbose V(1) level for log messages
+	Stopper     *Stopper      // Optionally end retry loop on stopper signal
 }
 
 // RetryWithBackoff implements retry with exponential backoff using
@@ -72,8 +84,8 @@ type RetryOptions struct {
 // retried. When fn returns RetryBreak, retry ends. As a special case,
 // if fn returns RetryReset, the backoff and retry count are reset to
 // starting values and the next retry occurs immediately. Returns an
-// error if the maximum number of retries is exceeded or if the fn
-// returns an error.
+// error if the maximum number of retries is exceeded, if the fn
+// returns an error or if opts.Stopper is signaled to stop.
 func RetryWithBackoff(opts RetryOptions, fn func() (RetryStatus, error)) error {
 	backoff := opts.Backoff
 	for count := 1; true; count++ {
@@ -107,8 +119,14 @@ func RetryWithBackoff(opts RetryOptions, fn func() (RetryStatus, error)) error {
 			}
 		}
 		// Wait before retry.
+		var stopper <-chan struct{}
+		if opts.Stopper != nil {
+			stopper = opts.Stopper.ShouldStop()
+		}
 		select {
 		case <-time.After(wait):
+		case <-stopper:
+			return &RetryStoppedError{opts.Tag}
 		}
 	}
 	return nil
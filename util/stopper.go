@@ -0,0 +1,204 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package util
+
+import "sync"
+
+// Closer is an interface for objects to attach to the stopper to
+// be closed once the stopper completes.
+type Closer interface {
+	Close()
+}
+
+// A Stopper provides a channel-based mechanism to stop an arbitrary
+// array of workers and to wait for outstanding tasks to complete.
+//
+// Workers are long-running goroutines started via RunWorker which
+// must exit promptly once the channel returned by ShouldStop() is
+// closed. Tasks are shorter-lived operations started via RunTask or
+// RunAsyncTask; once Stop() has been invoked, new tasks are refused
+// and Stop() waits for all outstanding tasks to finish before
+// signaling workers to exit. This ordering allows tasks to depend
+// on workers (e.g. a command waiting on a processing loop) while
+// the stopper drains.
+//
+// Example usage:
+//
+//   s := util.NewStopper()
+//   s.RunWorker(func() {
+//     for {
+//       select {
+//       case <-s.ShouldStop():
+//         return
+//       default:
+//         ...
+//       }
+//     }
+//   })
+//   ...
+//   s.Stop()
+type Stopper struct {
+	stopper  chan struct{}  // Closed when stopping
+	stopped  chan struct{}  // Closed when stopped completely
+	stop     sync.WaitGroup // Incremented for outstanding workers
+	mu       sync.Mutex     // Protects the fields below
+	drain    *sync.Cond     // Conditional variable to wait for outstanding tasks
+	draining bool           // true when Quiesce() has been called
+	stopping bool           // true when Stop() has been called
+	numTasks int            // number of outstanding tasks
+	closers  []Closer
+}
+
+// NewStopper returns an instance of Stopper.
+func NewStopper() *Stopper {
+	s := &Stopper{
+		stopper: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	s.drain = sync.NewCond(&s.mu)
+	return s
+}
+
+// RunWorker runs the supplied function as a "worker" to be stopped
+// by the stopper. The function f must exit once the channel returned
+// by ShouldStop() is closed.
+func (s *Stopper) RunWorker(f func()) {
+	s.stop.Add(1)
+	go func() {
+		defer s.stop.Done()
+		f()
+	}()
+}
+
+// AddCloser adds an object to close after the stopper has been stopped.
+func (s *Stopper) AddCloser(c Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closers = append(s.closers, c)
+}
+
+// RunTask runs the supplied function synchronously as a task. Returns
+// false without invoking f if the stopper is draining; true otherwise.
+// Stop() will not signal workers to exit until f has returned.
+func (s *Stopper) RunTask(f func()) bool {
+	if !s.startTask() {
+		return false
+	}
+	defer s.finishTask()
+	f()
+	return true
+}
+
+// RunAsyncTask runs the supplied function as a task in a new
+// goroutine. Returns false without starting the goroutine if the
+// stopper is draining; true otherwise.
+func (s *Stopper) RunAsyncTask(f func()) bool {
+	if !s.startTask() {
+		return false
+	}
+	go func() {
+		defer s.finishTask()
+		f()
+	}()
+	return true
+}
+
+// startTask adds one to the count of tasks left to drain in the
+// system. Any worker which is a "first mover" when starting tasks
+// must call this method before starting work on a new task. Returns
+// false if the stopper is already draining.
+func (s *Stopper) startTask() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.numTasks++
+	return true
+}
+
+// finishTask removes one from the count of tasks left to drain in
+// the system and wakes any waiters if no tasks remain.
+func (s *Stopper) finishTask() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numTasks--
+	s.drain.Broadcast()
+}
+
+// NumTasks returns the number of outstanding tasks.
+func (s *Stopper) NumTasks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numTasks
+}
+
+// Stop signals all live workers to stop and then waits for each to
+// confirm it has stopped (workers do this by returning from the
+// function passed to RunWorker). Outstanding tasks are drained
+// first via Quiesce(). Once all workers have exited, registered
+// closers are invoked in the order they were added. Stop is
+// idempotent; subsequent invocations block until the first has
+// completed.
+func (s *Stopper) Stop() {
+	s.mu.Lock()
+	alreadyStopping := s.stopping
+	s.stopping = true
+	s.mu.Unlock()
+	if alreadyStopping {
+		<-s.stopped
+		return
+	}
+	s.Quiesce()
+	close(s.stopper)
+	s.stop.Wait()
+	s.mu.Lock()
+	closers := s.closers
+	s.closers = nil
+	s.mu.Unlock()
+	for _, c := range closers {
+		c.Close()
+	}
+	close(s.stopped)
+}
+
+// ShouldStop returns a channel which will be closed when Stop() has
+// been invoked and outstanding tasks have drained. Workers should
+// exit when this channel is closed.
+func (s *Stopper) ShouldStop() <-chan struct{} {
+	return s.stopper
+}
+
+// IsStopped returns a channel which will be closed after Stop() has
+// been invoked and all workers and closers have completed.
+func (s *Stopper) IsStopped() <-chan struct{} {
+	return s.stopped
+}
+
+// Quiesce moves the stopper to state draining, refuses new tasks and
+// waits until all outstanding tasks have completed. Workers are not
+// signaled to stop.
+func (s *Stopper) Quiesce() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+	for s.numTasks > 0 {
+		// Unlock s.mu, wait for the signal, and lock s.mu.
+		s.drain.Wait()
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: agent (agent@local)
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopper(t *testing.T) {
+	s := NewStopper()
+	running := make(chan struct{})
+	waiting := make(chan struct{})
+	exited := make(chan struct{})
+
+	s.RunWorker(func() {
+		<-running
+	})
+
+	go func() {
+		<-s.ShouldStop()
+		close(waiting)
+		s.Stop() // a second Stop() waits for the first
+		close(exited)
+	}()
+	go s.Stop()
+
+	<-waiting
+
+	select {
+	case <-exited:
+		t.Fatal("expected stopper to have blocked")
+	case <-time.After(1 * time.Millisecond):
+		// Expected.
+	}
+	close(running)
+	select {
+	case <-exited:
+		// Success.
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("stopper should have finished waiting")
+	}
+	select {
+	case <-s.IsStopped():
+		// Success.
+	default:
+		t.Fatal("expected stopper to be stopped")
+	}
+}
+
+type blockingCloser struct {
+	block chan struct{}
+}
+
+func newBlockingCloser() *blockingCloser {
+	return &blockingCloser{block: make(chan struct{})}
+}
+
+func (bc *blockingCloser) Unblock() {
+	close(bc.block)
+}
+
+func (bc *blockingCloser) Close() {
+	<-bc.block
+}
+
+func TestStopperIsStopped(t *testing.T) {
+	s := NewStopper()
+	bc := newBlockingCloser()
+	s.AddCloser(bc)
+	go s.Stop()
+
+	select {
+	case <-s.ShouldStop():
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("stopper should have been stopping")
+	}
+	select {
+	case <-s.IsStopped():
+		t.Fatal("stopper should not have been stopped yet")
+	case <-time.After(1 * time.Millisecond):
+		// Expected.
+	}
+	bc.Unblock()
+	select {
+	case <-s.IsStopped():
+		// Success.
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("stopper should have been stopped")
+	}
+}
+
+func TestStopperMultipleStopees(t *testing.T) {
+	const count = 3
+	s := NewStopper()
+
+	for i := 0; i < count; i++ {
+		s.RunWorker(func() {
+			<-s.ShouldStop()
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("timed out waiting for stop")
+	}
+}
+
+func TestStopperStartFinishTasks(t *testing.T) {
+	s := NewStopper()
+	release := make(chan struct{})
+
+	if !s.RunAsyncTask(func() { <-release }) {
+		t.Fatal("expected RunAsyncTask to succeed")
+	}
+	if n := s.NumTasks(); n != 1 {
+		t.Errorf("expected 1 outstanding task; got %d", n)
+	}
+	go s.Stop()
+
+	select {
+	case <-s.ShouldStop():
+		t.Fatal("expected stopper to be draining tasks")
+	case <-time.After(1 * time.Millisecond):
+		// Expected.
+	}
+	close(release)
+	select {
+	case <-s.ShouldStop():
+		// Success.
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("stopper should be ready to stop")
+	}
+}
+
+func TestStopperRunTaskAfterStop(t *testing.T) {
+	s := NewStopper()
+	var ran bool
+	if !s.RunTask(func() { ran = true }) || !ran {
+		t.Fatal("expected task to run before stop")
+	}
+	s.Stop()
+	if s.RunTask(func() { t.Error("task should not run after stop") }) {
+		t.Error("expected RunTask to fail after stop")
+	}
+	if s.RunAsyncTask(func() { t.Error("async task should not run after stop") }) {
+		t.Error("expected RunAsyncTask to fail after stop")
+	}
+}
+
+func TestStopperQuiesce(t *testing.T) {
+	var stoppers []*Stopper
+	for i := 0; i < 3; i++ {
+		stoppers = append(stoppers, NewStopper())
+	}
+	var quiesceDone []chan struct{}
+	var release []chan struct{}
+
+	for _, s := range stoppers {
+		qc := make(chan struct{})
+		rc := make(chan struct{})
+		quiesceDone = append(quiesceDone, qc)
+		release = append(release, rc)
+		s.RunAsyncTask(func() { <-rc })
+		go func(s *Stopper) {
+			s.Quiesce()
+			close(qc)
+		}(s)
+	}
+
+	for i := range stoppers {
+		select {
+		case <-quiesceDone[i]:
+			t.Errorf("%d: quiesce should block with outstanding task", i)
+		case <-time.After(1 * time.Millisecond):
+		}
+		close(release[i])
+		select {
+		case <-quiesceDone[i]:
+		case <-time.After(100 * time.Millisecond):
+			t.Errorf("%d: timed out waiting for quiesce", i)
+		}
+		stoppers[i].Stop()
+	}
+}